@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// handler creates the alerts topic and email subscription of every user
+// queued by users.RequestEmailAlerts. A failed user is retried by a later
+// run after its backoff; one failure doesn't stop the others.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	snsClient := sns.NewFromConfig(awsCfg)
+
+	pending, err := users.DuePendingAlerts(ctx, ddb, time.Now())
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Alerts, "alerts-provisioner", err)
+		return err
+	}
+
+	done, failed := 0, 0
+	var lastErr error
+	for _, p := range pending {
+		if err := users.ProvisionPendingAlerts(ctx, ddb, snsClient, p); err != nil {
+			fmt.Printf("alerts-provisioner: user %s attempt %d: %v\n", p.Sub(), p.Attempts+1, err)
+			failed++
+			lastErr = err
+			continue
+		}
+		done++
+	}
+	ops.Beat(ctx, ddb, ops.Alerts, "alerts-provisioner", lastErr)
+
+	fmt.Printf("alerts-provisioner: %d pending, %d provisioned, %d failed\n", len(pending), done, failed)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
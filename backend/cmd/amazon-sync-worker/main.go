@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"backend/internal/amazon"
+	"backend/internal/db"
+	"backend/internal/ops"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler pulls new orders and fees for every connected Amazon seller.
+// One failing seller must not block the rest, so errors are logged and skipped.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	integs, err := amazon.ListIntegrations(ctx, ddb, "")
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "amazon-sync-worker", err)
+		return fmt.Errorf("list amazon integrations: %w", err)
+	}
+
+	failed := 0
+	for _, it := range integs {
+		res, err := amazon.SyncSeller(ctx, ddb, it)
+		if err != nil {
+			failed++
+			fmt.Printf("amazon-sync: seller=%s user=%s failed: %v\n", it.SellerId, it.UserSub(), err)
+			continue
+		}
+		fmt.Printf("amazon-sync: seller=%s orders=%d fees=%d skipped=%d\n", res.SellerId, res.Orders, res.Fees, res.Skipped)
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "amazon-sync-worker", ops.BatchErr(len(integs), failed))
+	fmt.Printf("amazon-sync: done sellers=%d failed=%d\n", len(integs), failed)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/dupes"
+	"backend/internal/ops"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// scanDays is how far back each run looks; later edits to older months are
+// left to the create-time check.
+const scanDays = 35
+
+// handler re-runs duplicate detection over every user's recent manual
+// transactions, so groups created by imports, edits or deletes after the
+// create-time check are filed or cleared.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	txTable := db.TransactionsTableName()
+
+	subs, err := users.AllSubs(ctx, ddb)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "duplicates-scanner", err)
+		return err
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, 1-scanDays)
+	found, failed := 0, 0
+	var lastErr error
+	for _, sub := range subs {
+		groups, days, err := dupes.Detect(ctx, ddb, txTable, sub, from, to)
+		if err == nil {
+			err = dupes.Sync(ctx, ddb, txTable, sub, days, groups)
+		}
+		if err != nil {
+			fmt.Printf("duplicates-scanner: user %s: %v\n", sub, err)
+			failed++
+			lastErr = err
+			continue
+		}
+		found += len(groups)
+	}
+	ops.Beat(ctx, ddb, ops.Sync, "duplicates-scanner", ops.BatchErr(len(subs), failed))
+
+	fmt.Printf("duplicates-scanner: %d users, %d groups, %d failed\n", len(subs), found, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users failed: %w", failed, len(subs), lastErr)
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"backend/internal/db"
+	"backend/internal/fx"
+	"backend/internal/ops"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler pulls today's reference rates and stores them in FX_RATES_TABLE,
+// where fx.Convert and GET /fx/rates read them.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	r, err := fx.Fetch(ctx)
+	if err == nil {
+		err = fx.Save(ctx, ddb, r)
+	}
+	ops.Beat(ctx, ddb, ops.Sync, "fx-fetcher", err)
+	if err != nil {
+		return fmt.Errorf("fx fetch: %w", err)
+	}
+
+	fmt.Printf("fx-fetcher: stored %d %s rates for %s (%s)\n", len(r.Rates), r.Base, r.Date, r.Source)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/gsheets"
+	"backend/internal/ops"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler appends yesterday's per-shop metrics to each user's selected sheet.
+// Users without a target yet are skipped; a failing user never blocks the rest.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	integs, err := gsheets.ListIntegrations(ctx, ddb)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "gsheets-exporter", err)
+		return fmt.Errorf("list gsheets integrations: %w", err)
+	}
+
+	through := gsheets.Yesterday(time.Now())
+	exported, failed := 0, 0
+	for i := range integs {
+		it := &integs[i]
+		if it.SpreadsheetId == "" {
+			continue
+		}
+		res, err := gsheets.Export(ctx, ddb, it, through)
+		if err != nil {
+			failed++
+			fmt.Printf("gsheets-exporter: user=%s failed: %v\n", it.UserSub(), err)
+			continue
+		}
+		exported++
+		fmt.Printf("gsheets-exporter: user=%s days=%d rows=%d\n", it.UserSub(), len(res.Days), res.Rows)
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "gsheets-exporter", ops.BatchErr(exported+failed, failed))
+	fmt.Printf("gsheets-exporter: done through=%s exported=%d failed=%d\n", through, exported, failed)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"backend/internal/ads/meta"
+	"backend/internal/db"
+	"backend/internal/ops"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler pulls daily ad spend for every connected Meta ad account.
+// It runs before the daily_metrics ETL so marketing_costs are current.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	integs, err := meta.ListIntegrations(ctx, ddb, "")
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "meta-sync-worker", err)
+		return fmt.Errorf("list meta integrations: %w", err)
+	}
+
+	failed := 0
+	for _, it := range integs {
+		res, err := meta.SyncAccount(ctx, ddb, it)
+		if err != nil {
+			failed++
+			fmt.Printf("meta-sync: account=%s user=%s failed: %v\n", it.AdAccountId, it.UserSub(), err)
+			continue
+		}
+		fmt.Printf("meta-sync: account=%s days=%d spend=%.2f %s\n", res.AdAccountId, res.Days, res.Spend, res.Currency)
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "meta-sync-worker", ops.BatchErr(len(integs), failed))
+	fmt.Printf("meta-sync: done accounts=%d failed=%d\n", len(integs), failed)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/metering"
+	"backend/internal/nlq"
+	"backend/internal/notify"
+	"backend/internal/ops"
+	"backend/internal/security"
+	"backend/internal/tenancy"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/athena"
+	bedrockruntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/glue"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// digestMaxWait bounds one digest's Athena query; there is no request to
+// answer, so it can wait far longer than /ask.
+const digestMaxWait = 2 * time.Minute
+
+// digestRows is how many result rows go into the email under the answer.
+const digestRows = 10
+
+type clients struct {
+	ddb     *dynamodb.Client
+	glue    *glue.Client
+	bedrock *bedrockruntime.Client
+	athena  *athena.Client
+	sns     *sns.Client
+}
+
+// handler answers every due digest and emails the answer. A digest is
+// advanced once it was asked, whatever the outcome; a question that can't
+// be answered (rejected, ambiguous, over quota) is emailed as such rather
+// than retried, and the outcome shows on GET /ask/digests.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	c := clients{
+		ddb:     ddb,
+		glue:    glue.NewFromConfig(cfg),
+		bedrock: bedrockruntime.NewFromConfig(cfg),
+		athena:  athena.NewFromConfig(cfg),
+		sns:     sns.NewFromConfig(cfg),
+	}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	due, err := nlq.DueDigests(ctx, ddb, today.Format("2006-01-02"))
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.NLQ, "nlq-digests", err)
+		return err
+	}
+
+	failed := 0
+	var lastErr error
+	for _, d := range due {
+		if err := run(ctx, c, d, today); err != nil {
+			fmt.Printf("nlq-digests: digest %s user %s: %v\n", d.Id, d.UserSub, err)
+			failed++
+			lastErr = err
+		}
+	}
+	ops.Beat(ctx, ddb, ops.NLQ, "nlq-digests", ops.BatchErr(len(due), failed))
+
+	fmt.Printf("nlq-digests: %d due, %d failed\n", len(due), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d digests failed: %w", failed, len(due), lastErr)
+	}
+	return nil
+}
+
+// run answers one digest. Errors are infrastructure failures; the digest
+// is then left due and retried by the next run.
+func run(ctx context.Context, c clients, d nlq.Digest, today time.Time) error {
+	ctx = metering.WithAttribution(ctx, c.ddb, d.UserSub, metering.FeatureDigest)
+	started := time.Now()
+
+	allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, c.ddb, d.UserSub)
+	if err != nil {
+		return fmt.Errorf("shop lookup: %w", err)
+	}
+
+	var (
+		out      nlq.DigestAnswer
+		runErr   error
+		quotaErr *metering.QuotaError
+	)
+	if errors.As(metering.ReserveNLQQuery(ctx, c.ddb, d.UserSub), &quotaErr) {
+		out.Ask = nlq.AskRecord{Question: d.Question, DigestID: d.Id, Outcome: nlq.AskQuotaExceeded, Reason: quotaErr.Error()}
+	} else {
+		settings, err := users.GetSettings(ctx, c.ddb, d.UserSub)
+		if err != nil {
+			fmt.Printf("nlq-digests: load settings failed: %v\n", err)
+		}
+		out, runErr = nlq.AnswerDigest(ctx, c.glue, c.bedrock, c.athena, nlq.DigestRun{
+			Digest:       d,
+			AllowedShops: allowed,
+			Fiscal:       settings.Calendar.PromptText(time.Now().UTC()),
+			Athena: nlq.AthenaRunOptions{
+				Database:       strings.TrimSpace(os.Getenv("ATHENA_DATABASE")),
+				Workgroup:      strings.TrimSpace(os.Getenv("ATHENA_WORKGROUP")),
+				OutputLocation: strings.TrimSpace(os.Getenv("ATHENA_OUTPUT_S3")),
+				MaxWait:        digestMaxWait,
+				PollInterval:   2 * time.Second,
+				MaxResultRows:  200,
+			},
+		})
+	}
+	if out.Ask.Outcome == nlq.AskResult {
+		metering.RecordNLQScan(ctx, c.ddb, d.UserSub, out.Ask.ScannedBytes)
+	}
+	out.Ask.LatencyMs = time.Since(started).Milliseconds()
+	out.Ask.AddSpend(ctx)
+	if err := nlq.RecordAsk(ctx, c.ddb, d.UserSub, out.Ask); err != nil {
+		fmt.Printf("nlq-digests: record history failed: %v\n", err)
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if out.Rejection != nil {
+		recordRejection(ctx, c.ddb, d, out)
+	}
+
+	if err := nlq.AdvanceDigest(ctx, c.ddb, d, today, out.Ask.Outcome, out.Ask.Reason); err != nil {
+		return err
+	}
+	if err := notifyUser(ctx, c, d, out); err != nil {
+		fmt.Printf("nlq-digests: notify digest %s user %s: %v\n", d.Id, d.UserSub, err)
+	}
+	return nil
+}
+
+// recordRejection logs a validator rejection as a security event, as /ask
+// does; a saved question gets no pass for having been saved.
+func recordRejection(ctx context.Context, ddb *dynamodb.Client, d nlq.Digest, out nlq.DigestAnswer) {
+	_, err := security.RecordNLQRejection(ctx, ddb, security.NLQRejection{
+		UserSub:  d.UserSub,
+		Question: d.Question,
+		SQL:      out.Ask.SQL,
+		Reason:   out.Rejection.Error(),
+		Kind:     nlq.RejectionKind(out.Rejection),
+		Stage:    "digest",
+		Shops:    out.Ask.Shops,
+	})
+	if err != nil {
+		fmt.Printf("nlq-digests: record rejection failed: %v\n", err)
+	}
+}
+
+func notifyUser(ctx context.Context, c clients, d nlq.Digest, out nlq.DigestAnswer) error {
+	topicArn, err := users.GetAlertsTopicArn(ctx, c.ddb, d.UserSub)
+	if err != nil || strings.TrimSpace(topicArn) == "" {
+		return err
+	}
+	m := notify.New("TrueProfit digest: " + notify.Truncate(d.Question, 60)).
+		Line(d.Question).
+		Line("")
+	switch out.Ask.Outcome {
+	case nlq.AskResult:
+		if out.Answer != "" {
+			m.Line(out.Answer).Line("")
+		}
+		if out.Ask.LowConfidence {
+			m.Line("TrueProfit was not confident it read this question right; check the figures, or reword the digest.").Line("")
+		}
+		rows := out.Rows
+		if len(rows) > digestRows {
+			rows = rows[:digestRows]
+		}
+		for _, r := range rows {
+			cells := make([]string, 0, len(out.Columns))
+			for _, col := range out.Columns {
+				cells = append(cells, fmt.Sprintf("%s: %v", col, r[col]))
+			}
+			m.Line(strings.Join(cells, ", "))
+		}
+		if len(out.Rows) > digestRows {
+			m.Line(fmt.Sprintf("... %d rows in total", len(out.Rows)))
+		}
+	case nlq.AskClarification:
+		m.Line("This question was too ambiguous to answer today. Try rewording it:")
+		m.Line(out.Ask.Reason)
+	case nlq.AskNoShops:
+		m.Line("None of this digest's shops are connected any more.")
+	case nlq.AskQuotaExceeded:
+		m.Line("Your daily question quota was used up, so this digest was skipped today.")
+	default:
+		m.Line("This question could not be answered today:")
+		m.Line(notify.Truncate(out.Ask.Reason, 300))
+	}
+	m.Line("").Line("You get this email every morning for a question you scheduled in TrueProfit.")
+
+	_, err = c.sns.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(m.Subject()),
+		Message:  aws.String(m.Body()),
+	})
+	return err
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/metering"
+	"backend/internal/ops"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// handler sends the weekly ops report for the last 7 full UTC days to the ops
+// topic, and to Slack when OPS_SLACK_WEBHOOK_URL is set.
+func handler(ctx context.Context) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	from := to.AddDate(0, 0, -6)
+	r := ops.WeeklyReport{From: from, To: to}
+
+	if r.Status, err = ops.LoadStatus(ctx, ddb, now); err != nil {
+		return fmt.Errorf("load status: %w", err)
+	}
+	if r.Components, r.Shops, err = ops.LoadCounters(ctx, ddb, from, to); err != nil {
+		return fmt.Errorf("load counters: %w", err)
+	}
+	if usage, err := metering.LoadDays(ctx, ddb, from, to); err != nil {
+		fmt.Printf("ops-report: bedrock usage: %v\n", err)
+	} else {
+		for _, u := range usage {
+			if u.ModelId == metering.ModelAthena {
+				continue
+			}
+			r.BedrockCalls += u.Calls
+			r.BedrockCostUSD += u.CostUSD
+		}
+	}
+	r.DLQs = dlqDepths(ctx, sqs.NewFromConfig(cfg))
+
+	m := r.Message()
+	if err := ops.Notify(ctx, sns.NewFromConfig(cfg), m.Subject(), m.Body()); err != nil {
+		return fmt.Errorf("publish report: %w", err)
+	}
+	if hook := strings.TrimSpace(os.Getenv("OPS_SLACK_WEBHOOK_URL")); hook != "" {
+		if err := postSlack(ctx, hook, m.Subject()+"\n```\n"+m.Body()+"\n```"); err != nil {
+			// the email went out; don't retry the whole report for Slack
+			fmt.Printf("ops-report: slack: %v\n", err)
+		}
+	}
+	fmt.Printf("ops-report: sent %s..%s components=%d shops=%d\n", from.Format("2006-01-02"), to.Format("2006-01-02"), len(r.Components), len(r.Shops))
+	return nil
+}
+
+// dlqDepths reads OPS_REPORT_DLQ_URLS (comma separated queue URLs).
+func dlqDepths(ctx context.Context, client *sqs.Client) []ops.QueueDepth {
+	var out []ops.QueueDepth
+	for _, u := range strings.Split(os.Getenv("OPS_REPORT_DLQ_URLS"), ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		q := ops.QueueDepth{Name: u[strings.LastIndex(u, "/")+1:], Messages: -1}
+		res, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       aws.String(u),
+			AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameApproximateNumberOfMessages},
+		})
+		if err != nil {
+			fmt.Printf("ops-report: dlq %s: %v\n", q.Name, err)
+		} else if n, err := strconv.Atoi(res.Attributes[string(sqstypes.QueueAttributeNameApproximateNumberOfMessages)]); err == nil {
+			q.Messages = n
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+func postSlack(ctx context.Context, url, text string) error {
+	b, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
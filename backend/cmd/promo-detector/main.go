@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/live"
+	"backend/internal/ops"
+	"backend/internal/promos"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// baselineDays of history set each shop's normal discount share.
+const baselineDays = 35
+
+// handler relabels each shop's recent days as promo or normal, from discount
+// usage in its synced orders and its limited-time Shopify discounts. It runs
+// just before the daily metrics ETL so is_promo is current when a day closes.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	txTable := db.TransactionsTableName()
+
+	integs, err := shopify.ListIntegrations(ctx, ddb)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "promo-detector", err)
+		return fmt.Errorf("list shopify integrations: %w", err)
+	}
+
+	apiVersion := strings.TrimSpace(os.Getenv("SHOPIFY_API_VERSION"))
+	if apiVersion == "" {
+		apiVersion = "2026-01"
+	}
+	relabel := 7
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("PROMO_RELABEL_DAYS"))); err == nil && v > 0 {
+		relabel = v
+	}
+	loc := live.Location()
+	now := time.Now().In(loc)
+	to := now.Format("2006-01-02")
+	from := now.AddDate(0, 0, -(relabel - 1)).Format("2006-01-02")
+
+	// Every user of a shop has the same orders, so one integration per shop
+	// is enough.
+	byShop := map[string]shopify.IntegrationItem{}
+	var shops []string
+	for _, it := range integs {
+		if _, ok := byShop[it.Shop]; !ok {
+			shops = append(shops, it.Shop)
+			byShop[it.Shop] = it
+		}
+	}
+
+	failed, labelled := 0, 0
+	for _, shop := range shops {
+		it := byShop[shop]
+		stats, err := promos.DailyStats(ctx, ddb, txTable, it.UserSub(), shop, now.AddDate(0, 0, -baselineDays), now)
+		if err != nil {
+			failed++
+			fmt.Printf("promo-detector: shop=%s stats: %v\n", shop, err)
+			continue
+		}
+
+		var windows []promos.Window
+		if token, err := it.DecryptAccessToken(); err == nil {
+			ds, err := shopify.ListDiscounts(ctx, shop, apiVersion, token)
+			if err != nil {
+				// Usually a missing read_discounts scope; the spike signal still works.
+				fmt.Printf("promo-detector: shop=%s discounts: %v\n", shop, err)
+			}
+			windows = promos.Windows(ds)
+		}
+
+		var recent []promos.Label
+		for _, l := range promos.Detect(stats, windows) {
+			if l.Date >= from {
+				recent = append(recent, l)
+			}
+		}
+		if err := promos.Save(ctx, ddb, shop, from, to, recent); err != nil {
+			failed++
+			fmt.Printf("promo-detector: shop=%s save: %v\n", shop, err)
+			continue
+		}
+		labelled += len(recent)
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "promo-detector", ops.BatchErr(len(shops), failed))
+	fmt.Printf("promo-detector: done shops=%d failed=%d promoDays=%d\n", len(shops), failed, labelled)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
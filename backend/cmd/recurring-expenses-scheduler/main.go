@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/recurring"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler posts every recurring expense due on or before today (UTC) as a
+// transaction. Posting is idempotent per rule and date, so overlapping or
+// retried runs are harmless.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	txTable := db.TransactionsTableName()
+	today := time.Now().UTC().Format("2006-01-02")
+
+	rules, err := recurring.Due(ctx, ddb, today)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "recurring-expenses", err)
+		return err
+	}
+
+	posted, failed := 0, 0
+	var lastErr error
+	for _, r := range rules {
+		n, err := recurring.Materialize(ctx, ddb, txTable, r, today)
+		posted += n
+		if err != nil {
+			fmt.Printf("recurring-expenses: rule %s for %s: %v\n", r.Id, r.UserSub, err)
+			failed++
+			lastErr = err
+		}
+	}
+	ops.Beat(ctx, ddb, ops.Sync, "recurring-expenses", lastErr)
+
+	fmt.Printf("recurring-expenses: %d rules due, %d transactions posted, %d failed\n", len(rules), posted, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rules failed", failed, len(rules))
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
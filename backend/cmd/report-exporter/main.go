@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/exports"
+	"backend/internal/notify"
+	"backend/internal/ops"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/athena"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// handler writes every due scheduled export to S3 and emails its link.
+// A schedule is advanced once its file is stored; a failed email or
+// delivery to the customer's bucket only costs that copy, the export stays
+// downloadable and the destination shows the failure.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	s3Client := s3.NewFromConfig(cfg)
+	snsClient := sns.NewFromConfig(cfg)
+	ath := athena.NewFromConfig(cfg)
+	txTable := db.TransactionsTableName()
+	if exports.Bucket() == "" {
+		return fmt.Errorf("ANALYTICS_BUCKET not set")
+	}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	due, err := exports.Due(ctx, ddb, today.Format("2006-01-02"))
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "report-exporter", err)
+		return err
+	}
+
+	failed := 0
+	var lastErr error
+	for _, s := range due {
+		if err := export(ctx, cfg, ddb, ath, s3Client, snsClient, txTable, s, today); err != nil {
+			fmt.Printf("report-exporter: schedule %s user %s: %v\n", s.Id, s.UserSub, err)
+			failed++
+			lastErr = err
+		}
+	}
+	ops.Beat(ctx, ddb, ops.Sync, "report-exporter", ops.BatchErr(len(due), failed))
+
+	fmt.Printf("report-exporter: %d due, %d failed\n", len(due), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d exports failed: %w", failed, len(due), lastErr)
+	}
+	return nil
+}
+
+func export(ctx context.Context, cfg aws.Config, ddb *dynamodb.Client, ath *athena.Client, s3Client *s3.Client, snsClient *sns.Client, txTable string, s exports.Schedule, today time.Time) error {
+	// A run that was missed covers the last full period, not the one that
+	// was due back then.
+	from, to := exports.Period(s.Cadence, today)
+	body, err := exports.Build(ctx, ddb, ath, txTable, s, from, to)
+	if err != nil {
+		return err
+	}
+	key := exports.Key(s, from, to)
+	if err := exports.Upload(ctx, s3Client, key, body); err != nil {
+		return err
+	}
+	delivery := deliver(ctx, cfg, ddb, s, exports.FileName(s, from, to), body)
+	if err := exports.Advance(ctx, ddb, s, key, from, to, today); err != nil {
+		return err
+	}
+
+	ttl := exports.LinkTTL()
+	link, err := exports.Link(ctx, s3Client, key, ttl)
+	if err == nil {
+		err = notifyUser(ctx, ddb, snsClient, s, from, to, link, ttl, delivery)
+	}
+	if err != nil {
+		fmt.Printf("report-exporter: notify schedule %s user %s: %v\n", s.Id, s.UserSub, err)
+	}
+	return nil
+}
+
+// deliver copies an export to the schedule's destination, if any, and
+// records the outcome on it. The result is a line for the email ("" when
+// there is no destination).
+func deliver(ctx context.Context, cfg aws.Config, ddb *dynamodb.Client, s exports.Schedule, name string, body []byte) string {
+	if s.DestinationId == "" {
+		return ""
+	}
+	d, err := exports.GetDestination(ctx, ddb, s.UserSub, s.DestinationId)
+	if errors.Is(err, exports.ErrDestinationNotFound) {
+		return "Its destination bucket was removed; the export was not copied."
+	}
+	if err != nil {
+		fmt.Printf("report-exporter: schedule %s: load destination: %v\n", s.Id, err)
+		return "The copy to your bucket failed; it will not be retried for this period."
+	}
+	key, err := exports.Deliver(ctx, cfg, d, s.Id+"/"+name, body)
+	if rerr := exports.RecordDelivery(ctx, ddb, d, key, err); rerr != nil {
+		fmt.Printf("report-exporter: %v\n", rerr)
+	}
+	if err != nil {
+		fmt.Printf("report-exporter: schedule %s user %s: %v\n", s.Id, s.UserSub, err)
+		return fmt.Sprintf("The copy to s3://%s failed: %v", d.Bucket, err)
+	}
+	return fmt.Sprintf("Also delivered to s3://%s/%s.", d.Bucket, key)
+}
+
+func notifyUser(ctx context.Context, ddb *dynamodb.Client, snsClient *sns.Client, s exports.Schedule, from, to time.Time, link string, ttl time.Duration, delivery string) error {
+	topicArn, err := users.GetAlertsTopicArn(ctx, ddb, s.UserSub)
+	if err != nil || strings.TrimSpace(topicArn) == "" {
+		return err
+	}
+	name := "P&L"
+	switch s.Report {
+	case exports.ReportTransactions:
+		name = "Transactions"
+	case exports.ReportDailyMetrics:
+		name = "Daily metrics"
+	}
+	m := notify.New(fmt.Sprintf("TrueProfit: %s export %s to %s", name, from.Format("2006-01-02"), to.Format("2006-01-02"))).
+		Line(fmt.Sprintf("Your %s %s export is ready.", s.Cadence, name)).
+		Line("").
+		Field("From", from.Format("2006-01-02")).
+		Field("To", to.Format("2006-01-02")).
+		Field("Shop", s.Shop).
+		Line("").
+		Line(fmt.Sprintf("Download (link valid for up to %d hours):", int(ttl.Hours()))).
+		Line(link)
+	if delivery != "" {
+		m.Line("").Line(delivery)
+	}
+	_, err = snsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(m.Subject()),
+		Message:  aws.String(m.Body()),
+	})
+	return err
+}
+
+func main() {
+	lambda.Start(handler)
+}
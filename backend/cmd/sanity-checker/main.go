@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/notify"
+	"backend/internal/ops"
+	"backend/internal/sanity"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// handler runs the data-sanity checks for every user, stores each report
+// and emails users about findings their previous report didn't have. With
+// SANITY_AUTO_FIX=true it applies the automatic fixes first.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	snsClient := sns.NewFromConfig(cfg)
+	txTable := db.TransactionsTableName()
+	autoFix := strings.EqualFold(strings.TrimSpace(os.Getenv("SANITY_AUTO_FIX")), "true")
+
+	subs, err := users.AllSubs(ctx, ddb)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "sanity-checker", err)
+		return err
+	}
+
+	now := time.Now().UTC()
+	findings, fixed, failed := 0, 0, 0
+	var lastErr error
+	for _, sub := range subs {
+		n, newFindings, err := checkUser(ctx, ddb, txTable, sub, now, autoFix)
+		if err != nil {
+			fmt.Printf("sanity-checker: user %s: %v\n", sub, err)
+			failed++
+			lastErr = err
+			continue
+		}
+		fixed += n
+		findings += len(newFindings)
+		if len(newFindings) > 0 {
+			if err := notifyUser(ctx, ddb, snsClient, sub, newFindings); err != nil {
+				fmt.Printf("sanity-checker: notify user %s: %v\n", sub, err)
+			}
+		}
+	}
+	ops.Beat(ctx, ddb, ops.Sync, "sanity-checker", ops.BatchErr(len(subs), failed))
+
+	fmt.Printf("sanity-checker: %d users, %d new findings, %d rows fixed, %d failed\n", len(subs), findings, fixed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users failed: %w", failed, len(subs), lastErr)
+	}
+	return nil
+}
+
+func checkUser(ctx context.Context, ddb *dynamodb.Client, txTable, sub string, now time.Time, autoFix bool) (int, []sanity.Finding, error) {
+	prev, err := sanity.Latest(ctx, ddb, txTable, sub)
+	if err != nil && !errors.Is(err, sanity.ErrNotFound) {
+		return 0, nil, err
+	}
+	fixed := 0
+	if autoFix {
+		if fixed, err = sanity.FixMissingGSI(ctx, ddb, txTable, sub); err != nil {
+			return fixed, nil, err
+		}
+	}
+	r, err := sanity.Run(ctx, ddb, txTable, sub, now)
+	if err != nil {
+		return fixed, nil, err
+	}
+	r.Fixed = fixed
+	if err := sanity.Save(ctx, ddb, txTable, sub, r); err != nil {
+		return fixed, nil, err
+	}
+	return fixed, sanity.New(prev, r), nil
+}
+
+func notifyUser(ctx context.Context, ddb *dynamodb.Client, snsClient *sns.Client, sub string, findings []sanity.Finding) error {
+	topicArn, err := users.GetAlertsTopicArn(ctx, ddb, sub)
+	if err != nil || strings.TrimSpace(topicArn) == "" {
+		return err
+	}
+	m := notify.New("TrueProfit: data check found something to review").
+		Line("This week's data check found issues that may make your numbers wrong:").
+		Line("")
+	for _, f := range findings {
+		m.Line("- " + f.Message)
+	}
+	m.Line("").Line("Open TrueProfit to see the full report.")
+	_, err = snsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(m.Subject()),
+		Message:  aws.String(m.Body()),
+	})
+	return err
+}
+
+func main() {
+	lambda.Start(handler)
+}
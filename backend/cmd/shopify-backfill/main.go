@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// pageSize is small on purpose: many cheap pages interleave shops better
+// than a few expensive ones.
+const pageSize = 50
+
+// stopBefore leaves time to persist progress before the Lambda deadline.
+const stopBefore = 45 * time.Second
+
+type shopRun struct {
+	sync      *shopify.OrdersSync
+	notBefore time.Time
+	failed    bool
+}
+
+// handler incrementally syncs every connected shop from its LastSyncAt,
+// closing gaps left by missed webhooks. Shops are served round-robin one
+// page at a time under a shared Budget, and each shop's throttle status is
+// honoured, so a single large store can neither starve the others nor eat
+// the merchant's API limit. Progress is saved after every page, so a run
+// cut short by the budget or the deadline resumes where it stopped.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	txTable := db.TransactionsTableName()
+
+	integs, err := shopify.ListIntegrations(ctx, ddb)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "shopify-backfill", err)
+		return fmt.Errorf("list shopify integrations: %w", err)
+	}
+
+	apiVersion := strings.TrimSpace(os.Getenv("SHOPIFY_API_VERSION"))
+	if apiVersion == "" {
+		apiVersion = "2026-01"
+	}
+
+	runs := make([]*shopRun, 0, len(integs))
+	failed := 0
+	for _, it := range integs {
+		token, err := it.DecryptAccessToken()
+		if err != nil {
+			failed++
+			fmt.Printf("shopify-backfill: shop=%s user=%s token: %v\n", it.Shop, it.UserSub(), err)
+			continue
+		}
+		since := it.LastSyncAt
+		if since == "" {
+			since = time.Now().UTC().Add(-30 * 24 * time.Hour).Format(time.RFC3339)
+		}
+		runs = append(runs, &shopRun{sync: shopify.NewOrdersSync(it.UserSub(), it.Shop, apiVersion, token, since)})
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	budget := shopify.NewBudgetFromEnv()
+	stopped := ""
+
+	for {
+		if budget.Exhausted() {
+			stopped = "budget"
+			break
+		}
+		if hasDeadline && time.Until(deadline) < stopBefore {
+			stopped = "deadline"
+			break
+		}
+
+		// Pick the next runnable shop; if all are cooling down, wait for the earliest.
+		var next *shopRun
+		active := 0
+		for _, r := range runs {
+			if r.failed || r.sync.Done {
+				continue
+			}
+			active++
+			if next == nil || r.notBefore.Before(next.notBefore) {
+				next = r
+			}
+		}
+		if active == 0 {
+			break
+		}
+		if d := time.Until(next.notBefore); d > 0 {
+			time.Sleep(d)
+		}
+		if err := budget.Wait(ctx); err != nil {
+			stopped = "context"
+			break
+		}
+
+		s := next.sync
+		err := s.NextPage(ctx, ddb, txTable, pageSize)
+		budget.Spend(s.LastCost)
+		next.notBefore = time.Now().Add(shopify.ShopBackoff(s.LastCost))
+
+		var gqlErr *shopify.GraphQLErrors
+		if err != nil {
+			if errors.As(err, &gqlErr) && isThrottled(gqlErr) {
+				// Leave the shop alone for a while and retry the same page.
+				next.notBefore = time.Now().Add(10 * time.Second)
+				continue
+			}
+			next.failed = true
+			failed++
+			fmt.Printf("shopify-backfill: shop=%s user=%s failed: %v\n", s.Shop, s.Sub, err)
+			continue
+		}
+		if err := s.SaveProgress(ctx, ddb); err != nil {
+			fmt.Printf("shopify-backfill: shop=%s save progress: %v\n", s.Shop, err)
+		}
+	}
+
+	created, pages, complete := 0, 0, 0
+	for _, r := range runs {
+		created += r.sync.Created
+		pages += r.sync.Pages
+		if r.sync.Done {
+			complete++
+		}
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "shopify-backfill", ops.BatchErr(len(integs), failed))
+	fmt.Printf("shopify-backfill: done shops=%d complete=%d failed=%d pages=%d created=%d cost=%.0f stopped=%q\n",
+		len(integs), complete, failed, pages, created, budget.Spent(), stopped)
+	return nil
+}
+
+func isThrottled(e *shopify.GraphQLErrors) bool {
+	for _, m := range e.Messages {
+		if strings.Contains(m, "THROTTLED") {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"backend/internal/db"
+	"backend/internal/notify"
+	"backend/internal/ops"
+	"backend/internal/shadow"
 	"backend/internal/shopify"
 	"backend/internal/users"
 
@@ -15,24 +17,17 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 )
 
-type EBEvent struct {
-	DetailType string         `json:"detail-type"`
-	Source     string         `json:"source"`
-	Time       string         `json:"time"`
-	Detail     map[string]any `json:"detail"`
-}
-
 func handler(ctx context.Context, sqsEvent events.SQSEvent) (any, error) {
 	ddb, err := db.NewDynamoClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	awsCfg, err := config.LoadDefaultConfig(ctx)
+	awsCfg, err := shadow.LoadConfig(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -40,24 +35,46 @@ func handler(ctx context.Context, sqsEvent events.SQSEvent) (any, error) {
 
 	sent := 0
 	skipped := 0
+	failed := 0
+
+	// During a replay per-event alerts are held back; the replay monitor
+	// sends one catch-up summary when the shop goes quiet.
+	replaying := map[string]bool{}
+	suppressed := map[string]*shopify.ReplayBatch{}
 
 	for _, rec := range sqsEvent.Records {
-		var ev EBEvent
-		if err := json.Unmarshal([]byte(rec.Body), &ev); err != nil {
+		ev, err := shopify.ParseWebhookEvent(rec.Body)
+		if err != nil {
 			skipped++
 			continue
 		}
 
-		meta := asMap(pickAny(ev.Detail, "metadata"))
-		topic := pickString(meta, "X-Shopify-Topic")
-		shopDomain := pickString(meta, "X-Shopify-Shop-Domain")
-		webhookID := pickString(meta, "X-Shopify-Webhook-Id")
-
+		meta := ev.Detail.Metadata
+		topic := meta.Topic
+		shopDomain := meta.ShopDomain
 		if topic == "" || shopDomain == "" {
 			skipped++
 			continue
 		}
 
+		at := shopify.EventTime(meta.TriggeredAt, ev.Time, time.Now())
+		inReplay, seen := replaying[shopDomain]
+		if !seen {
+			inReplay, err = shopify.InReplay(ctx, ddb, shopDomain)
+			if err != nil {
+				fmt.Printf("shopify-emailer: replay check shop=%s: %v\n", shopDomain, err)
+			}
+			replaying[shopDomain] = inReplay
+		}
+		if inReplay || time.Since(at) >= shopify.ReplayLag {
+			if suppressed[shopDomain] == nil {
+				suppressed[shopDomain] = &shopify.ReplayBatch{}
+			}
+			suppressed[shopDomain].Add(at, false, false)
+			skipped++
+			continue
+		}
+
 		// shop -> users
 		subs, err := shopify.UsersForShop(ctx, ddb, shopDomain)
 		if err != nil || len(subs) == 0 {
@@ -65,8 +82,18 @@ func handler(ctx context.Context, sqsEvent events.SQSEvent) (any, error) {
 			continue
 		}
 
-		subject, message := buildMessage(topic, shopDomain, webhookID, ev.Detail)
+		a, err := parseAlert(ev)
+		if err != nil {
+			fmt.Printf("shopify-emailer: shop=%s topic=%s: %v\n", shopDomain, topic, err)
+			skipped++
+			continue
+		}
+		if a.refund != nil {
+			a.order = refundedOrder(ctx, ddb, subs, shopDomain, a.refund.OrderID)
+		}
 
+		// Users differ only in how many line items they want listed.
+		messages := map[int][2]string{}
 		for _, sub := range subs {
 			userTopicArn, err := users.GetAlertsTopicArn(ctx, ddb, sub)
 			if err != nil || strings.TrimSpace(userTopicArn) == "" {
@@ -74,85 +101,193 @@ func handler(ctx context.Context, sqsEvent events.SQSEvent) (any, error) {
 				continue
 			}
 
+			lines := 0
+			if settings, err := users.GetSettings(ctx, ddb, sub); err == nil {
+				lines = settings.Notifications.OrderLineItems
+			}
+			msg, ok := messages[lines]
+			if !ok {
+				subject, body := buildMessage(a, lines)
+				msg = [2]string{subject, body}
+				messages[lines] = msg
+			}
+
 			_, err = snsClient.Publish(ctx, &sns.PublishInput{
 				TopicArn: aws.String(userTopicArn),
-				Subject:  aws.String(subject),
-				Message:  aws.String(message),
+				Subject:  aws.String(msg[0]),
+				Message:  aws.String(msg[1]),
 			})
 			if err == nil {
 				sent++
+			} else {
+				failed++
 			}
 		}
 	}
 
-	return map[string]any{"ok": true, "sent": sent, "skipped": skipped}, nil
+	held := 0
+	for shop, s := range suppressed {
+		held += s.Events
+		if err := shopify.NoteSuppressed(ctx, ddb, shop, s.Events, s.Oldest); err != nil {
+			fmt.Printf("shopify-emailer: note suppressed shop=%s: %v\n", shop, err)
+		}
+	}
+
+	ops.Events(ctx, ddb, ops.Alerts, "shopify-emailer", sent+failed, failed)
+	ops.Beat(ctx, ddb, ops.Alerts, "shopify-emailer", ops.BatchErr(sent+failed, failed))
+	return map[string]any{"ok": true, "sent": sent, "skipped": skipped, "suppressed": held}, nil
 }
 
-func buildMessage(topic, shopDomain, webhookID string, detail map[string]any) (subject string, body string) {
-	payload := asMap(pickAny(detail, "payload"))
+// alert is one webhook event decoded for an alert email. Exactly one of
+// orderPayload and refund is set.
+type alert struct {
+	topic, shopDomain, webhookID string
+	orderPayload                 *shopify.OrderPayload
+	refund                       *shopify.RefundPayload
+	refundRaw                    map[string]any // for shopify.RefundAmount
 
-	objID := fmt.Sprintf("%v", pickAny(payload, "id"))
-	total := fmt.Sprintf("%v", pickAny(payload, "current_total_price", "total_price"))
-	currency := pickString(payload, "currency")
-	createdAt := pickString(payload, "created_at", "processed_at")
+	// order is the stored original order of a refund, nil when unknown.
+	order *shopify.OrderTx
+}
 
-	subject = fmt.Sprintf("TrueProfit: %s (%s)", topic, shopDomain)
+func parseAlert(ev shopify.WebhookEvent) (alert, error) {
+	a := alert{
+		topic:      ev.Detail.Metadata.Topic,
+		shopDomain: ev.Detail.Metadata.ShopDomain,
+		webhookID:  ev.Detail.Metadata.WebhookID,
+	}
+	if strings.HasPrefix(a.topic, "refunds/") {
+		a.refund = &shopify.RefundPayload{}
+		if err := ev.DecodePayload(a.refund); err != nil {
+			return a, err
+		}
+		a.refundRaw = map[string]any{}
+		return a, ev.DecodePayload(&a.refundRaw)
+	}
+	a.orderPayload = &shopify.OrderPayload{}
+	return a, ev.DecodePayload(a.orderPayload)
+}
 
-	lines := []string{
-		"TrueProfit Shopify Event",
-		"",
-		fmt.Sprintf("Shop: %s", shopDomain),
-		fmt.Sprintf("Topic: %s", topic),
+// refundedOrder finds the stored order a refund event belongs to, or nil.
+// Every user of a shop gets the same order item, so the first hit will do.
+func refundedOrder(ctx context.Context, ddb *dynamodb.Client, subs []string, shopDomain string, orderID shopify.ObjectID) *shopify.OrderTx {
+	if orderID == "" {
+		return nil
 	}
-	if webhookID != "" {
-		lines = append(lines, fmt.Sprintf("WebhookId: %s", webhookID))
+	for _, sub := range subs {
+		o, err := shopify.LoadOrderTx(ctx, ddb, sub, shopDomain, string(orderID))
+		if err != nil {
+			fmt.Printf("shopify-emailer: order lookup shop=%s order=%s: %v\n", shopDomain, orderID, err)
+			return nil
+		}
+		if o != nil {
+			return o
+		}
 	}
-	if objID != "" && objID != "<nil>" {
-		lines = append(lines, fmt.Sprintf("ObjectId: %s", objID))
+	return nil
+}
+
+// buildMessage renders one alert. Order alerts list the top lineItems line
+// items (title × qty) when lineItems > 0; customer and address details stay
+// out on purpose. Refund alerts add the original order's name, date, amount
+// and the net left after the refund.
+func buildMessage(a alert, lineItems int) (subject string, body string) {
+	if a.refund != nil {
+		return buildRefundMessage(a)
 	}
-	if total != "" && total != "<nil>" {
+	o := a.orderPayload
+
+	m := notify.New(fmt.Sprintf("TrueProfit: %s (%s)", a.topic, a.shopDomain)).
+		Line("TrueProfit Shopify Event").
+		Line("").
+		Field("Shop", a.shopDomain).
+		Field("Topic", a.topic).
+		Field("WebhookId", a.webhookID).
+		Field("ObjectId", o.ID).
+		Field("Order", o.Name).
+		Field("FinancialStatus", o.FinancialStatus)
+	if total := o.Total(); total != "" {
+		currency := o.Currency
 		if currency == "" {
 			currency = "USD"
 		}
-		lines = append(lines, fmt.Sprintf("Amount: %s %s", total, currency))
+		m.Field("Amount", string(total)+" "+currency)
 	}
-	if createdAt != "" {
-		lines = append(lines, fmt.Sprintf("CreatedAt: %s", createdAt))
+	createdAt := o.CreatedAt
+	if createdAt == "" {
+		createdAt = o.ProcessedAt
 	}
-	lines = append(lines, "", fmt.Sprintf("ReceivedAt: %s", time.Now().UTC().Format(time.RFC3339)))
+	m.Field("CreatedAt", createdAt)
 
-	body = strings.Join(lines, "\n")
-	return subject, body
-}
-
-func pickString(m map[string]any, keys ...string) string {
-	for _, k := range keys {
-		if v, ok := m[k]; ok {
-			if s, ok := v.(string); ok && s != "" {
-				return s
+	if lineItems > 0 {
+		lines := o.Lines()
+		if len(lines) > 0 {
+			m.Line("").Line("Top items:")
+			for _, l := range shopify.TopLines(lines, lineItems) {
+				title := strings.Join(strings.Fields(l.Title), " ")
+				m.Line(fmt.Sprintf("  %s × %d", notify.Truncate(title, 120), l.Quantity))
+			}
+			if more := len(lines) - lineItems; more > 0 {
+				m.Line(fmt.Sprintf("  …and %d more", more))
 			}
 		}
 	}
-	return ""
+
+	m.Line("").
+		Field("ReceivedAt", time.Now().UTC().Format(time.RFC3339))
+	return m.Subject(), m.Body()
 }
 
-func pickAny(m map[string]any, keys ...string) any {
-	for _, k := range keys {
-		if v, ok := m[k]; ok {
-			return v
-		}
+func buildRefundMessage(a alert) (subject string, body string) {
+	r, order := a.refund, a.order
+	amount, ok := shopify.RefundAmount(a.refundRaw)
+	currency := r.Currency
+	if currency == "" && order != nil {
+		currency = order.Currency
+	}
+	if currency == "" {
+		currency = "USD"
 	}
-	return nil
-}
 
-func asMap(v any) map[string]any {
-	if v == nil {
-		return map[string]any{}
+	title := fmt.Sprintf("TrueProfit: refund (%s)", a.shopDomain)
+	if order != nil && order.OrderName != "" {
+		title = fmt.Sprintf("TrueProfit: refund on %s (%s)", order.OrderName, a.shopDomain)
 	}
-	if m, ok := v.(map[string]any); ok {
-		return m
+	m := notify.New(title).
+		Line("TrueProfit Shopify Refund").
+		Line("").
+		Field("Shop", a.shopDomain).
+		Field("Topic", a.topic).
+		Field("WebhookId", a.webhookID).
+		Field("RefundId", r.ID)
+	if ok {
+		m.Field("Refunded", fmt.Sprintf("%.2f %s", amount, currency))
 	}
-	return map[string]any{}
+
+	if order == nil {
+		m.Field("OrderId", r.OrderID).
+			Line("Original order not found in TrueProfit.")
+	} else {
+		m.Line("").
+			Field("Order", order.OrderName).
+			Field("OrderDate", order.CreatedAt).
+			Field("OrderAmount", fmt.Sprintf("%.2f %s", order.Amount, order.Currency))
+		if ok && order.Currency == currency {
+			m.Field("NetAfterRefund", fmt.Sprintf("%.2f %s", order.Amount-amount, currency))
+			if order.Amount > 0 {
+				m.Field("RefundedShare", fmt.Sprintf("%.1f%%", 100*amount/order.Amount))
+			}
+		}
+	}
+	createdAt := r.CreatedAt
+	if createdAt == "" {
+		createdAt = r.ProcessedAt
+	}
+	m.Field("CreatedAt", createdAt).
+		Line("").
+		Field("ReceivedAt", time.Now().UTC().Format(time.RFC3339))
+
+	return m.Subject(), m.Body()
 }
 
 func main() { lambda.Start(handler) }
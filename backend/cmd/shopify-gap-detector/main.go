@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// maxRepairPages bounds the API spend of one gap repair per user and shop.
+const maxRepairPages = 20
+
+// handler looks for holes in each shop's order number sequence over the last
+// few days (missed webhooks), re-syncs just the window around each hole for
+// every user of the shop, and records the outcome on their integration items.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	txTable := db.TransactionsTableName()
+
+	integs, err := shopify.ListIntegrations(ctx, ddb)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Ingestion, "shopify-gap-detector", err)
+		return fmt.Errorf("list shopify integrations: %w", err)
+	}
+
+	apiVersion := strings.TrimSpace(os.Getenv("SHOPIFY_API_VERSION"))
+	if apiVersion == "" {
+		apiVersion = "2026-01"
+	}
+	lookback := 3
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SHOPIFY_GAP_LOOKBACK_DAYS"))); err == nil && v > 0 {
+		lookback = v
+	}
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -lookback)
+
+	byShop := map[string][]shopify.IntegrationItem{}
+	var shops []string
+	for _, it := range integs {
+		if _, ok := byShop[it.Shop]; !ok {
+			shops = append(shops, it.Shop)
+		}
+		byShop[it.Shop] = append(byShop[it.Shop], it)
+	}
+
+	budget := shopify.NewBudgetFromEnv()
+	failed, found, recovered := 0, 0, 0
+	for _, shop := range shops {
+		gaps, err := shopify.FindOrderGaps(ctx, ddb, shop, from, to)
+		if err != nil {
+			failed++
+			ops.ShopFailure(ctx, ddb, shop)
+			fmt.Printf("shopify-gap-detector: shop=%s find gaps: %v\n", shop, err)
+			continue
+		}
+
+		rep := shopify.GapRepair{}
+		for _, g := range gaps {
+			rep.Found += len(g.Missing())
+			rep.Window = g.WindowStart.Format(time.RFC3339) + "/" + g.WindowEnd.Format(time.RFC3339)
+			for _, it := range byShop[shop] {
+				if err := repair(ctx, ddb, txTable, apiVersion, budget, it, g); err != nil {
+					rep.Err = err.Error()
+					fmt.Printf("shopify-gap-detector: shop=%s user=%s repair %d-%d: %v\n", shop, it.UserSub(), g.FirstMissing, g.LastMissing, err)
+				}
+			}
+			if rep.Err != "" {
+				// Leave the numbers unresolved so the next run retries them.
+				continue
+			}
+			n, err := shopify.ResolveGap(ctx, ddb, shop, g)
+			if err != nil {
+				rep.Err = err.Error()
+				continue
+			}
+			rep.Recovered += n
+		}
+
+		if rep.Err != "" {
+			failed++
+			ops.ShopFailure(ctx, ddb, shop)
+		}
+		found += rep.Found
+		recovered += rep.Recovered
+		if rep.Found > 0 {
+			fmt.Printf("shopify-gap-detector: shop=%s missing=%d recovered=%d\n", shop, rep.Found, rep.Recovered)
+		}
+		for _, it := range byShop[shop] {
+			if err := shopify.RecordGapRepair(ctx, ddb, it.UserSub(), shop, rep); err != nil {
+				fmt.Printf("shopify-gap-detector: shop=%s user=%s record: %v\n", shop, it.UserSub(), err)
+			}
+		}
+	}
+
+	ops.Beat(ctx, ddb, ops.Ingestion, "shopify-gap-detector", ops.BatchErr(len(shops), failed))
+	fmt.Printf("shopify-gap-detector: done shops=%d failed=%d missing=%d recovered=%d\n", len(shops), failed, found, recovered)
+	return nil
+}
+
+func repair(ctx context.Context, ddb *dynamodb.Client, txTable, apiVersion string, budget *shopify.Budget, it shopify.IntegrationItem, g shopify.OrderGap) error {
+	token, err := it.DecryptAccessToken()
+	if err != nil {
+		return err
+	}
+	s := shopify.NewOrdersRepair(it.UserSub(), it.Shop, apiVersion, token, g.WindowStart, g.WindowEnd)
+	for !s.Done && s.Pages < maxRepairPages {
+		if budget.Exhausted() {
+			return fmt.Errorf("budget exhausted")
+		}
+		if err := budget.Wait(ctx); err != nil {
+			return err
+		}
+		if err := s.NextPage(ctx, ddb, txTable, 50); err != nil {
+			return err
+		}
+		budget.Spend(s.LastCost)
+		if d := shopify.ShopBackoff(s.LastCost); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
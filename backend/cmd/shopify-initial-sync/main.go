@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// stopBefore leaves time to record progress and re-enqueue before the
+// Lambda deadline.
+const stopBefore = 60 * time.Second
+
+// handler runs the 90-day backfill queued by the OAuth callback, one shop per
+// message. Orders are read oldest-updated first, so when time runs out the
+// job re-enqueues itself from the newest updatedAt it stored.
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return events.SQSEventResponse{}, err
+	}
+
+	failures := make([]events.SQSBatchItemFailure, 0)
+	for _, rec := range sqsEvent.Records {
+		var job shopify.InitialSyncJob
+		if err := json.Unmarshal([]byte(rec.Body), &job); err != nil || job.Sub == "" || job.Shop == "" {
+			fmt.Printf("initial-sync: msgId=%s invalid job: %s\n", rec.MessageId, rec.Body)
+			continue
+		}
+		if err := run(ctx, ddb, job); err != nil {
+			fmt.Printf("initial-sync: shop=%s user=%s failed: %v\n", job.Shop, job.Sub, err)
+			failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+		}
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "shopify-initial-sync", ops.BatchErr(len(sqsEvent.Records), len(failures)))
+	return events.SQSEventResponse{BatchItemFailures: failures}, nil
+}
+
+// run returns an error only for infrastructure problems worth an SQS retry;
+// shop-side failures are recorded on the integration instead.
+func run(ctx context.Context, ddb *dynamodb.Client, job shopify.InitialSyncJob) error {
+	accessToken, _, err := shopify.LoadIntegrationAndDecryptToken(ctx, job.Sub, job.Shop)
+	if err != nil {
+		// Disconnected before the job started, or the token is unusable.
+		fmt.Printf("initial-sync: shop=%s user=%s skipped: %v\n", job.Shop, job.Sub, err)
+		return nil
+	}
+	if err := shopify.RecordInitialSyncRunning(ctx, ddb, job.Sub, job.Shop, 0); err != nil {
+		if errors.Is(err, shopify.ErrIntegrationGone) {
+			return nil
+		}
+		return err
+	}
+
+	apiVersion := strings.TrimSpace(os.Getenv("SHOPIFY_API_VERSION"))
+	if apiVersion == "" {
+		apiVersion = "2026-01"
+	}
+	txTable := db.TransactionsTableName()
+	deadline, hasDeadline := ctx.Deadline()
+
+	s := shopify.NewOrdersSync(job.Sub, job.Shop, apiVersion, accessToken, job.Since)
+	for !s.Done {
+		if hasDeadline && time.Until(deadline) < stopBefore {
+			if err := s.SaveProgress(ctx, ddb); err != nil {
+				return err
+			}
+			next := job
+			next.Since = s.LastSyncAt
+			fmt.Printf("initial-sync: shop=%s user=%s continuing from %s\n", job.Shop, job.Sub, next.Since)
+			return shopify.SendInitialSync(ctx, next)
+		}
+
+		before := s.Created
+		err := s.NextPage(ctx, ddb, txTable, 100)
+		var gqlErr *shopify.GraphQLErrors
+		if errors.As(err, &gqlErr) && throttled(gqlErr) {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if err != nil {
+			return finish(ctx, ddb, job, err.Error())
+		}
+		if err := shopify.RecordInitialSyncRunning(ctx, ddb, job.Sub, job.Shop, s.Created-before); err != nil {
+			if errors.Is(err, shopify.ErrIntegrationGone) {
+				return nil
+			}
+			return err
+		}
+		if err := s.SaveProgress(ctx, ddb); err != nil {
+			return err
+		}
+		if d := shopify.ShopBackoff(s.LastCost); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	fmt.Printf("initial-sync: shop=%s user=%s done pages=%d created=%d\n", job.Shop, job.Sub, s.Pages, s.Created)
+	return finish(ctx, ddb, job, "")
+}
+
+func finish(ctx context.Context, ddb *dynamodb.Client, job shopify.InitialSyncJob, errMsg string) error {
+	err := shopify.RecordInitialSyncFinished(ctx, ddb, job.Sub, job.Shop, errMsg)
+	if errors.Is(err, shopify.ErrIntegrationGone) {
+		return nil
+	}
+	return err
+}
+
+func throttled(e *shopify.GraphQLErrors) bool {
+	for _, m := range e.Messages {
+		if strings.Contains(m, "THROTTLED") {
+			return true
+		}
+	}
+	return false
+}
+
+func main() { lambda.Start(handler) }
@@ -3,12 +3,18 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	"backend/internal/db"
+	"backend/internal/feerules"
+	"backend/internal/live"
+	"backend/internal/nlq"
+	"backend/internal/ops"
+	"backend/internal/restate"
 	"backend/internal/shopify"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -35,19 +41,108 @@ func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResp
 	txTable := db.TransactionsTableName()
 
 	failures := make([]events.SQSBatchItemFailure, 0)
+	b := newBatch()
 
 	for _, rec := range sqsEvent.Records {
-		if err := processOneOrder(ctx, ddb, txTable, rec.Body); err != nil {
+		entry := eventMeta(rec.Body)
+		shop := entry.Shop
+		b.observe(shop, entry.TriggeredAt)
+		err := processOneOrder(ctx, ddb, txTable, rec.Body, b)
+		b.logWebhook(entry, err)
+		if err != nil {
 			// Log + mark this message as failed so it retries (or goes to DLQ)
 			fmt.Printf("orders-worker: msgId=%s failed: %v\n", rec.MessageId, err)
 			failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+			ops.ShopFailure(ctx, ddb, shop)
 		}
 	}
+	b.flush(ctx, ddb)
 
+	ops.Events(ctx, ddb, ops.Ingestion, "shopify-orders-worker", len(sqsEvent.Records), len(failures))
+	ops.Beat(ctx, ddb, ops.Ingestion, "shopify-orders-worker", ops.BatchErr(len(sqsEvent.Records), len(failures)))
 	return events.SQSEventResponse{BatchItemFailures: failures}, nil
 }
 
-func processOneOrder(ctx context.Context, ddb *dynamodb.Client, txTable string, body string) error {
+// batch holds the side effects written once per SQS batch rather than once
+// per event: last-event status, live aggregates, /ask cache invalidation and
+// replay tracking. Lambda hands us bigger batches the deeper the queue, so
+// during a replay burst these writes shrink with the load.
+type batch struct {
+	live       live.Batch
+	lastEvents map[[2]string]lastEvent // (sub, shop)
+	invalidate map[[2]string]bool
+	replays    map[string]*shopify.ReplayBatch
+
+	webhooks    []shopify.WebhookLogEntry
+	quarantined map[string]bool // webhook ids parked for lack of users
+}
+
+type lastEvent struct {
+	topic, webhookID string
+}
+
+func newBatch() *batch {
+	return &batch{
+		lastEvents: map[[2]string]lastEvent{},
+		invalidate: map[[2]string]bool{},
+		replays:    map[string]*shopify.ReplayBatch{},
+
+		quarantined: map[string]bool{},
+	}
+}
+
+// logWebhook records what became of one event for the webhook log.
+func (b *batch) logWebhook(e shopify.WebhookLogEntry, err error) {
+	switch {
+	case err != nil:
+		e.Status = shopify.WebhookFailed
+	case b.quarantined[e.WebhookID]:
+		e.Status = shopify.WebhookQuarantined
+	default:
+		e.Status = shopify.WebhookProcessed
+	}
+	e.ProcessedAt = time.Now().UTC().Format(time.RFC3339)
+	b.webhooks = append(b.webhooks, e)
+}
+
+func (b *batch) observe(shop string, at time.Time) {
+	if shop == "" {
+		return
+	}
+	if b.replays[shop] == nil {
+		b.replays[shop] = &shopify.ReplayBatch{}
+	}
+	b.replays[shop].Add(at, true, false)
+}
+
+func (b *batch) flush(ctx context.Context, ddb *dynamodb.Client) {
+	nowISO := time.Now().UTC().Format(time.RFC3339)
+	for k, e := range b.lastEvents {
+		_ = shopify.UpdateLastEvent(ctx, ddb, k[0], k[1], nowISO, e.topic, e.webhookID)
+	}
+	b.live.Flush(ctx, ddb, func(sub string, d live.Delta, err error) {
+		fmt.Printf("orders-worker: live aggregates user=%s shop=%s: %v\n", sub, d.Shop, err)
+	})
+	for k := range b.invalidate {
+		if _, err := nlq.InvalidateCachedForShop(ctx, ddb, k[0], k[1]); err != nil {
+			fmt.Printf("orders-worker: nlq cache invalidation user=%s shop=%s: %v\n", k[0], k[1], err)
+		}
+	}
+	if err := shopify.LogWebhooks(ctx, ddb, b.webhooks); err != nil {
+		fmt.Printf("orders-worker: %v\n", err)
+	}
+	for shop, r := range b.replays {
+		replaying, err := shopify.ObserveEvents(ctx, ddb, shop, *r)
+		if err != nil {
+			fmt.Printf("orders-worker: replay tracking shop=%s: %v\n", shop, err)
+		}
+		if replaying {
+			ops.Events(ctx, ddb, ops.Ingestion, "shopify-replay", r.Events, 0)
+		}
+	}
+}
+
+func processOneOrder(ctx context.Context, ddb *dynamodb.Client, txTable string, body string, b *batch) error {
 	var e EBEvent
 	if err := json.Unmarshal([]byte(body), &e); err != nil {
 		return fmt.Errorf("unmarshal eb event: %w", err)
@@ -89,6 +184,10 @@ func processOneOrder(ctx context.Context, ddb *dynamodb.Client, txTable string,
 	tm := parseShopifyTime(createdAt)
 	month := tm.Format("2006-01")
 
+	// UpdatedAt drives the conditional write below.
+	updatedAt, versioned := orderVersion(order)
+	cond, condValues := orderPutCondition(updatedAt, versioned)
+
 	name := pickString(order, "name")
 	if name == "" {
 		name = fmt.Sprintf("Order %s", orderID)
@@ -99,14 +198,14 @@ func processOneOrder(ctx context.Context, ddb *dynamodb.Client, txTable string,
 		return fmt.Errorf("usersForShop: %w", err)
 	}
 	if len(subs) == 0 {
-		// No users mapped; success (nothing to do)
-		return nil
+		// No users mapped (yet): park the event instead of dropping it.
+		b.quarantined[webhookID] = true
+		return quarantine(ctx, body, shopify.QuarantineOrders, shopDomain)
 	}
 
-	// UpdateLastEvent (non-fatal)
-	nowISO := time.Now().UTC().Format(time.RFC3339)
+	// UpdateLastEvent (non-fatal, written with the batch)
 	for _, sub := range subs {
-		_ = shopify.UpdateLastEvent(ctx, ddb, sub, shopDomain, nowISO, topic, webhookID)
+		b.lastEvents[[2]string{sub, shopDomain}] = lastEvent{topic, webhookID}
 	}
 
 	// Upsert per user
@@ -130,18 +229,105 @@ func processOneOrder(ctx context.Context, ddb *dynamodb.Client, txTable string,
 			"OrderId":   &types.AttributeValueMemberS{Value: orderID},
 			"OrderName": &types.AttributeValueMemberS{Value: name},
 		}
+		if versioned {
+			item["UpdatedAt"] = &types.AttributeValueMemberS{Value: updatedAt}
+		}
+		addDiscounts(item, order)
+		addTags(item, order)
+		shopify.SetLineItems(item, shopify.LineItemsFromWebhook(order))
+		restate.Stamp(item)
 
-		if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
-			TableName: aws.String(txTable),
-			Item:      item,
-		}); err != nil {
+		// Only write when this webhook is newer than what we already stored, so an
+		// out-of-order orders/updated delivery cannot clobber a newer total.
+		out, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                 aws.String(txTable),
+			Item:                      item,
+			ConditionExpression:       aws.String(cond),
+			ExpressionAttributeValues: condValues,
+			ReturnValues:              types.ReturnValueAllOld,
+		})
+		if err != nil {
+			var cfe *types.ConditionalCheckFailedException
+			if errors.As(err, &cfe) {
+				if !versioned {
+					fmt.Printf("orders-worker: skip order=%s shop=%s without updated_at, already stored\n", orderID, shopDomain)
+					continue
+				}
+				// Stale event; stored item is already as new or newer
+				fmt.Printf("orders-worker: skip stale order=%s shop=%s updatedAt=%s\n", orderID, shopDomain, updatedAt)
+				continue
+			}
 			return fmt.Errorf("ddb put order tx: %w", err)
 		}
+		if err := restate.Record(ctx, ddb, txTable, out.Attributes, item); err != nil {
+			fmt.Printf("orders-worker: record restatement order=%s: %v\n", orderID, err)
+		}
+		if err := feerules.Apply(ctx, ddb, txTable, sub, item, len(out.Attributes) > 0); err != nil {
+			fmt.Printf("orders-worker: fee rules order=%s: %v\n", orderID, err)
+		}
+
+		// Live today/MTD counters: count the order once, then only the change in total.
+		delta := live.Delta{Shop: shopDomain, Currency: currency, At: tm, Gross: amount, Orders: 1}
+		if prev, ok := out.Attributes["Amount"].(*types.AttributeValueMemberN); ok {
+			old, _ := strconv.ParseFloat(prev.Value, 64)
+			delta.Gross = amount - old
+			delta.Orders = 0
+		}
+		b.live.Add(sub, delta)
+		b.invalidate[[2]string{sub, shopDomain}] = true
+	}
+
+	// Feed the gap detector only once the order is stored for every user.
+	if num, ok := pickAny(order, "order_number").(float64); ok && num > 0 {
+		created := parseShopifyTime(pickString(order, "created_at", "processed_at"))
+		if err := shopify.RecordOrderNumber(ctx, ddb, shopDomain, int64(num), created); err != nil {
+			fmt.Printf("orders-worker: record order number shop=%s number=%.0f: %v\n", shopDomain, num, err)
+		}
 	}
 
 	return nil
 }
 
+// addDiscounts records what the order saved through discounts, which the
+// promo detector uses to spot sale days.
+func addDiscounts(item map[string]types.AttributeValue, order map[string]any) {
+	if s := pickString(order, "current_total_discounts", "total_discounts"); s != "" {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && f > 0 {
+			item["Discount"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", f)}
+		}
+	}
+	var codes []string
+	seen := map[string]bool{}
+	if dc, ok := pickAny(order, "discount_codes").([]any); ok {
+		for _, d := range dc {
+			m, _ := d.(map[string]any)
+			if c := strings.TrimSpace(pickString(m, "code")); c != "" && !seen[c] {
+				seen[c] = true
+				codes = append(codes, c)
+			}
+		}
+	}
+	if len(codes) > 0 {
+		item["DiscountCodes"] = &types.AttributeValueMemberSS{Value: codes}
+	}
+}
+
+// addTags records the order's tags, a comma-separated string in webhooks,
+// which fee rules can match on.
+func addTags(item map[string]types.AttributeValue, order map[string]any) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(pickString(order, "tags"), ",") {
+		if t = strings.TrimSpace(t); t != "" && !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) > 0 {
+		item["OrderTags"] = &types.AttributeValueMemberSS{Value: tags}
+	}
+}
+
 func extractOrderTotal(order map[string]any) (amount float64, currency string, err error) {
 	// 1) current_total_price (string)
 	if s, ok := pickAny(order, "current_total_price").(string); ok && s != "" {
@@ -186,6 +372,28 @@ func extractOrderTotal(order map[string]any) (amount float64, currency string, e
 	return 0, "", fmt.Errorf("no total price field found")
 }
 
+// orderVersion is the order's updated_at in UTC, so stored versions compare
+// lexicographically in DynamoDB; false when the payload has none that parses.
+func orderVersion(order map[string]any) (string, bool) {
+	t, err := time.Parse(time.RFC3339, pickString(order, "updated_at"))
+	if err != nil {
+		return "", false
+	}
+	return t.UTC().Format(time.RFC3339), true
+}
+
+// orderPutCondition guards the order write: a versioned payload replaces only
+// an older (or unversioned) stored order, so a redelivery of the same version
+// is skipped too; one without a version can't be ordered against what is
+// stored and only creates the order.
+func orderPutCondition(updatedAt string, versioned bool) (string, map[string]types.AttributeValue) {
+	if !versioned {
+		return "attribute_not_exists(PK)", nil
+	}
+	return "attribute_not_exists(PK) OR attribute_not_exists(UpdatedAt) OR UpdatedAt < :u",
+		map[string]types.AttributeValue{":u": &types.AttributeValueMemberS{Value: updatedAt}}
+}
+
 func parseShopifyTime(s string) time.Time {
 	if s == "" {
 		return time.Now().UTC()
@@ -200,6 +408,24 @@ func parseShopifyTime(s string) time.Time {
 	return time.Now().UTC()
 }
 
+// eventMeta is the webhook metadata of an event: the shop it came from (""
+// when it can't be parsed), its id, topic and API version, and when Shopify
+// triggered it.
+func eventMeta(body string) shopify.WebhookLogEntry {
+	var e EBEvent
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return shopify.WebhookLogEntry{TriggeredAt: time.Now().UTC()}
+	}
+	meta := asMap(pickAny(e.Detail, "metadata"))
+	return shopify.WebhookLogEntry{
+		Shop:        pickString(meta, "X-Shopify-Shop-Domain"),
+		WebhookID:   pickString(meta, "X-Shopify-Webhook-Id"),
+		Topic:       pickString(meta, "X-Shopify-Topic"),
+		APIVersion:  pickString(meta, "X-Shopify-API-Version"),
+		TriggeredAt: shopify.EventTime(pickString(meta, "X-Shopify-Triggered-At"), e.Time, time.Now()),
+	}
+}
+
 func pickString(m map[string]any, keys ...string) string {
 	for _, k := range keys {
 		if v, ok := m[k]; ok {
@@ -230,4 +456,17 @@ func asMap(v any) map[string]any {
 	return map[string]any{}
 }
 
+func quarantine(ctx context.Context, body, target, shopDomain string) error {
+	err := shopify.Quarantine(ctx, body, target, shopDomain)
+	if errors.Is(err, shopify.ErrQuarantineDisabled) {
+		fmt.Printf("orders-worker: no users for shop=%s, dropping (quarantine disabled)\n", shopDomain)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("orders-worker: no users for shop=%s, quarantined\n", shopDomain)
+	return nil
+}
+
 func main() { lambda.Start(handler) }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestOrderVersion(t *testing.T) {
+	for _, tc := range []struct {
+		updatedAt any
+		want      string
+		ok        bool
+	}{
+		{"2026-01-18T10:21:02-05:00", "2026-01-18T15:21:02Z", true},
+		{"2026-01-18T15:21:02Z", "2026-01-18T15:21:02Z", true},
+		{nil, "", false},
+		{"", "", false},
+		{"yesterday", "", false},
+		{"2026-01-18 10:21:02", "", false},
+	} {
+		order := map[string]any{"id": 1.0, "created_at": "2026-01-01T00:00:00Z"}
+		if tc.updatedAt != nil {
+			order["updated_at"] = tc.updatedAt
+		}
+		got, ok := orderVersion(order)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("orderVersion(updated_at=%v) = %q, %v; want %q, %v", tc.updatedAt, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// holds evaluates the conditions orderPutCondition builds: OR-ed
+// attribute_not_exists(A) and A < :v clauses on string attributes.
+func holds(t *testing.T, cond string, values map[string]types.AttributeValue, stored map[string]string) bool {
+	t.Helper()
+	for _, clause := range strings.Split(cond, " OR ") {
+		if a, ok := strings.CutPrefix(clause, "attribute_not_exists("); ok {
+			if _, exists := stored[strings.TrimSuffix(a, ")")]; !exists {
+				return true
+			}
+			continue
+		}
+		a, v, ok := strings.Cut(clause, " < ")
+		if !ok {
+			t.Fatalf("unexpected clause %q", clause)
+		}
+		val, ok := values[v].(*types.AttributeValueMemberS)
+		if !ok {
+			t.Fatalf("no string value for %s", v)
+		}
+		if s, exists := stored[a]; exists && s < val.Value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOrderPutCondition(t *testing.T) {
+	const stored = "2026-01-18T15:21:02Z"
+	for _, tc := range []struct {
+		name      string
+		updatedAt any
+		stored    map[string]string
+		write     bool
+	}{
+		{"new order", "2026-01-18T10:00:00Z", nil, true},
+		{"newer", "2026-01-18T15:30:00Z", map[string]string{"PK": "USER#u", "UpdatedAt": stored}, true},
+		{"newer in another zone", "2026-01-18T10:30:00-05:00", map[string]string{"PK": "USER#u", "UpdatedAt": stored}, true},
+		{"stale", "2026-01-18T15:00:00Z", map[string]string{"PK": "USER#u", "UpdatedAt": stored}, false},
+		{"equal", "2026-01-18T10:21:02-05:00", map[string]string{"PK": "USER#u", "UpdatedAt": stored}, false},
+		{"stored unversioned", "2026-01-18T15:00:00Z", map[string]string{"PK": "USER#u"}, true},
+		{"missing", nil, map[string]string{"PK": "USER#u", "UpdatedAt": stored}, false},
+		{"malformed", "not a time", map[string]string{"PK": "USER#u", "UpdatedAt": stored}, false},
+		{"missing over unversioned", nil, map[string]string{"PK": "USER#u"}, false},
+		{"missing, new order", nil, nil, true},
+	} {
+		order := map[string]any{"id": 1.0}
+		if tc.updatedAt != nil {
+			order["updated_at"] = tc.updatedAt
+		}
+		cond, values := orderPutCondition(orderVersion(order))
+		if got := holds(t, cond, values, tc.stored); got != tc.write {
+			t.Errorf("%s: write = %v, want %v (condition %q)", tc.name, got, tc.write, cond)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler retries events parked by the orders/refunds workers. An event goes
+// back to its worker queue as soon as its shop has users again; until then it
+// is reported as failed, so SQS redelivers it after the queue's visibility
+// timeout. Events older than shopify.QuarantineWindow are dropped.
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return events.SQSEventResponse{}, err
+	}
+
+	failures := make([]events.SQSBatchItemFailure, 0)
+	released, dropped, errs := 0, 0, 0
+
+	for _, rec := range sqsEvent.Records {
+		target := attr(rec, "Target")
+		shop := attr(rec, "Shop")
+
+		subs, err := shopify.UsersForShop(ctx, ddb, shop)
+		if err != nil {
+			errs++
+			fmt.Printf("quarantine-worker: msgId=%s shop=%s lookup: %v\n", rec.MessageId, shop, err)
+			failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+			continue
+		}
+
+		if len(subs) > 0 {
+			if err := shopify.Release(ctx, rec.Body, target); err != nil {
+				errs++
+				fmt.Printf("quarantine-worker: msgId=%s shop=%s release: %v\n", rec.MessageId, shop, err)
+				failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+				continue
+			}
+			released++
+			continue
+		}
+
+		if age := time.Since(quarantinedAt(rec)); age > shopify.QuarantineWindow {
+			dropped++
+			fmt.Printf("quarantine-worker: msgId=%s shop=%s target=%s dropped after %s without users\n", rec.MessageId, shop, target, age.Round(time.Minute))
+			continue
+		}
+		// Still unmapped: leave it in the queue for the next attempt.
+		failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+	}
+
+	ops.Events(ctx, ddb, ops.Ingestion, "shopify-quarantine-worker", len(sqsEvent.Records), errs)
+	ops.Beat(ctx, ddb, ops.Ingestion, "shopify-quarantine-worker", ops.BatchErr(len(sqsEvent.Records), errs))
+	if released > 0 || dropped > 0 {
+		fmt.Printf("quarantine-worker: released=%d dropped=%d waiting=%d\n", released, dropped, len(failures)-errs)
+	}
+	return events.SQSEventResponse{BatchItemFailures: failures}, nil
+}
+
+func attr(rec events.SQSMessage, name string) string {
+	if a, ok := rec.MessageAttributes[name]; ok && a.StringValue != nil {
+		return *a.StringValue
+	}
+	return ""
+}
+
+func quarantinedAt(rec events.SQSMessage) time.Time {
+	if n, err := strconv.ParseInt(attr(rec, "QuarantinedAt"), 10, 64); err == nil {
+		return time.Unix(n, 0)
+	}
+	if ms, err := strconv.ParseInt(rec.Attributes["SentTimestamp"], 10, 64); err == nil {
+		return time.UnixMilli(ms)
+	}
+	return time.Now()
+}
+
+func main() { lambda.Start(handler) }
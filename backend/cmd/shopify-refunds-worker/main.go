@@ -3,12 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
 	"backend/internal/db"
+	"backend/internal/live"
+	"backend/internal/nlq"
+	"backend/internal/ops"
+	"backend/internal/restate"
 	"backend/internal/shopify"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -34,18 +38,105 @@ func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResp
 	txTable := db.TransactionsTableName()
 
 	failures := make([]events.SQSBatchItemFailure, 0)
+	b := newBatch()
 
 	for _, rec := range sqsEvent.Records {
-		if err := processOneRefund(ctx, ddb, txTable, rec.Body); err != nil {
+		entry := eventMeta(rec.Body)
+		shop := entry.Shop
+		b.observe(shop, entry.TriggeredAt)
+		err := processOneRefund(ctx, ddb, txTable, rec.Body, b)
+		b.logWebhook(entry, err)
+		if err != nil {
 			fmt.Printf("refunds-worker: msgId=%s failed: %v\n", rec.MessageId, err)
 			failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+			ops.ShopFailure(ctx, ddb, shop)
 		}
 	}
+	b.flush(ctx, ddb)
 
+	ops.Events(ctx, ddb, ops.Ingestion, "shopify-refunds-worker", len(sqsEvent.Records), len(failures))
+	ops.Beat(ctx, ddb, ops.Ingestion, "shopify-refunds-worker", ops.BatchErr(len(sqsEvent.Records), len(failures)))
 	return events.SQSEventResponse{BatchItemFailures: failures}, nil
 }
 
-func processOneRefund(ctx context.Context, ddb *dynamodb.Client, txTable string, body string) error {
+// batch holds the side effects written once per SQS batch rather than once
+// per event, as in the orders worker.
+type batch struct {
+	live       live.Batch
+	lastEvents map[[2]string]lastEvent // (sub, shop)
+	invalidate map[[2]string]bool
+	replays    map[string]*shopify.ReplayBatch
+
+	webhooks    []shopify.WebhookLogEntry
+	quarantined map[string]bool // webhook ids parked for lack of users
+}
+
+type lastEvent struct {
+	topic, webhookID string
+}
+
+func newBatch() *batch {
+	return &batch{
+		lastEvents: map[[2]string]lastEvent{},
+		invalidate: map[[2]string]bool{},
+		replays:    map[string]*shopify.ReplayBatch{},
+
+		quarantined: map[string]bool{},
+	}
+}
+
+// logWebhook records what became of one event for the webhook log.
+func (b *batch) logWebhook(e shopify.WebhookLogEntry, err error) {
+	switch {
+	case err != nil:
+		e.Status = shopify.WebhookFailed
+	case b.quarantined[e.WebhookID]:
+		e.Status = shopify.WebhookQuarantined
+	default:
+		e.Status = shopify.WebhookProcessed
+	}
+	e.ProcessedAt = time.Now().UTC().Format(time.RFC3339)
+	b.webhooks = append(b.webhooks, e)
+}
+
+func (b *batch) observe(shop string, at time.Time) {
+	if shop == "" {
+		return
+	}
+	if b.replays[shop] == nil {
+		b.replays[shop] = &shopify.ReplayBatch{}
+	}
+	b.replays[shop].Add(at, false, true)
+}
+
+func (b *batch) flush(ctx context.Context, ddb *dynamodb.Client) {
+	nowISO := time.Now().UTC().Format(time.RFC3339)
+	for k, e := range b.lastEvents {
+		_ = shopify.UpdateLastEvent(ctx, ddb, k[0], k[1], nowISO, e.topic, e.webhookID)
+	}
+	b.live.Flush(ctx, ddb, func(sub string, d live.Delta, err error) {
+		fmt.Printf("refunds-worker: live aggregates user=%s shop=%s: %v\n", sub, d.Shop, err)
+	})
+	for k := range b.invalidate {
+		if _, err := nlq.InvalidateCachedForShop(ctx, ddb, k[0], k[1]); err != nil {
+			fmt.Printf("refunds-worker: nlq cache invalidation user=%s shop=%s: %v\n", k[0], k[1], err)
+		}
+	}
+	if err := shopify.LogWebhooks(ctx, ddb, b.webhooks); err != nil {
+		fmt.Printf("refunds-worker: %v\n", err)
+	}
+	for shop, r := range b.replays {
+		replaying, err := shopify.ObserveEvents(ctx, ddb, shop, *r)
+		if err != nil {
+			fmt.Printf("refunds-worker: replay tracking shop=%s: %v\n", shop, err)
+		}
+		if replaying {
+			ops.Events(ctx, ddb, ops.Ingestion, "shopify-replay", r.Events, 0)
+		}
+	}
+}
+
+func processOneRefund(ctx context.Context, ddb *dynamodb.Client, txTable string, body string, b *batch) error {
 	var e EBEvent
 	if err := json.Unmarshal([]byte(body), &e); err != nil {
 		return fmt.Errorf("unmarshal eb event: %w", err)
@@ -73,7 +164,7 @@ func processOneRefund(ctx context.Context, ddb *dynamodb.Client, txTable string,
 		return fmt.Errorf("missing refund id")
 	}
 
-	amount, ok := findRefundAmount(refund)
+	amount, ok := shopify.RefundAmount(refund)
 	if !ok {
 		return fmt.Errorf("cannot determine refund amount")
 	}
@@ -92,12 +183,13 @@ func processOneRefund(ctx context.Context, ddb *dynamodb.Client, txTable string,
 		return fmt.Errorf("usersForShop: %w", err)
 	}
 	if len(subs) == 0 {
-		return nil
+		// No users mapped (yet): park the event instead of dropping it.
+		b.quarantined[webhookID] = true
+		return quarantine(ctx, body, shopify.QuarantineRefunds, shopDomain)
 	}
 
-	nowISO := time.Now().UTC().Format(time.RFC3339)
 	for _, sub := range subs {
-		_ = shopify.UpdateLastEvent(ctx, ddb, sub, shopDomain, nowISO, topic, webhookID)
+		b.lastEvents[[2]string{sub, shopDomain}] = lastEvent{topic, webhookID}
 	}
 
 	for _, sub := range subs {
@@ -119,6 +211,12 @@ func processOneRefund(ctx context.Context, ddb *dynamodb.Client, txTable string,
 			"Topic":     &types.AttributeValueMemberS{Value: topic},
 			"RefundId":  &types.AttributeValueMemberS{Value: refundID},
 		}
+		// formatted like the order worker's ids, so the order's SK can be rebuilt
+		if orderID := fmt.Sprintf("%v", pickAny(refund, "order_id")); orderID != "" && orderID != "<nil>" {
+			item["OrderId"] = &types.AttributeValueMemberS{Value: orderID}
+		}
+		shopify.SetLineItems(item, shopify.RefundLineItemsFromWebhook(refund))
+		restate.Stamp(item)
 
 		_, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
 			TableName:           aws.String(txTable),
@@ -130,65 +228,14 @@ func processOneRefund(ctx context.Context, ddb *dynamodb.Client, txTable string,
 			if !strings.Contains(err.Error(), "ConditionalCheckFailedException") {
 				return fmt.Errorf("ddb put refund tx: %w", err)
 			}
+			continue
 		}
-	}
 
-	return nil
-}
-
-func findRefundAmount(refund map[string]any) (float64, bool) {
-	if txs, ok := pickAny(refund, "transactions").([]any); ok && len(txs) > 0 {
-		sum := 0.0
-		found := false
-		for _, t := range txs {
-			m, ok := t.(map[string]any)
-			if !ok {
-				continue
-			}
-			kind := strings.ToLower(fmt.Sprintf("%v", pickAny(m, "kind")))
-			status := strings.ToLower(fmt.Sprintf("%v", pickAny(m, "status")))
-
-			if kind != "" && kind != "refund" {
-				continue
-			}
-			if status != "" && status != "success" {
-				continue
-			}
-			if f, ok := parseFloatAny(pickAny(m, "amount")); ok {
-				sum += f
-				found = true
-			}
-		}
-		if found {
-			return sum, true
-		}
+		b.live.Add(sub, live.Delta{Shop: shopDomain, Currency: currency, At: tm, Refunds: amount})
+		b.invalidate[[2]string{sub, shopDomain}] = true
 	}
 
-	if f, ok := parseFloatAny(pickAny(refund, "amount")); ok {
-		return f, true
-	}
-	if f, ok := parseFloatAny(pickAny(refund, "total_refunded")); ok {
-		return f, true
-	}
-	return 0, false
-}
-
-func parseFloatAny(v any) (float64, bool) {
-	switch x := v.(type) {
-	case float64:
-		return x, true
-	case string:
-		if x == "" || x == "<nil>" {
-			return 0, false
-		}
-		f, err := strconv.ParseFloat(x, 64)
-		if err != nil {
-			return 0, false
-		}
-		return f, true
-	default:
-		return 0, false
-	}
+	return nil
 }
 
 func parseShopifyTime(s string) time.Time {
@@ -201,6 +248,24 @@ func parseShopifyTime(s string) time.Time {
 	return time.Now().UTC()
 }
 
+// eventMeta is the webhook metadata of an event: the shop it came from (""
+// when it can't be parsed), its id, topic and API version, and when Shopify
+// triggered it.
+func eventMeta(body string) shopify.WebhookLogEntry {
+	var e EBEvent
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return shopify.WebhookLogEntry{TriggeredAt: time.Now().UTC()}
+	}
+	meta := asMap(pickAny(e.Detail, "metadata"))
+	return shopify.WebhookLogEntry{
+		Shop:        pickString(meta, "X-Shopify-Shop-Domain"),
+		WebhookID:   pickString(meta, "X-Shopify-Webhook-Id"),
+		Topic:       pickString(meta, "X-Shopify-Topic"),
+		APIVersion:  pickString(meta, "X-Shopify-API-Version"),
+		TriggeredAt: shopify.EventTime(pickString(meta, "X-Shopify-Triggered-At"), e.Time, time.Now()),
+	}
+}
+
 func pickString(m map[string]any, keys ...string) string {
 	for _, k := range keys {
 		if v, ok := m[k]; ok {
@@ -231,4 +296,17 @@ func asMap(v any) map[string]any {
 	return map[string]any{}
 }
 
+func quarantine(ctx context.Context, body, target, shopDomain string) error {
+	err := shopify.Quarantine(ctx, body, target, shopDomain)
+	if errors.Is(err, shopify.ErrQuarantineDisabled) {
+		fmt.Printf("refunds-worker: no users for shop=%s, dropping (quarantine disabled)\n", shopDomain)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("refunds-worker: no users for shop=%s, quarantined\n", shopDomain)
+	return nil
+}
+
 func main() { lambda.Start(handler) }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/notify"
+	"backend/internal/ops"
+	"backend/internal/shopify"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// handler closes the Shopify replays that have gone quiet and sends each user
+// of the shop one "catch-up completed" summary in place of the per-event
+// alerts the emailer held back.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	snsClient := sns.NewFromConfig(awsCfg)
+
+	replays, err := shopify.OpenReplays(ctx, ddb)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Ingestion, "shopify-replay-monitor", err)
+		return err
+	}
+
+	now := time.Now().UTC()
+	closed, failed := 0, 0
+	for _, r := range replays {
+		if !r.Quiet(now) {
+			fmt.Printf("shopify-replay-monitor: shop=%s still replaying, events=%d since=%s\n", r.Shop, r.Events, r.StartedAt)
+			continue
+		}
+		ok, err := shopify.CloseReplay(ctx, ddb, r)
+		if err != nil {
+			failed++
+			fmt.Printf("shopify-replay-monitor: shop=%s close: %v\n", r.Shop, err)
+			continue
+		}
+		if !ok {
+			// Events arrived since we read it; next run.
+			continue
+		}
+		closed++
+		fmt.Printf("shopify-replay-monitor: shop=%s replay done events=%d orders=%d refunds=%d suppressed=%d backlogSince=%s\n",
+			r.Shop, r.Events, r.Orders, r.Refunds, r.Suppressed, r.BacklogSince)
+		if err := notifyUsers(ctx, ddb, snsClient, r); err != nil {
+			failed++
+			fmt.Printf("shopify-replay-monitor: shop=%s notify: %v\n", r.Shop, err)
+		}
+	}
+
+	ops.Beat(ctx, ddb, ops.Ingestion, "shopify-replay-monitor", ops.BatchErr(closed+failed, failed))
+	return nil
+}
+
+func notifyUsers(ctx context.Context, ddb *dynamodb.Client, snsClient *sns.Client, r shopify.Replay) error {
+	subs, err := shopify.UsersForShop(ctx, ddb, r.Shop)
+	if err != nil {
+		return fmt.Errorf("usersForShop: %w", err)
+	}
+	m := catchUpMessage(r)
+	var firstErr error
+	for _, sub := range subs {
+		topicArn, err := users.GetAlertsTopicArn(ctx, ddb, sub)
+		if err != nil || strings.TrimSpace(topicArn) == "" {
+			continue
+		}
+		_, err = snsClient.Publish(ctx, &sns.PublishInput{
+			TopicArn: aws.String(topicArn),
+			Subject:  aws.String(m.Subject()),
+			Message:  aws.String(m.Body()),
+		})
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("publish user=%s: %w", sub, err)
+		}
+	}
+	return firstErr
+}
+
+func catchUpMessage(r shopify.Replay) *notify.Message {
+	m := notify.New(fmt.Sprintf("TrueProfit: Shopify catch-up completed (%s)", r.Shop)).
+		Line("Shopify delivered a backlog of events for your shop and TrueProfit has processed all of it.").
+		Line("").
+		Field("Shop", r.Shop).
+		Field("Events", r.Events).
+		Field("Orders", r.Orders).
+		Field("Refunds", r.Refunds).
+		Field("BacklogSince", r.BacklogSince).
+		Field("StartedAt", r.StartedAt).
+		Field("FinishedAt", r.LastEventAt)
+	if r.Suppressed > 0 {
+		m.Line("").
+			Line(fmt.Sprintf("%d individual event alerts were held back during the catch-up; this summary replaces them.", r.Suppressed))
+	}
+	return m
+}
+
+func main() { lambda.Start(handler) }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// handler subscribes a newly connected shop to the EventBridge webhook
+// topics, one shop per message. A partial failure re-enqueues the job with a
+// growing delay until MaxWebhookSubscribeAttempts, then records "failed".
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return events.SQSEventResponse{}, err
+	}
+
+	failures := make([]events.SQSBatchItemFailure, 0)
+	for _, rec := range sqsEvent.Records {
+		var job shopify.WebhookSubscribeJob
+		if err := json.Unmarshal([]byte(rec.Body), &job); err != nil || job.Sub == "" || job.Shop == "" {
+			fmt.Printf("webhook-subscriber: msgId=%s invalid job: %s\n", rec.MessageId, rec.Body)
+			continue
+		}
+		if job.Attempt < 1 {
+			job.Attempt = 1
+		}
+		if err := run(ctx, ddb, job); err != nil {
+			fmt.Printf("webhook-subscriber: shop=%s user=%s failed: %v\n", job.Shop, job.Sub, err)
+			failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+		}
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "shopify-webhook-subscriber", ops.BatchErr(len(sqsEvent.Records), len(failures)))
+	return events.SQSEventResponse{BatchItemFailures: failures}, nil
+}
+
+// run returns an error only for infrastructure problems worth an SQS retry;
+// Shopify-side failures are retried by re-enqueueing with a delay.
+func run(ctx context.Context, ddb *dynamodb.Client, job shopify.WebhookSubscribeJob) error {
+	accessToken, _, err := shopify.LoadIntegrationAndDecryptToken(ctx, job.Sub, job.Shop)
+	if err != nil {
+		// Disconnected before the job ran, or the token is unusable.
+		fmt.Printf("webhook-subscriber: shop=%s user=%s skipped: %v\n", job.Shop, job.Sub, err)
+		return nil
+	}
+
+	eventSourceArn := strings.TrimSpace(os.Getenv("SHOPIFY_EVENTBRIDGE_SOURCE_ARN"))
+	apiVersion := strings.TrimSpace(os.Getenv("SHOPIFY_API_VERSION"))
+	if apiVersion == "" {
+		apiVersion = "2026-01"
+	}
+
+	topics := shopify.SubscribeEventBridgeTopics(ctx, job.Shop, apiVersion, accessToken, eventSourceArn)
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i := range topics {
+		topics[i].Attempts = job.Attempt
+		topics[i].UpdatedAt = now
+	}
+	retry := job.Attempt < shopify.MaxWebhookSubscribeAttempts
+	overall := shopify.OverallWebhookStatus(topics, retry)
+
+	if err := shopify.RecordWebhookStatus(ctx, ddb, job.Sub, job.Shop, overall, topics); err != nil {
+		if errors.Is(err, shopify.ErrIntegrationGone) {
+			return nil
+		}
+		return err
+	}
+	fmt.Printf("webhook-subscriber: shop=%s user=%s attempt=%d status=%s\n", job.Shop, job.Sub, job.Attempt, overall)
+
+	if overall != shopify.WebhooksRetrying {
+		return nil
+	}
+	next := job
+	next.Attempt++
+	return shopify.SendWebhookSubscribe(ctx, next, shopify.WebhookRetryDelay(job.Attempt))
+}
+
+func main() { lambda.Start(handler) }
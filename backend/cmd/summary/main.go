@@ -7,5 +7,5 @@ import (
 )
 
 func main() {
-	lambda.Start(handlers.SummaryMonthly)
+	lambda.Start(handlers.SummaryHandler)
 }
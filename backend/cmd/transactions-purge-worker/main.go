@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/purge"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// stopBefore leaves time to re-enqueue before the Lambda deadline.
+const stopBefore = 60 * time.Second
+
+const pageSize = 200
+
+// handler runs the purges queued by DELETE /transactions?source=, one job
+// per message.
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return events.SQSEventResponse{}, err
+	}
+
+	failures := make([]events.SQSBatchItemFailure, 0)
+	for _, rec := range sqsEvent.Records {
+		var job purge.Job
+		if err := json.Unmarshal([]byte(rec.Body), &job); err != nil || job.Sub == "" || job.Source == "" {
+			fmt.Printf("purge: msgId=%s invalid job: %s\n", rec.MessageId, rec.Body)
+			continue
+		}
+		if err := run(ctx, ddb, job); err != nil {
+			fmt.Printf("purge: user=%s source=%s shop=%s failed: %v\n", job.Sub, job.Source, job.Shop, err)
+			failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+		}
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "transactions-purge", ops.BatchErr(len(sqsEvent.Records), len(failures)))
+	return events.SQSEventResponse{BatchItemFailures: failures}, nil
+}
+
+// run deletes pages until the job is done or time runs out. Deletes are
+// idempotent, so a retried message just redoes its current page.
+func run(ctx context.Context, ddb *dynamodb.Client, job purge.Job) error {
+	table := db.TransactionsTableName()
+	deadline, hasDeadline := ctx.Deadline()
+	for {
+		if hasDeadline && time.Until(deadline) < stopBefore {
+			fmt.Printf("purge: user=%s source=%s shop=%s continuing after %s deleted=%d\n", job.Sub, job.Source, job.Shop, job.StartAfter, job.Deleted)
+			return purge.Send(ctx, job)
+		}
+		next, n, locked, err := purge.Page(ctx, ddb, table, job, pageSize)
+		if err != nil {
+			return err
+		}
+		job.Deleted += n
+		job.Locked += locked
+		if next == "" {
+			fmt.Printf("purge: user=%s source=%s shop=%s done deleted=%d locked=%d requestedAt=%s\n", job.Sub, job.Source, job.Shop, job.Deleted, job.Locked, job.RequestedAt)
+			return nil
+		}
+		job.StartAfter = next
+	}
+}
+
+func main() { lambda.Start(handler) }
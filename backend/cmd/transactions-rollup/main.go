@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/rollup"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler folds transactions table stream records into the rollup table.
+// Records are applied in order and the first failure stops the batch: the
+// stream is retried from that record, so nothing after it may be applied
+// twice.
+func handler(ctx context.Context, e events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return events.DynamoDBEventResponse{}, err
+	}
+
+	var resp events.DynamoDBEventResponse
+	failed := 0
+	for i, rec := range e.Records {
+		err := rollup.Apply(ctx, ddb, rec.EventID,
+			rollup.FromStreamImage(rec.Change.OldImage),
+			rollup.FromStreamImage(rec.Change.NewImage))
+		if err != nil {
+			fmt.Printf("transactions-rollup: eventId=%s seq=%s failed: %v\n", rec.EventID, rec.Change.SequenceNumber, err)
+			resp.BatchItemFailures = []events.DynamoDBBatchItemFailure{{ItemIdentifier: rec.Change.SequenceNumber}}
+			failed = len(e.Records) - i
+			break
+		}
+	}
+
+	ops.Beat(ctx, ddb, ops.Ingestion, "transactions-rollup", ops.BatchErr(len(e.Records), failed))
+	return resp, nil
+}
+
+func main() { lambda.Start(handler) }
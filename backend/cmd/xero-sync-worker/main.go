@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+	"backend/internal/xero"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler mirrors the current month into each connected Xero organisation,
+// plus the previous month during the first days of a month so late edits
+// still land. A failing user never blocks the rest.
+func handler(ctx context.Context) error {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	integs, err := xero.ListIntegrations(ctx, ddb)
+	if err != nil {
+		ops.Beat(ctx, ddb, ops.Sync, "xero-sync-worker", err)
+		return fmt.Errorf("list xero integrations: %w", err)
+	}
+
+	now := time.Now().UTC()
+	months := []string{now.Format("2006-01")}
+	if now.Day() <= 5 {
+		months = append([]string{now.AddDate(0, 0, -now.Day()).Format("2006-01")}, months...)
+	}
+
+	synced, failed := 0, 0
+	for i := range integs {
+		it := &integs[i]
+		for _, m := range months {
+			res, err := xero.SyncMonth(ctx, ddb, it, m)
+			if err != nil {
+				failed++
+				fmt.Printf("xero-sync-worker: user=%s month=%s failed: %v\n", it.UserSub(), m, err)
+				break
+			}
+			synced++
+			fmt.Printf("xero-sync-worker: user=%s month=%s created=%d updated=%d unchanged=%d skipped=%d conflicts=%d errors=%d\n",
+				it.UserSub(), m, res.Created, res.Updated, res.Unchanged, res.Skipped, res.Conflicts, len(res.Errors))
+		}
+	}
+
+	ops.Beat(ctx, ddb, ops.Sync, "xero-sync-worker", ops.BatchErr(synced+failed, failed))
+	fmt.Printf("xero-sync-worker: done synced=%d failed=%d\n", synced, failed)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
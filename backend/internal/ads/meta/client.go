@@ -0,0 +1,183 @@
+package meta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const graphBase = "https://graph.facebook.com"
+
+func GraphVersion() string {
+	v := strings.TrimSpace(os.Getenv("META_GRAPH_VERSION"))
+	if v == "" {
+		v = "v19.0"
+	}
+	return v
+}
+
+// AuthorizeURL builds the Facebook Login dialog URL for the ads_read scope.
+func AuthorizeURL(state, redirectURI string) string {
+	q := url.Values{}
+	q.Set("client_id", os.Getenv("META_APP_ID"))
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	q.Set("scope", "ads_read")
+	q.Set("response_type", "code")
+	return fmt.Sprintf("https://www.facebook.com/%s/dialog/oauth?%s", GraphVersion(), q.Encode())
+}
+
+type tokenResp struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// ExchangeCode trades the login code for a long-lived (~60 day) user token.
+func ExchangeCode(ctx context.Context, code, redirectURI string) (string, int64, error) {
+	q := url.Values{}
+	q.Set("client_id", os.Getenv("META_APP_ID"))
+	q.Set("client_secret", os.Getenv("META_APP_SECRET"))
+	q.Set("redirect_uri", redirectURI)
+	q.Set("code", code)
+
+	var short tokenResp
+	if err := getGraph(ctx, "/oauth/access_token", q, &short); err != nil {
+		return "", 0, err
+	}
+	if short.AccessToken == "" {
+		return "", 0, fmt.Errorf("meta: no access_token in response")
+	}
+
+	q = url.Values{}
+	q.Set("grant_type", "fb_exchange_token")
+	q.Set("client_id", os.Getenv("META_APP_ID"))
+	q.Set("client_secret", os.Getenv("META_APP_SECRET"))
+	q.Set("fb_exchange_token", short.AccessToken)
+
+	var long tokenResp
+	if err := getGraph(ctx, "/oauth/access_token", q, &long); err != nil {
+		return "", 0, err
+	}
+	if long.AccessToken == "" {
+		return "", 0, fmt.Errorf("meta: no long-lived access_token in response")
+	}
+	return long.AccessToken, long.ExpiresIn, nil
+}
+
+type AdAccount struct {
+	ID       string `json:"id"` // act_<n>
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Timezone string `json:"timezone_name"`
+}
+
+// ListAdAccounts returns the ad accounts the token can read.
+func ListAdAccounts(ctx context.Context, accessToken string) ([]AdAccount, error) {
+	q := url.Values{}
+	q.Set("access_token", accessToken)
+	q.Set("fields", "id,name,currency,timezone_name")
+	q.Set("limit", "100")
+
+	var all []AdAccount
+	path := "/me/adaccounts"
+	for {
+		var page struct {
+			Data   []AdAccount `json:"data"`
+			Paging struct {
+				Cursors struct {
+					After string `json:"after"`
+				} `json:"cursors"`
+				Next string `json:"next"`
+			} `json:"paging"`
+		}
+		if err := getGraph(ctx, path, q, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		if page.Paging.Next == "" || page.Paging.Cursors.After == "" {
+			break
+		}
+		q.Set("after", page.Paging.Cursors.After)
+	}
+	return all, nil
+}
+
+type DaySpend struct {
+	Date     string  // YYYY-MM-DD in the ad account's timezone
+	Spend    float64 // in the ad account currency
+	Currency string
+}
+
+// DailySpend pulls account-level spend per day for [since, until] (inclusive, YYYY-MM-DD).
+func DailySpend(ctx context.Context, accessToken, adAccountID, since, until string) ([]DaySpend, error) {
+	tr, _ := json.Marshal(map[string]string{"since": since, "until": until})
+
+	q := url.Values{}
+	q.Set("access_token", accessToken)
+	q.Set("level", "account")
+	q.Set("time_increment", "1")
+	q.Set("fields", "spend,account_currency")
+	q.Set("time_range", string(tr))
+	q.Set("limit", "100")
+
+	var out []DaySpend
+	path := "/" + adAccountID + "/insights"
+	for {
+		var page struct {
+			Data []struct {
+				DateStart       string      `json:"date_start"`
+				Spend           json.Number `json:"spend"`
+				AccountCurrency string      `json:"account_currency"`
+			} `json:"data"`
+			Paging struct {
+				Cursors struct {
+					After string `json:"after"`
+				} `json:"cursors"`
+				Next string `json:"next"`
+			} `json:"paging"`
+		}
+		if err := getGraph(ctx, path, q, &page); err != nil {
+			return nil, err
+		}
+		for _, d := range page.Data {
+			spend, err := d.Spend.Float64()
+			if err != nil {
+				continue
+			}
+			out = append(out, DaySpend{Date: d.DateStart, Spend: spend, Currency: d.AccountCurrency})
+		}
+		if page.Paging.Next == "" || page.Paging.Cursors.After == "" {
+			break
+		}
+		q.Set("after", page.Paging.Cursors.After)
+	}
+	return out, nil
+}
+
+func getGraph(ctx context.Context, path string, query url.Values, out any) error {
+	u := fmt.Sprintf("%s/%s%s?%s", graphBase, GraphVersion(), path, query.Encode())
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	req.Header.Set("accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("meta graph %s: http %d: %s", path, res.StatusCode, string(raw))
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("meta graph %s unmarshal: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package meta
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/restate"
+	"backend/internal/security"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	Source   = "meta"
+	Category = "Marketing Costs"
+)
+
+// Integration mirrors a connected ad account in INTEGRATIONS_TABLE.
+// PK = USER#<sub>
+// SK = META#<act_id>
+//
+// Shop is the store the spend is attributed to in daily_metrics; empty means
+// the spend only shows up in the user's monthly summary.
+type Integration struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	AdAccountId    string `dynamodbav:"AdAccountId"`
+	AccountName    string `dynamodbav:"AccountName"`
+	Currency       string `dynamodbav:"Currency"`
+	Shop           string `dynamodbav:"Shop,omitempty"`
+	AccessTokenEnc string `dynamodbav:"AccessTokenEnc"`
+	TokenExpiresAt string `dynamodbav:"TokenExpiresAt,omitempty"`
+	CreatedAt      string `dynamodbav:"CreatedAt"`
+	LastSyncAt     string `dynamodbav:"LastSyncAt,omitempty"`
+}
+
+func (i Integration) UserSub() string {
+	return strings.TrimPrefix(i.PK, "USER#")
+}
+
+type SyncResult struct {
+	AdAccountId string  `json:"adAccountId"`
+	Days        int     `json:"days"`
+	Spend       float64 `json:"spend"`
+	Currency    string  `json:"currency"`
+	LastSyncAt  string  `json:"lastSyncAt"`
+}
+
+// ListIntegrations returns Meta ad account integrations for one user, or for every user when sub is empty.
+func ListIntegrations(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	var items []map[string]types.AttributeValue
+	var startKey map[string]types.AttributeValue
+	for {
+		var (
+			page    []map[string]types.AttributeValue
+			lastKey map[string]types.AttributeValue
+		)
+		if sub != "" {
+			out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(tbl),
+				KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+					":p":  &types.AttributeValueMemberS{Value: "META#"},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			page, lastKey = out.Items, out.LastEvaluatedKey
+		} else {
+			out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+				TableName:        aws.String(tbl),
+				FilterExpression: aws.String("begins_with(SK, :p)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":p": &types.AttributeValueMemberS{Value: "META#"},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			page, lastKey = out.Items, out.LastEvaluatedKey
+		}
+
+		items = append(items, page...)
+		if len(lastKey) == 0 {
+			break
+		}
+		startKey = lastKey
+	}
+
+	var out []Integration
+	if err := attributevalue.UnmarshalListOfMaps(items, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveIntegration upserts the ad account item, keeping LastSyncAt if it already exists.
+func SaveIntegration(ctx context.Context, ddb *dynamodb.Client, sub string, acct AdAccount, shop, tokenEnc string, expiresIn int64) (*Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	now := time.Now().UTC()
+	integ := &Integration{
+		PK:             fmt.Sprintf("USER#%s", sub),
+		SK:             fmt.Sprintf("META#%s", acct.ID),
+		AdAccountId:    acct.ID,
+		AccountName:    acct.Name,
+		Currency:       acct.Currency,
+		Shop:           shop,
+		AccessTokenEnc: tokenEnc,
+		CreatedAt:      now.Format(time.RFC3339),
+	}
+	if expiresIn > 0 {
+		integ.TokenExpiresAt = now.Add(time.Duration(expiresIn) * time.Second).Format(time.RFC3339)
+	}
+
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: integ.PK},
+			"SK": &types.AttributeValueMemberS{Value: integ.SK},
+		},
+		UpdateExpression: aws.String("SET Provider = :p, AdAccountId = :a, AccountName = :n, Currency = :c, Shop = :s, AccessTokenEnc = :t, TokenExpiresAt = :e, CreatedAt = if_not_exists(CreatedAt, :now)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":p":   &types.AttributeValueMemberS{Value: Source},
+			":a":   &types.AttributeValueMemberS{Value: integ.AdAccountId},
+			":n":   &types.AttributeValueMemberS{Value: integ.AccountName},
+			":c":   &types.AttributeValueMemberS{Value: integ.Currency},
+			":s":   &types.AttributeValueMemberS{Value: integ.Shop},
+			":t":   &types.AttributeValueMemberS{Value: integ.AccessTokenEnc},
+			":e":   &types.AttributeValueMemberS{Value: integ.TokenExpiresAt},
+			":now": &types.AttributeValueMemberS{Value: integ.CreatedAt},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("save meta integration: %w", err)
+	}
+	return integ, nil
+}
+
+// SyncAccount pulls daily spend since LastSyncAt (or the last 30 days) and writes
+// one "Marketing Costs" transaction per day. Meta keeps revising spend for a few
+// days after the fact, so the last 3 days are always re-pulled and overwritten.
+func SyncAccount(ctx context.Context, ddb *dynamodb.Client, integ Integration) (*SyncResult, error) {
+	txTable := strings.TrimSpace(db.TransactionsTableName())
+	intTable := strings.TrimSpace(db.IntegrationsTableName())
+	if txTable == "" || intTable == "" {
+		return nil, fmt.Errorf("tables not configured")
+	}
+
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	token, err := security.DecryptAESGCM(key, integ.AccessTokenEnc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt access token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	since := now.AddDate(0, 0, -30)
+	if t, err := time.Parse(time.RFC3339, integ.LastSyncAt); err == nil {
+		since = t.AddDate(0, 0, -3)
+	}
+
+	days, err := DailySpend(ctx, token, integ.AdAccountId, since.Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SyncResult{AdAccountId: integ.AdAccountId, Currency: integ.Currency}
+	sub := integ.UserSub()
+	for _, d := range days {
+		day, err := time.Parse("2006-01-02", d.Date)
+		if err != nil {
+			continue
+		}
+		cur := d.Currency
+		if cur == "" {
+			cur = integ.Currency
+		}
+
+		item := map[string]types.AttributeValue{
+			"PK":          &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK":          &types.AttributeValueMemberS{Value: fmt.Sprintf("META#%s#SPEND#%s", integ.AdAccountId, d.Date)},
+			"GSI1PK":      &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, day.Format("2006-01"))},
+			"GSI1SK":      &types.AttributeValueMemberS{Value: day.Format(time.RFC3339Nano)},
+			"Amount":      &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", -d.Spend)},
+			"Currency":    &types.AttributeValueMemberS{Value: cur},
+			"Category":    &types.AttributeValueMemberS{Value: Category},
+			"Note":        &types.AttributeValueMemberS{Value: fmt.Sprintf("Meta Ads spend (%s)", integ.AccountName)},
+			"CreatedAt":   &types.AttributeValueMemberS{Value: day.Format(time.RFC3339)},
+			"UpdatedAt":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			"Source":      &types.AttributeValueMemberS{Value: Source},
+			"AdAccountId": &types.AttributeValueMemberS{Value: integ.AdAccountId},
+		}
+		if integ.Shop != "" {
+			item["Shop"] = &types.AttributeValueMemberS{Value: integ.Shop}
+		}
+
+		restate.Stamp(item)
+
+		// Spend for a day is a snapshot, so the latest pull always wins.
+		out, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:    aws.String(txTable),
+			Item:         item,
+			ReturnValues: types.ReturnValueAllOld,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ddb put meta spend: %w", err)
+		}
+		if err := restate.Record(ctx, ddb, txTable, out.Attributes, item); err != nil {
+			fmt.Printf("meta: record restatement %s: %v\n", d.Date, err)
+		}
+		res.Days++
+		res.Spend += d.Spend
+	}
+
+	res.LastSyncAt = now.Format(time.RFC3339)
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(intTable),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: integ.PK},
+			"SK": &types.AttributeValueMemberS{Value: integ.SK},
+		},
+		UpdateExpression: aws.String("SET LastSyncAt = :t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberS{Value: res.LastSyncAt},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update LastSyncAt: %w", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,129 @@
+package amazon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const lwaTokenURL = "https://api.amazon.com/auth/o2/token"
+
+func Endpoint() string {
+	e := strings.TrimRight(strings.TrimSpace(os.Getenv("AMAZON_SP_API_ENDPOINT")), "/")
+	if e == "" {
+		e = "https://sellingpartnerapi-na.amazon.com"
+	}
+	return e
+}
+
+func MarketplaceIDs() []string {
+	v := strings.TrimSpace(os.Getenv("AMAZON_MARKETPLACE_IDS"))
+	if v == "" {
+		return []string{"ATVPDKIKX0DEK"} // amazon.com
+	}
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+type lwaTokenResp struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// ExchangeAuthCode trades the spapi_oauth_code from the consent redirect for a refresh token.
+func ExchangeAuthCode(ctx context.Context, code, redirectURI string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", os.Getenv("AMAZON_LWA_CLIENT_ID"))
+	form.Set("client_secret", os.Getenv("AMAZON_LWA_CLIENT_SECRET"))
+
+	tok, err := postLWA(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	if tok.RefreshToken == "" {
+		return "", fmt.Errorf("lwa: no refresh_token in response")
+	}
+	return tok.RefreshToken, nil
+}
+
+// AccessToken mints a short-lived SP-API access token from a stored refresh token.
+func AccessToken(ctx context.Context, refreshToken string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	form.Set("client_id", os.Getenv("AMAZON_LWA_CLIENT_ID"))
+	form.Set("client_secret", os.Getenv("AMAZON_LWA_CLIENT_SECRET"))
+
+	tok, err := postLWA(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("lwa: no access_token in response")
+	}
+	return tok.AccessToken, nil
+}
+
+func postLWA(ctx context.Context, form url.Values) (*lwaTokenResp, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, lwaTokenURL, strings.NewReader(form.Encode()))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("lwa token failed: http %d: %s", res.StatusCode, string(raw))
+	}
+
+	var tok lwaTokenResp
+	if err := json.Unmarshal(raw, &tok); err != nil {
+		return nil, fmt.Errorf("lwa token unmarshal: %w", err)
+	}
+	return &tok, nil
+}
+
+// GetSPAPI performs a GET against the SP-API and decodes the JSON body into out.
+func GetSPAPI(ctx context.Context, accessToken, path string, query url.Values, out any) error {
+	u := Endpoint() + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	req.Header.Set("x-amz-access-token", accessToken)
+	req.Header.Set("accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("sp-api %s: http %d: %s", path, res.StatusCode, string(raw))
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("sp-api %s unmarshal: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/restate"
+	"backend/internal/security"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const Source = "amazon"
+
+// Integration mirrors the Amazon item in INTEGRATIONS_TABLE.
+// PK = USER#<sub>
+// SK = AMAZON#<sellingPartnerId>
+type Integration struct {
+	PK              string `dynamodbav:"PK"`
+	SK              string `dynamodbav:"SK"`
+	SellerId        string `dynamodbav:"SellerId"`
+	RefreshTokenEnc string `dynamodbav:"RefreshTokenEnc"`
+	CreatedAt       string `dynamodbav:"CreatedAt"`
+	LastSyncAt      string `dynamodbav:"LastSyncAt,omitempty"`
+}
+
+func (i Integration) UserSub() string {
+	return strings.TrimPrefix(i.PK, "USER#")
+}
+
+type SyncResult struct {
+	SellerId   string `json:"sellerId"`
+	Orders     int    `json:"orders"`
+	Fees       int    `json:"fees"`
+	Skipped    int    `json:"skipped"`
+	LastSyncAt string `json:"lastSyncAt"`
+}
+
+type money struct {
+	CurrencyCode string `json:"CurrencyCode"`
+	Amount       string `json:"Amount"`
+}
+
+type feeMoney struct {
+	CurrencyCode   string  `json:"CurrencyCode"`
+	CurrencyAmount float64 `json:"CurrencyAmount"`
+}
+
+type ordersResp struct {
+	Payload struct {
+		Orders []struct {
+			AmazonOrderId  string `json:"AmazonOrderId"`
+			PurchaseDate   string `json:"PurchaseDate"`
+			LastUpdateDate string `json:"LastUpdateDate"`
+			OrderStatus    string `json:"OrderStatus"`
+			OrderTotal     *money `json:"OrderTotal"`
+		} `json:"Orders"`
+		NextToken string `json:"NextToken"`
+	} `json:"payload"`
+}
+
+type financesResp struct {
+	Payload struct {
+		FinancialEvents struct {
+			ShipmentEventList []struct {
+				AmazonOrderId    string `json:"AmazonOrderId"`
+				PostedDate       string `json:"PostedDate"`
+				ShipmentItemList []struct {
+					SellerSKU   string `json:"SellerSKU"`
+					ItemFeeList []struct {
+						FeeType   string   `json:"FeeType"`
+						FeeAmount feeMoney `json:"FeeAmount"`
+					} `json:"ItemFeeList"`
+				} `json:"ShipmentItemList"`
+			} `json:"ShipmentEventList"`
+		} `json:"FinancialEvents"`
+		NextToken string `json:"NextToken"`
+	} `json:"payload"`
+}
+
+// ListIntegrations returns Amazon integrations for one user, or for every user when sub is empty.
+func ListIntegrations(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	var items []map[string]types.AttributeValue
+	var startKey map[string]types.AttributeValue
+	for {
+		var (
+			page    []map[string]types.AttributeValue
+			lastKey map[string]types.AttributeValue
+		)
+		if sub != "" {
+			out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(tbl),
+				KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+					":p":  &types.AttributeValueMemberS{Value: "AMAZON#"},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			page, lastKey = out.Items, out.LastEvaluatedKey
+		} else {
+			out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+				TableName:        aws.String(tbl),
+				FilterExpression: aws.String("begins_with(SK, :p)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":p": &types.AttributeValueMemberS{Value: "AMAZON#"},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			page, lastKey = out.Items, out.LastEvaluatedKey
+		}
+
+		items = append(items, page...)
+		if len(lastKey) == 0 {
+			break
+		}
+		startKey = lastKey
+	}
+
+	var out []Integration
+	if err := attributevalue.UnmarshalListOfMaps(items, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SyncSeller pulls orders and fee events updated since LastSyncAt (or the last
+// 30 days) and writes them as transactions for the owning user.
+func SyncSeller(ctx context.Context, ddb *dynamodb.Client, integ Integration) (*SyncResult, error) {
+	txTable := strings.TrimSpace(db.TransactionsTableName())
+	intTable := strings.TrimSpace(db.IntegrationsTableName())
+	if txTable == "" || intTable == "" {
+		return nil, fmt.Errorf("tables not configured")
+	}
+
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	refresh, err := security.DecryptAESGCM(key, integ.RefreshTokenEnc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+	accessToken, err := AccessToken(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	// SP-API rejects windows ending within the last two minutes.
+	startedAt := time.Now().UTC().Add(-5 * time.Minute)
+	since := integ.LastSyncAt
+	if since == "" {
+		since = startedAt.Add(-30 * 24 * time.Hour).Format(time.RFC3339)
+	}
+
+	res := &SyncResult{SellerId: integ.SellerId}
+	sub := integ.UserSub()
+
+	// Orders
+	q := url.Values{}
+	q.Set("MarketplaceIds", strings.Join(MarketplaceIDs(), ","))
+	q.Set("LastUpdatedAfter", since)
+	for {
+		var page ordersResp
+		if err := GetSPAPI(ctx, accessToken, "/orders/v0/orders", q, &page); err != nil {
+			return nil, err
+		}
+
+		for _, o := range page.Payload.Orders {
+			if o.OrderTotal == nil || strings.EqualFold(o.OrderStatus, "Canceled") {
+				res.Skipped++
+				continue
+			}
+			amt, err := strconv.ParseFloat(o.OrderTotal.Amount, 64)
+			if err != nil {
+				res.Skipped++
+				continue
+			}
+
+			sk := fmt.Sprintf("AMAZON#%s#ORDER#%s", integ.SellerId, o.AmazonOrderId)
+			wrote, err := putTransaction(ctx, ddb, txTable, sub, sk, txFields{
+				At:        parseTime(o.PurchaseDate),
+				Amount:    amt,
+				Currency:  o.OrderTotal.CurrencyCode,
+				Category:  "Amazon Sales",
+				Note:      fmt.Sprintf("Amazon order %s", o.AmazonOrderId),
+				UpdatedAt: parseTime(o.LastUpdateDate).Format(time.RFC3339),
+				Extra: map[string]string{
+					"SellerId": integ.SellerId,
+					"OrderId":  o.AmazonOrderId,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if wrote {
+				res.Orders++
+			} else {
+				res.Skipped++
+			}
+		}
+
+		if page.Payload.NextToken == "" {
+			break
+		}
+		q = url.Values{}
+		q.Set("MarketplaceIds", strings.Join(MarketplaceIDs(), ","))
+		q.Set("NextToken", page.Payload.NextToken)
+	}
+
+	// Fees (FBA fulfillment + referral) from shipment financial events
+	fq := url.Values{}
+	fq.Set("PostedAfter", since)
+	fq.Set("MaxResultsPerPage", "100")
+	for {
+		var page financesResp
+		if err := GetSPAPI(ctx, accessToken, "/finances/v0/financialEvents", fq, &page); err != nil {
+			return nil, err
+		}
+
+		for _, ev := range page.Payload.FinancialEvents.ShipmentEventList {
+			// Sum per fee category per order so each order yields at most one row per category.
+			sums := map[string]feeMoney{}
+			for _, item := range ev.ShipmentItemList {
+				for _, f := range item.ItemFeeList {
+					cat := feeCategory(f.FeeType)
+					cur := sums[cat]
+					cur.CurrencyCode = f.FeeAmount.CurrencyCode
+					cur.CurrencyAmount += f.FeeAmount.CurrencyAmount
+					sums[cat] = cur
+				}
+			}
+
+			for cat, m := range sums {
+				if m.CurrencyAmount == 0 {
+					continue
+				}
+				slug := strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(cat, "Amazon "), " ", "_"))
+				sk := fmt.Sprintf("AMAZON#%s#FEE#%s#%s", integ.SellerId, ev.AmazonOrderId, slug)
+
+				// Fee amounts arrive negative already; store as expense regardless of sign.
+				amt := m.CurrencyAmount
+				if amt > 0 {
+					amt = -amt
+				}
+				wrote, err := putTransaction(ctx, ddb, txTable, sub, sk, txFields{
+					At:       parseTime(ev.PostedDate),
+					Amount:   amt,
+					Currency: m.CurrencyCode,
+					Category: cat,
+					Note:     fmt.Sprintf("%s for order %s", cat, ev.AmazonOrderId),
+					Extra: map[string]string{
+						"SellerId": integ.SellerId,
+						"OrderId":  ev.AmazonOrderId,
+					},
+				})
+				if err != nil {
+					return nil, err
+				}
+				if wrote {
+					res.Fees++
+				} else {
+					res.Skipped++
+				}
+			}
+		}
+
+		if page.Payload.NextToken == "" {
+			break
+		}
+		fq = url.Values{}
+		fq.Set("NextToken", page.Payload.NextToken)
+	}
+
+	res.LastSyncAt = startedAt.Format(time.RFC3339)
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(intTable),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: integ.PK},
+			"SK": &types.AttributeValueMemberS{Value: integ.SK},
+		},
+		UpdateExpression: aws.String("SET LastSyncAt = :t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberS{Value: res.LastSyncAt},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update LastSyncAt: %w", err)
+	}
+
+	return res, nil
+}
+
+func feeCategory(feeType string) string {
+	switch {
+	case feeType == "Commission":
+		return "Amazon Referral Fees"
+	case strings.HasPrefix(feeType, "FBA"):
+		return "Amazon FBA Fees"
+	default:
+		return "Amazon Other Fees"
+	}
+}
+
+type txFields struct {
+	At        time.Time
+	Amount    float64
+	Currency  string
+	Category  string
+	Note      string
+	UpdatedAt string // when set, newer versions overwrite older ones
+	Extra     map[string]string
+}
+
+// putTransaction writes one transaction idempotently. Returns false when the
+// item already existed (or was newer).
+func putTransaction(ctx context.Context, ddb *dynamodb.Client, table, sub, sk string, f txFields) (bool, error) {
+	if f.Currency == "" {
+		f.Currency = "USD"
+	}
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+		"SK":        &types.AttributeValueMemberS{Value: sk},
+		"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, f.At.Format("2006-01"))},
+		"GSI1SK":    &types.AttributeValueMemberS{Value: f.At.Format(time.RFC3339Nano)},
+		"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", f.Amount)},
+		"Currency":  &types.AttributeValueMemberS{Value: f.Currency},
+		"Category":  &types.AttributeValueMemberS{Value: f.Category},
+		"Note":      &types.AttributeValueMemberS{Value: f.Note},
+		"CreatedAt": &types.AttributeValueMemberS{Value: f.At.Format(time.RFC3339)},
+		"Source":    &types.AttributeValueMemberS{Value: Source},
+	}
+	for k, v := range f.Extra {
+		item[k] = &types.AttributeValueMemberS{Value: v}
+	}
+
+	restate.Stamp(item)
+
+	in := &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+		ReturnValues:        types.ReturnValueAllOld,
+	}
+	if f.UpdatedAt != "" {
+		item["UpdatedAt"] = &types.AttributeValueMemberS{Value: f.UpdatedAt}
+		in.ConditionExpression = aws.String("attribute_not_exists(PK) OR attribute_not_exists(UpdatedAt) OR UpdatedAt < :u")
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: f.UpdatedAt},
+		}
+	}
+
+	out, err := ddb.PutItem(ctx, in)
+	if err != nil {
+		var cfe *types.ConditionalCheckFailedException
+		if errors.As(err, &cfe) {
+			return false, nil
+		}
+		return false, fmt.Errorf("ddb put amazon tx: %w", err)
+	}
+	if err := restate.Record(ctx, ddb, table, out.Attributes, item); err != nil {
+		fmt.Printf("amazon: record restatement %s: %v\n", sk, err)
+	}
+	return true, nil
+}
+
+func parseTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC()
+	}
+	return time.Now().UTC()
+}
@@ -0,0 +1,432 @@
+package bi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/athena"
+	athenatypes "github.com/aws/aws-sdk-go-v2/service/athena/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/glue"
+	gluetypes "github.com/aws/aws-sdk-go-v2/service/glue/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	sortKey = "BIACCESS"
+
+	// maxSessionPolicyLen is the STS limit for an inline session policy.
+	maxSessionPolicyLen = 2048
+
+	// bytesScannedCutoff caps a single BI query at 1 GiB.
+	bytesScannedCutoff = 1 << 30
+)
+
+// Access mirrors the BI access item in INTEGRATIONS_TABLE.
+// PK = USER#<sub>
+// SK = BIACCESS
+type Access struct {
+	PK            string   `dynamodbav:"PK" json:"-"`
+	SK            string   `dynamodbav:"SK" json:"-"`
+	Workgroup     string   `dynamodbav:"Workgroup" json:"workgroup"`
+	Database      string   `dynamodbav:"Database" json:"database"`
+	Table         string   `dynamodbav:"Table" json:"table"`
+	OutputS3      string   `dynamodbav:"OutputS3" json:"outputS3"`
+	Shops         []string `dynamodbav:"Shops" json:"shops"`
+	CreatedAt     string   `dynamodbav:"CreatedAt" json:"createdAt"`
+	ProvisionedAt string   `dynamodbav:"ProvisionedAt" json:"provisionedAt"`
+}
+
+// Credentials are short-lived keys for the shared BI reader role, narrowed by
+// a session policy to one user's workgroup, database and shop partitions.
+type Credentials struct {
+	AccessKeyId     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken"`
+	Expiration      string `json:"expiration"`
+}
+
+type Provisioner struct {
+	ath    *athena.Client
+	glue   *glue.Client
+	sts    *sts.Client
+	region string
+}
+
+func NewProvisioner(cfg aws.Config) *Provisioner {
+	return &Provisioner{
+		ath:    athena.NewFromConfig(cfg),
+		glue:   glue.NewFromConfig(cfg),
+		sts:    sts.NewFromConfig(cfg),
+		region: cfg.Region,
+	}
+}
+
+func (p *Provisioner) Region() string {
+	return p.region
+}
+
+// userID is a short stable id that is safe in workgroup, database and S3 names.
+func userID(sub string) string {
+	h := sha256.Sum256([]byte(sub))
+	return hex.EncodeToString(h[:6])
+}
+
+func stage() string {
+	s := strings.TrimSpace(os.Getenv("APP_STAGE"))
+	if s == "" {
+		s = "dev"
+	}
+	return s
+}
+
+func names(sub string) (workgroup, database, outputPrefix string) {
+	id := userID(sub)
+	return fmt.Sprintf("trueprofit-bi-%s-%s", stage(), id),
+		fmt.Sprintf("trueprofit_bi_%s_%s", strings.ReplaceAll(stage(), "-", "_"), id),
+		fmt.Sprintf("bi-results/%s/", id)
+}
+
+// Provision creates (or refreshes) the user's dedicated workgroup and a
+// database holding a copy of the daily_metrics table whose partition
+// projection only enumerates the given shops. Re-running it after shops are
+// connected or removed updates the projection in place.
+func (p *Provisioner) Provision(ctx context.Context, ddb *dynamodb.Client, sub string, shops []string) (*Access, error) {
+	bucket := strings.TrimSpace(os.Getenv("ANALYTICS_BUCKET"))
+	srcDB := strings.TrimSpace(os.Getenv("GLUE_DATABASE"))
+	srcTable := strings.TrimSpace(os.Getenv("DAILY_METRICS_TABLE"))
+	if bucket == "" || srcDB == "" || srcTable == "" {
+		return nil, fmt.Errorf("missing env: ANALYTICS_BUCKET/GLUE_DATABASE/DAILY_METRICS_TABLE")
+	}
+	if len(shops) == 0 {
+		return nil, fmt.Errorf("no shops to expose")
+	}
+	shops = append([]string(nil), shops...)
+	sort.Strings(shops)
+
+	wg, dbName, outPrefix := names(sub)
+	outS3 := fmt.Sprintf("s3://%s/%s", bucket, outPrefix)
+
+	if err := p.ensureWorkgroup(ctx, wg, outS3); err != nil {
+		return nil, err
+	}
+	if err := p.ensureTable(ctx, srcDB, srcTable, dbName, bucket, shops); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	acc := &Access{
+		PK:            fmt.Sprintf("USER#%s", sub),
+		SK:            sortKey,
+		Workgroup:     wg,
+		Database:      dbName,
+		Table:         srcTable,
+		OutputS3:      outS3,
+		Shops:         shops,
+		CreatedAt:     now,
+		ProvisionedAt: now,
+	}
+	if prev, err := Load(ctx, ddb, sub); err == nil && prev != nil && prev.CreatedAt != "" {
+		acc.CreatedAt = prev.CreatedAt
+	}
+
+	item, err := attributevalue.MarshalMap(acc)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(db.IntegrationsTableName()),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("store bi access: %w", err)
+	}
+	return acc, nil
+}
+
+func (p *Provisioner) ensureWorkgroup(ctx context.Context, name, outS3 string) error {
+	conf := &athenatypes.WorkGroupConfiguration{
+		EnforceWorkGroupConfiguration:   aws.Bool(true),
+		PublishCloudWatchMetricsEnabled: aws.Bool(true),
+		BytesScannedCutoffPerQuery:      aws.Int64(bytesScannedCutoff),
+		ResultConfiguration: &athenatypes.ResultConfiguration{
+			OutputLocation: aws.String(outS3),
+		},
+	}
+
+	_, err := p.ath.GetWorkGroup(ctx, &athena.GetWorkGroupInput{WorkGroup: aws.String(name)})
+	if err == nil {
+		return nil
+	}
+	var ire *athenatypes.InvalidRequestException
+	if !errors.As(err, &ire) {
+		return fmt.Errorf("athena GetWorkGroup %s: %w", name, err)
+	}
+
+	_, err = p.ath.CreateWorkGroup(ctx, &athena.CreateWorkGroupInput{
+		Name:          aws.String(name),
+		Description:   aws.String("TrueProfit BI read-only access"),
+		Configuration: conf,
+	})
+	if err != nil {
+		return fmt.Errorf("athena CreateWorkGroup %s: %w", name, err)
+	}
+	return nil
+}
+
+// ensureTable mirrors the source table's schema into the user's database and
+// replaces partition discovery with projection over the allowed shops only.
+func (p *Provisioner) ensureTable(ctx context.Context, srcDB, srcTable, dbName, bucket string, shops []string) error {
+	src, err := p.glue.GetTable(ctx, &glue.GetTableInput{
+		DatabaseName: aws.String(srcDB),
+		Name:         aws.String(srcTable),
+	})
+	if err != nil {
+		return fmt.Errorf("glue GetTable %s.%s: %w", srcDB, srcTable, err)
+	}
+
+	_, err = p.glue.CreateDatabase(ctx, &glue.CreateDatabaseInput{
+		DatabaseInput: &gluetypes.DatabaseInput{
+			Name:        aws.String(dbName),
+			Description: aws.String("TrueProfit BI read-only view of one user's shops"),
+		},
+	})
+	var exists *gluetypes.AlreadyExistsException
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("glue CreateDatabase %s: %w", dbName, err)
+	}
+
+	prefix := strings.TrimSpace(os.Getenv("DAILY_METRICS_PREFIX"))
+	if prefix == "" {
+		prefix = "daily_metrics/"
+	}
+
+	input := &gluetypes.TableInput{
+		Name:              aws.String(srcTable),
+		Description:       src.Table.Description,
+		TableType:         aws.String("EXTERNAL_TABLE"),
+		PartitionKeys:     src.Table.PartitionKeys,
+		StorageDescriptor: src.Table.StorageDescriptor,
+		Parameters: map[string]string{
+			"classification":              "parquet",
+			"EXTERNAL":                    "TRUE",
+			"projection.enabled":          "true",
+			"projection.dt.type":          "date",
+			"projection.dt.format":        "yyyy-MM-dd",
+			"projection.dt.range":         "2020-01-01,NOW",
+			"projection.dt.interval":      "1",
+			"projection.dt.interval.unit": "DAYS",
+			"projection.shop_id.type":     "enum",
+			"projection.shop_id.values":   strings.Join(shops, ","),
+			"storage.location.template":   fmt.Sprintf("s3://%s/%sdt=${dt}/shop_id=${shop_id}/", bucket, prefix),
+		},
+	}
+
+	_, err = p.glue.CreateTable(ctx, &glue.CreateTableInput{
+		DatabaseName: aws.String(dbName),
+		TableInput:   input,
+	})
+	if errors.As(err, &exists) {
+		_, err = p.glue.UpdateTable(ctx, &glue.UpdateTableInput{
+			DatabaseName: aws.String(dbName),
+			TableInput:   input,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("glue put table %s.%s: %w", dbName, srcTable, err)
+	}
+	return nil
+}
+
+// Deprovision removes the workgroup (with its query history), the database and the access item.
+func (p *Provisioner) Deprovision(ctx context.Context, ddb *dynamodb.Client, sub string) error {
+	wg, dbName, _ := names(sub)
+
+	_, err := p.ath.DeleteWorkGroup(ctx, &athena.DeleteWorkGroupInput{
+		WorkGroup:             aws.String(wg),
+		RecursiveDeleteOption: aws.Bool(true),
+	})
+	var ire *athenatypes.InvalidRequestException
+	if err != nil && !errors.As(err, &ire) {
+		return fmt.Errorf("athena DeleteWorkGroup %s: %w", wg, err)
+	}
+
+	_, err = p.glue.DeleteDatabase(ctx, &glue.DeleteDatabaseInput{Name: aws.String(dbName)})
+	var nf *gluetypes.EntityNotFoundException
+	if err != nil && !errors.As(err, &nf) {
+		return fmt.Errorf("glue DeleteDatabase %s: %w", dbName, err)
+	}
+
+	_, err = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.IntegrationsTableName()),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK": &types.AttributeValueMemberS{Value: sortKey},
+		},
+	})
+	return err
+}
+
+func Load(ctx context.Context, ddb *dynamodb.Client, sub string) (*Access, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(db.IntegrationsTableName()),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK": &types.AttributeValueMemberS{Value: sortKey},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var acc Access
+	if err := attributevalue.UnmarshalMap(out.Item, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// ErrPolicyTooLarge means the user has too many shops to express in one
+// session policy; callers should ask for a subset.
+var ErrPolicyTooLarge = errors.New("too many shops for one BI credential")
+
+// IssueCredentials assumes BI_READER_ROLE_ARN with a session policy that only
+// allows the user's workgroup, their database and the S3 partitions of their
+// shops. The role itself is the upper bound; the session policy is what keeps
+// one user's credentials away from another user's data.
+func (p *Provisioner) IssueCredentials(ctx context.Context, acc *Access, sessionName string) (*Credentials, error) {
+	roleArn := strings.TrimSpace(os.Getenv("BI_READER_ROLE_ARN"))
+	bucket := strings.TrimSpace(os.Getenv("ANALYTICS_BUCKET"))
+	if roleArn == "" || bucket == "" {
+		return nil, fmt.Errorf("missing env: BI_READER_ROLE_ARN/ANALYTICS_BUCKET")
+	}
+
+	policy, err := sessionPolicy(acc, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := p.sts.AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleArn),
+		RoleSessionName: aws.String(sessionName),
+		Policy:          aws.String(policy),
+		DurationSeconds: aws.Int32(3600), // role chaining caps sessions at 1h
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sts AssumeRole: %w", err)
+	}
+	c := out.Credentials
+	return &Credentials{
+		AccessKeyId:     aws.ToString(c.AccessKeyId),
+		SecretAccessKey: aws.ToString(c.SecretAccessKey),
+		SessionToken:    aws.ToString(c.SessionToken),
+		Expiration:      aws.ToTime(c.Expiration).UTC().Format(time.RFC3339),
+	}, nil
+}
+
+type statement struct {
+	Effect    string         `json:"Effect"`
+	Action    []string       `json:"Action"`
+	Resource  []string       `json:"Resource"`
+	Condition map[string]any `json:"Condition,omitempty"`
+}
+
+// Athena actions a BI session may use, on its workgroup and on the Glue
+// data catalog. Keep in sync with TrueProfitBiReaderRole in serverless.yml.
+var (
+	biWorkgroupActions = []string{
+		"athena:StartQueryExecution", "athena:GetQueryExecution", "athena:GetQueryResults",
+		"athena:StopQueryExecution", "athena:GetWorkGroup",
+	}
+	biCatalogActions = []string{
+		"athena:GetDataCatalog", "athena:ListDataCatalogs", "athena:GetDatabase", "athena:ListDatabases",
+		"athena:GetTableMetadata", "athena:ListTableMetadata",
+	}
+)
+
+func sessionPolicy(acc *Access, bucket string) (string, error) {
+	prefix := strings.TrimSpace(os.Getenv("DAILY_METRICS_PREFIX"))
+	if prefix == "" {
+		prefix = "daily_metrics/"
+	}
+	outPrefix := strings.TrimPrefix(acc.OutputS3, "s3://"+bucket+"/")
+
+	var objects, listPrefixes []string
+	for _, s := range acc.Shops {
+		objects = append(objects, fmt.Sprintf("arn:aws:s3:::%s/%s*/shop_id=%s/*", bucket, prefix, s))
+		listPrefixes = append(listPrefixes, fmt.Sprintf("%s*/shop_id=%s/*", prefix, s))
+	}
+	listPrefixes = append(listPrefixes, outPrefix+"*")
+
+	doc := map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []statement{
+			{
+				// Run and read queries only: no UpdateWorkGroup (it would lift
+				// the per-query scan cutoff) or DeleteWorkGroup.
+				Effect:   "Allow",
+				Action:   biWorkgroupActions,
+				Resource: []string{fmt.Sprintf("arn:aws:athena:*:*:workgroup/%s", acc.Workgroup)},
+			},
+			{
+				Effect:   "Allow",
+				Action:   biCatalogActions,
+				Resource: []string{"arn:aws:athena:*:*:datacatalog/AwsDataCatalog"},
+			},
+			{
+				Effect: "Allow",
+				Action: []string{"glue:GetDatabase", "glue:GetDatabases", "glue:GetTable", "glue:GetTables", "glue:GetPartitions"},
+				Resource: []string{
+					"arn:aws:glue:*:*:catalog",
+					fmt.Sprintf("arn:aws:glue:*:*:database/%s", acc.Database),
+					fmt.Sprintf("arn:aws:glue:*:*:table/%s/*", acc.Database),
+				},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"s3:GetObject"},
+				Resource: objects,
+			},
+			{
+				Effect:    "Allow",
+				Action:    []string{"s3:ListBucket"},
+				Resource:  []string{"arn:aws:s3:::" + bucket},
+				Condition: map[string]any{"StringLike": map[string]any{"s3:prefix": listPrefixes}},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"s3:GetBucketLocation"},
+				Resource: []string{"arn:aws:s3:::" + bucket},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"s3:GetObject", "s3:PutObject", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts"},
+				Resource: []string{fmt.Sprintf("arn:aws:s3:::%s/%s*", bucket, outPrefix)},
+			},
+		},
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxSessionPolicyLen {
+		return "", ErrPolicyTooLarge
+	}
+	return string(b), nil
+}
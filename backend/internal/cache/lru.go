@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"container/list"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LRU is a small in-memory cache with a TTL, for lookups that every request
+// repeats (a user's shops, their settings). It lives as long as the Lambda
+// container, so it saves reads on warm invocations only.
+//
+// Writers invalidate the entries they change, but only in their own
+// container; other containers see the change once their copy expires. Keep
+// TTLs short for anything a user edits and then reads back.
+type LRU[K comparable, V any] struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List // front = most recently used
+	items map[K]*list.Element
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	val     V
+	expires time.Time
+}
+
+// New returns a cache holding up to size entries for ttl each. A zero ttl
+// or size disables it: Get always misses.
+func New[K comparable, V any](size int, ttl time.Duration) *LRU[K, V] {
+	return &LRU[K, V]{size: size, ttl: ttl, order: list.New(), items: map[K]*list.Element{}}
+}
+
+// TTL is the hot-read TTL: HOT_CACHE_TTL_SECONDS, default 60s; 0 turns
+// caching off.
+func TTL() time.Duration {
+	v := strings.TrimSpace(os.Getenv("HOT_CACHE_TTL_SECONDS"))
+	if v == "" {
+		return 60 * time.Second
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(n) * time.Second
+}
+
+func (c *LRU[K, V]) Get(k K) (V, bool) {
+	var zero V
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[k]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, k)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.val, true
+}
+
+func (c *LRU[K, V]) Set(k K, v V) {
+	if c.size <= 0 || c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[k]; ok {
+		e := el.Value.(*entry[K, V])
+		e.val, e.expires = v, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[k] = c.order.PushFront(&entry[K, V]{key: k, val: v, expires: expires})
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*entry[K, V]).key)
+	}
+}
+
+// Delete drops k, after a write that changes it.
+func (c *LRU[K, V]) Delete(k K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		c.order.Remove(el)
+		delete(c.items, k)
+	}
+}
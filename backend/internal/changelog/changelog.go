@@ -0,0 +1,176 @@
+package changelog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Layout of CHANGELOG_TABLE:
+//
+//	PK = CHANGELOG,   SK = <PublishedAt RFC3339>#<Id>   one release note
+//	PK = USER#<sub>,  SK = READ                         the user's read marker
+const entriesPK = "CHANGELOG"
+
+// Categories the frontend knows how to badge.
+var Categories = map[string]bool{
+	"feature":     true,
+	"integration": true,
+	"nlq":         true,
+	"fix":         true,
+}
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("CHANGELOG_TABLE"))
+}
+
+type Entry struct {
+	Id          string `dynamodbav:"Id" json:"id"`
+	Title       string `dynamodbav:"Title" json:"title"`
+	Body        string `dynamodbav:"Body" json:"body"` // markdown
+	Category    string `dynamodbav:"Category" json:"category"`
+	Link        string `dynamodbav:"Link,omitempty" json:"link,omitempty"`
+	PublishedAt string `dynamodbav:"PublishedAt" json:"publishedAt"`
+}
+
+type entryItem struct {
+	PK string `dynamodbav:"PK"`
+	SK string `dynamodbav:"SK"`
+	Entry
+}
+
+// Publish stores e, filling Id and PublishedAt when empty. A future
+// PublishedAt schedules the note: List hides it until then.
+func Publish(ctx context.Context, ddb *dynamodb.Client, e Entry) (Entry, error) {
+	tbl := Table()
+	if tbl == "" {
+		return e, fmt.Errorf("CHANGELOG_TABLE not set")
+	}
+	if e.Id == "" {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return e, err
+		}
+		e.Id = hex.EncodeToString(b)
+	}
+	if e.PublishedAt == "" {
+		e.PublishedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	item, err := attributevalue.MarshalMap(entryItem{PK: entriesPK, SK: e.PublishedAt + "#" + e.Id, Entry: e})
+	if err != nil {
+		return e, err
+	}
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(tbl), Item: item})
+	if err != nil {
+		return e, fmt.Errorf("put changelog entry: %w", err)
+	}
+	return e, nil
+}
+
+// List returns up to limit published entries, newest first.
+func List(ctx context.Context, ddb *dynamodb.Client, limit int32) ([]Entry, error) {
+	tbl := Table()
+	if tbl == "" {
+		return nil, fmt.Errorf("CHANGELOG_TABLE not set")
+	}
+	// "~" sorts after any "<timestamp>#<id>" up to now, so scheduled notes stay hidden.
+	now := time.Now().UTC().Format(time.RFC3339) + "~"
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tbl),
+		KeyConditionExpression: aws.String("PK = :pk AND SK <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":  &types.AttributeValueMemberS{Value: entriesPK},
+			":now": &types.AttributeValueMemberS{Value: now},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query changelog: %w", err)
+	}
+
+	var items []entryItem
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(items))
+	for _, it := range items {
+		entries = append(entries, it.Entry)
+	}
+	return entries, nil
+}
+
+// Delete removes an entry by its publish time and id.
+func Delete(ctx context.Context, ddb *dynamodb.Client, publishedAt, id string) error {
+	_, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(Table()),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: entriesPK},
+			"SK": &types.AttributeValueMemberS{Value: publishedAt + "#" + id},
+		},
+	})
+	return err
+}
+
+func readKey(sub string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: "READ"},
+	}
+}
+
+// LastRead returns when the user last marked the feed read ("" if never).
+func LastRead(ctx context.Context, ddb *dynamodb.Client, sub string) (string, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(Table()),
+		Key:       readKey(sub),
+	})
+	if err != nil || out.Item == nil {
+		return "", err
+	}
+	if v, ok := out.Item["ReadAt"].(*types.AttributeValueMemberS); ok {
+		return v.Value, nil
+	}
+	return "", nil
+}
+
+// MarkRead moves the user's marker forward to at. It never moves backwards,
+// so a stale tab can't resurrect notes the user already dismissed.
+func MarkRead(ctx context.Context, ddb *dynamodb.Client, sub, at string) error {
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(Table()),
+		Key:                 readKey(sub),
+		UpdateExpression:    aws.String("SET ReadAt = :at"),
+		ConditionExpression: aws.String("attribute_not_exists(ReadAt) OR ReadAt < :at"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: at},
+		},
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		return fmt.Errorf("mark changelog read: %w", err)
+	}
+	return nil
+}
+
+// Unread counts entries published after readAt.
+func Unread(entries []Entry, readAt string) int {
+	n := 0
+	for _, e := range entries {
+		if e.PublishedAt > readAt {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,267 @@
+package costs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Manual cost of goods, per shop: a unit cost for a SKU, or the total cost of
+// one order. An order override wins over SKU costs for that order. Costs are
+// in the shop currency, like order lines.
+//
+// PRODUCT_COSTS_TABLE
+// PK = SHOP#<shop>
+// SK = SKU#<sku>          UnitCost (N)
+// SK = ORDER#<orderId>    Cost (N)
+// both with UpdatedAt, UpdatedBy (user sub)
+
+const (
+	SourceOrder = "order"
+	SourceSKU   = "sku"
+)
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("PRODUCT_COSTS_TABLE"))
+}
+
+func key(shop, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "SHOP#" + strings.ToLower(shop)},
+		"SK": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+func skuKey(sku string) string       { return "SKU#" + sku }
+func orderKey(orderID string) string { return "ORDER#" + orderID }
+
+// SetSKU sets the unit cost of sku in shop.
+func SetSKU(ctx context.Context, ddb *dynamodb.Client, shop, sku string, unitCost float64, by string) error {
+	return put(ctx, ddb, shop, skuKey(sku), "UnitCost", unitCost, by)
+}
+
+// SetOrder sets the total cost of goods of one order.
+func SetOrder(ctx context.Context, ddb *dynamodb.Client, shop, orderID string, cost float64, by string) error {
+	return put(ctx, ddb, shop, orderKey(orderID), "Cost", cost, by)
+}
+
+func put(ctx context.Context, ddb *dynamodb.Client, shop, sk, attr string, v float64, by string) error {
+	tbl := Table()
+	if tbl == "" {
+		return fmt.Errorf("PRODUCT_COSTS_TABLE not set")
+	}
+	item := key(shop, sk)
+	item[attr] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(v, 'f', 2, 64)}
+	item["UpdatedAt"] = &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)}
+	item["UpdatedBy"] = &types.AttributeValueMemberS{Value: by}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(tbl), Item: item}); err != nil {
+		return fmt.Errorf("put cost %s: %w", sk, err)
+	}
+	return nil
+}
+
+// DeleteSKU removes a SKU override; it reports whether there was one.
+func DeleteSKU(ctx context.Context, ddb *dynamodb.Client, shop, sku string) (bool, error) {
+	return del(ctx, ddb, shop, skuKey(sku))
+}
+
+// DeleteOrder removes an order override; it reports whether there was one.
+func DeleteOrder(ctx context.Context, ddb *dynamodb.Client, shop, orderID string) (bool, error) {
+	return del(ctx, ddb, shop, orderKey(orderID))
+}
+
+func del(ctx context.Context, ddb *dynamodb.Client, shop, sk string) (bool, error) {
+	tbl := Table()
+	if tbl == "" {
+		return false, fmt.Errorf("PRODUCT_COSTS_TABLE not set")
+	}
+	out, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(tbl),
+		Key:          key(shop, sk),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return false, fmt.Errorf("delete cost %s: %w", sk, err)
+	}
+	return len(out.Attributes) > 0, nil
+}
+
+// Override is one stored cost, for listing.
+type Override struct {
+	SKU       string  `json:"sku,omitempty"`
+	OrderID   string  `json:"orderId,omitempty"`
+	UnitCost  float64 `json:"unitCost,omitempty"`
+	Cost      float64 `json:"cost,omitempty"`
+	UpdatedAt string  `json:"updatedAt"`
+}
+
+// Book is a shop's overrides, ready for costing lines.
+type Book struct {
+	SKUs   map[string]float64
+	Orders map[string]float64
+
+	list []Override
+}
+
+// Load reads all of shop's overrides. Without a table the book is empty.
+func Load(ctx context.Context, ddb *dynamodb.Client, shop string) (*Book, error) {
+	b := &Book{SKUs: map[string]float64{}, Orders: map[string]float64{}}
+	tbl := Table()
+	if tbl == "" {
+		return b, nil
+	}
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: "SHOP#" + strings.ToLower(shop)},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query costs: %w", err)
+		}
+		for _, it := range out.Items {
+			sk, _ := it["SK"].(*types.AttributeValueMemberS)
+			if sk == nil {
+				continue
+			}
+			o := Override{UpdatedAt: strAttr(it["UpdatedAt"])}
+			switch {
+			case strings.HasPrefix(sk.Value, "SKU#"):
+				o.SKU = strings.TrimPrefix(sk.Value, "SKU#")
+				o.UnitCost = numAttr(it["UnitCost"])
+				b.SKUs[o.SKU] = o.UnitCost
+			case strings.HasPrefix(sk.Value, "ORDER#"):
+				o.OrderID = strings.TrimPrefix(sk.Value, "ORDER#")
+				o.Cost = numAttr(it["Cost"])
+				b.Orders[o.OrderID] = o.Cost
+			default:
+				continue
+			}
+			b.list = append(b.list, o)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return b, nil
+}
+
+// HasAny reports whether shop has at least one override.
+func HasAny(ctx context.Context, ddb *dynamodb.Client, shop string) (bool, error) {
+	tbl := Table()
+	if tbl == "" {
+		return false, nil
+	}
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tbl),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "SHOP#" + strings.ToLower(shop)},
+		},
+		ProjectionExpression: aws.String("PK"),
+		Limit:                aws.Int32(1),
+	})
+	if err != nil {
+		return false, fmt.Errorf("query costs: %w", err)
+	}
+	return len(out.Items) > 0, nil
+}
+
+// Overrides lists the book's entries, SKUs first.
+func (b *Book) Overrides() []Override {
+	out := append([]Override(nil), b.list...)
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].SKU == "") != (out[j].SKU == "") {
+			return out[i].SKU != ""
+		}
+		return out[i].SKU+out[i].OrderID < out[j].SKU+out[j].OrderID
+	})
+	return out
+}
+
+// LineCost is a line's cost of goods and where it came from ("" when the
+// line has no known cost).
+type LineCost struct {
+	Cost   float64
+	Source string
+}
+
+// OrderLines costs an order's lines. An order override is spread over the
+// lines by revenue (by quantity when the order had no revenue).
+func (b *Book) OrderLines(orderID string, lines []shopify.LineItem) []LineCost {
+	out := make([]LineCost, len(lines))
+	if total, ok := b.Orders[orderID]; ok {
+		var rev float64
+		var units int
+		for _, l := range lines {
+			rev += l.Revenue()
+			units += l.Quantity
+		}
+		for i, l := range lines {
+			share := 0.0
+			switch {
+			case rev > 0:
+				share = l.Revenue() / rev
+			case units > 0:
+				share = float64(l.Quantity) / float64(units)
+			}
+			out[i] = LineCost{Cost: total * share, Source: SourceOrder}
+		}
+		return out
+	}
+	for i, l := range lines {
+		if unit, ok := b.SKUs[l.SKU]; ok && l.SKU != "" {
+			out[i] = LineCost{Cost: unit * float64(l.Quantity), Source: SourceSKU}
+		}
+	}
+	return out
+}
+
+// Order is the total cost of goods of an order, and whether any of it is
+// known. An order override counts even when its lines weren't captured.
+func (b *Book) Order(orderID string, lines []shopify.LineItem) (float64, bool) {
+	if total, ok := b.Orders[orderID]; ok {
+		return total, true
+	}
+	var sum float64
+	known := false
+	for _, c := range b.OrderLines(orderID, lines) {
+		if c.Source != "" {
+			sum += c.Cost
+			known = true
+		}
+	}
+	return sum, known
+}
+
+func strAttr(av types.AttributeValue) string {
+	s, _ := av.(*types.AttributeValueMemberS)
+	if s == nil {
+		return ""
+	}
+	return s.Value
+}
+
+func numAttr(av types.AttributeValue) float64 {
+	n, _ := av.(*types.AttributeValueMemberN)
+	if n == nil {
+		return 0
+	}
+	f, _ := strconv.ParseFloat(n.Value, 64)
+	return f
+}
@@ -3,13 +3,14 @@ package db
 import (
 	"context"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"backend/internal/shadow"
+
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
 func NewDynamoClient(ctx context.Context) (*dynamodb.Client, error) {
 	// Uses Lambda’s execution role creds automatically
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := shadow.LoadConfig(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -21,3 +21,11 @@ func ShopToUserTableName() string {
 func UsersTableName() string {
 	return os.Getenv("USERS_TABLE")
 }
+
+func FxRatesTableName() string {
+	return os.Getenv("FX_RATES_TABLE")
+}
+
+func SecurityEventsTableName() string {
+	return os.Getenv("SECURITY_EVENTS_TABLE")
+}
@@ -0,0 +1,350 @@
+package dupes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Likely duplicates are manual transactions with the same amount, currency
+// and category on the same (UTC) day. Groups of them wait for review in the
+// transactions table, outside the user's own partition:
+//
+// PK = DUPES#USER#<sub>
+// SK = <YYYY-MM-DD>#<currency>#<cents>#<category>
+//
+// Dismissing a group keeps it quiet until a new transaction joins it.
+
+const (
+	StatusOpen      = "open"
+	StatusDismissed = "dismissed"
+)
+
+// groupTTL drops groups nobody looked at.
+const groupTTL = 90 * 24 * time.Hour
+
+var ErrNotFound = errors.New("duplicate group not found")
+
+type Group struct {
+	PK string `dynamodbav:"PK" json:"-"`
+	SK string `dynamodbav:"SK" json:"key"`
+
+	Day          string   `dynamodbav:"Day" json:"day"`
+	Currency     string   `dynamodbav:"Currency" json:"currency"`
+	Amount       float64  `dynamodbav:"Amount" json:"amount"`
+	Category     string   `dynamodbav:"Category" json:"category"`
+	Ids          []string `dynamodbav:"Ids,stringset" json:"ids"`
+	DismissedIds []string `dynamodbav:"DismissedIds,stringset,omitempty" json:"-"`
+	Status       string   `dynamodbav:"Status" json:"status"`
+	DetectedAt   string   `dynamodbav:"DetectedAt" json:"detectedAt"`
+	UpdatedAt    string   `dynamodbav:"UpdatedAt" json:"updatedAt"`
+	ExpiresAt    int64    `dynamodbav:"ExpiresAt" json:"-"`
+}
+
+// Entry is a manual transaction as the detector sees it.
+type Entry struct {
+	Id       string
+	Day      string // YYYY-MM-DD
+	Currency string
+	Amount   float64
+	Category string
+}
+
+func pk(sub string) string {
+	return "DUPES#USER#" + sub
+}
+
+// Key is the group SK an entry falls in.
+func Key(e Entry) string {
+	e = normalize(e)
+	return fmt.Sprintf("%s#%s#%d#%s", e.Day, e.Currency, int64(math.Round(e.Amount*100)), e.Category)
+}
+
+// Find groups entries by Key and returns those with more than one member.
+func Find(entries []Entry) []Group {
+	byKey := map[string]*Group{}
+	var keys []string
+	for _, e := range entries {
+		e = normalize(e)
+		k := Key(e)
+		g := byKey[k]
+		if g == nil {
+			g = &Group{SK: k, Day: e.Day, Currency: e.Currency, Amount: e.Amount, Category: e.Category}
+			byKey[k] = g
+			keys = append(keys, k)
+		}
+		g.Ids = append(g.Ids, e.Id)
+	}
+	var out []Group
+	for _, k := range keys {
+		if g := byKey[k]; len(g.Ids) > 1 {
+			sort.Strings(g.Ids)
+			out = append(out, *g)
+		}
+	}
+	return out
+}
+
+// Record stores groups for sub, merged with what is already stored. A
+// dismissed group reopens only when it gained a transaction since.
+func Record(ctx context.Context, ddb *dynamodb.Client, table, sub string, groups []Group) error {
+	return record(ctx, ddb, table, sub, groups, false)
+}
+
+// record is Record; replace makes groups' Ids authoritative instead of
+// adding to the stored ones.
+func record(ctx context.Context, ddb *dynamodb.Client, table, sub string, groups []Group, replace bool) error {
+	now := time.Now().UTC()
+	for _, g := range groups {
+		prev, err := get(ctx, ddb, table, sub, g.SK)
+		if err != nil {
+			return err
+		}
+		g.PK = pk(sub)
+		g.Status = StatusOpen
+		g.DetectedAt = now.Format(time.RFC3339)
+		if prev != nil {
+			g.DetectedAt = prev.DetectedAt
+			if !replace {
+				g.Ids = union(prev.Ids, g.Ids)
+			}
+			g.DismissedIds = prev.DismissedIds
+			if prev.Status == StatusDismissed && len(union(prev.DismissedIds, g.Ids)) == len(prev.DismissedIds) {
+				g.Status = StatusDismissed
+			}
+		}
+		g.UpdatedAt = now.Format(time.RFC3339)
+		g.ExpiresAt = now.Add(groupTTL).Unix()
+
+		av, err := attributevalue.MarshalMap(g)
+		if err != nil {
+			return err
+		}
+		if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: av}); err != nil {
+			return fmt.Errorf("put duplicate group: %w", err)
+		}
+	}
+	return nil
+}
+
+// Sync replaces sub's groups for the given days with found, a full
+// detection over those days: groups take found's members, and open groups
+// that no longer have duplicates are removed.
+func Sync(ctx context.Context, ddb *dynamodb.Client, table, sub string, days map[string]bool, found []Group) error {
+	if err := record(ctx, ddb, table, sub, found, true); err != nil {
+		return err
+	}
+	still := map[string]bool{}
+	for _, g := range found {
+		still[g.SK] = true
+	}
+	stored, err := List(ctx, ddb, table, sub, StatusOpen)
+	if err != nil {
+		return err
+	}
+	for _, g := range stored {
+		if !days[g.Day] || still[g.SK] {
+			continue
+		}
+		_, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(table),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: pk(sub)},
+				"SK": &types.AttributeValueMemberS{Value: g.SK},
+			},
+			ConditionExpression:      aws.String("#s = :open"),
+			ExpressionAttributeNames: map[string]string{"#s": "Status"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":open": &types.AttributeValueMemberS{Value: StatusOpen},
+			},
+		})
+		var cfe *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &cfe) {
+			return fmt.Errorf("delete duplicate group: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns sub's groups, newest day first; status "" lists all.
+func List(ctx context.Context, ddb *dynamodb.Client, table, sub, status string) ([]Group, error) {
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: pk(sub)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	if status != "" {
+		in.FilterExpression = aws.String("#s = :status")
+		in.ExpressionAttributeNames = map[string]string{"#s": "Status"}
+		in.ExpressionAttributeValues[":status"] = &types.AttributeValueMemberS{Value: status}
+	}
+	var groups []Group
+	for {
+		out, err := ddb.Query(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("query duplicate groups: %w", err)
+		}
+		var page []Group
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		groups = append(groups, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return groups, nil
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// Dismiss marks a group reviewed as not duplicates.
+func Dismiss(ctx context.Context, ddb *dynamodb.Client, table, sub, key string) (*Group, error) {
+	g, err := get(ctx, ddb, table, sub, key)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, ErrNotFound
+	}
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pk(sub)},
+			"SK": &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression:         aws.String("SET #s = :dismissed, DismissedIds = :ids, UpdatedAt = :now"),
+		ConditionExpression:      aws.String("attribute_exists(PK)"),
+		ExpressionAttributeNames: map[string]string{"#s": "Status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":dismissed": &types.AttributeValueMemberS{Value: StatusDismissed},
+			":ids":       &types.AttributeValueMemberSS{Value: g.Ids},
+			":now":       &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dismiss duplicate group: %w", err)
+	}
+	g.Status, g.DismissedIds = StatusDismissed, g.Ids
+	return g, nil
+}
+
+func get(ctx context.Context, ddb *dynamodb.Client, table, sub, key string) (*Group, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pk(sub)},
+			"SK": &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get duplicate group: %w", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var g Group
+	if err := attributevalue.UnmarshalMap(out.Item, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range append(append([]string{}, a...), b...) {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// normalize keeps keys stable across spacing and case of the currency.
+func normalize(e Entry) Entry {
+	e.Currency = strings.ToUpper(strings.TrimSpace(e.Currency))
+	e.Category = strings.TrimSpace(e.Category)
+	return e
+}
+
+// Row is what detection needs of a transactions item.
+type Row struct {
+	SK        string  `dynamodbav:"SK"`
+	GSI1SK    string  `dynamodbav:"GSI1SK"`
+	Amount    float64 `dynamodbav:"Amount"`
+	Currency  string  `dynamodbav:"Currency"`
+	Category  string  `dynamodbav:"Category"`
+	Source    string  `dynamodbav:"Source"`
+	SplitInto int     `dynamodbav:"SplitInto"`
+	ParentId  string  `dynamodbav:"ParentId"`
+	DeletedAt string  `dynamodbav:"DeletedAt"`
+}
+
+// Detect runs detection over sub's manual transactions on days [from, to]
+// (UTC), reading the GSI1 month partitions. days lists every day covered,
+// for Sync.
+func Detect(ctx context.Context, ddb *dynamodb.Client, table, sub string, from, to time.Time) (groups []Group, days map[string]bool, err error) {
+	days = map[string]bool{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		days[d.Format("2006-01-02")] = true
+	}
+	var entries []Entry
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(to); m = m.AddDate(0, 1, 0) {
+		in := &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			IndexName:              aws.String("GSI1"),
+			KeyConditionExpression: aws.String("GSI1PK = :pk AND GSI1SK BETWEEN :from AND :to"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":   &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, m.Format("2006-01"))},
+				":from": &types.AttributeValueMemberS{Value: from.Format("2006-01-02")},
+				":to":   &types.AttributeValueMemberS{Value: to.Format("2006-01-02") + "~"},
+			},
+		}
+		for {
+			out, err := ddb.Query(ctx, in)
+			if err != nil {
+				return nil, nil, fmt.Errorf("query transactions: %w", err)
+			}
+			var page []Row
+			if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+				return nil, nil, err
+			}
+			for _, r := range page {
+				if e, ok := r.Entry(); ok {
+					entries = append(entries, e)
+				}
+			}
+			if len(out.LastEvaluatedKey) == 0 {
+				break
+			}
+			in.ExclusiveStartKey = out.LastEvaluatedKey
+		}
+	}
+	return Find(entries), days, nil
+}
+
+// Entry returns r as detection sees it; ok is false unless r is a live,
+// user-entered row that counts toward totals.
+func (r Row) Entry() (Entry, bool) {
+	if r.Source != "" || r.SplitInto > 0 || r.ParentId != "" || r.DeletedAt != "" || len(r.GSI1SK) < 10 {
+		return Entry{}, false
+	}
+	return Entry{Id: r.SK, Day: r.GSI1SK[:10], Currency: r.Currency, Amount: r.Amount, Category: r.Category}, true
+}
@@ -12,6 +12,13 @@ import (
 	"strings"
 	"time"
 
+	"backend/internal/costs"
+	"backend/internal/fx"
+	"backend/internal/nlq"
+	"backend/internal/ops"
+	"backend/internal/promos"
+	"backend/internal/shopify"
+
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -34,6 +41,7 @@ type DailyMetricsRow struct {
 	FulfillmentCosts float64 `parquet:"name=fulfillment_costs, type=DOUBLE"`
 	ProcessingFees   float64 `parquet:"name=processing_fees, type=DOUBLE"`
 	OtherCosts       float64 `parquet:"name=other_costs, type=DOUBLE"`
+	IsPromo          bool    `parquet:"name=is_promo, type=BOOLEAN"` // labelled by the promo detector
 }
 
 type DailyMetricsETL struct {
@@ -51,9 +59,9 @@ func NewDailyMetricsETL(cfg aws.Config) *DailyMetricsETL {
 // Handle is triggered by EventBridge schedule.
 //
 // Behavior:
-// - Discover shops from SHOP_TO_USER_TABLE
-// - For each shop and each day in the backfill window, aggregate from TRANSACTIONS_TABLE
-// - Write one Parquet row per (shop, dt) under:
+//   - Discover shops from SHOP_TO_USER_TABLE
+//   - For each shop and each day in the backfill window, aggregate from TRANSACTIONS_TABLE
+//   - Write one Parquet row per (shop, dt) under:
 //     daily_metrics/dt=YYYY-MM-DD/shop_id=<shop>/part-<rand>.parquet
 //
 // Env:
@@ -63,7 +71,17 @@ func NewDailyMetricsETL(cfg aws.Config) *DailyMetricsETL {
 // - DAILY_METRICS_PREFIX (default "daily_metrics/")
 // - ETL_TIMEZONE (default "Asia/Ho_Chi_Minh")
 // - ETL_DAYS_BACK (default "1")  // number of days including today
-func (h *DailyMetricsETL) Handle(ctx context.Context, _ events.CloudWatchEvent) (map[string]any, error) {
+// - ETL_CURRENCY (optional)      // convert amounts to this currency via FX_RATES_TABLE
+// - NLQ_CACHE_TABLE (optional)   // bump each written shop's NLQ data version
+func (h *DailyMetricsETL) Handle(ctx context.Context, ev events.CloudWatchEvent) (map[string]any, error) {
+	started := time.Now()
+	out, err := h.run(ctx, ev)
+	ops.Duration(ctx, h.ddb, ops.Sync, "etl-daily-metrics", time.Since(started))
+	ops.Beat(ctx, h.ddb, ops.Sync, "etl-daily-metrics", err)
+	return out, err
+}
+
+func (h *DailyMetricsETL) run(ctx context.Context, _ events.CloudWatchEvent) (map[string]any, error) {
 	mapTable := strings.TrimSpace(os.Getenv("SHOP_TO_USER_TABLE"))
 	txTable := strings.TrimSpace(os.Getenv("TRANSACTIONS_TABLE"))
 
@@ -112,29 +130,21 @@ func (h *DailyMetricsETL) Handle(ctx context.Context, _ events.CloudWatchEvent)
 	written := 0
 	totalTx := 0
 
+	// Retire cached /ask answers of every shop that got new rows, also when
+	// the run stops part way.
+	changed := map[string]bool{}
+	defer h.bumpDataVersions(ctx, changed)
+
 	for i := 0; i < daysBack; i++ {
 		day := now.AddDate(0, 0, -i)
 		dtStr := day.Format("2006-01-02")
 
 		for _, shop := range shops {
-			gross, net, cnt, err := h.sumShopAmountsForDay(ctx, txTable, shop, dtStr)
+			row, cnt, err := BuildDailyMetricsRow(ctx, h.ddb, txTable, shop, dtStr)
 			if err != nil {
 				return nil, fmt.Errorf("sum tx for shop=%s dt=%s: %w", shop, dtStr, err)
 			}
 
-			// You asked to keep costs 0 for now.
-			row := DailyMetricsRow{
-				MerchantID:       shop, // MVP: merchant_id = shop
-				MetricDate:       dtStr,
-				GrossRevenue:     gross,
-				NetRevenue:       net,
-				ProductCosts:     0,
-				MarketingCosts:   0,
-				FulfillmentCosts: 0,
-				ProcessingFees:   0,
-				OtherCosts:       0,
-			}
-
 			key := fmt.Sprintf("%sdt=%s/shop_id=%s/part-%s.parquet",
 				ensureTrailingSlash(prefix),
 				dtStr,
@@ -148,6 +158,7 @@ func (h *DailyMetricsETL) Handle(ctx context.Context, _ events.CloudWatchEvent)
 
 			written++
 			totalTx += cnt
+			changed[shop] = true
 		}
 	}
 
@@ -162,6 +173,16 @@ func (h *DailyMetricsETL) Handle(ctx context.Context, _ events.CloudWatchEvent)
 	}, nil
 }
 
+// bumpDataVersions bumps the NLQ data version of each shop. Failures are
+// logged only: the rows are written, and the cache entries expire anyway.
+func (h *DailyMetricsETL) bumpDataVersions(ctx context.Context, shops map[string]bool) {
+	for shop := range shops {
+		if err := nlq.BumpDataVersion(ctx, h.ddb, shop); err != nil {
+			fmt.Printf("etl: %v\n", err)
+		}
+	}
+}
+
 // listDistinctShops scans SHOP_TO_USER_TABLE and extracts the "Shop" attribute.
 func (h *DailyMetricsETL) listDistinctShops(ctx context.Context, table string) ([]string, error) {
 	seen := map[string]bool{}
@@ -205,33 +226,110 @@ func (h *DailyMetricsETL) listDistinctShops(ctx context.Context, table string) (
 	return shops, nil
 }
 
+// BuildDailyMetricsRow aggregates one shop's transactions for one day into a
+// daily_metrics row. Also used by exporters that need the same numbers.
+// Returns the row and the number of transactions it covers.
+func BuildDailyMetricsRow(ctx context.Context, ddb *dynamodb.Client, txTable, shop, dayYYYYMMDD string) (DailyMetricsRow, int, error) {
+	sums, err := sumShopAmountsForDay(ctx, ddb, txTable, shop, dayYYYYMMDD)
+	if err != nil {
+		return DailyMetricsRow{}, 0, err
+	}
+	isPromo, err := promos.IsPromoDay(ctx, ddb, shop, dayYYYYMMDD)
+	if err != nil {
+		return DailyMetricsRow{}, 0, err
+	}
+
+	// Ad spend and manual product costs are attributed per shop so far;
+	// other costs stay 0.
+	return DailyMetricsRow{
+		MerchantID:       shop, // MVP: merchant_id = shop
+		MetricDate:       dayYYYYMMDD,
+		GrossRevenue:     sums.Gross,
+		NetRevenue:       sums.Net,
+		ProductCosts:     sums.ProductCosts,
+		MarketingCosts:   sums.Marketing,
+		FulfillmentCosts: 0,
+		ProcessingFees:   0,
+		OtherCosts:       0,
+		IsPromo:          isPromo,
+	}, sums.Count, nil
+}
+
+// shopDayTotals is what one shop contributed on one day.
+type shopDayTotals struct {
+	Gross     float64
+	Net       float64
+	Marketing float64 // positive spend
+	// ProductCosts is the cost of goods of the day's orders, from the
+	// shop's manual costs (package costs).
+	ProductCosts float64
+	Count        int
+}
+
+// marketingCategory is the Category written by the ad spend connectors.
+const marketingCategory = "Marketing Costs"
+
+// orderIDOf is the Shopify order id of an order transaction ("" otherwise).
+// Webhook rows carry OrderId; synced rows only have it in the SK.
+func orderIDOf(it map[string]ddbtypes.AttributeValue) string {
+	if v, ok := it["OrderId"].(*ddbtypes.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	if v, ok := it["SK"].(*ddbtypes.AttributeValueMemberS); ok {
+		if _, id, found := strings.Cut(v.Value, "#ORDER#"); found {
+			return id
+		}
+	}
+	return ""
+}
+
 // sumShopAmountsForDay scans TRANSACTIONS_TABLE and sums Amount for one shop + one day.
 // Works with your worker inserts:
-// - Shop: "<domain>"
-// - CreatedAt: RFC3339, so begins_with("YYYY-MM-DD") works
-// - Amount: N string (positive sale / negative refund)
-func (h *DailyMetricsETL) sumShopAmountsForDay(ctx context.Context, txTable, shop, dayYYYYMMDD string) (gross float64, net float64, count int, err error) {
+//   - Shop: "<domain>"
+//   - CreatedAt: RFC3339, so begins_with("YYYY-MM-DD") works
+//   - Amount: N string (positive sale / negative refund)
+//   - Category: "Marketing Costs" rows (negative ad spend) go to Marketing, not revenue
+//   - Currency: converted to ETL_CURRENCY when set (e.g. USD ad spend on a VND shop)
+//   - SplitInto: split orders count through their allocations, but their
+//     lines still carry the cost of goods
+//   - DeletedAt: soft-deleted rows don't count at all
+func sumShopAmountsForDay(ctx context.Context, ddb *dynamodb.Client, txTable, shop, dayYYYYMMDD string) (shopDayTotals, error) {
+	var t shopDayTotals
 	var startKey map[string]ddbtypes.AttributeValue
 
+	target := strings.ToUpper(strings.TrimSpace(os.Getenv("ETL_CURRENCY")))
+	conv := fx.NewConverter(ddb)
+	book, err := costs.Load(ctx, ddb, shop)
+	if err != nil {
+		return t, err
+	}
+
 	for {
-		out, err := h.ddb.Scan(ctx, &dynamodb.ScanInput{
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
 			TableName:         aws.String(txTable),
 			ExclusiveStartKey: startKey,
 
-			FilterExpression: aws.String("#shop = :shop AND begins_with(#createdAt, :day)"),
+			FilterExpression: aws.String("#shop = :shop AND begins_with(#createdAt, :day) AND attribute_not_exists(#deletedAt)"),
 			ExpressionAttributeNames: map[string]string{
 				"#shop":      "Shop",
 				"#createdAt": "CreatedAt",
 				"#amount":    "Amount",
+				"#category":  "Category",
+				"#currency":  "Currency",
+				"#orderId":   "OrderId",
+				"#sk":        "SK",
+				"#lines":     "Lines",
+				"#splitInto": "SplitInto",
+				"#deletedAt": "DeletedAt",
 			},
 			ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
 				":shop": &ddbtypes.AttributeValueMemberS{Value: shop},
 				":day":  &ddbtypes.AttributeValueMemberS{Value: dayYYYYMMDD},
 			},
-			ProjectionExpression: aws.String("#shop, #createdAt, #amount"),
+			ProjectionExpression: aws.String("#shop, #createdAt, #amount, #category, #currency, #orderId, #sk, #lines, #splitInto"),
 		})
 		if err != nil {
-			return 0, 0, 0, fmt.Errorf("scan tx table: %w", err)
+			return t, fmt.Errorf("scan tx table: %w", err)
 		}
 
 		for _, it := range out.Items {
@@ -247,12 +345,34 @@ func (h *DailyMetricsETL) sumShopAmountsForDay(ctx context.Context, txTable, sho
 			if perr != nil {
 				continue
 			}
+			cost, _ := book.Order(orderIDOf(it), shopify.LineItemsOf(it))
+			if cur, ok := it["Currency"].(*ddbtypes.AttributeValueMemberS); ok && target != "" {
+				amt, err = conv.Convert(ctx, amt, cur.Value, target, dayYYYYMMDD)
+				if err != nil {
+					return t, fmt.Errorf("convert %s to %s: %w", cur.Value, target, err)
+				}
+				if cost != 0 {
+					if cost, err = conv.Convert(ctx, cost, cur.Value, target, dayYYYYMMDD); err != nil {
+						return t, fmt.Errorf("convert %s to %s: %w", cur.Value, target, err)
+					}
+				}
+			}
+			t.ProductCosts += cost
+			if _, split := it["SplitInto"]; split {
+				continue
+			}
+
+			if cv, ok := it["Category"].(*ddbtypes.AttributeValueMemberS); ok && cv.Value == marketingCategory {
+				t.Marketing += -amt
+				t.Count++
+				continue
+			}
 
 			if amt > 0 {
-				gross += amt
+				t.Gross += amt
 			}
-			net += amt
-			count++
+			t.Net += amt
+			t.Count++
 		}
 
 		if out.LastEvaluatedKey == nil || len(out.LastEvaluatedKey) == 0 {
@@ -261,7 +381,7 @@ func (h *DailyMetricsETL) sumShopAmountsForDay(ctx context.Context, txTable, sho
 		startKey = out.LastEvaluatedKey
 	}
 
-	return gross, net, count, nil
+	return t, nil
 }
 
 func (h *DailyMetricsETL) writeOneParquetRowToS3(ctx context.Context, bucket, key string, row DailyMetricsRow) error {
@@ -0,0 +1,245 @@
+package exports
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/metrics"
+	"backend/internal/nlq"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Row is a transaction as the exports see it.
+type Row struct {
+	SK        string   `dynamodbav:"SK"`
+	GSI1SK    string   `dynamodbav:"GSI1SK"`
+	Amount    float64  `dynamodbav:"Amount"`
+	Currency  string   `dynamodbav:"Currency"`
+	Category  string   `dynamodbav:"Category"`
+	Note      string   `dynamodbav:"Note"`
+	Source    string   `dynamodbav:"Source"`
+	Shop      string   `dynamodbav:"Shop"`
+	Tags      []string `dynamodbav:"Tags,stringset"`
+	SplitInto int      `dynamodbav:"SplitInto"`
+	DeletedAt string   `dynamodbav:"DeletedAt"`
+}
+
+// Rows loads sub's countable transactions (not split, not deleted) dated in
+// the inclusive UTC range [from, to], optionally of one shop.
+func Rows(ctx context.Context, ddb *dynamodb.Client, txTable, sub, shop string, from, to time.Time) ([]Row, error) {
+	var rows []Row
+	fromS, toS := from.Format("2006-01-02"), to.Format("2006-01-02")
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(to); m = m.AddDate(0, 1, 0) {
+		var startKey map[string]types.AttributeValue
+		for {
+			out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(txTable),
+				IndexName:              aws.String("GSI1"),
+				KeyConditionExpression: aws.String("GSI1PK = :pk AND GSI1SK BETWEEN :from AND :to"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":pk":   &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, m.Format("2006-01"))},
+					":from": &types.AttributeValueMemberS{Value: fromS},
+					":to":   &types.AttributeValueMemberS{Value: toS + "~"}, // sorts after any timestamp that day
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("query transactions: %w", err)
+			}
+			var page []Row
+			if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+				return nil, err
+			}
+			for _, r := range page {
+				if r.SplitInto == 0 && r.DeletedAt == "" && (shop == "" || r.Shop == shop) {
+					rows = append(rows, r)
+				}
+			}
+			if len(out.LastEvaluatedKey) == 0 {
+				break
+			}
+			startKey = out.LastEvaluatedKey
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].GSI1SK < rows[j].GSI1SK })
+	return rows, nil
+}
+
+func money(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', 2, 64)
+}
+
+// cell keeps user text from being read as a formula by spreadsheet apps.
+func cell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// TransactionsCSV lists rows one per line, oldest first.
+func TransactionsCSV(rows []Row) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"date", "id", "category", "amount", "currency", "shop", "source", "tags", "note"})
+	for _, r := range rows {
+		day := r.GSI1SK
+		if len(day) >= 10 {
+			day = day[:10]
+		}
+		_ = w.Write([]string{day, r.SK, cell(r.Category), money(r.Amount), r.Currency, r.Shop, r.Source, cell(strings.Join(r.Tags, ";")), cell(r.Note)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// PnLCSV sums rows into a profit and loss statement per currency: income
+// categories, expense categories (as positive amounts), then totals.
+func PnLCSV(rows []Row, from, to time.Time) ([]byte, error) {
+	type pnl struct {
+		income, expense map[string]float64
+	}
+	byCur := map[string]*pnl{}
+	for _, r := range rows {
+		p := byCur[r.Currency]
+		if p == nil {
+			p = &pnl{income: map[string]float64{}, expense: map[string]float64{}}
+			byCur[r.Currency] = p
+		}
+		if r.Amount >= 0 {
+			p.income[r.Category] += r.Amount
+		} else {
+			p.expense[r.Category] += -r.Amount
+		}
+	}
+	curs := make([]string, 0, len(byCur))
+	for c := range byCur {
+		curs = append(curs, c)
+	}
+	sort.Strings(curs)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	period := from.Format("2006-01-02") + " to " + to.Format("2006-01-02")
+	_ = w.Write([]string{"period", "currency", "section", "category", "amount"})
+	section := func(cur, name string, m map[string]float64) float64 {
+		cats := make([]string, 0, len(m))
+		for c := range m {
+			cats = append(cats, c)
+		}
+		sort.Strings(cats)
+		total := 0.0
+		for _, c := range cats {
+			total += m[c]
+			_ = w.Write([]string{period, cur, name, cell(c), money(m[c])})
+		}
+		return total
+	}
+	for _, cur := range curs {
+		p := byCur[cur]
+		income := section(cur, "income", p.income)
+		expense := section(cur, "expense", p.expense)
+		_ = w.Write([]string{period, cur, "total", "income", money(income)})
+		_ = w.Write([]string{period, cur, "total", "expense", money(expense)})
+		_ = w.Write([]string{period, cur, "total", "net", money(income - expense)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// ShopMetrics is one shop's daily_metrics rows.
+type ShopMetrics struct {
+	Shop string
+	Rows []metrics.Row
+}
+
+// MetricsCSV lists daily metrics one shop-day per line, by shop then date.
+func MetricsCSV(shops []ShopMetrics) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"date", "shop", "gross_revenue", "net_revenue", "product_costs", "marketing_costs", "fulfillment_costs", "processing_fees", "other_costs"})
+	for _, s := range shops {
+		for _, r := range s.Rows {
+			_ = w.Write([]string{r.Date, s.Shop, money(r.GrossRevenue), money(r.NetRevenue), money(r.ProductCosts),
+				money(r.MarketingCosts), money(r.FulfillmentCosts), money(r.ProcessingFees), money(r.OtherCosts)})
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// athenaOptions runs the daily_metrics queries; a month of one shop is a
+// small scan, but the exporter has time to wait for a busy workgroup.
+func athenaOptions() nlq.AthenaRunOptions {
+	return nlq.AthenaRunOptions{
+		Database:       strings.TrimSpace(os.Getenv("ATHENA_DATABASE")),
+		Workgroup:      strings.TrimSpace(os.Getenv("ATHENA_WORKGROUP")),
+		OutputLocation: strings.TrimSpace(os.Getenv("ATHENA_OUTPUT_S3")),
+		MaxWait:        60 * time.Second,
+	}
+}
+
+// dailyMetrics loads s's shop, or every shop of the user, from daily_metrics.
+func dailyMetrics(ctx context.Context, ddb *dynamodb.Client, ath nlq.AthenaClient, s Schedule, from, to time.Time) ([]ShopMetrics, error) {
+	shops := []string{s.Shop}
+	if s.Shop == "" {
+		all, err := tenancy.GetAllowedShopsByUserSub(ctx, ddb, s.UserSub)
+		if err != nil {
+			return nil, fmt.Errorf("shop lookup: %w", err)
+		}
+		shops = append([]string(nil), all...)
+		sort.Strings(shops)
+	}
+	out := make([]ShopMetrics, 0, len(shops))
+	for _, shop := range shops {
+		res, err := metrics.Daily(ctx, ddb, ath, athenaOptions(), shop, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("daily metrics %s: %w", shop, err)
+		}
+		out = append(out, ShopMetrics{Shop: shop, Rows: res.Rows})
+	}
+	return out, nil
+}
+
+// Build renders s's report over [from, to]. ath is only used by daily
+// metrics reports.
+func Build(ctx context.Context, ddb *dynamodb.Client, ath nlq.AthenaClient, txTable string, s Schedule, from, to time.Time) ([]byte, error) {
+	if s.Report == ReportDailyMetrics {
+		shops, err := dailyMetrics(ctx, ddb, ath, s, from, to)
+		if err != nil {
+			return nil, err
+		}
+		return MetricsCSV(shops)
+	}
+	rows, err := Rows(ctx, ddb, txTable, s.UserSub, s.Shop, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if s.Report == ReportTransactions {
+		return TransactionsCSV(rows)
+	}
+	return PnLCSV(rows, from, to)
+}
+
+// FileName is the name of an export of s over [from, to].
+func FileName(s Schedule, from, to time.Time) string {
+	return fmt.Sprintf("%s-%s_%s.csv", s.Report, from.Format("20060102"), to.Format("20060102"))
+}
+
+// Key is where an export of s over [from, to] is stored.
+func Key(s Schedule, from, to time.Time) string {
+	return fmt.Sprintf("exports/%s/%s/%s", s.UserSub, s.Id, FileName(s, from, to))
+}
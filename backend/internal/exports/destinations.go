@@ -0,0 +1,230 @@
+package exports
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Destinations are customer-owned S3 buckets a schedule also delivers to.
+// The exporter assumes RoleArn in the customer's account with ExternalId
+// (generated here, to go in the role's trust policy) and writes under
+// Prefix. Kept in EXPORT_SCHEDULES_TABLE next to the schedules:
+//
+//	PK = USER#<sub>, SK = DESTINATION#<id>
+//
+// Every delivery, and every POST .../test, records its outcome on the
+// destination (LastStatus, LastError, LastKey, LastDeliveryAt).
+
+const (
+	DeliveryOK     = "ok"
+	DeliveryFailed = "failed"
+)
+
+var ErrDestinationNotFound = errors.New("destination not found")
+
+var (
+	bucketRe  = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	roleArnRe = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]{1,512}$`)
+	regionRe  = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d$`)
+	prefixRe  = regexp.MustCompile(`^[A-Za-z0-9!_.*'()/-]*$`)
+)
+
+type Destination struct {
+	Id         string `dynamodbav:"DestinationId" json:"id"`
+	UserSub    string `dynamodbav:"UserSub" json:"-"`
+	Name       string `dynamodbav:"Name,omitempty" json:"name,omitempty"`
+	Bucket     string `dynamodbav:"Bucket" json:"bucket"`
+	Prefix     string `dynamodbav:"Prefix,omitempty" json:"prefix,omitempty"` // "" or ends with "/"
+	Region     string `dynamodbav:"Region" json:"region"`
+	RoleArn    string `dynamodbav:"RoleArn" json:"roleArn"`
+	ExternalId string `dynamodbav:"ExternalId" json:"externalId"`
+	CreatedAt  string `dynamodbav:"CreatedAt" json:"createdAt"`
+
+	LastStatus     string `dynamodbav:"LastStatus,omitempty" json:"lastStatus,omitempty"`
+	LastError      string `dynamodbav:"LastError,omitempty" json:"lastError,omitempty"`
+	LastKey        string `dynamodbav:"LastKey,omitempty" json:"lastKey,omitempty"`
+	LastDeliveryAt string `dynamodbav:"LastDeliveryAt,omitempty" json:"lastDeliveryAt,omitempty"`
+}
+
+// Validate normalizes and checks a user-supplied destination.
+func (d *Destination) Validate() error {
+	d.Name = strings.TrimSpace(d.Name)
+	d.Bucket = strings.TrimSpace(d.Bucket)
+	d.Region = strings.ToLower(strings.TrimSpace(d.Region))
+	d.RoleArn = strings.TrimSpace(d.RoleArn)
+	d.Prefix = strings.Trim(strings.TrimSpace(d.Prefix), "/")
+	if len(d.Name) > 100 {
+		return fmt.Errorf("name must be at most 100 characters")
+	}
+	if !bucketRe.MatchString(d.Bucket) || strings.Contains(d.Bucket, "..") {
+		return fmt.Errorf("bucket must be a valid S3 bucket name")
+	}
+	if !regionRe.MatchString(d.Region) {
+		return fmt.Errorf("region must be an AWS region such as eu-west-1")
+	}
+	if !roleArnRe.MatchString(d.RoleArn) {
+		return fmt.Errorf("roleArn must be an IAM role ARN")
+	}
+	if len(d.Prefix) > 200 || !prefixRe.MatchString(d.Prefix) || strings.Contains(d.Prefix, "..") {
+		return fmt.Errorf("prefix may only contain letters, digits and !_.*'()/-")
+	}
+	if d.Prefix != "" {
+		d.Prefix += "/"
+	}
+	return nil
+}
+
+func destinationKey(sub, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: "DESTINATION#" + id},
+	}
+}
+
+// PutDestination creates or replaces a destination. A new one gets its id
+// and ExternalId here; both survive a replace, as does the delivery status.
+func PutDestination(ctx context.Context, ddb *dynamodb.Client, sub string, d Destination) (Destination, error) {
+	if Table() == "" {
+		return d, fmt.Errorf("EXPORT_SCHEDULES_TABLE not set")
+	}
+	if d.Id == "" {
+		id := make([]byte, 6)
+		ext := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return d, err
+		}
+		if _, err := rand.Read(ext); err != nil {
+			return d, err
+		}
+		d.Id, d.ExternalId = hex.EncodeToString(id), hex.EncodeToString(ext)
+		d.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	d.UserSub = sub
+
+	item, err := attributevalue.MarshalMap(d)
+	if err != nil {
+		return d, err
+	}
+	for k, v := range destinationKey(sub, d.Id) {
+		item[k] = v
+	}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(Table()), Item: item}); err != nil {
+		return d, fmt.Errorf("put destination: %w", err)
+	}
+	return d, nil
+}
+
+func GetDestination(ctx context.Context, ddb *dynamodb.Client, sub, id string) (Destination, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(Table()), Key: destinationKey(sub, id)})
+	if err != nil {
+		return Destination{}, fmt.Errorf("get destination: %w", err)
+	}
+	if out.Item == nil {
+		return Destination{}, ErrDestinationNotFound
+	}
+	var d Destination
+	err = attributevalue.UnmarshalMap(out.Item, &d)
+	return d, err
+}
+
+func ListDestinations(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Destination, error) {
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(Table()),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			":p":  &types.AttributeValueMemberS{Value: "DESTINATION#"},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query destinations: %w", err)
+	}
+	destinations := []Destination{}
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &destinations)
+	return destinations, err
+}
+
+func DeleteDestination(ctx context.Context, ddb *dynamodb.Client, sub, id string) error {
+	_, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(Table()), Key: destinationKey(sub, id)})
+	return err
+}
+
+// RecordDelivery stores the outcome of writing key to d: deliveryErr nil
+// is a success.
+func RecordDelivery(ctx context.Context, ddb *dynamodb.Client, d Destination, key string, deliveryErr error) error {
+	status, msg := DeliveryOK, ""
+	if deliveryErr != nil {
+		status, msg = DeliveryFailed, deliveryErr.Error()
+	}
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(Table()),
+		Key:                 destinationKey(d.UserSub, d.Id),
+		UpdateExpression:    aws.String("SET LastStatus = :s, LastError = :e, LastKey = :k, LastDeliveryAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s":   &types.AttributeValueMemberS{Value: status},
+			":e":   &types.AttributeValueMemberS{Value: msg},
+			":k":   &types.AttributeValueMemberS{Value: key},
+			":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		return fmt.Errorf("record delivery %s: %w", d.Id, err)
+	}
+	return nil
+}
+
+// Deliver writes body to d's bucket at d.Prefix+name as the customer's
+// role. cfg is the caller's own configuration; the role is assumed from it.
+func Deliver(ctx context.Context, cfg aws.Config, d Destination, name string, body []byte) (string, error) {
+	assumeCfg := cfg.Copy()
+	assumeCfg.Region = d.Region
+	creds := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), d.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.ExternalID = aws.String(d.ExternalId)
+		o.RoleSessionName = "trueprofit-export-" + d.Id
+	})
+	assumeCfg.Credentials = aws.NewCredentialsCache(creds)
+
+	key := d.Prefix + name
+	_, err := s3.NewFromConfig(assumeCfg).PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(d.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("text/csv"),
+	})
+	if err != nil {
+		return key, fmt.Errorf("deliver to s3://%s/%s: %w", d.Bucket, key, err)
+	}
+	return key, nil
+}
+
+// InUse lists the ids of sub's schedules that deliver to destination id.
+func InUse(ctx context.Context, ddb *dynamodb.Client, sub, id string) ([]string, error) {
+	schedules, err := List(ctx, ddb, sub)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, s := range schedules {
+		if s.DestinationId == id {
+			ids = append(ids, s.Id)
+		}
+	}
+	return ids, nil
+}
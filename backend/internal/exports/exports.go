@@ -0,0 +1,236 @@
+package exports
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Scheduled exports: a user picks a report (P&L, transactions or daily
+// metrics) and a cadence, and the report-exporter job writes the last full
+// week or month as CSV to ANALYTICS_BUCKET under exports/<sub>/, copies it
+// to the schedule's destination bucket if it has one (see Destination),
+// and emails a download link through the user's alerts topic.
+//
+// Layout of EXPORT_SCHEDULES_TABLE:
+//
+//	PK = USER#<sub>, SK = SCHEDULE#<id>
+//
+// NextRun is the day the next export is due; weekly exports run on Mondays
+// for the week before, monthly ones on the 1st for the month before.
+
+const (
+	Weekly  = "weekly"
+	Monthly = "monthly"
+
+	ReportPnL          = "pnl"
+	ReportTransactions = "transactions"
+	ReportDailyMetrics = "daily_metrics"
+)
+
+var (
+	Cadences = map[string]bool{Weekly: true, Monthly: true}
+	Reports  = map[string]bool{ReportPnL: true, ReportTransactions: true, ReportDailyMetrics: true}
+)
+
+var ErrNotFound = errors.New("schedule not found")
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("EXPORT_SCHEDULES_TABLE"))
+}
+
+type Schedule struct {
+	Id        string `dynamodbav:"ScheduleId" json:"id"`
+	UserSub   string `dynamodbav:"UserSub" json:"-"`
+	Report    string `dynamodbav:"Report" json:"report"`
+	Cadence   string `dynamodbav:"Cadence" json:"cadence"`
+	Shop      string `dynamodbav:"Shop,omitempty" json:"shop,omitempty"` // "" = every shop and manual rows
+	NextRun   string `dynamodbav:"NextRun" json:"nextRun"`               // YYYY-MM-DD
+	LastKey   string `dynamodbav:"LastKey,omitempty" json:"-"`           // S3 key of the latest export
+	LastRunAt string `dynamodbav:"LastRunAt,omitempty" json:"lastRunAt,omitempty"`
+	LastFrom  string `dynamodbav:"LastFrom,omitempty" json:"lastFrom,omitempty"`
+	LastTo    string `dynamodbav:"LastTo,omitempty" json:"lastTo,omitempty"`
+	CreatedAt string `dynamodbav:"CreatedAt" json:"createdAt"`
+
+	DestinationId string `dynamodbav:"DestinationId,omitempty" json:"destinationId,omitempty"`
+}
+
+// Validate normalizes and checks a user-supplied schedule.
+func (s *Schedule) Validate() error {
+	s.Report = strings.ToLower(strings.TrimSpace(s.Report))
+	s.Cadence = strings.ToLower(strings.TrimSpace(s.Cadence))
+	s.Shop = strings.ToLower(strings.TrimSpace(s.Shop))
+	s.DestinationId = strings.TrimSpace(s.DestinationId)
+	if !Reports[s.Report] {
+		return fmt.Errorf("report must be pnl, transactions or daily_metrics")
+	}
+	if !Cadences[s.Cadence] {
+		return fmt.Errorf("cadence must be weekly or monthly")
+	}
+	return nil
+}
+
+// NextRunAfter is the first run day of cadence strictly after day.
+func NextRunAfter(cadence string, day time.Time) time.Time {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	if cadence == Weekly {
+		days := (8 - int(day.Weekday())) % 7 // to the next Monday
+		if days == 0 {
+			days = 7
+		}
+		return day.AddDate(0, 0, days)
+	}
+	return time.Date(day.Year(), day.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Period is the inclusive range an export run on runDay covers: the week
+// or month that ended the day before.
+func Period(cadence string, runDay time.Time) (from, to time.Time) {
+	to = time.Date(runDay.Year(), runDay.Month(), runDay.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	if cadence == Weekly {
+		return to.AddDate(0, 0, -6), to
+	}
+	return time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC), to
+}
+
+func scheduleKey(sub, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: "SCHEDULE#" + id},
+	}
+}
+
+// Put creates or replaces a schedule. A new schedule, or one whose cadence
+// changed, first runs at the next period boundary.
+func Put(ctx context.Context, ddb *dynamodb.Client, sub string, s Schedule) (Schedule, error) {
+	if Table() == "" {
+		return s, fmt.Errorf("EXPORT_SCHEDULES_TABLE not set")
+	}
+	now := time.Now().UTC()
+	if s.Id == "" {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return s, err
+		}
+		s.Id = hex.EncodeToString(b)
+		s.CreatedAt = now.Format(time.RFC3339)
+	}
+	if s.NextRun == "" {
+		s.NextRun = NextRunAfter(s.Cadence, now).Format("2006-01-02")
+	}
+	s.UserSub = sub
+
+	item, err := attributevalue.MarshalMap(s)
+	if err != nil {
+		return s, err
+	}
+	for k, v := range scheduleKey(sub, s.Id) {
+		item[k] = v
+	}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(Table()), Item: item}); err != nil {
+		return s, fmt.Errorf("put schedule: %w", err)
+	}
+	return s, nil
+}
+
+func Get(ctx context.Context, ddb *dynamodb.Client, sub, id string) (Schedule, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(Table()), Key: scheduleKey(sub, id)})
+	if err != nil {
+		return Schedule{}, fmt.Errorf("get schedule: %w", err)
+	}
+	if out.Item == nil {
+		return Schedule{}, ErrNotFound
+	}
+	var s Schedule
+	err = attributevalue.UnmarshalMap(out.Item, &s)
+	return s, err
+}
+
+func List(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Schedule, error) {
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(Table()),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			":p":  &types.AttributeValueMemberS{Value: "SCHEDULE#"},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query schedules: %w", err)
+	}
+	schedules := []Schedule{}
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &schedules)
+	return schedules, err
+}
+
+// Delete stops a schedule. Exports already written stay in the bucket.
+func Delete(ctx context.Context, ddb *dynamodb.Client, sub, id string) error {
+	_, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(Table()), Key: scheduleKey(sub, id)})
+	return err
+}
+
+// Due scans for schedules whose next run is on or before today.
+func Due(ctx context.Context, ddb *dynamodb.Client, today string) ([]Schedule, error) {
+	var (
+		schedules []Schedule
+		startKey  map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(Table()),
+			FilterExpression: aws.String("NextRun <= :today"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":today": &types.AttributeValueMemberS{Value: today},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan schedules: %w", err)
+		}
+		var page []Schedule
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return schedules, nil
+}
+
+// Advance records an export of [from, to] at key and moves NextRun past
+// today. Conditional on NextRun so an overlapping run can't move it back.
+func Advance(ctx context.Context, ddb *dynamodb.Client, s Schedule, key string, from, to, today time.Time) error {
+	next := NextRunAfter(s.Cadence, today).Format("2006-01-02")
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(Table()),
+		Key:                 scheduleKey(s.UserSub, s.Id),
+		UpdateExpression:    aws.String("SET NextRun = :next, LastKey = :key, LastRunAt = :now, LastFrom = :from, LastTo = :to"),
+		ConditionExpression: aws.String("NextRun = :prev"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":next": &types.AttributeValueMemberS{Value: next},
+			":prev": &types.AttributeValueMemberS{Value: s.NextRun},
+			":key":  &types.AttributeValueMemberS{Value: key},
+			":now":  &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":from": &types.AttributeValueMemberS{Value: from.Format("2006-01-02")},
+			":to":   &types.AttributeValueMemberS{Value: to.Format("2006-01-02")},
+		},
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		return fmt.Errorf("advance schedule %s: %w", s.Id, err)
+	}
+	return nil
+}
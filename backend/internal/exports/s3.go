@@ -0,0 +1,61 @@
+package exports
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func Bucket() string {
+	return strings.TrimSpace(os.Getenv("ANALYTICS_BUCKET"))
+}
+
+// LinkTTL is how long an emailed download link works:
+// EXPORT_LINK_TTL_HOURS, default 72, at most 7 days (the SigV4 limit). A
+// link signed with the Lambda role's session credentials stops working when
+// they expire, which can be sooner; GET /report-schedules/{id}/download
+// signs a fresh one.
+func LinkTTL() time.Duration {
+	h, err := strconv.Atoi(strings.TrimSpace(os.Getenv("EXPORT_LINK_TTL_HOURS")))
+	if err != nil || h <= 0 {
+		h = 72
+	}
+	if h > 7*24 {
+		h = 7 * 24
+	}
+	return time.Duration(h) * time.Hour
+}
+
+// Upload writes an export to the bucket.
+func Upload(ctx context.Context, client *s3.Client, key string, body []byte) error {
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:             aws.String(Bucket()),
+		Key:                aws.String(key),
+		Body:               bytes.NewReader(body),
+		ContentType:        aws.String("text/csv"),
+		ContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", key[strings.LastIndex(key, "/")+1:])),
+	})
+	if err != nil {
+		return fmt.Errorf("upload export: %w", err)
+	}
+	return nil
+}
+
+// Link presigns a download of key for ttl.
+func Link(ctx context.Context, client *s3.Client, key string, ttl time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(Bucket()),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("presign export: %w", err)
+	}
+	return req.URL, nil
+}
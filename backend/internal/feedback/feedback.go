@@ -0,0 +1,115 @@
+package feedback
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Kinds of feedback the form offers. wrong_numbers is the one ops cares most
+// about: it usually means a missed webhook or a bad mapping.
+var Kinds = map[string]bool{
+	"bug":           true,
+	"wrong_numbers": true,
+	"idea":          true,
+	"other":         true,
+}
+
+const (
+	MaxMessageLen = 4000
+	// MaxRequestIds bounds the recent failed request ids the client may attach.
+	MaxRequestIds = 20
+)
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("FEEDBACK_TABLE"))
+}
+
+// Report is one submission.
+// PK = FEEDBACK#<YYYY-MM>, SK = <CreatedAt>#<Id>, so ops can page a month newest first.
+type Report struct {
+	Id         string   `dynamodbav:"Id" json:"id"`
+	UserSub    string   `dynamodbav:"UserSub" json:"userSub"`
+	Email      string   `dynamodbav:"Email,omitempty" json:"email,omitempty"`
+	Kind       string   `dynamodbav:"Kind" json:"kind"`
+	Message    string   `dynamodbav:"Message" json:"message"`
+	Page       string   `dynamodbav:"Page,omitempty" json:"page,omitempty"`
+	RequestIds []string `dynamodbav:"RequestIds,omitempty,stringset" json:"requestIds,omitempty"`
+	Shops      []string `dynamodbav:"Shops,omitempty,stringset" json:"shops,omitempty"`
+	UserAgent  string   `dynamodbav:"UserAgent,omitempty" json:"userAgent,omitempty"`
+	AppVersion string   `dynamodbav:"AppVersion,omitempty" json:"appVersion,omitempty"`
+	CreatedAt  string   `dynamodbav:"CreatedAt" json:"createdAt"`
+}
+
+type reportItem struct {
+	PK string `dynamodbav:"PK"`
+	SK string `dynamodbav:"SK"`
+	Report
+}
+
+func monthPK(month string) string {
+	return fmt.Sprintf("FEEDBACK#%s", month)
+}
+
+// Save stores r, assigning Id and CreatedAt.
+func Save(ctx context.Context, ddb *dynamodb.Client, r *Report) error {
+	tbl := Table()
+	if tbl == "" {
+		return fmt.Errorf("FEEDBACK_TABLE not set")
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	r.Id = hex.EncodeToString(b)
+	r.CreatedAt = now.Format(time.RFC3339)
+
+	item, err := attributevalue.MarshalMap(reportItem{
+		PK:     monthPK(now.Format("2006-01")),
+		SK:     r.CreatedAt + "#" + r.Id,
+		Report: *r,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(tbl), Item: item})
+	if err != nil {
+		return fmt.Errorf("put feedback: %w", err)
+	}
+	return nil
+}
+
+// ListMonth returns a month's reports (YYYY-MM), newest first.
+func ListMonth(ctx context.Context, ddb *dynamodb.Client, month string, limit int32) ([]Report, error) {
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(Table()),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: monthPK(month)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query feedback: %w", err)
+	}
+	var items []reportItem
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
+		return nil, err
+	}
+	reports := make([]Report, 0, len(items))
+	for _, it := range items {
+		reports = append(reports, it.Report)
+	}
+	return reports, nil
+}
@@ -0,0 +1,303 @@
+package feerules
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/cache"
+	"backend/internal/restate"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Fee rules are percent-of-revenue costs ("2% platform fee on Shopify
+// Sales", "3% affiliate commission on orders tagged affiliate") that order
+// ingestion and the order backfill turn into expense transactions linked to
+// the order they were charged on.
+//
+// Layout of FEE_RULES_TABLE:
+//
+//	PK = USER#<sub>, SK = RULE#<id>
+//
+// A fee gets SK FEE#<rule id>#<order SK> and Source "fee_rule", so
+// re-ingesting an order rewrites its fee instead of adding another one.
+
+const (
+	// Source marks materialized fees.
+	Source = "fee_rule"
+
+	DefaultCategory  = "Fees"
+	DefaultAppliesTo = "Shopify Sales"
+)
+
+var ErrNotFound = errors.New("rule not found")
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("FEE_RULES_TABLE"))
+}
+
+type Rule struct {
+	Id        string  `dynamodbav:"RuleId" json:"id"`
+	UserSub   string  `dynamodbav:"UserSub" json:"-"`
+	Name      string  `dynamodbav:"Name" json:"name"`
+	Percent   float64 `dynamodbav:"Percent" json:"percent"`             // of the order amount, 0-100
+	Category  string  `dynamodbav:"Category" json:"category"`           // expense category posted
+	AppliesTo string  `dynamodbav:"AppliesTo" json:"appliesTo"`         // income category it is charged on
+	Shop      string  `dynamodbav:"Shop,omitempty" json:"shop"`         // "" = every shop
+	OrderTag  string  `dynamodbav:"OrderTag,omitempty" json:"orderTag"` // "" = every order
+	CreatedAt string  `dynamodbav:"CreatedAt" json:"createdAt"`
+}
+
+// Validate normalizes and checks a user-supplied rule.
+func (r *Rule) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Category = strings.TrimSpace(r.Category)
+	r.AppliesTo = strings.TrimSpace(r.AppliesTo)
+	r.Shop = strings.ToLower(strings.TrimSpace(r.Shop))
+	r.OrderTag = strings.TrimSpace(r.OrderTag)
+	if r.Category == "" {
+		r.Category = DefaultCategory
+	}
+	if r.AppliesTo == "" {
+		r.AppliesTo = DefaultAppliesTo
+	}
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.Percent <= 0 || r.Percent > 100 {
+		return fmt.Errorf("percent must be greater than 0 and at most 100")
+	}
+	if strings.EqualFold(r.Category, r.AppliesTo) {
+		return fmt.Errorf("category must differ from appliesTo")
+	}
+	return nil
+}
+
+// Order is what a rule looks at on an ingested row.
+type Order struct {
+	SK        string   `dynamodbav:"SK"`
+	GSI1PK    string   `dynamodbav:"GSI1PK"`
+	GSI1SK    string   `dynamodbav:"GSI1SK"`
+	CreatedAt string   `dynamodbav:"CreatedAt"`
+	Amount    float64  `dynamodbav:"Amount"`
+	Currency  string   `dynamodbav:"Currency"`
+	Category  string   `dynamodbav:"Category"`
+	Shop      string   `dynamodbav:"Shop"`
+	OrderName string   `dynamodbav:"OrderName"`
+	Tags      []string `dynamodbav:"OrderTags,stringset"`
+}
+
+// Matches reports whether r charges a fee on o.
+func (r Rule) Matches(o Order) bool {
+	if !strings.EqualFold(o.Category, r.AppliesTo) {
+		return false
+	}
+	if r.Shop != "" && r.Shop != o.Shop {
+		return false
+	}
+	if r.OrderTag == "" {
+		return true
+	}
+	for _, t := range o.Tags {
+		if strings.EqualFold(strings.TrimSpace(t), r.OrderTag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fee is r's charge on o, in cents-rounded currency units; negative like
+// any expense, and positive when o is itself negative (a refund rule gives
+// the fee back).
+func (r Rule) Fee(o Order) float64 {
+	return -math.Round(o.Amount*r.Percent) / 100
+}
+
+func ruleKey(sub, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: "RULE#" + id},
+	}
+}
+
+// rulesCache holds ForUser results; ingestion looks the rules up once per
+// order and user.
+var rulesCache = cache.New[string, []Rule](1000, cache.TTL())
+
+// Put creates or replaces a rule. Fees already posted keep their amount;
+// the new terms apply from the next time an order is ingested.
+func Put(ctx context.Context, ddb *dynamodb.Client, sub string, r Rule) (Rule, error) {
+	if Table() == "" {
+		return r, fmt.Errorf("FEE_RULES_TABLE not set")
+	}
+	if r.Id == "" {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return r, err
+		}
+		r.Id = hex.EncodeToString(b)
+		r.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	r.UserSub = sub
+
+	item, err := attributevalue.MarshalMap(r)
+	if err != nil {
+		return r, err
+	}
+	for k, v := range ruleKey(sub, r.Id) {
+		item[k] = v
+	}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(Table()), Item: item}); err != nil {
+		return r, fmt.Errorf("put fee rule: %w", err)
+	}
+	rulesCache.Delete(sub)
+	return r, nil
+}
+
+func Get(ctx context.Context, ddb *dynamodb.Client, sub, id string) (Rule, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(Table()), Key: ruleKey(sub, id)})
+	if err != nil {
+		return Rule{}, fmt.Errorf("get fee rule: %w", err)
+	}
+	if out.Item == nil {
+		return Rule{}, ErrNotFound
+	}
+	var r Rule
+	err = attributevalue.UnmarshalMap(out.Item, &r)
+	return r, err
+}
+
+func List(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Rule, error) {
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(Table()),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			":p":  &types.AttributeValueMemberS{Value: "RULE#"},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query fee rules: %w", err)
+	}
+	rules := []Rule{}
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &rules)
+	return rules, err
+}
+
+// Delete stops a rule. Fees it already posted stay.
+func Delete(ctx context.Context, ddb *dynamodb.Client, sub, id string) error {
+	_, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(Table()), Key: ruleKey(sub, id)})
+	if err == nil {
+		rulesCache.Delete(sub)
+	}
+	return err
+}
+
+// ForUser is List through the cache. Without FEE_RULES_TABLE there are no
+// rules, so ingestion runs unchanged where the table isn't deployed.
+func ForUser(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Rule, error) {
+	if Table() == "" {
+		return nil, nil
+	}
+	if rules, ok := rulesCache.Get(sub); ok {
+		return rules, nil
+	}
+	rules, err := List(ctx, ddb, sub)
+	if err != nil {
+		return nil, err
+	}
+	rulesCache.Set(sub, rules)
+	return rules, nil
+}
+
+// Apply brings the fees on one ingested row in line with sub's rules: a
+// matching rule (re)writes its fee, and when the row was already stored
+// (existed) a rule that no longer matches, e.g. after a tag was removed,
+// takes its fee back. A fee the user deleted stays deleted.
+func Apply(ctx context.Context, ddb *dynamodb.Client, txTable, sub string, row map[string]types.AttributeValue, existed bool) error {
+	rules, err := ForUser(ctx, ddb, sub)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	var o Order
+	if err := attributevalue.UnmarshalMap(row, &o); err != nil {
+		return fmt.Errorf("decode row: %w", err)
+	}
+	if o.SK == "" || o.GSI1PK == "" {
+		return nil
+	}
+
+	var cfe *types.ConditionalCheckFailedException
+	for _, r := range rules {
+		key := map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("FEE#%s#%s", r.Id, o.SK)},
+		}
+		fee := r.Fee(o)
+		if !r.Matches(o) || fee == 0 {
+			if !existed {
+				continue
+			}
+			_, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName:           aws.String(txTable),
+				Key:                 key,
+				ConditionExpression: aws.String("Source = :src AND attribute_not_exists(DeletedAt)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":src": &types.AttributeValueMemberS{Value: Source},
+				},
+			})
+			if err != nil && !errors.As(err, &cfe) {
+				return fmt.Errorf("remove fee %s: %w", r.Id, err)
+			}
+			continue
+		}
+
+		note := fmt.Sprintf("%s %g%%", r.Name, r.Percent)
+		if o.OrderName != "" {
+			note += " on " + o.OrderName
+		}
+		item := map[string]types.AttributeValue{
+			"GSI1PK":    &types.AttributeValueMemberS{Value: o.GSI1PK},
+			"GSI1SK":    &types.AttributeValueMemberS{Value: o.GSI1SK},
+			"UserSub":   &types.AttributeValueMemberS{Value: sub},
+			"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", fee)},
+			"Currency":  &types.AttributeValueMemberS{Value: o.Currency},
+			"Category":  &types.AttributeValueMemberS{Value: r.Category},
+			"Note":      &types.AttributeValueMemberS{Value: note},
+			"CreatedAt": &types.AttributeValueMemberS{Value: o.CreatedAt},
+			"Source":    &types.AttributeValueMemberS{Value: Source},
+			"RuleId":    &types.AttributeValueMemberS{Value: r.Id},
+			"LinkedTo":  &types.AttributeValueMemberS{Value: o.SK},
+		}
+		for k, v := range key {
+			item[k] = v
+		}
+		if o.Shop != "" {
+			item["Shop"] = &types.AttributeValueMemberS{Value: o.Shop}
+		}
+		restate.Stamp(item)
+
+		_, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(txTable),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(PK) OR (Source = :src AND attribute_not_exists(DeletedAt))"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":src": &types.AttributeValueMemberS{Value: Source},
+			},
+		})
+		if err != nil && !errors.As(err, &cfe) {
+			return fmt.Errorf("put fee %s: %w", r.Id, err)
+		}
+	}
+	return nil
+}
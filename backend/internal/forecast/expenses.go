@@ -0,0 +1,263 @@
+package forecast
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// MonthTotals is one historical month of a user's transactions, bucketed.
+// Expense amounts are positive numbers.
+type MonthTotals struct {
+	Month    string // YYYY-MM
+	Income   float64
+	Expenses map[string]float64 // category -> spend
+}
+
+type CategoryKind string
+
+const (
+	KindFixed    CategoryKind = "fixed"
+	KindVariable CategoryKind = "variable"
+)
+
+type CategoryModel struct {
+	Category string       `json:"category"`
+	Kind     CategoryKind `json:"kind"`
+	Monthly  float64      `json:"monthly,omitempty"`      // fixed: projected spend per month
+	Ratio    float64      `json:"revenueRatio,omitempty"` // variable: spend as share of revenue
+}
+
+type ProjectedMonth struct {
+	Month           string             `json:"month"`
+	Revenue         float64            `json:"revenue"`
+	FixedCosts      float64            `json:"fixedCosts"`
+	VariableCosts   float64            `json:"variableCosts"`
+	TotalExpenses   float64            `json:"totalExpenses"`
+	ProjectedProfit float64            `json:"projectedProfit"`
+	ByCategory      map[string]float64 `json:"byCategory"`
+}
+
+type ExpenseForecast struct {
+	HistoryMonths int              `json:"historyMonths"`
+	Categories    []CategoryModel  `json:"categories"`
+	Months        []ProjectedMonth `json:"months"`
+}
+
+// Fixed-cost heuristic: a category that shows up in most months with little
+// month-to-month variation is treated as a fixed cost (rent, SaaS, salaries).
+const (
+	fixedPresenceRatio = 0.75
+	fixedMaxCV         = 0.2
+)
+
+// ProjectExpenses builds a per-month projection starting at the month after
+// lastMonth. Revenue follows a least-squares trend over history, fixed costs
+// repeat at their historical mean, and variable costs scale with revenue.
+func ProjectExpenses(history []MonthTotals, lastMonth time.Time, months int) ExpenseForecast {
+	out := ExpenseForecast{
+		HistoryMonths: len(history),
+		Categories:    []CategoryModel{},
+		Months:        []ProjectedMonth{},
+	}
+	if months <= 0 {
+		return out
+	}
+
+	models := classifyCategories(history)
+	out.Categories = models
+
+	revenue := make([]float64, len(history))
+	for i, h := range history {
+		revenue[i] = h.Income
+	}
+	slope, intercept := linearTrend(revenue)
+
+	start := time.Date(lastMonth.Year(), lastMonth.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= months; i++ {
+		m := ProjectedMonth{
+			Month:      start.AddDate(0, i, 0).Format("2006-01"),
+			ByCategory: map[string]float64{},
+		}
+		m.Revenue = round2(math.Max(0, intercept+slope*float64(len(history)-1+i)))
+
+		for _, cm := range models {
+			switch cm.Kind {
+			case KindFixed:
+				m.FixedCosts += cm.Monthly
+				m.ByCategory[cm.Category] = round2(cm.Monthly)
+			case KindVariable:
+				v := cm.Ratio * m.Revenue
+				m.VariableCosts += v
+				m.ByCategory[cm.Category] = round2(v)
+			}
+		}
+
+		m.FixedCosts = round2(m.FixedCosts)
+		m.VariableCosts = round2(m.VariableCosts)
+		m.TotalExpenses = round2(m.FixedCosts + m.VariableCosts)
+		m.ProjectedProfit = round2(m.Revenue - m.TotalExpenses)
+		out.Months = append(out.Months, m)
+	}
+
+	return out
+}
+
+func classifyCategories(history []MonthTotals) []CategoryModel {
+	if len(history) == 0 {
+		return []CategoryModel{}
+	}
+
+	series := map[string][]float64{}
+	totalIncome := 0.0
+	for i, h := range history {
+		totalIncome += h.Income
+		for cat, v := range h.Expenses {
+			if _, ok := series[cat]; !ok {
+				series[cat] = make([]float64, len(history))
+			}
+			series[cat][i] = v
+		}
+	}
+
+	cats := make([]string, 0, len(series))
+	for c := range series {
+		cats = append(cats, c)
+	}
+	sort.Strings(cats)
+
+	out := make([]CategoryModel, 0, len(cats))
+	for _, cat := range cats {
+		vals := series[cat]
+
+		present, sum := 0, 0.0
+		for _, v := range vals {
+			if v > 0 {
+				present++
+			}
+			sum += v
+		}
+		mean := sum / float64(len(vals))
+
+		if float64(present)/float64(len(vals)) >= fixedPresenceRatio && coeffOfVariation(vals) <= fixedMaxCV {
+			out = append(out, CategoryModel{Category: cat, Kind: KindFixed, Monthly: round2(mean)})
+			continue
+		}
+
+		ratio := 0.0
+		if totalIncome > 0 {
+			ratio = sum / totalIncome
+		}
+		out = append(out, CategoryModel{Category: cat, Kind: KindVariable, Ratio: math.Round(ratio*10000) / 10000})
+	}
+	return out
+}
+
+func coeffOfVariation(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	mean := 0.0
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+	if mean == 0 {
+		return 0
+	}
+	varSum := 0.0
+	for _, v := range vals {
+		varSum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(varSum/float64(len(vals))) / mean
+}
+
+// linearTrend returns slope and intercept of a least-squares fit over x = 0..n-1.
+func linearTrend(y []float64) (slope, intercept float64) {
+	n := float64(len(y))
+	if n == 0 {
+		return 0, 0
+	}
+	if n == 1 {
+		return 0, y[0]
+	}
+	var sx, sy, sxy, sxx float64
+	for i, v := range y {
+		x := float64(i)
+		sx += x
+		sy += v
+		sxy += x * v
+		sxx += x * x
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, sy / n
+	}
+	slope = (n*sxy - sx*sy) / den
+	intercept = (sy - slope*sx) / n
+	return slope, intercept
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+type Runway struct {
+	Status        string   `json:"status"` // profitable | burning | unknown_balance
+	CashBalance   *float64 `json:"cashBalance"`
+	MonthlyBurn   float64  `json:"monthlyBurn"`
+	RunwayMonths  *float64 `json:"runwayMonths"`
+	ZeroCashMonth string   `json:"zeroCashMonth,omitempty"`
+}
+
+// ComputeRunway derives burn from the projected months (average projected loss)
+// and, when a cash balance is known, how many months until it runs out.
+func ComputeRunway(cash *float64, months []ProjectedMonth) Runway {
+	out := Runway{Status: "profitable", CashBalance: cash}
+	if len(months) == 0 {
+		return out
+	}
+
+	total := 0.0
+	for _, m := range months {
+		total += m.ProjectedProfit
+	}
+	avg := total / float64(len(months))
+	if avg >= 0 {
+		return out
+	}
+	out.MonthlyBurn = round2(-avg)
+
+	if cash == nil {
+		out.Status = "unknown_balance"
+		return out
+	}
+	out.Status = "burning"
+
+	if *cash <= 0 {
+		zero := 0.0
+		out.RunwayMonths = &zero
+		return out
+	}
+
+	// Walk the projection first so an uneven burn is honored, then extrapolate.
+	bal := *cash
+	for i, m := range months {
+		next := bal + m.ProjectedProfit
+		if next < 0 {
+			r := round2(float64(i) + bal/(-m.ProjectedProfit))
+			out.RunwayMonths = &r
+			out.ZeroCashMonth = m.Month
+			return out
+		}
+		bal = next
+	}
+
+	r := round2(float64(len(months)) + bal/out.MonthlyBurn)
+	out.RunwayMonths = &r
+	if last, err := time.Parse("2006-01", months[len(months)-1].Month); err == nil {
+		extra := int(math.Ceil(bal / out.MonthlyBurn))
+		out.ZeroCashMonth = last.AddDate(0, extra, 0).Format("2006-01")
+	}
+	return out
+}
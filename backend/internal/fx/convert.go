@@ -0,0 +1,69 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Converter converts amounts using stored snapshots. It caches one snapshot per
+// date, so a worker converting thousands of rows hits the table once per day.
+type Converter struct {
+	ddb   *dynamodb.Client
+	cache map[string]*Rates
+}
+
+func NewConverter(ddb *dynamodb.Client) *Converter {
+	return &Converter{ddb: ddb, cache: map[string]*Rates{}}
+}
+
+// Convert converts amount from one currency to another at the rates in force on
+// date (YYYY-MM-DD; the latest snapshot on or before it). Same-currency and
+// empty-currency conversions are returned unchanged.
+func (c *Converter) Convert(ctx context.Context, amount float64, from, to, date string) (float64, error) {
+	from = strings.ToUpper(strings.TrimSpace(from))
+	to = strings.ToUpper(strings.TrimSpace(to))
+	if from == "" || to == "" || from == to {
+		return amount, nil
+	}
+	if len(date) > 10 {
+		date = date[:10]
+	}
+
+	r, ok := c.cache[date]
+	if !ok {
+		var err error
+		r, err = GetRates(ctx, c.ddb, PivotBase(), date)
+		if err != nil {
+			return 0, err
+		}
+		c.cache[date] = r
+	}
+	return convertWith(r, amount, from, to)
+}
+
+// Convert is the one-off form of Converter.Convert.
+func Convert(ctx context.Context, ddb *dynamodb.Client, amount float64, from, to, date string) (float64, error) {
+	return NewConverter(ddb).Convert(ctx, amount, from, to, date)
+}
+
+func convertWith(r *Rates, amount float64, from, to string) (float64, error) {
+	rate := func(cur string) (float64, bool) {
+		if cur == r.Base {
+			return 1, true
+		}
+		v, ok := r.Rates[cur]
+		return v, ok
+	}
+	fromRate, ok := rate(from)
+	if !ok || fromRate == 0 {
+		return 0, fmt.Errorf("no %s rate for %s", from, r.Date)
+	}
+	toRate, ok := rate(to)
+	if !ok {
+		return 0, fmt.Errorf("no %s rate for %s", to, r.Date)
+	}
+	return amount / fromRate * toRate, nil
+}
@@ -0,0 +1,168 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const (
+	SourceECB = "ecb"
+	SourceOXR = "openexchangerates"
+
+	ecbDailyURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+	oxrBaseURL  = "https://openexchangerates.org/api"
+)
+
+var httpClient = &http.Client{Timeout: 20 * time.Second}
+
+// Fetch pulls the latest daily snapshot. Open Exchange Rates is used when
+// OPEN_EXCHANGE_RATES_APP_ID is set (wider currency coverage), otherwise the
+// free ECB reference rates. The result is rebased to PivotBase().
+func Fetch(ctx context.Context) (*Rates, error) {
+	var (
+		r   *Rates
+		err error
+	)
+	if appID := strings.TrimSpace(os.Getenv("OPEN_EXCHANGE_RATES_APP_ID")); appID != "" {
+		r, err = fetchOXR(ctx, appID)
+	} else {
+		r, err = fetchECB(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.FetchedAt = time.Now().UTC().Format(time.RFC3339)
+	return Rebase(r, PivotBase())
+}
+
+// Save stores a snapshot under the pivot base. Re-fetching the same date
+// overwrites it, so the job is safe to re-run.
+func Save(ctx context.Context, ddb *dynamodb.Client, r *Rates) error {
+	tbl := strings.TrimSpace(db.FxRatesTableName())
+	if tbl == "" {
+		return fmt.Errorf("FX_RATES_TABLE not set")
+	}
+	if r.Base != PivotBase() {
+		rb, err := Rebase(r, PivotBase())
+		if err != nil {
+			return err
+		}
+		r = rb
+	}
+
+	item, err := attributevalue.MarshalMap(ratesItem{
+		PK:    ratesPK(r.Base),
+		SK:    ratesSK(r.Date),
+		Rates: *r,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal fx rates: %w", err)
+	}
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tbl),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("put fx rates: %w", err)
+	}
+	return nil
+}
+
+func get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("fx GET %s: status %d", url, res.StatusCode)
+	}
+	return raw, nil
+}
+
+type ecbEnvelope struct {
+	Cube struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+func fetchECB(ctx context.Context) (*Rates, error) {
+	raw, err := get(ctx, ecbDailyURL)
+	if err != nil {
+		return nil, err
+	}
+	var env ecbEnvelope
+	if err := xml.Unmarshal(raw, &env); err != nil {
+		return nil, fmt.Errorf("parse ecb xml: %w", err)
+	}
+	if len(env.Cube.Days) == 0 || len(env.Cube.Days[0].Rates) == 0 {
+		return nil, fmt.Errorf("ecb: empty response")
+	}
+
+	day := env.Cube.Days[0]
+	r := &Rates{
+		Base:   "EUR",
+		Date:   day.Time,
+		Rates:  make(map[string]float64, len(day.Rates)+1),
+		Source: SourceECB,
+	}
+	for _, x := range day.Rates {
+		v, err := strconv.ParseFloat(x.Rate, 64)
+		if err != nil || v <= 0 {
+			continue
+		}
+		r.Rates[strings.ToUpper(x.Currency)] = v
+	}
+	r.Rates["EUR"] = 1
+	return r, nil
+}
+
+func fetchOXR(ctx context.Context, appID string) (*Rates, error) {
+	raw, err := get(ctx, oxrBaseURL+"/latest.json?app_id="+appID)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Timestamp int64              `json:"timestamp"`
+		Base      string             `json:"base"`
+		Rates     map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("parse oxr json: %w", err)
+	}
+	if body.Base == "" || len(body.Rates) == 0 {
+		return nil, fmt.Errorf("oxr: empty response")
+	}
+
+	return &Rates{
+		Base:   strings.ToUpper(body.Base),
+		Date:   time.Unix(body.Timestamp, 0).UTC().Format("2006-01-02"),
+		Rates:  body.Rates,
+		Source: SourceOXR,
+	}, nil
+}
@@ -0,0 +1,119 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Rates is one day's snapshot of exchange rates: 1 Base = Rates[X] units of X.
+type Rates struct {
+	Base      string             `dynamodbav:"Base" json:"base"`
+	Date      string             `dynamodbav:"Date" json:"date"` // YYYY-MM-DD
+	Rates     map[string]float64 `dynamodbav:"Rates" json:"rates"`
+	Source    string             `dynamodbav:"Source" json:"source"`
+	FetchedAt string             `dynamodbav:"FetchedAt" json:"fetchedAt"`
+}
+
+// ratesItem mirrors the FX_RATES_TABLE layout.
+// PK = BASE#<pivot>
+// SK = DATE#<YYYY-MM-DD>
+type ratesItem struct {
+	PK string `dynamodbav:"PK"`
+	SK string `dynamodbav:"SK"`
+	Rates
+}
+
+var ErrNoRates = errors.New("no fx rates available")
+
+// PivotBase is the currency rates are stored against; other bases are derived by cross rates.
+func PivotBase() string {
+	b := strings.ToUpper(strings.TrimSpace(os.Getenv("FX_PIVOT_BASE")))
+	if b == "" {
+		return "USD"
+	}
+	return b
+}
+
+func ratesPK(base string) string {
+	return fmt.Sprintf("BASE#%s", base)
+}
+
+func ratesSK(date string) string {
+	return fmt.Sprintf("DATE#%s", date)
+}
+
+// GetRates returns the most recent stored snapshot on or before date (YYYY-MM-DD),
+// rebased to base. An empty date means the latest snapshot.
+func GetRates(ctx context.Context, ddb *dynamodb.Client, base, date string) (*Rates, error) {
+	tbl := strings.TrimSpace(db.FxRatesTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("FX_RATES_TABLE not set")
+	}
+
+	keyCond := "PK = :pk"
+	vals := map[string]types.AttributeValue{
+		":pk": &types.AttributeValueMemberS{Value: ratesPK(PivotBase())},
+	}
+	if strings.TrimSpace(date) != "" {
+		keyCond += " AND SK <= :sk"
+		vals[":sk"] = &types.AttributeValueMemberS{Value: ratesSK(date)}
+	}
+
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(tbl),
+		KeyConditionExpression:    aws.String(keyCond),
+		ExpressionAttributeValues: vals,
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query fx rates: %w", err)
+	}
+	if len(out.Items) == 0 {
+		return nil, ErrNoRates
+	}
+
+	var it ratesItem
+	if err := attributevalue.UnmarshalMap(out.Items[0], &it); err != nil {
+		return nil, fmt.Errorf("unmarshal fx rates: %w", err)
+	}
+
+	return Rebase(&it.Rates, base)
+}
+
+// Rebase converts a snapshot to a different base using cross rates.
+func Rebase(r *Rates, base string) (*Rates, error) {
+	base = strings.ToUpper(strings.TrimSpace(base))
+	if base == "" || base == r.Base {
+		return r, nil
+	}
+
+	pivotToBase, ok := r.Rates[base]
+	if !ok || pivotToBase == 0 {
+		return nil, fmt.Errorf("unsupported base currency: %s", base)
+	}
+
+	out := &Rates{
+		Base:      base,
+		Date:      r.Date,
+		Rates:     make(map[string]float64, len(r.Rates)+1),
+		Source:    r.Source,
+		FetchedAt: r.FetchedAt,
+	}
+	for cur, v := range r.Rates {
+		out.Rates[cur] = v / pivotToBase
+	}
+	out.Rates[r.Base] = 1 / pivotToBase
+	out.Rates[base] = 1
+	return out, nil
+}
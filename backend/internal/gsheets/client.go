@@ -0,0 +1,164 @@
+package gsheets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	authorizeEndpoint = "https://accounts.google.com/o/oauth2/v2/auth"
+	tokenEndpoint     = "https://oauth2.googleapis.com/token"
+	sheetsEndpoint    = "https://sheets.googleapis.com/v4/spreadsheets"
+
+	// drive.file would be narrower but cannot see spreadsheets the user
+	// created outside the app, which is the common case here.
+	Scope = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+// AuthorizeURL builds the Google consent URL. access_type=offline and
+// prompt=consent make Google return a refresh token every time.
+func AuthorizeURL(state, redirectURI string) string {
+	q := url.Values{}
+	q.Set("client_id", os.Getenv("GOOGLE_CLIENT_ID"))
+	q.Set("redirect_uri", redirectURI)
+	q.Set("response_type", "code")
+	q.Set("scope", Scope)
+	q.Set("access_type", "offline")
+	q.Set("prompt", "consent")
+	q.Set("include_granted_scopes", "true")
+	q.Set("state", state)
+	return authorizeEndpoint + "?" + q.Encode()
+}
+
+type tokenResp struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	ErrorDesc    string `json:"error_description"`
+}
+
+// ExchangeCode trades the consent code for a refresh token.
+func ExchangeCode(ctx context.Context, code, redirectURI string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", os.Getenv("GOOGLE_CLIENT_ID"))
+	form.Set("client_secret", os.Getenv("GOOGLE_CLIENT_SECRET"))
+
+	tok, err := postToken(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	if tok.RefreshToken == "" {
+		return "", fmt.Errorf("google: no refresh_token in response")
+	}
+	return tok.RefreshToken, nil
+}
+
+// AccessToken mints a short-lived access token from a stored refresh token.
+func AccessToken(ctx context.Context, refreshToken string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	form.Set("client_id", os.Getenv("GOOGLE_CLIENT_ID"))
+	form.Set("client_secret", os.Getenv("GOOGLE_CLIENT_SECRET"))
+
+	tok, err := postToken(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("google: no access_token in response")
+	}
+	return tok.AccessToken, nil
+}
+
+func postToken(ctx context.Context, form url.Values) (*tokenResp, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+	var tok tokenResp
+	_ = json.Unmarshal(raw, &tok)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		if tok.Error != "" {
+			return nil, fmt.Errorf("google token failed: %s: %s", tok.Error, tok.ErrorDesc)
+		}
+		return nil, fmt.Errorf("google token failed: http %d: %s", res.StatusCode, string(raw))
+	}
+	return &tok, nil
+}
+
+// SpreadsheetTitle fetches the title, doubling as an access check for a target spreadsheet.
+func SpreadsheetTitle(ctx context.Context, accessToken, spreadsheetID string) (string, error) {
+	u := fmt.Sprintf("%s/%s?fields=properties.title", sheetsEndpoint, url.PathEscape(spreadsheetID))
+
+	var out struct {
+		Properties struct {
+			Title string `json:"title"`
+		} `json:"properties"`
+	}
+	if err := doSheets(ctx, http.MethodGet, u, accessToken, nil, &out); err != nil {
+		return "", err
+	}
+	return out.Properties.Title, nil
+}
+
+// AppendRows appends rows below the last row of sheetName.
+func AppendRows(ctx context.Context, accessToken, spreadsheetID, sheetName string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	rng := fmt.Sprintf("'%s'!A1", strings.ReplaceAll(sheetName, "'", "''"))
+	u := fmt.Sprintf("%s/%s/values/%s:append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS",
+		sheetsEndpoint, url.PathEscape(spreadsheetID), url.PathEscape(rng))
+
+	body := map[string]any{"values": rows}
+	return doSheets(ctx, http.MethodPost, u, accessToken, body, nil)
+}
+
+func doSheets(ctx context.Context, method, u, accessToken string, body any, out any) error {
+	var rdr io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rdr = bytes.NewReader(b)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, method, u, rdr)
+	req.Header.Set("authorization", "Bearer "+accessToken)
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("sheets api: http %d: %s", res.StatusCode, string(raw))
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("sheets api unmarshal: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package gsheets
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/etl"
+	"backend/internal/live"
+	"backend/internal/security"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	Source  = "gsheets"
+	sortKey = "GSHEETS"
+
+	// maxCatchUpDays bounds how many missed days one run appends.
+	maxCatchUpDays = 7
+)
+
+// Integration mirrors the Google Sheets item in INTEGRATIONS_TABLE.
+// PK = USER#<sub>
+// SK = GSHEETS
+type Integration struct {
+	PK               string `dynamodbav:"PK" json:"-"`
+	SK               string `dynamodbav:"SK" json:"-"`
+	RefreshTokenEnc  string `dynamodbav:"RefreshTokenEnc" json:"-"`
+	SpreadsheetId    string `dynamodbav:"SpreadsheetId,omitempty" json:"spreadsheetId"`
+	SpreadsheetTitle string `dynamodbav:"SpreadsheetTitle,omitempty" json:"spreadsheetTitle"`
+	SheetName        string `dynamodbav:"SheetName,omitempty" json:"sheetName"`
+	LastExportedDate string `dynamodbav:"LastExportedDate,omitempty" json:"lastExportedDate"`
+	LastExportError  string `dynamodbav:"LastExportError,omitempty" json:"lastExportError"`
+	CreatedAt        string `dynamodbav:"CreatedAt" json:"createdAt"`
+}
+
+func (i Integration) UserSub() string {
+	return strings.TrimPrefix(i.PK, "USER#")
+}
+
+var Header = []any{"date", "shop", "gross_revenue", "net_revenue", "product_costs", "marketing_costs", "fulfillment_costs", "processing_fees", "other_costs"}
+
+func key(sub string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+		"SK": &types.AttributeValueMemberS{Value: sortKey},
+	}
+}
+
+func LoadIntegration(ctx context.Context, ddb *dynamodb.Client, sub string) (*Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key:       key(sub),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var integ Integration
+	if err := attributevalue.UnmarshalMap(out.Item, &integ); err != nil {
+		return nil, err
+	}
+	return &integ, nil
+}
+
+// ListIntegrations returns every user's Google Sheets integration.
+func ListIntegrations(ctx context.Context, ddb *dynamodb.Client) ([]Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	var items []map[string]types.AttributeValue
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(tbl),
+			FilterExpression: aws.String("SK = :sk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":sk": &types.AttributeValueMemberS{Value: sortKey},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+
+	var out []Integration
+	if err := attributevalue.UnmarshalListOfMaps(items, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveRefreshToken stores (or replaces) the encrypted refresh token, keeping any target already chosen.
+func SaveRefreshToken(ctx context.Context, ddb *dynamodb.Client, sub, refreshTokenEnc string) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              key(sub),
+		UpdateExpression: aws.String("SET Provider = :p, RefreshTokenEnc = :t, CreatedAt = if_not_exists(CreatedAt, :now)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":p":   &types.AttributeValueMemberS{Value: Source},
+			":t":   &types.AttributeValueMemberS{Value: refreshTokenEnc},
+			":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	return err
+}
+
+// SetTarget points the exporter at a spreadsheet/tab. Switching spreadsheets
+// restarts the export (header row, then from yesterday).
+func SetTarget(ctx context.Context, ddb *dynamodb.Client, integ *Integration, spreadsheetID, title, sheetName string) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+
+	expr := "SET SpreadsheetId = :id, SpreadsheetTitle = :title, SheetName = :sheet"
+	if integ.SpreadsheetId != spreadsheetID || integ.SheetName != sheetName {
+		expr += " REMOVE LastExportedDate, LastExportError"
+	}
+
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              key(integ.UserSub()),
+		UpdateExpression: aws.String(expr),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id":    &types.AttributeValueMemberS{Value: spreadsheetID},
+			":title": &types.AttributeValueMemberS{Value: title},
+			":sheet": &types.AttributeValueMemberS{Value: sheetName},
+		},
+	})
+	return err
+}
+
+// DecryptedAccessToken mints an access token from the integration's refresh token.
+func DecryptedAccessToken(ctx context.Context, integ *Integration) (string, error) {
+	k, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	refresh, err := security.DecryptAESGCM(k, integ.RefreshTokenEnc)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+	return AccessToken(ctx, refresh)
+}
+
+type ExportResult struct {
+	Days []string `json:"days"`
+	Rows int      `json:"rows"`
+}
+
+// Export appends one row per shop per day for every day after LastExportedDate
+// up to and including `through` (YYYY-MM-DD, in ETL_TIMEZONE), at most
+// maxCatchUpDays days per run. The first export also writes a header row.
+func Export(ctx context.Context, ddb *dynamodb.Client, integ *Integration, through string) (*ExportResult, error) {
+	if integ.SpreadsheetId == "" || integ.SheetName == "" {
+		return nil, fmt.Errorf("no target spreadsheet selected")
+	}
+	txTable := strings.TrimSpace(db.TransactionsTableName())
+	if txTable == "" {
+		return nil, fmt.Errorf("TRANSACTIONS_TABLE not set")
+	}
+
+	end, err := time.Parse("2006-01-02", through)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", through)
+	}
+	start := end
+	if integ.LastExportedDate != "" {
+		last, err := time.Parse("2006-01-02", integ.LastExportedDate)
+		if err == nil {
+			start = last.AddDate(0, 0, 1)
+		}
+	}
+	if start.Before(end.AddDate(0, 0, -(maxCatchUpDays - 1))) {
+		start = end.AddDate(0, 0, -(maxCatchUpDays - 1))
+	}
+
+	res := &ExportResult{}
+	if start.After(end) {
+		return res, nil
+	}
+
+	sub := integ.UserSub()
+	shops, err := tenancy.GetAllowedShopsByUserSub(ctx, ddb, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]any
+	if integ.LastExportedDate == "" {
+		rows = append(rows, Header)
+	}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		for _, shop := range shops {
+			r, _, err := etl.BuildDailyMetricsRow(ctx, ddb, txTable, shop, day)
+			if err != nil {
+				return nil, fmt.Errorf("build row shop=%s dt=%s: %w", shop, day, err)
+			}
+			rows = append(rows, []any{
+				r.MetricDate, r.MerchantID,
+				round2(r.GrossRevenue), round2(r.NetRevenue), round2(r.ProductCosts), round2(r.MarketingCosts),
+				round2(r.FulfillmentCosts), round2(r.ProcessingFees), round2(r.OtherCosts),
+			})
+			res.Rows++
+		}
+		res.Days = append(res.Days, day)
+	}
+
+	accessToken, err := DecryptedAccessToken(ctx, integ)
+	if err != nil {
+		recordExport(ctx, ddb, sub, "", err.Error())
+		return nil, err
+	}
+	if err := AppendRows(ctx, accessToken, integ.SpreadsheetId, integ.SheetName, rows); err != nil {
+		recordExport(ctx, ddb, sub, "", err.Error())
+		return nil, err
+	}
+
+	integ.LastExportedDate = through
+	recordExport(ctx, ddb, sub, through, "")
+	return res, nil
+}
+
+// Yesterday is the last complete day in the reporting timezone.
+func Yesterday(now time.Time) string {
+	return now.In(live.Location()).AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+func recordExport(ctx context.Context, ddb *dynamodb.Client, sub, exportedDate, errMsg string) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+
+	expr := "SET LastExportError = :e"
+	vals := map[string]types.AttributeValue{
+		":e": &types.AttributeValueMemberS{Value: errMsg},
+	}
+	if exportedDate != "" {
+		expr += ", LastExportedDate = :d"
+		vals[":d"] = &types.AttributeValueMemberS{Value: exportedDate}
+	}
+
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tbl),
+		Key:                       key(sub),
+		UpdateExpression:          aws.String(expr),
+		ExpressionAttributeValues: vals,
+	})
+	if err != nil {
+		fmt.Printf("gsheets: record export user=%s: %v\n", sub, err)
+	}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
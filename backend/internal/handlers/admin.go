@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/internal/changelog"
+	"backend/internal/db"
+	"backend/internal/feedback"
+	"backend/internal/metering"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// AdminHandler serves operator-only routes. Callers must be in the Cognito
+// "admin" group or listed in ADMIN_USER_SUBS (comma-separated).
+func AdminHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	if !isAdmin(req, sub) {
+		return errResp(403, "forbidden")
+	}
+
+	switch req.RawPath {
+	case "/admin/bedrock-usage":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return adminBedrockUsage(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/admin/changelog":
+		switch req.RequestContext.HTTP.Method {
+		case "POST":
+			return adminPublishChangelog(ctx, req)
+		case "DELETE":
+			return adminDeleteChangelog(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/admin/feedback":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return adminListFeedback(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/admin/integrations/export":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return adminExportIntegrations(ctx, req, sub)
+		}
+		return errResp(405, "method not allowed")
+	case "/admin/integrations/import":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return adminImportIntegrations(ctx, req, sub)
+		}
+		return errResp(405, "method not allowed")
+	case "/admin/shop-mappings/rebuild":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return adminRebuildShopMappings(ctx, req, sub)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func isAdmin(req events.APIGatewayV2HTTPRequest, sub string) bool {
+	// HTTP API flattens array claims to "[a b]".
+	groups := strings.Trim(req.RequestContext.Authorizer.JWT.Claims["cognito:groups"], "[]")
+	for _, g := range strings.FieldsFunc(groups, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if g == "admin" {
+			return true
+		}
+	}
+	for _, s := range strings.Split(os.Getenv("ADMIN_USER_SUBS"), ",") {
+		if strings.TrimSpace(s) == sub {
+			return true
+		}
+	}
+	return false
+}
+
+// adminBedrockUsage reports Bedrock tokens, Athena scans (model "athena")
+// and their estimated cost for ?from=YYYY-MM-DD&to=YYYY-MM-DD (default:
+// last 7 days, at most 93), grouped by ?groupBy=day|user|feature|model
+// (default day).
+func adminBedrockUsage(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+	from, to, msg := usageRange(q, 7)
+	if msg != "" {
+		return errResp(400, msg)
+	}
+
+	groupBy := strings.TrimSpace(q["groupBy"])
+	switch groupBy {
+	case "":
+		groupBy = "day"
+	case "day", "user", "feature", "model":
+	default:
+		return errResp(400, "groupBy must be day, user, feature or model")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	items, err := metering.LoadDays(ctx, ddb, from, to)
+	if err != nil {
+		return errResp(500, "usage query failed")
+	}
+
+	total := metering.Total(items)
+
+	rows := make([]*metering.Usage, 0)
+	for _, u := range metering.Rollup(items, groupBy) {
+		rows = append(rows, u)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if groupBy == "day" {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].CostUSD > rows[j].CostUSD
+	})
+
+	return jsonResp(200, map[string]any{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"groupBy": groupBy,
+		"total":   total,
+		"items":   rows,
+	})
+}
+
+// adminPublishChangelog stores a release note. publishedAt may be in the future
+// to schedule it.
+func adminPublishChangelog(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	var e changelog.Entry
+	if err := json.Unmarshal([]byte(req.Body), &e); err != nil {
+		return errResp(400, "invalid json")
+	}
+	e.Title = strings.TrimSpace(e.Title)
+	if e.Title == "" || strings.TrimSpace(e.Body) == "" {
+		return errResp(400, "title and body are required")
+	}
+	if e.Category == "" {
+		e.Category = "feature"
+	}
+	if !changelog.Categories[e.Category] {
+		return errResp(400, "invalid category")
+	}
+	if e.PublishedAt != "" {
+		t, err := time.Parse(time.RFC3339, e.PublishedAt)
+		if err != nil {
+			return errResp(400, "publishedAt must be RFC3339")
+		}
+		e.PublishedAt = t.UTC().Format(time.RFC3339)
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	e, err = changelog.Publish(ctx, ddb, e)
+	if err != nil {
+		return errResp(500, "failed to publish")
+	}
+	return jsonResp(201, e)
+}
+
+// adminDeleteChangelog removes ?id=&publishedAt= (both as returned by publish).
+func adminDeleteChangelog(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	id := strings.TrimSpace(req.QueryStringParameters["id"])
+	publishedAt := strings.TrimSpace(req.QueryStringParameters["publishedAt"])
+	if id == "" || publishedAt == "" {
+		return errResp(400, "id and publishedAt are required")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	if err := changelog.Delete(ctx, ddb, publishedAt, id); err != nil {
+		return errResp(500, "failed to delete")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+// adminListFeedback lists feedback for ?month=YYYY-MM (default current), newest first.
+func adminListFeedback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	month := strings.TrimSpace(req.QueryStringParameters["month"])
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return errResp(400, "month must be in format YYYY-MM")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	items, err := feedback.ListMonth(ctx, ddb, month, 200)
+	if err != nil {
+		return errResp(500, "feedback query failed")
+	}
+	return jsonResp(200, map[string]any{"month": month, "items": items})
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"backend/internal/db"
+	"backend/internal/migrate"
+	"backend/internal/security"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// adminExportIntegrations serves POST /admin/integrations/export
+// {"sub": "...", "target": "<target id>"}: the user's integration records
+// with every token re-encrypted for a registered target environment (see
+// migrate.TargetKey), to be posted to its /admin/integrations/import. Raw
+// keys are refused. The export is audited before anything is returned;
+// nothing secret is logged.
+func adminExportIntegrations(ctx context.Context, req events.APIGatewayV2HTTPRequest, adminSub string) (events.APIGatewayV2HTTPResponse, error) {
+	var in struct {
+		Sub       string          `json:"sub"`
+		Target    string          `json:"target"`
+		TargetKey json.RawMessage `json:"targetKey"`
+	}
+	if err := json.Unmarshal([]byte(req.Body), &in); err != nil {
+		return errResp(400, "invalid json")
+	}
+	if len(in.TargetKey) > 0 {
+		return errResp(400, "targetKey is not accepted; name a registered target")
+	}
+	in.Sub = strings.TrimSpace(in.Sub)
+	if in.Sub == "" {
+		return errResp(400, "sub is required")
+	}
+	in.Target = strings.TrimSpace(in.Target)
+	if in.Target == "" {
+		return errResp(400, "target is required")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	target, err := migrate.TargetKey(ctx, ssm.NewFromConfig(cfg), in.Target)
+	if errors.Is(err, migrate.ErrUnknownTarget) {
+		return errResp(400, "unknown target")
+	}
+	if err != nil {
+		fmt.Printf("admin: integrations export target=%s: %v\n", in.Target, err)
+		return errResp(500, "failed to load target key")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	bundle, err := migrate.Export(ctx, ddb, in.Sub, target)
+	if err != nil {
+		fmt.Printf("admin: integrations export sub=%s failed: %v\n", in.Sub, err)
+		return errResp(500, "failed to export integrations")
+	}
+	if err := security.RecordAdminAction(ctx, ddb, security.AdminAction{
+		AdminSub:  adminSub,
+		Action:    "integrations_export",
+		TargetSub: in.Sub,
+		Detail:    fmt.Sprintf("items=%d target=%s keyId=%s", len(bundle.Items), in.Target, bundle.KeyID),
+	}); err != nil {
+		fmt.Printf("admin: integrations export audit failed: %v\n", err)
+		return errResp(500, "failed to audit export")
+	}
+	return jsonResp(200, bundle)
+}
+
+// adminImportIntegrations serves POST /admin/integrations/import with a
+// bundle from another environment's export. The bundle must have been
+// exported for this environment's key.
+func adminImportIntegrations(ctx context.Context, req events.APIGatewayV2HTTPRequest, adminSub string) (events.APIGatewayV2HTTPResponse, error) {
+	var bundle migrate.Bundle
+	if err := json.Unmarshal([]byte(req.Body), &bundle); err != nil {
+		return errResp(400, "invalid json")
+	}
+	if strings.TrimSpace(bundle.UserSub) == "" {
+		return errResp(400, "userSub is required")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	// Audit the attempt first so a partial import is still on record.
+	if err := security.RecordAdminAction(ctx, ddb, security.AdminAction{
+		AdminSub:  adminSub,
+		Action:    "integrations_import",
+		TargetSub: bundle.UserSub,
+		Detail:    fmt.Sprintf("items=%d keyId=%s exportedAt=%s", len(bundle.Items), bundle.KeyID, bundle.ExportedAt),
+	}); err != nil {
+		fmt.Printf("admin: integrations import audit failed: %v\n", err)
+		return errResp(500, "failed to audit import")
+	}
+	n, err := migrate.Import(ctx, ddb, &bundle)
+	if errors.Is(err, migrate.ErrKeyMismatch) {
+		return errResp(409, err.Error())
+	}
+	if err != nil {
+		fmt.Printf("admin: integrations import sub=%s failed: %v\n", bundle.UserSub, err)
+		return errResp(400, "failed to import integrations")
+	}
+	return jsonResp(200, map[string]any{"userSub": bundle.UserSub, "imported": n})
+}
+
+// adminRebuildShopMappings serves POST /admin/shop-mappings/rebuild
+// [{"dryRun": true}]: writes the shop-to-user rows missing for connected
+// Shopify integrations (see shopify.RepairShopMappings) and reports them.
+func adminRebuildShopMappings(ctx context.Context, req events.APIGatewayV2HTTPRequest, adminSub string) (events.APIGatewayV2HTTPResponse, error) {
+	var in struct {
+		DryRun bool `json:"dryRun"`
+	}
+	if strings.TrimSpace(req.Body) != "" {
+		if err := json.Unmarshal([]byte(req.Body), &in); err != nil {
+			return errResp(400, "invalid json")
+		}
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	r, err := shopify.RepairShopMappings(ctx, ddb, in.DryRun)
+	if err != nil {
+		fmt.Printf("admin: shop mappings rebuild failed: %v\n", err)
+		return errResp(500, "failed to rebuild shop mappings")
+	}
+	if !in.DryRun {
+		if err := security.RecordAdminAction(ctx, ddb, security.AdminAction{
+			AdminSub: adminSub,
+			Action:   "shop_mappings_rebuild",
+			Detail:   fmt.Sprintf("integrations=%d repaired=%d stale=%d", r.Integrations, len(r.Repaired), len(r.Stale)),
+		}); err != nil {
+			fmt.Printf("admin: shop mappings rebuild audit failed: %v\n", err)
+		}
+	}
+	return jsonResp(200, r)
+}
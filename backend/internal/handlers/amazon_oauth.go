@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/amazon"
+	"backend/internal/db"
+	"backend/internal/security"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func AmazonHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RawPath {
+	case "/integrations/amazon/connect":
+		return amazonConnect(ctx, req)
+	case "/integrations/amazon/callback":
+		return amazonCallback(ctx, req)
+	case "/integrations/amazon/sellers":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return amazonListSellers(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/integrations/amazon/sync":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return amazonSync(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func amazonConnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	appID := strings.TrimSpace(os.Getenv("AMAZON_SP_APP_ID"))
+	if appID == "" {
+		return errResp(500, "AMAZON_SP_APP_ID not set")
+	}
+
+	state, err := randomState(24)
+	if err != nil {
+		return errResp(500, "failed to generate state")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	if strings.TrimSpace(stateTable) == "" {
+		return errResp(500, "OAUTH_STATE_TABLE not set")
+	}
+
+	exp := time.Now().UTC().Add(10 * time.Minute).Unix()
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(stateTable),
+		Item: map[string]types.AttributeValue{
+			"State":          &types.AttributeValueMemberS{Value: state},
+			"UserSub":        &types.AttributeValueMemberS{Value: sub},
+			"Provider":       &types.AttributeValueMemberS{Value: amazon.Source},
+			"ExpiresAtEpoch": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
+		},
+	})
+	if err != nil {
+		return errResp(500, "failed to store oauth state")
+	}
+
+	redirectBase, err := getApiBaseUrl()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+	redirectURI := strings.TrimRight(redirectBase, "/") + "/integrations/amazon/callback"
+
+	sellerCentral := strings.TrimRight(strings.TrimSpace(os.Getenv("AMAZON_SELLER_CENTRAL_URL")), "/")
+	if sellerCentral == "" {
+		sellerCentral = "https://sellercentral.amazon.com"
+	}
+
+	u, _ := url.Parse(sellerCentral + "/apps/authorize/consent")
+	q := u.Query()
+	q.Set("application_id", appID)
+	q.Set("state", state)
+	q.Set("redirect_uri", redirectURI)
+	if os.Getenv("AMAZON_SP_APP_DRAFT") == "true" {
+		q.Set("version", "beta")
+	}
+	u.RawQuery = q.Encode()
+
+	return jsonResp(200, map[string]any{
+		"authorizeUrl": u.String(),
+	})
+}
+
+func amazonCallback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	params := req.QueryStringParameters
+	state := strings.TrimSpace(params["state"])
+	code := strings.TrimSpace(params["spapi_oauth_code"])
+	sellerID := strings.TrimSpace(params["selling_partner_id"])
+	if state == "" || code == "" || sellerID == "" {
+		return errResp(400, "missing required oauth params")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return errResp(400, "invalid or expired state")
+	}
+	sub := attrS(out.Item["UserSub"])
+	if sub == "" || attrS(out.Item["Provider"]) != amazon.Source {
+		return errResp(400, "state mismatch")
+	}
+
+	redirectBase, err := getApiBaseUrl()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+	redirectURI := strings.TrimRight(redirectBase, "/") + "/integrations/amazon/callback"
+
+	refresh, err := amazon.ExchangeAuthCode(ctx, code, redirectURI)
+	if err != nil {
+		return errResp(502, "token exchange failed")
+	}
+
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return errResp(500, "invalid TOKEN_ENC_KEY_B64")
+	}
+	enc, err := security.EncryptAESGCM(key, refresh)
+	if err != nil {
+		return errResp(500, "failed to encrypt token")
+	}
+
+	intTable := db.IntegrationsTableName()
+	if strings.TrimSpace(intTable) == "" {
+		return errResp(500, "INTEGRATIONS_TABLE not set")
+	}
+
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(intTable),
+		Item: map[string]types.AttributeValue{
+			"PK":              &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK":              &types.AttributeValueMemberS{Value: fmt.Sprintf("AMAZON#%s", sellerID)},
+			"Provider":        &types.AttributeValueMemberS{Value: amazon.Source},
+			"SellerId":        &types.AttributeValueMemberS{Value: sellerID},
+			"RefreshTokenEnc": &types.AttributeValueMemberS{Value: enc},
+			"CreatedAt":       &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return errResp(500, "failed to store integration")
+	}
+
+	_, _ = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+
+	fe := strings.TrimRight(os.Getenv("FRONTEND_BASE_URL"), "/")
+	if fe == "" {
+		fe = "/"
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"location": fe + "/amazon?connected=1&seller=" + url.QueryEscape(sellerID),
+		},
+	}, nil
+}
+
+func amazonListSellers(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integs, err := amazon.ListIntegrations(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	type SellerItem struct {
+		SellerId   string `json:"sellerId"`
+		CreatedAt  string `json:"createdAt"`
+		LastSyncAt string `json:"lastSyncAt"`
+	}
+	items := make([]SellerItem, 0, len(integs))
+	for _, it := range integs {
+		items = append(items, SellerItem{SellerId: it.SellerId, CreatedAt: it.CreatedAt, LastSyncAt: it.LastSyncAt})
+	}
+	return jsonResp(200, map[string]any{"items": items})
+}
+
+// amazonSync runs an on-demand sync for the caller's sellers (optionally ?seller=<id>).
+func amazonSync(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	only := strings.TrimSpace(req.QueryStringParameters["seller"])
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integs, err := amazon.ListIntegrations(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	results := make([]any, 0, len(integs))
+	for _, it := range integs {
+		if only != "" && it.SellerId != only {
+			continue
+		}
+		res, err := amazon.SyncSeller(ctx, ddb, it)
+		if err != nil {
+			results = append(results, map[string]any{"sellerId": it.SellerId, "error": err.Error()})
+			continue
+		}
+		results = append(results, res)
+	}
+
+	return jsonResp(200, map[string]any{"ok": true, "results": results})
+}
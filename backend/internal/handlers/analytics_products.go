@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/internal/costs"
+	"backend/internal/db"
+	"backend/internal/pagination"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// TopProduct is one product's period in one currency. Profit is revenue
+// less refunds, cost of goods and the variable cost model; it is only set
+// when every unit sold has a known cost.
+type TopProduct struct {
+	Key           string   `json:"key"` // SKU, or title when there is none
+	SKU           string   `json:"sku,omitempty"`
+	Title         string   `json:"title"`
+	Currency      string   `json:"currency"`
+	Orders        int      `json:"orders"`
+	Units         int      `json:"units"`
+	Revenue       float64  `json:"revenue"`
+	RefundedUnits int      `json:"refundedUnits"`
+	Refunds       float64  `json:"refunds"`
+	NetRevenue    float64  `json:"netRevenue"`
+	ProductCost   *float64 `json:"productCost"`
+	Profit        *float64 `json:"profit"`
+
+	uncosted int // lines sold without a known cost
+	variable float64
+}
+
+var topProductSorts = map[string]func(a, b *TopProduct) bool{
+	"revenue": func(a, b *TopProduct) bool { return a.Revenue > b.Revenue },
+	"units":   func(a, b *TopProduct) bool { return a.Units > b.Units },
+	"refunds": func(a, b *TopProduct) bool { return a.Refunds > b.Refunds },
+	// products without a profit figure go last
+	"profit": func(a, b *TopProduct) bool {
+		if (a.Profit == nil) != (b.Profit == nil) {
+			return a.Profit != nil
+		}
+		return a.Profit != nil && *a.Profit > *b.Profit
+	},
+}
+
+// analyticsTopProducts serves GET /analytics/top-products?period=<spec>
+// [&shop=&sort=revenue|units|refunds|profit&limit=&offset=]: per product
+// revenue, units and refunds from stored order and refund lines over a
+// period of the caller's fiscal calendar (default this_period), with profit
+// where product costs are known. Orders and refunds synced before lines
+// were captured are counted in ordersWithoutLines / refundsWithoutLines.
+func analyticsTopProducts(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+	spec := strings.TrimSpace(q["period"])
+	if spec == "" {
+		spec = "this_period"
+	}
+	sortBy := strings.ToLower(strings.TrimSpace(q["sort"]))
+	if sortBy == "" {
+		sortBy = "revenue"
+	}
+	less, ok := topProductSorts[sortBy]
+	if !ok {
+		return errResp(400, "sort must be revenue, units, refunds or profit")
+	}
+	limit, err := topProductsPageSize.Limit(q["limit"])
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+	offset, err := pagination.Offset(q["offset"])
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+	shop := strings.ToLower(strings.TrimSpace(q["shop"]))
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	settings, err := users.GetSettings(ctx, client, sub)
+	if err != nil {
+		return errResp(500, "failed to load settings")
+	}
+	p, err := settings.Calendar.Resolve(spec, time.Now().UTC())
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+	if len(p.Months()) > 13 {
+		return errResp(400, "period must span at most 13 months")
+	}
+	model := settings.CostModel
+
+	byKey := map[string]*TopProduct{}
+	product := func(key, sku, title, currency string) *TopProduct {
+		k := key + "|" + currency
+		r := byKey[k]
+		if r == nil {
+			r = &TopProduct{Key: key, SKU: sku, Title: title, Currency: currency}
+			byKey[k] = r
+		}
+		return r
+	}
+	books := map[string]*costs.Book{}
+	ordersWithoutLines, refundsWithoutLines := 0, 0
+	for _, m := range p.Months() {
+		// Split orders still sold the same products: read the parent's
+		// lines and skip its allocations.
+		items, err := queryMonthItems(ctx, client, table, sub, m)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		for _, t := range items {
+			if t.ParentId != "" || t.DeletedAt != "" || (shop != "" && t.Shop != shop) {
+				continue
+			}
+			if at, err := time.Parse(time.RFC3339, t.CreatedAt); err == nil && !p.Contains(at) {
+				continue
+			}
+			switch {
+			case skHasMarker(t.SK, refundSKMarkers):
+				if len(t.Lines) == 0 {
+					refundsWithoutLines++
+					continue
+				}
+				for _, l := range t.Lines {
+					r := product(l.Key(), l.SKU, l.Title, t.Currency)
+					r.RefundedUnits += l.Quantity
+					r.Refunds += l.Revenue()
+				}
+			case skHasMarker(t.SK, orderSKMarkers):
+				if len(t.Lines) == 0 {
+					ordersWithoutLines++
+					continue
+				}
+				book := books[t.Shop]
+				if book == nil {
+					if book, err = costs.Load(ctx, client, t.Shop); err != nil {
+						return errResp(500, "failed to load product costs")
+					}
+					books[t.Shop] = book
+				}
+				_, orderID, _ := strings.Cut(t.SK, "#ORDER#")
+				lineCosts := book.OrderLines(orderID, t.Lines)
+				for i, l := range t.Lines {
+					c := model.Apply(l, lineCosts[i].Cost)
+					r := product(l.Key(), l.SKU, l.Title, t.Currency)
+					r.Orders++
+					r.Units += l.Quantity
+					r.Revenue += c.Revenue
+					r.variable += c.VariableCosts
+					if lineCosts[i].Source != "" {
+						cost := c.ProductCost
+						if r.ProductCost != nil {
+							cost += *r.ProductCost
+						}
+						r.ProductCost = &cost
+					} else {
+						r.uncosted++
+					}
+				}
+			}
+		}
+	}
+
+	r2 := func(v float64) float64 { return math.Round(v*100) / 100 }
+	rows := make([]*TopProduct, 0, len(byKey))
+	for _, r := range byKey {
+		r.Revenue, r.Refunds = r2(r.Revenue), r2(r.Refunds)
+		r.NetRevenue = r2(r.Revenue - r.Refunds)
+		if r.ProductCost != nil {
+			cost := r2(*r.ProductCost)
+			r.ProductCost = &cost
+			if r.uncosted == 0 {
+				profit := r2(r.NetRevenue - cost - r.variable)
+				r.Profit = &profit
+			}
+		}
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if less(rows[i], rows[j]) {
+			return true
+		}
+		if less(rows[j], rows[i]) {
+			return false
+		}
+		return rows[i].Key < rows[j].Key
+	})
+
+	total := len(rows)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	resp := map[string]any{
+		"period":              toPeriodJSON(p),
+		"shop":                shop,
+		"sort":                sortBy,
+		"costModel":           model,
+		"items":               rows[offset:end],
+		"totalProducts":       total,
+		"ordersWithoutLines":  ordersWithoutLines,
+		"refundsWithoutLines": refundsWithoutLines,
+	}
+	if end < total {
+		resp["nextOffset"] = end
+	}
+	return jsonResp(200, resp)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"backend/internal/nlq"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// SchemaColumn is one column of a queryable table as Glue has it, with its
+// glossary entry (empty when the glossary doesn't know the column yet).
+type SchemaColumn struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Partition   bool   `json:"partition,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+}
+
+// SchemaTable is one table /ask can query, allowlisted columns only.
+type SchemaTable struct {
+	Table       string         `json:"table"`
+	Kind        string         `json:"kind"`
+	Description string         `json:"description,omitempty"`
+	Columns     []SchemaColumn `json:"columns"`
+}
+
+// handleSchema serves GET /analytics/schema: the columns of the tables /ask
+// can query, from Glue, plus the metric definitions, from the same glossary
+// the /ask prompt is built with. "table" and "columns" are daily_metrics'
+// and predate "tables".
+func (h *AskHandler) handleSchema(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RequestContext.HTTP.Method != http.MethodGet {
+		return jsonErr(http.StatusMethodNotAllowed, "method_not_allowed", nil), nil
+	}
+	sub := ""
+	if req.RequestContext.Authorizer.JWT.Claims != nil {
+		sub = req.RequestContext.Authorizer.JWT.Claims["sub"]
+	}
+	if strings.TrimSpace(sub) == "" {
+		return jsonErr(http.StatusUnauthorized, "missing_user_sub", nil), nil
+	}
+
+	schemas, err := nlq.LoadSchemasFromEnv(ctx, h.glue)
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "schema_load_failed", err), nil
+	}
+
+	tables := make([]SchemaTable, 0, len(schemas))
+	for _, schema := range schemas {
+		cols := make([]SchemaColumn, 0, len(schema.Columns)+len(schema.Partitions))
+		add := func(c nlq.Column, partition bool) {
+			sc := SchemaColumn{Name: c.Name, Type: nlq.NormalizeGlueType(c.Type), Partition: partition}
+			if d, ok := schema.Describe(c.Name); ok {
+				sc.Label, sc.Description, sc.Unit = d.Label, d.Description, d.Unit
+			}
+			cols = append(cols, sc)
+		}
+		for _, c := range schema.Columns {
+			add(c, false)
+		}
+		for _, c := range schema.Partitions {
+			add(c, true)
+		}
+		tables = append(tables, SchemaTable{Table: schema.Table, Kind: schema.Kind, Description: schema.Description, Columns: cols})
+	}
+
+	return jsonOK(map[string]any{
+		"table":   tables[0].Table,
+		"columns": tables[0].Columns,
+		"tables":  tables,
+		"metrics": nlq.Metrics(),
+	}), nil
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/metrics"
+	"backend/internal/nlq"
+	"backend/internal/rollup"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/athena"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// defaultTrendsLiveDays is how far back GET /analytics/trends reads the
+// transactions table; older days come from daily_metrics.
+const defaultTrendsLiveDays = 92
+
+const (
+	trendsFromTransactions = "transactions"
+	trendsFromDailyMetrics = "daily_metrics"
+	trendsFromMixed        = "mixed"
+)
+
+type TrendBucket struct {
+	Start    string  `json:"start"` // first day in the bucket, inside [from, to]
+	End      string  `json:"end"`
+	Revenue  float64 `json:"revenue"`
+	Expenses float64 `json:"expenses"`
+	Net      float64 `json:"net"`
+	Source   string  `json:"source"`
+}
+
+// trendsLiveDays reads TRENDS_LIVE_DAYS.
+func trendsLiveDays() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("TRENDS_LIVE_DAYS"))); err == nil && n > 0 {
+		return n
+	}
+	return defaultTrendsLiveDays
+}
+
+// analyticsTrends serves GET /analytics/trends?granularity=day|week&from=&to=:
+// revenue, expenses and net per bucket, oldest first, with every bucket in
+// the range present so charts need no gap filling. Weeks start on Monday.
+// Days within TRENDS_LIVE_DAYS come from the GSI1 month partitions, like
+// /summary/daily, or the rollup where it covers the month; older days are
+// summed over the caller's shops from daily_metrics, where revenue is
+// net_revenue and expenses are all costs.
+func analyticsTrends(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+
+	granularity := strings.ToLower(strings.TrimSpace(q["granularity"]))
+	if granularity == "" {
+		granularity = "day"
+	}
+	if granularity != "day" && granularity != "week" {
+		return errResp(400, "granularity must be day or week")
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to := today
+	if v := strings.TrimSpace(q["to"]); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return errResp(400, "to must be in format YYYY-MM-DD")
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -29)
+	if v := strings.TrimSpace(q["from"]); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return errResp(400, "from must be in format YYYY-MM-DD")
+		}
+		from = t
+	}
+	if from.After(to) || to.Sub(from) >= metrics.MaxDays*24*time.Hour {
+		return errResp(400, fmt.Sprintf("from/to must span at most %d days", metrics.MaxDays))
+	}
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	ddb := dynamodb.NewFromConfig(cfg)
+
+	liveFrom := today.AddDate(0, 0, -trendsLiveDays())
+	days, currency, err := trendDays(ctx, cfg, ddb, table, sub, from, to, liveFrom)
+	if err != nil {
+		return trendDaysErr(err)
+	}
+
+	var buckets []TrendBucket
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		ds := d.Format("2006-01-02")
+		day := days[ds]
+		newBucket := len(buckets) == 0 || granularity == "day" || d.Weekday() == time.Monday
+		if newBucket {
+			buckets = append(buckets, TrendBucket{Start: ds, Source: day.source})
+		}
+		b := &buckets[len(buckets)-1]
+		b.End = ds
+		b.Revenue += day.revenue
+		b.Expenses += day.expenses
+		if b.Source != day.source {
+			b.Source = trendsFromMixed
+		}
+	}
+	for i := range buckets {
+		buckets[i].Revenue = math.Round(buckets[i].Revenue*100) / 100
+		buckets[i].Expenses = math.Round(buckets[i].Expenses*100) / 100
+		buckets[i].Net = math.Round((buckets[i].Revenue-buckets[i].Expenses)*100) / 100
+	}
+
+	return jsonResp(200, map[string]any{
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"granularity": granularity,
+		"currency":    currency,
+		"liveFrom":    liveFrom.Format("2006-01-02"),
+		"buckets":     buckets,
+	})
+}
+
+// trendDay is one day of a trend series.
+type trendDay struct {
+	revenue, expenses float64
+	source            string
+}
+
+var errTrendCurrencies = errors.New("multiple currencies in range not supported yet")
+
+// trendDays totals revenue and expenses per day over [from, to]: days from
+// liveFrom on come from the transactions table (its rollup where that covers
+// the month), older days are summed over the caller's shops from
+// daily_metrics. Every day in the range is present.
+func trendDays(ctx context.Context, cfg aws.Config, ddb *dynamodb.Client, table, sub string, from, to, liveFrom time.Time) (map[string]*trendDay, string, error) {
+	days := map[string]*trendDay{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		src := trendsFromTransactions
+		if d.Before(liveFrom) {
+			src = trendsFromDailyMetrics
+		}
+		days[d.Format("2006-01-02")] = &trendDay{source: src}
+	}
+
+	currency := ""
+	useCurrency := func(c string) error {
+		if currency == "" {
+			currency = c
+		} else if c != currency {
+			return errTrendCurrencies
+		}
+		return nil
+	}
+	if !to.Before(liveFrom) {
+		start := from
+		if start.Before(liveFrom) {
+			start = liveFrom
+		}
+		for month := start.Format("2006-01"); month <= to.Format("2006-01"); month = nextMonth(month) {
+			if rollup.Covers(month) {
+				for d := start; !d.After(to); d = d.AddDate(0, 0, 1) {
+					ds := d.Format("2006-01-02")
+					if ds[:7] != month {
+						continue
+					}
+					totals, err := rollup.Day(ctx, ddb, sub, ds)
+					if err != nil {
+						return nil, "", err
+					}
+					for cur, t := range totals {
+						if err := useCurrency(cur); err != nil {
+							return nil, "", err
+						}
+						days[ds].revenue += t.Income
+						days[ds].expenses += t.Expense
+					}
+				}
+				continue
+			}
+			items, err := queryMonthRange(ctx, ddb, table, sub, month, start.Format("2006-01-02"), to.Format("2006-01-02"))
+			if err != nil {
+				return nil, "", err
+			}
+			for _, t := range items {
+				if len(t.GSI1SK) < 10 {
+					continue
+				}
+				day := days[t.GSI1SK[:10]]
+				if day == nil {
+					continue
+				}
+				if err := useCurrency(t.Currency); err != nil {
+					return nil, "", err
+				}
+				if t.Amount >= 0 {
+					day.revenue += t.Amount
+				} else {
+					day.expenses += math.Abs(t.Amount)
+				}
+			}
+		}
+	}
+
+	if from.Before(liveFrom) {
+		end := to
+		if !end.Before(liveFrom) {
+			end = liveFrom.AddDate(0, 0, -1)
+		}
+		shops, err := tenancy.GetAllowedShopsByUserSub(ctx, ddb, sub)
+		if err != nil {
+			return nil, "", err
+		}
+		opt := nlq.AthenaRunOptions{
+			Database:       strings.TrimSpace(os.Getenv("ATHENA_DATABASE")),
+			Workgroup:      strings.TrimSpace(os.Getenv("ATHENA_WORKGROUP")),
+			OutputLocation: strings.TrimSpace(os.Getenv("ATHENA_OUTPUT_S3")),
+			MaxWait:        25 * time.Second,
+		}
+		ath := athena.NewFromConfig(cfg)
+		for _, shop := range shops {
+			// metrics.Daily reads at most MaxDays per call
+			for chunk := from; !chunk.After(end); chunk = chunk.AddDate(0, 0, metrics.MaxDays) {
+				chunkEnd := chunk.AddDate(0, 0, metrics.MaxDays-1)
+				if chunkEnd.After(end) {
+					chunkEnd = end
+				}
+				res, err := metrics.Daily(ctx, ddb, ath, opt, shop, chunk, chunkEnd)
+				if err != nil {
+					return nil, "", err
+				}
+				for _, r := range res.Rows {
+					day := days[r.Date]
+					if day == nil {
+						continue
+					}
+					day.revenue += r.NetRevenue
+					day.expenses += r.ProductCosts + r.MarketingCosts + r.FulfillmentCosts + r.ProcessingFees + r.OtherCosts
+				}
+			}
+		}
+		if currency == "" {
+			currency = strings.ToUpper(strings.TrimSpace(os.Getenv("ETL_CURRENCY")))
+		}
+	}
+	return days, currency, nil
+}
+
+// trendDaysErr maps a trendDays error to a response.
+func trendDaysErr(err error) (events.APIGatewayV2HTTPResponse, error) {
+	var ae *nlq.AthenaError
+	switch {
+	case errors.Is(err, errTrendCurrencies):
+		return errResp(400, err.Error())
+	case errors.As(err, &ae) && ae.State == nlq.AthenaStateTimeout:
+		return errResp(504, "metrics query timed out, try a shorter range")
+	}
+	fmt.Printf("trends: %v\n", err)
+	return errResp(500, "query failed")
+}
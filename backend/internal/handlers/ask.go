@@ -3,6 +3,8 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"strings"
@@ -14,15 +16,22 @@ import (
 	bedrockruntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/glue"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
 
+	"backend/internal/metering"
 	"backend/internal/nlq"
+	"backend/internal/notify"
+	"backend/internal/ops"
+	"backend/internal/security"
 	"backend/internal/tenancy"
+	"backend/internal/users"
 )
 
 type AskHandler struct {
 	cfg  aws.Config
 	glue *glue.Client
 	ddb  *dynamodb.Client
+	sns  *sns.Client
 }
 
 func NewAskHandler(cfg aws.Config) *AskHandler {
@@ -30,15 +39,47 @@ func NewAskHandler(cfg aws.Config) *AskHandler {
 		cfg:  cfg,
 		glue: glue.NewFromConfig(cfg),
 		ddb:  dynamodb.NewFromConfig(cfg),
+		sns:  sns.NewFromConfig(cfg),
 	}
 }
 
 type AskRequest struct {
 	Question string   `json:"question"`
 	ShopIDs  []string `json:"shop_ids,omitempty"` // optional subset
+
+	// SessionID scopes pinned context and conversation history to one chat
+	// session. Sending Context replaces what is pinned (an empty object
+	// clears it); omitting it reuses the session's pinned context. Earlier
+	// questions of the session are in the prompt, so follow-ups work.
+	SessionID string             `json:"session_id,omitempty"`
+	Context   *nlq.PinnedContext `json:"context,omitempty"`
+
+	// Model pins one Bedrock model instead of the configured chain, for
+	// experiments; it must be in the chain or BEDROCK_EXPERIMENT_MODEL_IDS.
+	Model string `json:"model,omitempty"`
+
+	// Language is the code (nlq.Languages) to answer in, e.g. the UI locale;
+	// by default the answer follows the question's language.
+	Language string `json:"language,omitempty"`
 }
 
 func (h *AskHandler) Handle(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RawPath == "/analytics/schema" {
+		return h.handleSchema(ctx, req)
+	}
+	if req.RawPath == "/ask/history" {
+		return h.handleHistory(ctx, req)
+	}
+	if req.RawPath == "/ask/feedback" {
+		return h.handleFeedback(ctx, req)
+	}
+	if strings.HasPrefix(req.RawPath, "/ask/result/") {
+		return h.handleResult(ctx, req)
+	}
+	if req.RawPath == "/ask/digests" || strings.HasPrefix(req.RawPath, "/ask/digests/") {
+		return h.handleDigests(ctx, req)
+	}
+
 	// Parse JSON body
 	var body AskRequest
 	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
@@ -58,34 +99,54 @@ func (h *AskHandler) Handle(ctx context.Context, req events.APIGatewayV2HTTPRequ
 	if sub == "" {
 		return jsonErr(http.StatusUnauthorized, "missing_user_sub", nil), nil
 	}
+	ctx = metering.WithAttribution(ctx, h.ddb, sub, metering.FeatureAsk)
+
+	// From here on every ask is kept in the ask log (GET /ask/history),
+	// whatever its outcome.
+	started := time.Now()
+	rec := &nlq.AskRecord{Question: body.Question, SessionID: strings.TrimSpace(body.SessionID), Outcome: nlq.AskError}
+	defer func() { h.recordAsk(ctx, sub, rec, started) }()
+	fail := func(status int, msg string, err error) (events.APIGatewayV2HTTPResponse, error) {
+		rec.Reason = msg
+		if err != nil {
+			rec.Reason += ": " + err.Error()
+		}
+		return jsonErr(status, msg, err), nil
+	}
+
+	body.Model = strings.TrimSpace(body.Model)
+	if body.Model != "" {
+		if !nlq.SelectableModel(body.Model) {
+			return fail(http.StatusBadRequest, "model_not_allowed", nil)
+		}
+		ctx = nlq.WithModel(ctx, body.Model)
+	}
+
+	body.Language = strings.ToLower(strings.TrimSpace(body.Language))
+	if body.Language != "" && nlq.Languages[body.Language] == "" {
+		return fail(http.StatusBadRequest, "language_not_supported", nil)
+	}
+	lang := body.Language
+	if lang == "" {
+		lang = nlq.DetectLanguage(body.Question)
+	}
+	rec.Language = lang
 
 	// Tenant scoping: allowed shops for this user (via GSI_UserSub on ShopToUser table)
 	allowedShopIDs, err := tenancy.GetAllowedShopsByUserSub(ctx, h.ddb, sub)
 	if err != nil {
-		return jsonErr(http.StatusInternalServerError, "shop_lookup_failed", err), nil
+		return fail(http.StatusInternalServerError, "shop_lookup_failed", err)
 	}
 	if len(allowedShopIDs) == 0 {
+		rec.Outcome = nlq.AskNoShops
 		return jsonOK(map[string]any{
 			"type":  "no_shops",
 			"error": "no shops connected to this user",
 		}), nil
 	}
 
-	effectiveShopIDs := intersectAllowed(body.ShopIDs, allowedShopIDs)
-	if len(effectiveShopIDs) == 0 {
-		return jsonErr(http.StatusForbidden, "no_allowed_shops_in_request", nil), nil
-	}
-	allowedShopIDs = effectiveShopIDs
-
-	// Load schema from Glue
-	schema, err := nlq.LoadTableSchemaFromEnv(ctx, h.glue)
-	if err != nil {
-		return jsonErr(http.StatusInternalServerError, "glue_get_table_failed", err), nil
-	}
-	schemaText := nlq.CompactSchemaText(schema)
-
 	// Config
-	maxDays := 90
+	maxDays := askMaxDays
 	if v := strings.TrimSpace(os.Getenv("NLQ_MAX_DAYS")); v != "" {
 		// optional parse
 		// strconv.Atoi
@@ -93,29 +154,108 @@ func (h *AskHandler) Handle(ctx context.Context, req events.APIGatewayV2HTTPRequ
 	today := nlq.TodayISO()
 	tz := "Asia/Ho_Chi_Minh"
 
+	// Session pinned context (shops / date range / currency)
+	pinned, err := h.resolvePinnedContext(ctx, sub, body, today, maxDays)
+	if err != nil {
+		return fail(http.StatusBadRequest, "invalid_context", err)
+	}
+
+	// Fiscal calendar for "this quarter"/"this year" wording; defaults on failure.
+	settings, err := users.GetSettings(ctx, h.ddb, sub)
+	if err != nil {
+		fmt.Printf("ask: load settings failed: %v\n", err)
+	}
+	fiscal := settings.Calendar.PromptText(time.Now().UTC())
+
+	// Earlier turns of the session, for follow-up questions.
+	history := h.sessionHistory(ctx, sub, body.SessionID)
+
+	// Explicit shop_ids win over pinned shops for this one question.
+	requestedShops := body.ShopIDs
+	if len(requestedShops) == 0 && pinned != nil {
+		requestedShops = pinned.ShopIDs
+	}
+
+	effectiveShopIDs := intersectAllowed(requestedShops, allowedShopIDs)
+	if len(effectiveShopIDs) == 0 {
+		return fail(http.StatusForbidden, "no_allowed_shops_in_request", nil)
+	}
+	allowedShopIDs = effectiveShopIDs
+	rec.Shops = allowedShopIDs
+
+	// Load the queryable tables from Glue
+	schemas, err := nlq.LoadSchemasFromEnv(ctx, h.glue)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "glue_get_table_failed", err)
+	}
+	schemaText := nlq.ComposeSchemaText(schemas)
+
 	schemaHash := nlq.SchemaHash(schemaText)
+	rec.SchemaHash = schemaHash
+
+	// The user's best-rated earlier questions for this schema (POST /ask/feedback).
+	examples, err := nlq.TopExamples(ctx, h.ddb, sub, schemaHash, nlq.PromptExamples)
+	if err != nil {
+		fmt.Printf("ask: load examples failed: %v\n", err)
+	}
+
+	// Data versions of the asked shops, so an ETL run retires cached answers.
+	// Without them the cache can't tell fresh from stale and is skipped.
+	dataVersion, err := nlq.DataVersionMaterial(ctx, h.ddb, allowedShopIDs)
+	useCache := err == nil
+	if err != nil {
+		fmt.Printf("ask: load data versions failed: %v\n", err)
+	}
 
 	// Check cache
 	ck := nlq.CacheKey{
-		UserSub:    sub,
-		Shops:      allowedShopIDs,
-		Question:   body.Question,
-		TodayISO:   today,
-		MaxDays:    maxDays,
-		SchemaHash: schemaHash,
+		UserSub:     sub,
+		Shops:       allowedShopIDs,
+		Question:    body.Question,
+		TodayISO:    today,
+		MaxDays:     maxDays,
+		SchemaHash:  schemaHash,
+		Context:     pinned.CacheMaterial() + settings.Calendar.CacheMaterial() + nlq.HistoryCacheMaterial(history) + nlq.ExamplesCacheMaterial(examples) + modelCacheMaterial(body.Model) + languageCacheMaterial(body.Language),
+		DataVersion: dataVersion,
 	}
 
-	if cached, ok, err := nlq.GetCached(ctx, h.ddb, ck); err == nil && ok {
+	if cached, ok, err := nlq.GetCached(ctx, h.ddb, ck); useCache && err == nil && ok {
+		rec.Outcome, rec.Cached, rec.SQL, rec.Validation = nlq.AskResult, true, cached.SQL, nlq.ValidationPassed
+		rec.QueryID, rec.ScannedBytes, rec.ExecMs, rec.Model = cached.QueryID, cached.ScannedBytes, cached.ExecMs, cached.Model
+		h.appendTurn(ctx, sub, body.SessionID, nlq.Turn{
+			Question:    body.Question,
+			SQL:         cached.SQL,
+			Assumptions: cached.Assumptions,
+			Answer:      turnAnswer(cached.Answer, cached.Columns, cached.Rows),
+		})
 		return jsonOK(map[string]any{
 			"type":          "result",
 			"cached":        true,
+			"answer":        cached.Answer,
 			"sql":           cached.SQL,
+			"explanation":   nlq.ExplainSQL(cached.SQL, schemas),
 			"assumptions":   cached.Assumptions,
 			"confidence":    cached.Confidence,
 			"result":        nlq.ShapeResult(cached.Columns, cached.Rows),
 			"query_id":      cached.QueryID,
 			"scanned_bytes": cached.ScannedBytes,
 			"exec_ms":       cached.ExecMs,
+			"model":         cached.Model,
+			"context":       pinned,
+		}), nil
+	}
+
+	// Daily quota; only asks that reach the model and Athena count.
+	var quotaErr *metering.QuotaError
+	if errors.As(metering.ReserveNLQQuery(ctx, h.ddb, sub), &quotaErr) {
+		rec.Outcome, rec.Reason = nlq.AskQuotaExceeded, quotaErr.Error()
+		return jsonStatus(http.StatusTooManyRequests, map[string]any{
+			"type":      "quota_exceeded",
+			"error":     quotaErr.Error(),
+			"limit":     quotaErr.Limit,
+			"used":      quotaErr.Used,
+			"quota":     quotaErr.Quota,
+			"resets_at": quotaErr.ResetsAt.Format(time.RFC3339),
 		}), nil
 	}
 
@@ -127,6 +267,11 @@ func (h *AskHandler) Handle(ctx context.Context, req events.APIGatewayV2HTTPRequ
 		SchemaText:      schemaText,
 		TodayISO:        today,
 		DefaultTimezone: tz,
+		PinnedContext:   pinned.PromptText(),
+		FiscalCalendar:  fiscal,
+		History:         nlq.HistoryText(history),
+		Examples:        nlq.ExamplesText(examples),
+		Language:        lang,
 	})
 
 	// Clients
@@ -134,50 +279,47 @@ func (h *AskHandler) Handle(ctx context.Context, req events.APIGatewayV2HTTPRequ
 	ath := athena.NewFromConfig(h.cfg)
 
 	// Invoke LLM for initial SQL
-	llmRes, err := nlq.InvokeBedrockClaude(ctx, br, prompt)
+	llmRes, err := nlq.InvokeBedrock(ctx, br, prompt)
 	if err != nil {
-		return jsonErr(http.StatusInternalServerError, "bedrock_error", err), nil
+		ops.Beat(ctx, h.ddb, ops.NLQ, "ask", err)
+		return fail(http.StatusInternalServerError, "bedrock_error", err)
 	}
 
+	// SQL the model doubts is asked back rather than run, or run and
+	// flagged (NLQ_CONFIDENCE_FLOOR, NLQ_LOW_CONFIDENCE_MODE).
+	confidence := nlq.ConfidencePolicyFromEnv()
+	rec.LowConfidence = confidence.Route(llmRes, lang)
+
 	// Clarification branch
 	if llmRes.NeedsClarification {
-		return jsonOK(map[string]any{
-			"type":                "clarification",
-			"clarifying_question": llmRes.ClarifyingQuestion,
-			"assumptions":         llmRes.Assumptions,
-			"confidence":          llmRes.Confidence,
-		}), nil
+		rec.Outcome = nlq.AskClarification
+		h.appendClarification(ctx, sub, body, llmRes)
+		return clarificationResp(llmRes, rec.LowConfidence), nil
 	}
 
-	// Validate initial SQL (Step 12 includes dt lookback bound)
+	// Validation (Step 12 includes dt lookback bound), scoping and the run
+	// of the initial SQL and of any rewrite: ExecuteWithSelfCorrection.
 	sqlValidate := nlq.ValidateOptions{
 		AllowedShopIDs:  allowedShopIDs,
 		RequireDTFilter: true,
 		MaxDaysLookback: maxDays,
 		TodayISO:        today,
+		Tables:          schemas,
 	}
-	if err := nlq.ValidateSQL(llmRes.SQL, sqlValidate); err != nil {
-		return jsonOK(map[string]any{
-			"type":        "sql_rejected",
-			"reason":      err.Error(),
-			"model_sql":   llmRes.SQL,
-			"assumptions": llmRes.Assumptions,
-			"confidence":  llmRes.Confidence,
-		}), nil
-	}
+	rec.SQL, rec.Model = llmRes.SQL, llmRes.Model
 
 	// Athena run options
 	athOpt := nlq.AthenaRunOptions{
 		Database:       strings.TrimSpace(os.Getenv("ATHENA_DATABASE")),
 		Workgroup:      strings.TrimSpace(os.Getenv("ATHENA_WORKGROUP")),
 		OutputLocation: strings.TrimSpace(os.Getenv("ATHENA_OUTPUT_S3")),
-		MaxWait:        25 * time.Second,
+		MaxWait:        askMaxWait,
 		PollInterval:   700 * time.Millisecond,
 		MaxResultRows:  200,
 	}
 
-	// Execute with self-correction (2 fix attempts)
-	finalLLM, athRes, runErr := nlq.ExecuteWithSelfCorrection(
+	// Execute with self-correction (NLQ_FIX_* policy)
+	finalLLM, athRes, fix, runErr := nlq.ExecuteWithSelfCorrection(
 		ctx,
 		br,  // BedrockClient
 		ath, // AthenaClient
@@ -190,8 +332,26 @@ func (h *AskHandler) Handle(ctx context.Context, req events.APIGatewayV2HTTPRequ
 		today,
 		tz,
 		llmRes,
-		2, // max fix attempts
+		nlq.FixPolicyFromEnv(),
 	)
+	rec.FixAttempts, rec.FixFailures = fix.Attempts, fix.Failures
+	rec.LowConfidence = confidence.AfterFix(finalLLM)
+	rec.Validation = nlq.ValidationPassed
+
+	// The model's first SQL failed validation; it is not rewritten.
+	var rejected *nlq.RejectedError
+	if errors.As(runErr, &rejected) && rejected.Label == "initial" {
+		rec.Outcome, rec.Validation, rec.Reason = nlq.AskSQLRejected, nlq.ValidationRejected, rejected.Err.Error()
+		h.recordRejection(ctx, sub, body.Question, llmRes.SQL, "initial", allowedShopIDs, rejected.Err)
+		return jsonOK(map[string]any{
+			"type":        "sql_rejected",
+			"reason":      rejected.Err.Error(),
+			"model_sql":   llmRes.SQL,
+			"assumptions": llmRes.Assumptions,
+			"confidence":  llmRes.Confidence,
+		}), nil
+	}
+
 	if runErr != nil {
 		lastSQL := ""
 		lastAssumptions := []string(nil)
@@ -201,6 +361,32 @@ func (h *AskHandler) Handle(ctx context.Context, req events.APIGatewayV2HTTPRequ
 			lastAssumptions = finalLLM.Assumptions
 			lastConfidence = finalLLM.Confidence
 		}
+		rec.Outcome, rec.Reason = nlq.AskAthenaFailed, runErr.Error()
+		if lastSQL != "" {
+			rec.SQL = lastSQL
+		}
+		var athErr *nlq.AthenaError
+		if errors.As(runErr, &athErr) {
+			rec.QueryID = athErr.QueryExecutionID
+		}
+		if athErr != nil && athErr.State == nlq.AthenaStateTimeout && finalLLM != nil {
+			// Athena keeps running; GET /ask/result/{queryId} collects it.
+			rec.Outcome, rec.Reason, rec.Model = nlq.AskPending, "", finalLLM.Model
+			return jsonStatus(http.StatusAccepted, map[string]any{
+				"type":        "pending",
+				"query_id":    athErr.QueryExecutionID,
+				"sql":         lastSQL,
+				"explanation": nlq.ExplainSQL(lastSQL, schemas),
+				"assumptions": lastAssumptions,
+				"confidence":  lastConfidence,
+				"model":       finalLLM.Model,
+				"context":     pinned,
+			}), nil
+		}
+		if rejected != nil {
+			rec.Validation = nlq.ValidationRejected
+			h.recordRejection(ctx, sub, body.Question, lastSQL, "fix", allowedShopIDs, runErr)
+		}
 		return jsonOK(map[string]any{
 			"type":        "athena_failed",
 			"error":       runErr.Error(),
@@ -212,43 +398,252 @@ func (h *AskHandler) Handle(ctx context.Context, req events.APIGatewayV2HTTPRequ
 
 	// Clarification after a fix attempt (rare, but allowed)
 	if athRes == nil && finalLLM != nil && finalLLM.NeedsClarification {
-		return jsonOK(map[string]any{
-			"type":                "clarification",
-			"clarifying_question": finalLLM.ClarifyingQuestion,
-			"assumptions":         finalLLM.Assumptions,
-			"confidence":          finalLLM.Confidence,
-		}), nil
+		rec.Outcome = nlq.AskClarification
+		h.appendClarification(ctx, sub, body, finalLLM)
+		return clarificationResp(finalLLM, rec.LowConfidence), nil
+	}
+
+	rec.Outcome, rec.SQL, rec.Model = nlq.AskResult, finalLLM.SQL, finalLLM.Model
+	metering.RecordNLQScan(ctx, h.ddb, sub, athRes.ScannedBytes)
+	rec.QueryID, rec.ScannedBytes, rec.ExecMs = athRes.QueryExecutionID, athRes.ScannedBytes, athRes.ExecutionMs
+
+	// Plain-language answer; the rows are returned either way.
+	currency := ""
+	if pinned != nil {
+		currency = pinned.Currency
+	}
+	answer, err := nlq.SynthesizeAnswer(ctx, br, nlq.AnswerRequest{
+		Question:    body.Question,
+		SQL:         finalLLM.SQL,
+		Columns:     athRes.Columns,
+		Rows:        athRes.Rows,
+		Assumptions: finalLLM.Assumptions,
+		TodayISO:    today,
+		Currency:    currency,
+		Language:    lang,
+	})
+	if err != nil {
+		fmt.Printf("ask: answer synthesis failed: %v\n", err)
+	}
+
+	// Cache successful result; a flagged one is worth asking again.
+	if useCache && !rec.LowConfidence {
+		_ = nlq.PutCached(ctx, h.ddb, ck, nlq.CachedResponse{
+			SQL:          finalLLM.SQL,
+			Columns:      athRes.Columns,
+			Rows:         athRes.Rows,
+			Assumptions:  finalLLM.Assumptions,
+			Confidence:   finalLLM.Confidence,
+			ScannedBytes: athRes.ScannedBytes,
+			ExecMs:       athRes.ExecutionMs,
+			QueryID:      athRes.QueryExecutionID,
+			Answer:       answer,
+			Model:        finalLLM.Model,
+		})
 	}
 
-	// Cache successful result
-	_ = nlq.PutCached(ctx, h.ddb, ck, nlq.CachedResponse{
-		SQL:          finalLLM.SQL,
-		Columns:      athRes.Columns,
-		Rows:         athRes.Rows,
-		Assumptions:  finalLLM.Assumptions,
-		Confidence:   finalLLM.Confidence,
-		ScannedBytes: athRes.ScannedBytes,
-		ExecMs:       athRes.ExecutionMs,
-		QueryID:      athRes.QueryExecutionID,
+	h.appendTurn(ctx, sub, body.SessionID, nlq.Turn{
+		Question:    body.Question,
+		SQL:         finalLLM.SQL,
+		Assumptions: finalLLM.Assumptions,
+		Answer:      turnAnswer(answer, athRes.Columns, athRes.Rows),
 	})
 
+	ops.Beat(ctx, h.ddb, ops.NLQ, "ask", nil)
+	// For onboarding; a cached answer implies an earlier fresh one.
+	_ = users.MarkFirstAsk(ctx, h.ddb, sub)
+
 	// Success: return results
 	return jsonOK(map[string]any{
-		"type":          "result",
-		"sql":           finalLLM.SQL,
-		"assumptions":   finalLLM.Assumptions,
-		"confidence":    finalLLM.Confidence,
-		"result":        nlq.ShapeResult(athRes.Columns, athRes.Rows),
-		"query_id":      athRes.QueryExecutionID,
-		"scanned_bytes": athRes.ScannedBytes,
-		"exec_ms":       athRes.ExecutionMs,
+		"type":           "result",
+		"answer":         answer,
+		"sql":            finalLLM.SQL,
+		"explanation":    nlq.ExplainSQL(finalLLM.SQL, schemas),
+		"assumptions":    finalLLM.Assumptions,
+		"confidence":     finalLLM.Confidence,
+		"result":         nlq.ShapeResult(athRes.Columns, athRes.Rows),
+		"query_id":       athRes.QueryExecutionID,
+		"scanned_bytes":  athRes.ScannedBytes,
+		"exec_ms":        athRes.ExecutionMs,
+		"model":          finalLLM.Model,
+		"context":        pinned,
+		"language":       lang,
+		"low_confidence": rec.LowConfidence,
 	}), nil
 }
 
+// resolvePinnedContext returns the context to apply to this ask. With a
+// session_id, a context in the body is saved for the session; otherwise the
+// session's saved context is loaded. Lookup failures degrade to no context.
+func (h *AskHandler) resolvePinnedContext(ctx context.Context, sub string, body AskRequest, today string, maxDays int) (*nlq.PinnedContext, error) {
+	sessionID := strings.TrimSpace(body.SessionID)
+	if sessionID != "" && !nlq.ValidSessionID(sessionID) {
+		return nil, fmt.Errorf("invalid session_id")
+	}
+
+	if body.Context != nil {
+		p := *body.Context
+		if err := p.Normalize(today, maxDays); err != nil {
+			return nil, err
+		}
+		if sessionID != "" {
+			if err := nlq.PutPinnedContext(ctx, h.ddb, sub, sessionID, p); err != nil {
+				fmt.Printf("ask: save pinned context failed: %v\n", err)
+			}
+		}
+		if p.IsEmpty() {
+			return nil, nil
+		}
+		return &p, nil
+	}
+
+	if sessionID == "" {
+		return nil, nil
+	}
+	p, err := nlq.GetPinnedContext(ctx, h.ddb, sub, sessionID)
+	if err != nil {
+		fmt.Printf("ask: load pinned context failed: %v\n", err)
+		return nil, nil
+	}
+	if p.IsEmpty() {
+		return nil, nil
+	}
+	// Re-clamp: the range may have been pinned on an earlier day.
+	if err := p.Normalize(today, maxDays); err != nil {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// sessionHistory loads the latest turns of a session; none without a
+// session_id or when the lookup fails. An invalid session_id has already
+// been rejected by resolvePinnedContext.
+func (h *AskHandler) sessionHistory(ctx context.Context, sub, sessionID string) []nlq.Turn {
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" {
+		return nil
+	}
+	turns, err := nlq.GetHistory(ctx, h.ddb, sub, sessionID, nlq.HistoryTurns)
+	if err != nil {
+		fmt.Printf("ask: load conversation failed: %v\n", err)
+		return nil
+	}
+	return turns
+}
+
+// appendTurn adds an answered question to the session's history. Failures
+// are logged only; the answer is still returned.
+func (h *AskHandler) appendTurn(ctx context.Context, sub, sessionID string, t nlq.Turn) {
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" {
+		return
+	}
+	if err := nlq.AppendTurn(ctx, h.ddb, sub, sessionID, t); err != nil {
+		fmt.Printf("ask: save conversation failed: %v\n", err)
+	}
+}
+
+// turnAnswer is what the history keeps of a result: the synthesized answer
+// when there is one, else the first rows.
+func turnAnswer(answer string, columns []string, rows []map[string]any) string {
+	if answer != "" {
+		return answer
+	}
+	return nlq.AnswerText(columns, rows)
+}
+
+// appendClarification keeps a clarifying question in the history so the
+// user's reply to it is read as an answer, not a new question.
+func (h *AskHandler) appendClarification(ctx context.Context, sub string, body AskRequest, res *nlq.LLMResult) {
+	answer := "(asked for clarification)"
+	if res.ClarifyingQuestion != nil {
+		answer = "Clarification asked: " + *res.ClarifyingQuestion
+	}
+	h.appendTurn(ctx, sub, body.SessionID, nlq.Turn{Question: body.Question, Assumptions: res.Assumptions, Answer: answer})
+}
+
+// modelCacheMaterial keeps answers of a pinned model apart from the chain's.
+func modelCacheMaterial(model string) string {
+	if model == "" {
+		return ""
+	}
+	return "model=" + model
+}
+
+// languageCacheMaterial keys a requested answer language; a detected one
+// follows from the question, which is keyed already.
+func languageCacheMaterial(lang string) string {
+	if lang == "" {
+		return ""
+	}
+	return "language=" + lang
+}
+
+// recordAsk writes rec to the ask log. Failures are logged only; the
+// response is already decided.
+func (h *AskHandler) recordAsk(ctx context.Context, sub string, rec *nlq.AskRecord, started time.Time) {
+	rec.LatencyMs = time.Since(started).Milliseconds()
+	rec.AddSpend(ctx)
+	if err := nlq.RecordAsk(ctx, h.ddb, sub, *rec); err != nil {
+		fmt.Printf("ask: record history failed: %v\n", err)
+	}
+}
+
+// recordRejection logs a validator rejection as a security event and alerts
+// ops once a user crosses the daily shop allowlist violation threshold.
+// Failures are logged only; they must never change the /ask response.
+func (h *AskHandler) recordRejection(ctx context.Context, sub, question, sql, stage string, shops []string, verr error) {
+	kind := nlq.RejectionKind(verr)
+	n, err := security.RecordNLQRejection(ctx, h.ddb, security.NLQRejection{
+		UserSub:  sub,
+		Question: question,
+		SQL:      sql,
+		Reason:   verr.Error(),
+		Kind:     kind,
+		Stage:    stage,
+		Shops:    shops,
+	})
+	if err != nil {
+		fmt.Printf("ask: record rejection failed: %v\n", err)
+		return
+	}
+
+	threshold := security.ShopViolationAlertThreshold()
+	if n == 0 || n%threshold != 0 {
+		return
+	}
+
+	m := notify.New("TrueProfit security: repeated NLQ shop allowlist violations").
+		Line(fmt.Sprintf("User %s triggered %d shop_id allowlist violations today.", sub, n)).
+		Line("").
+		Field("Latest reason", verr.Error()).
+		Field("Question", question).
+		Field("SQL", sql)
+	if err := ops.Notify(ctx, h.sns, m.Subject(), m.Body()); err != nil {
+		fmt.Printf("ask: ops alert failed: %v\n", err)
+	}
+}
+
+// clarificationResp answers an ask with the model's question, initial or
+// from the fix loop alike.
+func clarificationResp(res *nlq.LLMResult, lowConfidence bool) events.APIGatewayV2HTTPResponse {
+	return jsonOK(map[string]any{
+		"type":                "clarification",
+		"clarifying_question": res.ClarifyingQuestion,
+		"assumptions":         res.Assumptions,
+		"confidence":          res.Confidence,
+		"low_confidence":      lowConfidence,
+	})
+}
+
 func jsonOK(v any) events.APIGatewayV2HTTPResponse {
+	return jsonStatus(http.StatusOK, v)
+}
+
+func jsonStatus(status int, v any) events.APIGatewayV2HTTPResponse {
 	b, _ := json.Marshal(v)
 	return events.APIGatewayV2HTTPResponse{
-		StatusCode: http.StatusOK,
+		StatusCode: status,
 		Headers: map[string]string{
 			"Content-Type": "application/json",
 		},
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"backend/internal/nlq"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type AskDigestRequest struct {
+	Question string   `json:"question"`
+	ShopIDs  []string `json:"shop_ids,omitempty"` // optional subset; all shops otherwise
+}
+
+// handleDigests serves the scheduled digests of saved questions (see
+// nlq.Digest):
+//
+//	GET    /ask/digests       list the caller's digests
+//	POST   /ask/digests       save a question; it is first answered tomorrow morning
+//	DELETE /ask/digests/{id}  stop one
+func (h *AskHandler) handleDigests(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub := ""
+	if req.RequestContext.Authorizer.JWT.Claims != nil {
+		sub = req.RequestContext.Authorizer.JWT.Claims["sub"]
+	}
+	sub = strings.TrimSpace(sub)
+	if sub == "" {
+		return jsonErr(http.StatusUnauthorized, "missing_user_sub", nil), nil
+	}
+	id := strings.TrimSpace(req.PathParameters["id"])
+
+	switch {
+	case req.RequestContext.HTTP.Method == http.MethodGet && id == "":
+		digests, err := nlq.ListDigests(ctx, h.ddb, sub)
+		if err != nil {
+			return jsonErr(http.StatusInternalServerError, "digests_load_failed", err), nil
+		}
+		return jsonOK(map[string]any{"digests": digests}), nil
+
+	case req.RequestContext.HTTP.Method == http.MethodPost && id == "":
+		return h.createDigest(ctx, sub, req)
+
+	case req.RequestContext.HTTP.Method == http.MethodDelete && id != "":
+		err := nlq.DeleteDigest(ctx, h.ddb, sub, id)
+		if errors.Is(err, nlq.ErrDigestNotFound) {
+			return jsonErr(http.StatusNotFound, "digest_not_found", nil), nil
+		}
+		if err != nil {
+			return jsonErr(http.StatusInternalServerError, "digest_delete_failed", err), nil
+		}
+		return jsonOK(map[string]any{"deleted": id}), nil
+	}
+	return jsonErr(http.StatusMethodNotAllowed, "method_not_allowed", nil), nil
+}
+
+func (h *AskHandler) createDigest(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	var body AskDigestRequest
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		return jsonErr(http.StatusBadRequest, "invalid_json", err), nil
+	}
+	body.Question = strings.TrimSpace(body.Question)
+	if body.Question == "" {
+		return jsonErr(http.StatusBadRequest, "question_required", nil), nil
+	}
+	if len(body.Question) > nlq.MaxDigestQuestionLen {
+		return jsonErr(http.StatusBadRequest, "question_too_long", nil), nil
+	}
+
+	// Shops are checked now for a clear error, and again at every run.
+	var shops []string
+	if len(body.ShopIDs) > 0 {
+		allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, h.ddb, sub)
+		if err != nil {
+			return jsonErr(http.StatusInternalServerError, "shop_lookup_failed", err), nil
+		}
+		shops = intersectAllowed(body.ShopIDs, allowed)
+		if len(shops) != len(body.ShopIDs) {
+			return jsonErr(http.StatusForbidden, "shop_not_allowed", nil), nil
+		}
+	}
+
+	existing, err := nlq.ListDigests(ctx, h.ddb, sub)
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "digests_load_failed", err), nil
+	}
+	if len(existing) >= nlq.MaxDigests {
+		return jsonErr(http.StatusConflict, "too_many_digests", nil), nil
+	}
+
+	d, err := nlq.PutDigest(ctx, h.ddb, sub, nlq.Digest{Question: body.Question, ShopIDs: shops})
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "digest_save_failed", err), nil
+	}
+	return jsonStatus(http.StatusCreated, d), nil
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"backend/internal/nlq"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// askMaxDays is the /ask lookback bound (days before today dt may start).
+const askMaxDays = 90
+
+const (
+	maxCorrectionLen = 4000
+	maxCommentLen    = 1000
+)
+
+type AskFeedbackRequest struct {
+	QueryID    string `json:"query_id"`
+	Rating     string `json:"rating"`               // "up" or "down"
+	Correction string `json:"correction,omitempty"` // SQL that answers the question instead
+	Comment    string `json:"comment,omitempty"`
+}
+
+// handleFeedback serves POST /ask/feedback: the user rates an /ask result
+// by its query_id, optionally with corrected SQL. Ratings feed the few-shot
+// examples of later prompts (see nlq.RecordFeedback); a correction is held
+// to the same validator as model SQL before it can become one.
+func (h *AskHandler) handleFeedback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RequestContext.HTTP.Method != http.MethodPost {
+		return jsonErr(http.StatusMethodNotAllowed, "method_not_allowed", nil), nil
+	}
+	sub := ""
+	if req.RequestContext.Authorizer.JWT.Claims != nil {
+		sub = req.RequestContext.Authorizer.JWT.Claims["sub"]
+	}
+	sub = strings.TrimSpace(sub)
+	if sub == "" {
+		return jsonErr(http.StatusUnauthorized, "missing_user_sub", nil), nil
+	}
+
+	var body AskFeedbackRequest
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		return jsonErr(http.StatusBadRequest, "invalid_json", err), nil
+	}
+	body.QueryID = strings.TrimSpace(body.QueryID)
+	body.Correction = strings.TrimSpace(body.Correction)
+	body.Comment = strings.TrimSpace(body.Comment)
+	if body.QueryID == "" {
+		return jsonErr(http.StatusBadRequest, "query_id_required", nil), nil
+	}
+	if body.Rating != nlq.RatingUp && body.Rating != nlq.RatingDown {
+		return jsonErr(http.StatusBadRequest, "rating_must_be_up_or_down", nil), nil
+	}
+	if len(body.Correction) > maxCorrectionLen || len(body.Comment) > maxCommentLen {
+		return jsonErr(http.StatusBadRequest, "feedback_too_long", nil), nil
+	}
+
+	ask, err := nlq.GetAskByQueryID(ctx, h.ddb, sub, body.QueryID)
+	if errors.Is(err, nlq.ErrAskNotFound) {
+		return jsonErr(http.StatusNotFound, "query_not_found", nil), nil
+	}
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "history_load_failed", err), nil
+	}
+	if ask.Outcome == nlq.AskPending {
+		return jsonErr(http.StatusConflict, "query_pending", nil), nil
+	}
+
+	if body.Correction != "" {
+		allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, h.ddb, sub)
+		if err != nil {
+			return jsonErr(http.StatusInternalServerError, "shop_lookup_failed", err), nil
+		}
+		schemas, err := nlq.LoadSchemasFromEnv(ctx, h.glue)
+		if err != nil {
+			return jsonErr(http.StatusInternalServerError, "glue_get_table_failed", err), nil
+		}
+		if err := nlq.ValidateSQL(body.Correction, nlq.ValidateOptions{
+			AllowedShopIDs:  allowed,
+			RequireDTFilter: true,
+			MaxDaysLookback: askMaxDays,
+			TodayISO:        nlq.TodayISO(),
+			Tables:          schemas,
+		}); err != nil {
+			h.recordRejection(ctx, sub, ask.Question, body.Correction, "feedback", allowed, err)
+			return jsonErr(http.StatusBadRequest, "correction_rejected", err), nil
+		}
+	}
+
+	err = nlq.RecordFeedback(ctx, h.ddb, sub, ask, nlq.Feedback{
+		QueryID:    body.QueryID,
+		Rating:     body.Rating,
+		Correction: body.Correction,
+		Comment:    body.Comment,
+	})
+	if errors.Is(err, nlq.ErrAlreadyRated) {
+		return jsonErr(http.StatusConflict, "already_rated", nil), nil
+	}
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "feedback_save_failed", err), nil
+	}
+	return jsonOK(map[string]any{"ok": true}), nil
+}
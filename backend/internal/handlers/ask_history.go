@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"backend/internal/nlq"
+	"backend/internal/pagination"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// handleHistory serves GET /ask/history: the caller's asks, newest first,
+// with the SQL the model wrote, how the validator and Athena handled it and
+// what it cost (see nlq.AskRecord). Paged with ?limit= and ?nextToken=.
+func (h *AskHandler) handleHistory(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RequestContext.HTTP.Method != http.MethodGet {
+		return jsonErr(http.StatusMethodNotAllowed, "method_not_allowed", nil), nil
+	}
+	sub := ""
+	if req.RequestContext.Authorizer.JWT.Claims != nil {
+		sub = req.RequestContext.Authorizer.JWT.Claims["sub"]
+	}
+	sub = strings.TrimSpace(sub)
+	if sub == "" {
+		return jsonErr(http.StatusUnauthorized, "missing_user_sub", nil), nil
+	}
+
+	limit, err := askHistoryPageSize.Limit(req.QueryStringParameters["limit"])
+	if err != nil {
+		return jsonErr(http.StatusBadRequest, "invalid_limit", err), nil
+	}
+	startKey, err := pagination.DecodeToken(scopeAskHistory, sub, req.QueryStringParameters["nextToken"])
+	if err != nil {
+		return jsonErr(http.StatusBadRequest, "invalid_next_token", err), nil
+	}
+
+	records, lek, err := nlq.ListAsks(ctx, h.ddb, sub, limit, startKey)
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "history_load_failed", err), nil
+	}
+	nextToken, err := pagination.EncodeToken(scopeAskHistory, sub, lek)
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "next_token_failed", err), nil
+	}
+
+	return jsonOK(map[string]any{
+		"items":     records,
+		"nextToken": nextToken,
+	}), nil
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/internal/metering"
+	"backend/internal/nlq"
+	"backend/internal/ops"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/athena"
+	bedrockruntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// askMaxWait is how long /ask waits for Athena before answering "pending";
+// it leaves the model calls room inside the 25s function timeout.
+const askMaxWait = 12 * time.Second
+
+// resultMaxWait is how long one GET /ask/result waits for Athena.
+const resultMaxWait = 5 * time.Second
+
+// handleResult serves GET /ask/result/{queryId}: the result of an ask whose
+// Athena query outlived the /ask request. While the query runs it answers
+// 202 "pending" again; once it succeeds the rows are shaped and answered
+// like a fresh /ask, and the ask log entry is completed. Only the caller's
+// own asks can be collected.
+func (h *AskHandler) handleResult(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RequestContext.HTTP.Method != http.MethodGet {
+		return jsonErr(http.StatusMethodNotAllowed, "method_not_allowed", nil), nil
+	}
+	sub := ""
+	if req.RequestContext.Authorizer.JWT.Claims != nil {
+		sub = req.RequestContext.Authorizer.JWT.Claims["sub"]
+	}
+	sub = strings.TrimSpace(sub)
+	if sub == "" {
+		return jsonErr(http.StatusUnauthorized, "missing_user_sub", nil), nil
+	}
+	qid := strings.TrimSpace(req.PathParameters["queryId"])
+	if qid == "" {
+		return jsonErr(http.StatusBadRequest, "query_id_required", nil), nil
+	}
+	ctx = metering.WithAttribution(ctx, h.ddb, sub, metering.FeatureAsk)
+
+	rec, err := nlq.GetAskByQueryID(ctx, h.ddb, sub, qid)
+	if errors.Is(err, nlq.ErrAskNotFound) {
+		return jsonErr(http.StatusNotFound, "query_not_found", nil), nil
+	}
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "history_load_failed", err), nil
+	}
+
+	res, err := nlq.AwaitAthenaQuery(ctx, athena.NewFromConfig(h.cfg), qid, nlq.AthenaRunOptions{
+		MaxWait:       resultMaxWait,
+		PollInterval:  700 * time.Millisecond,
+		MaxResultRows: 200,
+	})
+	var athErr *nlq.AthenaError
+	if errors.As(err, &athErr) && athErr.State == nlq.AthenaStateTimeout {
+		return jsonStatus(http.StatusAccepted, map[string]any{
+			"type":        "pending",
+			"query_id":    qid,
+			"sql":         rec.SQL,
+			"explanation": nlq.ExplainSQL(rec.SQL, nil),
+			"model":       rec.Model,
+		}), nil
+	}
+	if athErr != nil {
+		if rec.Outcome == nlq.AskPending {
+			rec.Outcome, rec.Reason = nlq.AskAthenaFailed, err.Error()
+			h.completeAsk(ctx, sub, rec)
+		}
+		return jsonOK(map[string]any{
+			"type":     "athena_failed",
+			"error":    err.Error(),
+			"last_sql": rec.SQL,
+		}), nil
+	}
+	if err != nil {
+		return jsonErr(http.StatusInternalServerError, "athena_result_failed", err), nil
+	}
+
+	// A completed ask can be collected again; only the first collection
+	// answers, meters and records it.
+	answer := ""
+	if rec.Outcome == nlq.AskPending {
+		metering.RecordNLQScan(ctx, h.ddb, sub, res.ScannedBytes)
+		answer, err = nlq.SynthesizeAnswer(ctx, bedrockruntime.NewFromConfig(h.cfg), nlq.AnswerRequest{
+			Question: rec.Question,
+			SQL:      rec.SQL,
+			Columns:  res.Columns,
+			Rows:     res.Rows,
+			TodayISO: nlq.TodayISO(),
+			Language: rec.Language,
+		})
+		if err != nil {
+			fmt.Printf("ask: answer synthesis failed: %v\n", err)
+		}
+		rec.Outcome, rec.ScannedBytes, rec.ExecMs = nlq.AskResult, res.ScannedBytes, res.ExecutionMs
+		h.completeAsk(ctx, sub, rec)
+		h.appendTurn(ctx, sub, rec.SessionID, nlq.Turn{
+			Question: rec.Question,
+			SQL:      rec.SQL,
+			Answer:   turnAnswer(answer, res.Columns, res.Rows),
+		})
+		ops.Beat(ctx, h.ddb, ops.NLQ, "ask", nil)
+	}
+
+	return jsonOK(map[string]any{
+		"type":          "result",
+		"answer":        answer,
+		"sql":           rec.SQL,
+		"explanation":   nlq.ExplainSQL(rec.SQL, nil),
+		"result":        nlq.ShapeResult(res.Columns, res.Rows),
+		"query_id":      qid,
+		"scanned_bytes": res.ScannedBytes,
+		"exec_ms":       res.ExecutionMs,
+		"model":         rec.Model,
+		"language":      rec.Language,
+	}), nil
+}
+
+// completeAsk records the final outcome of a pending ask, with what
+// collecting it spent. Failures are logged only, like recordAsk.
+func (h *AskHandler) completeAsk(ctx context.Context, sub string, rec nlq.AskRecord) {
+	rec.AddSpend(ctx)
+	if err := nlq.CompleteAsk(ctx, h.ddb, sub, rec); err != nil {
+		fmt.Printf("ask: complete history failed: %v\n", err)
+	}
+}
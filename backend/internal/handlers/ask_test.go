@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"backend/internal/nlq"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestClarificationRespCarriesLowConfidence(t *testing.T) {
+	res := &nlq.LLMResult{NeedsClarification: true, ClarifyingQuestion: aws.String("Which shop?"), Confidence: 0.8}
+	policy := nlq.ConfidencePolicy{Floor: 0.4, Mode: nlq.LowConfidenceClarify}
+
+	// A clarification the fix loop ended on, as ask builds it.
+	resp := clarificationResp(res, policy.AfterFix(res))
+	var body map[string]any
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["type"] != "clarification" || body["low_confidence"] != true {
+		t.Fatalf("body = %s, want a clarification with low_confidence true", resp.Body)
+	}
+}
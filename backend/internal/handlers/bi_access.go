@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"backend/internal/bi"
+	"backend/internal/db"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// BIAccessHandler lets users point their own BI tool (Metabase, Looker, ...)
+// at Athena with credentials that can only see their own shops.
+func BIAccessHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RawPath {
+	case "/analytics/bi-access":
+		switch req.RequestContext.HTTP.Method {
+		case "GET":
+			return biAccessStatus(ctx, req)
+		case "POST":
+			return biAccessProvision(ctx, req)
+		case "DELETE":
+			return biAccessRevoke(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/analytics/bi-access/credentials":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return biAccessCredentials(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+type biAccessRequest struct {
+	// Shops optionally narrows access to a subset of the caller's shops.
+	Shops []string `json:"shops"`
+}
+
+func biAccessStatus(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	acc, err := bi.Load(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load bi access")
+	}
+	if acc == nil {
+		return jsonResp(200, map[string]any{"provisioned": false})
+	}
+	return jsonResp(200, map[string]any{"provisioned": true, "access": acc})
+}
+
+// biAccessProvision serves POST /analytics/bi-access. It is safe to call
+// again after connecting or removing shops; the response includes a first
+// set of credentials.
+func biAccessProvision(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	var body biAccessRequest
+	if strings.TrimSpace(req.Body) != "" {
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			return errResp(400, "invalid json")
+		}
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load shops")
+	}
+	shops := allowed
+	if len(body.Shops) > 0 {
+		allowedSet := map[string]bool{}
+		for _, s := range allowed {
+			allowedSet[s] = true
+		}
+		shops = nil
+		for _, s := range body.Shops {
+			s = strings.TrimSpace(s)
+			if !allowedSet[s] {
+				return errResp(403, fmt.Sprintf("shop not connected: %s", s))
+			}
+			shops = append(shops, s)
+		}
+	}
+	if len(shops) == 0 {
+		return errResp(400, "no connected shops")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	p := bi.NewProvisioner(cfg)
+
+	acc, err := p.Provision(ctx, ddb, sub, shops)
+	if err != nil {
+		return errResp(502, err.Error())
+	}
+	return biCredentialsResp(ctx, p, acc, sub)
+}
+
+// biAccessCredentials serves POST /analytics/bi-access/credentials for a fresh
+// set of short-lived keys once the previous ones expire.
+func biAccessCredentials(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	acc, err := bi.Load(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load bi access")
+	}
+	if acc == nil {
+		return errResp(404, "bi access not provisioned")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	return biCredentialsResp(ctx, bi.NewProvisioner(cfg), acc, sub)
+}
+
+func biCredentialsResp(ctx context.Context, p *bi.Provisioner, acc *bi.Access, sub string) (events.APIGatewayV2HTTPResponse, error) {
+	session := strings.ReplaceAll(sub, "-", "")
+	if len(session) > 24 {
+		session = session[:24]
+	}
+	creds, err := p.IssueCredentials(ctx, acc, "tp-bi-"+session)
+	if errors.Is(err, bi.ErrPolicyTooLarge) {
+		return errResp(400, "too many shops for one credential; pass a subset in \"shops\"")
+	}
+	if err != nil {
+		return errResp(502, "failed to issue credentials")
+	}
+
+	return jsonResp(200, map[string]any{
+		"access":      acc,
+		"credentials": creds,
+		"connection": map[string]any{
+			"region":       p.Region(),
+			"workgroup":    acc.Workgroup,
+			"database":     acc.Database,
+			"table":        acc.Table,
+			"s3StagingDir": acc.OutputS3,
+			"jdbcUrl":      fmt.Sprintf("jdbc:athena://AwsRegion=%s;Workgroup=%s;Catalog=AwsDataCatalog;Schema=%s", p.Region(), acc.Workgroup, acc.Database),
+		},
+	})
+}
+
+func biAccessRevoke(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	if err := bi.NewProvisioner(cfg).Deprovision(ctx, ddb, sub); err != nil {
+		return errResp(502, err.Error())
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"backend/internal/changelog"
+	"backend/internal/db"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ChangelogHandler serves the what's-new feed: GET /changelog lists release
+// notes with the caller's unread count, POST /changelog/read marks them read.
+func ChangelogHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	switch req.RawPath {
+	case "/changelog":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return listChangelog(ctx, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/changelog/read":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return markChangelogRead(ctx, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func listChangelog(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	limit, err := changelogPageSize.Limit(req.QueryStringParameters["limit"])
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	entries, err := changelog.List(ctx, ddb, int32(limit))
+	if err != nil {
+		return errResp(500, "changelog query failed")
+	}
+	readAt, err := changelog.LastRead(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "changelog query failed")
+	}
+
+	return jsonResp(200, map[string]any{
+		"items":      entries,
+		"lastReadAt": readAt,
+		"unread":     changelog.Unread(entries, readAt),
+	})
+}
+
+// markChangelogRead accepts an optional {"until": "<publishedAt>"} so the
+// frontend can mark only what it actually showed; default is now.
+func markChangelogRead(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	var body struct {
+		Until string `json:"until"`
+	}
+	if strings.TrimSpace(req.Body) != "" {
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			return errResp(400, "invalid json")
+		}
+	}
+	now := time.Now().UTC()
+	at := now.Format(time.RFC3339)
+	if body.Until != "" {
+		t, err := time.Parse(time.RFC3339, body.Until)
+		if err != nil {
+			return errResp(400, "until must be RFC3339")
+		}
+		if t.Before(now) {
+			at = t.UTC().Format(time.RFC3339)
+		}
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	if err := changelog.MarkRead(ctx, ddb, sub, at); err != nil {
+		return errResp(500, "failed to save read marker")
+	}
+	return jsonResp(200, map[string]any{"ok": true, "lastReadAt": at})
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"backend/internal/costs"
+	"backend/internal/db"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// productCosts manages a shop's manual cost of goods:
+//
+//	GET    /costs?shop=                                   all overrides
+//	PUT    /costs/skus    {"shop","sku","unitCost"}
+//	DELETE /costs/skus?shop=&sku=
+//	PUT    /costs/orders  {"shop","orderId","cost"}
+//	DELETE /costs/orders?shop=&orderId=
+//
+// Costs belong to the shop, so every user of the shop sees the same ones.
+func productCosts(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	method := req.RequestContext.HTTP.Method
+	q := req.QueryStringParameters
+
+	var in struct {
+		Shop     string   `json:"shop"`
+		SKU      string   `json:"sku"`
+		OrderID  string   `json:"orderId"`
+		UnitCost *float64 `json:"unitCost"`
+		Cost     *float64 `json:"cost"`
+	}
+	if method == "PUT" {
+		if err := json.Unmarshal([]byte(req.Body), &in); err != nil {
+			return errResp(400, "invalid json body")
+		}
+	} else {
+		in.Shop, in.SKU, in.OrderID = q["shop"], q["sku"], q["orderId"]
+	}
+	shop, resp, ok := ownedShop(ctx, client, sub, in.Shop)
+	if !ok {
+		return resp, nil
+	}
+	sku := strings.TrimSpace(in.SKU)
+	orderID := strings.TrimSpace(in.OrderID)
+
+	switch req.RawPath {
+	case "/costs":
+		if method != "GET" {
+			return errResp(405, "method not allowed")
+		}
+		book, err := costs.Load(ctx, client, shop)
+		if err != nil {
+			return errResp(500, "failed to load product costs")
+		}
+		return jsonResp(200, map[string]any{"shop": shop, "items": book.Overrides()})
+
+	case "/costs/skus":
+		if sku == "" {
+			return errResp(400, "sku is required")
+		}
+		switch method {
+		case "PUT":
+			if in.UnitCost == nil || *in.UnitCost < 0 {
+				return errResp(400, "unitCost must be a non-negative number")
+			}
+			if err := costs.SetSKU(ctx, client, shop, sku, *in.UnitCost, sub); err != nil {
+				return errResp(500, "failed to save product cost")
+			}
+			return jsonResp(200, map[string]any{"shop": shop, "sku": sku, "unitCost": *in.UnitCost})
+		case "DELETE":
+			return deleteCost(costs.DeleteSKU(ctx, client, shop, sku))
+		}
+		return errResp(405, "method not allowed")
+
+	case "/costs/orders":
+		if orderID == "" {
+			return errResp(400, "orderId is required")
+		}
+		switch method {
+		case "PUT":
+			if in.Cost == nil || *in.Cost < 0 {
+				return errResp(400, "cost must be a non-negative number")
+			}
+			if err := costs.SetOrder(ctx, client, shop, orderID, *in.Cost, sub); err != nil {
+				return errResp(500, "failed to save order cost")
+			}
+			return jsonResp(200, map[string]any{"shop": shop, "orderId": orderID, "cost": *in.Cost})
+		case "DELETE":
+			return deleteCost(costs.DeleteOrder(ctx, client, shop, orderID))
+		}
+		return errResp(405, "method not allowed")
+	}
+	return errResp(404, "not found")
+}
+
+func deleteCost(found bool, err error) (events.APIGatewayV2HTTPResponse, error) {
+	if err != nil {
+		return errResp(500, "failed to delete cost")
+	}
+	if !found {
+		return errResp(404, "cost not found")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+// ownedShop resolves shop among the caller's shops (defaulting to the only
+// one), answering 404 alike for "not yours" and "doesn't exist".
+func ownedShop(ctx context.Context, client *dynamodb.Client, sub, shop string) (string, events.APIGatewayV2HTTPResponse, bool) {
+	allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, client, sub)
+	if err != nil {
+		resp, _ := errResp(500, "shop lookup failed")
+		return "", resp, false
+	}
+	shop = strings.TrimSpace(shop)
+	if shop == "" {
+		if len(allowed) != 1 {
+			resp, _ := errResp(400, "shop is required")
+			return "", resp, false
+		}
+		shop = allowed[0]
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, shop) {
+			return a, events.APIGatewayV2HTTPResponse{}, true
+		}
+	}
+	resp, _ := errResp(404, "shop not found")
+	return "", resp, false
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/forecast"
+	"backend/internal/rollup"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	defaultDashboardRecent = 10
+	maxDashboardRecent     = 50
+)
+
+// DashboardShop is a connected Shopify store as the dashboard shows it.
+type DashboardShop struct {
+	Shop              string `json:"shop"`
+	LastEventAt       string `json:"lastEventAt"`
+	LastEventTopic    string `json:"lastEventTopic"`
+	LastCheckStatus   string `json:"lastCheckStatus"`
+	InitialSyncStatus string `json:"initialSyncStatus"`
+	WebhooksStatus    string `json:"webhooksStatus"`
+}
+
+// dashboardRunway is forecast.Runway with the currency it is counted in.
+type dashboardRunway struct {
+	forecast.Runway
+	Currency string `json:"currency"`
+}
+
+// dashboard serves GET /dashboard[?recent=N][&cash=X]: today's and this
+// month's totals (shaped like /summary/monthly, UTC periods), the connected
+// shops, the N newest transactions of this month and last, and the runway
+// over the next six months (as /forecast/runway), in one response. The cash
+// balance is ?cash= or, without it, the one saved in settings.
+func dashboard(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	recent := defaultDashboardRecent
+	if v := strings.TrimSpace(req.QueryStringParameters["recent"]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxDashboardRecent {
+			return errResp(400, "recent must be between 0 and "+strconv.Itoa(maxDashboardRecent))
+		}
+		recent = n
+	}
+	cash, err := parseCash(req.QueryStringParameters["cash"])
+	if err != nil {
+		return errResp(400, "cash must be a number")
+	}
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	intTable := db.IntegrationsTableName()
+	if strings.TrimSpace(intTable) == "" {
+		return errResp(500, "INTEGRATIONS_TABLE not set")
+	}
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	now := time.Now().UTC()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+
+	var todayCur, monthCur map[string]*CurrencySummary
+	if rollup.Covers(month) {
+		d, err := rollup.Day(ctx, client, sub, day)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		m, err := rollup.Month(ctx, client, sub, month)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		todayCur, monthCur = rollupByCurrency(d), rollupByCurrency(m)
+	} else {
+		items, err := queryMonthTransactions(ctx, client, table, sub, month)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		var todays []Transaction
+		for _, t := range items {
+			if strings.HasPrefix(t.GSI1SK, day) {
+				todays = append(todays, t)
+			}
+		}
+		todayCur, monthCur = sumByCurrency(todays), sumByCurrency(items)
+	}
+
+	today := MonthlySummary{Month: month, Currency: "USD", ByCategory: map[string]float64{}, From: day, To: day}
+	if err := totalMonthly(ctx, client, sub, &today, todayCur); err != nil {
+		return errResp(500, "failed to load settings")
+	}
+	mtd := MonthlySummary{Month: month, Currency: "USD", ByCategory: map[string]float64{}}
+	if err := totalMonthly(ctx, client, sub, &mtd, monthCur); err != nil {
+		return errResp(500, "failed to load settings")
+	}
+
+	shops, err := dashboardShops(ctx, client, intTable, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	txs, err := recentTransactions(ctx, client, table, sub, month, recent)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+	signReceipts(ctx, txs)
+
+	if cash == nil {
+		s, err := users.GetSettings(ctx, client, sub)
+		if err != nil {
+			return errResp(500, "failed to load settings")
+		}
+		cash = s.CashBalance
+	}
+	rw, currency, err := computeRunway(ctx, client, table, sub, cash, 6)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	return jsonResp(200, map[string]any{
+		"today":        today,
+		"month":        mtd,
+		"shops":        shops,
+		"transactions": txs,
+		"runway":       dashboardRunway{Runway: rw, Currency: currency},
+	})
+}
+
+func dashboardShops(ctx context.Context, client *dynamodb.Client, intTable, sub string) ([]DashboardShop, error) {
+	out, err := client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(intTable),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :pref)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":   &types.AttributeValueMemberS{Value: "USER#" + sub},
+			":pref": &types.AttributeValueMemberS{Value: "SHOPIFY#"},
+		},
+		Limit: aws.Int32(50),
+	})
+	if err != nil {
+		return nil, err
+	}
+	shops := make([]DashboardShop, 0, len(out.Items))
+	for _, it := range out.Items {
+		shops = append(shops, DashboardShop{
+			Shop:              attrS(it["Shop"]),
+			LastEventAt:       attrS(it["LastEventAt"]),
+			LastEventTopic:    attrS(it["LastEventTopic"]),
+			LastCheckStatus:   attrS(it["LastCheckStatus"]),
+			InitialSyncStatus: attrS(it["InitialSyncStatus"]),
+			WebhooksStatus:    attrS(it["WebhooksStatus"]),
+		})
+	}
+	return shops, nil
+}
+
+// recentTransactions returns up to n of the newest non-deleted transactions
+// of month and the month before, newest first.
+func recentTransactions(ctx context.Context, client *dynamodb.Client, table, sub, month string, n int) ([]Transaction, error) {
+	txs := []Transaction{}
+	if n == 0 {
+		return txs, nil
+	}
+	in := &dynamodb.QueryInput{
+		TableName:                aws.String(table),
+		IndexName:                aws.String("GSI1"),
+		KeyConditionExpression:   aws.String("GSI1PK = :pk"),
+		FilterExpression:         aws.String("attribute_not_exists(#deletedAt)"),
+		ExpressionAttributeNames: map[string]string{"#deletedAt": "DeletedAt"},
+		ScanIndexForward:         aws.Bool(false),
+	}
+	for _, m := range []string{month, prevMonth(month)} {
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, m)},
+		}
+		raw, _, err := queryUpTo(ctx, client, in, nil, int32(n-len(txs)))
+		if err != nil {
+			return nil, err
+		}
+		var page []Transaction
+		if err := attributevalue.UnmarshalListOfMaps(raw, &page); err != nil {
+			return nil, err
+		}
+		txs = append(txs, page...)
+		if len(txs) >= n {
+			return txs[:n], nil
+		}
+	}
+	return txs, nil
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"backend/internal/exports"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// exportDestinations manages the customer S3 buckets scheduled exports are
+// copied to (see exports.Destination):
+//
+//	GET    /export-destinations             list destinations with delivery status
+//	POST   /export-destinations             add a destination
+//	PUT    /export-destinations/{id}        replace a destination
+//	DELETE /export-destinations/{id}        remove an unused destination
+//	POST   /export-destinations/{id}/test   write a test file and record the outcome
+func exportDestinations(ctx context.Context, client *dynamodb.Client, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	method := req.RequestContext.HTTP.Method
+
+	if req.RawPath == "/export-destinations" {
+		switch method {
+		case "GET":
+			destinations, err := exports.ListDestinations(ctx, client, sub)
+			if err != nil {
+				return errResp(500, "failed to list destinations")
+			}
+			return jsonResp(200, map[string]any{"items": destinations})
+		case "POST":
+			return putExportDestination(ctx, client, sub, "", req.Body)
+		}
+		return errResp(405, "method not allowed")
+	}
+
+	rest := strings.TrimPrefix(req.RawPath, "/export-destinations/")
+	if !strings.HasPrefix(req.RawPath, "/export-destinations/") || rest == "" {
+		return errResp(404, "not found")
+	}
+	if id, ok := strings.CutSuffix(rest, "/test"); ok && id != "" && !strings.Contains(id, "/") {
+		if method != "POST" {
+			return errResp(405, "method not allowed")
+		}
+		return testExportDestination(ctx, client, sub, id)
+	}
+	id := rest
+	if strings.Contains(id, "/") {
+		return errResp(404, "not found")
+	}
+	switch method {
+	case "PUT":
+		return putExportDestination(ctx, client, sub, id, req.Body)
+	case "DELETE":
+		if _, err := exports.GetDestination(ctx, client, sub, id); errors.Is(err, exports.ErrDestinationNotFound) {
+			return errResp(404, "destination not found")
+		} else if err != nil {
+			return errResp(500, "failed to load destination")
+		}
+		used, err := exports.InUse(ctx, client, sub, id)
+		if err != nil {
+			return errResp(500, "failed to list schedules")
+		}
+		if len(used) > 0 {
+			return jsonResp(409, map[string]any{"error": "destination is used by schedules", "schedules": used})
+		}
+		if err := exports.DeleteDestination(ctx, client, sub, id); err != nil {
+			return errResp(500, "failed to delete destination")
+		}
+		return jsonResp(200, map[string]any{"ok": true})
+	}
+	return errResp(405, "method not allowed")
+}
+
+func putExportDestination(ctx context.Context, client *dynamodb.Client, sub, id, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var d exports.Destination
+	if err := json.Unmarshal([]byte(body), &d); err != nil {
+		return errResp(400, "invalid json")
+	}
+	if err := d.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+	d.Id, d.ExternalId, d.CreatedAt = "", "", ""
+	d.LastStatus, d.LastError, d.LastKey, d.LastDeliveryAt = "", "", "", ""
+
+	status := 201
+	if id != "" {
+		old, err := exports.GetDestination(ctx, client, sub, id)
+		if errors.Is(err, exports.ErrDestinationNotFound) {
+			return errResp(404, "destination not found")
+		}
+		if err != nil {
+			return errResp(500, "failed to load destination")
+		}
+		d.Id, d.ExternalId, d.CreatedAt = old.Id, old.ExternalId, old.CreatedAt
+		if d.Bucket == old.Bucket && d.Prefix == old.Prefix && d.RoleArn == old.RoleArn && d.Region == old.Region {
+			d.LastStatus, d.LastError, d.LastKey, d.LastDeliveryAt = old.LastStatus, old.LastError, old.LastKey, old.LastDeliveryAt
+		}
+		status = 200
+	}
+
+	d, err := exports.PutDestination(ctx, client, sub, d)
+	if err != nil {
+		return errResp(500, "failed to save destination")
+	}
+	return jsonResp(status, d)
+}
+
+// testExportDestination checks the role and bucket setup with a small file,
+// so a misconfiguration shows up before the first scheduled run.
+func testExportDestination(ctx context.Context, client *dynamodb.Client, sub, id string) (events.APIGatewayV2HTTPResponse, error) {
+	d, err := exports.GetDestination(ctx, client, sub, id)
+	if errors.Is(err, exports.ErrDestinationNotFound) {
+		return errResp(404, "destination not found")
+	}
+	if err != nil {
+		return errResp(500, "failed to load destination")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+
+	key, derr := exports.Deliver(ctx, cfg, d, "trueprofit-test.csv", []byte("status\nok\n"))
+	if err := exports.RecordDelivery(ctx, client, d, key, derr); err != nil {
+		return errResp(500, "failed to record delivery")
+	}
+	if derr != nil {
+		return jsonResp(200, map[string]any{"ok": false, "key": key, "error": derr.Error()})
+	}
+	return jsonResp(200, map[string]any{"ok": true, "key": key})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/metrics"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// maxTimeseriesDays bounds one /export/timeseries request. Ranges older
+// than TRENDS_LIVE_DAYS are read from Athena in metrics.MaxDays chunks;
+// closed days are cached, so a timed-out request is cheaper to retry.
+const maxTimeseriesDays = 3 * metrics.MaxDays
+
+var timeseriesMetrics = map[string]func(d trendDay) float64{
+	"revenue":  func(d trendDay) float64 { return d.revenue },
+	"expenses": func(d trendDay) float64 { return d.expenses },
+	"net":      func(d trendDay) float64 { return d.revenue - d.expenses },
+}
+
+// TimeseriesPoint is one bucket of an exported series.
+type TimeseriesPoint struct {
+	Start  string  `json:"start"` // first day in the bucket, inside [from, to]
+	End    string  `json:"end"`
+	Value  float64 `json:"value"`
+	Source string  `json:"source"`
+}
+
+// exportTimeseries serves GET /export/timeseries?metric=revenue|expenses|net
+// &granularity=day|week|month[&from=&to=&format=json|csv]: one metric per
+// bucket, oldest first, with every bucket present, for feeding external
+// dashboards. Days come from the same sources as /analytics/trends. Weeks
+// start on Monday; the range defaults to the last 30 days.
+func exportTimeseries(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+
+	metric := strings.ToLower(strings.TrimSpace(q["metric"]))
+	if metric == "" {
+		metric = "net"
+	}
+	value, ok := timeseriesMetrics[metric]
+	if !ok {
+		return errResp(400, "metric must be revenue, expenses or net")
+	}
+	granularity := strings.ToLower(strings.TrimSpace(q["granularity"]))
+	if granularity == "" {
+		granularity = "day"
+	}
+	if granularity != "day" && granularity != "week" && granularity != "month" {
+		return errResp(400, "granularity must be day, week or month")
+	}
+	format := strings.ToLower(strings.TrimSpace(q["format"]))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		return errResp(400, "format must be json or csv")
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to := today
+	if v := strings.TrimSpace(q["to"]); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return errResp(400, "to must be in format YYYY-MM-DD")
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -29)
+	if v := strings.TrimSpace(q["from"]); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return errResp(400, "from must be in format YYYY-MM-DD")
+		}
+		from = t
+	}
+	if from.After(to) || to.Sub(from) >= maxTimeseriesDays*24*time.Hour {
+		return errResp(400, fmt.Sprintf("from/to must span at most %d days", maxTimeseriesDays))
+	}
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	ddb := dynamodb.NewFromConfig(cfg)
+
+	liveFrom := today.AddDate(0, 0, -trendsLiveDays())
+	days, currency, err := trendDays(ctx, cfg, ddb, table, sub, from, to, liveFrom)
+	if err != nil {
+		return trendDaysErr(err)
+	}
+
+	points := []TimeseriesPoint{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		ds := d.Format("2006-01-02")
+		day := days[ds]
+		newBucket := len(points) == 0 || granularity == "day" ||
+			(granularity == "week" && d.Weekday() == time.Monday) ||
+			(granularity == "month" && d.Day() == 1)
+		if newBucket {
+			points = append(points, TimeseriesPoint{Start: ds, Source: day.source})
+		}
+		p := &points[len(points)-1]
+		p.End = ds
+		p.Value += value(*day)
+		if p.Source != day.source {
+			p.Source = trendsFromMixed
+		}
+	}
+	for i := range points {
+		points[i].Value = math.Round(points[i].Value*100) / 100
+	}
+
+	if format == "csv" {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		_ = w.Write([]string{"start", "end", metric, "currency", "source"})
+		for _, p := range points {
+			_ = w.Write([]string{p.Start, p.End, strconv.FormatFloat(p.Value, 'f', 2, 64), currency, p.Source})
+		}
+		w.Flush()
+		return events.APIGatewayV2HTTPResponse{
+			StatusCode: 200,
+			Headers: map[string]string{
+				"content-type":                "text/csv; charset=utf-8",
+				"content-disposition":         fmt.Sprintf("attachment; filename=\"%s-%s-%s_%s.csv\"", metric, granularity, from.Format("20060102"), to.Format("20060102")),
+				"access-control-allow-origin": "*",
+			},
+			Body: buf.String(),
+		}, nil
+	}
+	return jsonResp(200, map[string]any{
+		"metric":      metric,
+		"granularity": granularity,
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"currency":    currency,
+		"liveFrom":    liveFrom.Format("2006-01-02"),
+		"points":      points,
+	})
+}
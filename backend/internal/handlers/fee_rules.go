@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"backend/internal/db"
+	"backend/internal/feerules"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// FeeRulesHandler manages percent-of-revenue fee rules:
+//
+//	GET    /fee-rules        list rules
+//	POST   /fee-rules        create a rule
+//	PUT    /fee-rules/{id}   replace a rule
+//	DELETE /fee-rules/{id}   stop a rule (posted fees stay)
+//
+// Rules apply to orders as they are ingested or backfilled, so a shop sync
+// also charges a new rule on the past orders it re-reads.
+func FeeRulesHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	method := req.RequestContext.HTTP.Method
+
+	if req.RawPath == "/fee-rules" {
+		switch method {
+		case "GET":
+			rules, err := feerules.List(ctx, client, sub)
+			if err != nil {
+				return errResp(500, "failed to list rules")
+			}
+			return jsonResp(200, map[string]any{"items": rules})
+		case "POST":
+			return putFeeRule(ctx, client, sub, "", req.Body)
+		}
+		return errResp(405, "method not allowed")
+	}
+
+	id := strings.TrimPrefix(req.RawPath, "/fee-rules/")
+	if !strings.HasPrefix(req.RawPath, "/fee-rules/") || id == "" || strings.Contains(id, "/") {
+		return errResp(404, "not found")
+	}
+	switch method {
+	case "PUT":
+		return putFeeRule(ctx, client, sub, id, req.Body)
+	case "DELETE":
+		if _, err := feerules.Get(ctx, client, sub, id); errors.Is(err, feerules.ErrNotFound) {
+			return errResp(404, "rule not found")
+		} else if err != nil {
+			return errResp(500, "failed to load rule")
+		}
+		if err := feerules.Delete(ctx, client, sub, id); err != nil {
+			return errResp(500, "failed to delete rule")
+		}
+		return jsonResp(200, map[string]any{"ok": true})
+	}
+	return errResp(405, "method not allowed")
+}
+
+func putFeeRule(ctx context.Context, client *dynamodb.Client, sub, id, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var r feerules.Rule
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		return errResp(400, "invalid json")
+	}
+	if err := r.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+
+	status := 201
+	if id != "" {
+		old, err := feerules.Get(ctx, client, sub, id)
+		if errors.Is(err, feerules.ErrNotFound) {
+			return errResp(404, "rule not found")
+		}
+		if err != nil {
+			return errResp(500, "failed to load rule")
+		}
+		r.Id, r.CreatedAt = old.Id, old.CreatedAt
+		status = 200
+	}
+
+	r, err := feerules.Put(ctx, client, sub, r)
+	if err != nil {
+		return errResp(500, "failed to save rule")
+	}
+	return jsonResp(status, r)
+}
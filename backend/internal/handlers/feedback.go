@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"backend/internal/db"
+	"backend/internal/feedback"
+	"backend/internal/notify"
+	"backend/internal/ops"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+type feedbackRequest struct {
+	Kind       string   `json:"kind"`
+	Message    string   `json:"message"`
+	Page       string   `json:"page"`
+	RequestIds []string `json:"requestIds"` // ids of the caller's recent failed API calls
+	AppVersion string   `json:"appVersion"`
+}
+
+// FeedbackHandler serves POST /feedback: stores the report with the caller's
+// context and pings the ops topic so someone actually reads it.
+func FeedbackHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RawPath != "/feedback" {
+		return errResp(404, "not found")
+	}
+	if req.RequestContext.HTTP.Method != "POST" {
+		return errResp(405, "method not allowed")
+	}
+	sub, email, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	var body feedbackRequest
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		return errResp(400, "invalid json")
+	}
+	body.Message = strings.TrimSpace(body.Message)
+	if body.Message == "" {
+		return errResp(400, "message is required")
+	}
+	if len(body.Message) > feedback.MaxMessageLen {
+		return errResp(400, fmt.Sprintf("message must be at most %d characters", feedback.MaxMessageLen))
+	}
+	if body.Kind == "" {
+		body.Kind = "other"
+	}
+	if !feedback.Kinds[body.Kind] {
+		return errResp(400, "kind must be bug, wrong_numbers, idea or other")
+	}
+
+	ids := make([]string, 0, len(body.RequestIds))
+	seen := map[string]bool{}
+	for _, id := range body.RequestIds {
+		id = strings.TrimSpace(id)
+		if id == "" || len(id) > 128 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		if len(ids) == feedback.MaxRequestIds {
+			break
+		}
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	// best-effort: the report is still useful without the shop list
+	shops, _ := tenancy.GetAllowedShopsByUserSub(ctx, ddb, sub)
+
+	r := &feedback.Report{
+		UserSub:    sub,
+		Email:      email,
+		Kind:       body.Kind,
+		Message:    body.Message,
+		Page:       notify.Truncate(strings.TrimSpace(body.Page), 500),
+		RequestIds: ids,
+		Shops:      shops,
+		UserAgent:  notify.Truncate(req.RequestContext.HTTP.UserAgent, 500),
+		AppVersion: notify.Truncate(strings.TrimSpace(body.AppVersion), 64),
+	}
+	if err := feedback.Save(ctx, ddb, r); err != nil {
+		return errResp(500, "failed to save feedback")
+	}
+
+	if awsCfg, err := config.LoadDefaultConfig(ctx); err == nil {
+		m := notify.New(fmt.Sprintf("TrueProfit feedback: %s", r.Kind)).
+			Field("Id", r.Id).
+			Field("User", r.UserSub).
+			Field("Email", r.Email).
+			Field("Page", r.Page).
+			Field("Shops", strings.Join(r.Shops, ", ")).
+			Field("RequestIds", strings.Join(r.RequestIds, ", ")).
+			Field("AppVersion", r.AppVersion).
+			Line("").
+			Line(r.Message)
+		if err := ops.Notify(ctx, sns.NewFromConfig(awsCfg), m.Subject(), m.Body()); err != nil {
+			fmt.Printf("feedback: ops notify failed: %v\n", err)
+		}
+	}
+
+	return jsonResp(201, map[string]any{"ok": true, "id": r.Id})
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/forecast"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func ForecastHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RawPath {
+	case "/forecast/expenses":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return forecastExpenses(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/forecast/runway":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return forecastRunway(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+// forecastExpenses serves GET /forecast/expenses?months=3[&history=6].
+func forecastExpenses(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	months := 3
+	if s := strings.TrimSpace(req.QueryStringParameters["months"]); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 12 {
+			return errResp(400, "months must be between 1 and 12")
+		}
+		months = n
+	}
+
+	historyMonths := 6
+	if s := strings.TrimSpace(req.QueryStringParameters["history"]); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 24 {
+			return errResp(400, "history must be between 1 and 24")
+		}
+		historyMonths = n
+	}
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	// History is the last N complete months; the current month is partial and would skew trends.
+	now := time.Now().UTC()
+	lastComplete := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+
+	history, currency, skipped, err := loadMonthTotals(ctx, client, table, sub, lastComplete, historyMonths)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	fc := forecast.ProjectExpenses(history, lastComplete, months)
+
+	return jsonResp(200, map[string]any{
+		"currency":             currency,
+		"historyMonths":        fc.HistoryMonths,
+		"categories":           fc.Categories,
+		"months":               fc.Months,
+		"skippedOtherCurrency": skipped,
+	})
+}
+
+// forecastRunway serves GET /forecast/runway[?cash=12000][&months=6], the
+// dashboard's runway block over a chosen horizon. Until bank feeds exist the
+// cash balance is ?cash= or, without it, the one saved in settings.
+func forecastRunway(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	months := 6
+	if s := strings.TrimSpace(req.QueryStringParameters["months"]); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 12 {
+			return errResp(400, "months must be between 1 and 12")
+		}
+		months = n
+	}
+
+	cash, err := parseCash(req.QueryStringParameters["cash"])
+	if err != nil {
+		return errResp(400, "cash must be a number")
+	}
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	if cash == nil {
+		s, err := users.GetSettings(ctx, client, sub)
+		if err != nil {
+			return errResp(500, "failed to load settings")
+		}
+		cash = s.CashBalance
+	}
+
+	rw, currency, err := computeRunway(ctx, client, table, sub, cash, months)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	return jsonResp(200, map[string]any{
+		"currency": currency,
+		"runway":   rw,
+	})
+}
+
+// parseCash reads an optional ?cash= balance; empty is nil (not known).
+func parseCash(s string) (*float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("cash %q is not finite", s)
+	}
+	return &f, nil
+}
+
+// computeRunway projects the next months from the last six complete months
+// and derives burn/runway from the projected profit.
+func computeRunway(ctx context.Context, client *dynamodb.Client, table, sub string, cash *float64, months int) (forecast.Runway, string, error) {
+	now := time.Now().UTC()
+	lastComplete := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+
+	history, currency, _, err := loadMonthTotals(ctx, client, table, sub, lastComplete, 6)
+	if err != nil {
+		return forecast.Runway{}, "", err
+	}
+
+	fc := forecast.ProjectExpenses(history, lastComplete, months)
+	return forecast.ComputeRunway(cash, fc.Months), currency, nil
+}
+
+// loadMonthTotals buckets the user's transactions for the n months ending at
+// lastMonth (oldest first). Only the dominant currency is kept; the number of
+// transactions in other currencies is returned as skipped.
+func loadMonthTotals(ctx context.Context, client *dynamodb.Client, table, sub string, lastMonth time.Time, n int) ([]forecast.MonthTotals, string, int, error) {
+	perMonth := make([][]Transaction, n)
+	currencyCount := map[string]int{}
+
+	for i := 0; i < n; i++ {
+		m := lastMonth.AddDate(0, -(n - 1 - i), 0).Format("2006-01")
+		items, err := queryMonthTransactions(ctx, client, table, sub, m)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		perMonth[i] = items
+		for _, t := range items {
+			currencyCount[t.Currency]++
+		}
+	}
+
+	currency := "USD"
+	best := 0
+	for c, cnt := range currencyCount {
+		if cnt > best || (cnt == best && c < currency) {
+			currency, best = c, cnt
+		}
+	}
+
+	skipped := 0
+	out := make([]forecast.MonthTotals, n)
+	for i, items := range perMonth {
+		mt := forecast.MonthTotals{
+			Month:    lastMonth.AddDate(0, -(n - 1 - i), 0).Format("2006-01"),
+			Expenses: map[string]float64{},
+		}
+		for _, t := range items {
+			if t.Currency != currency {
+				skipped++
+				continue
+			}
+			if t.Amount >= 0 {
+				mt.Income += t.Amount
+			} else {
+				mt.Expenses[t.Category] += math.Abs(t.Amount)
+			}
+		}
+		out[i] = mt
+	}
+
+	return out, currency, skipped, nil
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import "testing"
+
+func TestParseCash(t *testing.T) {
+	for _, s := range []string{"", "  "} {
+		if c, err := parseCash(s); err != nil || c != nil {
+			t.Errorf("parseCash(%q) = %v, %v; want nil, nil", s, c, err)
+		}
+	}
+	for s, want := range map[string]float64{"12000": 12000, " -250.5 ": -250.5, "0": 0} {
+		c, err := parseCash(s)
+		if err != nil || c == nil || *c != want {
+			t.Errorf("parseCash(%q) = %v, %v; want %v", s, c, err, want)
+		}
+	}
+	for _, s := range []string{"abc", "12,000", "NaN", "Inf", "-Inf", "1e999"} {
+		if _, err := parseCash(s); err == nil {
+			t.Errorf("parseCash(%q) accepted", s)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/fx"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// FxRates serves GET /fx/rates?base=USD[&date=YYYY-MM-DD] from the same rates
+// table the backend converts with, so the frontend shows identical numbers.
+func FxRates(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if _, _, err := userSub(req); err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	base := strings.ToUpper(strings.TrimSpace(req.QueryStringParameters["base"]))
+	if base == "" {
+		base = "USD"
+	}
+	if len(base) != 3 {
+		return errResp(400, "base must be a 3-letter currency code")
+	}
+
+	date := strings.TrimSpace(req.QueryStringParameters["date"])
+	if date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return errResp(400, "date must be in format YYYY-MM-DD")
+		}
+	}
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	rates, err := fx.GetRates(ctx, client, base, date)
+	if err != nil {
+		if errors.Is(err, fx.ErrNoRates) {
+			return errResp(404, "no rates available")
+		}
+		if strings.HasPrefix(err.Error(), "unsupported base") {
+			return errResp(400, err.Error())
+		}
+		return errResp(500, "failed to load rates")
+	}
+
+	return jsonResp(200, rates)
+}
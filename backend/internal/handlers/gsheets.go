@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/gsheets"
+	"backend/internal/security"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func GoogleSheetsHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RawPath {
+	case "/integrations/gsheets/connect":
+		return gsheetsConnect(ctx, req)
+	case "/integrations/gsheets/callback":
+		return gsheetsCallback(ctx, req)
+	case "/integrations/gsheets":
+		switch req.RequestContext.HTTP.Method {
+		case "GET":
+			return gsheetsStatus(ctx, req)
+		case "PUT":
+			return gsheetsSetTarget(ctx, req)
+		case "DELETE":
+			return gsheetsDisconnect(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/integrations/gsheets/export":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return gsheetsExportNow(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func gsheetsRedirectURI() (string, error) {
+	base, err := getApiBaseUrl()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base, "/") + "/integrations/gsheets/callback", nil
+}
+
+func gsheetsConnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	if strings.TrimSpace(os.Getenv("GOOGLE_CLIENT_ID")) == "" {
+		return errResp(500, "GOOGLE_CLIENT_ID not set")
+	}
+
+	state, err := randomState(24)
+	if err != nil {
+		return errResp(500, "failed to generate state")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	if strings.TrimSpace(stateTable) == "" {
+		return errResp(500, "OAUTH_STATE_TABLE not set")
+	}
+
+	exp := time.Now().UTC().Add(10 * time.Minute).Unix()
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(stateTable),
+		Item: map[string]types.AttributeValue{
+			"State":          &types.AttributeValueMemberS{Value: state},
+			"UserSub":        &types.AttributeValueMemberS{Value: sub},
+			"Provider":       &types.AttributeValueMemberS{Value: gsheets.Source},
+			"ExpiresAtEpoch": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
+		},
+	})
+	if err != nil {
+		return errResp(500, "failed to store oauth state")
+	}
+
+	redirectURI, err := gsheetsRedirectURI()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+
+	return jsonResp(200, map[string]any{
+		"authorizeUrl": gsheets.AuthorizeURL(state, redirectURI),
+	})
+}
+
+func gsheetsCallback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	params := req.QueryStringParameters
+	fe := strings.TrimRight(os.Getenv("FRONTEND_BASE_URL"), "/")
+	if fe == "" {
+		fe = "/"
+	}
+
+	// User declined consent
+	if params["error"] != "" {
+		return events.APIGatewayV2HTTPResponse{
+			StatusCode: 302,
+			Headers:    map[string]string{"location": fe + "/gsheets?connected=0"},
+		}, nil
+	}
+
+	state := strings.TrimSpace(params["state"])
+	code := strings.TrimSpace(params["code"])
+	if state == "" || code == "" {
+		return errResp(400, "missing required oauth params")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return errResp(400, "invalid or expired state")
+	}
+	sub := attrS(out.Item["UserSub"])
+	if sub == "" || attrS(out.Item["Provider"]) != gsheets.Source {
+		return errResp(400, "state mismatch")
+	}
+
+	redirectURI, err := gsheetsRedirectURI()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+	refresh, err := gsheets.ExchangeCode(ctx, code, redirectURI)
+	if err != nil {
+		return errResp(502, "token exchange failed")
+	}
+
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return errResp(500, "invalid TOKEN_ENC_KEY_B64")
+	}
+	enc, err := security.EncryptAESGCM(key, refresh)
+	if err != nil {
+		return errResp(500, "failed to encrypt token")
+	}
+
+	if err := gsheets.SaveRefreshToken(ctx, ddb, sub, enc); err != nil {
+		return errResp(500, "failed to store integration")
+	}
+
+	_, _ = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: 302,
+		Headers:    map[string]string{"location": fe + "/gsheets?connected=1"},
+	}, nil
+}
+
+func gsheetsStatus(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integ, err := gsheets.LoadIntegration(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load integration")
+	}
+	if integ == nil {
+		return jsonResp(200, map[string]any{"connected": false})
+	}
+	return jsonResp(200, map[string]any{"connected": true, "integration": integ})
+}
+
+type gsheetsTargetRequest struct {
+	SpreadsheetId string `json:"spreadsheetId"`
+	SheetName     string `json:"sheetName"`
+}
+
+// gsheetsSetTarget serves PUT /integrations/gsheets with the spreadsheet to append to.
+func gsheetsSetTarget(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	var body gsheetsTargetRequest
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		return errResp(400, "invalid json")
+	}
+	body.SpreadsheetId = strings.TrimSpace(body.SpreadsheetId)
+	body.SheetName = strings.TrimSpace(body.SheetName)
+	if body.SpreadsheetId == "" {
+		return errResp(400, "spreadsheetId is required")
+	}
+	if body.SheetName == "" {
+		body.SheetName = "daily_metrics"
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integ, err := gsheets.LoadIntegration(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load integration")
+	}
+	if integ == nil {
+		return errResp(404, "google sheets not connected")
+	}
+
+	accessToken, err := gsheets.DecryptedAccessToken(ctx, integ)
+	if err != nil {
+		return errResp(502, "google token refresh failed; reconnect google sheets")
+	}
+	title, err := gsheets.SpreadsheetTitle(ctx, accessToken, body.SpreadsheetId)
+	if err != nil {
+		return errResp(400, "spreadsheet not found or not accessible")
+	}
+
+	if err := gsheets.SetTarget(ctx, ddb, integ, body.SpreadsheetId, title, body.SheetName); err != nil {
+		return errResp(500, "failed to save target")
+	}
+
+	return jsonResp(200, map[string]any{
+		"ok":               true,
+		"spreadsheetId":    body.SpreadsheetId,
+		"spreadsheetTitle": title,
+		"sheetName":        body.SheetName,
+	})
+}
+
+func gsheetsDisconnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	_, err = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.IntegrationsTableName()),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK": &types.AttributeValueMemberS{Value: "GSHEETS"},
+		},
+	})
+	if err != nil {
+		return errResp(500, "delete failed")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+// gsheetsExportNow serves POST /integrations/gsheets/export[?date=YYYY-MM-DD]
+// so users don't have to wait for the nightly run after picking a sheet.
+func gsheetsExportNow(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	through := strings.TrimSpace(req.QueryStringParameters["date"])
+	if through == "" {
+		through = gsheets.Yesterday(time.Now())
+	} else if _, err := time.Parse("2006-01-02", through); err != nil {
+		return errResp(400, "date must be YYYY-MM-DD")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integ, err := gsheets.LoadIntegration(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load integration")
+	}
+	if integ == nil {
+		return errResp(404, "google sheets not connected")
+	}
+
+	res, err := gsheets.Export(ctx, ddb, integ, through)
+	if err != nil {
+		return errResp(502, err.Error())
+	}
+	return jsonResp(200, map[string]any{"ok": true, "days": res.Days, "rows": res.Rows})
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ingest"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// IngestHandler serves the per-user inbound webhook (/ingest/{sourceKey},
+// signed, no JWT) and the authenticated management routes for its sources.
+func IngestHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	method := req.RequestContext.HTTP.Method
+	switch {
+	case strings.HasPrefix(req.RawPath, "/ingest/"):
+		if method == "POST" {
+			return ingestReceive(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case req.RawPath == "/integrations/ingest":
+		switch method {
+		case "GET":
+			return ingestList(ctx, req)
+		case "POST":
+			return ingestCreate(ctx, req)
+		case "PUT":
+			return ingestUpdate(ctx, req)
+		case "DELETE":
+			return ingestDelete(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+type ingestSourceRequest struct {
+	Name    string         `json:"name"`
+	Mapping ingest.Mapping `json:"mapping"`
+}
+
+func ingestURL(sourceKey string) string {
+	base, err := getApiBaseUrl()
+	if err != nil {
+		return "/ingest/" + sourceKey
+	}
+	return strings.TrimRight(base, "/") + "/ingest/" + sourceKey
+}
+
+func ingestList(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	srcs, err := ingest.List(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	type SourceItem struct {
+		ingest.SourceConfig
+		URL string `json:"url"`
+	}
+	items := make([]SourceItem, 0, len(srcs))
+	for _, s := range srcs {
+		items = append(items, SourceItem{SourceConfig: s, URL: ingestURL(s.SourceKey)})
+	}
+	return jsonResp(200, map[string]any{"items": items})
+}
+
+// ingestCreate returns the signing secret; it is not retrievable afterwards.
+func ingestCreate(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	var in ingestSourceRequest
+	if err := json.Unmarshal([]byte(req.Body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return errResp(400, "name is required")
+	}
+	if err := in.Mapping.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+
+	sourceKey, err := randomState(18)
+	if err != nil {
+		return errResp(500, "failed to generate source key")
+	}
+	secret, err := randomState(32)
+	if err != nil {
+		return errResp(500, "failed to generate secret")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	src, err := ingest.Create(ctx, ddb, sub, sourceKey, secret, in.Name, in.Mapping)
+	if err != nil {
+		return errResp(500, "failed to store source")
+	}
+
+	return jsonResp(201, map[string]any{
+		"sourceKey": src.SourceKey,
+		"name":      src.Name,
+		"mapping":   src.Mapping,
+		"url":       ingestURL(src.SourceKey),
+		"secret":    secret,
+		"signing":   "X-TrueProfit-Timestamp: <unix seconds>; X-TrueProfit-Signature: hex(HMAC-SHA256(secret, timestamp + \".\" + body))",
+	})
+}
+
+func ingestUpdate(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	sourceKey := strings.TrimSpace(req.QueryStringParameters["source"])
+	if sourceKey == "" {
+		return errResp(400, "missing source")
+	}
+	var in ingestSourceRequest
+	if err := json.Unmarshal([]byte(req.Body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	if err := in.Mapping.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	err = ingest.UpdateMapping(ctx, ddb, sub, sourceKey, strings.TrimSpace(in.Name), in.Mapping)
+	if errors.Is(err, ingest.ErrNotFound) {
+		return errResp(404, "source not found")
+	}
+	if err != nil {
+		return errResp(500, "failed to update source")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+func ingestDelete(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	sourceKey := strings.TrimSpace(req.QueryStringParameters["source"])
+	if sourceKey == "" {
+		return errResp(400, "missing source")
+	}
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	err = ingest.Delete(ctx, ddb, sub, sourceKey)
+	if errors.Is(err, ingest.ErrNotFound) {
+		return errResp(404, "source not found")
+	}
+	if err != nil {
+		return errResp(500, "failed to delete source")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+func ingestReceive(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sourceKey := strings.TrimSpace(req.PathParameters["sourceKey"])
+	if sourceKey == "" {
+		sourceKey = strings.TrimPrefix(req.RawPath, "/ingest/")
+	}
+
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return errResp(400, "invalid body")
+		}
+		body = b
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	src, err := ingest.Resolve(ctx, ddb, sourceKey)
+	if errors.Is(err, ingest.ErrNotFound) {
+		return errResp(404, "not found")
+	}
+	if err != nil {
+		return errResp(500, "source lookup failed")
+	}
+
+	now := time.Now()
+	if !src.VerifySignature(req.Headers["x-trueprofit-timestamp"], body, req.Headers["x-trueprofit-signature"], now) {
+		return errResp(401, "invalid signature")
+	}
+
+	evs, evErrs, err := src.Mapping.Apply(body, now)
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+
+	sub := src.UserSub()
+	written, err := ingest.Write(ctx, ddb, db.TransactionsTableName(), sub, sourceKey, evs)
+	if err != nil {
+		// non-2xx lets the sender retry; already written events dedupe
+		return errResp(500, "failed to store events")
+	}
+	ingest.Touch(ctx, ddb, sub, sourceKey)
+
+	return jsonResp(200, map[string]any{
+		"ok":         true,
+		"received":   len(evs) + len(evErrs),
+		"written":    written,
+		"duplicates": len(evs) - written,
+		"errors":     evErrs,
+	})
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/ads/meta"
+	"backend/internal/db"
+	"backend/internal/security"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func MetaAdsHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RawPath {
+	case "/integrations/meta/connect":
+		return metaConnect(ctx, req)
+	case "/integrations/meta/callback":
+		return metaCallback(ctx, req)
+	case "/integrations/meta/accounts":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return metaListAccounts(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/integrations/meta/sync":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return metaSync(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+// metaConnect starts Facebook Login. Optional query params:
+// - adAccountId: connect only this account (act_<n>); default connects every readable account
+// - shop: store the spend is attributed to; defaults to the user's only shop
+func metaConnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	if strings.TrimSpace(os.Getenv("META_APP_ID")) == "" {
+		return errResp(500, "META_APP_ID not set")
+	}
+
+	adAccountID := strings.TrimSpace(req.QueryStringParameters["adAccountId"])
+	if adAccountID != "" && !strings.HasPrefix(adAccountID, "act_") {
+		adAccountID = "act_" + adAccountID
+	}
+	shop := strings.ToLower(strings.TrimSpace(req.QueryStringParameters["shop"]))
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	shops, err := tenancy.GetAllowedShopsByUserSub(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load shops")
+	}
+	if shop != "" {
+		found := false
+		for _, s := range shops {
+			if strings.EqualFold(s, shop) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errResp(400, "shop not connected")
+		}
+	} else if len(shops) == 1 {
+		shop = shops[0]
+	}
+
+	state, err := randomState(24)
+	if err != nil {
+		return errResp(500, "failed to generate state")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	if strings.TrimSpace(stateTable) == "" {
+		return errResp(500, "OAUTH_STATE_TABLE not set")
+	}
+
+	exp := time.Now().UTC().Add(10 * time.Minute).Unix()
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(stateTable),
+		Item: map[string]types.AttributeValue{
+			"State":          &types.AttributeValueMemberS{Value: state},
+			"UserSub":        &types.AttributeValueMemberS{Value: sub},
+			"Provider":       &types.AttributeValueMemberS{Value: meta.Source},
+			"Shop":           &types.AttributeValueMemberS{Value: shop},
+			"AdAccountId":    &types.AttributeValueMemberS{Value: adAccountID},
+			"ExpiresAtEpoch": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
+		},
+	})
+	if err != nil {
+		return errResp(500, "failed to store oauth state")
+	}
+
+	redirectBase, err := getApiBaseUrl()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+	redirectURI := strings.TrimRight(redirectBase, "/") + "/integrations/meta/callback"
+
+	return jsonResp(200, map[string]any{
+		"authorizeUrl": meta.AuthorizeURL(state, redirectURI),
+	})
+}
+
+func metaCallback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	params := req.QueryStringParameters
+	state := strings.TrimSpace(params["state"])
+	code := strings.TrimSpace(params["code"])
+	if state == "" || code == "" {
+		return errResp(400, "missing required oauth params")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return errResp(400, "invalid or expired state")
+	}
+	sub := attrS(out.Item["UserSub"])
+	if sub == "" || attrS(out.Item["Provider"]) != meta.Source {
+		return errResp(400, "state mismatch")
+	}
+	shop := attrS(out.Item["Shop"])
+	wantAccount := attrS(out.Item["AdAccountId"])
+
+	redirectBase, err := getApiBaseUrl()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+	redirectURI := strings.TrimRight(redirectBase, "/") + "/integrations/meta/callback"
+
+	token, expiresIn, err := meta.ExchangeCode(ctx, code, redirectURI)
+	if err != nil {
+		return errResp(502, "token exchange failed")
+	}
+
+	accounts, err := meta.ListAdAccounts(ctx, token)
+	if err != nil {
+		return errResp(502, "failed to list ad accounts")
+	}
+
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return errResp(500, "invalid TOKEN_ENC_KEY_B64")
+	}
+	enc, err := security.EncryptAESGCM(key, token)
+	if err != nil {
+		return errResp(500, "failed to encrypt token")
+	}
+
+	connected := 0
+	for _, acct := range accounts {
+		if wantAccount != "" && acct.ID != wantAccount {
+			continue
+		}
+		integ, err := meta.SaveIntegration(ctx, ddb, sub, acct, shop, enc, expiresIn)
+		if err != nil {
+			return errResp(500, "failed to store integration")
+		}
+		connected++
+
+		// Initial backfill; the scheduled worker picks up anything that fails here.
+		if _, err := meta.SyncAccount(ctx, ddb, *integ); err != nil {
+			fmt.Printf("meta: initial sync failed account=%s user=%s: %v\n", acct.ID, sub, err)
+		}
+	}
+	if connected == 0 {
+		return errResp(400, "no matching ad account")
+	}
+
+	_, _ = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+
+	fe := strings.TrimRight(os.Getenv("FRONTEND_BASE_URL"), "/")
+	if fe == "" {
+		fe = "/"
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"location": fe + "/meta?connected=" + url.QueryEscape(fmt.Sprintf("%d", connected)),
+		},
+	}, nil
+}
+
+func metaListAccounts(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integs, err := meta.ListIntegrations(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	type AccountItem struct {
+		AdAccountId    string `json:"adAccountId"`
+		Name           string `json:"name"`
+		Currency       string `json:"currency"`
+		Shop           string `json:"shop"`
+		TokenExpiresAt string `json:"tokenExpiresAt"`
+		LastSyncAt     string `json:"lastSyncAt"`
+	}
+	items := make([]AccountItem, 0, len(integs))
+	for _, it := range integs {
+		items = append(items, AccountItem{
+			AdAccountId:    it.AdAccountId,
+			Name:           it.AccountName,
+			Currency:       it.Currency,
+			Shop:           it.Shop,
+			TokenExpiresAt: it.TokenExpiresAt,
+			LastSyncAt:     it.LastSyncAt,
+		})
+	}
+	return jsonResp(200, map[string]any{"items": items})
+}
+
+// metaSync runs an on-demand spend sync for the caller's ad accounts (optionally ?adAccountId=).
+func metaSync(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	only := strings.TrimSpace(req.QueryStringParameters["adAccountId"])
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integs, err := meta.ListIntegrations(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	results := make([]any, 0, len(integs))
+	for _, it := range integs {
+		if only != "" && it.AdAccountId != only {
+			continue
+		}
+		res, err := meta.SyncAccount(ctx, ddb, it)
+		if err != nil {
+			results = append(results, map[string]any{"adAccountId": it.AdAccountId, "error": err.Error()})
+			continue
+		}
+		results = append(results, res)
+	}
+
+	return jsonResp(200, map[string]any{"ok": true, "results": results})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/metrics"
+	"backend/internal/nlq"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/athena"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// MetricsHandler serves GET /metrics/daily?shop=&from=YYYY-MM-DD&to=YYYY-MM-DD:
+// daily_metrics rows for one of the caller's shops, without going through
+// /ask. shop may be omitted when the caller has exactly one; the range
+// defaults to the last 30 days and is capped at metrics.MaxDays. It also
+// serves GET /analytics/trends, which reads the same table for older days,
+// GET /analytics/top-products (see analytics_products.go) and
+// GET /export/timeseries (see export_timeseries.go).
+func MetricsHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	switch req.RawPath {
+	case "/metrics/daily":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return dailyMetrics(ctx, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/analytics/trends":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return analyticsTrends(ctx, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/analytics/top-products":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return analyticsTopProducts(ctx, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/export/timeseries":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return exportTimeseries(ctx, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func dailyMetrics(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+	loc := metrics.Location()
+
+	to := time.Now().In(loc)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	if v := strings.TrimSpace(q["to"]); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return errResp(400, "to must be YYYY-MM-DD")
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -29)
+	if v := strings.TrimSpace(q["from"]); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return errResp(400, "from must be YYYY-MM-DD")
+		}
+		from = t
+	}
+	if from.After(to) || to.Sub(from) >= metrics.MaxDays*24*time.Hour {
+		return errResp(400, "range must be 1-366 days")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	ddb := dynamodb.NewFromConfig(cfg)
+
+	allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "shop lookup failed")
+	}
+	shop := strings.TrimSpace(q["shop"])
+	if shop == "" {
+		if len(allowed) != 1 {
+			return errResp(400, "shop is required")
+		}
+		shop = allowed[0]
+	}
+	owned := ""
+	for _, a := range allowed {
+		if strings.EqualFold(a, shop) {
+			owned = a
+		}
+	}
+	if owned == "" {
+		// same answer for "not yours" and "doesn't exist"
+		return errResp(404, "shop not found")
+	}
+	shop = owned
+
+	opt := nlq.AthenaRunOptions{
+		Database:       strings.TrimSpace(os.Getenv("ATHENA_DATABASE")),
+		Workgroup:      strings.TrimSpace(os.Getenv("ATHENA_WORKGROUP")),
+		OutputLocation: strings.TrimSpace(os.Getenv("ATHENA_OUTPUT_S3")),
+		MaxWait:        25 * time.Second,
+	}
+	res, err := metrics.Daily(ctx, ddb, athena.NewFromConfig(cfg), opt, shop, from, to)
+	var ae *nlq.AthenaError
+	if errors.As(err, &ae) && ae.State == nlq.AthenaStateTimeout {
+		return errResp(504, "metrics query timed out, try a shorter range")
+	}
+	if err != nil {
+		return errResp(500, "metrics query failed")
+	}
+
+	return jsonResp(200, map[string]any{
+		"shop":         shop,
+		"from":         from.Format("2006-01-02"),
+		"to":           to.Format("2006-01-02"),
+		"items":        res.Rows,
+		"cachedDays":   res.CachedDays,
+		"queriedDays":  res.QueriedDays,
+		"scannedBytes": res.ScannedBytes,
+	})
+}
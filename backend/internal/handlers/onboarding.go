@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"backend/internal/costs"
+	"backend/internal/db"
+	"backend/internal/margin"
+	"backend/internal/shopify"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// Onboarding steps, in the order the checklist shows them.
+const (
+	StepShopConnected   = "shop_connected"
+	StepFirstSync       = "first_sync"
+	StepCostsConfigured = "costs_configured"
+	StepAlertsConfirmed = "alerts_confirmed"
+	StepFirstAsk        = "first_ask"
+)
+
+var onboardingSteps = []string{StepShopConnected, StepFirstSync, StepCostsConfigured, StepAlertsConfirmed, StepFirstAsk}
+
+type OnboardingStep struct {
+	Id     string `json:"id"`
+	Done   bool   `json:"done"`
+	Source string `json:"source,omitempty"` // "data", or "manual" when marked done by the user
+	Detail string `json:"detail,omitempty"`
+}
+
+// OnboardingHandler serves the onboarding checklist:
+//
+//	GET  /onboarding            steps computed from the caller's data
+//	POST /onboarding/dismiss    {"dismissed": true|false}, hides or restores it
+//	POST /onboarding/complete   {"step": "<id>"}, marks a step done by hand
+func OnboardingHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	switch req.RawPath {
+	case "/onboarding":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return getOnboarding(ctx, client, sub)
+		}
+		return errResp(405, "method not allowed")
+	case "/onboarding/dismiss":
+		if req.RequestContext.HTTP.Method != "POST" {
+			return errResp(405, "method not allowed")
+		}
+		in := struct {
+			Dismissed *bool `json:"dismissed"`
+		}{}
+		if strings.TrimSpace(req.Body) != "" {
+			if err := json.Unmarshal([]byte(req.Body), &in); err != nil {
+				return errResp(400, "invalid json body")
+			}
+		}
+		dismissed := in.Dismissed == nil || *in.Dismissed
+		if err := users.SetOnboardingDismissed(ctx, client, sub, dismissed); err != nil {
+			return errResp(500, "failed to save onboarding")
+		}
+		return getOnboarding(ctx, client, sub)
+	case "/onboarding/complete":
+		if req.RequestContext.HTTP.Method != "POST" {
+			return errResp(405, "method not allowed")
+		}
+		var in struct {
+			Step string `json:"step"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &in); err != nil {
+			return errResp(400, "invalid json body")
+		}
+		step := strings.TrimSpace(in.Step)
+		known := false
+		for _, s := range onboardingSteps {
+			known = known || s == step
+		}
+		if !known {
+			return errResp(400, "step must be one of "+strings.Join(onboardingSteps, ", "))
+		}
+		if err := users.CompleteOnboardingStep(ctx, client, sub, step); err != nil {
+			return errResp(500, "failed to save onboarding")
+		}
+		return getOnboarding(ctx, client, sub)
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func getOnboarding(ctx context.Context, client *dynamodb.Client, sub string) (events.APIGatewayV2HTTPResponse, error) {
+	state, err := users.GetOnboarding(ctx, client, sub)
+	if err != nil {
+		return errResp(500, "failed to load onboarding")
+	}
+
+	steps := map[string]*OnboardingStep{}
+	for _, id := range onboardingSteps {
+		steps[id] = &OnboardingStep{Id: id}
+	}
+	mark := func(id, detail string) {
+		steps[id].Done, steps[id].Source, steps[id].Detail = true, "data", detail
+	}
+
+	shops, err := userShopIntegrations(ctx, client, sub)
+	if err != nil {
+		return errResp(500, "failed to load shops")
+	}
+	if len(shops) > 0 {
+		mark(StepShopConnected, fmt.Sprintf("%d shop(s) connected", len(shops)))
+	}
+	for _, it := range shops {
+		// Shops connected before initial syncs existed only have LastSyncAt.
+		status := attrS(it["InitialSyncStatus"])
+		if status == shopify.InitialSyncDone || (status == "" && attrS(it["LastSyncAt"]) != "") {
+			mark(StepFirstSync, attrS(it["Shop"]))
+			break
+		}
+		if status != "" {
+			steps[StepFirstSync].Detail = "initial sync " + status
+		}
+	}
+
+	settings, err := users.GetSettings(ctx, client, sub)
+	if err != nil {
+		return errResp(500, "failed to load settings")
+	}
+	if settings.CostModel != (margin.Model{}) {
+		mark(StepCostsConfigured, "cost model set")
+	} else {
+		for _, it := range shops {
+			shop := attrS(it["Shop"])
+			has, err := costs.HasAny(ctx, client, shop)
+			if err != nil {
+				return errResp(500, "failed to load product costs")
+			}
+			if has {
+				mark(StepCostsConfigured, "product costs for "+shop)
+				break
+			}
+		}
+	}
+
+	topicArn, err := users.GetAlertsTopicArn(ctx, client, sub)
+	if err != nil {
+		return errResp(500, "failed to load alerts")
+	}
+	if topicArn != "" {
+		confirmed := false
+		if cfg, err := config.LoadDefaultConfig(ctx); err == nil {
+			// An SNS hiccup shows the step as pending rather than failing the checklist.
+			confirmed, _ = users.AlertsConfirmed(ctx, sns.NewFromConfig(cfg), topicArn)
+		}
+		if confirmed {
+			mark(StepAlertsConfirmed, "")
+		} else {
+			steps[StepAlertsConfirmed].Detail = "confirmation email sent, link not clicked yet"
+		}
+	}
+
+	if state.FirstAskAt != "" {
+		mark(StepFirstAsk, state.FirstAskAt)
+	}
+
+	for _, id := range state.Completed {
+		if s, ok := steps[id]; ok && !s.Done {
+			s.Done, s.Source = true, "manual"
+		}
+	}
+
+	out := make([]OnboardingStep, 0, len(onboardingSteps))
+	done := 0
+	for _, id := range onboardingSteps {
+		out = append(out, *steps[id])
+		if steps[id].Done {
+			done++
+		}
+	}
+	return jsonResp(200, map[string]any{
+		"steps":       out,
+		"completed":   done,
+		"total":       len(out),
+		"allDone":     done == len(out),
+		"dismissed":   state.DismissedAt != "",
+		"dismissedAt": state.DismissedAt,
+	})
+}
+
+// userShopIntegrations lists the caller's own Shopify integration items.
+func userShopIntegrations(ctx context.Context, client *dynamodb.Client, sub string) ([]map[string]types.AttributeValue, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	var items []map[string]types.AttributeValue
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :pref)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":   &types.AttributeValueMemberS{Value: "USER#" + sub},
+				":pref": &types.AttributeValueMemberS{Value: "SHOPIFY#"},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/orgs"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// OrgsHandler serves orgs and their consolidated reporting:
+//
+//	GET  /orgs                         orgs the caller belongs to
+//	POST /orgs                         create an org, caller becomes admin
+//	POST /orgs/join                    redeem an invite code
+//	POST /orgs/{id}/invites            (admin) issue an invite code
+//	GET  /orgs/{id}/members            (admin) list members
+//	DELETE /orgs/{id}/members/{sub}    (admin, or the member themself) remove
+//	GET  /orgs/{id}/summary            (admin) summary across all members
+//	GET  /orgs/{id}/dashboard          (admin) today and month to date across all members
+//	GET  /orgs/{id}/reports/products   (admin) product report across all members
+//
+// The reports take ?member=<sub> to drill into one member.
+func OrgsHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, email, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	method := req.RequestContext.HTTP.Method
+
+	switch req.RawPath {
+	case "/orgs":
+		switch method {
+		case "GET":
+			return listOrgs(ctx, sub)
+		case "POST":
+			return createOrg(ctx, sub, email, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/orgs/join":
+		if method == "POST" {
+			return joinOrg(ctx, sub, email, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	}
+
+	// /orgs/{id}/{resource}[/{sub}]
+	parts := strings.Split(strings.TrimPrefix(req.RawPath, "/orgs/"), "/")
+	if !strings.HasPrefix(req.RawPath, "/orgs/") || len(parts) < 2 || parts[0] == "" {
+		return errResp(404, "not found")
+	}
+	orgId := parts[0]
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	role, err := orgs.Role(ctx, ddb, orgId, sub)
+	if err != nil {
+		return errResp(500, "failed to load org")
+	}
+	if role == "" {
+		// don't reveal whether the org exists
+		return errResp(404, "not found")
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "invites":
+		if method != "POST" {
+			return errResp(405, "method not allowed")
+		}
+		if role != orgs.RoleAdmin {
+			return errResp(403, "forbidden")
+		}
+		return createOrgInvite(ctx, ddb, orgId, req.Body)
+	case len(parts) == 2 && parts[1] == "members":
+		if method != "GET" {
+			return errResp(405, "method not allowed")
+		}
+		if role != orgs.RoleAdmin {
+			return errResp(403, "forbidden")
+		}
+		return listOrgMembers(ctx, ddb, orgId)
+	case len(parts) == 3 && parts[1] == "members":
+		if method != "DELETE" {
+			return errResp(405, "method not allowed")
+		}
+		if role != orgs.RoleAdmin && parts[2] != sub {
+			return errResp(403, "forbidden")
+		}
+		return removeOrgMember(ctx, ddb, orgId, parts[2])
+	case len(parts) == 2 && parts[1] == "summary":
+		if method != "GET" {
+			return errResp(405, "method not allowed")
+		}
+		if role != orgs.RoleAdmin {
+			return errResp(403, "forbidden")
+		}
+		return orgSummary(ctx, ddb, orgId, sub, req)
+	case len(parts) == 2 && parts[1] == "dashboard",
+		len(parts) == 3 && parts[1] == "reports" && parts[2] == "products":
+		if method != "GET" {
+			return errResp(405, "method not allowed")
+		}
+		if role != orgs.RoleAdmin {
+			return errResp(403, "forbidden")
+		}
+		if parts[1] == "dashboard" {
+			return orgDashboard(ctx, ddb, orgId, req)
+		}
+		return orgProductReport(ctx, ddb, orgId, req)
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func listOrgs(ctx context.Context, sub string) (events.APIGatewayV2HTTPResponse, error) {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	ms, err := orgs.For(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to list orgs")
+	}
+	if ms == nil {
+		ms = []orgs.Membership{}
+	}
+	return jsonResp(200, map[string]any{"items": ms})
+}
+
+func createOrg(ctx context.Context, sub, email, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json")
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || len(in.Name) > 100 {
+		return errResp(400, "name is required (max 100 characters)")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	org, err := orgs.Create(ctx, ddb, in.Name, sub, email)
+	if err != nil {
+		return errResp(500, "failed to create org")
+	}
+	return jsonResp(201, org)
+}
+
+func joinOrg(ctx context.Context, sub, email, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json")
+	}
+	in.Code = strings.TrimSpace(in.Code)
+	if in.Code == "" {
+		return errResp(400, "code is required")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	org, err := orgs.Accept(ctx, ddb, in.Code, sub, email)
+	switch {
+	case errors.Is(err, orgs.ErrInviteInvalid), errors.Is(err, orgs.ErrNotFound):
+		return errResp(400, orgs.ErrInviteInvalid.Error())
+	case errors.Is(err, orgs.ErrFull):
+		return errResp(409, err.Error())
+	case err != nil:
+		return errResp(500, "failed to join org")
+	}
+	return jsonResp(200, org)
+}
+
+func createOrgInvite(ctx context.Context, ddb *dynamodb.Client, orgId, body string) (events.APIGatewayV2HTTPResponse, error) {
+	in := struct {
+		Role string `json:"role"`
+	}{Role: orgs.RoleMember}
+	if strings.TrimSpace(body) != "" {
+		if err := json.Unmarshal([]byte(body), &in); err != nil {
+			return errResp(400, "invalid json")
+		}
+	}
+	if in.Role != orgs.RoleMember && in.Role != orgs.RoleAdmin {
+		return errResp(400, "role must be member or admin")
+	}
+	inv, err := orgs.CreateInvite(ctx, ddb, orgId, in.Role)
+	if err != nil {
+		return errResp(500, "failed to create invite")
+	}
+	return jsonResp(201, inv)
+}
+
+func listOrgMembers(ctx context.Context, ddb *dynamodb.Client, orgId string) (events.APIGatewayV2HTTPResponse, error) {
+	ms, err := orgs.Members(ctx, ddb, orgId)
+	if err != nil {
+		return errResp(500, "failed to list members")
+	}
+	return jsonResp(200, map[string]any{"items": ms})
+}
+
+func removeOrgMember(ctx context.Context, ddb *dynamodb.Client, orgId, memberSub string) (events.APIGatewayV2HTTPResponse, error) {
+	org, err := orgs.Get(ctx, ddb, orgId)
+	if err != nil {
+		return errResp(500, "failed to load org")
+	}
+	if memberSub == org.OwnerSub {
+		return errResp(400, "the org owner can't be removed")
+	}
+	if err := orgs.Remove(ctx, ddb, orgId, memberSub); err != nil {
+		return errResp(500, "failed to remove member")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+// CurrencyTotals is income/expense/net in one currency.
+type CurrencyTotals struct {
+	Income  float64 `json:"income"`
+	Expense float64 `json:"expense"`
+	Net     float64 `json:"net"`
+	Count   int     `json:"count"`
+}
+
+func (c *CurrencyTotals) add(t Transaction) {
+	if t.Amount >= 0 {
+		c.Income += t.Amount
+	} else {
+		c.Expense += math.Abs(t.Amount)
+	}
+	c.Net = c.Income - c.Expense
+	c.Count++
+}
+
+// OrgMemberSummary is one member's slice of an org summary.
+type OrgMemberSummary struct {
+	Sub        string                     `json:"sub"`
+	Email      string                     `json:"email,omitempty"`
+	ByCurrency map[string]*CurrencyTotals `json:"byCurrency"`
+	ByShop     map[string]*CurrencyTotals `json:"byShop"`
+	ByCategory map[string]float64         `json:"byCategory,omitempty"`
+}
+
+// orgSummary serves GET /orgs/{id}/summary?month=YYYY-MM (or ?period=, resolved
+// with the caller's fiscal calendar). Totals are per currency since members
+// may report in different ones. ?member=<sub> drills into one member and adds
+// their category breakdown.
+func orgSummary(ctx context.Context, ddb *dynamodb.Client, orgId, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+	month := strings.TrimSpace(q["month"])
+	spec := strings.TrimSpace(q["period"])
+	if spec == "" && (len(month) != 7 || month[4] != '-') {
+		return errResp(400, "month is required in format YYYY-MM")
+	}
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+
+	months := []string{month}
+	var contains func(time.Time) bool
+	resp := map[string]any{"orgId": orgId, "month": month}
+	if spec != "" {
+		settings, err := users.GetSettings(ctx, ddb, sub)
+		if err != nil {
+			return errResp(500, "failed to load settings")
+		}
+		p, err := settings.Calendar.Resolve(spec, time.Now().UTC())
+		if err != nil {
+			return errResp(400, err.Error())
+		}
+		if len(p.Months()) > 13 {
+			return errResp(400, "period must span at most 13 months")
+		}
+		months, contains = p.Months(), p.Contains
+		resp["month"], resp["from"], resp["to"] = p.Name, p.FromISO(), p.ToISO()
+	}
+
+	drill := strings.TrimSpace(q["member"])
+	members, status, err := orgReportMembers(ctx, ddb, orgId, drill)
+	if err != nil {
+		return errResp(status, err.Error())
+	}
+
+	totals := map[string]*CurrencyTotals{}
+	rows := make([]OrgMemberSummary, 0, len(members))
+	for _, m := range members {
+		row := OrgMemberSummary{
+			Sub:        m.Sub,
+			Email:      m.Email,
+			ByCurrency: map[string]*CurrencyTotals{},
+			ByShop:     map[string]*CurrencyTotals{},
+		}
+		if drill != "" {
+			row.ByCategory = map[string]float64{}
+		}
+		for _, mo := range months {
+			items, err := queryMonthTransactions(ctx, ddb, table, m.Sub, mo)
+			if err != nil {
+				return errResp(500, "query failed")
+			}
+			kept := items[:0]
+			for _, t := range items {
+				if contains != nil {
+					if at, err := time.Parse(time.RFC3339, t.CreatedAt); err == nil && !contains(at) {
+						continue
+					}
+				}
+				kept = append(kept, t)
+				for _, m := range []map[string]*CurrencyTotals{totals, row.ByCurrency} {
+					if m[t.Currency] == nil {
+						m[t.Currency] = &CurrencyTotals{}
+					}
+					m[t.Currency].add(t)
+				}
+				if row.ByCategory != nil {
+					row.ByCategory[t.Category] += t.Amount
+				}
+			}
+			// revenue_share rows count once above, but are spread over shops here
+			for _, t := range allocateShops(kept) {
+				shop := t.Shop
+				if shop == "" {
+					shop = "manual"
+				}
+				if row.ByShop[shop] == nil {
+					row.ByShop[shop] = &CurrencyTotals{}
+				}
+				row.ByShop[shop].add(t)
+			}
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Sub < rows[j].Sub })
+
+	resp["byCurrency"] = totals
+	resp["members"] = rows
+	return jsonResp(200, resp)
+}
+
+// orgReportMembers returns the members an org report covers: all of them,
+// or only drill when it is set. Errors come with their response status.
+func orgReportMembers(ctx context.Context, ddb *dynamodb.Client, orgId, drill string) ([]orgs.Member, int, error) {
+	members, err := orgs.Members(ctx, ddb, orgId)
+	if err != nil {
+		return nil, 500, errors.New("failed to list members")
+	}
+	if drill == "" {
+		return members, 0, nil
+	}
+	for _, m := range members {
+		if m.Sub == drill {
+			return []orgs.Member{m}, 0, nil
+		}
+	}
+	return nil, 404, errors.New("member not found")
+}
+
+// OrgMemberDashboard is one member's slice of an org dashboard.
+type OrgMemberDashboard struct {
+	Sub          string                     `json:"sub"`
+	Email        string                     `json:"email,omitempty"`
+	Today        map[string]*CurrencyTotals `json:"today"`
+	Month        map[string]*CurrencyTotals `json:"month"`
+	Shops        []DashboardShop            `json:"shops"`
+	Transactions []Transaction              `json:"transactions,omitempty"`
+}
+
+// orgDashboard serves GET /orgs/{id}/dashboard[?member=<sub>&recent=N]:
+// /dashboard across the org, today's and this month's totals (UTC) per
+// currency, overall and per member, with each member's connected shops.
+// Drilling into a member adds their N newest transactions.
+func orgDashboard(ctx context.Context, ddb *dynamodb.Client, orgId string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+	recent := defaultDashboardRecent
+	if v := strings.TrimSpace(q["recent"]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxDashboardRecent {
+			return errResp(400, "recent must be between 0 and "+strconv.Itoa(maxDashboardRecent))
+		}
+		recent = n
+	}
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	intTable := db.IntegrationsTableName()
+	if strings.TrimSpace(intTable) == "" {
+		return errResp(500, "INTEGRATIONS_TABLE not set")
+	}
+
+	drill := strings.TrimSpace(q["member"])
+	members, status, err := orgReportMembers(ctx, ddb, orgId, drill)
+	if err != nil {
+		return errResp(status, err.Error())
+	}
+
+	now := time.Now().UTC()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	today, mtd := map[string]*CurrencyTotals{}, map[string]*CurrencyTotals{}
+	rows := make([]OrgMemberDashboard, 0, len(members))
+	for _, m := range members {
+		row := OrgMemberDashboard{Sub: m.Sub, Email: m.Email, Today: map[string]*CurrencyTotals{}, Month: map[string]*CurrencyTotals{}}
+		items, err := queryMonthTransactions(ctx, ddb, table, m.Sub, month)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		for _, t := range items {
+			totals := []map[string]*CurrencyTotals{mtd, row.Month}
+			if strings.HasPrefix(t.GSI1SK, day) {
+				totals = append(totals, today, row.Today)
+			}
+			for _, c := range totals {
+				if c[t.Currency] == nil {
+					c[t.Currency] = &CurrencyTotals{}
+				}
+				c[t.Currency].add(t)
+			}
+		}
+		if row.Shops, err = dashboardShops(ctx, ddb, intTable, m.Sub); err != nil {
+			return errResp(500, "query failed")
+		}
+		if drill != "" {
+			if row.Transactions, err = recentTransactions(ctx, ddb, table, m.Sub, month, recent); err != nil {
+				return errResp(500, "query failed")
+			}
+			signReceipts(ctx, row.Transactions)
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Sub < rows[j].Sub })
+
+	return jsonResp(200, map[string]any{
+		"orgId":   orgId,
+		"day":     day,
+		"month":   month,
+		"today":   today,
+		"mtd":     mtd,
+		"members": rows,
+	})
+}
+
+// orgProductReport serves GET /orgs/{id}/reports/products?month=[&member=&shop=&limit=]:
+// /reports/products across the org. Each member's lines are costed with
+// their own cost model and product costs; rows are merged by product and
+// currency, and productsByMember counts each member's products.
+func orgProductReport(ctx context.Context, ddb *dynamodb.Client, orgId string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+	month := strings.TrimSpace(q["month"])
+	if len(month) != 7 || month[4] != '-' {
+		return errResp(400, "month is required in format YYYY-MM")
+	}
+	shop := strings.ToLower(strings.TrimSpace(q["shop"]))
+	limit, err := productReportPageSize.Limit(q["limit"])
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+
+	members, status, err := orgReportMembers(ctx, ddb, orgId, strings.TrimSpace(q["member"]))
+	if err != nil {
+		return errResp(status, err.Error())
+	}
+
+	byKey := map[string]*ProductRow{}
+	byMember := map[string]int{}
+	withoutLines := 0
+	for _, m := range members {
+		settings, err := users.GetSettings(ctx, ddb, m.Sub)
+		if err != nil {
+			return errResp(500, "failed to load settings")
+		}
+		mine := map[string]*ProductRow{}
+		n, err := addProductRows(ctx, ddb, table, m.Sub, settings.CostModel, month, shop, mine)
+		if err != nil {
+			return errResp(500, err.Error())
+		}
+		withoutLines += n
+		byMember[m.Sub] = len(mine)
+		for k, r := range mine {
+			if all := byKey[k]; all != nil {
+				all.Orders += r.Orders
+				all.Totals.Merge(r.Totals)
+			} else {
+				byKey[k] = r
+			}
+		}
+	}
+	rows, total := sortProductRows(byKey, limit)
+
+	return jsonResp(200, map[string]any{
+		"orgId":              orgId,
+		"month":              month,
+		"shop":               shop,
+		"items":              rows,
+		"totalProducts":      total,
+		"productsByMember":   byMember,
+		"ordersWithoutLines": withoutLines,
+	})
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import "backend/internal/pagination"
+
+// Page sizes of the paged endpoints, and the scopes their nextTokens are
+// bound to (see internal/pagination).
+var (
+	transactionsPageSize  = pagination.Policy{Default: 20, Max: 100}
+	webhookLogPageSize    = pagination.Policy{Default: 50, Max: 200}
+	changelogPageSize     = pagination.Policy{Default: 20, Max: 100}
+	topProductsPageSize   = pagination.Policy{Default: 50, Max: 500}
+	productReportPageSize = pagination.Policy{Default: 50, Max: 500}
+	askHistoryPageSize    = pagination.Policy{Default: 20, Max: 100}
+)
+
+const (
+	scopeTransactions      = "transactions"
+	scopeTransactionSearch = "transactions/search"
+	scopeWebhookLog        = "shopify/events"
+	scopeAskHistory        = "ask/history"
+)
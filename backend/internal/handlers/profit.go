@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"backend/internal/costs"
+	"backend/internal/db"
+	"backend/internal/margin"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ProfitHandler costs order lines with the shop's manual product costs and
+// the caller's variable cost model (settings.costModel):
+//
+//	GET /orders/{shop}/{orderId}/profit        one order, line by line
+//	GET /reports/products?month=[&shop=&limit=] per product for a month
+//
+// and manages the product costs (see costs.go).
+func ProfitHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	if req.RawPath == "/costs" || strings.HasPrefix(req.RawPath, "/costs/") {
+		return productCosts(ctx, sub, req)
+	}
+	if req.RequestContext.HTTP.Method != "GET" {
+		return errResp(405, "method not allowed")
+	}
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	settings, err := users.GetSettings(ctx, client, sub)
+	if err != nil {
+		return errResp(500, "failed to load settings")
+	}
+
+	if req.RawPath == "/reports/products" {
+		return productReport(ctx, client, table, sub, settings.CostModel, req.QueryStringParameters)
+	}
+	parts := strings.Split(strings.TrimPrefix(req.RawPath, "/orders/"), "/")
+	if strings.HasPrefix(req.RawPath, "/orders/") && len(parts) == 3 && parts[0] != "" && parts[1] != "" && parts[2] == "profit" {
+		return orderProfit(ctx, client, table, sub, settings.CostModel, strings.ToLower(parts[0]), parts[1])
+	}
+	return errResp(404, "not found")
+}
+
+func orderProfit(ctx context.Context, client *dynamodb.Client, table, sub string, model margin.Model, shop, orderID string) (events.APIGatewayV2HTTPResponse, error) {
+	out, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("SHOPIFY#%s#ORDER#%s", shop, orderID)},
+		},
+	})
+	if err != nil {
+		return errResp(500, "failed to load order")
+	}
+	if out.Item == nil {
+		return errResp(404, "order not found")
+	}
+	var t Transaction
+	if err := attributevalue.UnmarshalMap(out.Item, &t); err != nil {
+		return errResp(500, "unmarshal failed")
+	}
+	book, err := costs.Load(ctx, client, shop)
+	if err != nil {
+		return errResp(500, "failed to load product costs")
+	}
+
+	lines := make([]margin.Line, 0, len(t.Lines))
+	var totals margin.Totals
+	for i, lc := range book.OrderLines(orderID, t.Lines) {
+		c := model.Apply(t.Lines[i], lc.Cost)
+		c.CostSource = lc.Source
+		lines = append(lines, c)
+		totals.Add(c)
+	}
+	return jsonResp(200, map[string]any{
+		"id":        t.SK,
+		"orderName": t.OrderName,
+		"shop":      t.Shop,
+		"currency":  t.Currency,
+		"createdAt": t.CreatedAt,
+		"costModel": model,
+		"lines":     lines,
+		"totals":    totals,
+		// orders synced before line capture have no lines to cost
+		"linesAvailable": len(t.Lines) > 0,
+	})
+}
+
+// ProductRow is one product's month in one currency.
+type ProductRow struct {
+	Key      string `json:"key"` // SKU, or title when there is none
+	SKU      string `json:"sku,omitempty"`
+	Title    string `json:"title"`
+	Currency string `json:"currency"`
+	Orders   int    `json:"orders"`
+	margin.Totals
+}
+
+func productReport(ctx context.Context, client *dynamodb.Client, table, sub string, model margin.Model, q map[string]string) (events.APIGatewayV2HTTPResponse, error) {
+	month := strings.TrimSpace(q["month"])
+	if len(month) != 7 || month[4] != '-' {
+		return errResp(400, "month is required in format YYYY-MM")
+	}
+	shop := strings.ToLower(strings.TrimSpace(q["shop"]))
+	limit, err := productReportPageSize.Limit(q["limit"])
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+
+	byKey := map[string]*ProductRow{}
+	withoutLines, err := addProductRows(ctx, client, table, sub, model, month, shop, byKey)
+	if err != nil {
+		return errResp(500, err.Error())
+	}
+	rows, total := sortProductRows(byKey, limit)
+
+	return jsonResp(200, map[string]any{
+		"month":              month,
+		"shop":               shop,
+		"costModel":          model,
+		"items":              rows,
+		"totalProducts":      total,
+		"ordersWithoutLines": withoutLines,
+	})
+}
+
+// addProductRows costs the order lines of sub's month (of one shop, when
+// shop is set) with model and adds them to byKey, by product and currency.
+// It returns the orders that have no lines to cost. Errors are messages
+// for the response.
+func addProductRows(ctx context.Context, client *dynamodb.Client, table, sub string, model margin.Model, month, shop string, byKey map[string]*ProductRow) (int, error) {
+	// Split orders still sell the same products: cost the parent's lines and
+	// skip its allocations.
+	items, err := queryMonthItems(ctx, client, table, sub, month)
+	if err != nil {
+		return 0, fmt.Errorf("query failed")
+	}
+
+	books := map[string]*costs.Book{}
+	withoutLines := 0
+	for _, t := range items {
+		if t.ParentId != "" || t.DeletedAt != "" || (t.Category != "Shopify Sales" && t.SplitInto == 0) || (shop != "" && t.Shop != shop) {
+			continue
+		}
+		if len(t.Lines) == 0 {
+			withoutLines++
+			continue
+		}
+		book := books[t.Shop]
+		if book == nil {
+			if book, err = costs.Load(ctx, client, t.Shop); err != nil {
+				return 0, fmt.Errorf("failed to load product costs")
+			}
+			books[t.Shop] = book
+		}
+		_, orderID, _ := strings.Cut(t.SK, "#ORDER#")
+		lineCosts := book.OrderLines(orderID, t.Lines)
+		for i, l := range t.Lines {
+			k := l.Key() + "|" + t.Currency
+			r := byKey[k]
+			if r == nil {
+				r = &ProductRow{Key: l.Key(), SKU: l.SKU, Title: l.Title, Currency: t.Currency}
+				byKey[k] = r
+			}
+			r.Orders++
+			r.Add(model.Apply(l, lineCosts[i].Cost))
+		}
+	}
+	return withoutLines, nil
+}
+
+// sortProductRows orders byKey by contribution margin, best first, and
+// returns the first limit rows and how many there were.
+func sortProductRows(byKey map[string]*ProductRow, limit int) ([]*ProductRow, int) {
+	rows := make([]*ProductRow, 0, len(byKey))
+	for _, r := range byKey {
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].ContributionMargin != rows[j].ContributionMargin {
+			return rows[i].ContributionMargin > rows[j].ContributionMargin
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	total := len(rows)
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, total
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"backend/internal/db"
+	"backend/internal/recharge"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// RechargeHandler connects a Recharge store with an API token and receives
+// its webhooks at /webhooks/recharge/{webhookKey}.
+func RechargeHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	method := req.RequestContext.HTTP.Method
+	switch {
+	case req.RawPath == "/integrations/recharge":
+		switch method {
+		case "GET":
+			return rechargeGet(ctx, req)
+		case "PUT":
+			return rechargeConnect(ctx, req)
+		case "DELETE":
+			return rechargeDisconnect(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case strings.HasPrefix(req.RawPath, "/webhooks/recharge/"):
+		if method == "POST" {
+			return rechargeWebhook(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func rechargeGet(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	integ, err := recharge.Load(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+	if integ == nil {
+		return jsonResp(200, map[string]any{"connected": false})
+	}
+	return jsonResp(200, map[string]any{"connected": true, "integration": integ})
+}
+
+// rechargeConnect validates the token, stores it and subscribes the webhooks.
+// Body: {"apiToken": "...", "clientSecret": "..."}; the client secret is the
+// one Recharge signs webhooks with.
+func rechargeConnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	var in struct {
+		ApiToken     string `json:"apiToken"`
+		ClientSecret string `json:"clientSecret"`
+	}
+	if err := json.Unmarshal([]byte(req.Body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	in.ApiToken = strings.TrimSpace(in.ApiToken)
+	in.ClientSecret = strings.TrimSpace(in.ClientSecret)
+	if in.ApiToken == "" || in.ClientSecret == "" {
+		return errResp(400, "apiToken and clientSecret are required")
+	}
+
+	store, err := recharge.GetStore(ctx, in.ApiToken)
+	if err != nil {
+		return errResp(400, "Recharge rejected the API token")
+	}
+
+	webhookKey, err := randomState(18)
+	if err != nil {
+		return errResp(500, "failed to generate webhook key")
+	}
+	base, err := getApiBaseUrl()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+	address := strings.TrimRight(base, "/") + "/webhooks/recharge/" + webhookKey
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	integ, err := recharge.Save(ctx, ddb, sub, store, in.ApiToken, in.ClientSecret, webhookKey)
+	if err != nil {
+		return errResp(500, "failed to store integration")
+	}
+
+	var failed []string
+	for _, topic := range recharge.Topics {
+		if err := recharge.CreateWebhook(ctx, in.ApiToken, topic, address); err != nil {
+			failed = append(failed, topic)
+		}
+	}
+
+	return jsonResp(200, map[string]any{
+		"ok":                  len(failed) == 0,
+		"integration":         integ,
+		"failedSubscriptions": failed,
+	})
+}
+
+func rechargeDisconnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	if err := recharge.Delete(ctx, ddb, sub); err != nil {
+		return errResp(500, "failed to delete integration")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+type rechargeWebhookBody struct {
+	Charge *recharge.Charge `json:"charge"`
+}
+
+func rechargeWebhook(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	webhookKey := strings.TrimPrefix(req.RawPath, "/webhooks/recharge/")
+
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return errResp(400, "invalid body")
+		}
+		body = b
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	integ, err := recharge.Resolve(ctx, ddb, webhookKey)
+	if err != nil {
+		return errResp(500, "lookup failed")
+	}
+	if integ == nil {
+		return errResp(404, "not found")
+	}
+	secret, err := integ.ClientSecret()
+	if err != nil || !recharge.VerifyWebhook(secret, body, req.Headers["x-recharge-hmac-sha256"]) {
+		return errResp(401, "invalid signature")
+	}
+
+	var ev rechargeWebhookBody
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return errResp(400, "invalid json")
+	}
+	if ev.Charge == nil {
+		return jsonResp(200, map[string]any{"ok": true, "ignored": true})
+	}
+
+	txTable := db.TransactionsTableName()
+	topic := req.Headers["x-recharge-topic"]
+	var wrote bool
+	switch topic {
+	case "charge/paid":
+		wrote, err = recharge.WriteCharge(ctx, ddb, txTable, integ, *ev.Charge)
+	case "charge/refunded":
+		wrote, err = recharge.WriteRefund(ctx, ddb, txTable, integ, *ev.Charge)
+	default:
+		return jsonResp(200, map[string]any{"ok": true, "ignored": true})
+	}
+	if err != nil {
+		// non-2xx makes Recharge retry the delivery
+		return errResp(500, "failed to write transaction")
+	}
+	recharge.Touch(ctx, ddb, integ.UserSub())
+
+	return jsonResp(200, map[string]any{"ok": true, "written": wrote})
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/recurring"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// RecurringExpensesHandler manages recurring expense rules:
+//
+//	GET    /recurring-expenses        list rules
+//	POST   /recurring-expenses        create a rule
+//	PUT    /recurring-expenses/{id}   replace a rule
+//	DELETE /recurring-expenses/{id}   stop a rule (posted transactions stay)
+func RecurringExpensesHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	method := req.RequestContext.HTTP.Method
+
+	if req.RawPath == "/recurring-expenses" {
+		switch method {
+		case "GET":
+			rules, err := recurring.List(ctx, client, sub)
+			if err != nil {
+				return errResp(500, "failed to list rules")
+			}
+			return jsonResp(200, map[string]any{"items": rules})
+		case "POST":
+			return putRecurringRule(ctx, client, sub, "", req.Body)
+		}
+		return errResp(405, "method not allowed")
+	}
+
+	id := strings.TrimPrefix(req.RawPath, "/recurring-expenses/")
+	if !strings.HasPrefix(req.RawPath, "/recurring-expenses/") || id == "" || strings.Contains(id, "/") {
+		return errResp(404, "not found")
+	}
+	switch method {
+	case "PUT":
+		return putRecurringRule(ctx, client, sub, id, req.Body)
+	case "DELETE":
+		if _, err := recurring.Get(ctx, client, sub, id); errors.Is(err, recurring.ErrNotFound) {
+			return errResp(404, "rule not found")
+		} else if err != nil {
+			return errResp(500, "failed to load rule")
+		}
+		if err := recurring.Delete(ctx, client, sub, id); err != nil {
+			return errResp(500, "failed to delete rule")
+		}
+		return jsonResp(200, map[string]any{"ok": true})
+	}
+	return errResp(405, "method not allowed")
+}
+
+func putRecurringRule(ctx context.Context, client *dynamodb.Client, sub, id, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var r recurring.Rule
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		return errResp(400, "invalid json")
+	}
+	if err := r.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+
+	status := 201
+	if id != "" {
+		old, err := recurring.Get(ctx, client, sub, id)
+		if errors.Is(err, recurring.ErrNotFound) {
+			return errResp(404, "rule not found")
+		}
+		if err != nil {
+			return errResp(500, "failed to load rule")
+		}
+		r.Id, r.CreatedAt, r.NextIndex = old.Id, old.CreatedAt, old.NextIndex
+		if r.Cadence != old.Cadence || r.StartDate != old.StartDate {
+			// A new schedule only posts from today on; past dates were
+			// either posted under the old schedule or never owed.
+			today := time.Now().UTC().Format("2006-01-02")
+			r.NextIndex = 0
+			for r.Occurrence(r.NextIndex).Format("2006-01-02") < today {
+				r.NextIndex++
+			}
+		}
+		status = 200
+	}
+
+	r, err := recurring.Put(ctx, client, sub, r)
+	if err != nil {
+		return errResp(500, "failed to save rule")
+	}
+	return jsonResp(status, r)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"backend/internal/db"
+	"backend/internal/exports"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ReportSchedulesHandler manages scheduled CSV exports (see package exports):
+//
+//	GET    /report-schedules                 list schedules
+//	POST   /report-schedules                 create a schedule
+//	PUT    /report-schedules/{id}            replace a schedule
+//	DELETE /report-schedules/{id}            stop a schedule (exports stay)
+//	GET    /report-schedules/{id}/download   fresh link to the latest export
+//
+// and, through exportDestinations, the buckets exports are copied to.
+func ReportSchedulesHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	method := req.RequestContext.HTTP.Method
+
+	if strings.HasPrefix(req.RawPath, "/export-destinations") {
+		return exportDestinations(ctx, client, sub, req)
+	}
+	if req.RawPath == "/report-schedules" {
+		switch method {
+		case "GET":
+			schedules, err := exports.List(ctx, client, sub)
+			if err != nil {
+				return errResp(500, "failed to list schedules")
+			}
+			return jsonResp(200, map[string]any{"items": schedules})
+		case "POST":
+			return putReportSchedule(ctx, client, sub, "", req.Body)
+		}
+		return errResp(405, "method not allowed")
+	}
+
+	rest := strings.TrimPrefix(req.RawPath, "/report-schedules/")
+	if !strings.HasPrefix(req.RawPath, "/report-schedules/") || rest == "" {
+		return errResp(404, "not found")
+	}
+	if id, ok := strings.CutSuffix(rest, "/download"); ok && id != "" && !strings.Contains(id, "/") {
+		if method != "GET" {
+			return errResp(405, "method not allowed")
+		}
+		return reportDownload(ctx, client, sub, id)
+	}
+	id := rest
+	if strings.Contains(id, "/") {
+		return errResp(404, "not found")
+	}
+	switch method {
+	case "PUT":
+		return putReportSchedule(ctx, client, sub, id, req.Body)
+	case "DELETE":
+		if _, err := exports.Get(ctx, client, sub, id); errors.Is(err, exports.ErrNotFound) {
+			return errResp(404, "schedule not found")
+		} else if err != nil {
+			return errResp(500, "failed to load schedule")
+		}
+		if err := exports.Delete(ctx, client, sub, id); err != nil {
+			return errResp(500, "failed to delete schedule")
+		}
+		return jsonResp(200, map[string]any{"ok": true})
+	}
+	return errResp(405, "method not allowed")
+}
+
+func putReportSchedule(ctx context.Context, client *dynamodb.Client, sub, id, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var s exports.Schedule
+	if err := json.Unmarshal([]byte(body), &s); err != nil {
+		return errResp(400, "invalid json")
+	}
+	if err := s.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+	if s.Shop != "" {
+		owned, resp, ok := ownedShop(ctx, client, sub, s.Shop)
+		if !ok {
+			return resp, nil
+		}
+		s.Shop = owned
+	}
+	if s.DestinationId != "" {
+		if _, err := exports.GetDestination(ctx, client, sub, s.DestinationId); errors.Is(err, exports.ErrDestinationNotFound) {
+			return errResp(400, "destination not found")
+		} else if err != nil {
+			return errResp(500, "failed to load destination")
+		}
+	}
+	s.NextRun = ""
+
+	status := 201
+	if id != "" {
+		old, err := exports.Get(ctx, client, sub, id)
+		if errors.Is(err, exports.ErrNotFound) {
+			return errResp(404, "schedule not found")
+		}
+		if err != nil {
+			return errResp(500, "failed to load schedule")
+		}
+		s.Id, s.CreatedAt = old.Id, old.CreatedAt
+		s.LastKey, s.LastRunAt, s.LastFrom, s.LastTo = old.LastKey, old.LastRunAt, old.LastFrom, old.LastTo
+		if s.Cadence == old.Cadence {
+			s.NextRun = old.NextRun
+		}
+		status = 200
+	}
+
+	s, err := exports.Put(ctx, client, sub, s)
+	if err != nil {
+		return errResp(500, "failed to save schedule")
+	}
+	return jsonResp(status, s)
+}
+
+// reportDownload signs a new link to a schedule's latest export.
+func reportDownload(ctx context.Context, client *dynamodb.Client, sub, id string) (events.APIGatewayV2HTTPResponse, error) {
+	s, err := exports.Get(ctx, client, sub, id)
+	if errors.Is(err, exports.ErrNotFound) {
+		return errResp(404, "schedule not found")
+	}
+	if err != nil {
+		return errResp(500, "failed to load schedule")
+	}
+	if s.LastKey == "" {
+		return errResp(404, "no export yet")
+	}
+	if exports.Bucket() == "" {
+		return errResp(500, "ANALYTICS_BUCKET not set")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	ttl := exports.LinkTTL()
+	link, err := exports.Link(ctx, s3.NewFromConfig(cfg), s.LastKey, ttl)
+	if err != nil {
+		return errResp(500, "failed to presign download")
+	}
+	return jsonResp(200, map[string]any{
+		"url":       link,
+		"from":      s.LastFrom,
+		"to":        s.LastTo,
+		"expiresIn": int(ttl.Seconds()),
+	})
+}
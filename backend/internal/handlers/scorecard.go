@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/live"
+	"backend/internal/scorecard"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// ShopsHandler serves per-shop views under /shops/{shop}/...
+func ShopsHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(req.RawPath, "/shops/"), "/")
+	if !strings.HasPrefix(req.RawPath, "/shops/") || len(parts) != 2 || parts[0] == "" {
+		return errResp(404, "not found")
+	}
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, client, sub)
+	if err != nil {
+		return errResp(500, "shop lookup failed")
+	}
+	shop := ""
+	for _, a := range allowed {
+		if strings.EqualFold(a, parts[0]) {
+			shop = a
+		}
+	}
+	if shop == "" {
+		return errResp(404, "shop not found")
+	}
+
+	switch parts[1] {
+	case "scorecard":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return shopScorecard(ctx, client, sub, shop, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+// shopScorecard serves GET /shops/{shop}/scorecard?month=YYYY-MM (default:
+// this month): margin, growth, refund rate and ROAS with letter grades,
+// compared with the month before.
+func shopScorecard(ctx context.Context, client *dynamodb.Client, sub, shop string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+
+	now := time.Now().In(live.Location())
+	month := strings.TrimSpace(req.QueryStringParameters["month"])
+	if month == "" {
+		month = now.Format("2006-01")
+	}
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return errResp(400, "month must be in format YYYY-MM")
+	}
+	if month > now.Format("2006-01") {
+		return errResp(400, "month is in the future")
+	}
+	prev := start.AddDate(0, -1, 0).Format("2006-01")
+
+	var in [2]scorecard.Inputs
+	for i, m := range []string{month, prev} {
+		in[i], err = shopMonthInputs(ctx, client, table, sub, shop, m, now)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+	}
+
+	sc := scorecard.Build(in[0], in[1])
+	return jsonResp(200, map[string]any{
+		"shop":      shop,
+		"month":     month,
+		"scorecard": sc,
+	})
+}
+
+// shopMonthInputs sums one shop's month from its transactions (revenue and
+// costs, including its revenue share of allocated manual costs) and live
+// aggregates (order count).
+func shopMonthInputs(ctx context.Context, client *dynamodb.Client, table, sub, shop, month string, now time.Time) (scorecard.Inputs, error) {
+	start, _ := time.Parse("2006-01", month)
+	in := scorecard.Inputs{Month: month, Days: start.AddDate(0, 1, -1).Day()}
+	if month == now.Format("2006-01") {
+		in.Days = now.Day()
+	}
+
+	items, err := queryMonthTransactions(ctx, client, table, sub, month)
+	if err != nil {
+		return in, err
+	}
+	for _, t := range allocateShops(items) {
+		if !strings.EqualFold(t.Shop, shop) {
+			continue
+		}
+		addEfficiencyInput(&in, t)
+	}
+	for _, v := range []*float64{&in.Revenue, &in.Refunds, &in.AdSpend, &in.OtherCosts} {
+		*v = math.Round(*v*100) / 100
+	}
+
+	totals, err := live.Month(ctx, client, sub, shop, month)
+	if err != nil {
+		// order counts are a nice-to-have; AOV is left out without them
+		return in, nil
+	}
+	in.Orders = totals.Orders
+	return in, nil
+}
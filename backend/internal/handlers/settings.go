@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/periods"
+	"backend/internal/users"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// SettingsHandler serves the caller's reporting settings (GET/PUT /settings)
+// and the periods their fiscal calendar produces (GET /settings/periods).
+func SettingsHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	switch req.RawPath {
+	case "/settings":
+		switch req.RequestContext.HTTP.Method {
+		case "GET":
+			return getSettings(ctx, sub)
+		case "PUT":
+			return putSettings(ctx, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/settings/periods":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return listPeriods(ctx, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func getSettings(ctx context.Context, sub string) (events.APIGatewayV2HTTPResponse, error) {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	s, err := users.GetSettings(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load settings")
+	}
+	return jsonResp(200, s)
+}
+
+func putSettings(ctx context.Context, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	s := users.DefaultSettings()
+	if err := json.Unmarshal([]byte(body), &s); err != nil {
+		return errResp(400, "invalid json")
+	}
+	s.Calendar.Pattern = strings.TrimSpace(s.Calendar.Pattern)
+	if err := s.Calendar.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+	if err := s.CostModel.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+	if err := s.VAT.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+	if err := s.Notifications.Validate(); err != nil {
+		return errResp(400, err.Error())
+	}
+	s.BaseCurrency = strings.ToUpper(strings.TrimSpace(s.BaseCurrency))
+	if s.BaseCurrency != "" && len(s.BaseCurrency) != 3 {
+		return errResp(400, "baseCurrency must be a 3-letter currency code")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	if err := users.PutSettings(ctx, ddb, sub, s); err != nil {
+		return errResp(500, "failed to save settings")
+	}
+	return jsonResp(200, s)
+}
+
+type periodJSON struct {
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func toPeriodJSON(p periods.Period) periodJSON {
+	return periodJSON{Name: p.Name, From: p.FromISO(), To: p.ToISO()}
+}
+
+// listPeriods resolves ?period=<spec> (this_quarter, FY2026-P03, ...) or,
+// without it, lists the periods of ?fy= (default: the current fiscal year).
+func listPeriods(ctx context.Context, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	s, err := users.GetSettings(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load settings")
+	}
+	cal := s.Calendar
+	today := time.Now().UTC()
+
+	if spec := strings.TrimSpace(req.QueryStringParameters["period"]); spec != "" {
+		p, err := cal.Resolve(spec, today)
+		if err != nil {
+			return errResp(400, err.Error())
+		}
+		return jsonResp(200, toPeriodJSON(p))
+	}
+
+	fy := cal.FiscalYear(today)
+	if v := strings.TrimSpace(req.QueryStringParameters["fy"]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2000 || n > 2100 {
+			return errResp(400, "invalid fy")
+		}
+		fy = n
+	}
+	items := make([]periodJSON, 0, 12)
+	for _, p := range cal.Periods(fy) {
+		items = append(items, toPeriodJSON(p))
+	}
+	quarters := make([]periodJSON, 0, 4)
+	for q := 1; q <= 4; q++ {
+		quarters = append(quarters, toPeriodJSON(cal.Quarter(fy, q)))
+	}
+	return jsonResp(200, map[string]any{
+		"fiscalYear": toPeriodJSON(cal.Year(fy)),
+		"quarters":   quarters,
+		"periods":    items,
+	})
+}
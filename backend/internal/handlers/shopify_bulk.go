@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"backend/internal/db"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const maxBulkConnectShops = 50
+
+type bulkConnectRequest struct {
+	Shops []string `json:"shops"`
+}
+
+// shopifyBulkCreate serves POST /integrations/shopify/bulk with {"shops": [...]}.
+// Already connected stores are marked skipped; the response carries the
+// authorize URL of the first queued store.
+func shopifyBulkCreate(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	var body bulkConnectRequest
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		return errResp(400, "invalid json")
+	}
+
+	seen := map[string]bool{}
+	shops := make([]string, 0, len(body.Shops))
+	for _, s := range body.Shops {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" || seen[s] {
+			continue
+		}
+		if !isValidShopDomain(s) {
+			return errResp(400, "invalid shop: "+s)
+		}
+		seen[s] = true
+		shops = append(shops, s)
+	}
+	if len(shops) == 0 {
+		return errResp(400, "shops is required")
+	}
+	if len(shops) > maxBulkConnectShops {
+		return errResp(400, "too many shops (max 50)")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	batchID, err := randomState(12)
+	if err != nil {
+		return errResp(500, "failed to generate batch id")
+	}
+	batch := shopify.NewBulkConnectBatch(sub, batchID, shops)
+
+	for _, s := range shops {
+		if _, _, err := shopify.LoadIntegrationAndDecryptToken(ctx, sub, s); err == nil {
+			batch.SetStatus(s, shopify.BulkSkipped, "already connected")
+		}
+	}
+
+	next, err := startNextBulkConnect(ctx, ddb, sub, batch)
+	if err != nil {
+		return errResp(500, err.Error())
+	}
+
+	return jsonResp(201, bulkStatusBody(batch, next))
+}
+
+// shopifyBulkStatus serves GET /integrations/shopify/bulk?batchId= as the combined status page.
+func shopifyBulkStatus(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	batchID := strings.TrimSpace(req.QueryStringParameters["batchId"])
+	if batchID == "" {
+		return errResp(400, "batchId is required")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	batch, err := shopify.LoadBulkConnectBatch(ctx, ddb, sub, batchID)
+	if err != nil {
+		return errResp(500, "failed to load batch")
+	}
+	if batch == nil {
+		return errResp(404, "batch not found")
+	}
+
+	return jsonResp(200, bulkStatusBody(batch, ""))
+}
+
+// shopifyBulkNext serves POST /integrations/shopify/bulk/next?batchId=. A store
+// whose OAuth was started but never finished is marked failed (the user
+// abandoned or declined it) and the next queued store is started.
+func shopifyBulkNext(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	batchID := strings.TrimSpace(req.QueryStringParameters["batchId"])
+	if batchID == "" {
+		return errResp(400, "batchId is required")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	batch, err := shopify.LoadBulkConnectBatch(ctx, ddb, sub, batchID)
+	if err != nil {
+		return errResp(500, "failed to load batch")
+	}
+	if batch == nil {
+		return errResp(404, "batch not found")
+	}
+
+	if s := batch.InProgress(); s != "" {
+		batch.SetStatus(s, shopify.BulkFailed, "authorization not completed")
+	}
+
+	next, err := startNextBulkConnect(ctx, ddb, sub, batch)
+	if err != nil {
+		return errResp(500, err.Error())
+	}
+
+	return jsonResp(200, bulkStatusBody(batch, next))
+}
+
+// advanceBulkConnect is called from the OAuth callback once shop is connected.
+// Returns the next store's authorize URL, or "" when the batch is finished.
+func advanceBulkConnect(ctx context.Context, ddb *dynamodb.Client, sub, batchID, shop string) (string, error) {
+	batch, err := shopify.LoadBulkConnectBatch(ctx, ddb, sub, batchID)
+	if err != nil || batch == nil {
+		return "", err
+	}
+	batch.SetStatus(shop, shopify.BulkConnected, "")
+	return startNextBulkConnect(ctx, ddb, sub, batch)
+}
+
+// startNextBulkConnect marks the next pending store in_progress, saves the
+// batch and returns that store's authorize URL ("" when none are left).
+func startNextBulkConnect(ctx context.Context, ddb *dynamodb.Client, sub string, batch *shopify.BulkConnectBatch) (string, error) {
+	next := batch.NextPending()
+	authorizeURL := ""
+	if next != "" {
+		u, err := startShopifyOAuth(ctx, ddb, sub, next, batch.BatchId)
+		if err != nil {
+			return "", err
+		}
+		authorizeURL = u
+		batch.SetStatus(next, shopify.BulkInProgress, "")
+	}
+	if err := shopify.SaveBulkConnectBatch(ctx, ddb, batch); err != nil {
+		return "", err
+	}
+	return authorizeURL, nil
+}
+
+func bulkStatusBody(batch *shopify.BulkConnectBatch, nextAuthorizeURL string) map[string]any {
+	counts := batch.Counts()
+	return map[string]any{
+		"batchId":          batch.BatchId,
+		"createdAt":        batch.CreatedAt,
+		"updatedAt":        batch.UpdatedAt,
+		"shops":            batch.Shops,
+		"counts":           counts,
+		"done":             counts[shopify.BulkPending] == 0 && counts[shopify.BulkInProgress] == 0,
+		"nextAuthorizeUrl": nextAuthorizeURL,
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"backend/internal/db"
+	"backend/internal/pagination"
+	"backend/internal/shopify"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// shopifyShopEvents serves GET /integrations/shopify/shops/{shop}/events
+// [?webhookId=&topic=&limit=&nextToken=]: the webhook log of a shop the
+// caller is connected to (admins may read any shop), newest first, so a user
+// or support can confirm a specific Shopify event arrived and how it ended.
+func shopifyShopEvents(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	q := req.QueryStringParameters
+
+	shop := req.PathParameters["shop"]
+	if shop == "" {
+		shop = strings.TrimSuffix(strings.TrimPrefix(req.RawPath, "/integrations/shopify/shops/"), "/events")
+	}
+	if u, err := url.PathUnescape(shop); err == nil {
+		shop = u
+	}
+	shop = strings.ToLower(strings.TrimSpace(shop))
+	if !isValidShopDomain(shop) {
+		return errResp(400, "invalid shop")
+	}
+
+	limit, err := webhookLogPageSize.Limit(q["limit"])
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+
+	eks, err := pagination.DecodeToken(scopeWebhookLog, sub, q["nextToken"])
+	if err != nil {
+		return errResp(400, "invalid nextToken")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	if !isAdmin(req, sub) {
+		allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, ddb, sub)
+		if err != nil {
+			return errResp(500, "shop lookup failed")
+		}
+		owned := false
+		for _, a := range allowed {
+			if strings.EqualFold(a, shop) {
+				owned = true
+			}
+		}
+		if !owned {
+			return errResp(404, "shop not connected")
+		}
+	}
+
+	entries, lek, err := shopify.ListWebhookLog(ctx, ddb, shop,
+		strings.TrimSpace(q["webhookId"]), strings.TrimSpace(q["topic"]), int32(limit), eks)
+	if err != nil {
+		return errResp(500, "failed to load events")
+	}
+
+	nextToken, err := pagination.EncodeToken(scopeWebhookLog, sub, lek)
+	if err != nil {
+		return errResp(500, "failed to encode nextToken")
+	}
+	return jsonResp(200, map[string]any{
+		"shop":      shop,
+		"items":     entries,
+		"nextToken": nextToken,
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -21,10 +22,12 @@ import (
 	"backend/internal/db"
 	"backend/internal/security"
 	"backend/internal/shopify"
+	"backend/internal/tenancy"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
@@ -45,12 +48,37 @@ func ShopifyHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (ev
 			return shopifyDisconnectShop(ctx, req)
 		}
 		return errResp(405, "method not allowed")
+	case "/integrations/shopify/bulk":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return shopifyBulkCreate(ctx, req)
+		}
+		if req.RequestContext.HTTP.Method == "GET" {
+			return shopifyBulkStatus(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/integrations/shopify/bulk/next":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return shopifyBulkNext(ctx, req)
+		}
+		return errResp(405, "method not allowed")
 	case "/integrations/shopify/sync":
 		if req.RequestContext.HTTP.Method == "POST" {
 			return shopifySyncStub(ctx, req)
 		}
 		return errResp(405, "method not allowed")
 	default:
+		if strings.HasPrefix(req.RawPath, "/integrations/shopify/shops/") && strings.HasSuffix(req.RawPath, "/test") {
+			if req.RequestContext.HTTP.Method == "POST" {
+				return shopifyTestShop(ctx, req)
+			}
+			return errResp(405, "method not allowed")
+		}
+		if strings.HasPrefix(req.RawPath, "/integrations/shopify/shops/") && strings.HasSuffix(req.RawPath, "/events") {
+			if req.RequestContext.HTTP.Method == "GET" {
+				return shopifyShopEvents(ctx, req)
+			}
+			return errResp(405, "method not allowed")
+		}
 		return errResp(404, "not found")
 	}
 }
@@ -67,41 +95,59 @@ func shopifyConnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (ev
 		return errResp(400, "invalid shop (expected like your-store.myshopify.com)")
 	}
 
-	state, err := randomState(24)
+	ddb, err := db.NewDynamoClient(ctx)
 	if err != nil {
-		return errResp(500, "failed to generate state")
+		return errResp(500, "failed to init dynamodb")
 	}
 
-	ddb, err := db.NewDynamoClient(ctx)
+	authorizeURL, err := startShopifyOAuth(ctx, ddb, sub, shop, "")
 	if err != nil {
-		return errResp(500, "failed to init dynamodb")
+		return errResp(500, err.Error())
+	}
+
+	return jsonResp(200, map[string]any{
+		"authorizeUrl": authorizeURL,
+	})
+}
+
+// startShopifyOAuth stores a one-time state (optionally tied to a bulk connect
+// batch) and returns the shop's OAuth authorize URL.
+func startShopifyOAuth(ctx context.Context, ddb *dynamodb.Client, sub, shop, batchID string) (string, error) {
+	state, err := randomState(24)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate state")
 	}
 
 	stateTable := db.OAuthStateTableName()
 	if strings.TrimSpace(stateTable) == "" {
-		return errResp(500, "OAUTH_STATE_TABLE not set")
+		return "", fmt.Errorf("OAUTH_STATE_TABLE not set")
 	}
 
 	exp := time.Now().UTC().Add(10 * time.Minute).Unix()
 
+	item := map[string]types.AttributeValue{
+		"State":          &types.AttributeValueMemberS{Value: state},
+		"UserSub":        &types.AttributeValueMemberS{Value: sub},
+		"Shop":           &types.AttributeValueMemberS{Value: shop},
+		"ExpiresAtEpoch": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
+	}
+	if batchID != "" {
+		item["BatchId"] = &types.AttributeValueMemberS{Value: batchID}
+	}
+
 	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(stateTable),
-		Item: map[string]types.AttributeValue{
-			"State":          &types.AttributeValueMemberS{Value: state},
-			"UserSub":        &types.AttributeValueMemberS{Value: sub},
-			"Shop":           &types.AttributeValueMemberS{Value: shop},
-			"ExpiresAtEpoch": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
-		},
+		Item:      item,
 	})
 	if err != nil {
-		return errResp(500, "failed to store oauth state")
+		return "", fmt.Errorf("failed to store oauth state")
 	}
 
 	apiKey := os.Getenv("SHOPIFY_API_KEY")
 	scopes := strings.TrimSpace(os.Getenv("SHOPIFY_SCOPES"))
 	redirectBase, err := getApiBaseUrl()
 	if err != nil {
-		return errResp(500, "failed to get API base URL")
+		return "", fmt.Errorf("failed to get API base URL")
 	}
 	redirectBase = strings.TrimRight(redirectBase, "/")
 
@@ -116,9 +162,7 @@ func shopifyConnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (ev
 	q.Set("state", state)
 	u.RawQuery = q.Encode()
 
-	return jsonResp(200, map[string]any{
-		"authorizeUrl": u.String(),
-	})
+	return u.String(), nil
 }
 
 func shopifyCallback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
@@ -248,15 +292,34 @@ func shopifyCallback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (e
 				"CreatedAt": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
 			},
 		})
+		tenancy.InvalidateAllowedShops(userSub)
+		shopify.InvalidateUsersForShop(shop)
+	}
+
+	// Subscribe this shop to required webhooks. The worker retries; if the
+	// queue is unavailable, make one attempt here so the result is recorded.
+	if err := shopify.EnqueueWebhookSubscribe(ctx, ddb, userSub, shop); err != nil {
+		fmt.Printf("shopify: webhook subscribe enqueue shop=%s failed: %v\n", shop, err)
+		eventSourceArn := strings.TrimSpace(os.Getenv("SHOPIFY_EVENTBRIDGE_SOURCE_ARN"))
+		apiVersion := strings.TrimSpace(os.Getenv("SHOPIFY_API_VERSION"))
+		if apiVersion == "" {
+			apiVersion = "2026-01"
+		}
+		topics := shopify.SubscribeEventBridgeTopics(ctx, shop, apiVersion, tok.AccessToken, eventSourceArn)
+		now := time.Now().UTC().Format(time.RFC3339)
+		for i := range topics {
+			topics[i].Attempts = 1
+			topics[i].UpdatedAt = now
+		}
+		_ = shopify.RecordWebhookStatus(ctx, ddb, userSub, shop, shopify.OverallWebhookStatus(topics, false), topics)
 	}
 
-	// Subscribe this shop to required webhooks
-	eventSourceArn := strings.TrimSpace(os.Getenv("SHOPIFY_EVENTBRIDGE_SOURCE_ARN"))
-	apiVersion := strings.TrimSpace(os.Getenv("SHOPIFY_API_VERSION"))
-	if apiVersion == "" {
-		apiVersion = "2026-01"
+	// Kick off the 90-day backfill so the dashboard fills without a manual sync.
+	initialSync := shopify.InitialSyncQueued
+	if err := shopify.EnqueueInitialSync(ctx, ddb, userSub, shop); err != nil {
+		fmt.Printf("shopify: initial sync enqueue shop=%s failed: %v\n", shop, err)
+		initialSync = shopify.InitialSyncFailed
 	}
-	shopify.SubscribeEventBridgeTopics(ctx, shop, apiVersion, tok.AccessToken, eventSourceArn)
 
 	// one-time state cleanup
 	_, _ = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
@@ -271,10 +334,24 @@ func shopifyCallback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (e
 	if fe == "" {
 		fe = "/"
 	}
+
+	// Bulk connect: go straight on to the next queued store.
+	if batchID := attrS(out.Item["BatchId"]); batchID != "" {
+		location := fe + "/shopify/bulk?batchId=" + url.QueryEscape(batchID)
+		if next, err := advanceBulkConnect(ctx, ddb, userSub, batchID, shop); err != nil {
+			fmt.Printf("shopify: bulk connect batch=%s advance failed: %v\n", batchID, err)
+		} else if next != "" {
+			location = next
+		}
+		return events.APIGatewayV2HTTPResponse{
+			StatusCode: 302,
+			Headers:    map[string]string{"location": location},
+		}, nil
+	}
 	return events.APIGatewayV2HTTPResponse{
 		StatusCode: 302,
 		Headers: map[string]string{
-			"location": fe + "/shopify?connected=1&shop=" + url.QueryEscape(shop),
+			"location": fe + "/shopify?connected=1&shop=" + url.QueryEscape(shop) + "&initialSync=" + initialSync,
 		},
 	}, nil
 }
@@ -317,6 +394,22 @@ func shopifyListShops(ctx context.Context, req events.APIGatewayV2HTTPRequest) (
 		LastEventAt        string `json:"lastEventAt"`
 		LastEventTopic     string `json:"lastEventTopic"`
 		LastEventWebhookId string `json:"lastEventWebhookId"`
+		LastCheckedAt      string `json:"lastCheckedAt"`
+		LastCheckStatus    string `json:"lastCheckStatus"`
+		LastCheckReason    string `json:"lastCheckReason"`
+		LastGapCheckAt     string `json:"lastGapCheckAt"`
+		LastGapRepairAt    string `json:"lastGapRepairAt"`
+		LastGapFound       int    `json:"lastGapFound"`
+		LastGapRecovered   int    `json:"lastGapRecovered"`
+
+		InitialSyncStatus     string `json:"initialSyncStatus"`
+		InitialSyncOrders     int    `json:"initialSyncOrders"`
+		InitialSyncStartedAt  string `json:"initialSyncStartedAt"`
+		InitialSyncFinishedAt string `json:"initialSyncFinishedAt"`
+		InitialSyncError      string `json:"initialSyncError"`
+
+		WebhooksStatus string                       `json:"webhooksStatus"`
+		Webhooks       []shopify.WebhookTopicStatus `json:"webhooks"`
 	}
 
 	items := make([]ShopItem, 0, len(out.Items))
@@ -328,12 +421,36 @@ func shopifyListShops(ctx context.Context, req events.APIGatewayV2HTTPRequest) (
 			LastEventAt:        attrS(it["LastEventAt"]),
 			LastEventTopic:     attrS(it["LastEventTopic"]),
 			LastEventWebhookId: attrS(it["LastEventWebhookId"]),
+			LastCheckedAt:      attrS(it["LastCheckedAt"]),
+			LastCheckStatus:    attrS(it["LastCheckStatus"]),
+			LastCheckReason:    attrS(it["LastCheckReason"]),
+			LastGapCheckAt:     attrS(it["LastGapCheckAt"]),
+			LastGapRepairAt:    attrS(it["LastGapRepairAt"]),
+			LastGapFound:       attrInt(it["LastGapFound"]),
+			LastGapRecovered:   attrInt(it["LastGapRecovered"]),
+
+			InitialSyncStatus:     attrS(it["InitialSyncStatus"]),
+			InitialSyncOrders:     attrInt(it["InitialSyncOrders"]),
+			InitialSyncStartedAt:  attrS(it["InitialSyncStartedAt"]),
+			InitialSyncFinishedAt: attrS(it["InitialSyncFinishedAt"]),
+			InitialSyncError:      attrS(it["InitialSyncError"]),
+
+			WebhooksStatus: attrS(it["WebhooksStatus"]),
+			Webhooks:       webhookTopics(it["Webhooks"]),
 		})
 	}
 
 	return jsonResp(200, map[string]any{"items": items})
 }
 
+func webhookTopics(av types.AttributeValue) []shopify.WebhookTopicStatus {
+	var out []shopify.WebhookTopicStatus
+	if av != nil {
+		_ = attributevalue.Unmarshal(av, &out)
+	}
+	return out
+}
+
 func shopifyDisconnectShop(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	sub, _, err := userSub(req)
 	if err != nil {
@@ -372,62 +489,56 @@ func shopifyDisconnectShop(ctx context.Context, req events.APIGatewayV2HTTPReque
 	return jsonResp(200, map[string]any{"ok": true})
 }
 
-func shopifySyncStub(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	return shopifySyncReal(ctx, req)
-}
+// shopifyTestShop serves POST /integrations/shopify/shops/{shop}/test. Shop-side
+// failures are returned as 200 with ok=false and per-check reasons so the UI
+// can show what to fix.
+func shopifyTestShop(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
 
-type shopifyIntegrationItem struct {
-	PK             string `dynamodbav:"PK"`
-	SK             string `dynamodbav:"SK"`
-	Shop           string `dynamodbav:"Shop"`
-	AccessTokenEnc string `dynamodbav:"AccessTokenEnc"`
-	Scope          string `dynamodbav:"Scope"`
-	CreatedAt      string `dynamodbav:"CreatedAt"`
-	LastSyncAt     string `dynamodbav:"LastSyncAt,omitempty"`
-}
+	shop := req.PathParameters["shop"]
+	if shop == "" {
+		shop = strings.TrimSuffix(strings.TrimPrefix(req.RawPath, "/integrations/shopify/shops/"), "/test")
+	}
+	if u, err := url.PathUnescape(shop); err == nil {
+		shop = u
+	}
+	shop = strings.ToLower(strings.TrimSpace(shop))
+	if !isValidShopDomain(shop) {
+		return errResp(400, "invalid shop")
+	}
 
-type shopifyMoney struct {
-	Amount       string `json:"amount"`
-	CurrencyCode string `json:"currencyCode"`
-}
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
 
-type shopifyOrderNode struct {
-	Id            string `json:"id"`
-	Name          string `json:"name"`
-	ProcessedAt   string `json:"processedAt"`
-	UpdatedAt     string `json:"updatedAt"`
-	TotalPriceSet struct {
-		ShopMoney shopifyMoney `json:"shopMoney"`
-	} `json:"totalPriceSet"`
+	accessToken, _, err := shopify.LoadIntegrationAndDecryptToken(ctx, sub, shop)
+	if err != nil {
+		if strings.Contains(err.Error(), "shop not connected") {
+			return errResp(404, "shop not connected")
+		}
+		return errResp(500, err.Error())
+	}
 
-	Refunds shopifyRefunds `json:"refunds"`
-}
+	apiVersion := strings.TrimSpace(os.Getenv("SHOPIFY_API_VERSION"))
+	if apiVersion == "" {
+		apiVersion = "2026-01"
+	}
+	eventSourceArn := strings.TrimSpace(os.Getenv("SHOPIFY_EVENTBRIDGE_SOURCE_ARN"))
 
-type shopifyOrdersPage struct {
-	Orders struct {
-		Edges []struct {
-			Cursor string           `json:"cursor"`
-			Node   shopifyOrderNode `json:"node"`
-		} `json:"edges"`
-		PageInfo struct {
-			HasNextPage bool   `json:"hasNextPage"`
-			EndCursor   string `json:"endCursor"`
-		} `json:"pageInfo"`
-	} `json:"orders"`
-}
+	check := shopify.CheckConnection(ctx, shop, apiVersion, accessToken, eventSourceArn)
+	if err := shopify.RecordConnectionCheck(ctx, ddb, sub, check); err != nil {
+		return errResp(500, "failed to record check")
+	}
 
-type shopifyRefundNode struct {
-	Id               string `json:"id"`
-	CreatedAt        string `json:"createdAt"`
-	TotalRefundedSet struct {
-		ShopMoney shopifyMoney `json:"shopMoney"`
-	} `json:"totalRefundedSet"`
+	return jsonResp(200, check)
 }
 
-type shopifyRefunds struct {
-	Edges []struct {
-		Node shopifyRefundNode `json:"node"`
-	} `json:"edges"`
+func shopifySyncStub(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	return shopifySyncReal(ctx, req)
 }
 
 func shopifySyncReal(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
@@ -460,9 +571,6 @@ func shopifySyncReal(ctx context.Context, req events.APIGatewayV2HTTPRequest) (e
 		return errResp(500, "failed to init dynamodb")
 	}
 
-	pk := fmt.Sprintf("USER#%s", sub)
-	sk := fmt.Sprintf("SHOPIFY#%s", shopDomain)
-
 	accessToken, integ, err := shopify.LoadIntegrationAndDecryptToken(ctx, sub, shopDomain)
 	if err != nil {
 		return errResp(500, err.Error())
@@ -473,235 +581,39 @@ func shopifySyncReal(ctx context.Context, req events.APIGatewayV2HTTPRequest) (e
 		apiVersion = "2026-01"
 	}
 
-	// Build query: sync orders updated after LastSyncAt (or last 30 days if never synced)
-	// Shopify supports filtering in the orders query (query string)
+	// Sync orders updated after LastSyncAt (or last 30 days if never synced)
 	since := integ.LastSyncAt
 	if since == "" {
 		since = time.Now().UTC().Add(-30 * 24 * time.Hour).Format(time.RFC3339)
 	}
 
-	gqlQuery := `
-query OrdersSync($first: Int!, $after: String, $q: String!) {
-  orders(first: $first, after: $after, query: $q, sortKey: UPDATED_AT) {
-    edges {
-      cursor
-      node {
-        id
-        name
-        processedAt
-        updatedAt
-        totalPriceSet { shopMoney { amount currencyCode } }
-
-        refunds(first: 20) {
-          edges {
-            node {
-              id
-              createdAt
-              totalRefundedSet { shopMoney { amount currencyCode } }
-            }
-          }
-        }
-      }
-    }
-    pageInfo { hasNextPage endCursor }
-  }
-}`
-
-	q := fmt.Sprintf("updated_at:>=%s", since)
-
-	created := 0
-	skipped := 0
-	var endCursor *string = nil
-	var newestUpdatedAt string = since
-
-	for created+skipped < limit {
+	sync := shopify.NewOrdersSync(sub, shopDomain, apiVersion, accessToken, since)
+	for !sync.Done && sync.Created+sync.Skipped < limit {
 		first := 50
-		if limit-(created+skipped) < first {
-			first = limit - (created + skipped)
-		}
-
-		vars := map[string]any{
-			"first": first,
-			"after": endCursor,
-			"q":     q,
+		if limit-(sync.Created+sync.Skipped) < first {
+			first = limit - (sync.Created + sync.Skipped)
 		}
-
-		resp, status, err := shopify.PostGraphQL[shopifyOrdersPage](ctx, shopDomain, apiVersion, accessToken, gqlQuery, vars)
-		if err != nil {
-			return errResp(502, "shopify request failed")
-		}
-		if status < 200 || status >= 300 {
-			return errResp(502, fmt.Sprintf("shopify error status %d", status))
-		}
-		if len(resp.Errors) > 0 {
-			msgs := make([]string, 0, len(resp.Errors))
-			for _, e := range resp.Errors {
-				if e.Extensions.Code != "" {
-					msgs = append(msgs, e.Message+" ("+e.Extensions.Code+")")
-				} else {
-					msgs = append(msgs, e.Message)
-				}
-			}
-			return jsonResp(502, map[string]any{
-				"error":  "shopify graphql returned errors",
-				"errors": msgs,
-			})
-		}
-
-		edges := resp.Data.Orders.Edges
-		if len(edges) == 0 {
-			break
-		}
-
-		for _, e := range edges {
-			o := e.Node
-
-			// Track newest updatedAt to advance LastSyncAt
-			if o.UpdatedAt != "" && o.UpdatedAt > newestUpdatedAt {
-				newestUpdatedAt = o.UpdatedAt
-			}
-
-			// Parse amount
-			amt, err := strconv.ParseFloat(o.TotalPriceSet.ShopMoney.Amount, 64)
-			if err != nil {
-				skipped++
-				continue
-			}
-
-			// Use order processedAt as CreatedAt
-			createdAt := o.ProcessedAt
-			if createdAt == "" {
-				createdAt = time.Now().UTC().Format(time.RFC3339)
-			}
-
-			// Create deterministic transaction key (idempotent)
-			// Example: SHOPIFY#shop.myshopify.com#ORDER#<gid last segment>
-			orderId := o.Id
-			if i := strings.LastIndex(orderId, "/"); i >= 0 {
-				orderId = orderId[i+1:]
-			}
-
-			txPK := fmt.Sprintf("USER#%s", sub)
-			txSK := fmt.Sprintf("SHOPIFY#%s#ORDER#%s", shopDomain, orderId)
-
-			// Also set GSI1 so monthly summary works
-			tm, terr := time.Parse(time.RFC3339, createdAt)
-			if terr != nil {
-				tm = time.Now().UTC()
-			}
-			month := tm.UTC().Format("2006-01")
-
-			item := map[string]types.AttributeValue{
-				"PK":        &types.AttributeValueMemberS{Value: txPK},
-				"SK":        &types.AttributeValueMemberS{Value: txSK},
-				"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, month)},
-				"GSI1SK":    &types.AttributeValueMemberS{Value: tm.UTC().Format(time.RFC3339Nano)},
-				"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", amt)},
-				"Currency":  &types.AttributeValueMemberS{Value: o.TotalPriceSet.ShopMoney.CurrencyCode},
-				"Category":  &types.AttributeValueMemberS{Value: "Shopify Sales"},
-				"Note":      &types.AttributeValueMemberS{Value: fmt.Sprintf("%s (%s)", o.Name, shopDomain)},
-				"CreatedAt": &types.AttributeValueMemberS{Value: tm.UTC().Format(time.RFC3339)},
-				"Source":    &types.AttributeValueMemberS{Value: "shopify"},
-				"Shop":      &types.AttributeValueMemberS{Value: shopDomain},
-				"OrderGid":  &types.AttributeValueMemberS{Value: o.Id},
-				"OrderName": &types.AttributeValueMemberS{Value: o.Name},
-				"UpdatedAt": &types.AttributeValueMemberS{Value: o.UpdatedAt},
-			}
-
-			_, putErr := ddb.PutItem(ctx, &dynamodb.PutItemInput{
-				TableName:           aws.String(txTable),
-				Item:                item,
-				ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
-			})
-			if putErr != nil {
-				// If already exists, treat as idempotent skip
-				skipped++
-			} else {
-				created++
-			}
-
-			// Create refund transactions (negative amounts)
-			for _, re := range o.Refunds.Edges {
-				r := re.Node
-
-				refAmt, err := strconv.ParseFloat(r.TotalRefundedSet.ShopMoney.Amount, 64)
-				if err != nil || refAmt == 0 {
-					continue
-				}
-
-				refId := r.Id
-				if i := strings.LastIndex(refId, "/"); i >= 0 {
-					refId = refId[i+1:]
-				}
-
-				refTime, terr := time.Parse(time.RFC3339, r.CreatedAt)
-				if terr != nil {
-					refTime = time.Now().UTC()
-				}
-				refMonth := refTime.UTC().Format("2006-01")
-
-				refSK := fmt.Sprintf("SHOPIFY#%s#REFUND#%s", shopDomain, refId)
-
-				refItem := map[string]types.AttributeValue{
-					"PK":        &types.AttributeValueMemberS{Value: txPK},
-					"SK":        &types.AttributeValueMemberS{Value: refSK},
-					"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, refMonth)},
-					"GSI1SK":    &types.AttributeValueMemberS{Value: refTime.UTC().Format(time.RFC3339Nano)},
-					"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", -1*refAmt)},
-					"Currency":  &types.AttributeValueMemberS{Value: r.TotalRefundedSet.ShopMoney.CurrencyCode},
-					"Category":  &types.AttributeValueMemberS{Value: "Shopify Refunds"},
-					"Note":      &types.AttributeValueMemberS{Value: fmt.Sprintf("%s refund (%s)", o.Name, shopDomain)},
-					"CreatedAt": &types.AttributeValueMemberS{Value: refTime.UTC().Format(time.RFC3339)},
-					"Source":    &types.AttributeValueMemberS{Value: "shopify"},
-					"Shop":      &types.AttributeValueMemberS{Value: shopDomain},
-					"OrderGid":  &types.AttributeValueMemberS{Value: o.Id},
-					"OrderName": &types.AttributeValueMemberS{Value: o.Name},
-					"RefundGid": &types.AttributeValueMemberS{Value: r.Id},
-				}
-
-				_, putErr := ddb.PutItem(ctx, &dynamodb.PutItemInput{
-					TableName:           aws.String(txTable),
-					Item:                refItem,
-					ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+		if err := sync.NextPage(ctx, ddb, txTable, first); err != nil {
+			var gqlErr *shopify.GraphQLErrors
+			if errors.As(err, &gqlErr) {
+				return jsonResp(502, map[string]any{
+					"error":  "shopify graphql returned errors",
+					"errors": gqlErr.Messages,
 				})
-				if putErr != nil {
-					// already exists => ignore
-				} else {
-					created++
-				}
 			}
-
-			if created+skipped >= limit {
-				break
-			}
-		}
-
-		if !resp.Data.Orders.PageInfo.HasNextPage || resp.Data.Orders.PageInfo.EndCursor == "" {
-			break
+			return errResp(502, err.Error())
 		}
-		c := resp.Data.Orders.PageInfo.EndCursor
-		endCursor = &c
 	}
 
 	// Persist LastSyncAt per shop so next sync continues
-	_, _ = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(intTable),
-		Key: map[string]types.AttributeValue{
-			"PK": &types.AttributeValueMemberS{Value: pk},
-			"SK": &types.AttributeValueMemberS{Value: sk},
-		},
-		UpdateExpression: aws.String("SET LastSyncAt = :t"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":t": &types.AttributeValueMemberS{Value: newestUpdatedAt},
-		},
-	})
+	_ = sync.SaveProgress(ctx, ddb)
 
 	return jsonResp(200, map[string]any{
 		"ok":         true,
 		"shop":       shopDomain,
-		"created":    created,
-		"skipped":    skipped,
-		"lastSyncAt": newestUpdatedAt,
+		"created":    sync.Created,
+		"skipped":    sync.Skipped,
+		"lastSyncAt": sync.LastSyncAt,
 	})
 }
 
@@ -714,6 +626,14 @@ func attrS(av types.AttributeValue) string {
 	return ""
 }
 
+func attrInt(av types.AttributeValue) int {
+	if n, ok := av.(*types.AttributeValueMemberN); ok {
+		v, _ := strconv.Atoi(n.Value)
+		return v
+	}
+	return 0
+}
+
 func isValidShopDomain(shop string) bool {
 	if !strings.HasSuffix(shop, ".myshopify.com") {
 		return false
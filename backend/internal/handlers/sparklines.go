@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/fx"
+	"backend/internal/live"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Sparkline metrics, all computed from the live day buckets.
+var sparklineMetrics = map[string]func(gross, refunds, net float64, orders int) float64{
+	"gross":   func(g, r, n float64, o int) float64 { return g },
+	"refunds": func(g, r, n float64, o int) float64 { return r },
+	"net":     func(g, r, n float64, o int) float64 { return n },
+	"orders":  func(g, r, n float64, o int) float64 { return float64(o) },
+	"aov": func(g, r, n float64, o int) float64 {
+		if o == 0 {
+			return 0
+		}
+		return g / float64(o)
+	},
+	"refund_rate": func(g, r, n float64, o int) float64 {
+		if g == 0 {
+			return 0
+		}
+		return r / g * 100
+	},
+}
+
+var sparklineOrder = []string{"gross", "refunds", "net", "orders", "aov", "refund_rate"}
+
+const defaultSparklineDays = 30
+
+// SparklinesHandler serves GET /analytics/sparklines?metric=net,orders&days=30
+// [&shop=][&currency=]: one array per metric, one value per day oldest first,
+// ending today. Everything comes from one query over the live aggregates, so
+// a dashboard row of sparklines costs a single request. Each shop's day is
+// converted to the base currency (currency, else ETL_CURRENCY, else the
+// shops' own currency when they share one, else the FX pivot) before summing.
+func SparklinesHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RawPath != "/analytics/sparklines" {
+		return errResp(404, "not found")
+	}
+	if req.RequestContext.HTTP.Method != "GET" {
+		return errResp(405, "method not allowed")
+	}
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	q := req.QueryStringParameters
+
+	metrics := sparklineOrder
+	if v := strings.TrimSpace(q["metric"]); v != "" {
+		metrics = nil
+		for _, m := range strings.Split(v, ",") {
+			m = strings.ToLower(strings.TrimSpace(m))
+			if _, ok := sparklineMetrics[m]; !ok {
+				return errResp(400, "metric must be a comma-separated list of "+strings.Join(sparklineOrder, ", "))
+			}
+			metrics = append(metrics, m)
+		}
+	}
+
+	maxDays := int(live.DayRetention / (24 * time.Hour))
+	days := defaultSparklineDays
+	if v := strings.TrimSpace(q["days"]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDays {
+			return errResp(400, "days must be between 1 and "+strconv.Itoa(maxDays))
+		}
+		days = n
+	}
+	shop := strings.ToLower(strings.TrimSpace(q["shop"]))
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	to := time.Now().In(live.Location())
+	from := to.AddDate(0, 0, 1-days)
+	rows, err := live.ShopDays(ctx, client, sub, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return errResp(500, "failed to load live totals")
+	}
+	if shop != "" {
+		kept := rows[:0]
+		for _, r := range rows {
+			if r.Shop == shop {
+				kept = append(kept, r)
+			}
+		}
+		rows = kept
+	}
+
+	base := strings.ToUpper(strings.TrimSpace(q["currency"]))
+	if base == "" {
+		base = strings.ToUpper(strings.TrimSpace(os.Getenv("ETL_CURRENCY")))
+	}
+	if base == "" {
+		for _, r := range rows {
+			cur := strings.ToUpper(r.Currency)
+			if base == "" {
+				base = cur
+			} else if cur != "" && cur != base {
+				base = fx.PivotBase()
+				break
+			}
+		}
+	}
+	if base == "" {
+		base = fx.PivotBase()
+	}
+
+	type dayTotals struct {
+		gross, refunds, net float64
+		orders              int
+	}
+	index := map[string]int{}
+	for i := 0; i < days; i++ {
+		index[from.AddDate(0, 0, i).Format("2006-01-02")] = i
+	}
+	totals := make([]dayTotals, days)
+	conv := fx.NewConverter(client)
+	for _, r := range rows {
+		i, ok := index[r.Period]
+		if !ok {
+			continue
+		}
+		var amounts [3]float64
+		for j, v := range []float64{r.Gross, r.Refunds, r.Net} {
+			if amounts[j], err = conv.Convert(ctx, v, r.Currency, base, r.Period); err != nil {
+				return errResp(500, "failed to convert "+r.Currency+" to "+base)
+			}
+		}
+		totals[i].gross += amounts[0]
+		totals[i].refunds += amounts[1]
+		totals[i].net += amounts[2]
+		totals[i].orders += r.Orders
+	}
+
+	series := map[string][]float64{}
+	for _, m := range metrics {
+		f := sparklineMetrics[m]
+		vals := make([]float64, days)
+		for i, t := range totals {
+			vals[i] = math.Round(f(t.gross, t.refunds, t.net, t.orders)*100) / 100
+		}
+		series[m] = vals
+	}
+
+	return jsonResp(200, map[string]any{
+		"from":     from.Format("2006-01-02"),
+		"to":       to.Format("2006-01-02"),
+		"timezone": live.Location().String(),
+		"currency": base,
+		"shop":     shop,
+		"series":   series,
+	})
+}
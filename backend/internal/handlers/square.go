@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/square"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func SquareHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RawPath {
+	case "/integrations/square/connect":
+		return squareConnect(ctx, req)
+	case "/integrations/square/callback":
+		return squareCallback(ctx, req)
+	case "/integrations/square/merchants":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return squareListMerchants(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/integrations/square/sync":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return squareSync(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/webhooks/square":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return squareWebhook(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func squareRedirectURI() (string, error) {
+	base, err := getApiBaseUrl()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base, "/") + "/integrations/square/callback", nil
+}
+
+func squareConnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	if strings.TrimSpace(os.Getenv("SQUARE_APP_ID")) == "" {
+		return errResp(500, "SQUARE_APP_ID not set")
+	}
+
+	state, err := randomState(24)
+	if err != nil {
+		return errResp(500, "failed to generate state")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	if strings.TrimSpace(stateTable) == "" {
+		return errResp(500, "OAUTH_STATE_TABLE not set")
+	}
+
+	exp := time.Now().UTC().Add(10 * time.Minute).Unix()
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(stateTable),
+		Item: map[string]types.AttributeValue{
+			"State":          &types.AttributeValueMemberS{Value: state},
+			"UserSub":        &types.AttributeValueMemberS{Value: sub},
+			"Provider":       &types.AttributeValueMemberS{Value: square.Source},
+			"ExpiresAtEpoch": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
+		},
+	})
+	if err != nil {
+		return errResp(500, "failed to store oauth state")
+	}
+
+	redirectURI, err := squareRedirectURI()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+
+	return jsonResp(200, map[string]any{
+		"authorizeUrl": square.AuthorizeURL(state, redirectURI),
+	})
+}
+
+func squareCallback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	params := req.QueryStringParameters
+	fe := strings.TrimRight(os.Getenv("FRONTEND_BASE_URL"), "/")
+	if fe == "" {
+		fe = "/"
+	}
+
+	// User declined consent
+	if params["error"] != "" {
+		return events.APIGatewayV2HTTPResponse{
+			StatusCode: 302,
+			Headers:    map[string]string{"location": fe + "/square?connected=0"},
+		}, nil
+	}
+
+	state := strings.TrimSpace(params["state"])
+	code := strings.TrimSpace(params["code"])
+	if state == "" || code == "" {
+		return errResp(400, "missing required oauth params")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return errResp(400, "invalid or expired state")
+	}
+	sub := attrS(out.Item["UserSub"])
+	if sub == "" || attrS(out.Item["Provider"]) != square.Source {
+		return errResp(400, "state mismatch")
+	}
+
+	redirectURI, err := squareRedirectURI()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+	tok, err := square.ExchangeCode(ctx, code, redirectURI)
+	if err != nil || tok.MerchantId == "" {
+		return errResp(502, "token exchange failed")
+	}
+
+	if err := square.SaveIntegration(ctx, ddb, sub, tok); err != nil {
+		return errResp(500, "failed to store integration")
+	}
+
+	_, _ = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"location": fe + "/square?connected=1&merchant=" + url.QueryEscape(tok.MerchantId),
+		},
+	}, nil
+}
+
+func squareListMerchants(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integs, err := square.ListIntegrations(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	type MerchantItem struct {
+		MerchantId string `json:"merchantId"`
+		CreatedAt  string `json:"createdAt"`
+		LastSyncAt string `json:"lastSyncAt"`
+	}
+	items := make([]MerchantItem, 0, len(integs))
+	for _, it := range integs {
+		items = append(items, MerchantItem{MerchantId: it.MerchantId, CreatedAt: it.CreatedAt, LastSyncAt: it.LastSyncAt})
+	}
+	return jsonResp(200, map[string]any{"items": items})
+}
+
+// squareSync runs an on-demand sync for the caller's merchants (optionally ?merchant=<id>).
+func squareSync(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	only := strings.TrimSpace(req.QueryStringParameters["merchant"])
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integs, err := square.ListIntegrations(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	results := make([]any, 0, len(integs))
+	for _, it := range integs {
+		if only != "" && it.MerchantId != only {
+			continue
+		}
+		res, err := square.SyncMerchant(ctx, ddb, it)
+		if err != nil {
+			results = append(results, map[string]any{"merchantId": it.MerchantId, "error": err.Error()})
+			continue
+		}
+		results = append(results, res)
+	}
+
+	return jsonResp(200, map[string]any{"ok": true, "results": results})
+}
+
+type squareWebhookEvent struct {
+	MerchantId string `json:"merchant_id"`
+	Type       string `json:"type"`
+	EventId    string `json:"event_id"`
+	Data       struct {
+		Object struct {
+			Payment *square.Payment `json:"payment"`
+			Refund  *square.Refund  `json:"refund"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// squareWebhook handles payment.* and refund.* notifications. Writes are
+// UpdatedAt-conditional, so Square's retries and out-of-order deliveries are safe.
+func squareWebhook(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return errResp(400, "invalid body")
+		}
+		body = b
+	}
+
+	notificationURL := strings.TrimSpace(os.Getenv("SQUARE_WEBHOOK_URL"))
+	if notificationURL == "" {
+		base, err := getApiBaseUrl()
+		if err != nil {
+			return errResp(500, "failed to get API base URL")
+		}
+		notificationURL = strings.TrimRight(base, "/") + "/webhooks/square"
+	}
+	sig := req.Headers["x-square-hmacsha256-signature"]
+	if !square.VerifyWebhookSignature(os.Getenv("SQUARE_WEBHOOK_SIGNATURE_KEY"), notificationURL, body, sig) {
+		return errResp(401, "invalid signature")
+	}
+
+	var ev squareWebhookEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return errResp(400, "invalid json")
+	}
+	if ev.MerchantId == "" {
+		return jsonResp(200, map[string]any{"ok": true, "ignored": true})
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	subs, err := square.UsersForMerchant(ctx, ddb, ev.MerchantId)
+	if err != nil {
+		return errResp(500, "merchant lookup failed")
+	}
+
+	txTable := db.TransactionsTableName()
+	written := 0
+	for _, sub := range subs {
+		switch {
+		case strings.HasPrefix(ev.Type, "payment.") && ev.Data.Object.Payment != nil:
+			n, fees, err := square.WritePayment(ctx, ddb, txTable, sub, ev.MerchantId, *ev.Data.Object.Payment)
+			if err != nil {
+				// non-2xx makes Square retry the delivery
+				return errResp(500, "failed to write payment")
+			}
+			written += n + fees
+		case strings.HasPrefix(ev.Type, "refund.") && ev.Data.Object.Refund != nil:
+			wrote, err := square.WriteRefund(ctx, ddb, txTable, sub, ev.MerchantId, *ev.Data.Object.Refund)
+			if err != nil {
+				return errResp(500, "failed to write refund")
+			}
+			if wrote {
+				written++
+			}
+		}
+	}
+
+	return jsonResp(200, map[string]any{"ok": true, "written": written})
+}
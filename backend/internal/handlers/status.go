@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/ops"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// StatusHandler serves the public GET /status used by the frontend banner.
+func StatusHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RequestContext.HTTP.Method != "GET" {
+		return errResp(405, "method not allowed")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	now := time.Now().UTC()
+	subs, err := ops.LoadStatus(ctx, ddb, now)
+	if err != nil {
+		return errResp(500, "failed to load status")
+	}
+
+	resp, _ := jsonResp(200, map[string]any{
+		"status":     ops.Overall(subs),
+		"subsystems": subs,
+		"checkedAt":  now.Format(time.RFC3339),
+	})
+	resp.Headers["cache-control"] = "public, max-age=60"
+	return resp, nil
+}
@@ -6,9 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
+	"time"
 
 	"backend/internal/db"
+	"backend/internal/fx"
+	"backend/internal/periods"
+	"backend/internal/rollup"
+	"backend/internal/users"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -25,8 +31,39 @@ type MonthlySummary struct {
 	Net        float64            `json:"net"`
 	ByCategory map[string]float64 `json:"byCategory"`
 	Count      int                `json:"count"`
+
+	// Set for ?period= requests: the resolved inclusive date range.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// Set when ?asOf= asked for the month as it was known at that time.
+	AsOf     string        `json:"asOf,omitempty"`
+	Restated []Restatement `json:"restated,omitempty"`
+
+	// Set when the month mixes currencies: each currency's own totals, with
+	// the totals above converted to Currency (the user's base currency) at
+	// FxDate's rates. Without rates those stay zero and FxError says why.
+	ByCurrency []CurrencySummary `json:"byCurrency,omitempty"`
+	FxDate     string            `json:"fxDate,omitempty"`
+	FxError    string            `json:"fxError,omitempty"`
 }
 
+// CurrencySummary is one currency's part of a mixed-currency summary.
+type CurrencySummary struct {
+	Currency   string             `json:"currency"`
+	Income     float64            `json:"income"`
+	Expense    float64            `json:"expense"`
+	Net        float64            `json:"net"`
+	ByCategory map[string]float64 `json:"byCategory"`
+	Count      int                `json:"count"`
+}
+
+// SummaryMonthly serves GET /summary/monthly?month=YYYY-MM, or ?period=<spec>
+// (this_quarter, FY2026-P03, ... resolved with the user's fiscal calendar).
+// ?asOf= rebuilds the numbers as they were known at that time. A plain month
+// covered by the rollup table is a single read of its running totals. A
+// month in more than one currency is summed per currency and converted to
+// the user's base currency (see convertMonthly).
 func SummaryMonthly(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	sub, _, err := userSub(req)
 	if err != nil {
@@ -34,7 +71,8 @@ func SummaryMonthly(ctx context.Context, req events.APIGatewayV2HTTPRequest) (ev
 	}
 
 	month := strings.TrimSpace(req.QueryStringParameters["month"])
-	if month == "" || len(month) != 7 || month[4] != '-' {
+	spec := strings.TrimSpace(req.QueryStringParameters["period"])
+	if spec == "" && (month == "" || len(month) != 7 || month[4] != '-') {
 		return errResp(400, "month is required in format YYYY-MM")
 	}
 
@@ -48,66 +86,277 @@ func SummaryMonthly(ctx context.Context, req events.APIGatewayV2HTTPRequest) (ev
 		return errResp(500, "failed to init dynamodb")
 	}
 
-	gsiPk := fmt.Sprintf("USER#%s#MONTH#%s", sub, month)
-
-	out, err := client.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(table),
-		IndexName:              aws.String("GSI1"),
-		KeyConditionExpression: aws.String("GSI1PK = :pk"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk": &types.AttributeValueMemberS{Value: gsiPk},
-		},
-		Limit: aws.Int32(500),
-	})
-	if err != nil {
-		return errResp(500, "query failed")
+	var (
+		items    []Transaction
+		asOf     string
+		asOfAt   time.Time
+		restated []Restatement
+		period   *periods.Period
+	)
+	if v := strings.TrimSpace(req.QueryStringParameters["asOf"]); v != "" {
+		at, ok := parseAsOf(v)
+		if !ok {
+			return errResp(400, "asOf must be YYYY-MM-DD or RFC3339")
+		}
+		asOf, asOfAt = at.Format(time.RFC3339), at
 	}
 
-	var items []Transaction
-	if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
-		return errResp(500, "unmarshal failed")
-	}
+	switch {
+	case spec != "":
+		settings, err := users.GetSettings(ctx, client, sub)
+		if err != nil {
+			return errResp(500, "failed to load settings")
+		}
+		p, err := settings.Calendar.Resolve(spec, time.Now().UTC())
+		if err != nil {
+			return errResp(400, err.Error())
+		}
+		if len(p.Months()) > 13 {
+			return errResp(400, "period must span at most 13 months")
+		}
+		period, month = &p, p.Name
 
-	if len(items) == 0 {
-		return jsonResp(200, MonthlySummary{
-			Month:      month,
-			Currency:   "USD",
-			Income:     0,
-			Expense:    0,
-			Net:        0,
-			ByCategory: map[string]float64{},
-			Count:      0,
+		for _, m := range p.Months() {
+			var page []Transaction
+			if asOf != "" {
+				var r []Restatement
+				page, r, err = monthTransactionsAsOf(ctx, client, table, sub, m, asOfAt)
+				restated = append(restated, r...)
+			} else {
+				page, err = queryMonthTransactions(ctx, client, table, sub, m)
+			}
+			if err != nil {
+				return errResp(500, "query failed")
+			}
+			for _, t := range page {
+				if at, err := time.Parse(time.RFC3339, t.CreatedAt); err == nil && !p.Contains(at) {
+					continue
+				}
+				items = append(items, t)
+			}
+		}
+	case asOf != "":
+		items, restated, err = monthTransactionsAsOf(ctx, client, table, sub, month, asOfAt)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+	case rollup.Covers(month):
+		totals, err := rollup.Month(ctx, client, sub, month)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		return monthlyFromRollup(ctx, client, sub, month, totals)
+	default:
+		gsiPk := fmt.Sprintf("USER#%s#MONTH#%s", sub, month)
+
+		out, err := client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			IndexName:              aws.String("GSI1"),
+			KeyConditionExpression: aws.String("GSI1PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: gsiPk},
+			},
+			Limit: aws.Int32(500),
 		})
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
+			return errResp(500, "unmarshal failed")
+		}
+		items = countable(items)
 	}
 
-	// For simplicity assume all same currency; production: group by currency
-	currency := items[0].Currency
 	sum := MonthlySummary{
 		Month:      month,
-		Currency:   currency,
+		Currency:   "USD",
 		ByCategory: map[string]float64{},
-		Count:      len(items),
+		AsOf:       asOf,
+		Restated:   restated,
 	}
+	if period != nil {
+		sum.From, sum.To = period.FromISO(), period.ToISO()
+	}
+
+	return finishMonthly(ctx, client, sub, sum, sumByCurrency(items))
+}
 
+// sumByCurrency totals countable transactions per currency.
+func sumByCurrency(items []Transaction) map[string]*CurrencySummary {
+	byCur := map[string]*CurrencySummary{}
 	for _, t := range items {
-		if t.Currency != currency {
-			// keep it simple for now
-			return errResp(400, "multiple currencies in month not supported yet")
+		c := byCur[t.Currency]
+		if c == nil {
+			c = &CurrencySummary{Currency: t.Currency, ByCategory: map[string]float64{}}
+			byCur[t.Currency] = c
 		}
 		if t.Amount >= 0 {
-			sum.Income += t.Amount
+			c.Income += t.Amount
 		} else {
-			sum.Expense += math.Abs(t.Amount)
+			c.Expense += math.Abs(t.Amount)
 		}
-		sum.ByCategory[t.Category] += t.Amount
+		// net contribution per category: income positive, expense negative
+		c.ByCategory[t.Category] += t.Amount
+		c.Count++
 	}
+	return byCur
+}
 
-	sum.Net = sum.Income - sum.Expense
+// rollupByCurrency is sumByCurrency for a rollup bucket.
+func rollupByCurrency(totals map[string]*rollup.Totals) map[string]*CurrencySummary {
+	byCur := map[string]*CurrencySummary{}
+	for cur, t := range totals {
+		byCur[cur] = &CurrencySummary{Currency: cur, Income: t.Income, Expense: t.Expense, ByCategory: t.ByCategory, Count: t.Count}
+	}
+	return byCur
+}
 
-	// normalize ByCategory: show net contribution per category
-	// (income positive, expense negative) already handled by Amount
+// monthlyFromRollup answers a plain ?month= request from the running
+// totals, with the same shape and currency rules as the query path.
+func monthlyFromRollup(ctx context.Context, client *dynamodb.Client, sub, month string, totals map[string]*rollup.Totals) (events.APIGatewayV2HTTPResponse, error) {
+	sum := MonthlySummary{Month: month, Currency: "USD", ByCategory: map[string]float64{}}
+	return finishMonthly(ctx, client, sub, sum, rollupByCurrency(totals))
+}
+
+// finishMonthly responds with sum filled in by totalMonthly.
+func finishMonthly(ctx context.Context, client *dynamodb.Client, sub string, sum MonthlySummary, byCur map[string]*CurrencySummary) (events.APIGatewayV2HTTPResponse, error) {
+	if err := totalMonthly(ctx, client, sub, &sum, byCur); err != nil {
+		return errResp(500, "failed to load settings")
+	}
 	return jsonResp(200, sum)
 }
 
+// totalMonthly fills sum from its per-currency totals: a single currency
+// is reported as is, several are converted by convertMonthly.
+func totalMonthly(ctx context.Context, client *dynamodb.Client, sub string, sum *MonthlySummary, byCur map[string]*CurrencySummary) error {
+	for _, c := range byCur {
+		c.Net = c.Income - c.Expense
+		sum.Count += c.Count
+	}
+	if len(byCur) == 1 {
+		for cur, c := range byCur {
+			sum.Currency = cur
+			sum.Income, sum.Expense, sum.Net = c.Income, c.Expense, c.Net
+			sum.ByCategory = c.ByCategory
+		}
+		return nil
+	}
+	if len(byCur) > 1 {
+		return convertMonthly(ctx, client, sub, sum, byCur)
+	}
+	return nil
+}
+
+// convertMonthly lists each currency in sum.ByCurrency and converts their
+// totals into the user's base currency. Every currency converts at the
+// rates of the period's last day (today for a running one), so the same
+// month always converts the same way; sum.FxError is set when a rate is
+// missing.
+func convertMonthly(ctx context.Context, client *dynamodb.Client, sub string, sum *MonthlySummary, byCur map[string]*CurrencySummary) error {
+	settings, err := users.GetSettings(ctx, client, sub)
+	if err != nil {
+		return err
+	}
+	sum.Currency = settings.ReportingCurrency()
+
+	last := sum.To
+	if last == "" {
+		if m, err := time.Parse("2006-01", sum.Month); err == nil {
+			last = m.AddDate(0, 1, -1).Format("2006-01-02")
+		}
+	}
+	if today := time.Now().UTC().Format("2006-01-02"); last == "" || last > today {
+		last = today
+	}
+	sum.FxDate = last
+
+	curs := make([]string, 0, len(byCur))
+	for cur := range byCur {
+		curs = append(curs, cur)
+	}
+	sort.Strings(curs)
+
+	conv := fx.NewConverter(client)
+	var income, expense float64
+	byCategory := map[string]float64{}
+	for _, cur := range curs {
+		c := byCur[cur]
+		sum.ByCurrency = append(sum.ByCurrency, *c)
+		if sum.FxError != "" {
+			continue
+		}
+		convert := func(v float64) float64 {
+			out, err := conv.Convert(ctx, v, cur, sum.Currency, last)
+			if err != nil && sum.FxError == "" {
+				sum.FxError = fmt.Sprintf("cannot convert %s to %s: %v", cur, sum.Currency, err)
+			}
+			return out
+		}
+		income += convert(c.Income)
+		expense += convert(c.Expense)
+		for cat, v := range c.ByCategory {
+			byCategory[cat] += convert(v)
+		}
+	}
+	if sum.FxError != "" {
+		return nil
+	}
+	r2 := func(v float64) float64 { return math.Round(v*100) / 100 }
+	sum.Income, sum.Expense = r2(income), r2(expense)
+	sum.Net = r2(income - expense)
+	for cat, v := range byCategory {
+		sum.ByCategory[cat] = r2(v)
+	}
+	return nil
+}
+
+// queryMonthTransactions loads the transactions of a user's GSI1 month that
+// count toward totals: a split transaction is represented by its allocations.
+func queryMonthTransactions(ctx context.Context, client *dynamodb.Client, table, sub, month string) ([]Transaction, error) {
+	items, err := queryMonthItems(ctx, client, table, sub, month)
+	if err != nil {
+		return nil, err
+	}
+	return countable(items), nil
+}
+
+// queryMonthItems loads every item in a user's GSI1 month partition,
+// following LastEvaluatedKey, split parents included.
+func queryMonthItems(ctx context.Context, client *dynamodb.Client, table, sub, month string) ([]Transaction, error) {
+	gsiPk := fmt.Sprintf("USER#%s#MONTH#%s", sub, month)
+
+	var (
+		items    []Transaction
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			IndexName:              aws.String("GSI1"),
+			KeyConditionExpression: aws.String("GSI1PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: gsiPk},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var page []Transaction
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return items, nil
+}
+
 var _ = errors.New // keep linter happy if needed
 var _ = json.Marshal
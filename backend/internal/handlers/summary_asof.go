@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"backend/internal/restate"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Restatement explains one transaction whose amount for the month changed
+// after asOf (late refunds, order edits, re-pulled ad spend). AmountAsOf is 0
+// when the row did not exist yet; AmountNow is 0 when it has since moved out
+// of the month.
+type Restatement struct {
+	Id         string  `json:"id"`
+	Category   string  `json:"category"`
+	AmountAsOf float64 `json:"amountAsOf"`
+	AmountNow  float64 `json:"amountNow"`
+	ChangedAt  string  `json:"changedAt"`
+}
+
+// maxRestatements bounds the explanation list in one response.
+const maxRestatements = 200
+
+// parseAsOf accepts YYYY-MM-DD (meaning the end of that UTC day) or RFC3339.
+func parseAsOf(v string) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t.Add(24*time.Hour - time.Second), true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// txVersion is one version of a transaction in the month; replacedAt is zero
+// for the current version.
+type txVersion struct {
+	tx         Transaction
+	recordedAt time.Time
+	replacedAt time.Time
+}
+
+// knownAt is when we learned about a version. Rows written before
+// RecordedAt existed fall back to their business date.
+func knownAt(recordedAt, createdAt string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, recordedAt); err == nil {
+		return t
+	}
+	t, _ := time.Parse(time.RFC3339, createdAt)
+	return t
+}
+
+// monthTransactionsAsOf rebuilds the month's transactions as they were stored
+// at asOf from the current rows plus their restatement history, and lists the
+// rows that changed since.
+func monthTransactionsAsOf(ctx context.Context, client *dynamodb.Client, table, sub, month string, asOf time.Time) ([]Transaction, []Restatement, error) {
+	current, err := queryMonthItems(ctx, client, table, sub, month)
+	if err != nil {
+		return nil, nil, err
+	}
+	history, err := restate.LoadMonth(ctx, client, table, sub, month)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	versions := map[string][]txVersion{}
+	now := map[string]Transaction{}
+	for _, t := range current {
+		if t.DeletedAt != "" {
+			// The delete kept the version that counted until then.
+			continue
+		}
+		v := txVersion{tx: t, recordedAt: knownAt(t.RecordedAt, t.CreatedAt)}
+		if t.SplitInto > 0 {
+			// A split parent stopped counting when its allocations were
+			// recorded; before that it counts as a whole.
+			v.replacedAt, _ = time.Parse(time.RFC3339Nano, t.SplitAt)
+			v.tx.SplitInto = 0
+			versions[t.SK] = append(versions[t.SK], v)
+			continue
+		}
+		versions[t.SK] = append(versions[t.SK], v)
+		now[t.SK] = t
+	}
+	for _, h := range history {
+		replacedAt, err := time.Parse(time.RFC3339Nano, h.ReplacedAt)
+		if err != nil {
+			continue
+		}
+		versions[h.TxSK] = append(versions[h.TxSK], txVersion{
+			tx: Transaction{
+				SK:        h.TxSK,
+				Amount:    h.PrevAmount,
+				Currency:  h.PrevCurrency,
+				Category:  h.PrevCategory,
+				CreatedAt: h.PrevCreatedAt,
+			},
+			recordedAt: knownAt(h.PrevRecordedAt, h.PrevCreatedAt),
+			replacedAt: replacedAt,
+		})
+	}
+
+	var (
+		items    []Transaction
+		restated []Restatement
+	)
+	for sk, vs := range versions {
+		// The version in force at asOf is the earliest one not yet replaced,
+		// provided it had been recorded by then.
+		var in *txVersion
+		for i := range vs {
+			v := &vs[i]
+			if !v.replacedAt.IsZero() && !v.replacedAt.After(asOf) {
+				continue
+			}
+			if in == nil || (!v.replacedAt.IsZero() && (in.replacedAt.IsZero() || v.replacedAt.Before(in.replacedAt))) {
+				in = v
+			}
+		}
+		if in != nil && in.recordedAt.After(asOf) {
+			in = nil
+		}
+
+		var then Restatement
+		then.Id = sk
+		if in != nil {
+			items = append(items, in.tx)
+			then.AmountAsOf = in.tx.Amount
+			then.Category = in.tx.Category
+		}
+		cur, stillHere := now[sk]
+		if stillHere {
+			then.AmountNow = cur.Amount
+			then.Category = cur.Category
+		}
+		if then.AmountAsOf == then.AmountNow && (in != nil) == stillHere {
+			continue
+		}
+		last := time.Time{}
+		for _, v := range vs {
+			if v.replacedAt.After(last) {
+				last = v.replacedAt
+			}
+			if v.replacedAt.IsZero() && v.recordedAt.After(last) {
+				last = v.recordedAt
+			}
+		}
+		then.ChangedAt = last.Format(time.RFC3339)
+		restated = append(restated, then)
+	}
+
+	sort.Slice(restated, func(i, j int) bool { return restated[i].ChangedAt > restated[j].ChangedAt })
+	if len(restated) > maxRestatements {
+		restated = restated[:maxRestatements]
+	}
+	return items, restated, nil
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxDailySummaryDays bounds a ?from=&to= request.
+const maxDailySummaryDays = 92
+
+type DailySummary struct {
+	Date       string             `json:"date,omitempty"` // empty for a range total
+	Currency   string             `json:"currency"`
+	Income     float64            `json:"income"`
+	Expense    float64            `json:"expense"`
+	Net        float64            `json:"net"`
+	ByCategory map[string]float64 `json:"byCategory"`
+	Count      int                `json:"count"`
+}
+
+// summaryDaily serves GET /summary/daily?date=YYYY-MM-DD, or ?from=&to= for
+// one summary per day plus the range total. Days are UTC, like the month
+// partitions; each month is read with a GSI1SK range rather than whole.
+func summaryDaily(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	q := req.QueryStringParameters
+
+	fromS, toS := strings.TrimSpace(q["from"]), strings.TrimSpace(q["to"])
+	single := strings.TrimSpace(q["date"])
+	if single != "" {
+		fromS, toS = single, single
+	}
+	if fromS == "" || toS == "" {
+		return errResp(400, "date, or from and to, are required in format YYYY-MM-DD")
+	}
+	from, err := time.Parse("2006-01-02", fromS)
+	if err != nil {
+		return errResp(400, "from must be in format YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", toS)
+	if err != nil {
+		return errResp(400, "to must be in format YYYY-MM-DD")
+	}
+	if from.After(to) || int(to.Sub(from).Hours()/24) >= maxDailySummaryDays {
+		return errResp(400, fmt.Sprintf("from/to must span at most %d days", maxDailySummaryDays))
+	}
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	var days []DailySummary
+	byDate := map[string]*DailySummary{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		days = append(days, DailySummary{Date: d.Format("2006-01-02"), ByCategory: map[string]float64{}})
+	}
+	for i := range days {
+		byDate[days[i].Date] = &days[i]
+	}
+	total := DailySummary{ByCategory: map[string]float64{}}
+
+	for month := from.Format("2006-01"); month <= to.Format("2006-01"); month = nextMonth(month) {
+		items, err := queryMonthRange(ctx, client, table, sub, month, from.Format("2006-01-02"), to.Format("2006-01-02"))
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		for _, t := range items {
+			if len(t.GSI1SK) < 10 {
+				continue
+			}
+			day := byDate[t.GSI1SK[:10]]
+			if day == nil {
+				continue
+			}
+			for _, s := range []*DailySummary{day, &total} {
+				if s.Currency == "" {
+					s.Currency = t.Currency
+				} else if t.Currency != s.Currency {
+					return errResp(400, "multiple currencies in range not supported yet")
+				}
+				addToSummary(s, t)
+			}
+		}
+	}
+	for i := range days {
+		days[i].Net = days[i].Income - days[i].Expense
+	}
+	total.Net = total.Income - total.Expense
+
+	if single != "" {
+		return jsonResp(200, days[0])
+	}
+	return jsonResp(200, map[string]any{
+		"from":  fromS,
+		"to":    toS,
+		"days":  days,
+		"total": total,
+	})
+}
+
+func addToSummary(s *DailySummary, t Transaction) {
+	if t.Amount >= 0 {
+		s.Income += t.Amount
+	} else {
+		s.Expense += math.Abs(t.Amount)
+	}
+	s.ByCategory[t.Category] += t.Amount
+	s.Count++
+}
+
+func nextMonth(month string) string {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return "9999-12"
+	}
+	return t.AddDate(0, 1, 0).Format("2006-01")
+}
+
+// queryMonthRange loads the countable transactions of a user's GSI1 month
+// whose GSI1SK falls on a day in [from, to].
+func queryMonthRange(ctx context.Context, client *dynamodb.Client, table, sub, month, from, to string) ([]Transaction, error) {
+	items, err := queryMonthRangeAll(ctx, client, table, sub, month, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return countable(items), nil
+}
+
+// queryMonthRangeAll is queryMonthRange including split parents, their
+// allocations and deleted rows.
+func queryMonthRangeAll(ctx context.Context, client *dynamodb.Client, table, sub, month, from, to string) ([]Transaction, error) {
+	var (
+		items    []Transaction
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			IndexName:              aws.String("GSI1"),
+			KeyConditionExpression: aws.String("GSI1PK = :pk AND GSI1SK BETWEEN :from AND :to"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":   &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, month)},
+				":from": &types.AttributeValueMemberS{Value: from},
+				":to":   &types.AttributeValueMemberS{Value: to + "~"}, // sorts after any timestamp that day
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var page []Transaction
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/live"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func SummaryHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RequestContext.HTTP.Method != "GET" {
+		return errResp(405, "method not allowed")
+	}
+	switch req.RawPath {
+	case "/summary/monthly":
+		return SummaryMonthly(ctx, req)
+	case "/summary/daily":
+		return summaryDaily(ctx, req)
+	case "/summary/range":
+		return summaryRange(ctx, req)
+	case "/summary/orders":
+		return summaryOrders(ctx, req)
+	case "/summary/roas":
+		return summaryROAS(ctx, req)
+	case "/summary/vat":
+		return summaryVAT(ctx, req)
+	case "/summary/live":
+		return summaryLive(ctx, req)
+	case "/summary/tags":
+		return summaryTags(ctx, req)
+	case "/summary/promos":
+		return summaryPromos(ctx, req)
+	case "/dashboard":
+		return dashboard(ctx, req)
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+// summaryLive serves GET /summary/live[?shop=] with today and month-to-date
+// totals kept current by the order/refund webhook workers.
+func summaryLive(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	shop := strings.ToLower(strings.TrimSpace(req.QueryStringParameters["shop"]))
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	today, mtd, err := live.TodayAndMTD(ctx, client, sub, shop, time.Now())
+	if err != nil {
+		return errResp(500, "failed to load live totals")
+	}
+
+	return jsonResp(200, map[string]any{
+		"shop":     shop,
+		"timezone": live.Location().String(),
+		"today":    today,
+		"mtd":      mtd,
+	})
+}
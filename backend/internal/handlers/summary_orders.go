@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Sales channels write one row per order and one per refund, and the SK says
+// which: SHOPIFY#<shop>#ORDER#<id>, SQUARE#<merchant>#PAYMENT#<id>,
+// RECHARGE#<store>#CHARGE#<id> (or a Shopify order SK), AMAZON#...#ORDER#,
+// and <channel>#...#REFUND#<id> for refunds.
+var (
+	orderSKMarkers  = []string{"#ORDER#", "#PAYMENT#", "#CHARGE#"}
+	refundSKMarkers = []string{"#REFUND#"}
+)
+
+func skHasMarker(sk string, markers []string) bool {
+	for _, m := range markers {
+		if strings.Contains(sk, m) {
+			return true
+		}
+	}
+	return false
+}
+
+type OrderKPIs struct {
+	From           string  `json:"from"`
+	To             string  `json:"to"`
+	Shop           string  `json:"shop,omitempty"`
+	Currency       string  `json:"currency"`
+	Orders         int     `json:"orders"`
+	OrderValue     float64 `json:"orderValue"`
+	AOV            float64 `json:"aov"`
+	Refunds        int     `json:"refunds"`
+	RefundedAmount float64 `json:"refundedAmount"`
+	RefundRate     float64 `json:"refundRate"`    // refunds per 100 orders
+	RefundedShare  float64 `json:"refundedShare"` // % of order value refunded
+}
+
+// summaryOrders serves GET /summary/orders?from=YYYY-MM-DD&to=YYYY-MM-DD
+// [&shop=]: order count, average order value, refund count and refund rate
+// over an inclusive UTC range. Order and refund rows are told apart by SK,
+// and a split order still counts once, by its original row. Refunds are
+// counted on the day they happened, not against their order's day.
+func summaryOrders(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	q := req.QueryStringParameters
+
+	fromS, toS := strings.TrimSpace(q["from"]), strings.TrimSpace(q["to"])
+	if fromS == "" || toS == "" {
+		return errResp(400, "from and to are required in format YYYY-MM-DD")
+	}
+	from, err := time.Parse("2006-01-02", fromS)
+	if err != nil {
+		return errResp(400, "from must be in format YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", toS)
+	if err != nil {
+		return errResp(400, "to must be in format YYYY-MM-DD")
+	}
+	if from.After(to) || int(to.Sub(from).Hours()/24) >= maxRangeSummaryDays {
+		return errResp(400, fmt.Sprintf("from/to must span at most %d days", maxRangeSummaryDays))
+	}
+	shop := strings.ToLower(strings.TrimSpace(q["shop"]))
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	k := OrderKPIs{From: fromS, To: toS, Shop: shop}
+	for month := from.Format("2006-01"); month <= to.Format("2006-01"); month = nextMonth(month) {
+		items, err := queryMonthRangeAll(ctx, client, table, sub, month, fromS, toS)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		for _, t := range items {
+			if t.ParentId != "" || t.DeletedAt != "" || (shop != "" && t.Shop != shop) {
+				continue
+			}
+			isOrder := skHasMarker(t.SK, orderSKMarkers)
+			isRefund := skHasMarker(t.SK, refundSKMarkers)
+			if !isOrder && !isRefund {
+				continue
+			}
+			if k.Currency == "" {
+				k.Currency = t.Currency
+			} else if t.Currency != k.Currency {
+				return errResp(400, "multiple currencies in range not supported yet")
+			}
+			if isRefund {
+				k.Refunds++
+				k.RefundedAmount += math.Abs(t.Amount)
+			} else {
+				k.Orders++
+				k.OrderValue += t.Amount
+			}
+		}
+	}
+
+	if k.Currency == "" {
+		k.Currency = "USD"
+	}
+	if k.Orders > 0 {
+		k.AOV = math.Round(k.OrderValue/float64(k.Orders)*100) / 100
+		k.RefundRate = math.Round(float64(k.Refunds)/float64(k.Orders)*10000) / 100
+	}
+	if k.OrderValue > 0 {
+		k.RefundedShare = math.Round(k.RefundedAmount/k.OrderValue*10000) / 100
+	}
+	k.OrderValue = math.Round(k.OrderValue*100) / 100
+	k.RefundedAmount = math.Round(k.RefundedAmount*100) / 100
+	return jsonResp(200, k)
+}
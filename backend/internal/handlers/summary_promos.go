@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/live"
+	"backend/internal/promos"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxPromoRangeDays bounds /summary/promos, which reads raw orders.
+const maxPromoRangeDays = 92
+
+// PromoSegment is one side of the sale-days vs normal-days comparison.
+type PromoSegment struct {
+	Days            int     `json:"days"`
+	Orders          int     `json:"orders"`
+	Revenue         float64 `json:"revenue"`
+	Discounts       float64 `json:"discounts"`
+	RevenuePerDay   float64 `json:"revenuePerDay"`
+	OrdersPerDay    float64 `json:"ordersPerDay"`
+	AvgOrderValue   float64 `json:"avgOrderValue"`
+	DiscountedShare float64 `json:"discountedShare"` // orders with a discount / orders
+	discounted      int
+}
+
+func (s *PromoSegment) add(d promos.DayStats) {
+	s.Days++
+	s.Orders += d.Orders
+	s.Revenue += d.Revenue
+	s.Discounts += d.Discount
+	s.discounted += d.Discounted
+}
+
+func (s *PromoSegment) finish() {
+	r2 := func(v float64) float64 { return math.Round(v*100) / 100 }
+	s.Revenue, s.Discounts = r2(s.Revenue), r2(s.Discounts)
+	if s.Days > 0 {
+		s.RevenuePerDay = r2(s.Revenue / float64(s.Days))
+		s.OrdersPerDay = r2(float64(s.Orders) / float64(s.Days))
+	}
+	if s.Orders > 0 {
+		s.AvgOrderValue = r2(s.Revenue / float64(s.Orders))
+		s.DiscountedShare = r2(float64(s.discounted) / float64(s.Orders))
+	}
+}
+
+// summaryPromos serves GET /summary/promos?shop=&from=&to= (local dates,
+// default the last 30 days): one shop's sale days, as labelled by the promo
+// detector, against its normal days.
+func summaryPromos(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	q := req.QueryStringParameters
+	loc := live.Location()
+
+	now := time.Now().In(loc)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if v := strings.TrimSpace(q["to"]); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return errResp(400, "to must be YYYY-MM-DD")
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -29)
+	if v := strings.TrimSpace(q["from"]); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return errResp(400, "from must be YYYY-MM-DD")
+		}
+		from = t
+	}
+	if from.After(to) || to.Sub(from) >= maxPromoRangeDays*24*time.Hour {
+		return errResp(400, "range must be 1-92 days")
+	}
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	allowed, err := tenancy.GetAllowedShopsByUserSub(ctx, client, sub)
+	if err != nil {
+		return errResp(500, "shop lookup failed")
+	}
+	shop := strings.TrimSpace(q["shop"])
+	if shop == "" {
+		if len(allowed) != 1 {
+			return errResp(400, "shop is required")
+		}
+		shop = allowed[0]
+	}
+	owned := ""
+	for _, a := range allowed {
+		if strings.EqualFold(a, shop) {
+			owned = a
+		}
+	}
+	if owned == "" {
+		return errResp(404, "shop not found")
+	}
+	shop = owned
+
+	fromDay, toDay := from.Format("2006-01-02"), to.Format("2006-01-02")
+	labels, err := promos.Load(ctx, client, shop, fromDay, toDay)
+	if err != nil {
+		return errResp(500, "failed to load promo days")
+	}
+	stats, err := promos.DailyStats(ctx, client, db.TransactionsTableName(), sub, shop, from, to)
+	if err != nil {
+		return errResp(500, "failed to load orders")
+	}
+
+	var sale, normal PromoSegment
+	promoDays := []promos.Label{}
+	for _, d := range stats {
+		if l, ok := labels[d.Date]; ok {
+			sale.add(d)
+			promoDays = append(promoDays, l)
+			continue
+		}
+		normal.add(d)
+	}
+	sale.finish()
+	normal.finish()
+
+	resp := map[string]any{
+		"shop":       shop,
+		"from":       fromDay,
+		"to":         toDay,
+		"timezone":   loc.String(),
+		"saleDays":   sale,
+		"normalDays": normal,
+		"promoDays":  promoDays,
+		"lift":       nil,
+	}
+	if sale.Days > 0 && normal.RevenuePerDay > 0 {
+		// revenue per sale day relative to a normal day
+		resp["lift"] = math.Round((sale.RevenuePerDay/normal.RevenuePerDay-1)*10000) / 10000
+	}
+	return jsonResp(200, resp)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxRangeSummaryDays bounds GET /summary/range; a year plus a leap day.
+const maxRangeSummaryDays = 366
+
+type RangeSummary struct {
+	From       string             `json:"from"`
+	To         string             `json:"to"`
+	Currency   string             `json:"currency"`
+	Income     float64            `json:"income"`
+	Expense    float64            `json:"expense"`
+	Net        float64            `json:"net"`
+	ByCategory map[string]float64 `json:"byCategory"`
+	Count      int                `json:"count"`
+	Months     []string           `json:"months"` // partitions read, oldest first
+}
+
+// summaryRange serves GET /summary/range?from=YYYY-MM-DD&to=YYYY-MM-DD: one
+// total over an arbitrary inclusive UTC date range. Every month partition the
+// range touches is read with a GSI1SK range and paged to the end, so "last 7
+// days" across a month boundary or "last quarter" needs a single call.
+func summaryRange(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	q := req.QueryStringParameters
+
+	fromS, toS := strings.TrimSpace(q["from"]), strings.TrimSpace(q["to"])
+	if fromS == "" || toS == "" {
+		return errResp(400, "from and to are required in format YYYY-MM-DD")
+	}
+	from, err := time.Parse("2006-01-02", fromS)
+	if err != nil {
+		return errResp(400, "from must be in format YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", toS)
+	if err != nil {
+		return errResp(400, "to must be in format YYYY-MM-DD")
+	}
+	if from.After(to) || int(to.Sub(from).Hours()/24) >= maxRangeSummaryDays {
+		return errResp(400, fmt.Sprintf("from/to must span at most %d days", maxRangeSummaryDays))
+	}
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	sum := RangeSummary{
+		From:       fromS,
+		To:         toS,
+		ByCategory: map[string]float64{},
+		Months:     []string{},
+	}
+	// DailySummary carries the same running totals; reuse its accumulator.
+	acc := DailySummary{ByCategory: sum.ByCategory}
+	for month := from.Format("2006-01"); month <= to.Format("2006-01"); month = nextMonth(month) {
+		items, err := queryMonthRange(ctx, client, table, sub, month, fromS, toS)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		sum.Months = append(sum.Months, month)
+		for _, t := range items {
+			if acc.Currency == "" {
+				acc.Currency = t.Currency
+			} else if t.Currency != acc.Currency {
+				return errResp(400, "multiple currencies in range not supported yet")
+			}
+			addToSummary(&acc, t)
+		}
+	}
+
+	sum.Currency = acc.Currency
+	if sum.Currency == "" {
+		sum.Currency = "USD"
+	}
+	sum.Income, sum.Expense, sum.Count = acc.Income, acc.Expense, acc.Count
+	sum.Net = sum.Income - sum.Expense
+	return jsonResp(200, sum)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/scorecard"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// summaryROAS serves GET /summary/roas?from=YYYY-MM-DD&to=YYYY-MM-DD[&shop=]:
+// blended ROAS, breakeven ROAS and net margin for the range, plus the same
+// per month it touches. Ad spend is the "Marketing Costs" category the ad
+// integrations post; with ?shop= the shop's rows and its revenue share of
+// allocated manual costs are used.
+func summaryROAS(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	q := req.QueryStringParameters
+
+	fromS, toS := strings.TrimSpace(q["from"]), strings.TrimSpace(q["to"])
+	if fromS == "" || toS == "" {
+		return errResp(400, "from and to are required in format YYYY-MM-DD")
+	}
+	from, err := time.Parse("2006-01-02", fromS)
+	if err != nil {
+		return errResp(400, "from must be in format YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", toS)
+	if err != nil {
+		return errResp(400, "to must be in format YYYY-MM-DD")
+	}
+	if from.After(to) || int(to.Sub(from).Hours()/24) >= maxRangeSummaryDays {
+		return errResp(400, fmt.Sprintf("from/to must span at most %d days", maxRangeSummaryDays))
+	}
+	shop := strings.ToLower(strings.TrimSpace(q["shop"]))
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	currency := ""
+	total := scorecard.Inputs{Month: fromS + ".." + toS, Days: int(to.Sub(from).Hours()/24) + 1}
+	var months []scorecard.Efficiency
+	for month := from.Format("2006-01"); month <= to.Format("2006-01"); month = nextMonth(month) {
+		items, err := queryMonthRange(ctx, client, table, sub, month, fromS, toS)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		if shop != "" {
+			items = allocateShops(items)
+		}
+		in := scorecard.Inputs{Month: month}
+		for _, t := range items {
+			if shop != "" && t.Shop != shop {
+				continue
+			}
+			if currency == "" {
+				currency = t.Currency
+			} else if t.Currency != currency {
+				return errResp(400, "multiple currencies in range not supported yet")
+			}
+			addEfficiencyInput(&in, t)
+		}
+		in.Days = monthDaysIn(month, from, to)
+		for _, v := range []*float64{&in.Revenue, &in.Refunds, &in.AdSpend, &in.OtherCosts} {
+			*v = math.Round(*v*100) / 100
+		}
+		total.Revenue += in.Revenue
+		total.Refunds += in.Refunds
+		total.AdSpend += in.AdSpend
+		total.OtherCosts += in.OtherCosts
+		months = append(months, scorecard.ComputeEfficiency(in))
+	}
+	for _, v := range []*float64{&total.Revenue, &total.Refunds, &total.AdSpend, &total.OtherCosts} {
+		*v = math.Round(*v*100) / 100
+	}
+
+	if currency == "" {
+		currency = "USD"
+	}
+	return jsonResp(200, map[string]any{
+		"from":     fromS,
+		"to":       toS,
+		"shop":     shop,
+		"currency": currency,
+		"total":    scorecard.ComputeEfficiency(total),
+		"months":   months,
+	})
+}
+
+// addEfficiencyInput files t under revenue, refunds, ad spend or other costs;
+// the shop scorecard sorts rows the same way.
+func addEfficiencyInput(in *scorecard.Inputs, t Transaction) {
+	switch {
+	case t.Category == "Marketing Costs":
+		in.AdSpend -= t.Amount
+	case t.Amount >= 0:
+		in.Revenue += t.Amount
+	case strings.HasSuffix(t.Category, " Refunds"):
+		in.Refunds -= t.Amount
+	default:
+		in.OtherCosts -= t.Amount
+	}
+}
+
+// monthDaysIn counts the days of month inside [from, to].
+func monthDaysIn(month string, from, to time.Time) int {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return 0
+	}
+	end := start.AddDate(0, 1, -1)
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	return int(end.Sub(start).Hours()/24) + 1
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// VATLine is one country and rate of the VAT report.
+type VATLine struct {
+	Country        string  `json:"country"`
+	Rate           float64 `json:"rate"`
+	Count          int     `json:"count"`
+	Gross          float64 `json:"gross"`
+	Net            float64 `json:"net"`
+	VAT            float64 `json:"vat"`
+	ReclaimableVAT float64 `json:"reclaimableVat"`
+}
+
+type VATReport struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Currency string `json:"currency"`
+
+	// Expense is every cost in the range, gross; NetCost takes the
+	// reclaimable VAT off it, which is what the costs really came to.
+	Expense        float64   `json:"expense"`
+	ReclaimableVAT float64   `json:"reclaimableVat"`
+	NetCost        float64   `json:"netCost"`
+	Lines          []VATLine `json:"lines"`
+
+	// Costs without a recorded VAT split count at their gross amount.
+	Unrecorded      int     `json:"unrecorded"`
+	UnrecordedGross float64 `json:"unrecordedGross"`
+}
+
+// summaryVAT serves GET /summary/vat?from=YYYY-MM-DD&to=YYYY-MM-DD: the VAT
+// paid on costs over an inclusive UTC range, by country and rate, and the
+// net cost once reclaimable VAT is taken off. Amounts are positive.
+func summaryVAT(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	q := req.QueryStringParameters
+
+	fromS, toS := strings.TrimSpace(q["from"]), strings.TrimSpace(q["to"])
+	if fromS == "" || toS == "" {
+		return errResp(400, "from and to are required in format YYYY-MM-DD")
+	}
+	from, err := time.Parse("2006-01-02", fromS)
+	if err != nil {
+		return errResp(400, "from must be in format YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", toS)
+	if err != nil {
+		return errResp(400, "to must be in format YYYY-MM-DD")
+	}
+	if from.After(to) || int(to.Sub(from).Hours()/24) >= maxRangeSummaryDays {
+		return errResp(400, fmt.Sprintf("from/to must span at most %d days", maxRangeSummaryDays))
+	}
+
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	r := VATReport{From: fromS, To: toS}
+	lines := map[string]*VATLine{}
+	for month := from.Format("2006-01"); month <= to.Format("2006-01"); month = nextMonth(month) {
+		items, err := queryMonthRange(ctx, client, table, sub, month, fromS, toS)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		for _, t := range items {
+			if t.Amount >= 0 {
+				continue
+			}
+			if r.Currency == "" {
+				r.Currency = t.Currency
+			} else if t.Currency != r.Currency {
+				return errResp(400, "multiple currencies in range not supported yet")
+			}
+			gross := math.Abs(t.Amount)
+			r.Expense += gross
+			if t.VATAmount == nil || t.NetAmount == nil || t.VATRate == nil {
+				r.Unrecorded++
+				r.UnrecordedGross += gross
+				continue
+			}
+			key := fmt.Sprintf("%s#%g", t.VATCountry, *t.VATRate)
+			l := lines[key]
+			if l == nil {
+				l = &VATLine{Country: t.VATCountry, Rate: *t.VATRate}
+				lines[key] = l
+			}
+			v := math.Abs(*t.VATAmount)
+			l.Count++
+			l.Gross += gross
+			l.Net += math.Abs(*t.NetAmount)
+			l.VAT += v
+			if t.VATReclaimable {
+				l.ReclaimableVAT += v
+				r.ReclaimableVAT += v
+			}
+		}
+	}
+
+	if r.Currency == "" {
+		r.Currency = "USD"
+	}
+	r2 := func(v float64) float64 { return math.Round(v*100) / 100 }
+	r.Lines = make([]VATLine, 0, len(lines))
+	for _, l := range lines {
+		l.Gross, l.Net = r2(l.Gross), r2(l.Net)
+		l.VAT, l.ReclaimableVAT = r2(l.VAT), r2(l.ReclaimableVAT)
+		r.Lines = append(r.Lines, *l)
+	}
+	sort.Slice(r.Lines, func(i, j int) bool {
+		if r.Lines[i].Country != r.Lines[j].Country {
+			return r.Lines[i].Country < r.Lines[j].Country
+		}
+		return r.Lines[i].Rate > r.Lines[j].Rate
+	})
+	r.Expense, r.ReclaimableVAT = r2(r.Expense), r2(r.ReclaimableVAT)
+	r.NetCost = r2(r.Expense - r.ReclaimableVAT)
+	r.UnrecordedGross = r2(r.UnrecordedGross)
+	return jsonResp(200, r)
+}
@@ -2,24 +2,23 @@ package handlers
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
 	"backend/internal/db"
+	"backend/internal/pagination"
+	"backend/internal/shopify"
 	"backend/internal/users"
+	"backend/internal/vat"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/aws/aws-sdk-go-v2/service/sns"
 )
 
 type Transaction struct {
@@ -29,19 +28,73 @@ type Transaction struct {
 	GSI1PK string `dynamodbav:"GSI1PK" json:"-"`
 	GSI1SK string `dynamodbav:"GSI1SK" json:"-"`
 
-	UserSub   string  `dynamodbav:"UserSub" json:"-"`
-	Amount    float64 `dynamodbav:"Amount" json:"amount"`
-	Currency  string  `dynamodbav:"Currency" json:"currency"`
-	Category  string  `dynamodbav:"Category" json:"category"`
-	Note      string  `dynamodbav:"Note" json:"note"`
-	CreatedAt string  `dynamodbav:"CreatedAt" json:"createdAt"`
+	UserSub   string   `dynamodbav:"UserSub" json:"-"`
+	Amount    float64  `dynamodbav:"Amount" json:"amount"`
+	Currency  string   `dynamodbav:"Currency" json:"currency"`
+	Category  string   `dynamodbav:"Category" json:"category"`
+	Note      string   `dynamodbav:"Note" json:"note"`
+	CreatedAt string   `dynamodbav:"CreatedAt" json:"createdAt"`
+	Source    string   `dynamodbav:"Source,omitempty" json:"source,omitempty"`
+	Shop      string   `dynamodbav:"Shop,omitempty" json:"shop,omitempty"`
+	OrderName string   `dynamodbav:"OrderName,omitempty" json:"orderName,omitempty"`
+	Tags      []string `dynamodbav:"Tags,stringset,omitempty" json:"tags,omitempty"`
+
+	// A split transaction keeps its row but no longer counts toward totals
+	// (SplitInto > 0); its allocations are rows of their own pointing back
+	// with ParentId.
+	SplitInto int    `dynamodbav:"SplitInto,omitempty" json:"splitInto,omitempty"`
+	SplitAt   string `dynamodbav:"SplitAt,omitempty" json:"-"`
+	ParentId  string `dynamodbav:"ParentId,omitempty" json:"parentId,omitempty"`
+
+	// Lines are the order lines of a Shopify order, when known.
+	Lines []shopify.LineItem `dynamodbav:"Lines,omitempty" json:"lines,omitempty"`
+
+	// ReceiptKey is the S3 key of an attached receipt; ReceiptURL is a
+	// short-lived download link filled in on read.
+	ReceiptKey string `dynamodbav:"ReceiptKey,omitempty" json:"receiptKey,omitempty"`
+	ReceiptURL string `dynamodbav:"-" json:"receiptUrl,omitempty"`
+
+	// RecordedAt is when the row was (last) written, for "as of" reporting.
+	RecordedAt string `dynamodbav:"RecordedAt,omitempty" json:"-"`
+
+	// DeletedAt marks a soft-deleted row: it no longer counts anywhere but
+	// can be restored (see transactions_history.go).
+	DeletedAt string `dynamodbav:"DeletedAt,omitempty" json:"deletedAt,omitempty"`
+
+	// AdjustsMonth is set on adjustments posted into a closed month (see
+	// transactions_close.go).
+	AdjustsMonth string `dynamodbav:"AdjustsMonth,omitempty" json:"adjustsMonth,omitempty"`
+
+	// Allocation spreads a manual row over all shops in per-shop reports
+	// (see transactions_allocation.go).
+	Allocation string `dynamodbav:"Allocation,omitempty" json:"allocation,omitempty"`
+
+	// LinkedTo is the SK of the order a fee rule charged this row on (see
+	// internal/feerules).
+	LinkedTo string `dynamodbav:"LinkedTo,omitempty" json:"linkedTo,omitempty"`
+
+	// VAT components of a cost, when recorded (see transactions_vat.go):
+	// NetAmount + VATAmount = Amount.
+	VATCountry     string   `dynamodbav:"VATCountry,omitempty" json:"vatCountry,omitempty"`
+	VATRate        *float64 `dynamodbav:"VATRate,omitempty" json:"vatRate,omitempty"`
+	VATAmount      *float64 `dynamodbav:"VATAmount,omitempty" json:"vatAmount,omitempty"`
+	NetAmount      *float64 `dynamodbav:"NetAmount,omitempty" json:"netAmount,omitempty"`
+	VATReclaimable bool     `dynamodbav:"VATReclaimable,omitempty" json:"vatReclaimable,omitempty"`
 }
 
 type CreateTransactionRequest struct {
-	Amount   float64 `json:"amount"`
-	Currency string  `json:"currency"`
-	Category string  `json:"category"`
-	Note     string  `json:"note"`
+	Amount   float64  `json:"amount"`
+	Currency string   `json:"currency"`
+	Category string   `json:"category"`
+	Note     string   `json:"note"`
+	Tags     []string `json:"tags,omitempty"`
+
+	// Optional attribution: one of the caller's shops, or an allocation.
+	Shop       string `json:"shop,omitempty"`
+	Allocation string `json:"allocation,omitempty"`
+
+	// Optional VAT split of a cost; gaps are filled from the VAT settings.
+	VAT *vat.Input `json:"vat,omitempty"`
 }
 
 func userSub(req events.APIGatewayV2HTTPRequest) (string, string, error) {
@@ -93,80 +146,318 @@ func Transactions(ctx context.Context, req events.APIGatewayV2HTTPRequest) (even
 		return errResp(500, "failed to init dynamodb")
 	}
 
-	awsCfg, _ := config.LoadDefaultConfig(ctx)
-	snsClient := sns.NewFromConfig(awsCfg)
+	// queues the user topic + confirm email once; alerts-provisioner does the SNS work
+	if err := users.RequestEmailAlerts(ctx, client, sub, email); err != nil {
+		fmt.Printf("transactions: %v\n", err)
+	}
 
-	// creates user topic + sends confirm email once
-	users.EnsureUserEmailAlerts(ctx, client, snsClient, sub, email)
+	switch req.RawPath {
+	case "/transactions/import":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return importTransactions(ctx, client, table, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/search":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return searchTransactions(ctx, client, table, sub, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/import/upload-url":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return importUploadURL(ctx, sub)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/receipts/upload-url":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return receiptUploadURL(ctx, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/split":
+		switch req.RequestContext.HTTP.Method {
+		case "POST":
+			return splitTransaction(ctx, client, table, sub, req.Body)
+		case "DELETE":
+			return unsplitTransaction(ctx, client, table, sub, req.QueryStringParameters["id"])
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/history":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return transactionHistory(ctx, client, table, sub, req.QueryStringParameters["id"])
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/restore":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return restoreTransaction(ctx, client, table, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/revert":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return revertTransaction(ctx, client, table, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/close":
+		switch req.RequestContext.HTTP.Method {
+		case "GET":
+			return listMonthCloses(ctx, client, table, sub)
+		case "POST":
+			return closeMonth(ctx, client, table, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/adjustments":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return postAdjustment(ctx, client, table, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/shop":
+		if req.RequestContext.HTTP.Method == "PUT" {
+			return setTransactionShop(ctx, client, table, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/vat":
+		if req.RequestContext.HTTP.Method == "PUT" {
+			return setTransactionVAT(ctx, client, table, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/sanity":
+		switch req.RequestContext.HTTP.Method {
+		case "GET":
+			return getSanityReport(ctx, client, table, sub)
+		case "POST":
+			return runSanityReport(ctx, client, table, sub, false)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/sanity/fix":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return runSanityReport(ctx, client, table, sub, true)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/duplicates":
+		if req.RequestContext.HTTP.Method == "GET" {
+			return listDuplicates(ctx, client, table, sub, req.QueryStringParameters)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/duplicates/dismiss":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return dismissDuplicate(ctx, client, table, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	case "/transactions/receipt":
+		if req.RequestContext.HTTP.Method == "PUT" {
+			return attachReceipt(ctx, client, table, sub, req.Body)
+		}
+		return errResp(405, "method not allowed")
+	}
 
 	switch req.RequestContext.HTTP.Method {
 	case "GET":
 		return listTransactions(ctx, client, table, sub, req)
 	case "POST":
-		return createTransaction(ctx, client, table, sub, req.Body)
+		key, err := idempotencyKey(req)
+		if err != nil {
+			return errResp(400, err.Error())
+		}
+		return createTransaction(ctx, client, table, sub, key, req.Body)
+	case "PATCH":
+		return updateTransactionTags(ctx, client, table, sub, req.Body)
+	case "DELETE":
+		if strings.TrimSpace(req.QueryStringParameters["source"]) != "" {
+			return purgeTransactions(ctx, client, sub, req.QueryStringParameters)
+		}
+		return deleteTransaction(ctx, client, table, sub, req.QueryStringParameters["id"])
 	default:
 		return errResp(405, "method not allowed")
 	}
 }
 
+// listTransactions pages through the caller's transactions, newest first.
+// Optional filters: from/to (YYYY-MM-DD, walked month by month over GSI1),
+// category, source ("manual" matches rows without a Source), shop and tag.
+// Deleted rows are left out unless deleted=true, which lists only them.
 func listTransactions(ctx context.Context, client *dynamodb.Client, table, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
 	pk := fmt.Sprintf("USER#%s", sub)
 
-	limit := int32(20)
-	if s := strings.TrimSpace(req.QueryStringParameters["limit"]); s != "" {
-		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= 100 {
-			limit = int32(n)
+	n, err := transactionsPageSize.Limit(q["limit"])
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+	limit := int32(n)
+
+	eks, err := pagination.DecodeToken(scopeTransactions, sub, q["nextToken"])
+	if err != nil {
+		return errResp(400, "invalid nextToken")
+	}
+
+	// Attribute filters
+	names := map[string]string{"#deletedAt": "DeletedAt"}
+	vals := map[string]types.AttributeValue{}
+	conds := []string{"attribute_not_exists(#deletedAt)"}
+	if q["deleted"] == "true" {
+		conds[0] = "attribute_exists(#deletedAt)"
+	}
+	if v := strings.TrimSpace(q["category"]); v != "" {
+		conds = append(conds, "#category = :category")
+		names["#category"] = "Category"
+		vals[":category"] = &types.AttributeValueMemberS{Value: v}
+	}
+	if v := strings.ToLower(strings.TrimSpace(q["source"])); v != "" {
+		names["#source"] = "Source"
+		if v == "manual" {
+			conds = append(conds, "attribute_not_exists(#source)")
+		} else {
+			conds = append(conds, "#source = :source")
+			vals[":source"] = &types.AttributeValueMemberS{Value: v}
+		}
+	}
+	if v := strings.ToLower(strings.TrimSpace(q["shop"])); v != "" {
+		conds = append(conds, "#shop = :shop")
+		names["#shop"] = "Shop"
+		vals[":shop"] = &types.AttributeValueMemberS{Value: v}
+	}
+
+	if v := strings.TrimSpace(q["tag"]); v != "" {
+		conds = append(conds, "contains(#tags, :tag)")
+		names["#tags"] = "Tags"
+		vals[":tag"] = &types.AttributeValueMemberS{Value: normalizeTag(v)}
+	}
+
+	in := &dynamodb.QueryInput{
+		TableName:        aws.String(table),
+		ScanIndexForward: aws.Bool(false),
+	}
+	in.FilterExpression = aws.String(strings.Join(conds, " AND "))
+	in.ExpressionAttributeNames = names
+
+	fromS, toS := strings.TrimSpace(q["from"]), strings.TrimSpace(q["to"])
+	if fromS == "" && toS == "" {
+		delete(eks, tokenMonthKey)
+		in.KeyConditionExpression = aws.String("PK = :pk")
+		vals[":pk"] = &types.AttributeValueMemberS{Value: pk}
+		in.ExpressionAttributeValues = vals
+
+		raw, lek, err := queryUpTo(ctx, client, in, eks, limit)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		return transactionsPage(ctx, scopeTransactions, sub, raw, lek, "")
+	}
+
+	// Date range: walk GSI1 month partitions from "to" back to "from".
+	to := time.Now().UTC()
+	if toS != "" {
+		t, err := time.Parse("2006-01-02", toS)
+		if err != nil {
+			return errResp(400, "to must be in format YYYY-MM-DD")
+		}
+		to = t
+	}
+	from := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if fromS != "" {
+		t, err := time.Parse("2006-01-02", fromS)
+		if err != nil {
+			return errResp(400, "from must be in format YYYY-MM-DD")
+		}
+		from = t
+	}
+	if from.After(to) || to.Sub(from) > 366*24*time.Hour {
+		return errResp(400, "from/to must span at most 366 days")
+	}
+
+	month := to.Format("2006-01")
+	if v, ok := eks[tokenMonthKey].(*types.AttributeValueMemberS); ok {
+		month = v.Value
+		delete(eks, tokenMonthKey)
+		if len(eks) == 0 {
+			eks = nil
 		}
 	}
 
-	var eks map[string]types.AttributeValue
-	if token := strings.TrimSpace(req.QueryStringParameters["nextToken"]); token != "" {
-		raw, err := base64.RawURLEncoding.DecodeString(token)
+	in.IndexName = aws.String("GSI1")
+	in.KeyConditionExpression = aws.String("GSI1PK = :pk AND GSI1SK BETWEEN :from AND :to")
+	vals[":from"] = &types.AttributeValueMemberS{Value: from.Format("2006-01-02")}
+	vals[":to"] = &types.AttributeValueMemberS{Value: to.Format("2006-01-02") + "~"} // sorts after any timestamp that day
+	in.ExpressionAttributeValues = vals
+
+	var items []map[string]types.AttributeValue
+	for month >= from.Format("2006-01") {
+		vals[":pk"] = &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, month)}
+		raw, lek, err := queryUpTo(ctx, client, in, eks, limit-int32(len(items)))
 		if err != nil {
-			return errResp(400, "invalid nextToken")
+			return errResp(500, "query failed")
 		}
-		var m map[string]map[string]string
-		if err := json.Unmarshal(raw, &m); err != nil {
-			return errResp(400, "invalid nextToken payload")
+		items = append(items, raw...)
+		eks = nil
+
+		prev := prevMonth(month)
+		if len(lek) > 0 {
+			return transactionsPage(ctx, scopeTransactions, sub, items, lek, month)
 		}
-		eks = map[string]types.AttributeValue{}
-		for k, v := range m {
-			if v["S"] != "" {
-				eks[k] = &types.AttributeValueMemberS{Value: v["S"]}
+		if int32(len(items)) >= limit {
+			if prev < from.Format("2006-01") {
+				break
 			}
+			return transactionsPage(ctx, scopeTransactions, sub, items, nil, prev)
 		}
+		month = prev
 	}
+	return transactionsPage(ctx, scopeTransactions, sub, items, nil, "")
+}
 
-	out, err := client.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(table),
-		KeyConditionExpression: aws.String("PK = :pk"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk": &types.AttributeValueMemberS{Value: pk},
-		},
-		ScanIndexForward:  aws.Bool(false),
-		Limit:             aws.Int32(limit),
-		ExclusiveStartKey: eks,
-	})
+// tokenMonthKey carries the GSI1 month a date-range listing continues from,
+// alongside the DynamoDB key, inside nextToken.
+const tokenMonthKey = "_month"
+
+func prevMonth(month string) string {
+	t, err := time.Parse("2006-01", month)
 	if err != nil {
-		return errResp(500, "query failed")
+		return ""
+	}
+	return t.AddDate(0, -1, 0).Format("2006-01")
+}
+
+// queryUpTo runs in from startKey until it has collected limit items after
+// filtering or the partition is exhausted (nil key).
+func queryUpTo(ctx context.Context, client *dynamodb.Client, in *dynamodb.QueryInput, startKey map[string]types.AttributeValue, limit int32) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	for {
+		in.Limit = aws.Int32(limit - int32(len(items)))
+		in.ExclusiveStartKey = startKey
+		out, err := client.Query(ctx, in)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return items, nil, nil
+		}
+		if int32(len(items)) >= limit {
+			return items, out.LastEvaluatedKey, nil
+		}
+		startKey = out.LastEvaluatedKey
 	}
+}
 
-	var items []Transaction
-	if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
+func transactionsPage(ctx context.Context, scope, sub string, raw []map[string]types.AttributeValue, lek map[string]types.AttributeValue, month string) (events.APIGatewayV2HTTPResponse, error) {
+	items := []Transaction{}
+	if err := attributevalue.UnmarshalListOfMaps(raw, &items); err != nil {
 		return errResp(500, "unmarshal failed")
 	}
+	signReceipts(ctx, items)
 
 	var nextToken string
-	if out.LastEvaluatedKey != nil && len(out.LastEvaluatedKey) > 0 {
-		// encode as a tiny json map of {key: {S:"value"}} and base64url it
-		m := map[string]map[string]string{}
-		for k, av := range out.LastEvaluatedKey {
-			if s, ok := av.(*types.AttributeValueMemberS); ok {
-				m[k] = map[string]string{"S": s.Value}
-			}
+	if len(lek) > 0 || month != "" {
+		key := map[string]types.AttributeValue{}
+		for k, av := range lek {
+			key[k] = av
+		}
+		if month != "" {
+			key[tokenMonthKey] = &types.AttributeValueMemberS{Value: month}
 		}
-		b, _ := json.Marshal(m)
-		nextToken = base64.RawURLEncoding.EncodeToString(b)
+		t, err := pagination.EncodeToken(scope, sub, key)
+		if err != nil {
+			return errResp(500, "failed to encode nextToken")
+		}
+		nextToken = t
 	}
 
 	return jsonResp(200, map[string]any{
@@ -175,7 +466,9 @@ func listTransactions(ctx context.Context, client *dynamodb.Client, table, sub s
 	})
 }
 
-func createTransaction(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+// createTransaction adds a manual transaction. With an idempotency key, a
+// retried request returns the original transaction instead of a duplicate.
+func createTransaction(ctx context.Context, client *dynamodb.Client, table, sub, idemKey, body string) (events.APIGatewayV2HTTPResponse, error) {
 	var in CreateTransactionRequest
 	if err := json.Unmarshal([]byte(body), &in); err != nil {
 		return errResp(400, "invalid json body")
@@ -183,6 +476,20 @@ func createTransaction(ctx context.Context, client *dynamodb.Client, table, sub,
 	if in.Amount == 0 || strings.TrimSpace(in.Currency) == "" || strings.TrimSpace(in.Category) == "" {
 		return errResp(400, "amount, currency, category are required")
 	}
+	tags, err := normalizeTags(in.Tags)
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+	shop, allocation, resp := attribution(ctx, client, sub, in.Shop, in.Allocation)
+	if resp != nil {
+		return *resp, nil
+	}
+	var vc *vat.Components
+	if in.VAT != nil {
+		if vc, resp = resolveVAT(ctx, client, sub, in.Amount, *in.VAT); resp != nil {
+			return *resp, nil
+		}
+	}
 
 	now := time.Now().UTC()
 	month := now.Format("2006-01") // YYYY-MM
@@ -202,6 +509,14 @@ func createTransaction(ctx context.Context, client *dynamodb.Client, table, sub,
 		Category:  strings.TrimSpace(in.Category),
 		Note:      strings.TrimSpace(in.Note),
 		CreatedAt: now.Format(time.RFC3339),
+		Shop:      shop,
+		Tags:      tags,
+
+		Allocation: allocation,
+		RecordedAt: now.Format(time.RFC3339Nano),
+	}
+	if vc != nil {
+		setVAT(&item, *vc)
 	}
 
 	av, err := attributevalue.MarshalMap(item)
@@ -209,6 +524,24 @@ func createTransaction(ctx context.Context, client *dynamodb.Client, table, sub,
 		return errResp(500, "marshal failed")
 	}
 
+	// Look-alikes only warn; the transaction is created regardless.
+	created := CreatedTransaction{Transaction: item}
+	dupIds, err := sameDayDuplicates(ctx, client, table, sub, item)
+	if err != nil {
+		fmt.Printf("transactions: duplicate check for %s failed: %v\n", sub, err)
+	}
+	if len(dupIds) > 0 {
+		created.Warnings = append(created.Warnings, duplicateWarning(dupIds))
+	}
+
+	if idemKey != "" {
+		resp, err := putIdempotent(ctx, client, table, sub, idemKey, body, av, created)
+		if resp.StatusCode == 201 && len(dupIds) > 0 {
+			recordDuplicate(ctx, client, table, sub, item, dupIds)
+		}
+		return resp, err
+	}
+
 	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(table),
 		Item:      av,
@@ -216,6 +549,9 @@ func createTransaction(ctx context.Context, client *dynamodb.Client, table, sub,
 	if err != nil {
 		return errResp(500, "put failed")
 	}
+	if len(dupIds) > 0 {
+		recordDuplicate(ctx, client, table, sub, item, dupIds)
+	}
 
-	return jsonResp(201, item)
+	return jsonResp(201, created)
 }
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Manual transactions carry no Shop, so per-shop reports would leave them
+// out. They can be attributed to one of the caller's shops (Shop), or spread
+// over every shop by revenue share (Allocation); reports apply the latter
+// with allocateShops, the stored row is never split.
+const AllocationRevenueShare = "revenue_share"
+
+type SetTransactionShopRequest struct {
+	Id         string `json:"id"`
+	Shop       string `json:"shop"`
+	Allocation string `json:"allocation"`
+}
+
+// attribution validates a shop/allocation pair from a request; both empty
+// means unattributed.
+func attribution(ctx context.Context, client *dynamodb.Client, sub, shop, allocation string) (string, string, *events.APIGatewayV2HTTPResponse) {
+	shop = strings.ToLower(strings.TrimSpace(shop))
+	allocation = strings.ToLower(strings.TrimSpace(allocation))
+	if allocation != "" && allocation != AllocationRevenueShare {
+		resp, _ := errResp(400, "allocation must be "+AllocationRevenueShare)
+		return "", "", &resp
+	}
+	if shop != "" && allocation != "" {
+		resp, _ := errResp(400, "shop and allocation are mutually exclusive")
+		return "", "", &resp
+	}
+	if shop != "" {
+		owned, resp, ok := ownedShop(ctx, client, sub, shop)
+		if !ok {
+			return "", "", &resp
+		}
+		shop = owned
+	}
+	return shop, allocation, nil
+}
+
+// setTransactionShop serves PUT /transactions/shop with {"id", "shop"} or
+// {"id", "allocation"}; neither clears the attribution. Only manual rows and
+// adjustments can be attributed, synced rows keep the shop they came from.
+func setTransactionShop(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in SetTransactionShopRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	shop, allocation, resp := attribution(ctx, client, sub, in.Shop, in.Allocation)
+	if resp != nil {
+		return *resp, nil
+	}
+	t, resp := ownTransaction(ctx, client, table, sub, in.Id)
+	if resp != nil {
+		return *resp, nil
+	}
+	if t.Source != "" && t.Source != "adjustment" {
+		return errResp(400, "only manual transactions can be attributed to a shop")
+	}
+
+	up := &types.Update{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: t.PK},
+			"SK": &types.AttributeValueMemberS{Value: t.SK},
+		},
+		ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(DeletedAt) AND attribute_not_exists(SplitInto)"),
+	}
+	switch {
+	case shop != "":
+		up.UpdateExpression = aws.String("SET Shop = :shop REMOVE Allocation")
+		up.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":shop": &types.AttributeValueMemberS{Value: shop},
+		}
+	case allocation != "":
+		up.UpdateExpression = aws.String("SET Allocation = :alloc REMOVE Shop")
+		up.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":alloc": &types.AttributeValueMemberS{Value: allocation},
+		}
+	default:
+		up.UpdateExpression = aws.String("REMOVE Shop, Allocation")
+	}
+	_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Update: up},
+			notClosedCheck(table, sub, t),
+		},
+	})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return errResp(409, "transaction changed, retry")
+	}
+	if err != nil {
+		return errResp(500, "update failed")
+	}
+
+	t.Shop, t.Allocation = shop, allocation
+	return jsonResp(200, t)
+}
+
+// allocateShops replaces every revenue_share row in items with one row per
+// shop, weighted by the shops' revenue in items in the row's currency. The
+// cent left over by rounding goes to the last shop. Rows whose currency has
+// no shop revenue stay as they are, unattributed.
+func allocateShops(items []Transaction) []Transaction {
+	revenue := map[string]map[string]float64{} // currency -> shop -> revenue
+	allocated := false
+	for _, t := range items {
+		if t.Allocation == AllocationRevenueShare {
+			allocated = true
+		}
+		if t.Shop == "" || t.Amount <= 0 {
+			continue
+		}
+		if revenue[t.Currency] == nil {
+			revenue[t.Currency] = map[string]float64{}
+		}
+		revenue[t.Currency][t.Shop] += t.Amount
+	}
+	if !allocated {
+		return items
+	}
+
+	out := make([]Transaction, 0, len(items))
+	for _, t := range items {
+		byShop := revenue[t.Currency]
+		if t.Allocation != AllocationRevenueShare || len(byShop) == 0 {
+			out = append(out, t)
+			continue
+		}
+		shops := make([]string, 0, len(byShop))
+		total := 0.0
+		for s, v := range byShop {
+			shops = append(shops, s)
+			total += v
+		}
+		sort.Strings(shops)
+		left := t.Amount
+		for i, s := range shops {
+			part := t
+			part.Shop = s
+			if i == len(shops)-1 {
+				part.Amount = math.Round(left*100) / 100
+			} else {
+				part.Amount = math.Round(t.Amount*byShop[s]/total*100) / 100
+				left -= part.Amount
+			}
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Closing a month locks its transactions for bookkeeping:
+//
+//	GET  /transactions/close                     closed months, oldest first
+//	POST /transactions/close {"month"}           close a past month
+//	POST /transactions/adjustments {...}         post an entry into a closed month
+//
+// A close records the month's balances per currency at that moment. After
+// it, rows of the month can't be deleted, restored, reverted, split or
+// imported into; the only way to change its totals is an adjustment, a row
+// with Source "adjustment" that is itself locked. Tags, notes and receipts
+// stay editable. Source syncs are not blocked: a late Shopify edit still
+// lands, and shows up as the difference between the closing balance and the
+// month's live total.
+//
+//	PK = CLOSE#USER#<sub>
+//	SK = MONTH#<YYYY-MM>
+type MonthClose struct {
+	PK string `dynamodbav:"PK" json:"-"`
+	SK string `dynamodbav:"SK" json:"-"`
+
+	Month       string         `dynamodbav:"Month" json:"month"`
+	ClosedAt    string         `dynamodbav:"ClosedAt" json:"closedAt"`
+	ClosedBy    string         `dynamodbav:"ClosedBy" json:"closedBy"`
+	Balances    []CloseBalance `dynamodbav:"Balances" json:"balances"`
+	Adjustments int            `dynamodbav:"Adjustments" json:"adjustments"`
+}
+
+// CloseBalance is a closed month's totals in one currency.
+type CloseBalance struct {
+	Currency string  `dynamodbav:"Currency" json:"currency"`
+	Income   float64 `dynamodbav:"Income" json:"income"`
+	Expense  float64 `dynamodbav:"Expense" json:"expense"`
+	Net      float64 `dynamodbav:"Net" json:"net"`
+	Count    int     `dynamodbav:"Count" json:"count"`
+}
+
+type AdjustmentRequest struct {
+	Month    string  `json:"month"`          // closed month, YYYY-MM
+	Date     string  `json:"date,omitempty"` // YYYY-MM-DD in month; default its last day
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Category string  `json:"category"`
+	Note     string  `json:"note"`
+}
+
+const sourceAdjustment = "adjustment"
+
+func monthCloseKey(sub, month string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "CLOSE#USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: "MONTH#" + month},
+	}
+}
+
+// txMonth is the GSI1 month partition a transaction counts in.
+func txMonth(t *Transaction) string {
+	if i := strings.LastIndex(t.GSI1PK, "#MONTH#"); i >= 0 {
+		return t.GSI1PK[i+len("#MONTH#"):]
+	}
+	return ""
+}
+
+func monthClosed(ctx context.Context, client *dynamodb.Client, table, sub, month string) (bool, error) {
+	if month == "" {
+		return false, nil
+	}
+	out, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:            aws.String(table),
+		Key:                  monthCloseKey(sub, month),
+		ProjectionExpression: aws.String("PK"),
+	})
+	if err != nil {
+		return false, err
+	}
+	return out.Item != nil, nil
+}
+
+// closedMonthResp is set when t's month is closed (or can't be checked).
+func closedMonthResp(ctx context.Context, client *dynamodb.Client, table, sub string, t *Transaction) *events.APIGatewayV2HTTPResponse {
+	month := txMonth(t)
+	closed, err := monthClosed(ctx, client, table, sub, month)
+	if err != nil {
+		resp, _ := errResp(500, "close lookup failed")
+		return &resp
+	}
+	if closed {
+		resp, _ := errResp(409, fmt.Sprintf("month %s is closed, post an adjustment instead", month))
+		return &resp
+	}
+	return nil
+}
+
+// notClosedCheck makes a transactional write fail if t's month is closed
+// between our check and the write.
+func notClosedCheck(table, sub string, t *Transaction) types.TransactWriteItem {
+	return types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+		TableName:           aws.String(table),
+		Key:                 monthCloseKey(sub, txMonth(t)),
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	}}
+}
+
+func listMonthCloses(ctx context.Context, client *dynamodb.Client, table, sub string) (events.APIGatewayV2HTTPResponse, error) {
+	var (
+		closes   []MonthClose
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			KeyConditionExpression: aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: "CLOSE#USER#" + sub},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		var page []MonthClose
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return errResp(500, "unmarshal failed")
+		}
+		closes = append(closes, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	if closes == nil {
+		closes = []MonthClose{}
+	}
+	return jsonResp(200, map[string]any{"items": closes})
+}
+
+// closeMonth serves POST /transactions/close. Only months that have ended
+// (UTC) can be closed, and a month closes once.
+func closeMonth(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in struct {
+		Month string `json:"month"`
+	}
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	m, err := time.Parse("2006-01", strings.TrimSpace(in.Month))
+	if err != nil {
+		return errResp(400, "month is required in format YYYY-MM")
+	}
+	month := m.Format("2006-01")
+	now := time.Now().UTC()
+	if month >= now.Format("2006-01") {
+		return errResp(400, "only past months can be closed")
+	}
+
+	items, err := queryMonthTransactions(ctx, client, table, sub, month)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+	byCur := map[string]*CloseBalance{}
+	for _, t := range items {
+		b := byCur[t.Currency]
+		if b == nil {
+			b = &CloseBalance{Currency: t.Currency}
+			byCur[t.Currency] = b
+		}
+		if t.Amount >= 0 {
+			b.Income += t.Amount
+		} else {
+			b.Expense += math.Abs(t.Amount)
+		}
+		b.Count++
+	}
+	balances := make([]CloseBalance, 0, len(byCur))
+	for _, b := range byCur {
+		b.Net = b.Income - b.Expense
+		balances = append(balances, *b)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Currency < balances[j].Currency })
+
+	key := monthCloseKey(sub, month)
+	c := MonthClose{
+		PK:       key["PK"].(*types.AttributeValueMemberS).Value,
+		SK:       key["SK"].(*types.AttributeValueMemberS).Value,
+		Month:    month,
+		ClosedAt: now.Format(time.RFC3339),
+		ClosedBy: sub,
+		Balances: balances,
+	}
+	av, err := attributevalue.MarshalMap(c)
+	if err != nil {
+		return errResp(500, "marshal failed")
+	}
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return errResp(409, "month already closed")
+	}
+	if err != nil {
+		return errResp(500, "close failed")
+	}
+	return jsonResp(201, c)
+}
+
+// postAdjustment serves POST /transactions/adjustments: a manual entry dated
+// in a closed month, counted on the month's close record.
+func postAdjustment(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in AdjustmentRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	if in.Amount == 0 || strings.TrimSpace(in.Currency) == "" || strings.TrimSpace(in.Category) == "" {
+		return errResp(400, "amount, currency, category are required")
+	}
+	m, err := time.Parse("2006-01", strings.TrimSpace(in.Month))
+	if err != nil {
+		return errResp(400, "month is required in format YYYY-MM")
+	}
+	month := m.Format("2006-01")
+	date := m.AddDate(0, 1, -1)
+	if s := strings.TrimSpace(in.Date); s != "" {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil || d.Format("2006-01") != month {
+			return errResp(400, "date must be YYYY-MM-DD within month")
+		}
+		date = d
+	}
+
+	now := time.Now().UTC()
+	// End of the chosen day, so the entry sorts after the day's other rows.
+	at := date.Add(24*time.Hour - time.Second)
+	item := Transaction{
+		PK: fmt.Sprintf("USER#%s", sub),
+		SK: fmt.Sprintf("TX#%s", now.Format(time.RFC3339Nano)),
+
+		GSI1PK: fmt.Sprintf("USER#%s#MONTH#%s", sub, month),
+		GSI1SK: at.Format(time.RFC3339Nano),
+
+		UserSub:      sub,
+		Amount:       in.Amount,
+		Currency:     strings.ToUpper(strings.TrimSpace(in.Currency)),
+		Category:     strings.TrimSpace(in.Category),
+		Note:         strings.TrimSpace(in.Note),
+		CreatedAt:    at.Format(time.RFC3339),
+		Source:       sourceAdjustment,
+		AdjustsMonth: month,
+
+		RecordedAt: now.Format(time.RFC3339Nano),
+	}
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return errResp(500, "marshal failed")
+	}
+
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:           aws.String(table),
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			}},
+			{Update: &types.Update{
+				TableName:           aws.String(table),
+				Key:                 monthCloseKey(sub, month),
+				UpdateExpression:    aws.String("ADD Adjustments :one"),
+				ConditionExpression: aws.String("attribute_exists(PK)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "1"},
+				},
+			}},
+		},
+	})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return errResp(409, fmt.Sprintf("month %s is not closed, edit its transactions directly", month))
+	}
+	if err != nil {
+		return errResp(500, "put failed")
+	}
+	return jsonResp(201, item)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"backend/internal/dupes"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// TransactionWarning is a non-blocking remark on a write.
+type TransactionWarning struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Ids     []string `json:"ids,omitempty"`
+}
+
+// CreatedTransaction is the response to POST /transactions.
+type CreatedTransaction struct {
+	Transaction
+	Warnings []TransactionWarning `json:"warnings,omitempty"`
+}
+
+func dupRow(t Transaction) dupes.Row {
+	return dupes.Row{
+		SK: t.SK, GSI1SK: t.GSI1SK, Amount: t.Amount, Currency: t.Currency, Category: t.Category,
+		Source: t.Source, SplitInto: t.SplitInto, ParentId: t.ParentId, DeletedAt: t.DeletedAt,
+	}
+}
+
+// sameDayDuplicates returns the ids of the caller's manual transactions that
+// t would duplicate: same day, currency, amount and category.
+func sameDayDuplicates(ctx context.Context, client *dynamodb.Client, table, sub string, t Transaction) ([]string, error) {
+	e, ok := dupRow(t).Entry()
+	if !ok {
+		return nil, nil
+	}
+	items, err := queryMonthRange(ctx, client, table, sub, txMonth(&t), e.Day, e.Day)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, other := range items {
+		o, ok := dupRow(other).Entry()
+		if ok && o.Id != e.Id && dupes.Key(o) == dupes.Key(e) {
+			ids = append(ids, o.Id)
+		}
+	}
+	return ids, nil
+}
+
+func duplicateWarning(ids []string) TransactionWarning {
+	return TransactionWarning{
+		Code:    "possible_duplicate",
+		Message: fmt.Sprintf("%d transaction(s) with the same amount and category were already entered that day", len(ids)),
+		Ids:     ids,
+	}
+}
+
+// recordDuplicate files t and its look-alikes for review; a failure only
+// costs the review entry, the scheduled scan finds the group again.
+func recordDuplicate(ctx context.Context, client *dynamodb.Client, table, sub string, t Transaction, ids []string) {
+	e, ok := dupRow(t).Entry()
+	if !ok {
+		return
+	}
+	var entries []dupes.Entry
+	for _, id := range append([]string{t.SK}, ids...) {
+		e.Id = id
+		entries = append(entries, e)
+	}
+	if err := dupes.Record(ctx, client, table, sub, dupes.Find(entries)); err != nil {
+		fmt.Printf("transactions: record duplicate for %s failed: %v\n", sub, err)
+	}
+}
+
+// listDuplicates serves GET /transactions/duplicates[?status=open|dismissed|all]:
+// groups of likely duplicate manual transactions, open ones by default.
+func listDuplicates(ctx context.Context, client *dynamodb.Client, table, sub string, q map[string]string) (events.APIGatewayV2HTTPResponse, error) {
+	status := strings.ToLower(strings.TrimSpace(q["status"]))
+	switch status {
+	case "":
+		status = dupes.StatusOpen
+	case "all":
+		status = ""
+	case dupes.StatusOpen, dupes.StatusDismissed:
+	default:
+		return errResp(400, "status must be open, dismissed or all")
+	}
+	groups, err := dupes.List(ctx, client, table, sub, status)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+	if groups == nil {
+		groups = []dupes.Group{}
+	}
+	return jsonResp(200, map[string]any{"items": groups})
+}
+
+type DismissDuplicateRequest struct {
+	Key string `json:"key"`
+}
+
+// dismissDuplicate serves POST /transactions/duplicates/dismiss with {"key"}:
+// the group is not a duplicate and stays quiet until another look-alike is
+// entered.
+func dismissDuplicate(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in DismissDuplicateRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	if strings.TrimSpace(in.Key) == "" {
+		return errResp(400, "key is required")
+	}
+	g, err := dupes.Dismiss(ctx, client, table, sub, strings.TrimSpace(in.Key))
+	if errors.Is(err, dupes.ErrNotFound) {
+		return errResp(404, "duplicate group not found")
+	}
+	if err != nil {
+		return errResp(500, "dismiss failed")
+	}
+	return jsonResp(200, g)
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/internal/restate"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Transactions are never hard-deleted by users. DELETE /transactions marks
+// the row with DeletedAt, which drops it from listings and totals, and keeps
+// the deleted version in the restatement history so "as of" reports still
+// see it before the delete. Together with the versions kept when sources
+// rewrite a row, that history can be listed and any version reverted to:
+//
+//	DELETE /transactions?id=                          soft delete
+//	POST   /transactions/restore {"id"}               undo a delete
+//	GET    /transactions/history?id=                  revisions, newest first
+//	POST   /transactions/revert  {"id","revision"}    back to a revision's amount/category
+//
+// A revert only sets amount, currency and category; the next sync of a
+// source row may restate it again.
+
+// TransactionRevision is one replaced version of a transaction. Revision is
+// its id for reverting; Action is "delete", "revert" or "" for a source
+// rewrite, and ReplacedBy the user who did it.
+type TransactionRevision struct {
+	Revision   string  `json:"revision"`
+	Amount     float64 `json:"amount"`
+	Currency   string  `json:"currency"`
+	Category   string  `json:"category"`
+	CreatedAt  string  `json:"createdAt"`
+	RecordedAt string  `json:"recordedAt,omitempty"`
+	ReplacedAt string  `json:"replacedAt"`
+	Action     string  `json:"action,omitempty"`
+	ReplacedBy string  `json:"replacedBy,omitempty"`
+}
+
+type transactionIDRequest struct {
+	Id       string `json:"id"`
+	Revision string `json:"revision"`
+}
+
+// ownTransaction loads the caller's transaction id for a user edit; resp is
+// set when there is none or it can't be edited that way.
+func ownTransaction(ctx context.Context, client *dynamodb.Client, table, sub, id string) (*Transaction, *events.APIGatewayV2HTTPResponse) {
+	fail := func(status int, msg string) (*Transaction, *events.APIGatewayV2HTTPResponse) {
+		resp, _ := errResp(status, msg)
+		return nil, &resp
+	}
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return fail(400, "id is required")
+	}
+	t, err := getTransaction(ctx, client, table, sub, id)
+	if err != nil {
+		return fail(500, "get failed")
+	}
+	if t == nil {
+		return fail(404, "transaction not found")
+	}
+	if t.ParentId != "" {
+		return fail(400, "allocations change through their split")
+	}
+	if t.SplitInto > 0 {
+		return fail(400, "transaction is split, unsplit it first")
+	}
+	if resp := closedMonthResp(ctx, client, table, sub, t); resp != nil {
+		return nil, resp
+	}
+	return t, nil
+}
+
+// writeWithHistory applies update to t and keeps t's current version as
+// history, in one transaction, provided t's month is still open. update's
+// condition should pin the fields the history copies.
+func writeWithHistory(ctx context.Context, client *dynamodb.Client, table string, t *Transaction, update *types.Update, now time.Time, action, sub string) error {
+	old, err := attributevalue.MarshalMap(t)
+	if err != nil {
+		return err
+	}
+	hist, err := restate.HistoryItem(old, now.Format(time.RFC3339Nano), action, sub)
+	if err != nil {
+		return err
+	}
+	update.TableName = aws.String(table)
+	update.Key = map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: t.PK},
+		"SK": &types.AttributeValueMemberS{Value: t.SK},
+	}
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Update: update},
+			{Put: &types.Put{TableName: aws.String(table), Item: hist}},
+			notClosedCheck(table, sub, t),
+		},
+	})
+	return err
+}
+
+func deleteTransaction(ctx context.Context, client *dynamodb.Client, table, sub, id string) (events.APIGatewayV2HTTPResponse, error) {
+	t, resp := ownTransaction(ctx, client, table, sub, id)
+	if resp != nil {
+		return *resp, nil
+	}
+	if t.DeletedAt != "" {
+		return errResp(409, "transaction already deleted")
+	}
+
+	amount, err := attributevalue.Marshal(t.Amount)
+	if err != nil {
+		return errResp(500, "marshal failed")
+	}
+	now := time.Now().UTC()
+	err = writeWithHistory(ctx, client, table, t, &types.Update{
+		UpdateExpression:    aws.String("SET DeletedAt = :at"),
+		ConditionExpression: aws.String("attribute_not_exists(DeletedAt) AND attribute_not_exists(SplitInto) AND Amount = :amt AND Category = :cat"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			":amt": amount,
+			":cat": &types.AttributeValueMemberS{Value: t.Category},
+		},
+	}, now, restate.ActionDelete, sub)
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return errResp(409, "transaction changed, retry")
+	}
+	if err != nil {
+		return errResp(500, "delete failed")
+	}
+
+	t.DeletedAt = now.Format(time.RFC3339Nano)
+	return jsonResp(200, t)
+}
+
+// restoreTransaction undoes a soft delete. The row counts again from now on;
+// its delete entry covers the time before the delete.
+func restoreTransaction(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in transactionIDRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	t, resp := ownTransaction(ctx, client, table, sub, in.Id)
+	if resp != nil {
+		return *resp, nil
+	}
+	if t.DeletedAt == "" {
+		return errResp(400, "transaction is not deleted")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: t.PK},
+			"SK": &types.AttributeValueMemberS{Value: t.SK},
+		},
+		UpdateExpression:    aws.String("SET RecordedAt = :now REMOVE DeletedAt"),
+		ConditionExpression: aws.String("DeletedAt = :at"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: now},
+			":at":  &types.AttributeValueMemberS{Value: t.DeletedAt},
+		},
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return errResp(409, "transaction changed, retry")
+	}
+	if err != nil {
+		return errResp(500, "restore failed")
+	}
+
+	t.DeletedAt = ""
+	t.RecordedAt = now
+	return jsonResp(200, t)
+}
+
+func transactionHistory(ctx context.Context, client *dynamodb.Client, table, sub, id string) (events.APIGatewayV2HTTPResponse, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return errResp(400, "id is required")
+	}
+	t, err := getTransaction(ctx, client, table, sub, id)
+	if err != nil {
+		return errResp(500, "get failed")
+	}
+	if t == nil {
+		return errResp(404, "transaction not found")
+	}
+	entries, err := restate.History(ctx, client, table, sub, t.SK)
+	if err != nil {
+		return errResp(500, "history query failed")
+	}
+
+	revisions := make([]TransactionRevision, 0, len(entries))
+	for _, e := range entries {
+		revisions = append(revisions, TransactionRevision{
+			Revision:   e.ReplacedAt,
+			Amount:     e.PrevAmount,
+			Currency:   e.PrevCurrency,
+			Category:   e.PrevCategory,
+			CreatedAt:  e.PrevCreatedAt,
+			RecordedAt: e.PrevRecordedAt,
+			ReplacedAt: e.ReplacedAt,
+			Action:     e.Action,
+			ReplacedBy: e.ReplacedBy,
+		})
+	}
+	return jsonResp(200, map[string]any{
+		"transaction": t,
+		"revisions":   revisions,
+	})
+}
+
+// revertTransaction sets a transaction's amount, currency and category back
+// to one of its revisions, keeping the version it replaces.
+func revertTransaction(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in transactionIDRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	in.Revision = strings.TrimSpace(in.Revision)
+	if in.Revision == "" {
+		return errResp(400, "revision is required")
+	}
+	t, resp := ownTransaction(ctx, client, table, sub, in.Id)
+	if resp != nil {
+		return *resp, nil
+	}
+	if t.DeletedAt != "" {
+		return errResp(400, "transaction is deleted, restore it first")
+	}
+	rev, err := restate.Get(ctx, client, table, sub, t.SK, in.Revision)
+	if err != nil {
+		return errResp(500, "get failed")
+	}
+	if rev == nil {
+		return errResp(404, "revision not found")
+	}
+	if cents(rev.PrevAmount) == cents(t.Amount) && rev.PrevCurrency == t.Currency && rev.PrevCategory == t.Category {
+		return jsonResp(200, t)
+	}
+
+	amount, err := attributevalue.Marshal(t.Amount)
+	if err != nil {
+		return errResp(500, "marshal failed")
+	}
+	prevAmount, err := attributevalue.Marshal(rev.PrevAmount)
+	if err != nil {
+		return errResp(500, "marshal failed")
+	}
+	now := time.Now().UTC()
+	err = writeWithHistory(ctx, client, table, t, &types.Update{
+		UpdateExpression:    aws.String("SET Amount = :prevAmt, Currency = :prevCur, Category = :prevCat, RecordedAt = :now"),
+		ConditionExpression: aws.String("attribute_not_exists(DeletedAt) AND attribute_not_exists(SplitInto) AND Amount = :amt AND Category = :cat"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prevAmt": prevAmount,
+			":prevCur": &types.AttributeValueMemberS{Value: rev.PrevCurrency},
+			":prevCat": &types.AttributeValueMemberS{Value: rev.PrevCategory},
+			":now":     &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			":amt":     amount,
+			":cat":     &types.AttributeValueMemberS{Value: t.Category},
+		},
+	}, now, restate.ActionRevert, sub)
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return errResp(409, "transaction changed, retry")
+	}
+	if err != nil {
+		return errResp(500, "revert failed")
+	}
+
+	t.Amount, t.Currency, t.Category = rev.PrevAmount, rev.PrevCurrency, rev.PrevCategory
+	t.RecordedAt = now.Format(time.RFC3339Nano)
+	return jsonResp(200, t)
+}
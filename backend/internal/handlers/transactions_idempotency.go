@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// An Idempotency-Key on POST /transactions is claimed with a conditional put
+// of a guard row at a key derived from it (PK = IDEMPOTENCY#USER#<sub>,
+// SK = KEY#<sha256>), in the same transaction as the new row. A retry finds
+// the guard and gets the row the first attempt created; guards expire after
+// idempotencyTTL.
+const (
+	idempotencyTTL    = 24 * time.Hour
+	maxIdempotencyKey = 255
+)
+
+func idempotencyGuardKey(sub, key string) map[string]types.AttributeValue {
+	sum := sha256.Sum256([]byte(key))
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "IDEMPOTENCY#USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: "KEY#" + hex.EncodeToString(sum[:])},
+	}
+}
+
+func requestHash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyKey reads the header (HTTP API lowercases header names).
+func idempotencyKey(req events.APIGatewayV2HTTPRequest) (string, error) {
+	key := strings.TrimSpace(req.Headers["idempotency-key"])
+	if len(key) > maxIdempotencyKey {
+		return "", fmt.Errorf("Idempotency-Key must be at most %d characters", maxIdempotencyKey)
+	}
+	return key, nil
+}
+
+// putIdempotent writes item unless key was already used by this user, in
+// which case it answers with the transaction created the first time.
+func putIdempotent(ctx context.Context, client *dynamodb.Client, table, sub, key, body string, item map[string]types.AttributeValue, created any) (events.APIGatewayV2HTTPResponse, error) {
+	guard := idempotencyGuardKey(sub, key)
+	guard["TxSK"] = item["SK"]
+	guard["RequestHash"] = &types.AttributeValueMemberS{Value: requestHash(body)}
+	guard["ExpiresAt"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Add(idempotencyTTL).Unix())}
+
+	_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:           aws.String(table),
+				Item:                guard,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			}},
+			{Put: &types.Put{TableName: aws.String(table), Item: item}},
+		},
+	})
+	if err == nil {
+		return jsonResp(201, created)
+	}
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) || len(tce.CancellationReasons) == 0 || aws.ToString(tce.CancellationReasons[0].Code) != "ConditionalCheckFailed" {
+		return errResp(500, "put failed")
+	}
+
+	// Replay: the key is taken.
+	out, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            idempotencyGuardKey(sub, key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || out.Item == nil {
+		return errResp(500, "idempotency lookup failed")
+	}
+	if h, _ := out.Item["RequestHash"].(*types.AttributeValueMemberS); h == nil || h.Value != requestHash(body) {
+		return errResp(422, "Idempotency-Key was already used with a different request")
+	}
+	prev, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			"SK": out.Item["TxSK"],
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return errResp(500, "idempotency lookup failed")
+	}
+	if prev.Item == nil {
+		// created then deleted; don't resurrect it
+		return errResp(409, "transaction for this Idempotency-Key no longer exists")
+	}
+	var t Transaction
+	if err := attributevalue.UnmarshalMap(prev.Item, &t); err != nil {
+		return errResp(500, "unmarshal failed")
+	}
+	return jsonResp(200, t)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/imports"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Uploaded CSVs live under imports/<sub>/ in ANALYTICS_BUCKET; a caller can
+// only import objects under its own prefix.
+func importPrefix(sub string) string {
+	return fmt.Sprintf("imports/%s/", sub)
+}
+
+// importUploadURL hands out a presigned PUT for files too large to post inline.
+func importUploadURL(ctx context.Context, sub string) (events.APIGatewayV2HTTPResponse, error) {
+	bucket := strings.TrimSpace(os.Getenv("ANALYTICS_BUCKET"))
+	if bucket == "" {
+		return errResp(500, "ANALYTICS_BUCKET not set")
+	}
+	state, err := randomState(12)
+	if err != nil {
+		return errResp(500, "failed to generate key")
+	}
+	key := importPrefix(sub) + time.Now().UTC().Format("20060102T150405Z") + "-" + state + ".csv"
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	presigned, err := s3.NewPresignClient(s3.NewFromConfig(cfg)).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("text/csv"),
+	}, s3.WithPresignExpires(15*time.Minute))
+	if err != nil {
+		return errResp(500, "failed to presign upload")
+	}
+
+	return jsonResp(200, map[string]any{
+		"uploadUrl": presigned.URL,
+		"s3Key":     key,
+		"headers":   map[string]string{"content-type": "text/csv"},
+		"expiresIn": 900,
+	})
+}
+
+// importTransactions accepts either the CSV itself (content-type text/csv) or
+// {"s3Key": "..."} pointing at a file uploaded via importUploadURL.
+func importTransactions(ctx context.Context, client *dynamodb.Client, table, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return errResp(400, "invalid base64 body")
+		}
+		body = b
+	}
+
+	var src io.Reader = bytes.NewReader(body)
+	if strings.HasPrefix(strings.ToLower(req.Headers["content-type"]), "application/json") {
+		var in struct {
+			S3Key string `json:"s3Key"`
+		}
+		if err := json.Unmarshal(body, &in); err != nil || strings.TrimSpace(in.S3Key) == "" {
+			return errResp(400, "expected {\"s3Key\": ...} or a text/csv body")
+		}
+		if !strings.HasPrefix(in.S3Key, importPrefix(sub)) || strings.Contains(in.S3Key, "..") {
+			return errResp(403, "forbidden s3Key")
+		}
+		bucket := strings.TrimSpace(os.Getenv("ANALYTICS_BUCKET"))
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return errResp(500, "failed to load aws config")
+		}
+		obj, err := s3.NewFromConfig(cfg).GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(in.S3Key),
+		})
+		if err != nil {
+			return errResp(404, "upload not found")
+		}
+		defer obj.Body.Close()
+		src = obj.Body
+	}
+
+	rows, rowErrs, err := imports.Parse(src)
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+	if len(rowErrs) > 0 {
+		return jsonResp(400, map[string]any{
+			"error":  "invalid rows; nothing was imported",
+			"errors": rowErrs,
+		})
+	}
+	if len(rows) == 0 {
+		return errResp(400, "no rows")
+	}
+
+	// Closed months only take adjustments.
+	closed := map[string]bool{}
+	for _, r := range rows {
+		m := r.Date.Format("2006-01")
+		if _, ok := closed[m]; ok {
+			continue
+		}
+		c, err := monthClosed(ctx, client, table, sub, m)
+		if err != nil {
+			return errResp(500, "close lookup failed")
+		}
+		closed[m] = c
+	}
+	for _, r := range rows {
+		if m := r.Date.Format("2006-01"); closed[m] {
+			rowErrs = append(rowErrs, imports.RowError{Line: r.Line, Error: fmt.Sprintf("month %s is closed", m)})
+		}
+	}
+	if len(rowErrs) > 0 {
+		return jsonResp(400, map[string]any{
+			"error":  "rows in closed months; nothing was imported",
+			"errors": rowErrs,
+		})
+	}
+
+	res, err := imports.Write(ctx, client, table, sub, rows)
+	if err != nil {
+		// Rows written so far stay; re-running the import skips them.
+		return jsonResp(500, map[string]any{
+			"error":      "import failed part-way; retry to import the rest",
+			"imported":   res.Imported,
+			"duplicates": res.Duplicates,
+		})
+	}
+
+	return jsonResp(200, map[string]any{
+		"ok":         true,
+		"rows":       len(rows),
+		"imported":   res.Imported,
+		"duplicates": res.Duplicates,
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/purge"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// purgeTransactions serves DELETE /transactions?source=&shop=: it queues a
+// hard delete of every transaction from that source (and shop). Manual,
+// split and adjustment rows can't be purged this way; allocations go with
+// their split row, and rows in a closed month are kept (purge.Page). A
+// Shopify shop has to be disconnected first, or its webhooks would
+// start refilling the slate right away.
+func purgeTransactions(ctx context.Context, client *dynamodb.Client, sub string, q map[string]string) (events.APIGatewayV2HTTPResponse, error) {
+	source := strings.ToLower(strings.TrimSpace(q["source"]))
+	shop := strings.ToLower(strings.TrimSpace(q["shop"]))
+	if source == "" || source == "manual" || source == "split" || source == purge.SourceAdjustment {
+		return errResp(400, "source must be an integration source")
+	}
+	if source == "shopify" {
+		if shop == "" {
+			return errResp(400, "shop is required")
+		}
+		out, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(db.IntegrationsTableName()),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+				"SK": &types.AttributeValueMemberS{Value: "SHOPIFY#" + shop},
+			},
+			ProjectionExpression: aws.String("PK"),
+		})
+		if err != nil {
+			return errResp(500, "shop lookup failed")
+		}
+		if out.Item != nil {
+			return errResp(409, "disconnect the shop before purging its transactions")
+		}
+	}
+
+	job := purge.Job{
+		Sub:         sub,
+		Source:      source,
+		Shop:        shop,
+		RequestedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := purge.Send(ctx, job); err != nil {
+		return errResp(500, "failed to queue purge")
+	}
+	return jsonResp(202, map[string]any{
+		"status":      "queued",
+		"source":      source,
+		"shop":        shop,
+		"requestedAt": job.RequestedAt,
+	})
+}
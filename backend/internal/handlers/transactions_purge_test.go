@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPurgeTransactionsRejectsLockedSources(t *testing.T) {
+	for _, source := range []string{"", "manual", "split", "adjustment", "Adjustment"} {
+		resp, err := purgeTransactions(context.Background(), nil, "u", map[string]string{"source": source})
+		if err != nil || resp.StatusCode != 400 {
+			t.Errorf("source %q: status %d, err %v; want 400", source, resp.StatusCode, err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Receipts live under receipts/<sub>/ in ANALYTICS_BUCKET, next to CSV
+// imports. The client uploads straight to S3 with a presigned PUT, then
+// attaches the key to a transaction; reads hand back a presigned GET.
+
+const (
+	maxReceiptBytes = 10 << 20
+	receiptURLTTL   = 15 * time.Minute
+)
+
+var receiptTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/heic":      ".heic",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+func receiptPrefix(sub string) string {
+	return fmt.Sprintf("receipts/%s/", sub)
+}
+
+// receiptUploadURL serves POST /transactions/receipts/upload-url with
+// {"contentType": "image/jpeg"}.
+func receiptUploadURL(ctx context.Context, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in struct {
+		ContentType string `json:"contentType"`
+	}
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	ct := strings.ToLower(strings.TrimSpace(in.ContentType))
+	ext, ok := receiptTypes[ct]
+	if !ok {
+		return errResp(400, "contentType must be a jpeg, png, heic, webp image or a pdf")
+	}
+	bucket := strings.TrimSpace(os.Getenv("ANALYTICS_BUCKET"))
+	if bucket == "" {
+		return errResp(500, "ANALYTICS_BUCKET not set")
+	}
+	state, err := randomState(12)
+	if err != nil {
+		return errResp(500, "failed to generate key")
+	}
+	key := receiptPrefix(sub) + time.Now().UTC().Format("20060102T150405Z") + "-" + state + ext
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errResp(500, "failed to load aws config")
+	}
+	presigned, err := s3.NewPresignClient(s3.NewFromConfig(cfg)).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(ct),
+	}, s3.WithPresignExpires(receiptURLTTL))
+	if err != nil {
+		return errResp(500, "failed to presign upload")
+	}
+
+	return jsonResp(200, map[string]any{
+		"uploadUrl":  presigned.URL,
+		"receiptKey": key,
+		"headers":    map[string]string{"content-type": ct},
+		"maxBytes":   maxReceiptBytes,
+		"expiresIn":  int(receiptURLTTL.Seconds()),
+	})
+}
+
+type AttachReceiptRequest struct {
+	Id         string `json:"id"`
+	ReceiptKey string `json:"receiptKey"` // empty detaches
+}
+
+// attachReceipt serves PUT /transactions/receipt with {"id", "receiptKey"}.
+// The object must already be uploaded; detaching leaves it in S3.
+func attachReceipt(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in AttachReceiptRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	if strings.TrimSpace(in.Id) == "" {
+		return errResp(400, "id is required")
+	}
+	key := strings.TrimSpace(in.ReceiptKey)
+
+	up := &dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			"SK": &types.AttributeValueMemberS{Value: in.Id},
+		},
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ReturnValues:        types.ReturnValueAllNew,
+	}
+	if key == "" {
+		up.UpdateExpression = aws.String("REMOVE ReceiptKey")
+	} else {
+		if !strings.HasPrefix(key, receiptPrefix(sub)) || strings.Contains(key, "..") {
+			return errResp(403, "forbidden receiptKey")
+		}
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return errResp(500, "failed to load aws config")
+		}
+		head, err := s3.NewFromConfig(cfg).HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(strings.TrimSpace(os.Getenv("ANALYTICS_BUCKET"))),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return errResp(400, "receipt not uploaded")
+		}
+		if aws.ToInt64(head.ContentLength) > maxReceiptBytes {
+			return errResp(413, "receipt must be at most 10 MB")
+		}
+		up.UpdateExpression = aws.String("SET ReceiptKey = :k")
+		up.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":k": &types.AttributeValueMemberS{Value: key},
+		}
+	}
+
+	out, err := client.UpdateItem(ctx, up)
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return errResp(404, "transaction not found")
+	}
+	if err != nil {
+		return errResp(500, "update failed")
+	}
+
+	var t Transaction
+	if err := attributevalue.UnmarshalMap(out.Attributes, &t); err != nil {
+		return errResp(500, "unmarshal failed")
+	}
+	items := []Transaction{t}
+	signReceipts(ctx, items)
+	return jsonResp(200, items[0])
+}
+
+// signReceipts fills in ReceiptURL for items with a receipt. Failures leave
+// it empty; the listing is still useful without the links.
+func signReceipts(ctx context.Context, items []Transaction) {
+	bucket := strings.TrimSpace(os.Getenv("ANALYTICS_BUCKET"))
+	var presign *s3.PresignClient
+	for i := range items {
+		if items[i].ReceiptKey == "" || bucket == "" {
+			continue
+		}
+		if presign == nil {
+			cfg, err := config.LoadDefaultConfig(ctx)
+			if err != nil {
+				return
+			}
+			presign = s3.NewPresignClient(s3.NewFromConfig(cfg))
+		}
+		req, err := presign.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(items[i].ReceiptKey),
+		}, s3.WithPresignExpires(receiptURLTTL))
+		if err == nil {
+			items[i].ReceiptURL = req.URL
+		}
+	}
+}
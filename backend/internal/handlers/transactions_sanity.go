@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/internal/sanity"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// getSanityReport serves GET /transactions/sanity: the latest data-sanity
+// report, from the weekly job or the last on-demand run.
+func getSanityReport(ctx context.Context, client *dynamodb.Client, table, sub string) (events.APIGatewayV2HTTPResponse, error) {
+	r, err := sanity.Latest(ctx, client, table, sub)
+	if errors.Is(err, sanity.ErrNotFound) {
+		return errResp(404, "no sanity report yet")
+	}
+	if err != nil {
+		return errResp(500, "failed to load report")
+	}
+	return jsonResp(200, r)
+}
+
+// runSanityReport serves POST /transactions/sanity (check now) and POST
+// /transactions/sanity/fix (apply the automatic fixes, then check). Either
+// way the new report replaces the stored one.
+func runSanityReport(ctx context.Context, client *dynamodb.Client, table, sub string, fix bool) (events.APIGatewayV2HTTPResponse, error) {
+	fixed := 0
+	if fix {
+		n, err := sanity.FixMissingGSI(ctx, client, table, sub)
+		if err != nil {
+			return errResp(500, "fix failed")
+		}
+		fixed = n
+	}
+	r, err := sanity.Run(ctx, client, table, sub, time.Now())
+	if err != nil {
+		return errResp(500, "sanity check failed")
+	}
+	r.Fixed = fixed
+	if err := sanity.Save(ctx, client, table, sub, r); err != nil {
+		return errResp(500, "failed to save report")
+	}
+	return jsonResp(200, r)
+}
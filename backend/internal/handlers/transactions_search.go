@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"backend/internal/pagination"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// searchScanBudget bounds how many of the user's rows one search request
+// reads; the client continues with nextToken when it runs out.
+const searchScanBudget = 3000
+
+// searchStopWords are dropped from queries: every Shopify row is an order,
+// so "order #1045" should just look for "#1045".
+var searchStopWords = map[string]bool{"order": true, "orders": true}
+
+// searchTransactions serves GET /transactions/search?q=...: a case-insensitive
+// match of every term against note, order name, category, source, shop and tags.
+// Deleted transactions are not searched.
+func searchTransactions(ctx context.Context, client *dynamodb.Client, table, sub string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	q := req.QueryStringParameters
+	var terms []string
+	for _, t := range strings.Fields(strings.ToLower(q["q"])) {
+		if !searchStopWords[t] {
+			terms = append(terms, t)
+		}
+	}
+	if len(terms) == 0 {
+		return errResp(400, "q is required")
+	}
+
+	limit, err := transactionsPageSize.Limit(q["limit"])
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+
+	eks, err := pagination.DecodeToken(scopeTransactionSearch, sub, q["nextToken"])
+	if err != nil {
+		return errResp(400, "invalid nextToken")
+	}
+
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "USER#" + sub},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+
+	matches := make([]map[string]types.AttributeValue, 0, limit)
+	scanned := 0
+	for {
+		in.ExclusiveStartKey = eks
+		in.Limit = aws.Int32(100)
+		out, err := client.Query(ctx, in)
+		if err != nil {
+			return errResp(500, "query failed")
+		}
+		for i, it := range out.Items {
+			scanned++
+			if _, deleted := it["DeletedAt"]; deleted || !matchesTerms(it, terms) {
+				continue
+			}
+			matches = append(matches, it)
+			if len(matches) == limit {
+				// resume right after this item
+				eks = keyOf(it)
+				if i == len(out.Items)-1 && len(out.LastEvaluatedKey) == 0 {
+					eks = nil
+				}
+				return transactionsPage(ctx, scopeTransactionSearch, sub, matches, eks, "")
+			}
+		}
+		eks = out.LastEvaluatedKey
+		if len(eks) == 0 || scanned >= searchScanBudget {
+			return transactionsPage(ctx, scopeTransactionSearch, sub, matches, eks, "")
+		}
+	}
+}
+
+func matchesTerms(it map[string]types.AttributeValue, terms []string) bool {
+	var b strings.Builder
+	for _, k := range []string{"Note", "OrderName", "Category", "Source", "Shop"} {
+		b.WriteString(strings.ToLower(attrS(it[k])))
+		b.WriteByte(' ')
+	}
+	if tags, ok := it["Tags"].(*types.AttributeValueMemberSS); ok {
+		b.WriteString(strings.Join(tags.Value, " "))
+	}
+	hay := b.String()
+	for _, t := range terms {
+		if !strings.Contains(hay, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func keyOf(it map[string]types.AttributeValue) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"PK": it["PK"], "SK": it["SK"]}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A transaction (usually a Shopify order) can be split into category
+// allocations that sum to its amount. The parent keeps its row, marked with
+// SplitInto, and stops counting toward totals; each allocation is a row of
+// its own in the same month and at the same time:
+//
+//	SK = SPLIT#<parent SK>#<n>, ParentId = <parent SK>, Source = "split"
+
+const (
+	minSplitParts = 2
+	maxSplitParts = 20
+)
+
+type SplitAllocation struct {
+	Category string  `json:"category"`
+	Amount   float64 `json:"amount"`
+	Note     string  `json:"note,omitempty"`
+}
+
+type SplitTransactionRequest struct {
+	Id          string            `json:"id"`
+	Allocations []SplitAllocation `json:"allocations"`
+}
+
+func splitSK(parentSK string, n int) string {
+	return fmt.Sprintf("SPLIT#%s#%d", parentSK, n)
+}
+
+func cents(f float64) int64 {
+	return int64(math.Round(f * 100))
+}
+
+// splitTransaction serves POST /transactions/split. Splitting an already
+// split transaction replaces its allocations.
+func splitTransaction(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in SplitTransactionRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	in.Id = strings.TrimSpace(in.Id)
+	if in.Id == "" {
+		return errResp(400, "id is required")
+	}
+	if len(in.Allocations) < minSplitParts || len(in.Allocations) > maxSplitParts {
+		return errResp(400, fmt.Sprintf("allocations must have %d to %d entries", minSplitParts, maxSplitParts))
+	}
+	var sum int64
+	for i, a := range in.Allocations {
+		if strings.TrimSpace(a.Category) == "" || a.Amount == 0 {
+			return errResp(400, fmt.Sprintf("allocations[%d]: category and a non-zero amount are required", i))
+		}
+		sum += cents(a.Amount)
+	}
+
+	parent, err := getTransaction(ctx, client, table, sub, in.Id)
+	if err != nil {
+		return errResp(500, "get failed")
+	}
+	if parent == nil {
+		return errResp(404, "transaction not found")
+	}
+	if parent.ParentId != "" {
+		return errResp(400, "an allocation cannot be split")
+	}
+	if parent.DeletedAt != "" {
+		return errResp(400, "transaction is deleted")
+	}
+	if resp := closedMonthResp(ctx, client, table, sub, parent); resp != nil {
+		return *resp, nil
+	}
+	if sum != cents(parent.Amount) {
+		return errResp(400, fmt.Sprintf("allocations must sum to the transaction amount %s", strconv.FormatFloat(parent.Amount, 'f', 2, 64)))
+	}
+
+	now := time.Now().UTC()
+	key := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: parent.PK},
+		"SK": &types.AttributeValueMemberS{Value: parent.SK},
+	}
+	// The amount condition guards against a sync rewriting the order between
+	// our read and the write; the client can simply retry.
+	amount, err := attributevalue.Marshal(parent.Amount)
+	if err != nil {
+		return errResp(500, "marshal failed")
+	}
+	writes := []types.TransactWriteItem{{
+		Update: &types.Update{
+			TableName:           aws.String(table),
+			Key:                 key,
+			UpdateExpression:    aws.String("SET SplitInto = :n, SplitAt = :at"),
+			ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(DeletedAt) AND Amount = :amt"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":n":   &types.AttributeValueMemberN{Value: strconv.Itoa(len(in.Allocations))},
+				":at":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+				":amt": amount,
+			},
+		},
+	}, notClosedCheck(table, sub, parent)}
+
+	children := make([]Transaction, 0, len(in.Allocations))
+	for i, a := range in.Allocations {
+		child := Transaction{
+			PK:         parent.PK,
+			SK:         splitSK(parent.SK, i+1),
+			GSI1PK:     parent.GSI1PK,
+			GSI1SK:     parent.GSI1SK,
+			UserSub:    sub,
+			Amount:     a.Amount,
+			Currency:   parent.Currency,
+			Category:   strings.TrimSpace(a.Category),
+			Note:       strings.TrimSpace(a.Note),
+			CreatedAt:  parent.CreatedAt,
+			Source:     "split",
+			Shop:       parent.Shop,
+			OrderName:  parent.OrderName,
+			Tags:       parent.Tags,
+			ParentId:   parent.SK,
+			RecordedAt: now.Format(time.RFC3339Nano),
+		}
+		if child.Note == "" {
+			child.Note = parent.Note
+		}
+		av, err := attributevalue.MarshalMap(child)
+		if err != nil {
+			return errResp(500, "marshal failed")
+		}
+		writes = append(writes, types.TransactWriteItem{Put: &types.Put{TableName: aws.String(table), Item: av}})
+		children = append(children, child)
+	}
+	for n := len(in.Allocations) + 1; n <= parent.SplitInto; n++ {
+		writes = append(writes, types.TransactWriteItem{Delete: &types.Delete{
+			TableName: aws.String(table),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: parent.PK},
+				"SK": &types.AttributeValueMemberS{Value: splitSK(parent.SK, n)},
+			},
+		}})
+	}
+
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return errResp(409, "transaction changed, retry")
+	}
+	if err != nil {
+		return errResp(500, "split failed")
+	}
+
+	parent.SplitInto = len(children)
+	return jsonResp(200, map[string]any{
+		"transaction": parent,
+		"allocations": children,
+	})
+}
+
+// unsplitTransaction serves DELETE /transactions/split?id=, dropping the
+// allocations so the transaction counts as a whole again.
+func unsplitTransaction(ctx context.Context, client *dynamodb.Client, table, sub, id string) (events.APIGatewayV2HTTPResponse, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return errResp(400, "id is required")
+	}
+	parent, err := getTransaction(ctx, client, table, sub, id)
+	if err != nil {
+		return errResp(500, "get failed")
+	}
+	if parent == nil {
+		return errResp(404, "transaction not found")
+	}
+	if parent.SplitInto == 0 {
+		return errResp(400, "transaction is not split")
+	}
+	if resp := closedMonthResp(ctx, client, table, sub, parent); resp != nil {
+		return *resp, nil
+	}
+
+	writes := []types.TransactWriteItem{{
+		Update: &types.Update{
+			TableName: aws.String(table),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: parent.PK},
+				"SK": &types.AttributeValueMemberS{Value: parent.SK},
+			},
+			UpdateExpression:    aws.String("REMOVE SplitInto, SplitAt"),
+			ConditionExpression: aws.String("SplitInto = :n"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":n": &types.AttributeValueMemberN{Value: strconv.Itoa(parent.SplitInto)},
+			},
+		},
+	}, notClosedCheck(table, sub, parent)}
+	for n := 1; n <= parent.SplitInto; n++ {
+		writes = append(writes, types.TransactWriteItem{Delete: &types.Delete{
+			TableName: aws.String(table),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: parent.PK},
+				"SK": &types.AttributeValueMemberS{Value: splitSK(parent.SK, n)},
+			},
+		}})
+	}
+
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return errResp(409, "transaction changed, retry")
+	}
+	if err != nil {
+		return errResp(500, "unsplit failed")
+	}
+
+	parent.SplitInto = 0
+	parent.SplitAt = ""
+	return jsonResp(200, parent)
+}
+
+func getTransaction(ctx context.Context, client *dynamodb.Client, table, sub, id string) (*Transaction, error) {
+	out, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			"SK": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var t Transaction
+	if err := attributevalue.UnmarshalMap(out.Item, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// countable drops split parents, whose allocations count instead, and
+// deleted rows.
+func countable(items []Transaction) []Transaction {
+	out := items[:0]
+	for _, t := range items {
+		if t.SplitInto == 0 && t.DeletedAt == "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
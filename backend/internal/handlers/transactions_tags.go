@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Tags cut across categories ("black-friday", "project-x"). They are stored
+// as a string set, lowercased, so filters and rollups don't split on case.
+const (
+	maxTags   = 10
+	maxTagLen = 40
+)
+
+func normalizeTag(t string) string {
+	return strings.ToLower(strings.Join(strings.Fields(t), "-"))
+}
+
+func normalizeTags(in []string) ([]string, error) {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range in {
+		t = normalizeTag(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		if len(t) > maxTagLen {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLen)
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("at most %d tags per transaction", maxTags)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+type UpdateTransactionTagsRequest struct {
+	Id   string   `json:"id"`
+	Tags []string `json:"tags"`
+}
+
+// updateTransactionTags serves PATCH /transactions with {"id", "tags"},
+// replacing the transaction's tags (an empty list clears them).
+func updateTransactionTags(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in UpdateTransactionTagsRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	if strings.TrimSpace(in.Id) == "" {
+		return errResp(400, "id is required")
+	}
+	tags, err := normalizeTags(in.Tags)
+	if err != nil {
+		return errResp(400, err.Error())
+	}
+
+	up := &dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			"SK": &types.AttributeValueMemberS{Value: in.Id},
+		},
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ReturnValues:        types.ReturnValueAllNew,
+	}
+	if len(tags) == 0 {
+		up.UpdateExpression = aws.String("REMOVE Tags")
+	} else {
+		up.UpdateExpression = aws.String("SET Tags = :tags")
+		up.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":tags": &types.AttributeValueMemberSS{Value: tags},
+		}
+	}
+	out, err := client.UpdateItem(ctx, up)
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return errResp(404, "transaction not found")
+	}
+	if err != nil {
+		return errResp(500, "update failed")
+	}
+
+	var t Transaction
+	if err := attributevalue.UnmarshalMap(out.Attributes, &t); err != nil {
+		return errResp(500, "unmarshal failed")
+	}
+	return jsonResp(200, t)
+}
+
+// TagSummary is one tag's totals in one currency for a month.
+type TagSummary struct {
+	Tag      string `json:"tag"`
+	Currency string `json:"currency"`
+	CurrencyTotals
+	ByCategory map[string]float64 `json:"byCategory"`
+}
+
+// summaryTags serves GET /summary/tags?month=YYYY-MM: income, expense and net
+// per tag, split by category. A transaction with several tags counts toward
+// each of them; untagged transactions are left out.
+func summaryTags(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	month := strings.TrimSpace(req.QueryStringParameters["month"])
+	if len(month) != 7 || month[4] != '-' {
+		return errResp(400, "month is required in format YYYY-MM")
+	}
+	table := db.TransactionsTableName()
+	if strings.TrimSpace(table) == "" {
+		return errResp(500, "TRANSACTIONS_TABLE is not set")
+	}
+
+	client, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	items, err := queryMonthTransactions(ctx, client, table, sub, month)
+	if err != nil {
+		return errResp(500, "query failed")
+	}
+
+	byKey := map[string]*TagSummary{}
+	for _, t := range items {
+		for _, tag := range t.Tags {
+			k := tag + "|" + t.Currency
+			s := byKey[k]
+			if s == nil {
+				s = &TagSummary{Tag: tag, Currency: t.Currency, ByCategory: map[string]float64{}}
+				byKey[k] = s
+			}
+			s.add(t)
+			s.ByCategory[t.Category] += t.Amount
+		}
+	}
+
+	out := make([]*TagSummary, 0, len(byKey))
+	for _, s := range byKey {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Tag != out[j].Tag {
+			return out[i].Tag < out[j].Tag
+		}
+		return out[i].Currency < out[j].Currency
+	})
+	return jsonResp(200, map[string]any{"month": month, "items": out})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"backend/internal/users"
+	"backend/internal/vat"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A cost can record how much of it was VAT. Amount stays the gross figure
+// every report sums; NetAmount, VATAmount and VATReclaimable let the VAT
+// report (summary_vat.go) show what can be claimed back and what the cost
+// really was.
+
+type SetTransactionVATRequest struct {
+	Id  string     `json:"id"`
+	VAT *vat.Input `json:"vat"`
+}
+
+// resolveVAT splits a cost of amount under in and the caller's VAT settings.
+func resolveVAT(ctx context.Context, client *dynamodb.Client, sub string, amount float64, in vat.Input) (*vat.Components, *events.APIGatewayV2HTTPResponse) {
+	if amount >= 0 {
+		resp, _ := errResp(400, "vat can only be recorded on expenses")
+		return nil, &resp
+	}
+	s, err := users.GetSettings(ctx, client, sub)
+	if err != nil {
+		resp, _ := errResp(500, "failed to load settings")
+		return nil, &resp
+	}
+	c, err := vat.Resolve(amount, in, s.VAT)
+	if err != nil {
+		resp, _ := errResp(400, err.Error())
+		return nil, &resp
+	}
+	return &c, nil
+}
+
+func setVAT(t *Transaction, c vat.Components) {
+	rate, v, net := c.Rate, c.VAT, c.Net
+	t.VATCountry, t.VATRate, t.VATAmount, t.NetAmount, t.VATReclaimable = c.Country, &rate, &v, &net, c.Reclaimable
+}
+
+// setTransactionVAT serves PUT /transactions/vat with {"id", "vat"}; a
+// missing or null vat clears the split. Any expense can carry VAT, synced
+// ones included, since the split doesn't touch the amount.
+func setTransactionVAT(ctx context.Context, client *dynamodb.Client, table, sub, body string) (events.APIGatewayV2HTTPResponse, error) {
+	var in SetTransactionVATRequest
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		return errResp(400, "invalid json body")
+	}
+	t, resp := ownTransaction(ctx, client, table, sub, in.Id)
+	if resp != nil {
+		return *resp, nil
+	}
+
+	up := &types.Update{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: t.PK},
+			"SK": &types.AttributeValueMemberS{Value: t.SK},
+		},
+		// The split is only valid for the amount it was made from.
+		ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(DeletedAt) AND attribute_not_exists(SplitInto) AND Amount = :amt"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":amt": &types.AttributeValueMemberN{Value: fmt.Sprint(t.Amount)},
+		},
+	}
+	if in.VAT == nil {
+		up.UpdateExpression = aws.String("REMOVE VATCountry, VATRate, VATAmount, NetAmount, VATReclaimable")
+		t.VATCountry, t.VATRate, t.VATAmount, t.NetAmount, t.VATReclaimable = "", nil, nil, nil, false
+	} else {
+		c, resp := resolveVAT(ctx, client, sub, t.Amount, *in.VAT)
+		if resp != nil {
+			return *resp, nil
+		}
+		up.UpdateExpression = aws.String("SET VATCountry = :c, VATRate = :r, VATAmount = :v, NetAmount = :n, VATReclaimable = :rec")
+		up.ExpressionAttributeValues[":c"] = &types.AttributeValueMemberS{Value: c.Country}
+		up.ExpressionAttributeValues[":r"] = &types.AttributeValueMemberN{Value: fmt.Sprint(c.Rate)}
+		up.ExpressionAttributeValues[":v"] = &types.AttributeValueMemberN{Value: fmt.Sprint(c.VAT)}
+		up.ExpressionAttributeValues[":n"] = &types.AttributeValueMemberN{Value: fmt.Sprint(c.Net)}
+		up.ExpressionAttributeValues[":rec"] = &types.AttributeValueMemberBOOL{Value: c.Reclaimable}
+		setVAT(t, *c)
+	}
+	_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Update: up},
+			notClosedCheck(table, sub, t),
+		},
+	})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return errResp(409, "transaction changed, retry")
+	}
+	if err != nil {
+		return errResp(500, "update failed")
+	}
+	return jsonResp(200, t)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/metering"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// UsageHandler serves GET /usage: what the caller's AI features (/ask,
+// its SQL fixes, summaries, digests) spent, as Bedrock tokens and Athena
+// scans with their estimated cost, for ?from=YYYY-MM-DD&to=YYYY-MM-DD
+// (default: last 30 days, at most 93), grouped by ?groupBy=day|feature|model
+// (default day). Today's /ask quota comes along.
+func UsageHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	if req.RequestContext.HTTP.Method != "GET" {
+		return errResp(405, "method not allowed")
+	}
+
+	q := req.QueryStringParameters
+	from, to, msg := usageRange(q, 30)
+	if msg != "" {
+		return errResp(400, msg)
+	}
+	groupBy := strings.TrimSpace(q["groupBy"])
+	switch groupBy {
+	case "":
+		groupBy = "day"
+	case "day", "feature", "model":
+	default:
+		return errResp(400, "groupBy must be day, feature or model")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+	items, err := metering.LoadUser(ctx, ddb, sub, from, to)
+	if err != nil {
+		return errResp(500, "usage query failed")
+	}
+	today, err := metering.GetNLQUsage(ctx, ddb, sub)
+	if err != nil {
+		fmt.Printf("usage: load nlq quota user=%s: %v\n", sub, err)
+	}
+
+	rows := make([]*metering.Usage, 0)
+	for _, u := range metering.Rollup(items, groupBy) {
+		u.UserSub = ""
+		rows = append(rows, u)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if groupBy == "day" {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].CostUSD > rows[j].CostUSD
+	})
+
+	return jsonResp(200, map[string]any{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"groupBy": groupBy,
+		"total":   metering.Total(items),
+		"items":   rows,
+		"quota": map[string]any{
+			"queries":      today.Queries,
+			"queryLimit":   metering.NLQQueryLimit(),
+			"scannedBytes": today.ScannedBytes,
+			"scanBudget":   metering.NLQScanBudget(),
+		},
+	})
+}
+
+// usageRange reads ?from and ?to as UTC days; to defaults to today and
+// from to days-1 before it. msg is the 400 message of a bad range.
+func usageRange(q map[string]string, days int) (from, to time.Time, msg string) {
+	to = time.Now().UTC().Truncate(24 * time.Hour)
+	if v := strings.TrimSpace(q["to"]); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, "invalid to"
+		}
+		to = t
+	}
+	from = to.AddDate(0, 0, -(days - 1))
+	if v := strings.TrimSpace(q["from"]); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, "invalid from"
+		}
+		from = t
+	}
+	if from.After(to) || to.Sub(from) > 92*24*time.Hour {
+		return from, to, "range must be 1-93 days"
+	}
+	return from, to, ""
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/xero"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func XeroHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RawPath {
+	case "/integrations/xero/connect":
+		return xeroConnect(ctx, req)
+	case "/integrations/xero/callback":
+		return xeroCallback(ctx, req)
+	case "/integrations/xero":
+		switch req.RequestContext.HTTP.Method {
+		case "GET":
+			return xeroStatus(ctx, req)
+		case "PUT":
+			return xeroSetAccounts(ctx, req)
+		case "DELETE":
+			return xeroDisconnect(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	case "/integrations/xero/sync":
+		if req.RequestContext.HTTP.Method == "POST" {
+			return xeroSync(ctx, req)
+		}
+		return errResp(405, "method not allowed")
+	default:
+		return errResp(404, "not found")
+	}
+}
+
+func xeroRedirectURI() (string, error) {
+	base, err := getApiBaseUrl()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base, "/") + "/integrations/xero/callback", nil
+}
+
+func xeroConnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+	if strings.TrimSpace(os.Getenv("XERO_CLIENT_ID")) == "" {
+		return errResp(500, "XERO_CLIENT_ID not set")
+	}
+
+	state, err := randomState(24)
+	if err != nil {
+		return errResp(500, "failed to generate state")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	if strings.TrimSpace(stateTable) == "" {
+		return errResp(500, "OAUTH_STATE_TABLE not set")
+	}
+
+	exp := time.Now().UTC().Add(10 * time.Minute).Unix()
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(stateTable),
+		Item: map[string]types.AttributeValue{
+			"State":          &types.AttributeValueMemberS{Value: state},
+			"UserSub":        &types.AttributeValueMemberS{Value: sub},
+			"Provider":       &types.AttributeValueMemberS{Value: xero.Source},
+			"ExpiresAtEpoch": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
+		},
+	})
+	if err != nil {
+		return errResp(500, "failed to store oauth state")
+	}
+
+	redirectURI, err := xeroRedirectURI()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+
+	return jsonResp(200, map[string]any{
+		"authorizeUrl": xero.AuthorizeURL(state, redirectURI),
+	})
+}
+
+func xeroCallback(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	params := req.QueryStringParameters
+	fe := strings.TrimRight(os.Getenv("FRONTEND_BASE_URL"), "/")
+	if fe == "" {
+		fe = "/"
+	}
+
+	// User declined consent
+	if params["error"] != "" {
+		return events.APIGatewayV2HTTPResponse{
+			StatusCode: 302,
+			Headers:    map[string]string{"location": fe + "/xero?connected=0"},
+		}, nil
+	}
+
+	state := strings.TrimSpace(params["state"])
+	code := strings.TrimSpace(params["code"])
+	if state == "" || code == "" {
+		return errResp(400, "missing required oauth params")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	stateTable := db.OAuthStateTableName()
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return errResp(400, "invalid or expired state")
+	}
+	sub := attrS(out.Item["UserSub"])
+	if sub == "" || attrS(out.Item["Provider"]) != xero.Source {
+		return errResp(400, "state mismatch")
+	}
+
+	redirectURI, err := xeroRedirectURI()
+	if err != nil {
+		return errResp(500, "failed to get API base URL")
+	}
+	tok, err := xero.ExchangeCode(ctx, code, redirectURI)
+	if err != nil {
+		return errResp(502, "token exchange failed")
+	}
+
+	conns, err := xero.Connections(ctx, tok.AccessToken)
+	if err != nil {
+		return errResp(502, "failed to list xero organisations")
+	}
+	var conn *xero.Connection
+	for i := range conns {
+		if conns[i].TenantType == "ORGANISATION" {
+			conn = &conns[i]
+			break
+		}
+	}
+	if conn == nil {
+		return errResp(400, "no xero organisation was authorised")
+	}
+
+	baseCurrency, err := xero.BaseCurrency(ctx, tok.AccessToken, conn.TenantId)
+	if err != nil {
+		return errResp(502, "failed to read xero organisation")
+	}
+
+	if err := xero.SaveConnection(ctx, ddb, sub, tok, *conn, baseCurrency); err != nil {
+		return errResp(500, "failed to store integration")
+	}
+
+	_, _ = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(stateTable),
+		Key: map[string]types.AttributeValue{
+			"State": &types.AttributeValueMemberS{Value: state},
+		},
+	})
+
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: 302,
+		Headers:    map[string]string{"location": fe + "/xero?connected=1"},
+	}, nil
+}
+
+func xeroStatus(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integ, err := xero.LoadIntegration(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load integration")
+	}
+	if integ == nil {
+		return jsonResp(200, map[string]any{"connected": false})
+	}
+	revenue, expense, clearing := integ.Accounts()
+	return jsonResp(200, map[string]any{
+		"connected":   true,
+		"integration": integ,
+		"accounts": map[string]string{
+			"revenue":  revenue,
+			"expense":  expense,
+			"clearing": clearing,
+		},
+	})
+}
+
+type xeroAccountsRequest struct {
+	RevenueAccountCode  string `json:"revenueAccountCode"`
+	ExpenseAccountCode  string `json:"expenseAccountCode"`
+	ClearingAccountCode string `json:"clearingAccountCode"`
+}
+
+// xeroSetAccounts serves PUT /integrations/xero with the chart-of-accounts codes to post to.
+func xeroSetAccounts(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	var body xeroAccountsRequest
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		return errResp(400, "invalid json")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integ, err := xero.LoadIntegration(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load integration")
+	}
+	if integ == nil {
+		return errResp(404, "xero not connected")
+	}
+
+	if err := xero.SetAccounts(ctx, ddb, sub, body.RevenueAccountCode, body.ExpenseAccountCode, body.ClearingAccountCode); err != nil {
+		return errResp(500, "failed to save accounts")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+// xeroDisconnect removes the connection. Mirror items are kept so reconnecting
+// the same organisation updates the existing journals instead of duplicating them.
+func xeroDisconnect(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	_, err = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.IntegrationsTableName()),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK": &types.AttributeValueMemberS{Value: "XERO"},
+		},
+	})
+	if err != nil {
+		return errResp(500, "delete failed")
+	}
+	return jsonResp(200, map[string]any{"ok": true})
+}
+
+// xeroSync serves POST /integrations/xero/sync[?month=YYYY-MM] (default: current month).
+func xeroSync(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	sub, _, err := userSub(req)
+	if err != nil {
+		return errResp(401, "unauthorized")
+	}
+
+	month := strings.TrimSpace(req.QueryStringParameters["month"])
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		return errResp(400, "month must be YYYY-MM")
+	}
+
+	ddb, err := db.NewDynamoClient(ctx)
+	if err != nil {
+		return errResp(500, "failed to init dynamodb")
+	}
+
+	integ, err := xero.LoadIntegration(ctx, ddb, sub)
+	if err != nil {
+		return errResp(500, "failed to load integration")
+	}
+	if integ == nil {
+		return errResp(404, "xero not connected")
+	}
+
+	res, err := xero.SyncMonth(ctx, ddb, integ, month)
+	if err != nil {
+		return errResp(502, err.Error())
+	}
+	return jsonResp(200, map[string]any{"ok": true, "result": res})
+}
@@ -0,0 +1,267 @@
+package imports
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	Source = "import"
+
+	// MaxRows bounds one import so it fits comfortably in a Lambda run.
+	MaxRows = 10000
+)
+
+// Columns are the accepted CSV headers; note is optional.
+var Columns = []string{"date", "amount", "currency", "category", "note", "external_id"}
+
+var required = []string{"date", "amount", "currency", "category", "external_id"}
+
+// Row is one validated CSV line. Amount keeps its sign: costs are negative,
+// like every other expense transaction.
+type Row struct {
+	Line       int
+	Date       time.Time
+	Amount     float64
+	Currency   string
+	Category   string
+	Note       string
+	ExternalID string
+}
+
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Parse reads and validates a whole CSV. The header row may list the columns
+// in any order. Any row error rejects the file, so an import is all or
+// nothing; duplicate external_ids within the file are errors too.
+func Parse(r io.Reader) ([]Row, []RowError, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid csv: %w", err)
+	}
+	idx := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		idx[h] = i
+	}
+	for _, c := range required {
+		if _, ok := idx[c]; !ok {
+			return nil, nil, fmt.Errorf("missing column %q (expected %s)", c, strings.Join(Columns, ","))
+		}
+	}
+
+	col := func(rec []string, name string) string {
+		i, ok := idx[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	var rows []Row
+	var rowErrs []RowError
+	seen := map[string]int{}
+	line := 1
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			rowErrs = append(rowErrs, RowError{Line: line, Error: err.Error()})
+			continue
+		}
+		if len(rec) == 1 && strings.TrimSpace(rec[0]) == "" {
+			continue
+		}
+		if len(rows)+len(rowErrs) >= MaxRows {
+			return nil, nil, fmt.Errorf("too many rows (max %d)", MaxRows)
+		}
+
+		row, err := parseRow(line, col(rec, "date"), col(rec, "amount"), col(rec, "currency"), col(rec, "category"), col(rec, "note"), col(rec, "external_id"))
+		if err == nil {
+			if first, dup := seen[row.ExternalID]; dup {
+				err = fmt.Errorf("external_id %q repeats line %d", row.ExternalID, first)
+			}
+		}
+		if err != nil {
+			rowErrs = append(rowErrs, RowError{Line: line, Error: err.Error()})
+			continue
+		}
+		seen[row.ExternalID] = line
+		rows = append(rows, row)
+	}
+	return rows, rowErrs, nil
+}
+
+func parseRow(line int, date, amount, currency, category, note, externalID string) (Row, error) {
+	r := Row{Line: line, Category: category, Note: note, ExternalID: externalID}
+
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, date)
+	}
+	if err != nil {
+		return r, fmt.Errorf("invalid date %q (use YYYY-MM-DD)", date)
+	}
+	r.Date = t.UTC()
+
+	a, err := strconv.ParseFloat(strings.ReplaceAll(amount, ",", ""), 64)
+	if err != nil || a == 0 {
+		return r, fmt.Errorf("invalid amount %q", amount)
+	}
+	r.Amount = a
+
+	r.Currency = strings.ToUpper(currency)
+	if len(r.Currency) != 3 {
+		return r, fmt.Errorf("invalid currency %q", currency)
+	}
+	if r.Category == "" {
+		return r, errors.New("category is required")
+	}
+	if r.ExternalID == "" || len(r.ExternalID) > 128 {
+		return r, errors.New("external_id is required (max 128 chars)")
+	}
+	return r, nil
+}
+
+// SortKey is deterministic in the external id, which is what dedupes re-imports.
+func SortKey(externalID string) string {
+	return "IMPORT#" + externalID
+}
+
+type Result struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+}
+
+// Write stores rows that are not already in the table, 100 key lookups and 25
+// puts at a time. Re-importing the same file is a no-op.
+func Write(ctx context.Context, ddb *dynamodb.Client, table, sub string, rows []Row) (*Result, error) {
+	pk := fmt.Sprintf("USER#%s", sub)
+	res := &Result{}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	for i := 0; i < len(rows); i += 100 {
+		end := i + 100
+		if end > len(rows) {
+			end = len(rows)
+		}
+		chunk := rows[i:end]
+
+		existing, err := existingKeys(ctx, ddb, table, pk, chunk)
+		if err != nil {
+			return res, err
+		}
+
+		var puts []types.WriteRequest
+		for _, r := range chunk {
+			sk := SortKey(r.ExternalID)
+			if existing[sk] {
+				res.Duplicates++
+				continue
+			}
+			puts = append(puts, types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+				"PK":         &types.AttributeValueMemberS{Value: pk},
+				"SK":         &types.AttributeValueMemberS{Value: sk},
+				"GSI1PK":     &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, r.Date.Format("2006-01"))},
+				"GSI1SK":     &types.AttributeValueMemberS{Value: r.Date.Format(time.RFC3339Nano)},
+				"UserSub":    &types.AttributeValueMemberS{Value: sub},
+				"Amount":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", r.Amount)},
+				"Currency":   &types.AttributeValueMemberS{Value: r.Currency},
+				"Category":   &types.AttributeValueMemberS{Value: r.Category},
+				"Note":       &types.AttributeValueMemberS{Value: r.Note},
+				"CreatedAt":  &types.AttributeValueMemberS{Value: r.Date.Format(time.RFC3339)},
+				"Source":     &types.AttributeValueMemberS{Value: Source},
+				"ExternalId": &types.AttributeValueMemberS{Value: r.ExternalID},
+				"ImportedAt": &types.AttributeValueMemberS{Value: now},
+				"RecordedAt": &types.AttributeValueMemberS{Value: now},
+			}}})
+		}
+
+		for j := 0; j < len(puts); j += 25 {
+			e := j + 25
+			if e > len(puts) {
+				e = len(puts)
+			}
+			if err := batchWrite(ctx, ddb, table, puts[j:e]); err != nil {
+				return res, err
+			}
+			res.Imported += e - j
+		}
+	}
+	return res, nil
+}
+
+func existingKeys(ctx context.Context, ddb *dynamodb.Client, table, pk string, rows []Row) (map[string]bool, error) {
+	keys := make([]map[string]types.AttributeValue, 0, len(rows))
+	for _, r := range rows {
+		keys = append(keys, map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pk},
+			"SK": &types.AttributeValueMemberS{Value: SortKey(r.ExternalID)},
+		})
+	}
+
+	found := map[string]bool{}
+	req := map[string]types.KeysAndAttributes{
+		table: {Keys: keys, ProjectionExpression: aws.String("SK")},
+	}
+	for attempt := 0; len(req) > 0 && attempt < 5; attempt++ {
+		out, err := ddb.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: req})
+		if err != nil {
+			return nil, fmt.Errorf("BatchGetItem: %w", err)
+		}
+		for _, it := range out.Responses[table] {
+			if s, ok := it["SK"].(*types.AttributeValueMemberS); ok {
+				found[s.Value] = true
+			}
+		}
+		req = out.UnprocessedKeys
+		if len(req) > 0 {
+			time.Sleep(time.Duration(attempt+1) * 200 * time.Millisecond)
+		}
+	}
+	if len(req) > 0 {
+		return nil, errors.New("BatchGetItem: unprocessed keys")
+	}
+	return found, nil
+}
+
+func batchWrite(ctx context.Context, ddb *dynamodb.Client, table string, reqs []types.WriteRequest) error {
+	items := map[string][]types.WriteRequest{table: reqs}
+	for attempt := 0; len(items[table]) > 0 && attempt < 5; attempt++ {
+		out, err := ddb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: items})
+		if err != nil {
+			return fmt.Errorf("BatchWriteItem: %w", err)
+		}
+		items = out.UnprocessedItems
+		if len(items[table]) > 0 {
+			time.Sleep(time.Duration(attempt+1) * 200 * time.Millisecond)
+		}
+	}
+	if n := len(items[table]); n > 0 {
+		return fmt.Errorf("BatchWriteItem: %d unprocessed", n)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/restate"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MaxEvents bounds one delivery.
+const MaxEvents = 500
+
+// Mapping tells the endpoint where each transaction field lives in the
+// sender's JSON. Paths are dot-separated ("data.total.amount"); array
+// elements are addressed by index ("lines.0.price").
+type Mapping struct {
+	// EventsPath points at an array of events; empty means the body is one
+	// event, or a top-level array of events.
+	EventsPath string `dynamodbav:"EventsPath,omitempty" json:"eventsPath,omitempty"`
+
+	Amount      string `dynamodbav:"Amount" json:"amount"`
+	AmountMinor bool   `dynamodbav:"AmountMinor,omitempty" json:"amountMinor,omitempty"` // amounts are in cents
+	// Kind is "cost" (stored negative), "revenue" (stored positive) or
+	// "signed" (stored as sent).
+	Kind string `dynamodbav:"Kind" json:"kind"`
+
+	Currency        string `dynamodbav:"Currency,omitempty" json:"currency,omitempty"`
+	DefaultCurrency string `dynamodbav:"DefaultCurrency,omitempty" json:"defaultCurrency,omitempty"`
+	Date            string `dynamodbav:"Date,omitempty" json:"date,omitempty"`
+	Category        string `dynamodbav:"Category,omitempty" json:"category,omitempty"`
+	DefaultCategory string `dynamodbav:"DefaultCategory,omitempty" json:"defaultCategory,omitempty"`
+	Note            string `dynamodbav:"Note,omitempty" json:"note,omitempty"`
+	// ExternalID dedupes redeliveries; without it the event body's hash is used.
+	ExternalID string `dynamodbav:"ExternalID,omitempty" json:"externalId,omitempty"`
+}
+
+func (m *Mapping) Validate() error {
+	if strings.TrimSpace(m.Amount) == "" {
+		return errors.New("mapping.amount is required")
+	}
+	switch m.Kind {
+	case "":
+		m.Kind = "signed"
+	case "cost", "revenue", "signed":
+	default:
+		return errors.New("mapping.kind must be cost, revenue or signed")
+	}
+	if m.Currency == "" && len(strings.TrimSpace(m.DefaultCurrency)) != 3 {
+		return errors.New("mapping.currency or a 3-letter mapping.defaultCurrency is required")
+	}
+	if m.Category == "" && strings.TrimSpace(m.DefaultCategory) == "" {
+		return errors.New("mapping.category or mapping.defaultCategory is required")
+	}
+	m.DefaultCurrency = strings.ToUpper(strings.TrimSpace(m.DefaultCurrency))
+	return nil
+}
+
+// Event is one mapped transaction.
+type Event struct {
+	ExternalID string    `json:"externalId"`
+	At         time.Time `json:"at"`
+	Amount     float64   `json:"amount"`
+	Currency   string    `json:"currency"`
+	Category   string    `json:"category"`
+	Note       string    `json:"note"`
+}
+
+type EventError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// Apply maps a delivery body into events. Events that cannot be mapped are
+// reported by index; the rest are still returned.
+func (m Mapping) Apply(body []byte, now time.Time) ([]Event, []EventError, error) {
+	var root any
+	dec := json.NewDecoder(strings.NewReader(string(body)))
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return nil, nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	var raw []any
+	switch {
+	case m.EventsPath != "":
+		arr, ok := lookup(root, m.EventsPath).([]any)
+		if !ok {
+			return nil, nil, fmt.Errorf("%q is not an array", m.EventsPath)
+		}
+		raw = arr
+	default:
+		if arr, ok := root.([]any); ok {
+			raw = arr
+		} else {
+			raw = []any{root}
+		}
+	}
+	if len(raw) > MaxEvents {
+		return nil, nil, fmt.Errorf("too many events (max %d)", MaxEvents)
+	}
+
+	var out []Event
+	var errs []EventError
+	for i, ev := range raw {
+		e, err := m.one(ev, now)
+		if err != nil {
+			errs = append(errs, EventError{Index: i, Error: err.Error()})
+			continue
+		}
+		out = append(out, e)
+	}
+	return out, errs, nil
+}
+
+func (m Mapping) one(ev any, now time.Time) (Event, error) {
+	e := Event{At: now.UTC()}
+
+	amt, ok := toFloat(lookup(ev, m.Amount))
+	if !ok || amt == 0 {
+		return e, fmt.Errorf("no amount at %q", m.Amount)
+	}
+	if m.AmountMinor {
+		amt /= 100
+	}
+	switch m.Kind {
+	case "cost":
+		amt = -math.Abs(amt)
+	case "revenue":
+		amt = math.Abs(amt)
+	}
+	e.Amount = amt
+
+	e.Currency = m.DefaultCurrency
+	if m.Currency != "" {
+		if c := strings.ToUpper(toString(lookup(ev, m.Currency))); c != "" {
+			e.Currency = c
+		}
+	}
+	if len(e.Currency) != 3 {
+		return e, fmt.Errorf("no currency at %q", m.Currency)
+	}
+
+	e.Category = m.DefaultCategory
+	if m.Category != "" {
+		if c := toString(lookup(ev, m.Category)); c != "" {
+			e.Category = c
+		}
+	}
+	if e.Category == "" {
+		return e, fmt.Errorf("no category at %q", m.Category)
+	}
+
+	if m.Date != "" {
+		t, ok := toTime(lookup(ev, m.Date))
+		if !ok {
+			return e, fmt.Errorf("no date at %q", m.Date)
+		}
+		e.At = t.UTC()
+	}
+	if m.Note != "" {
+		e.Note = toString(lookup(ev, m.Note))
+	}
+
+	if m.ExternalID != "" {
+		e.ExternalID = toString(lookup(ev, m.ExternalID))
+		if e.ExternalID == "" {
+			return e, fmt.Errorf("no external id at %q", m.ExternalID)
+		}
+	} else {
+		b, _ := json.Marshal(ev)
+		sum := sha256.Sum256(b)
+		e.ExternalID = hex.EncodeToString(sum[:16])
+	}
+	if len(e.ExternalID) > 128 {
+		return e, errors.New("external id longer than 128 chars")
+	}
+	return e, nil
+}
+
+// Write stores events for sub; redeliveries of the same external id are
+// skipped. It returns how many were new.
+func Write(ctx context.Context, ddb *dynamodb.Client, txTable, sub, sourceKey string, evs []Event) (int, error) {
+	written := 0
+	for _, e := range evs {
+		item := map[string]types.AttributeValue{
+			"PK":         &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK":         &types.AttributeValueMemberS{Value: fmt.Sprintf("INGEST#%s#%s", sourceKey, e.ExternalID)},
+			"GSI1PK":     &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, e.At.Format("2006-01"))},
+			"GSI1SK":     &types.AttributeValueMemberS{Value: e.At.Format(time.RFC3339Nano)},
+			"UserSub":    &types.AttributeValueMemberS{Value: sub},
+			"Amount":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", e.Amount)},
+			"Currency":   &types.AttributeValueMemberS{Value: e.Currency},
+			"Category":   &types.AttributeValueMemberS{Value: e.Category},
+			"Note":       &types.AttributeValueMemberS{Value: e.Note},
+			"CreatedAt":  &types.AttributeValueMemberS{Value: e.At.Format(time.RFC3339)},
+			"Source":     &types.AttributeValueMemberS{Value: Source},
+			"SourceKey":  &types.AttributeValueMemberS{Value: sourceKey},
+			"ExternalId": &types.AttributeValueMemberS{Value: e.ExternalID},
+		}
+		restate.Stamp(item)
+		_, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(txTable),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+		})
+		if err != nil {
+			var cfe *types.ConditionalCheckFailedException
+			if errors.As(err, &cfe) {
+				continue
+			}
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+func lookup(v any, path string) any {
+	if path == "" {
+		return nil
+	}
+	for _, part := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]any:
+			v = t[part]
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil
+			}
+			v = t[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return ""
+}
+
+func toFloat(v any) (float64, bool) {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(t), ",", ""), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// toTime accepts RFC3339, YYYY-MM-DD, or Unix seconds/milliseconds.
+func toTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case string:
+		if tm, err := time.Parse(time.RFC3339, t); err == nil {
+			return tm, true
+		}
+		if tm, err := time.Parse("2006-01-02", t); err == nil {
+			return tm, true
+		}
+		if n, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return unixAny(n), true
+		}
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return unixAny(n), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func unixAny(n int64) time.Time {
+	if n > 1e12 {
+		return time.UnixMilli(n)
+	}
+	return time.Unix(n, 0)
+}
@@ -0,0 +1,293 @@
+package ingest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/security"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	Source = "ingest"
+
+	// maxClockSkew bounds how old a signed delivery may be (replay window).
+	maxClockSkew = 5 * time.Minute
+)
+
+// ErrNotFound is returned for unknown or deleted source keys.
+var ErrNotFound = errors.New("ingest source not found")
+
+// SourceConfig mirrors one inbound webhook source in INTEGRATIONS_TABLE.
+// PK = USER#<sub>
+// SK = INGEST#<sourceKey>
+//
+// The public endpoint resolves sourceKey -> user through a reverse item:
+// PK = INGESTKEY#<sourceKey>
+// SK = SOURCE
+type SourceConfig struct {
+	PK          string  `dynamodbav:"PK" json:"-"`
+	SK          string  `dynamodbav:"SK" json:"-"`
+	SourceKey   string  `dynamodbav:"SourceKey" json:"sourceKey"`
+	Name        string  `dynamodbav:"Name" json:"name"`
+	SecretEnc   string  `dynamodbav:"SecretEnc" json:"-"`
+	Mapping     Mapping `dynamodbav:"Mapping" json:"mapping"`
+	CreatedAt   string  `dynamodbav:"CreatedAt" json:"createdAt"`
+	UpdatedAt   string  `dynamodbav:"UpdatedAt,omitempty" json:"updatedAt,omitempty"`
+	LastEventAt string  `dynamodbav:"LastEventAt,omitempty" json:"lastEventAt,omitempty"`
+}
+
+func (s SourceConfig) UserSub() string {
+	return strings.TrimPrefix(s.PK, "USER#")
+}
+
+func userKey(sub, sourceKey string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+		"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("INGEST#%s", sourceKey)},
+	}
+}
+
+func reverseKey(sourceKey string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("INGESTKEY#%s", sourceKey)},
+		"SK": &types.AttributeValueMemberS{Value: "SOURCE"},
+	}
+}
+
+func table() (string, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return "", fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	return tbl, nil
+}
+
+// Create stores a new source with its encrypted signing secret and the
+// reverse lookup item.
+func Create(ctx context.Context, ddb *dynamodb.Client, sub, sourceKey, secret, name string, m Mapping) (*SourceConfig, error) {
+	tbl, err := table()
+	if err != nil {
+		return nil, err
+	}
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	enc, err := security.EncryptAESGCM(key, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &SourceConfig{
+		PK:        fmt.Sprintf("USER#%s", sub),
+		SK:        fmt.Sprintf("INGEST#%s", sourceKey),
+		SourceKey: sourceKey,
+		Name:      name,
+		SecretEnc: enc,
+		Mapping:   m,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	item, err := attributevalue.MarshalMap(src)
+	if err != nil {
+		return nil, err
+	}
+	rev := reverseKey(sourceKey)
+	rev["UserSub"] = &types.AttributeValueMemberS{Value: sub}
+
+	_, err = ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{TableName: aws.String(tbl), Item: item, ConditionExpression: aws.String("attribute_not_exists(PK)")}},
+			{Put: &types.Put{TableName: aws.String(tbl), Item: rev, ConditionExpression: aws.String("attribute_not_exists(PK)")}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// Load returns the caller's source, or ErrNotFound.
+func Load(ctx context.Context, ddb *dynamodb.Client, sub, sourceKey string) (*SourceConfig, error) {
+	tbl, err := table()
+	if err != nil {
+		return nil, err
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key:       userKey(sub, sourceKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, ErrNotFound
+	}
+	var src SourceConfig
+	if err := attributevalue.UnmarshalMap(out.Item, &src); err != nil {
+		return nil, err
+	}
+	return &src, nil
+}
+
+// Resolve finds the source behind a public sourceKey.
+func Resolve(ctx context.Context, ddb *dynamodb.Client, sourceKey string) (*SourceConfig, error) {
+	tbl, err := table()
+	if err != nil {
+		return nil, err
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key:       reverseKey(sourceKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sub := ""
+	if s, ok := out.Item["UserSub"].(*types.AttributeValueMemberS); ok {
+		sub = s.Value
+	}
+	if sub == "" {
+		return nil, ErrNotFound
+	}
+	return Load(ctx, ddb, sub, sourceKey)
+}
+
+func List(ctx context.Context, ddb *dynamodb.Client, sub string) ([]SourceConfig, error) {
+	tbl, err := table()
+	if err != nil {
+		return nil, err
+	}
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tbl),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			":p":  &types.AttributeValueMemberS{Value: "INGEST#"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := []SourceConfig{}
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// UpdateMapping replaces the field mapping (and optionally the name).
+func UpdateMapping(ctx context.Context, ddb *dynamodb.Client, sub, sourceKey, name string, m Mapping) error {
+	tbl, err := table()
+	if err != nil {
+		return err
+	}
+	mv, err := attributevalue.Marshal(m)
+	if err != nil {
+		return err
+	}
+	expr := "SET Mapping = :m, UpdatedAt = :u"
+	vals := map[string]types.AttributeValue{
+		":m": mv,
+		":u": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+	}
+	if name != "" {
+		expr += ", #n = :n"
+		vals[":n"] = &types.AttributeValueMemberS{Value: name}
+	}
+	in := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tbl),
+		Key:                       userKey(sub, sourceKey),
+		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: vals,
+	}
+	if name != "" {
+		in.ExpressionAttributeNames = map[string]string{"#n": "Name"}
+	}
+	_, err = ddb.UpdateItem(ctx, in)
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Delete removes the source and its reverse item; already ingested
+// transactions stay.
+func Delete(ctx context.Context, ddb *dynamodb.Client, sub, sourceKey string) error {
+	tbl, err := table()
+	if err != nil {
+		return err
+	}
+	_, err = ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Delete: &types.Delete{TableName: aws.String(tbl), Key: userKey(sub, sourceKey), ConditionExpression: aws.String("attribute_exists(PK)")}},
+			{Delete: &types.Delete{TableName: aws.String(tbl), Key: reverseKey(sourceKey)}},
+		},
+	})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// VerifySignature checks X-TrueProfit-Signature: hex(HMAC-SHA256(secret,
+// "<timestamp>.<body>")), where timestamp is X-TrueProfit-Timestamp in Unix
+// seconds and must be within maxClockSkew of now.
+func (s *SourceConfig) VerifySignature(timestamp string, body []byte, signature string, now time.Time) bool {
+	ts, err := strconv.ParseInt(strings.TrimSpace(timestamp), 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > maxClockSkew || d < -maxClockSkew {
+		return false
+	}
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return false
+	}
+	secret, err := security.DecryptAESGCM(key, s.SecretEnc)
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.TrimSpace(timestamp)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// Touch records the last accepted delivery.
+func Touch(ctx context.Context, ddb *dynamodb.Client, sub, sourceKey string) {
+	tbl, err := table()
+	if err != nil {
+		return
+	}
+	_, _ = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              userKey(sub, sourceKey),
+		UpdateExpression: aws.String("SET LastEventAt = :a"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":a": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+}
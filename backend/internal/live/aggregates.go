@@ -0,0 +1,292 @@
+package live
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Live aggregates give the dashboard "today" and "month to date" numbers
+// within seconds of a webhook, ahead of the nightly daily_metrics ETL.
+//
+// LIVE_AGGREGATES_TABLE
+// PK = USER#<sub>
+// SK = DAY#<YYYY-MM-DD>                  all shops
+// SK = DAY#<YYYY-MM-DD>#SHOP#<shop>
+// SK = MONTH#<YYYY-MM>                   all shops
+// SK = MONTH#<YYYY-MM>#SHOP#<shop>
+//
+// Days and months are bucketed in ETL_TIMEZONE so they line up with daily_metrics.
+
+// DayRetention is how long day buckets are kept.
+const DayRetention = 45 * 24 * time.Hour
+
+func TableName() string {
+	return strings.TrimSpace(os.Getenv("LIVE_AGGREGATES_TABLE"))
+}
+
+func Location() *time.Location {
+	tz := strings.TrimSpace(os.Getenv("ETL_TIMEZONE"))
+	if tz == "" {
+		tz = "Asia/Ho_Chi_Minh"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Delta is the change one webhook makes to a shop's totals.
+type Delta struct {
+	Shop     string
+	Currency string
+	At       time.Time // order/refund time; picks the day and month bucket
+	Gross    float64   // sales delta (negative when an order total was lowered)
+	Refunds  float64   // positive refunded amount
+	Orders   int       // 1 for a first-seen order, 0 for updates
+}
+
+type Totals struct {
+	Period    string  `json:"period"` // YYYY-MM-DD or YYYY-MM
+	Currency  string  `json:"currency"`
+	Gross     float64 `json:"gross"`
+	Refunds   float64 `json:"refunds"`
+	Net       float64 `json:"net"`
+	Orders    int     `json:"orders"`
+	UpdatedAt string  `json:"updatedAt,omitempty"`
+}
+
+// Apply bumps the day and month counters (overall and per shop) for one user.
+func Apply(ctx context.Context, ddb *dynamodb.Client, sub string, d Delta) error {
+	tbl := TableName()
+	if tbl == "" {
+		return nil
+	}
+	if d.Gross == 0 && d.Refunds == 0 && d.Orders == 0 {
+		return nil
+	}
+
+	local := d.At.In(Location())
+	day := local.Format("2006-01-02")
+	month := local.Format("2006-01")
+	now := time.Now().UTC()
+
+	type bucket struct {
+		sk  string
+		ttl time.Duration
+	}
+	dayTTL, monthTTL := DayRetention, 400*24*time.Hour
+	keys := []bucket{
+		{"DAY#" + day, dayTTL},
+		{"MONTH#" + month, monthTTL},
+	}
+	if d.Shop != "" {
+		keys = append(keys,
+			bucket{"DAY#" + day + "#SHOP#" + d.Shop, dayTTL},
+			bucket{"MONTH#" + month + "#SHOP#" + d.Shop, monthTTL},
+		)
+	}
+
+	for _, k := range keys {
+		_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(tbl),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+				"SK": &types.AttributeValueMemberS{Value: k.sk},
+			},
+			UpdateExpression: aws.String("ADD Gross :g, Refunds :r, Net :n, Orders :o SET Currency = if_not_exists(Currency, :c), UpdatedAt = :u, ExpiresAt = :exp"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":g":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", d.Gross)},
+				":r":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", d.Refunds)},
+				":n":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", d.Gross-d.Refunds)},
+				":o":   &types.AttributeValueMemberN{Value: strconv.Itoa(d.Orders)},
+				":c":   &types.AttributeValueMemberS{Value: d.Currency},
+				":u":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+				":exp": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(k.ttl).Unix())},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("live aggregate %s: %w", k.sk, err)
+		}
+	}
+	return nil
+}
+
+// Batch sums deltas per user, shop, currency and day so a burst of webhooks
+// costs one Apply per bucket instead of one per event. The zero value is
+// ready to use.
+type Batch struct {
+	deltas map[batchKey]*Delta
+	keys   []batchKey
+}
+
+type batchKey struct {
+	sub, shop, currency, day string
+}
+
+func (b *Batch) Add(sub string, d Delta) {
+	k := batchKey{sub, d.Shop, d.Currency, d.At.In(Location()).Format("2006-01-02")}
+	if b.deltas == nil {
+		b.deltas = map[batchKey]*Delta{}
+	}
+	cur, ok := b.deltas[k]
+	if !ok {
+		c := d
+		b.deltas[k] = &c
+		b.keys = append(b.keys, k)
+		return
+	}
+	cur.Gross += d.Gross
+	cur.Refunds += d.Refunds
+	cur.Orders += d.Orders
+}
+
+// Flush applies the summed deltas in the order they were first added and
+// empties the batch. onErr is called for each bucket that failed; the rest
+// are still applied.
+func (b *Batch) Flush(ctx context.Context, ddb *dynamodb.Client, onErr func(sub string, d Delta, err error)) {
+	for _, k := range b.keys {
+		d := *b.deltas[k]
+		if err := Apply(ctx, ddb, k.sub, d); err != nil && onErr != nil {
+			onErr(k.sub, d, err)
+		}
+	}
+	b.deltas, b.keys = nil, nil
+}
+
+// TodayAndMTD reads the current day and month totals, for one shop or all shops when shop is empty.
+func TodayAndMTD(ctx context.Context, ddb *dynamodb.Client, sub, shop string, now time.Time) (Totals, Totals, error) {
+	local := now.In(Location())
+	today := Totals{Period: local.Format("2006-01-02")}
+	mtd := Totals{Period: local.Format("2006-01")}
+
+	tbl := TableName()
+	if tbl == "" {
+		return today, mtd, fmt.Errorf("LIVE_AGGREGATES_TABLE not set")
+	}
+
+	if err := load(ctx, ddb, tbl, sub, "DAY#", shop, &today); err != nil {
+		return today, mtd, err
+	}
+	if err := load(ctx, ddb, tbl, sub, "MONTH#", shop, &mtd); err != nil {
+		return today, mtd, err
+	}
+	return today, mtd, nil
+}
+
+// Month reads one month's totals (kept ~400 days), for one shop or all shops.
+func Month(ctx context.Context, ddb *dynamodb.Client, sub, shop, month string) (Totals, error) {
+	t := Totals{Period: month}
+	tbl := TableName()
+	if tbl == "" {
+		return t, fmt.Errorf("LIVE_AGGREGATES_TABLE not set")
+	}
+	err := load(ctx, ddb, tbl, sub, "MONTH#", shop, &t)
+	return t, err
+}
+
+// ShopDay is one shop's totals for one day.
+type ShopDay struct {
+	Shop string
+	Totals
+}
+
+// ShopDays reads every per-shop day bucket in [from, to] (YYYY-MM-DD) with a
+// single query. Unlike the all-shops buckets, each has one currency.
+func ShopDays(ctx context.Context, ddb *dynamodb.Client, sub, from, to string) ([]ShopDay, error) {
+	tbl := TableName()
+	if tbl == "" {
+		return nil, fmt.Errorf("LIVE_AGGREGATES_TABLE not set")
+	}
+	var (
+		days     []ShopDay
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk AND SK BETWEEN :from AND :to"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":   &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+				":from": &types.AttributeValueMemberS{Value: "DAY#" + from},
+				":to":   &types.AttributeValueMemberS{Value: "DAY#" + to + "#~"},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query live days: %w", err)
+		}
+		for _, it := range out.Items {
+			sk, _ := it["SK"].(*types.AttributeValueMemberS)
+			if sk == nil {
+				continue
+			}
+			day, shop, ok := strings.Cut(strings.TrimPrefix(sk.Value, "DAY#"), "#SHOP#")
+			if !ok {
+				continue
+			}
+			d := ShopDay{Shop: shop, Totals: totalsOf(it)}
+			d.Period = day
+			days = append(days, d)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return days, nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
+
+func load(ctx context.Context, ddb *dynamodb.Client, tbl, sub, prefix, shop string, t *Totals) error {
+	suffix := ""
+	if shop != "" {
+		suffix = "#SHOP#" + shop
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK": &types.AttributeValueMemberS{Value: prefix + t.Period + suffix},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("get live aggregate: %w", err)
+	}
+	if out.Item == nil {
+		return nil
+	}
+	period := t.Period
+	*t = totalsOf(out.Item)
+	t.Period = period
+	return nil
+}
+
+func totalsOf(item map[string]types.AttributeValue) Totals {
+	var t Totals
+	t.Gross = numAttr(item["Gross"])
+	t.Refunds = numAttr(item["Refunds"])
+	t.Net = numAttr(item["Net"])
+	t.Orders = int(numAttr(item["Orders"]))
+	if v, ok := item["Currency"].(*types.AttributeValueMemberS); ok {
+		t.Currency = v.Value
+	}
+	if v, ok := item["UpdatedAt"].(*types.AttributeValueMemberS); ok {
+		t.UpdatedAt = v.Value
+	}
+	return t
+}
+
+func numAttr(av types.AttributeValue) float64 {
+	if n, ok := av.(*types.AttributeValueMemberN); ok {
+		f, _ := strconv.ParseFloat(n.Value, 64)
+		return f
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package margin
+
+import (
+	"fmt"
+	"math"
+
+	"backend/internal/shopify"
+)
+
+// Model is a user's variable cost model: the costs that scale with each
+// order line, on top of what the product itself cost (see package costs).
+type Model struct {
+	PaymentPct       float64 `json:"paymentPct"`       // payment processing, % of line revenue
+	PickPackPerLine  float64 `json:"pickPackPerLine"`  // flat pick-and-pack fee per line
+	PackagingPerUnit float64 `json:"packagingPerUnit"` // packaging per unit shipped
+}
+
+func (m Model) Validate() error {
+	if m.PaymentPct < 0 || m.PaymentPct > 100 {
+		return fmt.Errorf("paymentPct must be between 0 and 100")
+	}
+	if m.PickPackPerLine < 0 || m.PackagingPerUnit < 0 {
+		return fmt.Errorf("pickPackPerLine and packagingPerUnit must not be negative")
+	}
+	return nil
+}
+
+// Line is one order line with its variable costs and contribution margin.
+type Line struct {
+	shopify.LineItem
+	Revenue            float64  `json:"revenue"`
+	ProductCost        float64  `json:"productCost"`
+	CostSource         string   `json:"costSource,omitempty"` // "order", "sku", or empty when unknown
+	PaymentFees        float64  `json:"paymentFees"`
+	PickPack           float64  `json:"pickPack"`
+	Packaging          float64  `json:"packaging"`
+	VariableCosts      float64  `json:"variableCosts"`
+	ContributionMargin float64  `json:"contributionMargin"`
+	MarginPct          *float64 `json:"marginPct"` // nil without revenue
+}
+
+// Apply costs one line under m, given what its goods cost.
+func (m Model) Apply(l shopify.LineItem, productCost float64) Line {
+	out := Line{LineItem: l, Revenue: round2(l.Revenue()), ProductCost: round2(productCost)}
+	out.PaymentFees = round2(out.Revenue * m.PaymentPct / 100)
+	out.PickPack = round2(m.PickPackPerLine)
+	out.Packaging = round2(m.PackagingPerUnit * float64(l.Quantity))
+	out.VariableCosts = round2(out.PaymentFees + out.PickPack + out.Packaging)
+	out.ContributionMargin = round2(out.Revenue - out.ProductCost - out.VariableCosts)
+	out.MarginPct = pct(out.ContributionMargin, out.Revenue)
+	return out
+}
+
+// Totals sums costed lines (an order, or a product across orders).
+type Totals struct {
+	Units              int      `json:"units"`
+	Revenue            float64  `json:"revenue"`
+	ProductCost        float64  `json:"productCost"`
+	VariableCosts      float64  `json:"variableCosts"`
+	ContributionMargin float64  `json:"contributionMargin"`
+	MarginPct          *float64 `json:"marginPct"`
+}
+
+func (t *Totals) Add(l Line) {
+	t.Units += l.Quantity
+	t.Revenue = round2(t.Revenue + l.Revenue)
+	t.ProductCost = round2(t.ProductCost + l.ProductCost)
+	t.VariableCosts = round2(t.VariableCosts + l.VariableCosts)
+	t.ContributionMargin = round2(t.ContributionMargin + l.ContributionMargin)
+	t.MarginPct = pct(t.ContributionMargin, t.Revenue)
+}
+
+// Merge adds o, totals of other lines, to t.
+func (t *Totals) Merge(o Totals) {
+	t.Units += o.Units
+	t.Revenue = round2(t.Revenue + o.Revenue)
+	t.ProductCost = round2(t.ProductCost + o.ProductCost)
+	t.VariableCosts = round2(t.VariableCosts + o.VariableCosts)
+	t.ContributionMargin = round2(t.ContributionMargin + o.ContributionMargin)
+	t.MarginPct = pct(t.ContributionMargin, t.Revenue)
+}
+
+func pct(n, d float64) *float64 {
+	if d == 0 {
+		return nil
+	}
+	v := math.Round(n/d*10000) / 100
+	return &v
+}
+
+func round2(v float64) float64 { return math.Round(v*100) / 100 }
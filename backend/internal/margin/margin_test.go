@@ -0,0 +1,27 @@
+package margin
+
+import (
+	"reflect"
+	"testing"
+
+	"backend/internal/shopify"
+)
+
+func TestTotalsMergeMatchesAdd(t *testing.T) {
+	a := Model{PaymentPct: 2.9, PickPackPerLine: 1}
+	b := Model{PackagingPerUnit: 0.5}
+	l1 := shopify.LineItem{SKU: "X", Quantity: 2, UnitPrice: 10}
+	l2 := shopify.LineItem{SKU: "X", Quantity: 1, UnitPrice: 12, Discount: 2}
+
+	// One member's lines under one model, another's under theirs.
+	var all, first, second Totals
+	all.Add(a.Apply(l1, 8))
+	all.Add(b.Apply(l2, 4))
+	first.Add(a.Apply(l1, 8))
+	second.Add(b.Apply(l2, 4))
+	first.Merge(second)
+
+	if !reflect.DeepEqual(first, all) {
+		t.Fatalf("merged %+v, want %+v", first, all)
+	}
+}
@@ -0,0 +1,55 @@
+package metering
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ModelAthena is the model of the usage aggregates that count Athena
+// queries: Calls are queries and ScannedBytes what they scanned.
+const ModelAthena = "athena"
+
+// Athena bills scanned data per TB, with a 10 MB minimum per query.
+const (
+	defaultAthenaUSDPerTB = 5.0
+	athenaMinBytes        = 10 << 20
+)
+
+// AthenaCost estimates the USD cost of one query that scanned
+// scannedBytes. ATHENA_PRICE_PER_TB overrides the list price.
+func AthenaCost(scannedBytes int64) float64 {
+	if scannedBytes <= 0 {
+		return 0
+	}
+	perTB := defaultAthenaUSDPerTB
+	if v := strings.TrimSpace(os.Getenv("ATHENA_PRICE_PER_TB")); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			perTB = f
+		}
+	}
+	if scannedBytes < athenaMinBytes {
+		scannedBytes = athenaMinBytes
+	}
+	return float64(scannedBytes) / 1e12 * perTB
+}
+
+// RecordAthena adds one query's scan to today's aggregates for the
+// attributed user and feature, like RecordBedrock.
+func RecordAthena(ctx context.Context, scannedBytes int64) {
+	a, ok := ctx.Value(attributionKey{}).(attribution)
+	if !ok || scannedBytes <= 0 {
+		return
+	}
+	cost := AthenaCost(scannedBytes)
+	if a.tally != nil {
+		a.tally.add(0, 0, scannedBytes, cost)
+	}
+	record(ctx, a, ModelAthena, "ADD Calls :one, ScannedBytes :b, CostUSD :c", map[string]types.AttributeValue{
+		":b": &types.AttributeValueMemberN{Value: strconv.FormatInt(scannedBytes, 10)},
+		":c": &types.AttributeValueMemberN{Value: strconv.FormatFloat(cost, 'f', 6, 64)},
+	})
+}
@@ -0,0 +1,339 @@
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Features that call Bedrock.
+const (
+	FeatureAsk       = "ask"
+	FeatureFix       = "fix"
+	FeatureSummarize = "summarize"
+	FeatureDigest    = "digest"
+)
+
+// retention keeps roughly a year of daily usage for trend and billing reviews.
+const retention = 400 * 24 * time.Hour
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("USAGE_METERING_TABLE"))
+}
+
+type attributionKey struct{}
+
+type attribution struct {
+	ddb     *dynamodb.Client
+	userSub string
+	feature string
+	tally   *tally
+}
+
+// WithAttribution tags Bedrock calls made with ctx as spent by userSub on
+// feature. Calls without attribution are not metered.
+func WithAttribution(ctx context.Context, ddb *dynamodb.Client, userSub, feature string) context.Context {
+	return context.WithValue(ctx, attributionKey{}, attribution{ddb: ddb, userSub: userSub, feature: feature, tally: &tally{}})
+}
+
+// tally is what the calls made with one attributed context spent, whatever
+// feature they were charged to: the cost of one ask or digest run.
+type tally struct {
+	mu           sync.Mutex
+	inputTokens  int
+	outputTokens int
+	scannedBytes int64
+	costUSD      float64
+}
+
+func (t *tally) add(in, out int, scanned int64, cost float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inputTokens += in
+	t.outputTokens += out
+	t.scannedBytes += scanned
+	t.costUSD += cost
+}
+
+// Spent returns the tally of ctx's attribution; zero without one.
+func Spent(ctx context.Context) (inputTokens, outputTokens int, scannedBytes int64, costUSD float64) {
+	a, ok := ctx.Value(attributionKey{}).(attribution)
+	if !ok || a.tally == nil {
+		return 0, 0, 0, 0
+	}
+	a.tally.mu.Lock()
+	defer a.tally.mu.Unlock()
+	return a.tally.inputTokens, a.tally.outputTokens, a.tally.scannedBytes, a.tally.costUSD
+}
+
+// WithFeature keeps the user but charges calls to a different feature, e.g.
+// the SQL fix loop inside an ask request.
+func WithFeature(ctx context.Context, feature string) context.Context {
+	a, ok := ctx.Value(attributionKey{}).(attribution)
+	if !ok {
+		return ctx
+	}
+	a.feature = feature
+	return context.WithValue(ctx, attributionKey{}, a)
+}
+
+// price is USD per million tokens.
+type price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// defaultPrices are on-demand list prices; BEDROCK_PRICING_JSON
+// ({"<model id>": {"input": 3, "output": 15}}) overrides or extends them.
+var defaultPrices = map[string]price{
+	"anthropic.claude-3-5-sonnet-20240620-v1:0": {Input: 3, Output: 15},
+	"anthropic.claude-3-5-sonnet-20241022-v2:0": {Input: 3, Output: 15},
+	"anthropic.claude-3-5-haiku-20241022-v1:0":  {Input: 0.8, Output: 4},
+	"anthropic.claude-3-haiku-20240307-v1:0":    {Input: 0.25, Output: 1.25},
+}
+
+func priceFor(modelID string) (price, bool) {
+	if raw := strings.TrimSpace(os.Getenv("BEDROCK_PRICING_JSON")); raw != "" {
+		var m map[string]price
+		if err := json.Unmarshal([]byte(raw), &m); err == nil {
+			if p, ok := m[modelID]; ok {
+				return p, true
+			}
+		}
+	}
+	// Cross-region inference profiles prefix the model id ("us.anthropic...").
+	id := modelID
+	if i := strings.Index(id, "anthropic."); i > 0 {
+		id = id[i:]
+	}
+	p, ok := defaultPrices[id]
+	return p, ok
+}
+
+// Cost estimates the USD cost of one call; unknown models cost 0.
+func Cost(modelID string, inputTokens, outputTokens int) float64 {
+	p, ok := priceFor(modelID)
+	if !ok {
+		return 0
+	}
+	return (float64(inputTokens)*p.Input + float64(outputTokens)*p.Output) / 1e6
+}
+
+// RecordBedrock adds one call's token counts to today's aggregates for the
+// attributed user and feature. It is best effort: metering never fails the
+// request that made the call.
+//
+// Two items per (day, user, feature, model):
+// PK = USER#<sub>   SK = DAY#<date>#FEATURE#<feature>#MODEL#<model>  (per-user views)
+// PK = DAY#<date>   SK = USER#<sub>#FEATURE#<feature>#MODEL#<model>  (admin rollups)
+//
+// Athena queries are kept the same way under the model ModelAthena (see
+// RecordAthena).
+func RecordBedrock(ctx context.Context, modelID string, inputTokens, outputTokens int) {
+	a, ok := ctx.Value(attributionKey{}).(attribution)
+	if !ok {
+		return
+	}
+	cost := Cost(modelID, inputTokens, outputTokens)
+	if a.tally != nil {
+		a.tally.add(inputTokens, outputTokens, 0, cost)
+	}
+	record(ctx, a, modelID, "ADD Calls :one, InputTokens :in, OutputTokens :out, CostUSD :c", map[string]types.AttributeValue{
+		":in":  &types.AttributeValueMemberN{Value: strconv.Itoa(inputTokens)},
+		":out": &types.AttributeValueMemberN{Value: strconv.Itoa(outputTokens)},
+		":c":   &types.AttributeValueMemberN{Value: strconv.FormatFloat(cost, 'f', 6, 64)},
+	})
+}
+
+// record applies add, an ADD clause over :one and values, to today's two
+// aggregate items of a's user and feature for modelID.
+func record(ctx context.Context, a attribution, modelID, add string, values map[string]types.AttributeValue) {
+	tbl := Table()
+	if a.ddb == nil || tbl == "" {
+		return
+	}
+
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+	exp := now.Add(retention).Unix()
+
+	keys := []map[string]types.AttributeValue{
+		{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + a.userSub},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("DAY#%s#FEATURE#%s#MODEL#%s", day, a.feature, modelID)},
+		},
+		{
+			"PK": &types.AttributeValueMemberS{Value: "DAY#" + day},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#FEATURE#%s#MODEL#%s", a.userSub, a.feature, modelID)},
+		},
+	}
+	vals := map[string]types.AttributeValue{
+		":d":   &types.AttributeValueMemberS{Value: day},
+		":u":   &types.AttributeValueMemberS{Value: a.userSub},
+		":f":   &types.AttributeValueMemberS{Value: a.feature},
+		":m":   &types.AttributeValueMemberS{Value: modelID},
+		":e":   &types.AttributeValueMemberN{Value: strconv.FormatInt(exp, 10)},
+		":one": &types.AttributeValueMemberN{Value: "1"},
+	}
+	for k, v := range values {
+		vals[k] = v
+	}
+	for _, k := range keys {
+		_, err := a.ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:        aws.String(tbl),
+			Key:              k,
+			UpdateExpression: aws.String("SET #d = :d, UserSub = :u, Feature = :f, ModelId = :m, ExpiresAt = :e " + add),
+			ExpressionAttributeNames: map[string]string{
+				"#d": "Day",
+			},
+			ExpressionAttributeValues: vals,
+		})
+		if err != nil {
+			fmt.Printf("metering: record %s user=%s feature=%s: %v\n", modelID, a.userSub, a.feature, err)
+			return
+		}
+	}
+}
+
+// Usage is one aggregate item.
+type Usage struct {
+	Day          string  `dynamodbav:"Day" json:"day"`
+	UserSub      string  `dynamodbav:"UserSub" json:"userSub"`
+	Feature      string  `dynamodbav:"Feature" json:"feature"`
+	ModelId      string  `dynamodbav:"ModelId" json:"modelId"`
+	Calls        int     `dynamodbav:"Calls" json:"calls"`
+	InputTokens  int     `dynamodbav:"InputTokens" json:"inputTokens"`
+	OutputTokens int     `dynamodbav:"OutputTokens" json:"outputTokens"`
+	ScannedBytes int64   `dynamodbav:"ScannedBytes" json:"scannedBytes"` // Athena items only
+	CostUSD      float64 `dynamodbav:"CostUSD" json:"costUsd"`
+}
+
+// LoadDays returns every aggregate for the UTC days [from, to].
+func LoadDays(ctx context.Context, ddb *dynamodb.Client, from, to time.Time) ([]Usage, error) {
+	tbl := Table()
+	if tbl == "" {
+		return nil, fmt.Errorf("USAGE_METERING_TABLE not set")
+	}
+	var out []Usage
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		var startKey map[string]types.AttributeValue
+		for {
+			res, err := ddb.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(tbl),
+				KeyConditionExpression: aws.String("PK = :pk"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":pk": &types.AttributeValueMemberS{Value: "DAY#" + d.Format("2006-01-02")},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			var items []Usage
+			if err := attributevalue.UnmarshalListOfMaps(res.Items, &items); err != nil {
+				return nil, err
+			}
+			out = append(out, items...)
+			if len(res.LastEvaluatedKey) == 0 {
+				break
+			}
+			startKey = res.LastEvaluatedKey
+		}
+	}
+	return out, nil
+}
+
+// LoadUser returns one user's aggregates for the UTC days [from, to].
+func LoadUser(ctx context.Context, ddb *dynamodb.Client, userSub string, from, to time.Time) ([]Usage, error) {
+	tbl := Table()
+	if tbl == "" {
+		return nil, fmt.Errorf("USAGE_METERING_TABLE not set")
+	}
+	var out []Usage
+	var startKey map[string]types.AttributeValue
+	for {
+		res, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk AND SK BETWEEN :a AND :b"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: "USER#" + userSub},
+				":a":  &types.AttributeValueMemberS{Value: "DAY#" + from.Format("2006-01-02")},
+				":b":  &types.AttributeValueMemberS{Value: "DAY#" + to.Format("2006-01-02") + "#~"},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var items []Usage
+		if err := attributevalue.UnmarshalListOfMaps(res.Items, &items); err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+		if len(res.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = res.LastEvaluatedKey
+	}
+	return out, nil
+}
+
+// Total sums items.
+func Total(items []Usage) Usage {
+	var t Usage
+	for _, it := range items {
+		t.Calls += it.Calls
+		t.InputTokens += it.InputTokens
+		t.OutputTokens += it.OutputTokens
+		t.ScannedBytes += it.ScannedBytes
+		t.CostUSD += it.CostUSD
+	}
+	return t
+}
+
+// Rollup sums usage by key ("user", "feature", "model" or "day").
+func Rollup(items []Usage, by string) map[string]*Usage {
+	out := map[string]*Usage{}
+	for _, it := range items {
+		k := it.Day
+		switch by {
+		case "user":
+			k = it.UserSub
+		case "feature":
+			k = it.Feature
+		case "model":
+			k = it.ModelId
+		}
+		agg, ok := out[k]
+		if !ok {
+			agg = &Usage{}
+			switch by {
+			case "user":
+				agg.UserSub = k
+			case "feature":
+				agg.Feature = k
+			case "model":
+				agg.ModelId = k
+			default:
+				agg.Day = k
+			}
+			out[k] = agg
+		}
+		agg.Calls += it.Calls
+		agg.InputTokens += it.InputTokens
+		agg.OutputTokens += it.OutputTokens
+		agg.ScannedBytes += it.ScannedBytes
+		agg.CostUSD += it.CostUSD
+	}
+	return out
+}
@@ -0,0 +1,178 @@
+package metering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The NLQ quota caps what one user can make /ask spend per UTC day: a
+// number of questions that reach the model and Athena (cache hits are free),
+// and a budget of bytes Athena scanned for them. The query count is reserved
+// atomically before the model is called; scanned bytes are only known after
+// the query, so the budget stops the next ask once it is spent.
+//
+// Kept in USAGE_METERING_TABLE:
+// PK = USER#<sub>   SK = NLQQUOTA#<date>
+//
+// Env: NLQ_DAILY_QUERY_LIMIT (default 200), NLQ_DAILY_SCAN_BYTES (default
+// 50 GiB); 0 turns a limit off.
+
+const (
+	defaultNLQQueryLimit = 200
+	defaultNLQScanBytes  = 50 << 30
+)
+
+// Limits a QuotaError can name.
+const (
+	LimitQueries      = "queries"
+	LimitScannedBytes = "scanned_bytes"
+)
+
+// QuotaError is returned by ReserveNLQQuery when the user is out of quota.
+type QuotaError struct {
+	Limit    string
+	Used     int64
+	Quota    int64
+	ResetsAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("daily %s quota exceeded (%d of %d)", e.Limit, e.Used, e.Quota)
+}
+
+// NLQUsage is a user's /ask spend for one day.
+type NLQUsage struct {
+	Queries      int64 `dynamodbav:"Queries"`
+	ScannedBytes int64 `dynamodbav:"ScannedBytes"`
+}
+
+func envLimit(name string, def int64) int64 {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return def
+}
+
+func NLQQueryLimit() int64 { return envLimit("NLQ_DAILY_QUERY_LIMIT", defaultNLQQueryLimit) }
+func NLQScanBudget() int64 { return envLimit("NLQ_DAILY_SCAN_BYTES", defaultNLQScanBytes) }
+
+func nlqQuotaKey(userSub string, now time.Time) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "USER#" + userSub},
+		"SK": &types.AttributeValueMemberS{Value: "NLQQUOTA#" + now.Format("2006-01-02")},
+	}
+}
+
+func nextUTCDay(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// ReserveNLQQuery counts one ask against today's quota, or returns a
+// *QuotaError without counting it. Like the rest of metering it fails open:
+// a metering outage logs and lets the ask through.
+func ReserveNLQQuery(ctx context.Context, ddb *dynamodb.Client, userSub string) error {
+	tbl := Table()
+	if tbl == "" {
+		return nil
+	}
+	limit, budget := NLQQueryLimit(), NLQScanBudget()
+	now := time.Now().UTC()
+
+	cond := []string{}
+	values := map[string]types.AttributeValue{
+		":one": &types.AttributeValueMemberN{Value: "1"},
+		":u":   &types.AttributeValueMemberS{Value: userSub},
+		":e":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(retention).Unix(), 10)},
+	}
+	if limit > 0 {
+		cond = append(cond, "(attribute_not_exists(Queries) OR Queries < :limit)")
+		values[":limit"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(limit, 10)}
+	}
+	if budget > 0 {
+		cond = append(cond, "(attribute_not_exists(ScannedBytes) OR ScannedBytes < :budget)")
+		values[":budget"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(budget, 10)}
+	}
+	in := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tbl),
+		Key:                       nlqQuotaKey(userSub, now),
+		UpdateExpression:          aws.String("SET UserSub = :u, ExpiresAt = :e ADD Queries :one"),
+		ExpressionAttributeValues: values,
+	}
+	if len(cond) > 0 {
+		in.ConditionExpression = aws.String(strings.Join(cond, " AND "))
+	}
+
+	_, err := ddb.UpdateItem(ctx, in)
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		usage, gerr := GetNLQUsage(ctx, ddb, userSub)
+		if gerr != nil {
+			fmt.Printf("metering: load nlq usage user=%s: %v\n", userSub, gerr)
+		}
+		qe := &QuotaError{Limit: LimitQueries, Used: usage.Queries, Quota: limit, ResetsAt: nextUTCDay(now)}
+		if budget > 0 && usage.ScannedBytes >= budget {
+			qe.Limit, qe.Used, qe.Quota = LimitScannedBytes, usage.ScannedBytes, budget
+		}
+		return qe
+	}
+	if err != nil {
+		fmt.Printf("metering: reserve nlq query user=%s: %v\n", userSub, err)
+	}
+	return nil
+}
+
+// RecordNLQScan adds the bytes an ask's Athena query scanned to today's
+// quota usage, and the query to the usage aggregates (RecordAthena). Best
+// effort, like RecordBedrock.
+func RecordNLQScan(ctx context.Context, ddb *dynamodb.Client, userSub string, scannedBytes int64) {
+	RecordAthena(ctx, scannedBytes)
+	tbl := Table()
+	if tbl == "" || scannedBytes <= 0 {
+		return
+	}
+	now := time.Now().UTC()
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              nlqQuotaKey(userSub, now),
+		UpdateExpression: aws.String("SET UserSub = :u, ExpiresAt = :e ADD ScannedBytes :b"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: userSub},
+			":e": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(retention).Unix(), 10)},
+			":b": &types.AttributeValueMemberN{Value: strconv.FormatInt(scannedBytes, 10)},
+		},
+	})
+	if err != nil {
+		fmt.Printf("metering: record nlq scan user=%s: %v\n", userSub, err)
+	}
+}
+
+// GetNLQUsage returns the user's /ask spend so far today.
+func GetNLQUsage(ctx context.Context, ddb *dynamodb.Client, userSub string) (NLQUsage, error) {
+	var u NLQUsage
+	tbl := Table()
+	if tbl == "" {
+		return u, nil
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key:       nlqQuotaKey(userSub, time.Now().UTC()),
+	})
+	if err != nil || out.Item == nil {
+		return u, err
+	}
+	err = attributevalue.UnmarshalMap(out.Item, &u)
+	return u, err
+}
@@ -0,0 +1,260 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/nlq"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Daily serves daily_metrics rows to API consumers with one canned,
+// parameterized Athena query. Closed days (older than METRICS_OPEN_DAYS,
+// i.e. outside the window the nightly ETL still rewrites) never change, so
+// their rows are kept in NLQ_CACHE_TABLE for a long time:
+//
+//	PK = METRICS#<shop>, SK = DAY#<YYYY-MM-DD>, Payload = Row JSON
+//
+// Days with no data are cached as zero rows, so gaps don't re-query Athena.
+
+// MaxDays bounds one request.
+const MaxDays = 366
+
+const closedTTL = 90 * 24 * time.Hour
+
+type Row struct {
+	Date             string  `json:"date"`
+	GrossRevenue     float64 `json:"grossRevenue"`
+	NetRevenue       float64 `json:"netRevenue"`
+	ProductCosts     float64 `json:"productCosts"`
+	MarketingCosts   float64 `json:"marketingCosts"`
+	FulfillmentCosts float64 `json:"fulfillmentCosts"`
+	ProcessingFees   float64 `json:"processingFees"`
+	OtherCosts       float64 `json:"otherCosts"`
+}
+
+// Result is what Daily returns: rows in date order plus where they came from.
+type Result struct {
+	Rows         []Row
+	CachedDays   int
+	QueriedDays  int
+	ScannedBytes int64
+}
+
+// dailySQL lines up with daily_metrics as written by the ETL. Re-runs of
+// the ETL add parts for the same partition, so rows are summed per dt.
+const dailySQL = `SELECT CAST(dt AS varchar) AS dt,
+  SUM(gross_revenue) AS gross_revenue,
+  SUM(net_revenue) AS net_revenue,
+  SUM(product_costs) AS product_costs,
+  SUM(marketing_costs) AS marketing_costs,
+  SUM(fulfillment_costs) AS fulfillment_costs,
+  SUM(processing_fees) AS processing_fees,
+  SUM(other_costs) AS other_costs
+FROM daily_metrics
+WHERE shop_id = ? AND dt BETWEEN CAST(? AS date) AND CAST(? AS date)
+GROUP BY dt
+ORDER BY dt`
+
+// openDays is how many recent days (today included) may still change.
+func openDays() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("METRICS_OPEN_DAYS"))); err == nil && n > 0 {
+		return n
+	}
+	return 2
+}
+
+// Location is the timezone days are cut in; same as the ETL.
+func Location() *time.Location {
+	name := strings.TrimSpace(os.Getenv("ETL_TIMEZONE"))
+	if name == "" {
+		name = "Asia/Ho_Chi_Minh"
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func cacheTable() string {
+	return strings.TrimSpace(os.Getenv("NLQ_CACHE_TABLE"))
+}
+
+// quote renders s as an Athena string literal for ExecutionParameters.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Daily returns one row per day in [from, to] for shop (inclusive, YYYY-MM-DD).
+// Days without data come back zeroed.
+func Daily(ctx context.Context, ddb *dynamodb.Client, ath nlq.AthenaClient, opt nlq.AthenaRunOptions, shop string, from, to time.Time) (*Result, error) {
+	closedBefore := time.Now().In(Location()).AddDate(0, 0, 1-openDays()).Format("2006-01-02")
+
+	cached, err := loadCached(ctx, ddb, shop, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		// the cache is an optimisation; fall through to Athena
+		fmt.Printf("metrics: load cache for %s failed: %v\n", shop, err)
+		cached = map[string]Row{}
+	}
+
+	res := &Result{}
+	var missFrom, missTo string
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		ds := d.Format("2006-01-02")
+		if _, ok := cached[ds]; ok {
+			continue
+		}
+		if missFrom == "" {
+			missFrom = ds
+		}
+		missTo = ds
+	}
+
+	queried := map[string]Row{}
+	if missFrom != "" {
+		opt.Parameters = []string{quote(shop), quote(missFrom), quote(missTo)}
+		if opt.MaxResultRows == 0 || opt.MaxResultRows < MaxDays {
+			opt.MaxResultRows = MaxDays
+		}
+		out, err := nlq.RunAthenaQuery(ctx, ath, dailySQL, opt)
+		if err != nil {
+			return nil, err
+		}
+		res.ScannedBytes = out.ScannedBytes
+		for _, r := range out.Rows {
+			row := Row{
+				Date:             fmt.Sprint(r["dt"]),
+				GrossRevenue:     num(r["gross_revenue"]),
+				NetRevenue:       num(r["net_revenue"]),
+				ProductCosts:     num(r["product_costs"]),
+				MarketingCosts:   num(r["marketing_costs"]),
+				FulfillmentCosts: num(r["fulfillment_costs"]),
+				ProcessingFees:   num(r["processing_fees"]),
+				OtherCosts:       num(r["other_costs"]),
+			}
+			queried[row.Date] = row
+		}
+	}
+
+	var toCache []Row
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		ds := d.Format("2006-01-02")
+		if row, ok := cached[ds]; ok {
+			res.CachedDays++
+			res.Rows = append(res.Rows, row)
+			continue
+		}
+		row, ok := queried[ds]
+		if !ok {
+			row = Row{Date: ds}
+		}
+		res.QueriedDays++
+		res.Rows = append(res.Rows, row)
+		if ds < closedBefore {
+			toCache = append(toCache, row)
+		}
+	}
+
+	if err := storeCached(ctx, ddb, shop, toCache); err != nil {
+		fmt.Printf("metrics: store cache for %s failed: %v\n", shop, err)
+	}
+	return res, nil
+}
+
+func num(v any) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
+
+func loadCached(ctx context.Context, ddb *dynamodb.Client, shop, from, to string) (map[string]Row, error) {
+	tbl := cacheTable()
+	if tbl == "" {
+		return nil, fmt.Errorf("NLQ_CACHE_TABLE not set")
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	rows := map[string]Row{}
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk AND SK BETWEEN :from AND :to"),
+			// TTL deletion lags by up to days
+			FilterExpression: aws.String("ExpiresAt > :now"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":   &types.AttributeValueMemberS{Value: "METRICS#" + shop},
+				":from": &types.AttributeValueMemberS{Value: "DAY#" + from},
+				":to":   &types.AttributeValueMemberS{Value: "DAY#" + to},
+				":now":  &types.AttributeValueMemberN{Value: now},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query metrics cache: %w", err)
+		}
+		for _, it := range out.Items {
+			p, ok := it["Payload"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			var r Row
+			if err := json.Unmarshal([]byte(p.Value), &r); err != nil {
+				continue
+			}
+			rows[r.Date] = r
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return rows, nil
+}
+
+func storeCached(ctx context.Context, ddb *dynamodb.Client, shop string, rows []Row) error {
+	tbl := cacheTable()
+	if tbl == "" || len(rows) == 0 {
+		return nil
+	}
+	exp := strconv.FormatInt(time.Now().Add(closedTTL).Unix(), 10)
+	for start := 0; start < len(rows); start += 25 {
+		end := start + 25
+		if end > len(rows) {
+			end = len(rows)
+		}
+		var reqs []types.WriteRequest
+		for _, r := range rows[start:end] {
+			b, _ := json.Marshal(r)
+			reqs = append(reqs, types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+				"PK":        &types.AttributeValueMemberS{Value: "METRICS#" + shop},
+				"SK":        &types.AttributeValueMemberS{Value: "DAY#" + r.Date},
+				"Payload":   &types.AttributeValueMemberS{Value: string(b)},
+				"ExpiresAt": &types.AttributeValueMemberN{Value: exp},
+			}}})
+		}
+		out, err := ddb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{tbl: reqs},
+		})
+		if err != nil {
+			return fmt.Errorf("batch write metrics cache: %w", err)
+		}
+		if n := len(out.UnprocessedItems[tbl]); n > 0 {
+			// next request fills the rest
+			fmt.Printf("metrics: %d cache rows unprocessed for %s\n", n, shop)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/security"
+	"backend/internal/shopify"
+	"backend/internal/tenancy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A Bundle carries one tenant's integration records (Shopify, Square,
+// Recharge, Xero, ...) from one stage or region to another, so a migrated
+// merchant doesn't have to reconnect every store. Every *Enc attribute is
+// re-encrypted under the target environment's TOKEN_ENC_KEY_B64 (a
+// registered target, targets.go) before the bundle leaves this environment;
+// KeyID tells Import which key that was.
+//
+// Items bound to this environment (BI access with its IAM role, in-flight
+// bulk connects) aren't exported; reverse lookups (shop -> user, merchant ->
+// user, webhook keys) are rebuilt by Import rather than copied.
+type Bundle struct {
+	Version    int    `json:"version"`
+	UserSub    string `json:"userSub"`
+	ExportedAt string `json:"exportedAt"`
+	KeyID      string `json:"keyId"`
+	Items      []Item `json:"items"`
+}
+
+// Item is a DynamoDB item in a JSON-safe typed form.
+type Item map[string]Value
+
+// Value is one attribute value; exactly one field is set.
+type Value struct {
+	S    *string  `json:"S,omitempty"`
+	N    *string  `json:"N,omitempty"`
+	BOOL *bool    `json:"BOOL,omitempty"`
+	NULL bool     `json:"NULL,omitempty"`
+	SS   []string `json:"SS,omitempty"`
+	NS   []string `json:"NS,omitempty"`
+	L    *[]Value `json:"L,omitempty"` // pointers keep empty lists and maps
+	M    *Item    `json:"M,omitempty"`
+}
+
+const bundleVersion = 1
+
+// ErrKeyMismatch is returned by Import for a bundle encrypted for another key.
+var ErrKeyMismatch = errors.New("bundle was exported for a different TOKEN_ENC_KEY_B64")
+
+// skipped are SK prefixes of items that only make sense in this environment.
+var skipped = []string{"BIACCESS", "BULKCONNECT#"}
+
+// KeyID fingerprints an encryption key without revealing it.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Export reads sub's integration records and re-encrypts their secrets from
+// the local key to targetKey.
+func Export(ctx context.Context, ddb *dynamodb.Client, sub string, targetKey []byte) (*Bundle, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	local, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+
+	b := &Bundle{
+		Version:    bundleVersion,
+		UserSub:    sub,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		KeyID:      KeyID(targetKey),
+	}
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query integrations: %w", err)
+		}
+		for _, it := range out.Items {
+			sk, _ := it["SK"].(*types.AttributeValueMemberS)
+			if sk == nil || isSkipped(sk.Value) {
+				continue
+			}
+			for name, v := range it {
+				s, ok := v.(*types.AttributeValueMemberS)
+				if !ok || !strings.HasSuffix(name, "Enc") || s.Value == "" {
+					continue
+				}
+				plain, err := security.DecryptAESGCM(local, s.Value)
+				if err != nil {
+					return nil, fmt.Errorf("decrypt %s of %s: %w", name, sk.Value, err)
+				}
+				enc, err := security.EncryptAESGCM(targetKey, plain)
+				if err != nil {
+					return nil, err
+				}
+				it[name] = &types.AttributeValueMemberS{Value: enc}
+			}
+			b.Items = append(b.Items, encodeItem(it))
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return b, nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
+
+// Import writes a bundle exported for this environment's key, recreates the
+// reverse lookups of its integrations and returns how many records it wrote.
+// Every secret is checked to decrypt before anything is written.
+func Import(ctx context.Context, ddb *dynamodb.Client, b *Bundle) (int, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return 0, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	local, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	if b.Version != bundleVersion {
+		return 0, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if b.KeyID != KeyID(local) {
+		return 0, ErrKeyMismatch
+	}
+
+	pk := "USER#" + b.UserSub
+	items := make([]map[string]types.AttributeValue, 0, len(b.Items))
+	for i, raw := range b.Items {
+		it, err := decodeItem(raw)
+		if err != nil {
+			return 0, fmt.Errorf("item %d: %w", i, err)
+		}
+		if p, _ := it["PK"].(*types.AttributeValueMemberS); p == nil || p.Value != pk {
+			return 0, fmt.Errorf("item %d: not an item of %s", i, b.UserSub)
+		}
+		sk, _ := it["SK"].(*types.AttributeValueMemberS)
+		if sk == nil || isSkipped(sk.Value) {
+			return 0, fmt.Errorf("item %d: unexpected SK", i)
+		}
+		for name, v := range it {
+			if s, ok := v.(*types.AttributeValueMemberS); ok && strings.HasSuffix(name, "Enc") && s.Value != "" {
+				if _, err := security.DecryptAESGCM(local, s.Value); err != nil {
+					return 0, fmt.Errorf("item %d: %s does not decrypt", i, name)
+				}
+			}
+		}
+		items = append(items, it)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, it := range items {
+		if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(tbl), Item: it}); err != nil {
+			return 0, fmt.Errorf("put integration: %w", err)
+		}
+		if err := putReverse(ctx, ddb, tbl, b.UserSub, it, now); err != nil {
+			return 0, err
+		}
+	}
+	tenancy.InvalidateAllowedShops(b.UserSub)
+	return len(items), nil
+}
+
+// putReverse recreates the lookup item the webhook paths use to find the
+// owner of an integration.
+func putReverse(ctx context.Context, ddb *dynamodb.Client, tbl, sub string, it map[string]types.AttributeValue, now string) error {
+	sk := it["SK"].(*types.AttributeValueMemberS).Value
+	str := func(name string) string {
+		if v, ok := it[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	s := func(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+
+	var table string
+	var rev map[string]types.AttributeValue
+	switch {
+	case strings.HasPrefix(sk, "SHOPIFY#") && strings.Count(sk, "#") == 1:
+		shop := strings.TrimPrefix(sk, "SHOPIFY#")
+		table = strings.TrimSpace(db.ShopToUserTableName())
+		rev = map[string]types.AttributeValue{
+			"PK": s("SHOP#" + shop), "SK": s("USER#" + sub),
+			"Shop": s(shop), "UserSub": s(sub), "CreatedAt": s(now),
+		}
+		defer shopify.InvalidateUsersForShop(shop)
+	case strings.HasPrefix(sk, "SQUARE#"):
+		table = tbl
+		rev = map[string]types.AttributeValue{
+			"PK": s("SQUAREMERCHANT#" + strings.TrimPrefix(sk, "SQUARE#")), "SK": s("USER#" + sub),
+			"UserSub": s(sub), "CreatedAt": s(now),
+		}
+	case sk == "RECHARGE" && str("WebhookKey") != "":
+		table = tbl
+		rev = map[string]types.AttributeValue{
+			"PK": s("RECHARGEKEY#" + str("WebhookKey")), "SK": s("USER"),
+			"UserSub": s(sub),
+		}
+	case strings.HasPrefix(sk, "INGEST#"):
+		table = tbl
+		rev = map[string]types.AttributeValue{
+			"PK": s("INGESTKEY#" + strings.TrimPrefix(sk, "INGEST#")), "SK": s("SOURCE"),
+			"UserSub": s(sub),
+		}
+	default:
+		return nil
+	}
+	if table == "" {
+		return fmt.Errorf("SHOP_TO_USER_TABLE not set")
+	}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: rev}); err != nil {
+		return fmt.Errorf("put reverse lookup for %s: %w", sk, err)
+	}
+	return nil
+}
+
+func isSkipped(sk string) bool {
+	for _, p := range skipped {
+		if strings.HasPrefix(sk, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeItem(it map[string]types.AttributeValue) Item {
+	out := Item{}
+	for k, v := range it {
+		out[k] = encode(v)
+	}
+	return out
+}
+
+func encode(av types.AttributeValue) Value {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return Value{S: aws.String(v.Value)}
+	case *types.AttributeValueMemberN:
+		return Value{N: aws.String(v.Value)}
+	case *types.AttributeValueMemberBOOL:
+		return Value{BOOL: aws.Bool(v.Value)}
+	case *types.AttributeValueMemberSS:
+		return Value{SS: v.Value}
+	case *types.AttributeValueMemberNS:
+		return Value{NS: v.Value}
+	case *types.AttributeValueMemberL:
+		l := make([]Value, len(v.Value))
+		for i, e := range v.Value {
+			l[i] = encode(e)
+		}
+		return Value{L: &l}
+	case *types.AttributeValueMemberM:
+		m := encodeItem(v.Value)
+		return Value{M: &m}
+	default: // NULL; binary attributes aren't used in integration items
+		return Value{NULL: true}
+	}
+}
+
+func decodeItem(it Item) (map[string]types.AttributeValue, error) {
+	out := make(map[string]types.AttributeValue, len(it))
+	for k, v := range it {
+		av, err := decode(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		out[k] = av
+	}
+	return out, nil
+}
+
+func decode(v Value) (types.AttributeValue, error) {
+	switch {
+	case v.S != nil:
+		return &types.AttributeValueMemberS{Value: *v.S}, nil
+	case v.N != nil:
+		return &types.AttributeValueMemberN{Value: *v.N}, nil
+	case v.BOOL != nil:
+		return &types.AttributeValueMemberBOOL{Value: *v.BOOL}, nil
+	case v.SS != nil:
+		return &types.AttributeValueMemberSS{Value: v.SS}, nil
+	case v.NS != nil:
+		return &types.AttributeValueMemberNS{Value: v.NS}, nil
+	case v.L != nil:
+		l := make([]types.AttributeValue, len(*v.L))
+		for i, e := range *v.L {
+			av, err := decode(e)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = av
+		}
+		return &types.AttributeValueMemberL{Value: l}, nil
+	case v.M != nil:
+		m, err := decodeItem(*v.M)
+		if err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	case v.NULL:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	}
+	return nil, fmt.Errorf("empty value")
+}
@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"backend/internal/security"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// Export targets are registered, not supplied: an operator with access to
+// both environments stores the target's TOKEN_ENC_KEY_B64 as a KMS-encrypted
+// SecureString at
+//
+//	/trueprofit/<stage>/migration-targets/<target id>
+//
+// and an export names the target id only. Key material never travels in a
+// request, and an admin can't re-encrypt a tenant's tokens under a key of
+// their own choosing.
+
+// ErrUnknownTarget is returned by TargetKey for an id with no registered key.
+var ErrUnknownTarget = errors.New("unknown migration target")
+
+var targetIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// SSMClient is the part of the SSM API TargetKey uses.
+type SSMClient interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// TargetParameter is the SSM parameter holding target id's key.
+func TargetParameter(id string) string {
+	stage := strings.TrimSpace(os.Getenv("APP_STAGE"))
+	if stage == "" {
+		stage = "dev"
+	}
+	return fmt.Sprintf("/trueprofit/%s/migration-targets/%s", stage, id)
+}
+
+// TargetKey resolves a registered target id to its encryption key.
+func TargetKey(ctx context.Context, c SSMClient, id string) ([]byte, error) {
+	if !targetIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTarget, id)
+	}
+	out, err := c.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(TargetParameter(id)),
+		WithDecryption: aws.Bool(true),
+	})
+	var nf *ssmtypes.ParameterNotFound
+	if errors.As(err, &nf) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTarget, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load migration target %s: %w", id, err)
+	}
+	if out.Parameter == nil || out.Parameter.Type != ssmtypes.ParameterTypeSecureString {
+		return nil, fmt.Errorf("migration target %s is not a SecureString", id)
+	}
+	key, err := security.LoadKeyFromBase64(strings.TrimSpace(aws.ToString(out.Parameter.Value)))
+	if err != nil {
+		return nil, fmt.Errorf("migration target %s: invalid key", id)
+	}
+	return key, nil
+}
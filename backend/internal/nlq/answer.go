@@ -0,0 +1,107 @@
+package nlq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"backend/internal/metering"
+)
+
+// Answer synthesis turns a result table into a sentence or two for the
+// user ("Net revenue for the last 7 days was $4,321, up 12% vs the prior
+// week"), in the language of the question. It is a second, cheaper Bedrock call after Athena; the rows stay
+// the source of truth and are returned alongside.
+
+// answerRows caps the rows shown to the model; larger results are described
+// from their first rows and the total count.
+const answerRows = 50
+
+type AnswerRequest struct {
+	Question    string
+	SQL         string
+	Columns     []string
+	Rows        []map[string]any
+	Assumptions []string
+	TodayISO    string
+	Currency    string // pinned reporting currency (optional)
+	Language    string // code of the question's language (Languages); "" when unknown
+}
+
+func BuildAnswerPrompt(r AnswerRequest) string {
+	rows := r.Rows
+	more := ""
+	if len(rows) > answerRows {
+		rows = rows[:answerRows]
+		more = fmt.Sprintf("\n(%d of %d rows shown)", answerRows, len(r.Rows))
+	}
+	rowsJSON, _ := json.Marshal(rows)
+
+	assumptions := "(none)"
+	if len(r.Assumptions) > 0 {
+		assumptions = "- " + strings.Join(r.Assumptions, "\n- ")
+	}
+	language := languageName(r.Language)
+	if language == "" {
+		language = "the language of the question"
+	}
+	currency := ""
+	if r.Currency != "" {
+		currency = fmt.Sprintf("- Amounts are in %s unless a column says otherwise.\n", r.Currency)
+	}
+
+	return fmt.Sprintf(`
+You answer an e-commerce merchant's question from the result of a SQL query that was run for it.
+
+RULES:
+- Answer by calling the submit_answer tool.
+- One to three plain sentences in %s; lead with the number that answers the question.
+- Use only numbers in the result; do not invent or extrapolate. Compute a change (e.g. "up 12%%") only from two values in the result.
+- Round money to whole units with that language's thousands separators, and percentages to whole numbers.
+- If the result is empty, say there was no data for the question.
+- Do not mention SQL, tables or columns.
+%s
+TODAY: %s
+
+QUESTION:
+%s
+
+ASSUMPTIONS MADE WHEN WRITING THE QUERY:
+%s
+
+SQL:
+%s
+
+RESULT (columns %s):
+%s%s
+`, language, currency, r.TodayISO, r.Question, assumptions, r.SQL, strings.Join(r.Columns, ", "), rowsJSON, more)
+}
+
+// answerTool is how the model answers a BuildAnswerPrompt.
+var answerTool = modelTool{
+	Name:        "submit_answer",
+	Description: "Submit the plain-language answer to the question.",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"answer": map[string]any{"type": "string", "description": "One to three sentences."},
+		},
+		"required": []string{"answer"},
+	},
+}
+
+// SynthesizeAnswer writes the plain-language answer for a result.
+func SynthesizeAnswer(ctx context.Context, c BedrockClient, r AnswerRequest) (string, error) {
+	models, err := modelsFor(ctx)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Answer string `json:"answer"`
+	}
+	if err := invokeTool(metering.WithFeature(ctx, metering.FeatureSummarize), c, models[0], BuildAnswerPrompt(r), 300, answerTool, &out); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.Answer), nil
+}
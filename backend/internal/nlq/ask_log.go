@@ -0,0 +1,232 @@
+package nlq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/metering"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The ask log keeps every /ask invocation: the question, the SQL the model
+// wrote, whether the validator let it through, and what Athena did with it.
+// It is the user's query history (GET /ask/history) and the audit trail of
+// the SQL run on their behalf, so it is written for rejections and failures
+// too, and kept far longer than the conversation.
+//
+// NLQ_HISTORY_TABLE
+// PK = USER#<sub>
+// SK = ASK#<RFC3339Nano>#<id>
+// SK = QUERY#<athena query id>   copy of the latest ask answered (or still
+//                                being answered) by that query, for feedback
+//                                and GET /ask/result
+// SK = FEEDBACK#<athena query id>  the user's rating of it (examples.go)
+// SK = EXAMPLE#<schema hash>#<question hash>  rated question->SQL pairs (examples.go)
+
+// Outcomes of an ask; the response "type" where there is one.
+const (
+	AskResult        = "result"
+	AskPending       = "pending" // Athena outlived the request; see CompleteAsk
+	AskClarification = "clarification"
+	AskSQLRejected   = "sql_rejected"
+	AskAthenaFailed  = "athena_failed"
+	AskNoShops       = "no_shops"
+	AskQuotaExceeded = "quota_exceeded"
+	AskError         = "error"
+)
+
+// Validation outcomes; empty when no SQL reached the validator.
+const (
+	ValidationPassed   = "passed"
+	ValidationRejected = "rejected"
+)
+
+// askLogRetention is how long the audit trail is kept.
+const askLogRetention = 400 * 24 * time.Hour
+
+var ErrAskNotFound = errors.New("ask not found")
+
+type AskLogClient interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// AskRecord is one /ask invocation.
+type AskRecord struct {
+	Id         string   `dynamodbav:"AskId" json:"id"`
+	Question   string   `dynamodbav:"Question" json:"question"`
+	SessionID  string   `dynamodbav:"SessionId,omitempty" json:"session_id,omitempty"`
+	DigestID   string   `dynamodbav:"DigestId,omitempty" json:"digest_id,omitempty"` // asked by a scheduled digest
+	Language   string   `dynamodbav:"Language,omitempty" json:"language,omitempty"`  // answer language (Languages)
+	Shops      []string `dynamodbav:"Shops,omitempty" json:"shops,omitempty"`
+	Outcome    string   `dynamodbav:"Outcome" json:"outcome"`
+	Reason     string   `dynamodbav:"Reason,omitempty" json:"reason,omitempty"` // rejection or error message
+	SQL        string   `dynamodbav:"Sql,omitempty" json:"sql,omitempty"`       // last SQL written by the model
+	Validation string   `dynamodbav:"Validation,omitempty" json:"validation,omitempty"`
+	Cached     bool     `dynamodbav:"Cached,omitempty" json:"cached,omitempty"`
+	SchemaHash string   `dynamodbav:"SchemaHash,omitempty" json:"-"`
+	Model      string   `dynamodbav:"Model,omitempty" json:"model,omitempty"` // Bedrock model that wrote SQL
+
+	QueryID      string `dynamodbav:"QueryId,omitempty" json:"query_id,omitempty"`
+	ScannedBytes int64  `dynamodbav:"ScannedBytes,omitempty" json:"scanned_bytes,omitempty"`
+	ExecMs       int64  `dynamodbav:"ExecMs,omitempty" json:"exec_ms,omitempty"`
+	LatencyMs    int64  `dynamodbav:"LatencyMs" json:"latency_ms"` // whole request
+	CreatedAt    string `dynamodbav:"CreatedAt" json:"created_at"`
+
+	// Self-correction: SQL rewrites asked of the model and why each
+	// candidate failed (FixStats).
+	FixAttempts int      `dynamodbav:"FixAttempts,omitempty" json:"fix_attempts,omitempty"`
+	FixFailures []string `dynamodbav:"FixFailures,omitempty" json:"fix_failures,omitempty"`
+
+	// LowConfidence marks SQL the model rated below the confidence floor:
+	// asked back as a clarification, or run and flagged (ConfidencePolicy).
+	LowConfidence bool `dynamodbav:"LowConfidence,omitempty" json:"low_confidence,omitempty"`
+
+	// Bedrock tokens of every model call the ask made, and the estimated
+	// cost of those and its Athena query.
+	InputTokens  int     `dynamodbav:"InputTokens,omitempty" json:"input_tokens,omitempty"`
+	OutputTokens int     `dynamodbav:"OutputTokens,omitempty" json:"output_tokens,omitempty"`
+	CostUSD      float64 `dynamodbav:"CostUSD,omitempty" json:"cost_usd,omitempty"`
+
+	AskSK string `dynamodbav:"AskSK,omitempty" json:"-"` // SK of the ASK# item, for CompleteAsk
+}
+
+// AddSpend adds what was metered on ctx (metering.WithAttribution) to r.
+func (r *AskRecord) AddSpend(ctx context.Context) {
+	in, out, _, cost := metering.Spent(ctx)
+	r.InputTokens += in
+	r.OutputTokens += out
+	r.CostUSD += cost
+}
+
+func askLogTable() (string, error) {
+	t := strings.TrimSpace(os.Getenv("NLQ_HISTORY_TABLE"))
+	if t == "" {
+		return "", fmt.Errorf("missing NLQ_HISTORY_TABLE")
+	}
+	return t, nil
+}
+
+// RecordAsk appends r to the user's ask log.
+func RecordAsk(ctx context.Context, ddb AskLogClient, userSub string, r AskRecord) error {
+	table, err := askLogTable()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	id := make([]byte, 4)
+	_, _ = rand.Read(id)
+	r.Id = hex.EncodeToString(id)
+	r.CreatedAt = now.Format(time.RFC3339Nano)
+	r.AskSK = "ASK#" + r.CreatedAt + "#" + r.Id
+	return putAsk(ctx, ddb, table, userSub, r, now)
+}
+
+// CompleteAsk replaces a pending ask, as returned by GetAskByQueryID, with
+// its final outcome in r.
+func CompleteAsk(ctx context.Context, ddb AskLogClient, userSub string, r AskRecord) error {
+	table, err := askLogTable()
+	if err != nil {
+		return err
+	}
+	created, err := time.Parse(time.RFC3339Nano, r.CreatedAt)
+	if r.AskSK == "" || err != nil {
+		return fmt.Errorf("ask log: incomplete record %q", r.Id)
+	}
+	return putAsk(ctx, ddb, table, userSub, r, created)
+}
+
+// putAsk writes r at its AskSK, and its QUERY# copy when r has a query
+// still running or answered.
+func putAsk(ctx context.Context, ddb AskLogClient, table, userSub string, r AskRecord, created time.Time) error {
+	item, err := attributevalue.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	item["PK"] = &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)}
+	item["SK"] = &ddbtypes.AttributeValueMemberS{Value: r.AskSK}
+	item["ExpiresAt"] = &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", created.Add(askLogRetention).Unix())}
+
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: item}); err != nil {
+		return fmt.Errorf("ask log PutItem: %w", err)
+	}
+	if r.QueryID == "" || (r.Outcome != AskResult && r.Outcome != AskPending) {
+		return nil
+	}
+	item["SK"] = &ddbtypes.AttributeValueMemberS{Value: askQuerySK(r.QueryID)}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: item}); err != nil {
+		return fmt.Errorf("ask log PutItem: %w", err)
+	}
+	return nil
+}
+
+func askQuerySK(queryID string) string {
+	return "QUERY#" + queryID
+}
+
+// GetAskByQueryID returns the latest of the user's asks answered by Athena
+// query queryID (the query_id of an /ask result).
+func GetAskByQueryID(ctx context.Context, ddb AskLogClient, userSub, queryID string) (AskRecord, error) {
+	table, err := askLogTable()
+	if err != nil {
+		return AskRecord{}, err
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]ddbtypes.AttributeValue{
+			"PK": &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+			"SK": &ddbtypes.AttributeValueMemberS{Value: askQuerySK(queryID)},
+		},
+	})
+	if err != nil {
+		return AskRecord{}, fmt.Errorf("ask log GetItem: %w", err)
+	}
+	if out.Item == nil {
+		return AskRecord{}, ErrAskNotFound
+	}
+	var r AskRecord
+	err = attributevalue.UnmarshalMap(out.Item, &r)
+	return r, err
+}
+
+// ListAsks returns a page of the user's ask log, newest first, and the key
+// to continue from (nil on the last page).
+func ListAsks(ctx context.Context, ddb AskLogClient, userSub string, limit int, startKey map[string]ddbtypes.AttributeValue) ([]AskRecord, map[string]ddbtypes.AttributeValue, error) {
+	table, err := askLogTable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":pk": &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+			":p":  &ddbtypes.AttributeValueMemberS{Value: "ASK#"},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(int32(limit)),
+		ExclusiveStartKey: startKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("ask log Query: %w", err)
+	}
+
+	records := []AskRecord{}
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &records); err != nil {
+		return nil, nil, err
+	}
+	return records, out.LastEvaluatedKey, nil
+}
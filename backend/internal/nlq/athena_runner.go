@@ -24,8 +24,9 @@ type AthenaRunOptions struct {
 	OutputLocation string // s3://.../athena-results/
 	MaxWait        time.Duration
 	PollInterval   time.Duration
-	MaxResultRows  int // safety
-	MaxResultBytes int // (not enforced in API; reserved)
+	MaxResultRows  int      // safety
+	MaxResultBytes int      // (not enforced in API; reserved)
+	Parameters     []string // values for ? placeholders, as SQL literals
 }
 
 type AthenaResult struct {
@@ -42,6 +43,10 @@ type AthenaError struct {
 	QueryExecutionID string
 }
 
+// AthenaStateTimeout is the AthenaError state of a query still running
+// when MaxWait ran out; it can be awaited again by its QueryExecutionID.
+const AthenaStateTimeout = "TIMEOUT"
+
 func (e *AthenaError) Error() string {
 	if e.QueryExecutionID != "" {
 		return fmt.Sprintf("athena %s: %s (qid=%s)", e.State, e.Reason, e.QueryExecutionID)
@@ -59,15 +64,6 @@ func RunAthenaQuery(ctx context.Context, c AthenaClient, sql string, opt AthenaR
 	if strings.TrimSpace(opt.OutputLocation) == "" {
 		return nil, fmt.Errorf("missing athena output location")
 	}
-	if opt.MaxWait == 0 {
-		opt.MaxWait = 25 * time.Second
-	}
-	if opt.PollInterval == 0 {
-		opt.PollInterval = 700 * time.Millisecond
-	}
-	if opt.MaxResultRows == 0 {
-		opt.MaxResultRows = 200
-	}
 
 	startOut, err := c.StartQueryExecution(ctx, &athena.StartQueryExecutionInput{
 		QueryString: aws.String(sql),
@@ -77,19 +73,35 @@ func RunAthenaQuery(ctx context.Context, c AthenaClient, sql string, opt AthenaR
 		ResultConfiguration: &athenatypes.ResultConfiguration{
 			OutputLocation: aws.String(opt.OutputLocation),
 		},
-		WorkGroup: aws.String(opt.Workgroup),
+		WorkGroup:           aws.String(opt.Workgroup),
+		ExecutionParameters: opt.Parameters,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("athena StartQueryExecution: %w", err)
 	}
-	qid := aws.ToString(startOut.QueryExecutionId)
+	return AwaitAthenaQuery(ctx, c, aws.ToString(startOut.QueryExecutionId), opt)
+}
+
+// AwaitAthenaQuery polls a started query for up to opt.MaxWait and returns
+// its results, or an *AthenaError (AthenaStateTimeout when it is still
+// running).
+func AwaitAthenaQuery(ctx context.Context, c AthenaClient, qid string, opt AthenaRunOptions) (*AthenaResult, error) {
+	if opt.MaxWait == 0 {
+		opt.MaxWait = 25 * time.Second
+	}
+	if opt.PollInterval == 0 {
+		opt.PollInterval = 700 * time.Millisecond
+	}
+	if opt.MaxResultRows == 0 {
+		opt.MaxResultRows = 200
+	}
 
 	// Poll status
 	deadline := time.Now().Add(opt.MaxWait)
 	var exec *athenatypes.QueryExecution
 	for {
 		if time.Now().After(deadline) {
-			return nil, &AthenaError{State: "TIMEOUT", Reason: "query timed out", QueryExecutionID: qid}
+			return nil, &AthenaError{State: AthenaStateTimeout, Reason: "query timed out", QueryExecutionID: qid}
 		}
 		getOut, err := c.GetQueryExecution(ctx, &athena.GetQueryExecutionInput{
 			QueryExecutionId: aws.String(qid),
@@ -189,6 +201,19 @@ RESULTS:
 	}, nil
 }
 
+// ExplainAthenaQuery dry-runs sql with EXPLAIN (TYPE VALIDATE): Athena
+// parses and analyzes it against the catalog without scanning data, so
+// syntax, column and type errors come back as an *AthenaError for a fraction
+// of a real run.
+func ExplainAthenaQuery(ctx context.Context, c AthenaClient, sql string, opt AthenaRunOptions) error {
+	if opt.MaxWait == 0 || opt.MaxWait > 10*time.Second {
+		opt.MaxWait = 10 * time.Second
+	}
+	opt.MaxResultRows = 1
+	_, err := RunAthenaQuery(ctx, c, "EXPLAIN (TYPE VALIDATE) "+sql, opt)
+	return err
+}
+
 func coerceScalar(v string) any {
 	v = strings.TrimSpace(v)
 	if v == "" {
@@ -4,16 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
+	"backend/internal/metering"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	bedrockruntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	brtypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 )
 
 type BedrockClient interface {
-	InvokeModel(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
+	Converse(ctx context.Context, params *bedrockruntime.ConverseInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error)
 }
 
 type LLMRequest struct {
@@ -23,6 +26,11 @@ type LLMRequest struct {
 	SchemaText      string
 	TodayISO        string // e.g. 2026-01-19
 	DefaultTimezone string // e.g. Asia/Ho_Chi_Minh (optional)
+	PinnedContext   string // rendered PinnedContext.PromptText() (optional)
+	FiscalCalendar  string // rendered periods.Calendar.PromptText() (optional)
+	History         string // rendered HistoryText() of the session's earlier turns (optional)
+	Examples        string // rendered ExamplesText() of the user's best-rated questions (optional)
+	Language        string // code of the question's language (Languages); "" when unknown
 }
 
 type LLMResult struct {
@@ -31,6 +39,8 @@ type LLMResult struct {
 	Assumptions        []string `json:"assumptions"`
 	NeedsClarification bool     `json:"needs_clarification"`
 	ClarifyingQuestion *string  `json:"clarifying_question"`
+
+	Model string `json:"-"` // Bedrock model that wrote it
 }
 
 func BuildPrompt(r LLMRequest) string {
@@ -42,10 +52,24 @@ func BuildPrompt(r LLMRequest) string {
 	today, _ := time.Parse("2006-01-02", r.TodayISO)
 	dtMin := today.AddDate(0, 0, -r.MaxDaysLookback).Format("2006-01-02")
 
+	pinned := ""
+	if r.PinnedContext != "" {
+		pinned = "\nPINNED CONTEXT (applies to every question in this session):\n" + r.PinnedContext + "\n"
+	}
+	if r.FiscalCalendar != "" {
+		pinned += "\nFISCAL CALENDAR (use these ranges for year/quarter/month wording):\n" + r.FiscalCalendar + "\n"
+	}
+	if r.Examples != "" {
+		pinned += "\nEXAMPLES (earlier questions of this user whose SQL they rated correct; reuse the patterns, but take dates from TODAY and shops from the allowlist):\n" + r.Examples + "\n"
+	}
+	if r.History != "" {
+		pinned += "\nCONVERSATION SO FAR (oldest first; the question may be a follow-up that keeps their metrics, shops or periods unless it changes them):\n" + r.History + "\n"
+	}
+
 	return fmt.Sprintf(`
 You are a Text-to-SQL compiler for AWS Athena.
 
-OUTPUT: valid JSON ONLY (never SQL alone).
+OUTPUT: call the submit_sql tool (never plain text).
 
 CRITICAL RULES:
 - One SELECT statement only, no semicolon, no comments.
@@ -56,6 +80,8 @@ CRITICAL RULES:
     dt >= date '%s'
     OR dt between date '%s' and date '%s'
 - metric_date is a string 'YYYY-MM-DD' — cast as date when needed.
+- is_promo is true on sale days (discount spike or limited-time discount). For "sale days vs normal days"
+  questions, GROUP BY is_promo; for "during the sale" filter is_promo = true.
 - NEVER remove dt filter.
 - Prefer partition pruning: filter dt and shop_id.
 - ALWAYS wrap aggregate functions using COALESCE(..., 0) so results never return NULL.
@@ -67,96 +93,134 @@ CRITICAL RULES:
     COUNT(x)      => COALESCE(COUNT(x), 0)
 - When the user asks for total/aggregate values, return a single scalar column named appropriately (e.g., total_net_revenue).
 
+LANGUAGE:
+%s
+
 TODAY: %s
 DT_MIN_ALLOWED: %s
 LOCAL_TIMEZONE: %s
-
+%s
 SCHEMA:
 %s
 
 USER QUESTION:
 %s
-
-Return JSON:
-{
-  "sql": "...",
-  "confidence": 0.0,
-  "assumptions": ["..."],
-  "needs_clarification": false,
-  "clarifying_question": null
+`, shops, dtMin, dtMin, dtMin, r.TodayISO, languagePrompt(r.Language), r.TodayISO, dtMin, r.DefaultTimezone, pinned, r.SchemaText, r.Question)
 }
-`, shops, dtMin, dtMin, dtMin, r.TodayISO, r.TodayISO, dtMin, r.DefaultTimezone, r.SchemaText, r.Question)
+
+// sqlTool is how the model answers a BuildPrompt or BuildFixPrompt: its
+// input is an LLMResult.
+var sqlTool = modelTool{
+	Name:        "submit_sql",
+	Description: "Submit the Athena SQL that answers the question, or a clarifying question when it cannot be answered as asked.",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"sql":                 map[string]any{"type": "string", "description": "One Athena SELECT statement; empty when clarification is needed."},
+			"confidence":          map[string]any{"type": "number", "minimum": 0, "maximum": 1},
+			"assumptions":         map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"needs_clarification": map[string]any{"type": "boolean"},
+			"clarifying_question": map[string]any{"type": []string{"string", "null"}},
+		},
+		"required": []string{"sql", "confidence", "assumptions", "needs_clarification"},
+	},
 }
 
-// InvokeBedrockClaude sends the prompt and parses Claude JSON output.
-// This version uses the Anthropic-style payload commonly used in Bedrock for Claude models.
-func InvokeBedrockClaude(ctx context.Context, c BedrockClient, prompt string) (*LLMResult, error) {
-	modelID := strings.TrimSpace(os.Getenv("BEDROCK_MODEL_ID"))
-	if modelID == "" {
-		return nil, fmt.Errorf("missing env BEDROCK_MODEL_ID")
+// InvokeBedrock sends a SQL prompt down the model chain (see models.go) and
+// returns the first submit_sql call confident enough, else the last one a
+// model made.
+func InvokeBedrock(ctx context.Context, c BedrockClient, prompt string) (*LLMResult, error) {
+	models, err := modelsFor(ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	// Claude on Bedrock typically accepts this schema:
-	// { "anthropic_version": "bedrock-2023-05-31", "max_tokens": ..., "messages": [...] }
-	payload := map[string]any{
-		"anthropic_version": "bedrock-2023-05-31",
-		"max_tokens":        700,
-		"temperature":       0.0,
-		"messages": []map[string]any{
-			{
-				"role": "user",
-				"content": []map[string]any{
-					{"type": "text", "text": prompt},
-				},
-			},
-		},
+	var best *LLMResult
+	var lastErr error
+	for i, modelID := range models {
+		var res LLMResult
+		if err := invokeTool(ctx, c, modelID, prompt, 700, sqlTool, &res); err != nil {
+			fmt.Printf("bedrock: model=%s failed: %v\n", modelID, err)
+			lastErr = err
+			continue
+		}
+		res.SQL = strings.TrimSpace(res.SQL)
+		res.Model = modelID
+		best = &res
+		if res.NeedsClarification || res.Confidence >= minConfidence() || i == len(models)-1 {
+			break
+		}
+		fmt.Printf("bedrock: model=%s confidence=%.2f below %.2f, trying %s\n", modelID, res.Confidence, minConfidence(), models[i+1])
 	}
+	if best == nil {
+		return nil, lastErr
+	}
+	return best, nil
+}
 
-	body, _ := json.Marshal(payload)
+// modelTool is a tool the model is made to call; Schema is the JSON schema
+// of its input.
+type modelTool struct {
+	Name        string
+	Description string
+	Schema      map[string]any
+}
 
-	out, err := c.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(modelID),
-		ContentType: aws.String("application/json"),
-		Accept:      aws.String("application/json"),
-		Body:        body,
+// invokeTool sends one user message to modelID through the Converse API
+// with tool as the only (and required) tool, and decodes the tool call's
+// input into out. Converse works the same for every model Bedrock serves, so
+// any model that supports tool use may be in the chain. Each call logs its
+// latency and estimated cost.
+func invokeTool(ctx context.Context, c BedrockClient, modelID, prompt string, maxTokens int, tool modelTool, out any) error {
+	started := time.Now()
+	res, err := c.Converse(ctx, &bedrockruntime.ConverseInput{
+		ModelId: aws.String(modelID),
+		Messages: []brtypes.Message{{
+			Role:    brtypes.ConversationRoleUser,
+			Content: []brtypes.ContentBlock{&brtypes.ContentBlockMemberText{Value: prompt}},
+		}},
+		InferenceConfig: &brtypes.InferenceConfiguration{
+			MaxTokens:   aws.Int32(int32(maxTokens)),
+			Temperature: aws.Float32(0),
+		},
+		ToolConfig: &brtypes.ToolConfiguration{
+			Tools: []brtypes.Tool{&brtypes.ToolMemberToolSpec{Value: brtypes.ToolSpecification{
+				Name:        aws.String(tool.Name),
+				Description: aws.String(tool.Description),
+				InputSchema: &brtypes.ToolInputSchemaMemberJson{Value: document.NewLazyDocument(tool.Schema)},
+			}}},
+			ToolChoice: &brtypes.ToolChoiceMemberTool{Value: brtypes.SpecificToolChoice{Name: aws.String(tool.Name)}},
+		},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("bedrock InvokeModel: %w", err)
+		return fmt.Errorf("bedrock Converse: %w", err)
 	}
-
-	// Parse response:
-	// Claude returns JSON like: { "content":[{"type":"text","text":"..."}], ... }
-	var raw struct {
-		Content []struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
-		} `json:"content"`
-	}
-	if err := json.Unmarshal(out.Body, &raw); err != nil {
-		return nil, fmt.Errorf("bedrock response unmarshal: %w", err)
-	}
-
-	var text string
-	for _, c := range raw.Content {
-		if c.Type == "text" {
-			text += c.Text
-		}
+	if u := res.Usage; u != nil {
+		in, outTokens := int(aws.ToInt32(u.InputTokens)), int(aws.ToInt32(u.OutputTokens))
+		metering.RecordBedrock(ctx, modelID, in, outTokens)
+		fmt.Printf("bedrock: model=%s tool=%s latency_ms=%d input_tokens=%d output_tokens=%d cost_usd=%.6f\n",
+			modelID, tool.Name, time.Since(started).Milliseconds(), in, outTokens, metering.Cost(modelID, in, outTokens))
 	}
-	text = strings.TrimSpace(text)
 
-	// Sometimes the model wraps JSON in extra whitespace. We require pure JSON.
-	// Try to extract the first JSON object.
-	jsonStr := extractFirstJSONObject(text)
-	if jsonStr == "" {
-		return nil, fmt.Errorf("model did not return JSON object")
+	msg, ok := res.Output.(*brtypes.ConverseOutputMemberMessage)
+	if !ok {
+		return fmt.Errorf("bedrock Converse: no message in output")
 	}
-
-	var res LLMResult
-	if err := json.Unmarshal([]byte(jsonStr), &res); err != nil {
-		return nil, fmt.Errorf("LLM JSON parse failed: %w; raw=%s", err, truncate(jsonStr, 800))
+	for _, block := range msg.Value.Content {
+		use, ok := block.(*brtypes.ContentBlockMemberToolUse)
+		if !ok || aws.ToString(use.Value.Name) != tool.Name || use.Value.Input == nil {
+			continue
+		}
+		// Via JSON so out's json tags apply.
+		raw, err := use.Value.Input.MarshalSmithyDocument()
+		if err != nil {
+			return fmt.Errorf("%s input: %w", tool.Name, err)
+		}
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("%s input parse failed: %w; raw=%s", tool.Name, err, truncate(string(raw), 800))
+		}
+		return nil
 	}
-	res.SQL = strings.TrimSpace(res.SQL)
-	return &res, nil
+	return fmt.Errorf("model did not call %s (stop reason %s)", tool.Name, res.StopReason)
 }
 
 func TodayISO() string {
@@ -169,24 +233,3 @@ func truncate(s string, n int) string {
 	}
 	return s[:n] + "..."
 }
-
-// extractFirstJSONObject finds the first {...} block. MVP-safe; not a full JSON parser.
-func extractFirstJSONObject(s string) string {
-	start := strings.Index(s, "{")
-	if start < 0 {
-		return ""
-	}
-	depth := 0
-	for i := start; i < len(s); i++ {
-		switch s[i] {
-		case '{':
-			depth++
-		case '}':
-			depth--
-			if depth == 0 {
-				return s[start : i+1]
-			}
-		}
-	}
-	return ""
-}
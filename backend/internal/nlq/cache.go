@@ -22,12 +22,14 @@ type CacheClient interface {
 }
 
 type CacheKey struct {
-	UserSub    string
-	Shops      []string
-	Question   string
-	TodayISO   string
-	MaxDays    int
-	SchemaHash string // optional but helps invalidate when schema changes
+	UserSub     string
+	Shops       []string
+	Question    string
+	TodayISO    string
+	MaxDays     int
+	SchemaHash  string // optional but helps invalidate when schema changes
+	Context     string // PinnedContext.CacheMaterial(), empty when nothing is pinned
+	DataVersion string // DataVersionMaterial() of Shops; changes when the ETL writes
 }
 
 type CachedResponse struct {
@@ -39,6 +41,8 @@ type CachedResponse struct {
 	ScannedBytes int64            `json:"scanned_bytes"`
 	ExecMs       int64            `json:"exec_ms"`
 	QueryID      string           `json:"query_id"`
+	Answer       string           `json:"answer,omitempty"`
+	Model        string           `json:"model,omitempty"`
 }
 
 func cacheTable() (string, error) {
@@ -93,6 +97,12 @@ func MakeCacheSK(k CacheKey) string {
 		"schema=" + k.SchemaHash,
 		"q=" + qn,
 	}, "|")
+	if k.Context != "" {
+		material += "|ctx=" + k.Context
+	}
+	if k.DataVersion != "" {
+		material += "|data=" + k.DataVersion
+	}
 	return "NLQ#" + HashKeyMaterial(material)
 }
 
@@ -143,15 +153,21 @@ func PutCached(ctx context.Context, ddb CacheClient, key CacheKey, resp CachedRe
 	now := time.Now().UTC().Unix()
 	exp := now + cacheTTLSeconds()
 
+	item := map[string]ddbtypes.AttributeValue{
+		"PK":        &ddbtypes.AttributeValueMemberS{Value: pk},
+		"SK":        &ddbtypes.AttributeValueMemberS{Value: sk},
+		"ExpiresAt": &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
+		"Payload":   &ddbtypes.AttributeValueMemberS{Value: string(b)},
+		"CreatedAt": &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
+	}
+	// Shops lets webhook workers find and drop answers a new order makes stale.
+	if shops := uniqueLower(key.Shops); len(shops) > 0 {
+		item["Shops"] = &ddbtypes.AttributeValueMemberSS{Value: shops}
+	}
+
 	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(table),
-		Item: map[string]ddbtypes.AttributeValue{
-			"PK":        &ddbtypes.AttributeValueMemberS{Value: pk},
-			"SK":        &ddbtypes.AttributeValueMemberS{Value: sk},
-			"ExpiresAt": &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
-			"Payload":   &ddbtypes.AttributeValueMemberS{Value: string(b)},
-			"CreatedAt": &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
-		},
+		Item:      item,
 	})
 	if err != nil {
 		return fmt.Errorf("cache PutItem: %w", err)
@@ -159,6 +175,82 @@ func PutCached(ctx context.Context, ddb CacheClient, key CacheKey, resp CachedRe
 	return nil
 }
 
+type CacheInvalidator interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// InvalidateCachedForShop deletes a user's cached answers that covered shop.
+// Called from webhook workers so "revenue today" reflects a sale immediately.
+// Returns the number of entries removed.
+func InvalidateCachedForShop(ctx context.Context, ddb CacheInvalidator, userSub, shop string) (int, error) {
+	table, err := cacheTable()
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []map[string]ddbtypes.AttributeValue
+	var startKey map[string]ddbtypes.AttributeValue
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+			FilterExpression:       aws.String("contains(Shops, :shop)"),
+			ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+				":pk":   &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+				":p":    &ddbtypes.AttributeValueMemberS{Value: "NLQ#"},
+				":shop": &ddbtypes.AttributeValueMemberS{Value: strings.ToLower(strings.TrimSpace(shop))},
+			},
+			ProjectionExpression: aws.String("PK, SK"),
+			ExclusiveStartKey:    startKey,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("cache Query: %w", err)
+		}
+		keys = append(keys, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+
+	// BatchWriteItem takes at most 25 requests.
+	for i := 0; i < len(keys); i += 25 {
+		end := i + 25
+		if end > len(keys) {
+			end = len(keys)
+		}
+		reqs := make([]ddbtypes.WriteRequest, 0, end-i)
+		for _, k := range keys[i:end] {
+			reqs = append(reqs, ddbtypes.WriteRequest{DeleteRequest: &ddbtypes.DeleteRequest{Key: k}})
+		}
+		out, err := ddb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]ddbtypes.WriteRequest{table: reqs},
+		})
+		if err != nil {
+			return i, fmt.Errorf("cache BatchWriteItem: %w", err)
+		}
+		if n := len(out.UnprocessedItems[table]); n > 0 {
+			return i + len(reqs) - n, fmt.Errorf("cache BatchWriteItem: %d unprocessed", n)
+		}
+	}
+	return len(keys), nil
+}
+
+func uniqueLower(in []string) []string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		k := strings.ToLower(strings.TrimSpace(s))
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, k)
+	}
+	return out
+}
+
 func SchemaHash(schemaText string) string {
 	return HashKeyMaterial(schemaText)
 }
@@ -0,0 +1,96 @@
+package nlq
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Confidence routing: SQL the model itself rates below NLQ_CONFIDENCE_FLOOR
+// is not run as if it were sound. With NLQ_LOW_CONFIDENCE_MODE=clarify (the
+// default) the ask becomes a clarification, the model's assumptions put to
+// the user to confirm; with flag the query runs and the answer is marked
+// low_confidence. A rewrite from the fix loop has run already by the time
+// its confidence is known, so it is only ever flagged; a clarification the
+// fix loop ends on is always low_confidence: the model's SQL failed and it
+// could not rewrite it.
+//
+// The floor is separate from NLQ_MIN_CONFIDENCE, which moves the ask down
+// the model chain (models.go); it applies to the last model's answer.
+// NLQ_CONFIDENCE_FLOOR=0 turns routing off.
+
+const (
+	LowConfidenceClarify = "clarify"
+	LowConfidenceFlag    = "flag"
+
+	defaultConfidenceFloor = 0.4
+)
+
+// ConfidencePolicy is the floor and what to do below it.
+type ConfidencePolicy struct {
+	Floor float64
+	Mode  string
+}
+
+func ConfidencePolicyFromEnv() ConfidencePolicy {
+	p := ConfidencePolicy{Floor: defaultConfidenceFloor, Mode: LowConfidenceClarify}
+	if v := strings.TrimSpace(os.Getenv("NLQ_CONFIDENCE_FLOOR")); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+			p.Floor = f
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("NLQ_LOW_CONFIDENCE_MODE")), LowConfidenceFlag) {
+		p.Mode = LowConfidenceFlag
+	}
+	return p
+}
+
+// Low reports SQL below the floor. A clarification has no SQL to doubt.
+func (p ConfidencePolicy) Low(res *LLMResult) bool {
+	return res != nil && !res.NeedsClarification && res.Confidence < p.Floor
+}
+
+// AfterFix is Low for what ExecuteWithSelfCorrection returned, with a
+// clarification in place of a fix counted low.
+func (p ConfidencePolicy) AfterFix(res *LLMResult) bool {
+	return res != nil && res.NeedsClarification || p.Low(res)
+}
+
+// Route applies p to the model's first SQL before it runs. In clarify mode
+// a low-confidence res is turned into a clarification, asked in lang, and
+// Route reports true; the caller then answers it as one. In flag mode res
+// is left alone and the caller flags the answer (Low).
+func (p ConfidencePolicy) Route(res *LLMResult, lang string) bool {
+	if p.Mode != LowConfidenceClarify || !p.Low(res) {
+		return false
+	}
+	res.NeedsClarification = true
+	res.ClarifyingQuestion = aws.String(lowConfidenceQuestion(lang, res.Assumptions))
+	return true
+}
+
+// lowConfidenceQuestions ask the user to confirm or reword, by language;
+// other languages get English.
+var lowConfidenceQuestions = map[string][2]string{
+	"en": {
+		"I'm not sure I understood the question. Could you rephrase it, naming the metric, shops and dates you mean?",
+		"I'm not sure I understood the question. I would assume: %s. Is that right, or could you rephrase it?",
+	},
+	"vi": {
+		"Tôi chưa chắc đã hiểu đúng câu hỏi. Bạn có thể diễn đạt lại, nêu rõ chỉ số, cửa hàng và khoảng thời gian không?",
+		"Tôi chưa chắc đã hiểu đúng câu hỏi. Tôi sẽ giả định: %s. Như vậy có đúng không, hay bạn có thể diễn đạt lại?",
+	},
+}
+
+func lowConfidenceQuestion(lang string, assumptions []string) string {
+	q, ok := lowConfidenceQuestions[lang]
+	if !ok {
+		q = lowConfidenceQuestions["en"]
+	}
+	if len(assumptions) == 0 {
+		return q[0]
+	}
+	return strings.Replace(q[1], "%s", strings.Join(assumptions, "; "), 1)
+}
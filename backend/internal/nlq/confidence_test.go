@@ -0,0 +1,29 @@
+package nlq
+
+import "testing"
+
+func TestConfidencePolicy(t *testing.T) {
+	p := ConfidencePolicy{Floor: 0.5, Mode: LowConfidenceClarify}
+	sure := &LLMResult{SQL: "SELECT 1", Confidence: 0.9}
+	unsure := &LLMResult{SQL: "SELECT 1", Confidence: 0.2}
+	question := &LLMResult{NeedsClarification: true, Confidence: 0.9}
+
+	if p.Low(sure) || !p.Low(unsure) || p.Low(question) {
+		t.Fatal("Low: want only SQL below the floor")
+	}
+
+	// The fix loop ends on a question instead of a rewrite: low, whatever
+	// the model's confidence in the question.
+	if !p.AfterFix(question) || !p.AfterFix(unsure) || p.AfterFix(sure) || p.AfterFix(nil) {
+		t.Fatal("AfterFix: want clarifications and SQL below the floor")
+	}
+
+	res := *unsure
+	if !p.Route(&res, "vi") || !res.NeedsClarification || res.ClarifyingQuestion == nil {
+		t.Fatal("Route: low-confidence SQL not turned into a clarification")
+	}
+	res = *unsure
+	if (ConfidencePolicy{Floor: 0.5, Mode: LowConfidenceFlag}).Route(&res, "en") || res.NeedsClarification {
+		t.Fatal("Route: flag mode changed the result")
+	}
+}
@@ -0,0 +1,175 @@
+package nlq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/notify"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Conversation history lets a session ask follow-ups ("and what about last
+// week?"): each answered question is kept with the SQL that answered it, and
+// the latest turns are put in the prompt for the next question.
+//
+// CONVERSATIONS_TABLE
+// PK = USER#<sub>
+// SK = SESSION#<id>#TURN#<RFC3339Nano>
+
+type ConversationClient interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// Turn is one question of a session and how it was answered.
+type Turn struct {
+	Question    string   `json:"question"`
+	SQL         string   `json:"sql,omitempty"`
+	Assumptions []string `json:"assumptions,omitempty"`
+	Answer      string   `json:"answer,omitempty"` // short rendering of the result or the clarifying question
+	At          string   `json:"at"`
+}
+
+const (
+	// conversationTTL matches sessionContextTTL: a working day after the last ask.
+	conversationTTL = 24 * time.Hour
+
+	// HistoryTurns is how many of the latest turns go into the prompt.
+	HistoryTurns = 5
+
+	answerPreviewRows = 3
+	maxAnswerLen      = 400
+)
+
+func conversationsTable() (string, error) {
+	t := strings.TrimSpace(os.Getenv("CONVERSATIONS_TABLE"))
+	if t == "" {
+		return "", fmt.Errorf("missing CONVERSATIONS_TABLE")
+	}
+	return t, nil
+}
+
+func makeTurnPrefix(sessionID string) string {
+	return "SESSION#" + sessionID + "#TURN#"
+}
+
+// GetHistory loads up to n of a session's latest turns, oldest first.
+func GetHistory(ctx context.Context, ddb ConversationClient, userSub, sessionID string, n int) ([]Turn, error) {
+	table, err := conversationsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":pk": &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+			":p":  &ddbtypes.AttributeValueMemberS{Value: makeTurnPrefix(sessionID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(n)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("conversation Query: %w", err)
+	}
+
+	turns := make([]Turn, 0, len(out.Items))
+	for i := len(out.Items) - 1; i >= 0; i-- {
+		payloadAttr, ok := out.Items[i]["Payload"].(*ddbtypes.AttributeValueMemberS)
+		if !ok {
+			continue
+		}
+		var t Turn
+		if err := json.Unmarshal([]byte(payloadAttr.Value), &t); err != nil {
+			continue
+		}
+		turns = append(turns, t)
+	}
+	return turns, nil
+}
+
+// AppendTurn records t as the session's latest turn.
+func AppendTurn(ctx context.Context, ddb ConversationClient, userSub, sessionID string, t Turn) error {
+	table, err := conversationsTable()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	t.At = now.Format(time.RFC3339Nano)
+	b, _ := json.Marshal(t)
+
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]ddbtypes.AttributeValue{
+			"PK":        &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+			"SK":        &ddbtypes.AttributeValueMemberS{Value: makeTurnPrefix(sessionID) + t.At},
+			"ExpiresAt": &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(conversationTTL).Unix())},
+			"Payload":   &ddbtypes.AttributeValueMemberS{Value: string(b)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("conversation PutItem: %w", err)
+	}
+	return nil
+}
+
+// AnswerText renders the first rows of a result for the history, so the
+// model can refer back to the numbers without the whole table.
+func AnswerText(columns []string, rows []map[string]any) string {
+	if len(rows) == 0 {
+		return "no rows"
+	}
+	var lines []string
+	for i, r := range rows {
+		if i == answerPreviewRows {
+			lines = append(lines, fmt.Sprintf("... %d rows in total", len(rows)))
+			break
+		}
+		cells := make([]string, 0, len(columns))
+		for _, c := range columns {
+			cells = append(cells, fmt.Sprintf("%s=%v", c, r[c]))
+		}
+		lines = append(lines, strings.Join(cells, ", "))
+	}
+	return notify.Truncate(strings.Join(lines, "; "), maxAnswerLen)
+}
+
+// HistoryText renders turns as prompt lines; empty when there are none.
+func HistoryText(turns []Turn) string {
+	var b strings.Builder
+	for i, t := range turns {
+		fmt.Fprintf(&b, "%d. Q: %s\n", i+1, t.Question)
+		if t.SQL != "" {
+			fmt.Fprintf(&b, "   SQL: %s\n", strings.Join(strings.Fields(t.SQL), " "))
+		}
+		if len(t.Assumptions) > 0 {
+			fmt.Fprintf(&b, "   Assumptions: %s\n", strings.Join(t.Assumptions, "; "))
+		}
+		if t.Answer != "" {
+			fmt.Fprintf(&b, "   A: %s\n", t.Answer)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// HistoryCacheMaterial folds the turns a follow-up depends on into the NLQ
+// cache key, so "and last week?" is not answered from another thread.
+func HistoryCacheMaterial(turns []Turn) string {
+	if len(turns) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(turns))
+	for _, t := range turns {
+		parts = append(parts, NormalizeQuestion(t.Question)+"="+t.SQL)
+	}
+	return HashKeyMaterial(strings.Join(parts, "\n"))
+}
@@ -0,0 +1,102 @@
+package nlq
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Data versions keep cached answers from outliving the data they were read
+// from: the daily metrics ETL bumps a shop's counter after writing its rows,
+// and the versions of the asked shops are part of the cache key, so the next
+// ask after an ETL run goes to Athena again.
+//
+// NLQ_CACHE_TABLE
+// PK = SHOP#<shop>
+// SK = DATAVERSION
+
+type DataVersionClient interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+func dataVersionKey(shop string) map[string]ddbtypes.AttributeValue {
+	return map[string]ddbtypes.AttributeValue{
+		"PK": &ddbtypes.AttributeValueMemberS{Value: "SHOP#" + strings.ToLower(shop)},
+		"SK": &ddbtypes.AttributeValueMemberS{Value: "DATAVERSION"},
+	}
+}
+
+// BumpDataVersion marks shop's analytics data as changed.
+func BumpDataVersion(ctx context.Context, ddb DataVersionClient, shop string) error {
+	table, err := cacheTable()
+	if err != nil {
+		return err
+	}
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(table),
+		Key:              dataVersionKey(shop),
+		UpdateExpression: aws.String("ADD Version :one"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":one": &ddbtypes.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("bump data version %s: %w", shop, err)
+	}
+	return nil
+}
+
+// DataVersionMaterial returns the cache key material for the current data
+// versions of shops; a shop never written by the ETL counts as version 0.
+func DataVersionMaterial(ctx context.Context, ddb DataVersionClient, shops []string) (string, error) {
+	table, err := cacheTable()
+	if err != nil {
+		return "", err
+	}
+	versions := map[string]string{}
+	for start := 0; start < len(shops); start += 100 {
+		end := min(start+100, len(shops))
+		keys := make([]map[string]ddbtypes.AttributeValue, 0, end-start)
+		seen := map[string]bool{}
+		for _, s := range shops[start:end] {
+			s = strings.ToLower(s)
+			if !seen[s] {
+				seen[s] = true
+				keys = append(keys, dataVersionKey(s))
+			}
+		}
+		req := map[string]ddbtypes.KeysAndAttributes{table: {Keys: keys}}
+		for len(req) > 0 {
+			out, err := ddb.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: req})
+			if err != nil {
+				return "", fmt.Errorf("data versions BatchGetItem: %w", err)
+			}
+			for _, it := range out.Responses[table] {
+				pk, _ := it["PK"].(*ddbtypes.AttributeValueMemberS)
+				v, _ := it["Version"].(*ddbtypes.AttributeValueMemberN)
+				if pk != nil && v != nil {
+					versions[strings.TrimPrefix(pk.Value, "SHOP#")] = v.Value
+				}
+			}
+			req = out.UnprocessedKeys
+		}
+	}
+
+	parts := make([]string, 0, len(shops))
+	for _, s := range shops {
+		s = strings.ToLower(s)
+		v := versions[s]
+		if v == "" {
+			v = "0"
+		}
+		parts = append(parts, s+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ","), nil
+}
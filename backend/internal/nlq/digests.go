@@ -0,0 +1,334 @@
+package nlq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Digests are saved questions ("yesterday's net profit by shop") that the
+// nlq-digests job asks again every morning, emailing the answer through the
+// user's alerts topic. The question is re-asked, not the SQL re-run, so
+// relative dates move with the day and schema changes are picked up.
+//
+// NLQ_DIGESTS_TABLE
+// PK = USER#<sub>
+// SK = DIGEST#<id>
+//
+// NextRun is the day the digest is next due.
+
+// MaxDigests caps the digests one user can keep.
+const MaxDigests = 10
+
+// MaxDigestQuestionLen bounds a saved question.
+const MaxDigestQuestionLen = 500
+
+// digestMaxDays is the lookback a digest question may use, as for /ask.
+const digestMaxDays = 90
+
+var ErrDigestNotFound = errors.New("digest not found")
+
+type Digest struct {
+	Id       string   `dynamodbav:"DigestId" json:"id"`
+	UserSub  string   `dynamodbav:"UserSub" json:"-"`
+	Question string   `dynamodbav:"Question" json:"question"`
+	ShopIDs  []string `dynamodbav:"ShopIds,omitempty" json:"shop_ids,omitempty"` // empty = every shop of the user at run time
+	NextRun  string   `dynamodbav:"NextRun" json:"next_run"`                     // YYYY-MM-DD
+
+	LastRunAt   string `dynamodbav:"LastRunAt,omitempty" json:"last_run_at,omitempty"`
+	LastOutcome string `dynamodbav:"LastOutcome,omitempty" json:"last_outcome,omitempty"` // an Ask* outcome
+	LastReason  string `dynamodbav:"LastReason,omitempty" json:"last_reason,omitempty"`
+	CreatedAt   string `dynamodbav:"CreatedAt" json:"created_at"`
+}
+
+func digestsTable() (string, error) {
+	t := strings.TrimSpace(os.Getenv("NLQ_DIGESTS_TABLE"))
+	if t == "" {
+		return "", fmt.Errorf("missing NLQ_DIGESTS_TABLE")
+	}
+	return t, nil
+}
+
+func digestKey(sub, id string) map[string]ddbtypes.AttributeValue {
+	return map[string]ddbtypes.AttributeValue{
+		"PK": &ddbtypes.AttributeValueMemberS{Value: "USER#" + sub},
+		"SK": &ddbtypes.AttributeValueMemberS{Value: "DIGEST#" + id},
+	}
+}
+
+// PutDigest saves a new digest; it first runs the next morning.
+func PutDigest(ctx context.Context, ddb *dynamodb.Client, sub string, d Digest) (Digest, error) {
+	table, err := digestsTable()
+	if err != nil {
+		return d, err
+	}
+	now := time.Now().UTC()
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return d, err
+	}
+	d.Id = hex.EncodeToString(b)
+	d.UserSub = sub
+	d.CreatedAt = now.Format(time.RFC3339)
+	d.NextRun = now.AddDate(0, 0, 1).Format("2006-01-02")
+
+	item, err := attributevalue.MarshalMap(d)
+	if err != nil {
+		return d, err
+	}
+	for k, v := range digestKey(sub, d.Id) {
+		item[k] = v
+	}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: item}); err != nil {
+		return d, fmt.Errorf("put digest: %w", err)
+	}
+	return d, nil
+}
+
+func ListDigests(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Digest, error) {
+	table, err := digestsTable()
+	if err != nil {
+		return nil, err
+	}
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":pk": &ddbtypes.AttributeValueMemberS{Value: "USER#" + sub},
+			":p":  &ddbtypes.AttributeValueMemberS{Value: "DIGEST#"},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query digests: %w", err)
+	}
+	digests := []Digest{}
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &digests)
+	return digests, err
+}
+
+// DeleteDigest stops a digest; ErrDigestNotFound when the user has none
+// with that id.
+func DeleteDigest(ctx context.Context, ddb *dynamodb.Client, sub, id string) error {
+	table, err := digestsTable()
+	if err != nil {
+		return err
+	}
+	_, err = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(table),
+		Key:                 digestKey(sub, id),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	})
+	var cfe *ddbtypes.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return ErrDigestNotFound
+	}
+	return err
+}
+
+// DueDigests scans for digests whose next run is on or before today.
+func DueDigests(ctx context.Context, ddb *dynamodb.Client, today string) ([]Digest, error) {
+	table, err := digestsTable()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		digests  []Digest
+		startKey map[string]ddbtypes.AttributeValue
+	)
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(table),
+			FilterExpression: aws.String("NextRun <= :today"),
+			ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+				":today": &ddbtypes.AttributeValueMemberS{Value: today},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan digests: %w", err)
+		}
+		var page []Digest
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		digests = append(digests, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return digests, nil
+}
+
+// AdvanceDigest records a run's outcome and moves NextRun to tomorrow.
+// Conditional on NextRun so an overlapping run can't move it back, and on
+// the item existing so a digest deleted mid-run stays deleted.
+func AdvanceDigest(ctx context.Context, ddb *dynamodb.Client, d Digest, today time.Time, outcome, reason string) error {
+	table, err := digestsTable()
+	if err != nil {
+		return err
+	}
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 digestKey(d.UserSub, d.Id),
+		UpdateExpression:    aws.String("SET NextRun = :next, LastRunAt = :now, LastOutcome = :o, LastReason = :r"),
+		ConditionExpression: aws.String("attribute_exists(PK) AND NextRun = :prev"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":next": &ddbtypes.AttributeValueMemberS{Value: today.AddDate(0, 0, 1).Format("2006-01-02")},
+			":prev": &ddbtypes.AttributeValueMemberS{Value: d.NextRun},
+			":now":  &ddbtypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":o":    &ddbtypes.AttributeValueMemberS{Value: outcome},
+			":r":    &ddbtypes.AttributeValueMemberS{Value: reason},
+		},
+	})
+	var cfe *ddbtypes.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		return fmt.Errorf("advance digest %s: %w", d.Id, err)
+	}
+	return nil
+}
+
+// DigestRun is one run of a digest.
+type DigestRun struct {
+	Digest       Digest
+	AllowedShops []string // the user's shops today
+	Fiscal       string   // LLMRequest.FiscalCalendar
+	Athena       AthenaRunOptions
+}
+
+// DigestAnswer is what a run produced. Ask is its ask log entry and is
+// filled in whatever the outcome; Answer and the rows only for AskResult.
+type DigestAnswer struct {
+	Ask       AskRecord
+	Answer    string
+	Columns   []string
+	Rows      []map[string]any
+	Rejection error // the validator's, when it rejected the model's SQL
+}
+
+// AnswerDigest asks a digest's question the way /ask does: the same prompt,
+// validator, scoping and fix loop, without a session, pinned context or the
+// answer cache. Shops the user no longer has are dropped. Rejections,
+// clarifications and Athena failures are outcomes on Ask, not errors.
+func AnswerDigest(ctx context.Context, glue GlueClient, br BedrockClient, ath AthenaClient, run DigestRun) (DigestAnswer, error) {
+	d := run.Digest
+	lang := DetectLanguage(d.Question)
+	out := DigestAnswer{Ask: AskRecord{Question: d.Question, DigestID: d.Id, Language: lang, Outcome: AskError}}
+	fail := func(err error) (DigestAnswer, error) {
+		out.Ask.Reason = err.Error()
+		return out, err
+	}
+
+	shops := run.AllowedShops
+	if len(d.ShopIDs) > 0 {
+		allowed := map[string]bool{}
+		for _, s := range run.AllowedShops {
+			allowed[strings.ToLower(s)] = true
+		}
+		shops = nil
+		for _, s := range d.ShopIDs {
+			if allowed[strings.ToLower(s)] {
+				shops = append(shops, s)
+			}
+		}
+	}
+	if len(shops) == 0 {
+		out.Ask.Outcome = AskNoShops
+		return out, nil
+	}
+	out.Ask.Shops = shops
+
+	schemas, err := LoadSchemasFromEnv(ctx, glue)
+	if err != nil {
+		return fail(err)
+	}
+	schemaText := ComposeSchemaText(schemas)
+	out.Ask.SchemaHash = SchemaHash(schemaText)
+	today := TodayISO()
+	tz := "Asia/Ho_Chi_Minh"
+
+	llmRes, err := InvokeBedrock(ctx, br, BuildPrompt(LLMRequest{
+		Question:        d.Question,
+		AllowedShopIDs:  shops,
+		MaxDaysLookback: digestMaxDays,
+		SchemaText:      schemaText,
+		TodayISO:        today,
+		DefaultTimezone: tz,
+		FiscalCalendar:  run.Fiscal,
+		Language:        lang,
+	}))
+	if err != nil {
+		return fail(err)
+	}
+	confidence := ConfidencePolicyFromEnv()
+	out.Ask.LowConfidence = confidence.Route(llmRes, lang)
+	if llmRes.NeedsClarification {
+		out.Ask.Outcome, out.Ask.Reason = AskClarification, aws.ToString(llmRes.ClarifyingQuestion)
+		return out, nil
+	}
+
+	opt := ValidateOptions{
+		AllowedShopIDs:  shops,
+		RequireDTFilter: true,
+		MaxDaysLookback: digestMaxDays,
+		TodayISO:        today,
+		Tables:          schemas,
+	}
+	out.Ask.SQL, out.Ask.Model = llmRes.SQL, llmRes.Model
+
+	final, res, fix, err := ExecuteWithSelfCorrection(ctx, br, ath, opt, run.Athena, d.Question, schemaText, shops, digestMaxDays, today, tz, llmRes, FixPolicyFromEnv())
+	out.Ask.FixAttempts, out.Ask.FixFailures = fix.Attempts, fix.Failures
+	out.Ask.Validation = ValidationPassed
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		out.Ask.Validation, out.Rejection = ValidationRejected, rejected.Err
+	}
+	if rejected != nil && rejected.Label == "initial" {
+		out.Ask.Outcome, out.Ask.Reason = AskSQLRejected, rejected.Err.Error()
+		return out, nil
+	}
+	out.Ask.LowConfidence = confidence.AfterFix(final)
+	if final != nil {
+		out.Ask.SQL, out.Ask.Model = final.SQL, final.Model
+	}
+	if err != nil {
+		out.Ask.Outcome, out.Ask.Reason = AskAthenaFailed, err.Error()
+		var athErr *AthenaError
+		if errors.As(err, &athErr) {
+			out.Ask.QueryID = athErr.QueryExecutionID
+		}
+		return out, nil
+	}
+	if res == nil {
+		out.Ask.Outcome, out.Ask.Reason = AskClarification, aws.ToString(final.ClarifyingQuestion)
+		return out, nil
+	}
+
+	out.Ask.Outcome = AskResult
+	out.Ask.QueryID, out.Ask.ScannedBytes, out.Ask.ExecMs = res.QueryExecutionID, res.ScannedBytes, res.ExecutionMs
+	out.Columns, out.Rows = res.Columns, res.Rows
+	out.Answer, err = SynthesizeAnswer(ctx, br, AnswerRequest{
+		Question:    d.Question,
+		SQL:         final.SQL,
+		Columns:     res.Columns,
+		Rows:        res.Rows,
+		Assumptions: final.Assumptions,
+		TodayISO:    today,
+		Language:    lang,
+	})
+	if err != nil {
+		// The rows still go out; the email falls back to them.
+		fmt.Printf("digest %s: answer synthesis failed: %v\n", d.Id, err)
+	}
+	return out, nil
+}
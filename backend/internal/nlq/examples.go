@@ -0,0 +1,230 @@
+package nlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Feedback on /ask results (POST /ask/feedback) turns into few-shot
+// examples: a thumbs up keeps the question->SQL pair, a correction replaces
+// its SQL, and the best-rated pairs for the current schema go into the
+// prompt. Examples are the user's own: their SQL names the user's shops, so
+// they are never shown to another tenant. They live in NLQ_HISTORY_TABLE
+// next to the ask log, keyed by schema hash so a schema change retires them.
+
+const (
+	RatingUp   = "up"
+	RatingDown = "down"
+
+	// PromptExamples is how many examples go into the prompt.
+	PromptExamples = 3
+
+	// examplesScanned bounds the examples read to pick the best ones.
+	examplesScanned = 200
+)
+
+var ErrAlreadyRated = errors.New("query already rated")
+
+type ExampleClient interface {
+	AskLogClient
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// Example is a rated question->SQL pair.
+type Example struct {
+	Question  string `dynamodbav:"Question" json:"question"`
+	SQL       string `dynamodbav:"Sql" json:"sql"`
+	Up        int    `dynamodbav:"Up" json:"up"`
+	Down      int    `dynamodbav:"Down" json:"down"`
+	Corrected bool   `dynamodbav:"Corrected,omitempty" json:"corrected,omitempty"` // SQL written by the user
+	UpdatedAt string `dynamodbav:"UpdatedAt" json:"updated_at"`
+}
+
+func (e Example) score() int { return e.Up - e.Down }
+
+// Feedback is the user's rating of one /ask result.
+type Feedback struct {
+	QueryID    string `dynamodbav:"QueryId" json:"query_id"`
+	Rating     string `dynamodbav:"Rating" json:"rating"`
+	Correction string `dynamodbav:"Correction,omitempty" json:"correction,omitempty"` // SQL that answers the question instead
+	Comment    string `dynamodbav:"Comment,omitempty" json:"comment,omitempty"`
+	CreatedAt  string `dynamodbav:"CreatedAt" json:"created_at"`
+}
+
+func exampleSK(schemaHash, question string) string {
+	return "EXAMPLE#" + schemaHash + "#" + HashKeyMaterial(NormalizeQuestion(question))
+}
+
+func userKey(userSub, sk string) map[string]ddbtypes.AttributeValue {
+	return map[string]ddbtypes.AttributeValue{
+		"PK": &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+		"SK": &ddbtypes.AttributeValueMemberS{Value: sk},
+	}
+}
+
+// RecordFeedback stores f for ask (the ask with f.QueryID) and updates the
+// example for its question. A query can be rated once (ErrAlreadyRated).
+// A correction must already have passed ValidateSQL.
+func RecordFeedback(ctx context.Context, ddb ExampleClient, userSub string, ask AskRecord, f Feedback) error {
+	table, err := askLogTable()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	f.CreatedAt = now.Format(time.RFC3339)
+	item, err := attributevalue.MarshalMap(f)
+	if err != nil {
+		return err
+	}
+	for k, v := range userKey(userSub, "FEEDBACK#"+f.QueryID) {
+		item[k] = v
+	}
+	item["ExpiresAt"] = &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(askLogRetention).Unix())}
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	var cfe *ddbtypes.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return ErrAlreadyRated
+	}
+	if err != nil {
+		return fmt.Errorf("feedback PutItem: %w", err)
+	}
+
+	if ask.SchemaHash == "" {
+		return nil
+	}
+	key := userKey(userSub, exampleSK(ask.SchemaHash, ask.Question))
+	nowS := &ddbtypes.AttributeValueMemberS{Value: f.CreatedAt}
+
+	if f.Correction != "" {
+		return putExample(ctx, ddb, table, key, Example{Question: ask.Question, SQL: f.Correction, Up: 1, Corrected: true, UpdatedAt: f.CreatedAt})
+	}
+
+	// Count the vote when the example still holds this SQL (or is new).
+	up := f.Rating == RatingUp
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET Question = :q, Sql = :sql, UpdatedAt = :now, Up = if_not_exists(Up, :zero) + :up, Down = if_not_exists(Down, :zero) + :down"),
+		ConditionExpression: aws.String("attribute_not_exists(Sql) OR Sql = :sql"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":q":    &ddbtypes.AttributeValueMemberS{Value: ask.Question},
+			":sql":  &ddbtypes.AttributeValueMemberS{Value: ask.SQL},
+			":now":  nowS,
+			":zero": &ddbtypes.AttributeValueMemberN{Value: "0"},
+			":up":   &ddbtypes.AttributeValueMemberN{Value: boolNum(up)},
+			":down": &ddbtypes.AttributeValueMemberN{Value: boolNum(!up)},
+		},
+	})
+	if errors.As(err, &cfe) {
+		// The example holds other SQL: an up vote replaces it, a down vote
+		// is about SQL the prompt no longer uses.
+		if up {
+			return putExample(ctx, ddb, table, key, Example{Question: ask.Question, SQL: ask.SQL, Up: 1, UpdatedAt: f.CreatedAt})
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("example UpdateItem: %w", err)
+	}
+	return nil
+}
+
+func putExample(ctx context.Context, ddb ExampleClient, table string, key map[string]ddbtypes.AttributeValue, e Example) error {
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return err
+	}
+	for k, v := range key {
+		item[k] = v
+	}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: item}); err != nil {
+		return fmt.Errorf("example PutItem: %w", err)
+	}
+	return nil
+}
+
+func boolNum(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// TopExamples returns up to n of the user's examples for schemaHash with a
+// positive score, best first (newest first among equals).
+func TopExamples(ctx context.Context, ddb ExampleClient, userSub, schemaHash string, n int) ([]Example, error) {
+	table, err := askLogTable()
+	if err != nil {
+		return nil, err
+	}
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":pk": &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+			":p":  &ddbtypes.AttributeValueMemberS{Value: "EXAMPLE#" + schemaHash + "#"},
+		},
+		Limit: aws.Int32(examplesScanned),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("examples Query: %w", err)
+	}
+	var all []Example
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &all); err != nil {
+		return nil, err
+	}
+
+	examples := make([]Example, 0, len(all))
+	for _, e := range all {
+		if e.score() > 0 && e.SQL != "" {
+			examples = append(examples, e)
+		}
+	}
+	sort.SliceStable(examples, func(i, j int) bool {
+		if examples[i].score() != examples[j].score() {
+			return examples[i].score() > examples[j].score()
+		}
+		return examples[i].UpdatedAt > examples[j].UpdatedAt
+	})
+	if len(examples) > n {
+		examples = examples[:n]
+	}
+	return examples, nil
+}
+
+// ExamplesText renders examples as prompt lines; empty when there are none.
+func ExamplesText(examples []Example) string {
+	var b strings.Builder
+	for i, e := range examples {
+		fmt.Fprintf(&b, "%d. Q: %s\n", i+1, e.Question)
+		fmt.Fprintf(&b, "   SQL: %s\n", strings.Join(strings.Fields(e.SQL), " "))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// ExamplesCacheMaterial folds the examples into the NLQ cache key, so new
+// feedback is not hidden behind a cached answer.
+func ExamplesCacheMaterial(examples []Example) string {
+	if len(examples) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(examples))
+	for _, e := range examples {
+		parts = append(parts, NormalizeQuestion(e.Question)+"="+e.SQL)
+	}
+	return HashKeyMaterial("examples\n" + strings.Join(parts, "\n"))
+}
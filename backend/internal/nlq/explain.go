@@ -0,0 +1,530 @@
+package nlq
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/internal/sqlast"
+)
+
+// ExplainSQL describes in plain English what sql does, for users who don't
+// read SQL to sanity-check an answer against: the tables it reads, the
+// shops and days it is limited to, its other filters, what it calculates
+// and how it groups and orders the rows. It is templated from the parsed
+// query, so it says what the SQL does rather than what the model meant it
+// to do, and costs no model call. schemas name the tables and columns (by
+// their glossary labels); nil falls back to the glossary alone. "" when sql
+// does not parse.
+func ExplainSQL(sql string, schemas []*TableSchema) string {
+	q, err := sqlast.Parse(strings.TrimSpace(sql))
+	if err != nil {
+		return ""
+	}
+	e := &explainer{schemas: schemas}
+	e.walk(q)
+
+	var sentences []string
+	reads := "Reads " + joinAnd(e.tables)
+	if len(e.tables) == 0 {
+		reads = "Reads no table"
+	}
+	if len(e.shops) > 0 {
+		noun := "shop"
+		if len(e.shops) > 1 {
+			noun = "shops"
+		}
+		reads += fmt.Sprintf(" for %s %s", noun, joinAnd(e.shops))
+	}
+	if w := e.window(); w != "" {
+		reads += ", " + w
+	}
+	if len(e.filters) > 0 {
+		reads += ", where " + joinAnd(e.filters)
+	}
+	if e.otherFilters {
+		reads += ", with other conditions"
+	}
+	sentences = append(sentences, reads)
+
+	if len(e.measures) > 0 {
+		s := "Calculates " + joinAnd(e.measures)
+		if len(e.groups) > 0 {
+			s += " per " + joinAnd(e.groups)
+		}
+		sentences = append(sentences, s)
+	} else if len(e.columns) > 0 {
+		sentences = append(sentences, "Lists "+joinAnd(e.columns))
+	}
+	if o := e.order(q); o != "" {
+		sentences = append(sentences, o)
+	}
+	return strings.Join(sentences, ". ") + "."
+}
+
+type explainer struct {
+	schemas []*TableSchema
+
+	tables       []string
+	shops        []string
+	from, to     string // dt window, YYYY-MM-DD; "" when open
+	days         []string
+	filters      []string
+	otherFilters bool
+	measures     []string
+	groups       []string
+	columns      []string // outermost SELECT, when nothing is aggregated
+}
+
+func (e *explainer) walk(q *sqlast.Query) {
+	for _, t := range sqlast.BaseTables(q) {
+		e.tables = appendNew(e.tables, e.tableLabel(t.Name[len(t.Name)-1]))
+	}
+	outer := outerSelect(q.Body)
+	sqlast.Inspect(q, func(n sqlast.Node) bool {
+		s, ok := n.(*sqlast.Select)
+		if !ok {
+			return true
+		}
+		for _, c := range conjuncts(s.Where) {
+			e.condition(c)
+		}
+		for _, it := range s.Items {
+			if m := e.measure(it, s == outer); m != "" {
+				e.measures = appendNew(e.measures, m)
+			}
+		}
+		for _, g := range s.GroupBy {
+			if l := e.groupLabel(g); l != "" {
+				e.groups = appendNew(e.groups, l)
+			}
+		}
+		return true
+	})
+	if outer != nil && len(e.measures) == 0 {
+		for _, it := range outer.Items {
+			switch {
+			case it.Star:
+				e.columns = appendNew(e.columns, "every column")
+			case it.Alias != "":
+				e.columns = appendNew(e.columns, it.Alias)
+			case columnRef(it.Expr) != nil:
+				e.columns = appendNew(e.columns, e.word(lastPart(columnRef(it.Expr))))
+			}
+		}
+	}
+}
+
+// outerSelect is the SELECT whose rows the query returns (the first branch
+// of a UNION).
+func outerSelect(b sqlast.QueryBody) *sqlast.Select {
+	switch b := b.(type) {
+	case *sqlast.Select:
+		return b
+	case *sqlast.SetOp:
+		return outerSelect(b.Left)
+	case *sqlast.ParenQuery:
+		return outerSelect(b.Query.Body)
+	}
+	return nil
+}
+
+// condition files one WHERE conjunct as a shop, dt or other filter. Join
+// conditions (column = column) are left out.
+func (e *explainer) condition(c sqlast.Expr) {
+	if _, shops := shopValues(c); shops != nil {
+		for _, s := range shops {
+			e.shops = appendNew(e.shops, s)
+		}
+		return
+	}
+	if e.dtBound(c) {
+		return
+	}
+	if b, ok := c.(*sqlast.Binary); ok && columnRef(b.Left) != nil && columnRef(b.Right) != nil {
+		return
+	}
+	if f := e.filter(c); f != "" {
+		e.filters = appendNew(e.filters, f)
+		return
+	}
+	e.otherFilters = true
+}
+
+// dtBound widens the window by one dt comparison with a literal date.
+func (e *explainer) dtBound(c sqlast.Expr) bool {
+	isDT := func(x sqlast.Expr) bool {
+		id := columnRef(x)
+		return id != nil && lastPart(id) == "dt"
+	}
+	day := func(x sqlast.Expr) (string, bool) {
+		d, ok := dateLiteral(x)
+		if len(d) > 10 {
+			d = d[:10]
+		}
+		return d, ok && len(d) == 10
+	}
+	switch c := c.(type) {
+	case *sqlast.Binary:
+		op, col, val := c.Op, c.Left, c.Right
+		if !isDT(col) {
+			// d <= dt reads as dt >= d
+			op, col, val = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<=", "=": "="}[c.Op], c.Right, c.Left
+		}
+		d, ok := day(val)
+		if !isDT(col) || !ok {
+			return false
+		}
+		switch op {
+		case ">=":
+			e.lower(d)
+		case ">":
+			e.lower(addDay(d, 1))
+		case "<=":
+			e.upper(d)
+		case "<":
+			e.upper(addDay(d, -1))
+		case "=":
+			e.lower(d)
+			e.upper(d)
+		default:
+			return false
+		}
+		return true
+	case *sqlast.Between:
+		lo, ok1 := day(c.Low)
+		hi, ok2 := day(c.High)
+		if c.Not || !isDT(c.X) || !ok1 || !ok2 {
+			return false
+		}
+		e.lower(lo)
+		e.upper(hi)
+		return true
+	case *sqlast.In:
+		if c.Not || c.Query != nil || !isDT(c.X) {
+			return false
+		}
+		var days []string
+		for _, it := range c.List {
+			d, ok := day(it)
+			if !ok {
+				return false
+			}
+			days = append(days, d)
+		}
+		for _, d := range days {
+			e.days = appendNew(e.days, d)
+		}
+		return true
+	}
+	return false
+}
+
+func (e *explainer) lower(d string) {
+	if e.from == "" || d < e.from {
+		e.from = d
+	}
+}
+
+func (e *explainer) upper(d string) {
+	if e.to == "" || d > e.to {
+		e.to = d
+	}
+}
+
+func (e *explainer) window() string {
+	switch {
+	case len(e.days) > 0 && e.from == "" && e.to == "":
+		sort.Strings(e.days)
+		return "on " + joinAnd(e.days)
+	case e.from != "" && e.from == e.to:
+		return "on " + e.from
+	case e.from != "" && e.to != "":
+		return fmt.Sprintf("from %s to %s", e.from, e.to)
+	case e.from != "":
+		return "from " + e.from + " onwards"
+	case e.to != "":
+		return "up to " + e.to
+	}
+	return ""
+}
+
+func addDay(d string, n int) string {
+	t, err := time.Parse("2006-01-02", d)
+	if err != nil {
+		return d
+	}
+	return t.AddDate(0, 0, n).Format("2006-01-02")
+}
+
+// filter phrases a simple condition on one column; "" for anything else.
+func (e *explainer) filter(c sqlast.Expr) string {
+	switch c := c.(type) {
+	case *sqlast.Ident:
+		return e.word(lastPart(c)) + " is true"
+	case *sqlast.Unary:
+		if id := columnRef(c.X); c.Op == "not" && id != nil {
+			return e.word(lastPart(id)) + " is false"
+		}
+	case *sqlast.Binary:
+		id, v := columnRef(c.Left), literalText(c.Right)
+		op := c.Op
+		if id == nil {
+			id, v = columnRef(c.Right), literalText(c.Left)
+			op = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<=", "=": "=", "<>": "<>", "!=": "!="}[c.Op]
+		}
+		phrase := map[string]string{
+			"=": "is", "<>": "is not", "!=": "is not",
+			">": "is above", ">=": "is at least", "<": "is below", "<=": "is at most",
+		}[op]
+		if id != nil && v != "" && phrase != "" {
+			return fmt.Sprintf("%s %s %s", e.word(lastPart(id)), phrase, v)
+		}
+	case *sqlast.In:
+		id := columnRef(c.X)
+		if id == nil || c.Query != nil {
+			return ""
+		}
+		var vals []string
+		for _, it := range c.List {
+			v := literalText(it)
+			if v == "" {
+				return ""
+			}
+			vals = append(vals, v)
+		}
+		phrase := "is one of"
+		if c.Not {
+			phrase = "is none of"
+		}
+		return fmt.Sprintf("%s %s %s", e.word(lastPart(id)), phrase, strings.Join(vals, ", "))
+	case *sqlast.Like:
+		id, v := columnRef(c.X), literalText(c.Pattern)
+		if id == nil || v == "" {
+			return ""
+		}
+		phrase := "matches"
+		if c.Not {
+			phrase = "does not match"
+		}
+		return fmt.Sprintf("%s %s %s", e.word(lastPart(id)), phrase, v)
+	case *sqlast.IsNull:
+		if id := columnRef(c.X); id != nil {
+			if c.Not {
+				return e.word(lastPart(id)) + " is set"
+			}
+			return e.word(lastPart(id)) + " is empty"
+		}
+	}
+	return ""
+}
+
+// literalText is a literal as a user would write it; "" for anything else.
+func literalText(x sqlast.Expr) string {
+	l, ok := x.(*sqlast.Literal)
+	if !ok {
+		return ""
+	}
+	switch l.Kind {
+	case sqlast.LitString, sqlast.LitTyped:
+		return "'" + l.Value + "'"
+	case sqlast.LitNumber, sqlast.LitBool:
+		return l.Value
+	}
+	return ""
+}
+
+// aggregates are the calls a measure is named by.
+var aggregates = map[string]string{
+	"sum": "total", "avg": "average", "min": "lowest", "max": "highest",
+	"count": "number of", "approx_distinct": "number of distinct",
+}
+
+// measure describes a SELECT item that aggregates, or a calculated one of
+// the outermost SELECT: "total net revenue", or its alias with the columns
+// it is calculated from.
+func (e *explainer) measure(it *sqlast.SelectItem, outer bool) string {
+	if it.Star || it.Expr == nil {
+		return ""
+	}
+	var calls []*sqlast.Call
+	sqlast.Inspect(it.Expr, func(n sqlast.Node) bool {
+		if c, ok := n.(*sqlast.Call); ok && aggregates[c.Name] != "" && c.Over == nil {
+			calls = append(calls, c)
+			return false
+		}
+		return true
+	})
+	if len(calls) == 0 && (!outer || it.Alias == "" || columnRef(it.Expr) != nil) {
+		return ""
+	}
+	if c, ok := unwrapCoalesce(it.Expr).(*sqlast.Call); ok && len(calls) == 1 && calls[0] == c {
+		verb := aggregates[c.Name]
+		switch {
+		case c.Star:
+			return "number of rows"
+		case len(c.Args) == 1 && columnRef(c.Args[0]) != nil:
+			if c.Name == "count" && c.Distinct {
+				verb = "number of distinct"
+			}
+			return verb + " " + e.word(lastPart(columnRef(c.Args[0])))
+		}
+	}
+	var cols []string
+	sqlast.Inspect(it.Expr, func(n sqlast.Node) bool {
+		if id, ok := n.(*sqlast.Ident); ok {
+			cols = appendNew(cols, e.word(lastPart(id)))
+		}
+		return true
+	})
+	name := it.Alias
+	if name == "" {
+		name = "a figure"
+	}
+	if len(cols) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s (from %s)", name, joinAnd(cols))
+}
+
+// unwrapCoalesce strips COALESCE(x, 0) and casts around an aggregate.
+func unwrapCoalesce(x sqlast.Expr) sqlast.Expr {
+	switch c := x.(type) {
+	case *sqlast.Call:
+		if c.Name == "coalesce" && len(c.Args) > 0 {
+			return unwrapCoalesce(c.Args[0])
+		}
+	case *sqlast.Cast:
+		return unwrapCoalesce(c.X)
+	}
+	return x
+}
+
+// groupLabel names a GROUP BY key: "day", "shop", "month", a column label.
+// Positional keys (GROUP BY 1) are left out.
+func (e *explainer) groupLabel(g sqlast.Expr) string {
+	if c, ok := g.(*sqlast.Call); ok && c.Name == "date_trunc" && len(c.Args) == 2 {
+		if l, ok := c.Args[0].(*sqlast.Literal); ok {
+			return strings.ToLower(l.Value)
+		}
+	}
+	id := columnRef(g)
+	if id == nil {
+		return ""
+	}
+	switch lastPart(id) {
+	case "dt":
+		return "day"
+	case "shop_id":
+		return "shop"
+	}
+	return e.word(lastPart(id))
+}
+
+// order describes the outermost ORDER BY and LIMIT.
+func (e *explainer) order(q *sqlast.Query) string {
+	if len(q.OrderBy) == 0 {
+		if q.Limit != "" && q.Limit != "all" {
+			return "Returns at most " + q.Limit + " rows"
+		}
+		return ""
+	}
+	first := q.OrderBy[0]
+	by := ""
+	if id := columnRef(first.Expr); id != nil {
+		by = e.word(lastPart(id))
+		if it := aliased(q, lastPart(id)); it != nil {
+			by = it.Alias
+			if m := e.measure(it, false); m != "" && !strings.Contains(m, "(") {
+				by = m
+			}
+		}
+	} else if l, ok := first.Expr.(*sqlast.Literal); ok && l.Kind == sqlast.LitNumber {
+		by = "column " + l.Value
+	}
+	if by == "" {
+		by = "a calculated value"
+	}
+	if q.Limit != "" && q.Limit != "all" {
+		if first.Desc {
+			return fmt.Sprintf("Keeps the top %s by %s", q.Limit, by)
+		}
+		return fmt.Sprintf("Keeps the bottom %s by %s", q.Limit, by)
+	}
+	if first.Desc {
+		return "Sorted by " + by + ", highest first"
+	}
+	return "Sorted by " + by + ", lowest first"
+}
+
+// aliased is the outermost SELECT item named name, if any.
+func aliased(q *sqlast.Query, name string) *sqlast.SelectItem {
+	if s := outerSelect(q.Body); s != nil {
+		for _, it := range s.Items {
+			if it.Alias == name {
+				return it
+			}
+		}
+	}
+	return nil
+}
+
+// label is a column's glossary label, or its name.
+func (e *explainer) label(col string) string {
+	switch col {
+	case "dt":
+		return "Day"
+	case "shop_id":
+		return "Shop"
+	}
+	for _, s := range e.schemas {
+		if s.has(col) {
+			if d, ok := s.Describe(col); ok && d.Label != "" {
+				return d.Label
+			}
+		}
+	}
+	if d, ok := DescribeColumn(col); ok && d.Label != "" {
+		return d.Label
+	}
+	return col
+}
+
+// word is a column's label as it reads mid-sentence: lower case, but
+// acronyms ("ROAS") kept.
+func (e *explainer) word(col string) string {
+	l := e.label(col)
+	if l == strings.ToUpper(l) {
+		return l
+	}
+	return strings.ToLower(l)
+}
+
+// tableLabel is a table's spec kind in words ("daily metrics", "orders").
+func (e *explainer) tableLabel(name string) string {
+	for _, s := range e.schemas {
+		if strings.EqualFold(s.Table, name) && s.Kind != "" {
+			name = s.Kind
+			break
+		}
+	}
+	return strings.ReplaceAll(strings.TrimSuffix(name, "_fact"), "_", " ")
+}
+
+func appendNew(list []string, s string) []string {
+	for _, x := range list {
+		if x == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// joinAnd joins "a", "a and b", "a, b and c".
+func joinAnd(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
@@ -0,0 +1,93 @@
+package nlq
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The glossary describes the daily_metrics columns and the metrics built
+// from them. It is the one source for the /ask prompt (CompactSchemaText)
+// and for GET /analytics/schema, so the model and the UI tooltips say the
+// same thing. A column Glue has that isn't listed here is still served,
+// just without a description; add it here when the ETL grows one.
+
+// ColumnDoc describes one daily_metrics column.
+type ColumnDoc struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Unit        string `json:"unit,omitempty"` // "money", "date", "bool", ...
+}
+
+// MetricDoc defines a metric derived from the columns. Formula is Athena
+// SQL over one or more daily_metrics rows.
+type MetricDoc struct {
+	Name        string `json:"name"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Formula     string `json:"formula"`
+	Unit        string `json:"unit,omitempty"`
+}
+
+var columnDocs = map[string]ColumnDoc{
+	"merchant_id": {Label: "Merchant", Description: "Merchant the row belongs to; currently the same as shop_id."},
+	"metric_date": {Label: "Date", Description: "Local day (ETL_TIMEZONE) the row covers, as a 'YYYY-MM-DD' string.", Unit: "date"},
+	"gross_revenue": {Label: "Gross revenue", Unit: "money",
+		Description: "Sum of the day's positive transactions (orders and other income), before refunds."},
+	"net_revenue": {Label: "Net revenue", Unit: "money",
+		Description: "Gross revenue minus refunds and other negative transactions, excluding marketing costs."},
+	"product_costs": {Label: "Product costs", Unit: "money",
+		Description: "Cost of goods of the day's orders, from the shop's product and package costs."},
+	"marketing_costs": {Label: "Marketing costs", Unit: "money",
+		Description: "Ad spend imported from the ad connectors, as a positive amount."},
+	"fulfillment_costs": {Label: "Fulfillment costs", Unit: "money",
+		Description: "Shipping and fulfillment costs. Not collected yet; always 0."},
+	"processing_fees": {Label: "Processing fees", Unit: "money",
+		Description: "Payment processing fees. Not collected yet; always 0."},
+	"other_costs": {Label: "Other costs", Unit: "money",
+		Description: "Costs that fit no other column. Not collected yet; always 0."},
+	"is_promo": {Label: "Sale day", Unit: "bool",
+		Description: "True on sale days (a discount spike or a limited-time discount), as labelled by the promo detector."},
+	"dt": {Label: "Day (partition)", Unit: "date",
+		Description: "Partition date of metric_date; filter on it so Athena reads only the needed days."},
+	"shop_id": {Label: "Shop (partition)",
+		Description: "Shopify shop domain; every query is restricted to the caller's shops."},
+}
+
+var metricDocs = []MetricDoc{
+	{Name: "total_costs", Label: "Total costs", Unit: "money",
+		Description: "Everything spent to make the day's sales.",
+		Formula:     "product_costs + marketing_costs + fulfillment_costs + processing_fees + other_costs"},
+	{Name: "net_profit", Label: "Net profit", Unit: "money",
+		Description: "What is left of net revenue after all costs.",
+		Formula:     "net_revenue - (product_costs + marketing_costs + fulfillment_costs + processing_fees + other_costs)"},
+	{Name: "refunds", Label: "Refunds", Unit: "money",
+		Description: "Money given back to customers.",
+		Formula:     "gross_revenue - net_revenue"},
+	{Name: "profit_margin", Label: "Profit margin", Unit: "percent",
+		Description: "Net profit as a share of net revenue; empty when there was no revenue.",
+		Formula:     "100 * (net_revenue - (product_costs + marketing_costs + fulfillment_costs + processing_fees + other_costs)) / NULLIF(net_revenue, 0)"},
+	{Name: "roas", Label: "ROAS", Unit: "ratio",
+		Description: "Return on ad spend: net revenue per unit of marketing cost.",
+		Formula:     "net_revenue / NULLIF(marketing_costs, 0)"},
+}
+
+// DescribeColumn returns the glossary entry of a column, if any.
+func DescribeColumn(name string) (ColumnDoc, bool) {
+	d, ok := columnDocs[strings.ToLower(name)]
+	return d, ok
+}
+
+// Metrics returns the metric definitions in display order.
+func Metrics() []MetricDoc {
+	return append([]MetricDoc(nil), metricDocs...)
+}
+
+// metricsPromptText is the METRICS block of the /ask prompt.
+func metricsPromptText() string {
+	var b strings.Builder
+	b.WriteString("METRICS (use these definitions when the question names them):\n")
+	for _, m := range metricDocs {
+		b.WriteString(fmt.Sprintf("  %s = %s  -- %s\n", m.Name, m.Formula, m.Description))
+	}
+	return b.String()
+}
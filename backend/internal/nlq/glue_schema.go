@@ -22,6 +22,12 @@ type TableSchema struct {
 	Location   string
 	Columns    []Column
 	Partitions []Column
+
+	Kind        string   // TableSpec.Kind, set by LoadSchemasFromEnv
+	Description string   // one-line description for the prompt
+	Hidden      []string // Glue columns dropped by the spec's allowlist or the denylist
+
+	docs map[string]ColumnDoc
 }
 
 type Column struct {
@@ -87,10 +93,43 @@ func LoadTableSchema(ctx context.Context, c GlueClient, database, table string)
 // TABLE daily_metrics ( ... )
 // PARTITIONED BY (dt date, shop_id string)
 // LOCATION s3://...
+// METRICS (...)
+//
+// Columns carry their glossary description as a trailing comment.
 func CompactSchemaText(s *TableSchema) string {
+	return ComposeSchemaText([]*TableSchema{s})
+}
+
+// ComposeSchemaText is CompactSchemaText for several tables of the same
+// database: one TABLE block each, then the JOINS rules when there is more
+// than one. A single table renders exactly as CompactSchemaText did, so
+// cached answers stay valid until a fact table is configured.
+func ComposeSchemaText(schemas []*TableSchema) string {
 	var b strings.Builder
 
-	b.WriteString(fmt.Sprintf("DATABASE %s\n", s.Database))
+	if len(schemas) > 0 {
+		b.WriteString(fmt.Sprintf("DATABASE %s\n", schemas[0].Database))
+	}
+	for i, s := range schemas {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		writeTableText(&b, s, len(schemas) > 1)
+	}
+	if len(schemas) > 1 {
+		b.WriteString("\n")
+		b.WriteString(joinPromptText())
+	}
+
+	b.WriteString(metricsPromptText())
+
+	return b.String()
+}
+
+func writeTableText(b *strings.Builder, s *TableSchema, described bool) {
+	if described && s.Description != "" {
+		b.WriteString(fmt.Sprintf("-- %s\n", s.Description))
+	}
 	b.WriteString(fmt.Sprintf("TABLE %s (\n", s.Table))
 
 	for i, c := range s.Columns {
@@ -98,6 +137,10 @@ func CompactSchemaText(s *TableSchema) string {
 		if i == len(s.Columns)-1 {
 			comma = ""
 		}
+		if d, ok := s.Describe(c.Name); ok {
+			b.WriteString(fmt.Sprintf("  %s %s%s -- %s\n", c.Name, c.Type, comma, d.Description))
+			continue
+		}
 		b.WriteString(fmt.Sprintf("  %s %s%s\n", c.Name, c.Type, comma))
 	}
 	b.WriteString(")\n")
@@ -116,8 +159,6 @@ func CompactSchemaText(s *TableSchema) string {
 	if s.Location != "" {
 		b.WriteString(fmt.Sprintf("LOCATION %s\n", s.Location))
 	}
-
-	return b.String()
 }
 
 // Optional: Glue column types sometimes include complex types;
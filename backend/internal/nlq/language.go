@@ -0,0 +1,119 @@
+package nlq
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Questions can be asked in any language the model reads; the SQL stays
+// English and the user-facing text (answer, assumptions, clarifying
+// question) follows the question. The language is detected from the
+// question where that is reliable, which is mostly a matter of script, so
+// the prompts can name it; otherwise they tell the model to follow the
+// question's own language. An ask may also name one (the UI locale).
+
+// Languages are the codes an ask may name, with the names the prompts use.
+var Languages = map[string]string{
+	"en": "English",
+	"vi": "Vietnamese",
+	"th": "Thai",
+	"id": "Indonesian",
+	"zh": "Chinese",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"fr": "French",
+	"es": "Spanish",
+	"de": "German",
+}
+
+// vietnameseWords are common unaccented spellings in merchants' questions
+// ("doanh thu hom qua"); two of them mark a question typed without
+// diacritics as Vietnamese.
+var vietnameseWords = map[string]bool{
+	"doanh": true, "thu": true, "loi": true, "nhuan": true, "hom": true,
+	"qua": true, "nay": true, "thang": true, "tuan": true, "nam": true,
+	"bao": true, "nhieu": true, "cua": true, "hang": true, "don": true,
+	"chi": true, "phi": true, "quang": true, "cao": true, "ngay": true,
+}
+
+// vietnameseGlossary maps the merchant's words to the schema's metrics, for
+// questions in Vietnamese.
+const vietnameseGlossary = `doanh thu = revenue (doanh thu thuần = net_revenue), lợi nhuận = profit (lợi nhuận ròng = net_profit),
+chi phí = costs, chi phí quảng cáo = marketing_costs, giá vốn = product_costs, hoàn tiền = refunds,
+đơn hàng = orders, cửa hàng = shop, ngày khuyến mãi / ngày sale = is_promo,
+hôm nay = today, hôm qua = yesterday, tuần này / tuần trước = this / last week,
+tháng này / tháng trước = this / last month, năm nay = this year`
+
+// DetectLanguage returns the code of the language question is written in,
+// or "" when it can't tell (most Latin-script text, English included).
+func DetectLanguage(question string) string {
+	var han, kana, hangul, thai bool
+	for _, r := range question {
+		switch {
+		case isVietnameseLetter(r):
+			return "vi"
+		case unicode.Is(unicode.Thai, r):
+			thai = true
+		case unicode.Is(unicode.Hangul, r):
+			hangul = true
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			kana = true
+		case unicode.Is(unicode.Han, r):
+			han = true
+		}
+	}
+	switch {
+	case thai:
+		return "th"
+	case hangul:
+		return "ko"
+	case kana:
+		return "ja"
+	case han:
+		return "zh"
+	}
+
+	hits := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(question), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if vietnameseWords[w] {
+			hits[w] = true
+		}
+	}
+	if len(hits) >= 2 {
+		return "vi"
+	}
+	return ""
+}
+
+// isVietnameseLetter reports letters only Vietnamese uses: ă, đ, ơ, ư and
+// the tone-marked vowels of Latin Extended Additional.
+func isVietnameseLetter(r rune) bool {
+	switch r {
+	case 'ă', 'Ă', 'đ', 'Đ', 'ơ', 'Ơ', 'ư', 'Ư':
+		return true
+	}
+	return r >= 0x1EA0 && r <= 0x1EF9
+}
+
+// languageName is the prompt name of code, or "" for none or an unknown one.
+func languageName(code string) string {
+	return Languages[code]
+}
+
+// languagePrompt is the LANGUAGE section of the SQL prompt.
+func languagePrompt(code string) string {
+	name := languageName(code)
+	if name == "" {
+		return `The question may be in any language. Understand it as written, without translating it first.
+Write assumptions and any clarifying_question in the question's language.
+SQL, identifiers and column aliases are always English.`
+	}
+	s := fmt.Sprintf(`The question is in %s. Understand it as written, without translating it first.
+Write assumptions and any clarifying_question in %s.
+SQL, identifiers and column aliases are always English.`, name, name)
+	if code == "vi" {
+		s += "\nVietnamese terms:\n" + vietnameseGlossary
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package nlq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Model chain: SQL generation tries BEDROCK_MODEL_IDS in order (e.g. Haiku,
+// then Sonnet) and moves to the next model when a call fails, the reply
+// can't be parsed, or the model's confidence is below NLQ_MIN_CONFIDENCE.
+// Without BEDROCK_MODEL_IDS the chain is BEDROCK_MODEL_ID alone. Fix prompts
+// use the same chain; answer synthesis uses its first model.
+//
+// A request may pin one model for experiments (AskRequest.Model). Only the
+// chain's models and BEDROCK_EXPERIMENT_MODEL_IDS may be picked.
+
+const defaultMinConfidence = 0.6
+
+type modelsKey struct{}
+
+func splitModelIDs(v string) []string {
+	var ids []string
+	for _, id := range strings.Split(v, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ModelChain is the configured chain.
+func ModelChain() []string {
+	if ids := splitModelIDs(os.Getenv("BEDROCK_MODEL_IDS")); len(ids) > 0 {
+		return ids
+	}
+	return splitModelIDs(os.Getenv("BEDROCK_MODEL_ID"))
+}
+
+// SelectableModel reports whether a request may pin modelID.
+func SelectableModel(modelID string) bool {
+	for _, id := range append(ModelChain(), splitModelIDs(os.Getenv("BEDROCK_EXPERIMENT_MODEL_IDS"))...) {
+		if id == modelID {
+			return true
+		}
+	}
+	return false
+}
+
+// WithModel makes Bedrock calls made with ctx use modelID only.
+func WithModel(ctx context.Context, modelID string) context.Context {
+	return context.WithValue(ctx, modelsKey{}, []string{modelID})
+}
+
+// modelsFor is the chain for calls made with ctx.
+func modelsFor(ctx context.Context) ([]string, error) {
+	if ids, ok := ctx.Value(modelsKey{}).([]string); ok && len(ids) > 0 {
+		return ids, nil
+	}
+	ids := ModelChain()
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("missing env BEDROCK_MODEL_ID")
+	}
+	return ids, nil
+}
+
+func minConfidence() float64 {
+	if v := strings.TrimSpace(os.Getenv("NLQ_MIN_CONFIDENCE")); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return defaultMinConfidence
+}
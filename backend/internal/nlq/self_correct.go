@@ -2,9 +2,15 @@ package nlq
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"backend/internal/metering"
 )
 
 type FixSQLRequest struct {
@@ -32,7 +38,7 @@ func BuildFixPrompt(r FixSQLRequest) string {
 FIX the SQL query.
 
 CRITICAL RULES:
-- Output JSON only.
+- Answer by calling the submit_sql tool.
 - One SELECT only.
 - shop_id must remain inside allowlist [%s].
 - dt MUST have lower bound >= '%s'.
@@ -49,18 +55,181 @@ PREVIOUS SQL:
 
 ATHENA ERROR:
 %s
+`, shops, dtMin, r.SchemaText, r.OriginalQuestion, r.PreviousSQL, r.AthenaError)
+}
 
-Return JSON:
-{
-  "sql": "...",
-  "confidence": 0.0,
-  "assumptions": ["..."],
-  "needs_clarification": false,
-  "clarifying_question": null
+// preflightAndRun validates sql with EXPLAIN before running it, so a query
+// Athena would reject goes to the fix loop without costing a full run. A
+// preflight that fails for any other reason (API error, timeout) is logged
+// and the query runs anyway.
+func preflightAndRun(ctx context.Context, athena AthenaClient, sql string, opt AthenaRunOptions) (*AthenaResult, error) {
+	err := ExplainAthenaQuery(ctx, athena, sql, opt)
+	var athErr *AthenaError
+	if errors.As(err, &athErr) && athErr.State == "FAILED" {
+		return nil, err
+	}
+	if err != nil {
+		fmt.Printf("nlq: explain preflight skipped: %v\n", err)
+	}
+	return RunAthenaQuery(ctx, athena, sql, opt)
 }
-`, shops, dtMin, r.SchemaText, r.OriginalQuestion, r.PreviousSQL, r.AthenaError)
+
+// isAthenaTimeout reports a query that is still running rather than
+// wrong: there is nothing to fix, and the caller can await it later.
+func isAthenaTimeout(err error) bool {
+	var athErr *AthenaError
+	return errors.As(err, &athErr) && athErr.State == AthenaStateTimeout
+}
+
+// FixPolicy is how hard ExecuteWithSelfCorrection tries to fix a failing
+// query. Set from env (FixPolicyFromEnv):
+//
+//	NLQ_FIX_MAX_ATTEMPTS        rewrites asked of the model (default 2, at most 5; 0 turns fixing off)
+//	NLQ_FIX_ATTEMPT_TIMEOUT_MS  bound on one rewrite call to Bedrock (default 0: the caller's deadline)
+//	NLQ_FIX_BACKOFF_MS          wait before the first rewrite, doubled for each one after (default 0)
+//
+// The timeout covers the model call only; the Athena run of a rewrite is
+// bounded by AthenaRunOptions.MaxWait as every run is.
+type FixPolicy struct {
+	MaxAttempts    int
+	AttemptTimeout time.Duration
+	Backoff        time.Duration
+}
+
+const (
+	defaultFixAttempts = 2
+	maxFixAttempts     = 5
+)
+
+func FixPolicyFromEnv() FixPolicy {
+	p := FixPolicy{MaxAttempts: defaultFixAttempts}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("NLQ_FIX_MAX_ATTEMPTS"))); err == nil && n >= 0 {
+		p.MaxAttempts = min(n, maxFixAttempts)
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("NLQ_FIX_ATTEMPT_TIMEOUT_MS"))); err == nil && n > 0 {
+		p.AttemptTimeout = time.Duration(n) * time.Millisecond
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("NLQ_FIX_BACKOFF_MS"))); err == nil && n > 0 {
+		p.Backoff = time.Duration(n) * time.Millisecond
+	}
+	return p
+}
+
+// backoff is the wait before rewrite attempt (1-based).
+func (p FixPolicy) backoff(attempt int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+	return p.Backoff << min(attempt-1, 5)
+}
+
+// Failure classes of a self-correction candidate, in FixStats.Failures.
+const (
+	FixFailRejected      = "rejected"      // the validator refused the SQL
+	FixFailAthena        = "athena_failed" // Athena ran it and failed (or EXPLAIN did)
+	FixFailTimeout       = "timeout"       // Athena outlived MaxWait
+	FixFailAthenaAPI     = "athena_api"    // Athena API error
+	FixFailScope         = "scope"         // the SQL could not be scoped
+	FixFailBedrock       = "bedrock"       // the rewrite call failed or timed out
+	FixFailClarification = "clarification" // the model asked a question instead
+)
+
+// FixStats is what one ExecuteWithSelfCorrection did: how many rewrites it
+// asked for and why each candidate, the first included, failed, in order.
+// A query that worked first time has neither.
+type FixStats struct {
+	Attempts int      `json:"attempts"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+func (s *FixStats) fail(class string) {
+	s.Failures = append(s.Failures, class)
+}
+
+// failureClass sorts a runCandidate error into a FixFail class.
+func failureClass(err error) string {
+	var athErr *AthenaError
+	switch {
+	case errors.Is(err, errScope):
+		return FixFailScope
+	case errors.As(err, &athErr) && athErr.State == "FAILED":
+		return FixFailAthena
+	case errors.As(err, &athErr) && athErr.State == AthenaStateTimeout:
+		return FixFailTimeout
+	case errors.As(err, new(*RejectedError)):
+		return FixFailRejected
+	}
+	return FixFailAthenaAPI
 }
 
+// logFixStats writes one JSON line per self-correction that did anything,
+// for CloudWatch Logs Insights (filter @message like "nlq: self-correct").
+func logFixStats(s FixStats, policy FixPolicy, outcome string) {
+	if s.Attempts == 0 && len(s.Failures) == 0 {
+		return
+	}
+	b, _ := json.Marshal(map[string]any{
+		"attempts":    s.Attempts,
+		"maxAttempts": policy.MaxAttempts,
+		"failures":    s.Failures,
+		"outcome":     outcome,
+	})
+	fmt.Printf("nlq: self-correct %s\n", b)
+}
+
+// RejectedError is a candidate the validator refused. Label is "initial"
+// for the model's first SQL and "fixed" for a rewrite.
+type RejectedError struct {
+	Label string
+	Err   error
+}
+
+func (e *RejectedError) Error() string { return e.Label + " sql rejected: " + e.Err.Error() }
+func (e *RejectedError) Unwrap() error { return e.Err }
+
+// errScope marks a candidate that could not be scoped, or whose scoped
+// form does not validate.
+var errScope = errors.New("scope sql")
+
+// runCandidate validates the model's sql, scopes it and runs the scoped
+// SQL. fixable reports an error a rewrite of the SQL may cure: a validator
+// rejection (*RejectedError) or a query Athena failed. A timeout, an API
+// error or a scoping failure is not.
+func runCandidate(ctx context.Context, athena AthenaClient, sql, label string, opt ValidateOptions, athenaOpt AthenaRunOptions) (res *AthenaResult, fixable bool, err error) {
+	if err := ValidateSQL(sql, opt); err != nil {
+		return nil, true, &RejectedError{Label: label, Err: err}
+	}
+	scoped, err := ScopeSQL(sql, opt)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", errScope, err)
+	}
+	// The scoped SQL is what runs, so it must pass the shop and dt rules on
+	// its own. The column rules are left out: its wrappers SELECT *.
+	check := opt
+	check.Tables = nil
+	if err := ValidateSQL(scoped, check); err != nil {
+		return nil, false, fmt.Errorf("%w: result does not validate: %w", errScope, err)
+	}
+
+	res, err = preflightAndRun(ctx, athena, scoped, athenaOpt)
+	var athErr *AthenaError
+	if errors.As(err, &athErr) && athErr.State == "FAILED" {
+		return nil, true, err
+	}
+	return res, false, err
+}
+
+// ExecuteWithSelfCorrection runs initialLLM's SQL and, while the error is
+// one the model may fix, asks it for up to policy.MaxAttempts rewrites.
+// Each candidate, the first included, goes through runCandidate, so what
+// Athena runs is always the validated SQL's scoped form. The returned
+// LLMResult is the last candidate (for the caller's record), also on
+// error; it is a clarification, with no result, when the model asked for
+// one instead of a fix. FixStats says what it took either way.
+//
+// The model's first SQL is not rewritten when the validator rejects it:
+// that is SQL reaching outside the caller's shops or tables, and the
+// *RejectedError (Label "initial") goes back for the caller to record.
 func ExecuteWithSelfCorrection(
 	ctx context.Context,
 	bedrock BedrockClient,
@@ -74,25 +243,35 @@ func ExecuteWithSelfCorrection(
 	todayISO string,
 	timezone string,
 	initialLLM *LLMResult,
-	maxFixAttempts int,
-) (*LLMResult, *AthenaResult, error) {
+	policy FixPolicy,
+) (*LLMResult, *AthenaResult, FixStats, error) {
 
-	if maxFixAttempts < 0 {
-		maxFixAttempts = 0
-	}
+	var stats FixStats
+	outcome := "error"
+	defer func() { logFixStats(stats, policy, outcome) }()
 
-	// Attempt 0: initial SQL
 	cur := *initialLLM
-	if err := ValidateSQL(cur.SQL, sqlValidate); err != nil {
-		return nil, nil, fmt.Errorf("initial sql rejected: %w", err)
-	}
-	res, err := RunAthenaQuery(ctx, athena, cur.SQL, athenaOpt)
+	res, fixable, err := runCandidate(ctx, athena, cur.SQL, "initial", sqlValidate, athenaOpt)
 	if err == nil {
-		return &cur, res, nil
+		outcome = "result"
+		return &cur, res, stats, nil
+	}
+	stats.fail(failureClass(err))
+	if !fixable || errors.As(err, new(*RejectedError)) {
+		return &cur, nil, stats, err
 	}
 
 	lastErr := err
-	for attempt := 1; attempt <= maxFixAttempts; attempt++ {
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if d := policy.backoff(attempt); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return &cur, nil, stats, fmt.Errorf("fix attempt %d: %w (last error: %w)", attempt, ctx.Err(), lastErr)
+			}
+		}
+		stats.Attempts = attempt
+
 		fixPrompt := BuildFixPrompt(FixSQLRequest{
 			OriginalQuestion: question,
 			SchemaText:       schemaText,
@@ -104,41 +283,42 @@ func ExecuteWithSelfCorrection(
 			AthenaError:      lastErr.Error(),
 		})
 
-		fixed, ferr := InvokeBedrockClaude(ctx, bedrock, fixPrompt)
+		fixed, ferr := invokeFix(ctx, bedrock, fixPrompt, policy.AttemptTimeout)
 		if ferr != nil {
-			return nil, nil, fmt.Errorf("bedrock fix attempt %d failed: %w", attempt, ferr)
+			stats.fail(FixFailBedrock)
+			return &cur, nil, stats, fmt.Errorf("bedrock fix attempt %d failed: %w", attempt, ferr)
 		}
 		if fixed.NeedsClarification {
 			// bubble up clarification
-			return fixed, nil, nil
-		}
-
-		if err := ValidateSQL(fixed.SQL, sqlValidate); err != nil {
-			lastErr = fmt.Errorf("fixed sql rejected: %w", err)
-			cur = *fixed
-			continue
+			stats.fail(FixFailClarification)
+			outcome = "clarification"
+			return fixed, nil, stats, nil
 		}
 
-		// If model forgot dt lower bound, auto-inject dt >= dtMin
-		today, _ := time.Parse("2006-01-02", todayISO)
-		dtMin := today.AddDate(0, 0, -maxDays).Format("2006-01-02")
-
-		if !strings.Contains(strings.ToLower(cur.SQL), "dt >=") &&
-			!strings.Contains(strings.ToLower(cur.SQL), "dt between") {
-			cur.SQL = fmt.Sprintf(
-				"SELECT * FROM (%s) WHERE dt >= date '%s'",
-				cur.SQL,
-				dtMin,
-			)
+		cur = *fixed
+		res, fixable, err = runCandidate(ctx, athena, cur.SQL, "fixed", sqlValidate, athenaOpt)
+		if err == nil {
+			outcome = "result"
+			return &cur, res, stats, nil
 		}
-
-		r2, err2 := RunAthenaQuery(ctx, athena, fixed.SQL, athenaOpt)
-		if err2 == nil {
-			return fixed, r2, nil
+		stats.fail(failureClass(err))
+		if !fixable {
+			return &cur, nil, stats, err
 		}
-		lastErr = err2
-		cur = *fixed
+		lastErr = err
 	}
 
-	return &cur, nil, fmt.Errorf("athena failed after retries: %w", lastErr)
+	outcome = "exhausted"
+	return &cur, nil, stats, fmt.Errorf("athena failed after retries: %w", lastErr)
+}
+
+// invokeFix asks the model for one rewrite, within timeout when one is set.
+func invokeFix(ctx context.Context, bedrock BedrockClient, prompt string, timeout time.Duration) (*LLMResult, error) {
+	ctx = metering.WithFeature(ctx, metering.FeatureFix)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return InvokeBedrock(ctx, bedrock, prompt)
 }
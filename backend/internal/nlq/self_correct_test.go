@@ -0,0 +1,129 @@
+package nlq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/athena"
+	athenatypes "github.com/aws/aws-sdk-go-v2/service/athena/types"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	brtypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// fakeAthena fails every query fail reports and succeeds the rest, with
+// no rows. queries is every QueryString started, in order.
+type fakeAthena struct {
+	fail    func(sql string) bool
+	queries []string
+}
+
+func (f *fakeAthena) StartQueryExecution(_ context.Context, in *athena.StartQueryExecutionInput, _ ...func(*athena.Options)) (*athena.StartQueryExecutionOutput, error) {
+	f.queries = append(f.queries, aws.ToString(in.QueryString))
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(strconv.Itoa(len(f.queries) - 1))}, nil
+}
+
+func (f *fakeAthena) GetQueryExecution(_ context.Context, in *athena.GetQueryExecutionInput, _ ...func(*athena.Options)) (*athena.GetQueryExecutionOutput, error) {
+	i, _ := strconv.Atoi(aws.ToString(in.QueryExecutionId))
+	state := athenatypes.QueryExecutionStateSucceeded
+	if f.fail != nil && f.fail(f.queries[i]) {
+		state = athenatypes.QueryExecutionStateFailed
+	}
+	return &athena.GetQueryExecutionOutput{QueryExecution: &athenatypes.QueryExecution{
+		Status: &athenatypes.QueryExecutionStatus{State: state, StateChangeReason: aws.String("COLUMN_NOT_FOUND")},
+	}}, nil
+}
+
+func (f *fakeAthena) GetQueryResults(context.Context, *athena.GetQueryResultsInput, ...func(*athena.Options)) (*athena.GetQueryResultsOutput, error) {
+	return &athena.GetQueryResultsOutput{ResultSet: &athenatypes.ResultSet{ResultSetMetadata: &athenatypes.ResultSetMetadata{}}}, nil
+}
+
+// fakeBedrock answers each Converse with the next of fixes as submit_sql.
+type fakeBedrock struct {
+	fixes []string
+	calls int
+}
+
+func (f *fakeBedrock) Converse(context.Context, *bedrockruntime.ConverseInput, ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error) {
+	if f.calls >= len(f.fixes) {
+		return nil, errors.New("no more fixes")
+	}
+	sql := f.fixes[f.calls]
+	f.calls++
+	return &bedrockruntime.ConverseOutput{Output: &brtypes.ConverseOutputMemberMessage{Value: brtypes.Message{
+		Content: []brtypes.ContentBlock{&brtypes.ContentBlockMemberToolUse{Value: brtypes.ToolUseBlock{
+			Name:  aws.String("submit_sql"),
+			Input: document.NewLazyDocument(map[string]any{"sql": sql, "confidence": 0.9}),
+		}}},
+	}}}, nil
+}
+
+func TestExecuteWithSelfCorrection(t *testing.T) {
+	t.Setenv("BEDROCK_MODEL_ID", "test-model")
+
+	const (
+		good   = "SELECT SUM(revenue) FROM daily_metrics WHERE shop_id = 's1' AND dt >= '2026-01-01'"
+		broken = "SELECT SUM(revenu) FROM daily_metrics WHERE shop_id = 's1' AND dt >= '2026-01-01'"
+		other  = "SELECT SUM(revenue) FROM daily_metrics WHERE shop_id = 's2' AND dt >= '2026-01-01'"
+	)
+	opt := ValidateOptions{AllowedShopIDs: []string{"s1"}, RequireDTFilter: true, MaxDaysLookback: 90, TodayISO: "2026-01-31"}
+	athOpt := AthenaRunOptions{Database: "db", Workgroup: "wg", OutputLocation: "s3://out/", PollInterval: time.Millisecond}
+	failBroken := func(sql string) bool { return strings.Contains(sql, "revenu)") }
+
+	cases := []struct {
+		name      string
+		initial   string
+		fixes     []string
+		wantErr   string // RejectedError label, "athena" or "" for a result
+		wantFixes int
+		wantFails []string
+	}{
+		{"initial rejected", other, nil, "initial", 0, []string{FixFailRejected}},
+		{"initial runs scoped", good, nil, "", 0, nil},
+		{"preflight failure fixed", broken, []string{good}, "", 1, []string{FixFailAthena}},
+		{"rewrite rejected", broken, []string{other, other}, "fixed", 2, []string{FixFailAthena, FixFailRejected, FixFailRejected}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ath := &fakeAthena{fail: failBroken}
+			br := &fakeBedrock{fixes: c.fixes}
+			_, res, stats, err := ExecuteWithSelfCorrection(context.Background(), br, ath, opt, athOpt,
+				"revenue?", "", opt.AllowedShopIDs, 90, opt.TodayISO, "", &LLMResult{SQL: c.initial}, FixPolicy{MaxAttempts: 2})
+
+			var rejected *RejectedError
+			switch {
+			case c.wantErr == "" && (err != nil || res == nil):
+				t.Fatalf("err = %v, want a result", err)
+			case c.wantErr != "" && (!errors.As(err, &rejected) || rejected.Label != c.wantErr):
+				t.Fatalf("err = %v, want %s sql rejected", err, c.wantErr)
+			}
+			if stats.Attempts != c.wantFixes || strings.Join(stats.Failures, ",") != strings.Join(c.wantFails, ",") {
+				b, _ := json.Marshal(stats)
+				t.Fatalf("stats = %s, want %d attempts, failures %v", b, c.wantFixes, c.wantFails)
+			}
+
+			// Athena only ever sees validated SQL in its scoped form.
+			for _, q := range ath.queries {
+				q = strings.TrimPrefix(q, "EXPLAIN (TYPE VALIDATE) ")
+				if q == good || q == broken {
+					t.Fatalf("ran unscoped SQL %q", q)
+				}
+				if err := ValidateSQL(q, opt); err != nil {
+					t.Fatalf("ran SQL that does not validate: %v\n%s", err, q)
+				}
+			}
+			if c.wantErr == "initial" && (len(ath.queries) > 0 || br.calls > 0) {
+				t.Fatalf("rejected SQL reached athena (%d) or was rewritten (%d)", len(ath.queries), br.calls)
+			}
+			if c.name == "preflight failure fixed" && len(ath.queries) != 3 {
+				t.Fatalf("queries = %d, want EXPLAIN, EXPLAIN, run: %q", len(ath.queries), ath.queries)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package nlq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// PinnedContext is what the frontend pins for a chat session so users don't
+// have to repeat "for store X in January" in every question.
+type PinnedContext struct {
+	ShopIDs  []string `json:"shop_ids,omitempty"`
+	From     string   `json:"from,omitempty"` // YYYY-MM-DD, inclusive
+	To       string   `json:"to,omitempty"`   // YYYY-MM-DD, inclusive
+	Currency string   `json:"currency,omitempty"`
+}
+
+// sessionContextTTL keeps pinned context around for a working day after the last ask.
+const sessionContextTTL = 24 * time.Hour
+
+var (
+	sessionIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	currencyRe  = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+func ValidSessionID(id string) bool {
+	return sessionIDRe.MatchString(id)
+}
+
+func (p *PinnedContext) IsEmpty() bool {
+	return p == nil || (len(p.ShopIDs) == 0 && p.From == "" && p.To == "" && p.Currency == "")
+}
+
+// Normalize validates the pinned values and clamps the date range to the
+// lookback the validator will accept (today-maxDays .. today).
+func (p *PinnedContext) Normalize(todayISO string, maxDays int) error {
+	p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+	if p.Currency != "" && !currencyRe.MatchString(p.Currency) {
+		return fmt.Errorf("invalid currency %q", p.Currency)
+	}
+
+	shops := make([]string, 0, len(p.ShopIDs))
+	for _, s := range p.ShopIDs {
+		if s = strings.TrimSpace(s); s != "" {
+			shops = append(shops, s)
+		}
+	}
+	p.ShopIDs = shops
+
+	today, err := time.Parse("2006-01-02", todayISO)
+	if err != nil {
+		return fmt.Errorf("invalid today %q", todayISO)
+	}
+	dtMin := today.AddDate(0, 0, -maxDays)
+
+	var from, to time.Time
+	if p.From != "" {
+		if from, err = time.Parse("2006-01-02", p.From); err != nil {
+			return fmt.Errorf("invalid from %q", p.From)
+		}
+		if from.Before(dtMin) {
+			from = dtMin
+		}
+		p.From = from.Format("2006-01-02")
+	}
+	if p.To != "" {
+		if to, err = time.Parse("2006-01-02", p.To); err != nil {
+			return fmt.Errorf("invalid to %q", p.To)
+		}
+		if to.After(today) {
+			to = today
+		}
+		p.To = to.Format("2006-01-02")
+	}
+	if p.From != "" && p.To != "" && to.Before(from) {
+		return fmt.Errorf("from must be on or before to")
+	}
+	return nil
+}
+
+// PromptText renders the pinned context as prompt lines; empty when nothing is pinned.
+func (p *PinnedContext) PromptText() string {
+	if p.IsEmpty() {
+		return ""
+	}
+	var lines []string
+	if len(p.ShopIDs) > 0 {
+		lines = append(lines, fmt.Sprintf("- Shops: [%s] (the user selected these; do not ask which store)", strings.Join(p.ShopIDs, ", ")))
+	}
+	switch {
+	case p.From != "" && p.To != "":
+		lines = append(lines, fmt.Sprintf("- Date range: dt between date '%s' and date '%s' unless the question names another period", p.From, p.To))
+	case p.From != "":
+		lines = append(lines, fmt.Sprintf("- Date range: dt >= date '%s' unless the question names another period", p.From))
+	case p.To != "":
+		lines = append(lines, fmt.Sprintf("- Date range: dt <= date '%s' unless the question names another period", p.To))
+	}
+	if p.Currency != "" {
+		lines = append(lines, fmt.Sprintf("- Currency: the user reports in %s; mention it in assumptions when amounts are in another currency", p.Currency))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CacheMaterial is the stable string folded into the NLQ cache key.
+func (p *PinnedContext) CacheMaterial() string {
+	if p.IsEmpty() {
+		return ""
+	}
+	return strings.Join([]string{ShopsKey(p.ShopIDs), p.From, p.To, p.Currency}, "/")
+}
+
+func makeSessionSK(sessionID string) string {
+	return "SESSION#" + sessionID + "#CONTEXT"
+}
+
+// GetPinnedContext loads the pinned context for a session (nil when none).
+// Stored in NLQ_CACHE_TABLE: PK = USER#<sub>, SK = SESSION#<id>#CONTEXT.
+func GetPinnedContext(ctx context.Context, ddb CacheClient, userSub, sessionID string) (*PinnedContext, error) {
+	table, err := cacheTable()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]ddbtypes.AttributeValue{
+			"PK": &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+			"SK": &ddbtypes.AttributeValueMemberS{Value: makeSessionSK(sessionID)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("session context GetItem: %w", err)
+	}
+	payloadAttr, ok := out.Item["Payload"].(*ddbtypes.AttributeValueMemberS)
+	if !ok {
+		return nil, nil
+	}
+	var p PinnedContext
+	if err := json.Unmarshal([]byte(payloadAttr.Value), &p); err != nil {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// PutPinnedContext replaces the session's pinned context and refreshes its TTL.
+func PutPinnedContext(ctx context.Context, ddb CacheClient, userSub, sessionID string, p PinnedContext) error {
+	table, err := cacheTable()
+	if err != nil {
+		return err
+	}
+
+	b, _ := json.Marshal(p)
+	now := time.Now().UTC()
+
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]ddbtypes.AttributeValue{
+			"PK":        &ddbtypes.AttributeValueMemberS{Value: MakeCachePK(userSub)},
+			"SK":        &ddbtypes.AttributeValueMemberS{Value: makeSessionSK(sessionID)},
+			"ExpiresAt": &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(sessionContextTTL).Unix())},
+			"Payload":   &ddbtypes.AttributeValueMemberS{Value: string(b)},
+			"CreatedAt": &ddbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Unix())},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("session context PutItem: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package nlq
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"backend/internal/sqlast"
+)
+
+// ScopeSQL makes tenancy structural rather than validated: every table sql
+// reads, at any depth, is swapped for a subquery filtered to the allowed
+// shops and (with RequireDTFilter) the dt lookback, so whatever the model's
+// own predicates say, the query cannot see another tenant's rows or older
+// partitions. ValidateSQL still requires the model to write the filters;
+// the scoped SQL is what Athena runs, the model's SQL is what users see.
+//
+//	FROM daily_metrics m
+//	FROM (SELECT * FROM daily_metrics WHERE shop_id IN ('a', 'b') AND dt >= date '2024-01-01') m
+func ScopeSQL(sql string, opt ValidateOptions) (string, error) {
+	if len(opt.AllowedShopIDs) == 0 {
+		return "", fmt.Errorf("no allowed shops to scope the query to")
+	}
+	s := strings.TrimSpace(sql)
+	q, err := sqlast.Parse(s)
+	if errors.Is(err, sqlast.ErrNotQuery) {
+		return "", fmt.Errorf("only SELECT queries are allowed")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	shops := make([]string, len(opt.AllowedShopIDs))
+	for i, id := range opt.AllowedShopIDs {
+		shops[i] = "'" + strings.ReplaceAll(strings.TrimSpace(id), "'", "''") + "'"
+	}
+	filter := "shop_id IN (" + strings.Join(shops, ", ") + ")"
+	if opt.RequireDTFilter {
+		minDT, _, err := dtLowerLimit(opt)
+		if err != nil {
+			return "", err
+		}
+		filter += fmt.Sprintf(" AND dt >= date '%s'", minDT.Format("2006-01-02"))
+	}
+
+	tables := sqlast.BaseTables(q)
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Pos < tables[j].Pos })
+	var b strings.Builder
+	last := 0
+	for _, t := range tables {
+		b.WriteString(s[last:t.Pos])
+		fmt.Fprintf(&b, "(SELECT * FROM %s WHERE %s)", s[t.Pos:t.End], filter)
+		if t.Alias == "" {
+			// keep daily_metrics.dt and the like resolving
+			b.WriteString(` "` + strings.ReplaceAll(t.Name[len(t.Name)-1], `"`, `""`) + `"`)
+		}
+		last = t.End
+	}
+	b.WriteString(s[last:])
+	return b.String(), nil
+}
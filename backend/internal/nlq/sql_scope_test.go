@@ -0,0 +1,90 @@
+package nlq
+
+import "testing"
+
+func TestScopeSQL(t *testing.T) {
+	opt := ValidateOptions{
+		AllowedShopIDs:  []string{"s1", "o'brien"},
+		RequireDTFilter: true,
+		MaxDaysLookback: 90,
+		TodayISO:        "2026-01-31",
+	}
+	const (
+		f   = "WHERE " + "shop_id = 's1' AND dt >= '2026-01-01'"
+		flt = "shop_id IN ('s1', 'o''brien') AND dt >= date '2025-11-02'"
+		dm  = "(SELECT * FROM daily_metrics WHERE " + flt + ")"
+		of  = "(SELECT * FROM orders_fact WHERE " + flt + ")"
+	)
+	cases := []struct {
+		name, sql, want string
+	}{
+		{
+			"unaliased table keeps its name",
+			"SELECT daily_metrics.revenue FROM daily_metrics " + f,
+			"SELECT daily_metrics.revenue FROM " + dm + ` "daily_metrics" ` + f,
+		},
+		{
+			"aliased table",
+			"SELECT m.revenue FROM daily_metrics m " + f,
+			"SELECT m.revenue FROM " + dm + " m " + f,
+		},
+		{
+			"AS alias and qualified name",
+			"SELECT m.revenue FROM analytics.daily_metrics AS m " + f,
+			"SELECT m.revenue FROM (SELECT * FROM analytics.daily_metrics WHERE " + flt + ") AS m " + f,
+		},
+		{
+			"join",
+			"SELECT o.order_id FROM orders_fact o JOIN daily_metrics m ON o.shop_id = m.shop_id AND o.dt = m.dt WHERE o.shop_id = 's1' AND o.dt >= '2026-01-01'",
+			"SELECT o.order_id FROM " + of + " o JOIN " + dm + " m ON o.shop_id = m.shop_id AND o.dt = m.dt WHERE o.shop_id = 's1' AND o.dt >= '2026-01-01'",
+		},
+		{
+			"cte name is not wrapped, its table is",
+			"WITH x AS (SELECT * FROM daily_metrics " + f + ") SELECT SUM(revenue) FROM x",
+			"WITH x AS (SELECT * FROM " + dm + ` "daily_metrics" ` + f + ") SELECT SUM(revenue) FROM x",
+		},
+		{
+			"cte named like the table",
+			"WITH daily_metrics AS (SELECT * FROM daily_metrics " + f + ") SELECT 1 FROM daily_metrics",
+			"WITH daily_metrics AS (SELECT * FROM " + dm + ` "daily_metrics" ` + f + ") SELECT 1 FROM daily_metrics",
+		},
+		{
+			"union",
+			"SELECT revenue FROM daily_metrics " + f + " UNION ALL SELECT revenue FROM orders_fact " + f,
+			"SELECT revenue FROM " + dm + ` "daily_metrics" ` + f + " UNION ALL SELECT revenue FROM " + of + ` "orders_fact" ` + f,
+		},
+		{
+			"correlated subquery",
+			"SELECT m.dt FROM daily_metrics m WHERE m.shop_id = 's1' AND m.dt >= '2026-01-01' AND m.revenue > (SELECT AVG(i.revenue) FROM daily_metrics i WHERE i.shop_id = m.shop_id AND i.dt >= '2026-01-01')",
+			"SELECT m.dt FROM " + dm + " m WHERE m.shop_id = 's1' AND m.dt >= '2026-01-01' AND m.revenue > (SELECT AVG(i.revenue) FROM " + dm + " i WHERE i.shop_id = m.shop_id AND i.dt >= '2026-01-01')",
+		},
+	}
+	check := opt
+	check.Tables = nil
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ScopeSQL(c.sql, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Fatalf("got\n%s\nwant\n%s", got, c.want)
+			}
+		})
+	}
+
+	// What runs must pass the shop and dt rules on its own, as
+	// runCandidate checks it.
+	for _, c := range cases {
+		got, _ := ScopeSQL(c.sql, opt)
+		if err := ValidateSQL(got, check); err != nil {
+			t.Errorf("%s: scoped SQL does not validate: %v\n%s", c.name, err, got)
+		}
+	}
+}
+
+func TestScopeSQLNeedsShops(t *testing.T) {
+	if _, err := ScopeSQL("SELECT 1 FROM daily_metrics", ValidateOptions{}); err == nil {
+		t.Fatal("scoped to no shops")
+	}
+}
@@ -1,10 +1,22 @@
 package nlq
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
 	"strings"
 	"time"
+
+	"backend/internal/sqlast"
+)
+
+// ErrShopNotAllowed marks SQL that references a shop_id outside the caller's allowlist.
+var ErrShopNotAllowed = errors.New("shop_id value not allowed")
+
+// Table and join rejections, checked when ValidateOptions.Tables is set.
+var (
+	ErrTableNotAllowed  = errors.New("table not allowed")
+	ErrColumnNotAllowed = errors.New("column not allowed")
+	ErrJoinNotAllowed   = errors.New("join not allowed")
 )
 
 type ValidateOptions struct {
@@ -12,175 +24,774 @@ type ValidateOptions struct {
 	RequireDTFilter bool
 	MaxDaysLookback int
 	TodayISO        string // "YYYY-MM-DD" (server-side). If empty, uses UTC today.
+	// Tables, when set, are the only tables FROM/JOIN may name (WITH names
+	// aside); their Hidden columns are rejected and joins must be on
+	// shop_id and dt.
+	Tables []*TableSchema
 }
 
-// ValidateSQL enforces:
-// - SELECT only
-// - no semicolon, no comments
-// - no dangerous keywords
-// - must include dt predicate (partition pruning) AND bounded lookback
-// - must include shop_id filter restricted to allowed shops
+// ValidateSQL parses sql and walks the query, enforcing:
+//   - a single SELECT query: no semicolon, no comments, no other statement
+//   - in every SELECT that reads a table, at any depth (WITH, subqueries,
+//     UNION branches), a shop_id filter on that table restricted to allowed
+//     shops and, with RequireDTFilter, a dt lower bound within the lookback.
+//     An OR only counts when every branch filters; a filter reaches another
+//     table through an a.shop_id = b.shop_id (a.dt = b.dt) join condition.
+//   - no shop_id literal outside the allowlist anywhere
+//   - with Tables: known tables and allowed columns only, joins on shop_id and dt
 func ValidateSQL(sql string, opt ValidateOptions) error {
 	s := strings.TrimSpace(sql)
 	if s == "" {
 		return fmt.Errorf("empty sql")
 	}
-	low := strings.ToLower(s)
+	q, err := sqlast.Parse(s)
+	if errors.Is(err, sqlast.ErrNotQuery) {
+		return fmt.Errorf("only SELECT queries are allowed")
+	}
+	if err != nil {
+		return err
+	}
 
-	if strings.Contains(low, ";") {
-		return fmt.Errorf("semicolon not allowed")
+	v := &sqlValidator{}
+	if opt.RequireDTFilter {
+		if v.minDT, v.maxDays, err = dtLowerLimit(opt); err != nil {
+			return err
+		}
 	}
-	if strings.Contains(low, "--") || strings.Contains(low, "/*") || strings.Contains(low, "*/") {
-		return fmt.Errorf("comments not allowed")
+	if len(opt.AllowedShopIDs) > 0 {
+		v.shops = map[string]bool{}
+		for _, id := range opt.AllowedShopIDs {
+			v.shops[strings.ToLower(strings.TrimSpace(id))] = true
+		}
 	}
-	if !(strings.HasPrefix(strings.TrimSpace(low), "select") || strings.HasPrefix(strings.TrimSpace(low), "with")) {
-		return fmt.Errorf("only SELECT queries are allowed")
+	if len(opt.Tables) > 0 {
+		v.tables = map[string]*TableSchema{}
+		for _, t := range opt.Tables {
+			v.tables[strings.ToLower(t.Table)] = t
+		}
+	}
+
+	if err := v.checkLiterals(q); err != nil {
+		return err
+	}
+	return v.query(q, nil)
+}
+
+// dtLowerLimit returns the oldest dt opt lets a query read and the lookback
+// in days it was computed from.
+func dtLowerLimit(opt ValidateOptions) (time.Time, int, error) {
+	maxDays := opt.MaxDaysLookback
+	if maxDays <= 0 {
+		maxDays = 90
+	}
+	today := opt.TodayISO
+	if strings.TrimSpace(today) == "" {
+		today = time.Now().UTC().Format("2006-01-02")
+	}
+	t, err := time.Parse("2006-01-02", today)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid TodayISO: %s", today)
+	}
+	return t.AddDate(0, 0, -maxDays), maxDays, nil
+}
+
+// RejectionKind classifies a ValidateSQL error for security telemetry.
+func RejectionKind(err error) string {
+	if err == nil {
+		return ""
+	}
+	switch {
+	case errors.Is(err, ErrShopNotAllowed):
+		return "shop_allowlist"
+	case errors.Is(err, ErrTableNotAllowed):
+		return "table_allowlist"
+	case errors.Is(err, ErrColumnNotAllowed):
+		return "column_allowlist"
+	case errors.Is(err, ErrJoinNotAllowed):
+		return "join"
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "shop_id"):
+		return "shop_filter"
+	case strings.Contains(msg, "dt "), strings.Contains(msg, "dt filter"):
+		return "dt_bound"
+	case strings.Contains(msg, "keyword"), strings.Contains(msg, "only SELECT"):
+		return "statement"
+	default:
+		return "syntax"
 	}
+}
+
+// sqlValidator walks a parsed query for ValidateSQL.
+type sqlValidator struct {
+	shops   map[string]bool         // nil: any shop_id literal
+	tables  map[string]*TableSchema // nil: any table
+	minDT   time.Time               // zero: no dt filter required
+	maxDays int
+	scopes  []*selectScope // enclosing SELECTs, innermost last, for hidden columns
+}
+
+// relation is one table, WITH name, subquery or UNNEST in a SELECT's FROM.
+type relation struct {
+	name   string       // alias, or the table name
+	base   bool         // a table, which must be filtered in this SELECT
+	schema *TableSchema // known columns of a base table; nil when unknown
+}
+
+// condition is a WHERE or JOIN ... ON condition of a SELECT. An outer join
+// condition only filters the relations that don't keep unmatched rows;
+// targets holds those (nil: all of them).
+type condition struct {
+	expr        sqlast.Expr
+	using       []string
+	left, right []int
+	targets     map[int]bool
+}
 
-	block := []string{
-		"insert ", "update ", "delete ", "merge ", "drop ", "alter ", "create ",
-		"truncate ", "grant ", "revoke ", "call ", "execute ", "prepare ", "deallocate ",
+func (c condition) filters(i int) bool { return c.targets == nil || c.targets[i] }
+
+type selectScope struct {
+	rels  []relation
+	conds []condition
+}
+
+func (v *sqlValidator) query(q *sqlast.Query, ctes map[string]bool) error {
+	inner := map[string]bool{}
+	for name := range ctes {
+		inner[name] = true
+	}
+	for _, c := range q.With {
+		if err := v.query(c.Query, inner); err != nil {
+			return err
+		}
+		inner[c.Name] = true
+	}
+	// ORDER BY of a plain SELECT may name its columns; check them in its scope.
+	if s, ok := q.Body.(*sqlast.Select); ok {
+		return v.selectBlock(s, inner, q.OrderBy)
 	}
-	for _, kw := range block {
-		if strings.Contains(low, kw) {
-			return fmt.Errorf("disallowed keyword: %s", strings.TrimSpace(kw))
+	if err := v.body(q.Body, inner); err != nil {
+		return err
+	}
+	for _, o := range q.OrderBy {
+		if err := v.subqueries(o, inner); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// dt predicate + bounded lookback
-	if opt.RequireDTFilter {
-		if opt.MaxDaysLookback <= 0 {
-			opt.MaxDaysLookback = 90
+func (v *sqlValidator) body(b sqlast.QueryBody, ctes map[string]bool) error {
+	switch b := b.(type) {
+	case *sqlast.SetOp:
+		if err := v.body(b.Left, ctes); err != nil {
+			return err
+		}
+		return v.body(b.Right, ctes)
+	case *sqlast.ParenQuery:
+		return v.query(b.Query, ctes)
+	case *sqlast.Select:
+		return v.selectBlock(b, ctes, nil)
+	}
+	return fmt.Errorf("unsupported query")
+}
+
+// subqueries validates the queries nested in the expressions under n.
+func (v *sqlValidator) subqueries(n sqlast.Node, ctes map[string]bool) error {
+	var err error
+	sqlast.Inspect(n, func(n sqlast.Node) bool {
+		if err != nil {
+			return false
 		}
-		today := opt.TodayISO
-		if strings.TrimSpace(today) == "" {
-			today = time.Now().UTC().Format("2006-01-02")
+		if q, ok := n.(*sqlast.Query); ok {
+			err = v.query(q, ctes)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+func (v *sqlValidator) selectBlock(s *sqlast.Select, ctes map[string]bool, orderBy []*sqlast.SortItem) error {
+	sc := &selectScope{}
+	for i, r := range s.From {
+		if _, unnest := r.(*sqlast.Unnest); i > 0 && !unnest && v.tables != nil {
+			return fmt.Errorf("%w: comma joins are not allowed; use JOIN ... ON shop_id and dt", ErrJoinNotAllowed)
 		}
-		if err := requireBoundedDTPredicate(low, today, opt.MaxDaysLookback); err != nil {
+		if _, err := v.relation(r, ctes, sc); err != nil {
 			return err
 		}
 	}
+	for _, it := range s.Items {
+		if it.Star {
+			if err := sc.checkStar(it); err != nil {
+				return err
+			}
+		}
+	}
 
-	// shop_id scoping
-	if len(opt.AllowedShopIDs) > 0 {
-		if err := requireAllowedShopFilter(low, opt.AllowedShopIDs); err != nil {
+	// Subqueries below may refer to this SELECT's relations.
+	v.scopes = append(v.scopes, sc)
+	defer func() { v.scopes = v.scopes[:len(v.scopes)-1] }()
+	if err := v.checkColumns(s, orderBy); err != nil {
+		return err
+	}
+	for _, o := range orderBy {
+		if err := v.subqueries(o, ctes); err != nil {
+			return err
+		}
+	}
+	for _, n := range []sqlast.Node{s.Where, s.Having} {
+		if err := v.subqueries(n, ctes); err != nil {
 			return err
 		}
-	} else {
-		if !regexp.MustCompile(`\bshop_id\b`).MatchString(low) {
-			return fmt.Errorf("missing required shop_id filter")
+	}
+	for _, it := range s.Items {
+		if err := v.subqueries(it, ctes); err != nil {
+			return err
+		}
+	}
+	for _, g := range s.GroupBy {
+		if err := v.subqueries(g, ctes); err != nil {
+			return err
 		}
 	}
 
+	if s.Where != nil {
+		sc.conds = append(sc.conds, condition{expr: s.Where})
+	}
+	shop := sc.filtered("shop_id", shopFilter)
+	var dt []bool
+	if !v.minDT.IsZero() {
+		dt = sc.filtered("dt", dtFilter)
+	}
+	for i, r := range sc.rels {
+		if !r.base {
+			continue
+		}
+		if !shop[i] {
+			return fmt.Errorf("missing required shop_id filter on %s", r.name)
+		}
+		if dt != nil && !dt[i] {
+			return fmt.Errorf("missing required dt filter on %s (dt >= ... or dt BETWEEN ...)", r.name)
+		}
+	}
 	return nil
 }
 
-// requireBoundedDTPredicate enforces dt is filtered and not older than maxDaysLookback.
-// Accepts:
-//
-//	dt >= date 'YYYY-MM-DD'
-//	dt >  date 'YYYY-MM-DD'   (treated as >= next day; MVP accept as is)
-//	dt between date 'YYYY-MM-DD' and date 'YYYY-MM-DD'
-//
-// Also accepts ISO without date keyword:
-//
-//	dt >= 'YYYY-MM-DD'
-//
-// But rejects missing lower bound (e.g. only dt <= ...).
-func requireBoundedDTPredicate(lowSQL, todayISO string, maxDays int) error {
-	today, err := time.Parse("2006-01-02", todayISO)
-	if err != nil {
-		return fmt.Errorf("invalid TodayISO: %s", todayISO)
-	}
-	minAllowed := today.AddDate(0, 0, -maxDays)
+// relation adds the relations of r to sc and returns their indexes.
+func (v *sqlValidator) relation(r sqlast.Relation, ctes map[string]bool, sc *selectScope) ([]int, error) {
+	switch r := r.(type) {
+	case *sqlast.Table:
+		tbl := r.Name[len(r.Name)-1]
+		rel := relation{name: r.Alias, base: true}
+		if rel.name == "" {
+			rel.name = tbl
+		}
+		switch {
+		case len(r.Name) == 1 && ctes[tbl]:
+			rel.base = false
+		case v.tables != nil:
+			t, ok := v.tables[tbl]
+			if !ok || (len(r.Name) > 1 && r.Name[len(r.Name)-2] != strings.ToLower(t.Database)) {
+				return nil, fmt.Errorf("%w: %s", ErrTableNotAllowed, strings.Join(r.Name, "."))
+			}
+			rel.schema = t
+		}
+		sc.rels = append(sc.rels, rel)
+		return []int{len(sc.rels) - 1}, nil
 
-	// BETWEEN pattern
-	betweenRe := regexp.MustCompile(`\bdt\b\s+between\s+(date\s+)?'(\d{4}-\d{2}-\d{2})'\s+and\s+(date\s+)?'(\d{4}-\d{2}-\d{2})'`)
-	if m := betweenRe.FindStringSubmatch(lowSQL); len(m) == 5 {
-		start := m[2]
-		// end := m[4]  // not needed for lookback bound, but could validate later
-		startDate, err := time.Parse("2006-01-02", start)
+	case *sqlast.Derived:
+		// A LATERAL query may name the relations before it in the FROM.
+		if r.Lateral {
+			v.scopes = append(v.scopes, sc)
+			defer func() { v.scopes = v.scopes[:len(v.scopes)-1] }()
+		}
+		if err := v.query(r.Query, ctes); err != nil {
+			return nil, err
+		}
+		sc.rels = append(sc.rels, relation{name: r.Alias})
+		return []int{len(sc.rels) - 1}, nil
+
+	case *sqlast.Unnest:
+		if err := v.subqueries(r, ctes); err != nil {
+			return nil, err
+		}
+		sc.rels = append(sc.rels, relation{name: r.Alias})
+		return []int{len(sc.rels) - 1}, nil
+
+	case *sqlast.Join:
+		left, err := v.relation(r.Left, ctes, sc)
+		if err != nil {
+			return nil, err
+		}
+		right, err := v.relation(r.Right, ctes, sc)
 		if err != nil {
-			return fmt.Errorf("dt BETWEEN has invalid start date: %s", start)
+			return nil, err
 		}
-		if startDate.Before(minAllowed) {
-			return fmt.Errorf("dt lookback too large: start=%s older than %d days", start, maxDays)
+		if v.tables != nil {
+			if err := checkJoin(r); err != nil {
+				return nil, err
+			}
+		}
+		if err := v.subqueries(r.On, ctes); err != nil {
+			return nil, err
+		}
+		c := condition{expr: r.On, using: r.Using, left: left, right: right, targets: map[int]bool{}}
+		switch r.Kind {
+		case sqlast.JoinInner:
+			c.targets = nil
+		case sqlast.JoinLeft:
+			for _, i := range right {
+				c.targets[i] = true
+			}
+		case sqlast.JoinRight:
+			for _, i := range left {
+				c.targets[i] = true
+			}
 		}
+		sc.conds = append(sc.conds, c)
+		return append(left, right...), nil
+	}
+	return nil, fmt.Errorf("unsupported relation")
+}
+
+// checkJoin requires an explicit join on both shop_id and dt.
+func checkJoin(j *sqlast.Join) error {
+	if _, unnest := j.Right.(*sqlast.Unnest); unnest {
 		return nil
 	}
+	if j.Kind == sqlast.JoinCross {
+		return fmt.Errorf("%w: cross joins are not allowed", ErrJoinNotAllowed)
+	}
+	if j.Natural {
+		return fmt.Errorf("%w: natural joins are not allowed; use JOIN ... ON shop_id and dt", ErrJoinNotAllowed)
+	}
+	keys := map[string]bool{}
+	for _, c := range j.Using {
+		keys[c] = true
+	}
+	for _, c := range conjuncts(j.On) {
+		if b, ok := c.(*sqlast.Binary); ok && b.Op == "=" {
+			l, lok := b.Left.(*sqlast.Ident)
+			r, rok := b.Right.(*sqlast.Ident)
+			if lok && rok && lastPart(l) == lastPart(r) {
+				keys[lastPart(l)] = true
+			}
+		}
+	}
+	if !keys["shop_id"] || !keys["dt"] {
+		return fmt.Errorf("%w: tables must be joined on both shop_id and dt", ErrJoinNotAllowed)
+	}
+	return nil
+}
 
-	// >= or > lower bound pattern
-	geRe := regexp.MustCompile(`\bdt\b\s*(>=|>)\s*(date\s+)?'(\d{4}-\d{2}-\d{2})'`)
-	if m := geRe.FindStringSubmatch(lowSQL); len(m) == 4 {
-		start := m[3]
-		startDate, err := time.Parse("2006-01-02", start)
-		if err != nil {
-			return fmt.Errorf("dt lower bound invalid: %s", start)
+// checkStar rejects * over a table with hidden columns.
+func (sc *selectScope) checkStar(it *sqlast.SelectItem) error {
+	for _, r := range sc.rels {
+		if r.schema == nil || len(r.schema.Hidden) == 0 {
+			continue
+		}
+		if len(it.Qualifier) == 0 || it.Qualifier[len(it.Qualifier)-1] == r.name {
+			return fmt.Errorf("%w: SELECT * from %s; name the columns", ErrColumnNotAllowed, r.schema.Table)
+		}
+	}
+	return nil
+}
+
+// filtered reports, for each relation of sc, whether its column col is
+// restricted by the conditions. match recognizes a restricting predicate
+// and returns the column it restricts.
+func (sc *selectScope) filtered(col string, match func(sqlast.Expr) sqlast.Expr) []bool {
+	ok := make([]bool, len(sc.rels))
+	type edge struct{ from, to int }
+	var edges []edge
+	link := func(c condition, a, b int) {
+		if a < 0 || b < 0 {
+			return
 		}
-		if startDate.Before(minAllowed) {
-			return fmt.Errorf("dt lookback too large: start=%s older than %d days", start, maxDays)
+		if c.filters(b) {
+			edges = append(edges, edge{a, b})
 		}
+		if c.filters(a) {
+			edges = append(edges, edge{b, a})
+		}
+	}
+
+	for _, c := range sc.conds {
+		for i := range sc.restricts(c.expr, col, match) {
+			if c.filters(i) {
+				ok[i] = true
+			}
+		}
+		for _, e := range conjuncts(c.expr) {
+			if b, isEq := e.(*sqlast.Binary); isEq && b.Op == "=" {
+				link(c, sc.resolve(b.Left, col), sc.resolve(b.Right, col))
+			}
+		}
+		for _, u := range c.using {
+			if u != col {
+				continue
+			}
+			for _, l := range c.left {
+				for _, r := range c.right {
+					link(c, l, r)
+				}
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, e := range edges {
+			if ok[e.from] && !ok[e.to] {
+				ok[e.to], changed = true, true
+			}
+		}
+	}
+	return ok
+}
+
+// restricts returns the relations whose col e restricts whenever it holds.
+func (sc *selectScope) restricts(e sqlast.Expr, col string, match func(sqlast.Expr) sqlast.Expr) map[int]bool {
+	if b, ok := e.(*sqlast.Binary); ok && (b.Op == "and" || b.Op == "or") {
+		l := sc.restricts(b.Left, col, match)
+		r := sc.restricts(b.Right, col, match)
+		if b.Op == "and" {
+			for i := range r {
+				l[i] = true
+			}
+			return l
+		}
+		both := map[int]bool{}
+		for i := range l {
+			if r[i] {
+				both[i] = true
+			}
+		}
+		return both
+	}
+	out := map[int]bool{}
+	if ref := match(e); ref != nil {
+		if i := sc.resolve(ref, col); i >= 0 {
+			out[i] = true
+		}
+	}
+	return out
+}
+
+// resolve returns the relation whose column col e is, or -1. An
+// unqualified column belongs to the only relation, or to the only table
+// known to have the column.
+func (sc *selectScope) resolve(e sqlast.Expr, col string) int {
+	id := columnRef(e)
+	if id == nil || lastPart(id) != col {
+		return -1
+	}
+	if len(id.Parts) > 1 {
+		q := id.Parts[len(id.Parts)-2]
+		for i, r := range sc.rels {
+			if r.name == q {
+				return i
+			}
+		}
+		return -1
+	}
+	if len(sc.rels) == 1 {
+		return 0
+	}
+	found := -1
+	for i, r := range sc.rels {
+		if r.schema == nil {
+			return -1
+		}
+		if r.schema.has(col) {
+			if found >= 0 {
+				return -1
+			}
+			found = i
+		}
+	}
+	return found
+}
+
+// columnRef unwraps CAST(x AS ...) and date(x) down to a column.
+func columnRef(e sqlast.Expr) *sqlast.Ident {
+	switch e := e.(type) {
+	case *sqlast.Ident:
+		return e
+	case *sqlast.Cast:
+		return columnRef(e.X)
+	case *sqlast.Call:
+		if e.Name == "date" && len(e.Args) == 1 {
+			return columnRef(e.Args[0])
+		}
+	}
+	return nil
+}
+
+func lastPart(id *sqlast.Ident) string { return id.Parts[len(id.Parts)-1] }
+
+func conjuncts(e sqlast.Expr) []sqlast.Expr {
+	if b, ok := e.(*sqlast.Binary); ok && b.Op == "and" {
+		return append(conjuncts(b.Left), conjuncts(b.Right)...)
+	}
+	if e == nil {
 		return nil
 	}
+	return []sqlast.Expr{e}
+}
+
+// shopFilter matches shop_id = 'x' and shop_id IN ('x', ...) and returns
+// the shop_id column.
+func shopFilter(e sqlast.Expr) sqlast.Expr {
+	ref, _ := shopValues(e)
+	return ref
+}
+
+func shopValues(e sqlast.Expr) (sqlast.Expr, []string) {
+	isShop := func(e sqlast.Expr) bool {
+		id := columnRef(e)
+		return id != nil && lastPart(id) == "shop_id"
+	}
+	switch e := e.(type) {
+	case *sqlast.Binary:
+		if e.Op != "=" {
+			return nil, nil
+		}
+		if isShop(e.Left) {
+			if v, ok := shopLiteral(e.Right); ok {
+				return e.Left, []string{v}
+			}
+		}
+		if isShop(e.Right) {
+			if v, ok := shopLiteral(e.Left); ok {
+				return e.Right, []string{v}
+			}
+		}
+	case *sqlast.In:
+		if e.Not || e.Query != nil || len(e.List) == 0 || !isShop(e.X) {
+			return nil, nil
+		}
+		var vals []string
+		for _, it := range e.List {
+			v, ok := shopLiteral(it)
+			if !ok {
+				return nil, nil
+			}
+			vals = append(vals, v)
+		}
+		return e.X, vals
+	}
+	return nil, nil
+}
 
-	// If dt exists but no >=/between, reject (prevents dt <= only)
-	if regexp.MustCompile(`\bdt\b`).MatchString(lowSQL) {
-		return fmt.Errorf("dt filter must include a lower bound (dt >= ... or dt BETWEEN ...)")
+func shopLiteral(e sqlast.Expr) (string, bool) {
+	if l, ok := e.(*sqlast.Literal); ok && (l.Kind == sqlast.LitString || l.Kind == sqlast.LitNumber) {
+		return l.Value, true
 	}
-	return fmt.Errorf("missing required dt filter")
+	return "", false
+}
+
+// dtFilter matches a lower bound on dt: dt >= / > / = d, d <= / < / = dt,
+// dt BETWEEN d AND ..., dt IN (d, ...), with literal dates d.
+func dtFilter(e sqlast.Expr) sqlast.Expr {
+	ref, _ := dtLowerBound(e)
+	return ref
 }
 
-func requireAllowedShopFilter(lowSQL string, allowed []string) error {
-	// Must mention shop_id somewhere
-	if !regexp.MustCompile(`\bshop_id\b`).MatchString(lowSQL) {
-		return fmt.Errorf("missing required shop_id filter")
+func dtLowerBound(e sqlast.Expr) (sqlast.Expr, []string) {
+	isDT := func(e sqlast.Expr) bool {
+		id := columnRef(e)
+		return id != nil && lastPart(id) == "dt"
+	}
+	switch e := e.(type) {
+	case *sqlast.Binary:
+		switch {
+		case (e.Op == ">=" || e.Op == ">" || e.Op == "=") && isDT(e.Left):
+			if d, ok := dateLiteral(e.Right); ok {
+				return e.Left, []string{d}
+			}
+		case (e.Op == "<=" || e.Op == "<" || e.Op == "=") && isDT(e.Right):
+			if d, ok := dateLiteral(e.Left); ok {
+				return e.Right, []string{d}
+			}
+		}
+	case *sqlast.Between:
+		if !e.Not && isDT(e.X) {
+			if d, ok := dateLiteral(e.Low); ok {
+				return e.X, []string{d}
+			}
+		}
+	case *sqlast.In:
+		if e.Not || e.Query != nil || len(e.List) == 0 || !isDT(e.X) {
+			return nil, nil
+		}
+		var ds []string
+		for _, it := range e.List {
+			d, ok := dateLiteral(it)
+			if !ok {
+				return nil, nil
+			}
+			ds = append(ds, d)
+		}
+		return e.X, ds
 	}
+	return nil, nil
+}
 
-	// Must NOT reference a shop_id literal outside allowlist.
-	// MVP approach: if query contains shop_id = 'X' or shop_id in ('X','Y'),
-	// ensure all quoted values are subset of allowlist.
-	allow := map[string]bool{}
-	for _, v := range allowed {
-		allow[strings.ToLower(strings.TrimSpace(v))] = true
+// dateLiteral returns the text of 'x', DATE 'x', TIMESTAMP 'x', date('x')
+// and CAST('x' AS ...).
+func dateLiteral(e sqlast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *sqlast.Literal:
+		if e.Kind == sqlast.LitString || (e.Kind == sqlast.LitTyped && (e.Type == "date" || e.Type == "timestamp")) {
+			return e.Value, true
+		}
+	case *sqlast.Cast:
+		return dateLiteral(e.X)
+	case *sqlast.Call:
+		if e.Name == "date" && len(e.Args) == 1 {
+			return dateLiteral(e.Args[0])
+		}
 	}
+	return "", false
+}
 
-	// Extract quoted strings near shop_id comparisons (MVP heuristic)
-	// Captures values in IN (...) or '=' contexts.
-	re := regexp.MustCompile(`\bshop_id\b\s*(=|in)\s*\(([^)]*)\)|\bshop_id\b\s*=\s*'([^']*)'`)
-	matches := re.FindAllStringSubmatch(lowSQL, -1)
-	if len(matches) == 0 {
-		// It has shop_id token but no detectable predicate
-		return fmt.Errorf("shop_id filter must be equality or IN list")
+// checkLiterals rejects shop_id values outside the allowlist and dt lower
+// bounds older than the lookback, wherever they appear.
+func (v *sqlValidator) checkLiterals(q *sqlast.Query) error {
+	var err error
+	sqlast.Inspect(q, func(n sqlast.Node) bool {
+		e, ok := n.(sqlast.Expr)
+		if err != nil || !ok {
+			return err == nil
+		}
+		if _, vals := shopValues(e); v.shops != nil {
+			for _, s := range vals {
+				if !v.shops[strings.ToLower(strings.TrimSpace(s))] {
+					err = fmt.Errorf("%w: %s", ErrShopNotAllowed, s)
+					return false
+				}
+			}
+		}
+		if _, dates := dtLowerBound(e); !v.minDT.IsZero() {
+			for _, d := range dates {
+				if len(d) > 10 {
+					d = d[:10]
+				}
+				start, perr := time.Parse("2006-01-02", d)
+				if perr != nil {
+					err = fmt.Errorf("dt lower bound invalid: %s", d)
+					return false
+				}
+				if start.Before(v.minDT) {
+					err = fmt.Errorf("dt lookback too large: start=%s older than %d days", d, v.maxDays)
+					return false
+				}
+			}
+		}
+		return true
+	})
+	return err
+}
+
+// checkColumns rejects references to hidden columns in s (and the ORDER BY
+// over it), nested queries aside: they are checked in their own SELECT.
+// Each reference is resolved to its relation, by qualifier or, unqualified,
+// among the relations of s, then of the enclosing SELECTs, so a column
+// hidden in one table is not let through because another table read
+// allows a column of that name.
+func (v *sqlValidator) checkColumns(s *sqlast.Select, orderBy []*sqlast.SortItem) error {
+	nodes := []sqlast.Node{s.Where, s.Having}
+	for _, it := range s.Items {
+		nodes = append(nodes, it)
+	}
+	for _, r := range s.From {
+		nodes = append(nodes, r) // join conditions and UNNEST arguments
+	}
+	for _, g := range s.GroupBy {
+		nodes = append(nodes, g)
+	}
+	for _, w := range s.Windows {
+		nodes = append(nodes, w)
+	}
+	for _, o := range orderBy {
+		nodes = append(nodes, o)
 	}
 
-	// Parse any IN list values: 'a','b'
-	inValRe := regexp.MustCompile(`'([^']*)'`)
-	for _, m := range matches {
-		// m[2] is inside (...) if IN was used
-		if strings.TrimSpace(m[2]) != "" {
-			valMatches := inValRe.FindAllStringSubmatch(m[2], -1)
-			if len(valMatches) == 0 {
-				return fmt.Errorf("shop_id IN list must contain quoted values")
+	var err error
+	for _, n := range nodes {
+		sqlast.Inspect(n, func(n sqlast.Node) bool {
+			if err != nil {
+				return false
+			}
+			switch n := n.(type) {
+			case *sqlast.Query:
+				return false
+			case *sqlast.Ident:
+				err = v.hiddenRef(n)
 			}
-			for _, vm := range valMatches {
-				v := strings.ToLower(strings.TrimSpace(vm[1]))
-				if !allow[v] {
-					return fmt.Errorf("shop_id value not allowed: %s", vm[1])
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hiddenRef rejects id if it names a hidden column of the relation it
+// resolves to. A reference that can't be resolved is rejected when any
+// relation in scope hides its column.
+func (v *sqlValidator) hiddenRef(id *sqlast.Ident) error {
+	col := lastPart(id)
+	reject := func(r relation) error {
+		return fmt.Errorf("%w: %s.%s", ErrColumnNotAllowed, r.schema.Table, col)
+	}
+	if len(id.Parts) > 1 {
+		q := id.Parts[len(id.Parts)-2]
+		for i := len(v.scopes) - 1; i >= 0; i-- {
+			for _, r := range v.scopes[i].rels {
+				if r.name != q {
+					continue
+				}
+				if r.hides(col) {
+					return reject(r)
 				}
+				return nil
 			}
-			return nil
 		}
-		// m[3] is direct equality value
-		if strings.TrimSpace(m[3]) != "" {
-			v := strings.ToLower(strings.TrimSpace(m[3]))
-			if !allow[v] {
-				return fmt.Errorf("shop_id value not allowed: %s", m[3])
+	}
+	for i := len(v.scopes) - 1; i >= 0; i-- {
+		found := false
+		for _, r := range v.scopes[i].rels {
+			if r.hides(col) {
+				return reject(r)
+			}
+			if r.schema == nil || r.schema.has(col) {
+				found = true
 			}
+		}
+		if found && len(id.Parts) == 1 {
 			return nil
 		}
 	}
+	return nil
+}
 
-	return fmt.Errorf("unable to validate shop_id predicate")
+// hides reports whether col is a hidden column of r's table.
+func (r relation) hides(col string) bool {
+	if r.schema == nil || r.schema.has(col) {
+		return false
+	}
+	for _, h := range r.schema.Hidden {
+		if strings.EqualFold(h, col) {
+			return true
+		}
+	}
+	return false
 }
 
 // wrapAggregate protects against NULL results from aggregates
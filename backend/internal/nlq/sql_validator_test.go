@@ -0,0 +1,135 @@
+package nlq
+
+import (
+	"errors"
+	"testing"
+)
+
+func joinTables() []*TableSchema {
+	parts := []Column{{Name: "shop_id", Type: "string"}, {Name: "dt", Type: "string"}}
+	return []*TableSchema{
+		{
+			Table:      "orders_fact",
+			Columns:    []Column{{Name: "order_id", Type: "string"}, {Name: "revenue", Type: "double"}},
+			Partitions: parts,
+			Hidden:     []string{"email"},
+		},
+		{
+			Table:      "customers_dim",
+			Columns:    []Column{{Name: "customer_id", Type: "string"}, {Name: "email", Type: "string"}},
+			Partitions: parts,
+		},
+	}
+}
+
+func TestValidateSQLHiddenColumnResolvesToItsTable(t *testing.T) {
+	opt := ValidateOptions{
+		AllowedShopIDs:  []string{"s1"},
+		RequireDTFilter: true,
+		MaxDaysLookback: 90,
+		TodayISO:        "2026-01-31",
+		Tables:          joinTables(),
+	}
+	const from = ` FROM orders_fact o JOIN customers_dim c ON o.shop_id = c.shop_id AND o.dt = c.dt` +
+		` WHERE o.shop_id = 's1' AND o.dt >= '2026-01-01'`
+	cases := []struct {
+		name, sql string
+		ok        bool
+	}{
+		{"allowed table's column", "SELECT c.email, o.revenue" + from, true},
+		{"hidden table's column", "SELECT o.email" + from, false},
+		{"hidden via full name", "SELECT orders_fact.email FROM orders_fact WHERE shop_id = 's1' AND dt >= '2026-01-01'", false},
+		{"unqualified, ambiguous", "SELECT email" + from, false},
+		{"hidden in where", "SELECT c.email" + from + " AND o.email IS NOT NULL", false},
+		{"hidden in subquery", "SELECT c.email" + from + " AND o.order_id IN (SELECT x.order_id FROM orders_fact x WHERE x.shop_id = 's1' AND x.dt >= '2026-01-01' AND x.email = 'a')", false},
+		{"hidden via lateral", "SELECT l.e FROM orders_fact o JOIN LATERAL (SELECT o.email AS e, o.shop_id AS shop_id, o.dt AS dt) l" +
+			" ON o.shop_id = l.shop_id AND o.dt = l.dt WHERE o.shop_id = 's1' AND o.dt >= '2026-01-01'", false},
+		{"allowed via lateral", "SELECT l.r FROM orders_fact o JOIN LATERAL (SELECT o.revenue AS r, o.shop_id AS shop_id, o.dt AS dt) l" +
+			" ON o.shop_id = l.shop_id AND o.dt = l.dt WHERE o.shop_id = 's1' AND o.dt >= '2026-01-01'", true},
+		{"unqualified, single table", "SELECT email FROM customers_dim WHERE shop_id = 's1' AND dt >= '2026-01-01'", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidateSQL(c.sql, opt)
+			if c.ok && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !c.ok && !errors.Is(err, ErrColumnNotAllowed) {
+				t.Fatalf("err = %v, want ErrColumnNotAllowed", err)
+			}
+		})
+	}
+}
+
+func TestValidateSQLFilters(t *testing.T) {
+	opt := ValidateOptions{
+		AllowedShopIDs:  []string{"s1", "s2"},
+		RequireDTFilter: true,
+		MaxDaysLookback: 90,
+		TodayISO:        "2026-01-31",
+	}
+	const ok = "shop_id = 's1' AND dt >= '2026-01-01'"
+	cases := []struct {
+		name, sql string
+		ok        bool
+	}{
+		{"filtered", "SELECT SUM(revenue) FROM daily_metrics WHERE " + ok, true},
+		{"shop IN allowlist", "SELECT 1 FROM daily_metrics WHERE shop_id IN ('s1', 's2') AND dt BETWEEN '2026-01-01' AND '2026-01-31'", true},
+		{"no where", "SELECT 1 FROM daily_metrics", false},
+		{"shop outside allowlist", "SELECT 1 FROM daily_metrics WHERE shop_id = 's3' AND dt >= '2026-01-01'", false},
+		{"other shop in a case expression", "SELECT CASE WHEN shop_id = 's3' THEN 1 END FROM daily_metrics WHERE " + ok, false},
+		{"other shop in an in list", "SELECT 1 FROM daily_metrics WHERE " + ok + " OR shop_id IN ('s1', 's3') AND dt >= '2026-01-01'", false},
+
+		// OR counts only when every branch filters.
+		{"or, both branches filter", "SELECT 1 FROM daily_metrics WHERE (shop_id = 's1' AND dt >= '2026-01-01') OR (shop_id = 's2' AND dt >= '2026-01-01')", true},
+		{"or, one branch without shop_id", "SELECT 1 FROM daily_metrics WHERE (shop_id = 's1' AND dt >= '2026-01-01') OR revenue > 0", false},
+		{"or at top level", "SELECT 1 FROM daily_metrics WHERE shop_id = 's1' AND dt >= '2026-01-01' OR 1 = 1", false},
+
+		// Every SELECT that reads a table, at any depth.
+		{"subquery without shop_id", "SELECT 1 FROM daily_metrics WHERE " + ok + " AND revenue > (SELECT AVG(revenue) FROM daily_metrics WHERE dt >= '2026-01-01')", false},
+		{"subquery filtered", "SELECT 1 FROM daily_metrics WHERE " + ok + " AND revenue > (SELECT AVG(revenue) FROM daily_metrics WHERE " + ok + ")", true},
+		{"derived table without shop_id", "SELECT * FROM (SELECT * FROM daily_metrics WHERE dt >= '2026-01-01') d WHERE d.shop_id = 's1'", false},
+		{"union branch without shop_id", "SELECT revenue FROM daily_metrics WHERE " + ok + " UNION ALL SELECT revenue FROM daily_metrics WHERE dt >= '2026-01-01'", false},
+		{"union, both filtered", "SELECT revenue FROM daily_metrics WHERE " + ok + " UNION ALL SELECT revenue FROM daily_metrics WHERE shop_id = 's2' AND dt >= '2026-01-01'", true},
+		{"join filter reaches the other table", "SELECT 1 FROM daily_metrics a JOIN daily_metrics b ON a.shop_id = b.shop_id AND a.dt = b.dt WHERE a.shop_id = 's1' AND a.dt >= '2026-01-01'", true},
+		{"left join, filter only on the outer side", "SELECT 1 FROM daily_metrics a LEFT JOIN daily_metrics b ON a.dt = b.dt WHERE a.shop_id = 's1' AND a.dt >= '2026-01-01'", false},
+
+		// WITH names.
+		{"cte filtered", "WITH m AS (SELECT * FROM daily_metrics WHERE " + ok + ") SELECT SUM(revenue) FROM m", true},
+		{"cte without shop_id", "WITH m AS (SELECT * FROM daily_metrics WHERE dt >= '2026-01-01') SELECT SUM(revenue) FROM m WHERE shop_id = 's1'", false},
+		{"cte shadowing the table", "WITH daily_metrics AS (SELECT * FROM daily_metrics) SELECT 1 FROM daily_metrics WHERE " + ok, false},
+		{"cte shadowing the table, filtered", "WITH daily_metrics AS (SELECT * FROM daily_metrics WHERE " + ok + ") SELECT 1 FROM daily_metrics", true},
+		{"cte out of scope reads the table", "SELECT 1 FROM (WITH m AS (SELECT * FROM daily_metrics WHERE " + ok + ") SELECT * FROM m) x JOIN m ON x.dt = m.dt", false},
+
+		// dt lower bound.
+		{"no dt filter", "SELECT 1 FROM daily_metrics WHERE shop_id = 's1'", false},
+		{"dt upper bound only", "SELECT 1 FROM daily_metrics WHERE shop_id = 's1' AND dt <= '2026-01-31'", false},
+		{"dt beyond the lookback", "SELECT 1 FROM daily_metrics WHERE shop_id = 's1' AND dt >= '2025-01-01'", false},
+		{"dt at the lookback", "SELECT 1 FROM daily_metrics WHERE shop_id = 's1' AND dt >= '2025-11-02'", true},
+		{"dt between beyond the lookback", "SELECT 1 FROM daily_metrics WHERE shop_id = 's1' AND dt BETWEEN '2024-01-01' AND '2026-01-31'", false},
+
+		// Statement shape.
+		{"stacked statement", "SELECT 1 FROM daily_metrics WHERE " + ok + "; DROP TABLE daily_metrics", false},
+		{"trailing semicolon", "SELECT 1 FROM daily_metrics WHERE " + ok + ";", false},
+		{"line comment hiding a condition", "SELECT 1 FROM daily_metrics WHERE " + ok + " -- AND shop_id = 's1'", false},
+		{"block comment", "SELECT 1 FROM daily_metrics /* x */ WHERE " + ok, false},
+		{"not a query", "DELETE FROM daily_metrics WHERE " + ok, false},
+
+		// Identifiers fold as Trino folds them.
+		{"quoted and mixed-case filter", `SELECT 1 FROM "Daily_Metrics" WHERE "SHOP_ID" = 's1' AND Dt >= '2026-01-01'`, true},
+		{"quoted column, other shop", `SELECT 1 FROM daily_metrics WHERE ` + ok + ` AND "Shop_Id" = 's3'`, false},
+		{"quoted table without filter", `SELECT 1 FROM "DAILY_METRICS"`, false},
+		{"mixed-case cte shadowing the table", `WITH "Daily_Metrics" AS (SELECT * FROM daily_metrics) SELECT 1 FROM DAILY_METRICS WHERE ` + ok, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidateSQL(c.sql, opt)
+			if c.ok && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !c.ok && err == nil {
+				t.Fatal("accepted")
+			}
+		})
+	}
+}
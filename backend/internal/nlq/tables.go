@@ -0,0 +1,210 @@
+package nlq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// /ask can query several Glue tables in GLUE_DATABASE. daily_metrics is
+// always there; the fact tables are added when their env var names a Glue
+// table. Every table must be partitioned (or at least keyed) by dt and
+// shop_id: the validator's dt bound and shop allowlist rely on it, and
+// tables are joined on those two columns only.
+//
+// A fact table's Columns is also its allowlist. Glue columns outside it are
+// left out of the prompt and rejected in SQL (ValidateSQL with
+// ValidateOptions.Tables), so customer fields in the orders fact never reach
+// the model or a result.
+//
+// The column denylist does the same for every table, daily_metrics
+// included, whatever its allowlist says: personal data such as a customer
+// email column added by a later ETL stays out even before anyone updates
+// the specs. defaultColumnDenylist is always on; NLQ_COLUMN_DENYLIST adds
+// comma-separated entries, each "column" or "table.column" (table is the
+// spec Kind or the Glue name), with * globs, e.g. "orders_fact.note,*_ip".
+// dt and shop_id can't be denied.
+
+// TableSpec describes one table /ask may query.
+type TableSpec struct {
+	Kind        string // stable name, independent of the Glue table name
+	Env         string // env var holding the Glue table name
+	Required    bool
+	Description string
+	Columns     map[string]ColumnDoc // allowlist with docs; nil allows every column (docs from the glossary)
+}
+
+var tableSpecs = []TableSpec{
+	{
+		Kind:        "daily_metrics",
+		Env:         "DAILY_METRICS_TABLE",
+		Required:    true,
+		Description: "One row per shop and day with revenue and costs.",
+	},
+	{
+		Kind:        "orders_fact",
+		Env:         "ORDERS_FACT_TABLE",
+		Description: "One row per order. Several rows per shop and day: aggregate by shop_id, dt before joining daily_metrics.",
+		Columns: map[string]ColumnDoc{
+			"order_id":        {Label: "Order", Description: "Platform order id."},
+			"order_name":      {Label: "Order number", Description: "Order number shown to the merchant, e.g. '#1042'."},
+			"created_at":      {Label: "Created", Description: "Order time as an ISO-8601 UTC string.", Unit: "date"},
+			"currency":        {Label: "Currency", Description: "Currency of the order's amounts."},
+			"gross_sales":     {Label: "Gross sales", Description: "Line item totals before discounts.", Unit: "money"},
+			"discounts":       {Label: "Discounts", Description: "Discounts applied to the order, as a positive amount.", Unit: "money"},
+			"refunds":         {Label: "Refunds", Description: "Amount refunded on the order so far.", Unit: "money"},
+			"net_sales":       {Label: "Net sales", Description: "Gross sales minus discounts and refunds.", Unit: "money"},
+			"product_costs":   {Label: "Product costs", Description: "Cost of goods of the order's items.", Unit: "money"},
+			"shipping":        {Label: "Shipping", Description: "Shipping charged to the customer.", Unit: "money"},
+			"taxes":           {Label: "Taxes", Description: "Taxes charged on the order.", Unit: "money"},
+			"items":           {Label: "Items", Description: "Number of units in the order."},
+			"source":          {Label: "Channel", Description: "Sales channel the order came from, e.g. 'web', 'pos'."},
+			"is_first_order":  {Label: "First order", Description: "True when it is the customer's first order in the shop.", Unit: "bool"},
+			"discount_code":   {Label: "Discount code", Description: "First discount code used, '' when none."},
+			"financial_state": {Label: "Payment status", Description: "Platform payment status, e.g. 'paid', 'refunded'."},
+		},
+	},
+	{
+		Kind:        "ad_spend",
+		Env:         "AD_SPEND_TABLE",
+		Description: "One row per shop, day and ad campaign. Aggregate by shop_id, dt before joining daily_metrics.",
+		Columns: map[string]ColumnDoc{
+			"platform":         {Label: "Platform", Description: "Ad platform, e.g. 'meta', 'google'."},
+			"account_id":       {Label: "Ad account", Description: "Ad account id on the platform."},
+			"campaign_id":      {Label: "Campaign", Description: "Campaign id on the platform."},
+			"campaign_name":    {Label: "Campaign name", Description: "Campaign name as set on the platform."},
+			"currency":         {Label: "Currency", Description: "Currency of spend and conversion_value."},
+			"spend":            {Label: "Spend", Description: "Amount spent on the campaign that day.", Unit: "money"},
+			"impressions":      {Label: "Impressions", Description: "Times the campaign's ads were shown."},
+			"clicks":           {Label: "Clicks", Description: "Clicks on the campaign's ads."},
+			"conversions":      {Label: "Conversions", Description: "Purchases the platform attributes to the campaign."},
+			"conversion_value": {Label: "Conversion value", Description: "Revenue the platform attributes to the campaign.", Unit: "money"},
+		},
+	},
+}
+
+var defaultColumnDenylist = []string{
+	"*email*", "*phone*", "*address*",
+	"first_name", "last_name", "customer_name", "browser_ip",
+}
+
+// columnDenylist is defaultColumnDenylist plus NLQ_COLUMN_DENYLIST,
+// lowercased.
+func columnDenylist() []string {
+	out := append([]string(nil), defaultColumnDenylist...)
+	for _, e := range strings.Split(os.Getenv("NLQ_COLUMN_DENYLIST"), ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// denied reports whether the denylist covers column of s.
+func (s *TableSchema) denied(column string, denylist []string) bool {
+	if column == "dt" || column == "shop_id" {
+		return false
+	}
+	for _, e := range denylist {
+		pattern := e
+		if table, col, ok := strings.Cut(e, "."); ok {
+			if table != strings.ToLower(s.Kind) && table != strings.ToLower(s.Table) {
+				continue
+			}
+			pattern = col
+		}
+		if ok, _ := path.Match(pattern, column); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSchemasFromEnv loads every configured table, daily_metrics first,
+// with the allowlists applied.
+func LoadSchemasFromEnv(ctx context.Context, c GlueClient) ([]*TableSchema, error) {
+	db := strings.TrimSpace(os.Getenv("GLUE_DATABASE"))
+	if db == "" {
+		return nil, fmt.Errorf("missing env vars: GLUE_DATABASE")
+	}
+	var out []*TableSchema
+	for _, spec := range tableSpecs {
+		name := strings.TrimSpace(os.Getenv(spec.Env))
+		if name == "" {
+			if spec.Required {
+				return nil, fmt.Errorf("missing env vars: %s", spec.Env)
+			}
+			continue
+		}
+		s, err := LoadTableSchema(ctx, c, db, name)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.apply(spec); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// apply checks s has the join keys and drops the columns spec doesn't allow
+// and the denylisted ones.
+func (s *TableSchema) apply(spec TableSpec) error {
+	s.Kind, s.Description, s.docs = spec.Kind, spec.Description, spec.Columns
+	for _, key := range []string{"dt", "shop_id"} {
+		if !s.has(key) {
+			return fmt.Errorf("table %s.%s has no %s column", s.Database, s.Table, key)
+		}
+	}
+	denylist := columnDenylist()
+	keep := func(cols []Column, allowlist map[string]ColumnDoc) []Column {
+		kept := cols[:0]
+		for _, c := range cols {
+			name := strings.ToLower(c.Name)
+			_, allowed := allowlist[name]
+			if (allowlist == nil || allowed) && !s.denied(name, denylist) {
+				kept = append(kept, c)
+				continue
+			}
+			s.Hidden = append(s.Hidden, name)
+		}
+		return kept
+	}
+	s.Columns = keep(s.Columns, spec.Columns)
+	s.Partitions = keep(s.Partitions, nil)
+	return nil
+}
+
+func (s *TableSchema) has(name string) bool {
+	for _, c := range append(append([]Column(nil), s.Columns...), s.Partitions...) {
+		if strings.EqualFold(c.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe returns the doc of a column of s: the spec's for fact tables,
+// the glossary's for daily_metrics and the shared dt and shop_id keys.
+func (s *TableSchema) Describe(name string) (ColumnDoc, bool) {
+	if d, ok := s.docs[strings.ToLower(name)]; ok {
+		return d, true
+	}
+	if s.docs != nil && !strings.EqualFold(name, "dt") && !strings.EqualFold(name, "shop_id") {
+		return ColumnDoc{}, false
+	}
+	return DescribeColumn(name)
+}
+
+// joinPromptText is the JOINS block of the prompt, shown when there is more
+// than one table.
+func joinPromptText() string {
+	return `JOINS:
+- Tables may only be joined with JOIN ... ON, on both shop_id and dt (e.g. m.shop_id = o.shop_id AND m.dt = o.dt). No CROSS JOIN, no comma joins.
+- Filter every table on dt and shop_id.
+- Aggregate a table with several rows per shop and day (GROUP BY shop_id, dt) in a subquery or WITH clause before joining it, so daily amounts are not repeated.
+`
+}
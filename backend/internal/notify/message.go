@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxSubjectLen is SNS's limit for email subjects.
+	MaxSubjectLen = 100
+	// MaxFieldLen caps a single field value; a long note or SQL string
+	// should not crowd out the rest of the message.
+	MaxFieldLen = 500
+
+	// defaultMaxBytes stays well under SNS's 256KB publish limit; anything
+	// larger is unreadable in an email anyway.
+	defaultMaxBytes = 8 * 1024
+
+	TruncatedMarker = "…[truncated]"
+)
+
+// MaxBytes is the body cap (NOTIFY_MAX_BYTES, default 8KB, never above 256KB).
+func MaxBytes() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("NOTIFY_MAX_BYTES"))); err == nil && n > 0 {
+		if n > 256*1024 {
+			return 256 * 1024
+		}
+		return n
+	}
+	return defaultMaxBytes
+}
+
+// Message is a plain-text notification body built from labelled fields.
+// Every channel (user alert emails, ops topic) goes through it so size limits
+// and truncation look the same everywhere.
+type Message struct {
+	subject string
+	lines   []string
+}
+
+func New(subject string) *Message {
+	return &Message{subject: subject}
+}
+
+// Line appends a free-form line.
+func (m *Message) Line(s string) *Message {
+	m.lines = append(m.lines, s)
+	return m
+}
+
+// Field appends "Label: value", truncating long values. Empty values are skipped.
+func (m *Message) Field(label string, value any) *Message {
+	s := strings.TrimSpace(fmt.Sprintf("%v", value))
+	if value == nil || s == "" || s == "<nil>" {
+		return m
+	}
+	return m.Line(label + ": " + Truncate(s, MaxFieldLen))
+}
+
+// Fields appends the allowlisted keys of payload, in allowlist order. Keys not
+// in the allowlist are never rendered, so a new webhook field can't leak into
+// emails by accident.
+func (m *Message) Fields(payload map[string]any, allow []Allowed) *Message {
+	for _, a := range allow {
+		if v, ok := payload[a.Key]; ok {
+			m.Field(a.Label, v)
+		}
+	}
+	return m
+}
+
+// Allowed is one payload key allowed into a message, with its display label.
+type Allowed struct {
+	Key   string
+	Label string
+}
+
+// Subject returns the subject capped to MaxSubjectLen.
+func (m *Message) Subject() string {
+	return Truncate(m.subject, MaxSubjectLen)
+}
+
+// Body returns the joined lines capped to MaxBytes.
+func (m *Message) Body() string {
+	return TruncateBytes(strings.Join(m.lines, "\n"), MaxBytes())
+}
+
+// Truncate shortens s to at most n runes, ending with TruncatedMarker when cut.
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	keep := n - utf8.RuneCountInString(TruncatedMarker)
+	if keep < 0 {
+		keep = 0
+	}
+	r := []rune(s)
+	return string(r[:keep]) + TruncatedMarker
+}
+
+// TruncateBytes shortens s to at most n bytes without splitting a UTF-8
+// sequence, ending with a truncation marker line when cut.
+func TruncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	marker := "\n" + TruncatedMarker
+	keep := n - len(marker)
+	if keep < 0 {
+		keep = 0
+	}
+	for keep > 0 && !utf8.RuneStart(s[keep]) {
+		keep--
+	}
+	return s[:keep] + marker
+}
@@ -0,0 +1,20 @@
+package notify
+
+import "fmt"
+
+// MaxOrderLineItems caps how many line items an order alert may list.
+const MaxOrderLineItems = 10
+
+// Preferences are what a user wants in their alert emails.
+type Preferences struct {
+	// OrderLineItems is how many of an order's top line items (title × qty,
+	// by value) order alerts list; 0 leaves line items out.
+	OrderLineItems int `json:"orderLineItems"`
+}
+
+func (p Preferences) Validate() error {
+	if p.OrderLineItems < 0 || p.OrderLineItems > MaxOrderLineItems {
+		return fmt.Errorf("notifications.orderLineItems must be between 0 and %d", MaxOrderLineItems)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package ops
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"backend/internal/notify"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+func AlertsTopicArn() string {
+	return strings.TrimSpace(os.Getenv("OPS_ALERTS_TOPIC_ARN"))
+}
+
+// Notify publishes a message to the maintainers' ops topic.
+// It is a no-op when OPS_ALERTS_TOPIC_ARN is not configured.
+func Notify(ctx context.Context, snsClient *sns.Client, subject, message string) error {
+	arn := AlertsTopicArn()
+	if arn == "" {
+		return nil
+	}
+
+	_, err := snsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(arn),
+		Subject:  aws.String(notify.Truncate(subject, notify.MaxSubjectLen)),
+		Message:  aws.String(notify.TruncateBytes(message, notify.MaxBytes())),
+	})
+	return err
+}
@@ -0,0 +1,244 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Daily counters for the weekly ops report, in OPS_STATUS_TABLE next to the
+// beats. Like Beat, every writer here is best effort and never fails the
+// caller.
+//
+// PK = DAY#<YYYY-MM-DD> (UTC)
+// SK = COMPONENT#<subsystem>#<component>   Runs, FailedRuns, Events, FailedEvents, DurationMs, TimedRuns, MaxDurationMs
+// SK = SHOP#<shop>                          Failures
+
+const counterTTL = 60 * 24 * time.Hour
+
+func dayPK(t time.Time) string {
+	return "DAY#" + t.UTC().Format("2006-01-02")
+}
+
+func bump(ctx context.Context, ddb *dynamodb.Client, sk string, add map[string]int64, extra map[string]types.AttributeValue) {
+	tbl := StatusTableName()
+	if tbl == "" {
+		return
+	}
+	now := time.Now()
+	var parts []string
+	vals := map[string]types.AttributeValue{
+		":exp": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(counterTTL).Unix(), 10)},
+	}
+	for k, v := range add {
+		parts = append(parts, fmt.Sprintf("%s :%s", k, k))
+		vals[":"+k] = &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+	}
+	sort.Strings(parts)
+	set := []string{"ExpiresAt = :exp"}
+	for k, v := range extra {
+		set = append(set, fmt.Sprintf("%s = if_not_exists(%s, :%s)", k, k, k))
+		vals[":"+k] = v
+	}
+	sort.Strings(set)
+
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: dayPK(now)},
+			"SK": &types.AttributeValueMemberS{Value: sk},
+		},
+		UpdateExpression:          aws.String("SET " + strings.Join(set, ", ") + " ADD " + strings.Join(parts, ", ")),
+		ExpressionAttributeValues: vals,
+	})
+	if err != nil {
+		fmt.Printf("ops: counter %s failed: %v\n", sk, err)
+	}
+}
+
+func componentSK(subsystem, component string) string {
+	return "COMPONENT#" + subsystem + "#" + component
+}
+
+func componentAttrs(subsystem, component string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"Subsystem": &types.AttributeValueMemberS{Value: subsystem},
+		"Component": &types.AttributeValueMemberS{Value: component},
+	}
+}
+
+func countRun(ctx context.Context, ddb *dynamodb.Client, subsystem, component string, runErr error) {
+	failed := int64(0)
+	if runErr != nil {
+		failed = 1
+	}
+	bump(ctx, ddb, componentSK(subsystem, component), map[string]int64{"Runs": 1, "FailedRuns": failed}, componentAttrs(subsystem, component))
+}
+
+// Events counts items (messages, orders, emails) a component handled and how
+// many of them failed.
+func Events(ctx context.Context, ddb *dynamodb.Client, subsystem, component string, total, failed int) {
+	if total == 0 {
+		return
+	}
+	bump(ctx, ddb, componentSK(subsystem, component), map[string]int64{"Events": int64(total), "FailedEvents": int64(failed)}, componentAttrs(subsystem, component))
+}
+
+// Duration records how long one run of a component took.
+func Duration(ctx context.Context, ddb *dynamodb.Client, subsystem, component string, d time.Duration) {
+	ms := d.Milliseconds()
+	bump(ctx, ddb, componentSK(subsystem, component), map[string]int64{"DurationMs": ms, "TimedRuns": 1}, componentAttrs(subsystem, component))
+
+	tbl := StatusTableName()
+	if tbl == "" {
+		return
+	}
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: dayPK(time.Now())},
+			"SK": &types.AttributeValueMemberS{Value: componentSK(subsystem, component)},
+		},
+		UpdateExpression:          aws.String("SET MaxDurationMs = :d"),
+		ConditionExpression:       aws.String("attribute_not_exists(MaxDurationMs) OR MaxDurationMs < :d"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":d": &types.AttributeValueMemberN{Value: strconv.FormatInt(ms, 10)}},
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		fmt.Printf("ops: max duration %s/%s failed: %v\n", subsystem, component, err)
+	}
+}
+
+// ShopFailure counts a failure attributable to one shop (a webhook that
+// could not be processed, a sync or repair that errored).
+func ShopFailure(ctx context.Context, ddb *dynamodb.Client, shop string) {
+	shop = strings.ToLower(strings.TrimSpace(shop))
+	if shop == "" {
+		return
+	}
+	bump(ctx, ddb, "SHOP#"+shop, map[string]int64{"Failures": 1}, map[string]types.AttributeValue{
+		"Shop": &types.AttributeValueMemberS{Value: shop},
+	})
+}
+
+// ComponentDay is one component's counters for one day, or summed over days.
+type ComponentDay struct {
+	Subsystem     string `dynamodbav:"Subsystem" json:"subsystem"`
+	Component     string `dynamodbav:"Component" json:"component"`
+	Runs          int    `dynamodbav:"Runs" json:"runs"`
+	FailedRuns    int    `dynamodbav:"FailedRuns" json:"failedRuns"`
+	Events        int    `dynamodbav:"Events" json:"events"`
+	FailedEvents  int    `dynamodbav:"FailedEvents" json:"failedEvents"`
+	DurationMs    int64  `dynamodbav:"DurationMs" json:"durationMs"`
+	TimedRuns     int    `dynamodbav:"TimedRuns" json:"timedRuns"`
+	MaxDurationMs int64  `dynamodbav:"MaxDurationMs" json:"maxDurationMs"`
+}
+
+func (c *ComponentDay) add(o ComponentDay) {
+	c.Runs += o.Runs
+	c.FailedRuns += o.FailedRuns
+	c.Events += o.Events
+	c.FailedEvents += o.FailedEvents
+	c.DurationMs += o.DurationMs
+	c.TimedRuns += o.TimedRuns
+	if o.MaxDurationMs > c.MaxDurationMs {
+		c.MaxDurationMs = o.MaxDurationMs
+	}
+}
+
+// AvgDuration is the mean timed run, 0 when no run was timed.
+func (c ComponentDay) AvgDuration() time.Duration {
+	if c.TimedRuns == 0 {
+		return 0
+	}
+	return time.Duration(c.DurationMs/int64(c.TimedRuns)) * time.Millisecond
+}
+
+type ShopFailures struct {
+	Shop     string `dynamodbav:"Shop" json:"shop"`
+	Failures int    `dynamodbav:"Failures" json:"failures"`
+}
+
+// LoadCounters sums the counters of the UTC days [from, to], per component
+// and per shop.
+func LoadCounters(ctx context.Context, ddb *dynamodb.Client, from, to time.Time) ([]ComponentDay, []ShopFailures, error) {
+	tbl := StatusTableName()
+	if tbl == "" {
+		return nil, nil, fmt.Errorf("OPS_STATUS_TABLE not set")
+	}
+	comps := map[string]*ComponentDay{}
+	shops := map[string]int{}
+	for d := from.UTC(); !d.After(to.UTC()); d = d.AddDate(0, 0, 1) {
+		var startKey map[string]types.AttributeValue
+		for {
+			res, err := ddb.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(tbl),
+				KeyConditionExpression: aws.String("PK = :pk"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":pk": &types.AttributeValueMemberS{Value: dayPK(d)},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("query counters %s: %w", d.Format("2006-01-02"), err)
+			}
+			for _, it := range res.Items {
+				sk, _ := it["SK"].(*types.AttributeValueMemberS)
+				if sk == nil {
+					continue
+				}
+				switch {
+				case strings.HasPrefix(sk.Value, "COMPONENT#"):
+					var c ComponentDay
+					if err := attributevalue.UnmarshalMap(it, &c); err != nil {
+						continue
+					}
+					if comps[sk.Value] == nil {
+						comps[sk.Value] = &ComponentDay{Subsystem: c.Subsystem, Component: c.Component}
+					}
+					comps[sk.Value].add(c)
+				case strings.HasPrefix(sk.Value, "SHOP#"):
+					var s ShopFailures
+					if err := attributevalue.UnmarshalMap(it, &s); err == nil {
+						shops[s.Shop] += s.Failures
+					}
+				}
+			}
+			if len(res.LastEvaluatedKey) == 0 {
+				break
+			}
+			startKey = res.LastEvaluatedKey
+		}
+	}
+
+	outC := make([]ComponentDay, 0, len(comps))
+	for _, c := range comps {
+		outC = append(outC, *c)
+	}
+	sort.Slice(outC, func(i, j int) bool {
+		if outC[i].Subsystem != outC[j].Subsystem {
+			return outC[i].Subsystem < outC[j].Subsystem
+		}
+		return outC[i].Component < outC[j].Component
+	})
+	outS := make([]ShopFailures, 0, len(shops))
+	for s, n := range shops {
+		outS = append(outS, ShopFailures{Shop: s, Failures: n})
+	}
+	sort.Slice(outS, func(i, j int) bool {
+		if outS[i].Failures != outS[j].Failures {
+			return outS[i].Failures > outS[j].Failures
+		}
+		return outS[i].Shop < outS[j].Shop
+	})
+	return outC, outS, nil
+}
@@ -0,0 +1,96 @@
+package ops
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"backend/internal/notify"
+)
+
+// QueueDepth is how many messages sit in one dead-letter queue.
+type QueueDepth struct {
+	Name     string
+	Messages int
+}
+
+// WeeklyReport is the maintainers' weekly summary of platform health.
+type WeeklyReport struct {
+	From, To       time.Time
+	Status         []SubsystemStatus
+	Components     []ComponentDay
+	Shops          []ShopFailures
+	DLQs           []QueueDepth
+	BedrockCalls   int
+	BedrockCostUSD float64
+}
+
+// topShops is how many failing shops the report lists.
+const topShops = 10
+
+func pctOf(n, d int) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(d))
+}
+
+// Message renders the report as a plain-text notification.
+func (r WeeklyReport) Message() *notify.Message {
+	m := notify.New(fmt.Sprintf("TrueProfit ops report %s - %s", r.From.Format("2006-01-02"), r.To.Format("2006-01-02")))
+
+	m.Line("Status now")
+	for _, s := range r.Status {
+		line := fmt.Sprintf("  %-10s %s", s.Subsystem, s.State)
+		if s.Incident {
+			line += " (incident)"
+		}
+		m.Line(line)
+	}
+
+	m.Line("").Line("Events processed")
+	for _, c := range r.Components {
+		if c.Events == 0 {
+			continue
+		}
+		m.Line(fmt.Sprintf("  %-30s %7d events, %d failed (%s)", c.Component, c.Events, c.FailedEvents, pctOf(c.FailedEvents, c.Events)))
+	}
+
+	m.Line("").Line("Runs")
+	for _, c := range r.Components {
+		line := fmt.Sprintf("  %-30s %5d runs, %d failed (%s)", c.Component, c.Runs, c.FailedRuns, pctOf(c.FailedRuns, c.Runs))
+		if c.TimedRuns > 0 {
+			line += fmt.Sprintf(", avg %s, max %s", c.AvgDuration().Round(time.Second), (time.Duration(c.MaxDurationMs) * time.Millisecond).Round(time.Second))
+		}
+		m.Line(line)
+	}
+
+	m.Line("").Line("NLQ")
+	for _, c := range r.Components {
+		if c.Subsystem == NLQ {
+			m.Line(fmt.Sprintf("  %s: %d questions, error rate %s", c.Component, c.Runs, pctOf(c.FailedRuns, c.Runs)))
+		}
+	}
+	m.Line(fmt.Sprintf("  Bedrock: %d calls, $%.2f", r.BedrockCalls, r.BedrockCostUSD))
+
+	m.Line("").Line("Dead-letter queues")
+	for _, q := range r.DLQs {
+		depth := strconv.Itoa(q.Messages)
+		if q.Messages < 0 {
+			depth = "unavailable"
+		}
+		m.Line(fmt.Sprintf("  %-40s %s", q.Name, depth))
+	}
+
+	m.Line("").Line("Top failing shops")
+	if len(r.Shops) == 0 {
+		m.Line("  none")
+	}
+	for i, s := range r.Shops {
+		if i == topShops {
+			break
+		}
+		m.Line(fmt.Sprintf("  %-40s %d", s.Shop, s.Failures))
+	}
+	return m
+}
@@ -0,0 +1,234 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Subsystems reported by GET /status.
+const (
+	Ingestion = "ingestion"
+	Sync      = "sync"
+	NLQ       = "nlq"
+	Alerts    = "alerts"
+)
+
+var Subsystems = []string{Ingestion, Sync, NLQ, Alerts}
+
+// Subsystem states, from best to worst.
+const (
+	StateOperational = "operational"
+	StateDelayed     = "delayed"
+	StateDegraded    = "degraded"
+	StateOutage      = "outage"
+)
+
+// failureThreshold is how many consecutive failed runs of one component mark
+// its subsystem degraded.
+const failureThreshold = 3
+
+func StatusTableName() string {
+	return strings.TrimSpace(os.Getenv("OPS_STATUS_TABLE"))
+}
+
+// Beat records the outcome of one run of a component (worker invocation,
+// scheduled job, request). It is a no-op when OPS_STATUS_TABLE is not
+// configured and never fails the caller; errors are only logged.
+//
+// PK = SUBSYSTEM#<subsystem>
+// SK = COMPONENT#<component>
+func Beat(ctx context.Context, ddb *dynamodb.Client, subsystem, component string, runErr error) {
+	tbl := StatusTableName()
+	if tbl == "" {
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	in := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "SUBSYSTEM#" + subsystem},
+			"SK": &types.AttributeValueMemberS{Value: "COMPONENT#" + component},
+		},
+	}
+	if runErr == nil {
+		in.UpdateExpression = aws.String("SET Component = :c, LastSuccessAt = :now, ConsecutiveFailures = :zero")
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":c":    &types.AttributeValueMemberS{Value: component},
+			":now":  &types.AttributeValueMemberS{Value: now},
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		}
+	} else {
+		msg := runErr.Error()
+		if len(msg) > 500 {
+			msg = msg[:500]
+		}
+		in.UpdateExpression = aws.String("SET Component = :c, LastFailureAt = :now, LastError = :e ADD ConsecutiveFailures :one")
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":c":   &types.AttributeValueMemberS{Value: component},
+			":now": &types.AttributeValueMemberS{Value: now},
+			":e":   &types.AttributeValueMemberS{Value: msg},
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		}
+	}
+
+	if _, err := ddb.UpdateItem(ctx, in); err != nil {
+		fmt.Printf("ops: beat %s/%s failed: %v\n", subsystem, component, err)
+	}
+	countRun(ctx, ddb, subsystem, component, runErr)
+}
+
+// ComponentHealth mirrors one COMPONENT# item.
+type ComponentHealth struct {
+	SK                  string `dynamodbav:"SK" json:"-"`
+	Component           string `dynamodbav:"Component" json:"component"`
+	LastSuccessAt       string `dynamodbav:"LastSuccessAt,omitempty" json:"lastSuccessAt,omitempty"`
+	LastFailureAt       string `dynamodbav:"LastFailureAt,omitempty" json:"lastFailureAt,omitempty"`
+	ConsecutiveFailures int    `dynamodbav:"ConsecutiveFailures" json:"consecutiveFailures"`
+
+	// Incident fields are only set on the manual SK = INCIDENT item, which
+	// maintainers put/delete by hand to override the derived state.
+	State   string `dynamodbav:"State,omitempty" json:"-"`
+	Message string `dynamodbav:"Message,omitempty" json:"-"`
+}
+
+type SubsystemStatus struct {
+	Subsystem  string            `json:"subsystem"`
+	State      string            `json:"state"`
+	Message    string            `json:"message,omitempty"`
+	Incident   bool              `json:"incident"`
+	Components []ComponentHealth `json:"components"`
+}
+
+// staleAfter is how long a subsystem may go without any successful run before
+// it is reported delayed; 0 disables the check (on-demand subsystems).
+func staleAfter(subsystem string) time.Duration {
+	def := map[string]int{
+		Ingestion: 180,
+		Sync:      26 * 60,
+		NLQ:       0,
+		Alerts:    0,
+	}[subsystem]
+
+	env := "OPS_STATUS_STALE_MINUTES_" + strings.ToUpper(subsystem)
+	if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			def = n
+		}
+	}
+	return time.Duration(def) * time.Minute
+}
+
+var defaultMessages = map[string]map[string]string{
+	Ingestion: {
+		StateDelayed:  "Order ingestion is delayed; new orders may take longer to appear.",
+		StateDegraded: "Order ingestion is failing for some events; numbers may be incomplete.",
+	},
+	Sync: {
+		StateDelayed:  "Integration syncs are behind schedule.",
+		StateDegraded: "Some integration syncs are failing.",
+	},
+	NLQ: {
+		StateDegraded: "Ask is having trouble answering questions right now.",
+	},
+	Alerts: {
+		StateDegraded: "Alert emails may be delayed.",
+	},
+}
+
+// LoadStatus derives the state of every subsystem from its component beats
+// and any manual incident flag.
+func LoadStatus(ctx context.Context, ddb *dynamodb.Client, now time.Time) ([]SubsystemStatus, error) {
+	tbl := StatusTableName()
+	out := make([]SubsystemStatus, 0, len(Subsystems))
+
+	for _, s := range Subsystems {
+		st := SubsystemStatus{Subsystem: s, State: StateOperational, Components: []ComponentHealth{}}
+		if tbl == "" {
+			out = append(out, st)
+			continue
+		}
+
+		res, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: "SUBSYSTEM#" + s},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query status %s: %w", s, err)
+		}
+		var items []ComponentHealth
+		if err := attributevalue.UnmarshalListOfMaps(res.Items, &items); err != nil {
+			return nil, err
+		}
+
+		var incident *ComponentHealth
+		var lastSuccess time.Time
+		for i := range items {
+			it := items[i]
+			if it.SK == "INCIDENT" {
+				incident = &it
+				continue
+			}
+			st.Components = append(st.Components, it)
+			if it.ConsecutiveFailures >= failureThreshold {
+				st.State = StateDegraded
+			}
+			if t, err := time.Parse(time.RFC3339, it.LastSuccessAt); err == nil && t.After(lastSuccess) {
+				lastSuccess = t
+			}
+		}
+
+		if st.State == StateOperational && len(st.Components) > 0 {
+			if d := staleAfter(s); d > 0 && now.Sub(lastSuccess) > d {
+				st.State = StateDelayed
+			}
+		}
+		st.Message = defaultMessages[s][st.State]
+
+		if incident != nil {
+			st.Incident = true
+			if incident.State != "" {
+				st.State = incident.State
+			}
+			if incident.Message != "" {
+				st.Message = incident.Message
+			}
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// Overall is the worst state across subsystems.
+func Overall(subs []SubsystemStatus) string {
+	rank := map[string]int{StateOperational: 0, StateDelayed: 1, StateDegraded: 2, StateOutage: 3}
+	worst := StateOperational
+	for _, s := range subs {
+		if rank[s.State] > rank[worst] {
+			worst = s.State
+		}
+	}
+	return worst
+}
+
+// BatchErr turns a batch outcome into a Beat error: a batch only counts as
+// failed when every item in it failed, so one bad integration or message
+// doesn't mark a whole subsystem degraded.
+func BatchErr(total, failed int) error {
+	if total > 0 && failed >= total {
+		return fmt.Errorf("all %d items failed", total)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package orgs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// An org groups users (an agency and its clients, a holding company and its
+// brands) so the org's admins can report across every member's shops.
+// Members join with an invite code, which is their consent to share their
+// numbers with the org's admins.
+//
+// Layout of ORGS_TABLE:
+//
+//	PK = ORG#<id>,     SK = META            name, owner
+//	PK = ORG#<id>,     SK = MEMBER#<sub>    membership (role, email)
+//	PK = USER#<sub>,   SK = ORG#<id>        reverse lookup for "my orgs"
+//	PK = INVITE#<code>, SK = INVITE         pending invite (TTL ExpiresAt)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+
+	// MaxMembers bounds org-wide reports, which read every member's month.
+	MaxMembers = 50
+
+	inviteTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrNotFound      = errors.New("org not found")
+	ErrInviteInvalid = errors.New("invite is invalid or expired")
+	ErrFull          = errors.New("org has reached its member limit")
+)
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("ORGS_TABLE"))
+}
+
+type Org struct {
+	Id        string `dynamodbav:"OrgId" json:"id"`
+	Name      string `dynamodbav:"Name" json:"name"`
+	OwnerSub  string `dynamodbav:"OwnerSub" json:"-"`
+	CreatedAt string `dynamodbav:"CreatedAt" json:"createdAt"`
+}
+
+type Member struct {
+	OrgId    string `dynamodbav:"OrgId" json:"-"`
+	Sub      string `dynamodbav:"Sub" json:"sub"`
+	Email    string `dynamodbav:"Email,omitempty" json:"email,omitempty"`
+	Role     string `dynamodbav:"Role" json:"role"`
+	JoinedAt string `dynamodbav:"JoinedAt" json:"joinedAt"`
+}
+
+// Membership is one org as seen from a member.
+type Membership struct {
+	OrgId string `dynamodbav:"OrgId" json:"orgId"`
+	Name  string `dynamodbav:"Name" json:"name"`
+	Role  string `dynamodbav:"Role" json:"role"`
+}
+
+type Invite struct {
+	Code      string `json:"code"`
+	OrgId     string `json:"orgId"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+func s(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+
+func key(pk, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"PK": s(pk), "SK": s(sk)}
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func memberItems(org Org, m Member) []types.TransactWriteItem {
+	mem, _ := attributevalue.MarshalMap(m)
+	mem["PK"], mem["SK"] = s("ORG#"+org.Id), s("MEMBER#"+m.Sub)
+	rev, _ := attributevalue.MarshalMap(Membership{OrgId: org.Id, Name: org.Name, Role: m.Role})
+	rev["PK"], rev["SK"] = s("USER#"+m.Sub), s("ORG#"+org.Id)
+	return []types.TransactWriteItem{
+		{Put: &types.Put{TableName: aws.String(Table()), Item: mem}},
+		{Put: &types.Put{TableName: aws.String(Table()), Item: rev}},
+	}
+}
+
+// Create makes a new org with ownerSub as its first admin.
+func Create(ctx context.Context, ddb *dynamodb.Client, name, ownerSub, ownerEmail string) (Org, error) {
+	if Table() == "" {
+		return Org{}, fmt.Errorf("ORGS_TABLE not set")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return Org{}, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	org := Org{Id: id, Name: name, OwnerSub: ownerSub, CreatedAt: now}
+
+	meta, err := attributevalue.MarshalMap(org)
+	if err != nil {
+		return Org{}, err
+	}
+	meta["PK"], meta["SK"] = s("ORG#"+id), s("META")
+	tx := []types.TransactWriteItem{{Put: &types.Put{TableName: aws.String(Table()), Item: meta}}}
+	tx = append(tx, memberItems(org, Member{OrgId: id, Sub: ownerSub, Email: ownerEmail, Role: RoleAdmin, JoinedAt: now})...)
+
+	if _, err := ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: tx}); err != nil {
+		return Org{}, fmt.Errorf("create org: %w", err)
+	}
+	return org, nil
+}
+
+// Get returns the org, or ErrNotFound.
+func Get(ctx context.Context, ddb *dynamodb.Client, id string) (Org, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(Table()),
+		Key:       key("ORG#"+id, "META"),
+	})
+	if err != nil {
+		return Org{}, fmt.Errorf("get org: %w", err)
+	}
+	if out.Item == nil {
+		return Org{}, ErrNotFound
+	}
+	var org Org
+	err = attributevalue.UnmarshalMap(out.Item, &org)
+	return org, err
+}
+
+// Role returns sub's role in the org, or "" when they are not a member.
+func Role(ctx context.Context, ddb *dynamodb.Client, id, sub string) (string, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(Table()),
+		Key:       key("ORG#"+id, "MEMBER#"+sub),
+	})
+	if err != nil {
+		return "", fmt.Errorf("get membership: %w", err)
+	}
+	if out.Item == nil {
+		return "", nil
+	}
+	var m Member
+	if err := attributevalue.UnmarshalMap(out.Item, &m); err != nil {
+		return "", err
+	}
+	return m.Role, nil
+}
+
+func queryAll(ctx context.Context, ddb *dynamodb.Client, pk, prefix string, out any) error {
+	var (
+		items    []map[string]types.AttributeValue
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		page, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(Table()),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": s(pk),
+				":p":  s(prefix),
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return fmt.Errorf("query orgs: %w", err)
+		}
+		items = append(items, page.Items...)
+		if len(page.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = page.LastEvaluatedKey
+	}
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+// Members lists everyone in the org.
+func Members(ctx context.Context, ddb *dynamodb.Client, id string) ([]Member, error) {
+	var ms []Member
+	err := queryAll(ctx, ddb, "ORG#"+id, "MEMBER#", &ms)
+	return ms, err
+}
+
+// For lists the orgs sub belongs to.
+func For(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Membership, error) {
+	var ms []Membership
+	err := queryAll(ctx, ddb, "USER#"+sub, "ORG#", &ms)
+	return ms, err
+}
+
+// CreateInvite issues a single-use code that adds whoever redeems it as role.
+func CreateInvite(ctx context.Context, ddb *dynamodb.Client, id, role string) (Invite, error) {
+	code, err := randomHex(12)
+	if err != nil {
+		return Invite{}, err
+	}
+	exp := time.Now().UTC().Add(inviteTTL)
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(Table()),
+		Item: map[string]types.AttributeValue{
+			"PK":        s("INVITE#" + code),
+			"SK":        s("INVITE"),
+			"OrgId":     s(id),
+			"Role":      s(role),
+			"ExpiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(exp.Unix(), 10)},
+		},
+	})
+	if err != nil {
+		return Invite{}, fmt.Errorf("put invite: %w", err)
+	}
+	return Invite{Code: code, OrgId: id, ExpiresAt: exp.Format(time.RFC3339)}, nil
+}
+
+// Accept redeems code for sub. The invite is consumed in the same transaction
+// that adds the membership, so a code can't be used twice.
+func Accept(ctx context.Context, ddb *dynamodb.Client, code, sub, email string) (Org, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(Table()),
+		Key:       key("INVITE#"+code, "INVITE"),
+	})
+	if err != nil {
+		return Org{}, fmt.Errorf("get invite: %w", err)
+	}
+	if out.Item == nil {
+		return Org{}, ErrInviteInvalid
+	}
+	var inv struct {
+		OrgId     string `dynamodbav:"OrgId"`
+		Role      string `dynamodbav:"Role"`
+		ExpiresAt int64  `dynamodbav:"ExpiresAt"`
+	}
+	if err := attributevalue.UnmarshalMap(out.Item, &inv); err != nil {
+		return Org{}, err
+	}
+	// TTL deletion lags, so check expiry ourselves
+	if time.Now().Unix() > inv.ExpiresAt {
+		return Org{}, ErrInviteInvalid
+	}
+	org, err := Get(ctx, ddb, inv.OrgId)
+	if err != nil {
+		return Org{}, err
+	}
+	if role, err := Role(ctx, ddb, org.Id, sub); err != nil {
+		return Org{}, err
+	} else if role != "" {
+		return org, nil
+	}
+	members, err := Members(ctx, ddb, org.Id)
+	if err != nil {
+		return Org{}, err
+	}
+	if len(members) >= MaxMembers {
+		return Org{}, ErrFull
+	}
+
+	tx := []types.TransactWriteItem{{Delete: &types.Delete{
+		TableName:           aws.String(Table()),
+		Key:                 key("INVITE#"+code, "INVITE"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	}}}
+	m := Member{OrgId: org.Id, Sub: sub, Email: email, Role: inv.Role, JoinedAt: time.Now().UTC().Format(time.RFC3339)}
+	tx = append(tx, memberItems(org, m)...)
+	_, err = ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: tx})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return Org{}, ErrInviteInvalid
+	}
+	if err != nil {
+		return Org{}, fmt.Errorf("accept invite: %w", err)
+	}
+	return org, nil
+}
+
+// Remove drops sub from the org (a member leaving, or an admin removing them).
+func Remove(ctx context.Context, ddb *dynamodb.Client, id, sub string) error {
+	_, err := ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Delete: &types.Delete{TableName: aws.String(Table()), Key: key("ORG#"+id, "MEMBER#"+sub)}},
+			{Delete: &types.Delete{TableName: aws.String(Table()), Key: key("USER#"+sub, "ORG#"+id)}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("remove member: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"backend/internal/security"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Paged endpoints take ?limit= (and ?offset= for computed lists) and answer
+// {"items": [...], "nextToken": "..."}; an empty nextToken is the last page.
+//
+// Tokens carry a DynamoDB LastEvaluatedKey back to the client as
+//
+//	base64url(json key) "." base64url(HMAC-SHA256(json key, bound to scope and caller))
+//
+// so any key type round-trips and a client can neither forge a start key,
+// reuse another user's token, nor replay one endpoint's token on another.
+// The MAC key is derived from TOKEN_ENC_KEY_B64.
+
+var (
+	ErrBadToken  = errors.New("invalid nextToken")
+	ErrBadLimit  = errors.New("limit must be a positive whole number")
+	ErrBadOffset = errors.New("offset must be a whole number, not negative")
+)
+
+// MaxOffset bounds ?offset= on lists computed in memory.
+const MaxOffset = 10000
+
+// Policy is an endpoint's page size: Default when no limit is asked for,
+// and larger limits are clamped to Max.
+type Policy struct {
+	Default int
+	Max     int
+}
+
+// Limit parses a ?limit= value under p.
+func (p Policy) Limit(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return p.Default, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return 0, ErrBadLimit
+	}
+	if n > p.Max {
+		n = p.Max
+	}
+	return n, nil
+}
+
+// Offset parses a ?offset= value, capped at MaxOffset.
+func Offset(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, ErrBadOffset
+	}
+	if n > MaxOffset {
+		n = MaxOffset
+	}
+	return n, nil
+}
+
+// tokenAttr is one key attribute; exactly one field is set.
+type tokenAttr struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+	B []byte  `json:"B,omitempty"`
+}
+
+func tokenMAC(scope, sub string, payload []byte) ([]byte, error) {
+	key, err := security.LoadKeyFromBase64(strings.TrimSpace(os.Getenv("TOKEN_ENC_KEY_B64")))
+	if err != nil {
+		return nil, fmt.Errorf("page token key: %w", err)
+	}
+	derived := hmac.New(sha256.New, key)
+	derived.Write([]byte("page-token"))
+	mac := hmac.New(sha256.New, derived.Sum(nil))
+	mac.Write([]byte(scope))
+	mac.Write([]byte{0})
+	mac.Write([]byte(sub))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+// EncodeToken signs key for sub on the endpoint named by scope; an empty
+// key is the last page and encodes as "". Non-key attribute types are not
+// expected in a LastEvaluatedKey and are rejected.
+func EncodeToken(scope, sub string, key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	m := make(map[string]tokenAttr, len(key))
+	for k, av := range key {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberS:
+			m[k] = tokenAttr{S: &v.Value}
+		case *types.AttributeValueMemberN:
+			m[k] = tokenAttr{N: &v.Value}
+		case *types.AttributeValueMemberB:
+			m[k] = tokenAttr{B: v.Value}
+		default:
+			return "", fmt.Errorf("page token: unsupported key type for %s", k)
+		}
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	sig, err := tokenMAC(scope, sub, payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// DecodeToken verifies a ?nextToken= value for sub and scope and returns
+// the key it carries; an empty token is the first page (nil key).
+func DecodeToken(scope, sub, token string) (map[string]types.AttributeValue, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, nil
+	}
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrBadToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	want, err := tokenMAC(scope, sub, payload)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(sig, want) {
+		return nil, ErrBadToken
+	}
+
+	var m map[string]tokenAttr
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, ErrBadToken
+	}
+	key := make(map[string]types.AttributeValue, len(m))
+	for k, v := range m {
+		switch {
+		case v.S != nil:
+			key[k] = &types.AttributeValueMemberS{Value: *v.S}
+		case v.N != nil:
+			key[k] = &types.AttributeValueMemberN{Value: *v.N}
+		case v.B != nil:
+			key[k] = &types.AttributeValueMemberB{Value: v.B}
+		default:
+			return nil, ErrBadToken
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,275 @@
+package periods
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Calendar describes how a merchant cuts their reporting year.
+//
+// With an empty Pattern, periods are calendar months and the fiscal year
+// starts on the 1st of StartMonth. With a retail pattern ("445", "454",
+// "544"), the year starts on the WeekStart day nearest the 1st of StartMonth
+// and is split into 12 periods of 4/5 weeks, three per 13-week quarter; the
+// last period absorbs the 53rd week when a year has one.
+type Calendar struct {
+	StartMonth time.Month   `json:"fiscalYearStartMonth"`
+	Pattern    string       `json:"periodPattern,omitempty"`
+	WeekStart  time.Weekday `json:"weekStart,omitempty"`
+}
+
+// Period is an inclusive date range. Dates are UTC midnight.
+type Period struct {
+	Name string    `json:"name"`
+	From time.Time `json:"-"`
+	To   time.Time `json:"-"`
+}
+
+func (p Period) FromISO() string { return p.From.Format("2006-01-02") }
+func (p Period) ToISO() string   { return p.To.Format("2006-01-02") }
+
+// Contains reports whether the day of t falls in p.
+func (p Period) Contains(t time.Time) bool {
+	d := day(t)
+	return !d.Before(p.From) && !d.After(p.To)
+}
+
+var patterns = map[string][3]int{
+	"445": {4, 4, 5},
+	"454": {4, 5, 4},
+	"544": {5, 4, 4},
+}
+
+// Default is the plain calendar year.
+var Default = Calendar{StartMonth: time.January}
+
+// Validate checks a user-supplied calendar.
+func (c Calendar) Validate() error {
+	if c.StartMonth < time.January || c.StartMonth > time.December {
+		return fmt.Errorf("fiscalYearStartMonth must be 1-12")
+	}
+	if c.Pattern != "" {
+		if _, ok := patterns[c.Pattern]; !ok {
+			return fmt.Errorf("periodPattern must be 445, 454 or 544")
+		}
+	}
+	if c.WeekStart < time.Sunday || c.WeekStart > time.Saturday {
+		return fmt.Errorf("weekStart must be 0-6")
+	}
+	return nil
+}
+
+func (c Calendar) normalized() Calendar {
+	if c.StartMonth == 0 {
+		c.StartMonth = time.January
+	}
+	return c
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// yearStart is the first day of the fiscal year labelled fy. Fiscal years are
+// labelled by the calendar year they end in (FY2026 may start in 2025).
+func (c Calendar) yearStart(fy int) time.Time {
+	c = c.normalized()
+	y := fy
+	if c.StartMonth != time.January {
+		y = fy - 1
+	}
+	first := time.Date(y, c.StartMonth, 1, 0, 0, 0, 0, time.UTC)
+	if c.Pattern == "" {
+		return first
+	}
+	// nearest WeekStart to the 1st (within ±3 days)
+	diff := (int(c.WeekStart) - int(first.Weekday()) + 7) % 7
+	if diff > 3 {
+		diff -= 7
+	}
+	return first.AddDate(0, 0, diff)
+}
+
+// FiscalYear returns the label of the fiscal year containing t.
+func (c Calendar) FiscalYear(t time.Time) int {
+	d := day(t)
+	fy := d.Year()
+	if c.normalized().StartMonth != time.January {
+		fy++
+	}
+	for d.Before(c.yearStart(fy)) {
+		fy--
+	}
+	for !d.Before(c.yearStart(fy + 1)) {
+		fy++
+	}
+	return fy
+}
+
+// Year returns fiscal year fy as a period.
+func (c Calendar) Year(fy int) Period {
+	return Period{
+		Name: fmt.Sprintf("FY%d", fy),
+		From: c.yearStart(fy),
+		To:   c.yearStart(fy+1).AddDate(0, 0, -1),
+	}
+}
+
+// Periods returns the 12 periods of fiscal year fy.
+func (c Calendar) Periods(fy int) []Period {
+	start := c.yearStart(fy)
+	next := c.yearStart(fy + 1)
+	out := make([]Period, 12)
+	for i := 0; i < 12; i++ {
+		var from, to time.Time
+		if c.Pattern == "" {
+			from = start.AddDate(0, i, 0)
+			to = start.AddDate(0, i+1, -1)
+		} else {
+			weeks := 0
+			for j := 0; j < i; j++ {
+				weeks += patterns[c.Pattern][j%3]
+			}
+			from = start.AddDate(0, 0, 7*weeks)
+			to = from.AddDate(0, 0, 7*patterns[c.Pattern][i%3]-1)
+			if i == 11 {
+				to = next.AddDate(0, 0, -1)
+			}
+		}
+		out[i] = Period{Name: fmt.Sprintf("FY%d-P%02d", fy, i+1), From: from, To: to}
+	}
+	return out
+}
+
+// Quarter returns quarter q (1-4) of fiscal year fy.
+func (c Calendar) Quarter(fy, q int) Period {
+	ps := c.Periods(fy)
+	return Period{
+		Name: fmt.Sprintf("FY%d-Q%d", fy, q),
+		From: ps[(q-1)*3].From,
+		To:   ps[(q-1)*3+2].To,
+	}
+}
+
+// PeriodOf returns the period (month or retail period) containing t.
+func (c Calendar) PeriodOf(t time.Time) (Period, int) {
+	fy := c.FiscalYear(t)
+	for i, p := range c.Periods(fy) {
+		if p.Contains(t) {
+			return p, i + 1
+		}
+	}
+	return Period{}, 0
+}
+
+var (
+	fyRe      = regexp.MustCompile(`^FY(\d{4})$`)
+	fyPartRe  = regexp.MustCompile(`^FY(\d{4})-([PQ])(\d{1,2})$`)
+	calMonthR = regexp.MustCompile(`^\d{4}-\d{2}$`)
+)
+
+// Resolve turns a period spec into dates, relative to today:
+//
+//	this_period, last_period, this_quarter, last_quarter,
+//	this_year, last_year, ytd, FY2026, FY2026-Q2, FY2026-P03, 2026-01 (calendar month)
+func (c Calendar) Resolve(spec string, today time.Time) (Period, error) {
+	spec = strings.TrimSpace(spec)
+	today = day(today)
+	fy := c.FiscalYear(today)
+	cur, idx := c.PeriodOf(today)
+	q := (idx-1)/3 + 1
+
+	switch strings.ToLower(spec) {
+	case "this_period":
+		return cur, nil
+	case "last_period":
+		p, _ := c.PeriodOf(cur.From.AddDate(0, 0, -1))
+		return p, nil
+	case "this_quarter":
+		return c.Quarter(fy, q), nil
+	case "last_quarter":
+		if q == 1 {
+			return c.Quarter(fy-1, 4), nil
+		}
+		return c.Quarter(fy, q-1), nil
+	case "this_year":
+		return c.Year(fy), nil
+	case "last_year":
+		return c.Year(fy - 1), nil
+	case "ytd":
+		y := c.Year(fy)
+		return Period{Name: y.Name + "-YTD", From: y.From, To: today}, nil
+	}
+
+	up := strings.ToUpper(spec)
+	if m := fyRe.FindStringSubmatch(up); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return c.Year(n), nil
+	}
+	if m := fyPartRe.FindStringSubmatch(up); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		k, _ := strconv.Atoi(m[3])
+		if m[2] == "Q" {
+			if k < 1 || k > 4 {
+				return Period{}, fmt.Errorf("quarter must be 1-4")
+			}
+			return c.Quarter(n, k), nil
+		}
+		if k < 1 || k > 12 {
+			return Period{}, fmt.Errorf("period must be 1-12")
+		}
+		return c.Periods(n)[k-1], nil
+	}
+	if calMonthR.MatchString(spec) {
+		t, err := time.Parse("2006-01", spec)
+		if err != nil {
+			return Period{}, fmt.Errorf("invalid month %q", spec)
+		}
+		return Period{Name: spec, From: t, To: t.AddDate(0, 1, -1)}, nil
+	}
+	return Period{}, fmt.Errorf("unknown period %q", spec)
+}
+
+// Months lists the YYYY-MM calendar months p overlaps, oldest first.
+func (p Period) Months() []string {
+	var out []string
+	for m := time.Date(p.From.Year(), p.From.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(p.To); m = m.AddDate(0, 1, 0) {
+		out = append(out, m.Format("2006-01"))
+	}
+	return out
+}
+
+// PromptText is date guidance for the NLQ prompt; empty for a plain calendar year.
+func (c Calendar) PromptText(today time.Time) string {
+	if c.normalized() == Default {
+		return ""
+	}
+	fy := c.FiscalYear(today)
+	cur, idx := c.PeriodOf(today)
+	q := c.Quarter(fy, (idx-1)/3+1)
+	y := c.Year(fy)
+	kind := "calendar months"
+	if c.Pattern != "" {
+		kind = fmt.Sprintf("retail %s-%s-%s week periods", c.Pattern[:1], c.Pattern[1:2], c.Pattern[2:])
+	}
+	lines := []string{
+		fmt.Sprintf("- The user reports on a fiscal year starting in %s, split into %s.", c.StartMonth, kind),
+		fmt.Sprintf("- \"this year\"/\"this fiscal year\" = %s: dt between date '%s' and date '%s'", y.Name, y.FromISO(), y.ToISO()),
+		fmt.Sprintf("- \"this quarter\" = %s: dt between date '%s' and date '%s'", q.Name, q.FromISO(), q.ToISO()),
+	}
+	if c.Pattern != "" {
+		lines = append(lines, fmt.Sprintf("- \"this month\"/\"this period\" = %s: dt between date '%s' and date '%s'", cur.Name, cur.FromISO(), cur.ToISO()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CacheMaterial folds the calendar into NLQ cache keys.
+func (c Calendar) CacheMaterial() string {
+	if c.normalized() == Default {
+		return ""
+	}
+	return fmt.Sprintf("fy%d/%s/%d", c.StartMonth, c.Pattern, c.WeekStart)
+}
@@ -0,0 +1,230 @@
+package promos
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Promo days are days a shop ran a sale, detected from two signals:
+//
+//   - a spike in the share of orders that used a discount, against the
+//     shop's own baseline (ReasonDiscountSpike)
+//   - a time-boxed Shopify discount (ends within MaxPromoDays of starting)
+//     active that day ("discount:<title>"); evergreen codes like WELCOME10
+//     don't count
+//
+// Layout of PROMO_DAYS_TABLE (only labelled days are stored):
+//
+//	PK = SHOP#<shop>, SK = DAY#<YYYY-MM-DD>, Reasons (SS), DiscountedShare (N)
+
+const (
+	ReasonDiscountSpike = "discount_spike"
+
+	// MaxPromoDays is the longest discount window still treated as a promotion.
+	MaxPromoDays = 31
+
+	// A day needs this many orders before its discount share means anything.
+	minOrders = 5
+	// Spike: share >= max(spikeFactor * baseline, minSpikeShare).
+	spikeFactor   = 2.0
+	minSpikeShare = 0.3
+)
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("PROMO_DAYS_TABLE"))
+}
+
+// DayStats is one shop-day of orders.
+type DayStats struct {
+	Date       string // YYYY-MM-DD
+	Orders     int
+	Revenue    float64 // order totals
+	Discounted int     // orders with a discount
+	Discount   float64 // total discount amount
+}
+
+// Window is a limited-time discount, in shop-local dates (inclusive).
+type Window struct {
+	Title string
+	From  string
+	To    string
+}
+
+type Label struct {
+	Date            string   `json:"date"`
+	Reasons         []string `json:"reasons"`
+	DiscountedShare float64  `json:"discountedShare"`
+}
+
+// Detect labels the days in stats (and any day inside a window) that look
+// like promotions. stats should cover a few weeks so the baseline is stable.
+func Detect(stats []DayStats, windows []Window) []Label {
+	var shares []float64
+	for _, s := range stats {
+		if s.Orders >= minOrders {
+			shares = append(shares, float64(s.Discounted)/float64(s.Orders))
+		}
+	}
+	threshold := spikeFactor * median(shares)
+	if threshold < minSpikeShare {
+		threshold = minSpikeShare
+	}
+
+	var out []Label
+	for _, s := range stats {
+		l := Label{Date: s.Date}
+		if s.Orders > 0 {
+			l.DiscountedShare = round2(float64(s.Discounted) / float64(s.Orders))
+		}
+		if s.Orders >= minOrders && l.DiscountedShare >= threshold {
+			l.Reasons = append(l.Reasons, ReasonDiscountSpike)
+		}
+		for _, w := range windows {
+			if s.Date >= w.From && s.Date <= w.To {
+				l.Reasons = append(l.Reasons, "discount:"+w.Title)
+			}
+		}
+		if len(l.Reasons) > 0 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func median(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	c := append([]float64(nil), v...)
+	sort.Float64s(c)
+	if len(c)%2 == 1 {
+		return c[len(c)/2]
+	}
+	return (c[len(c)/2-1] + c[len(c)/2]) / 2
+}
+
+func round2(f float64) float64 {
+	return float64(int64(f*100+0.5)) / 100
+}
+
+func dayKey(shop, day string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "SHOP#" + strings.ToLower(shop)},
+		"SK": &types.AttributeValueMemberS{Value: "DAY#" + day},
+	}
+}
+
+// Save replaces the labels for days in [from, to]: labelled days are written,
+// other days in the range are cleared.
+func Save(ctx context.Context, ddb *dynamodb.Client, shop, from, to string, labels []Label) error {
+	tbl := Table()
+	if tbl == "" {
+		return fmt.Errorf("PROMO_DAYS_TABLE not set")
+	}
+	byDay := map[string]Label{}
+	for _, l := range labels {
+		byDay[l.Date] = l
+	}
+
+	start, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for d := start; d.Format("2006-01-02") <= to; d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		l, ok := byDay[day]
+		if !ok {
+			if _, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(tbl), Key: dayKey(shop, day)}); err != nil {
+				return fmt.Errorf("clear promo day %s: %w", day, err)
+			}
+			continue
+		}
+		item := dayKey(shop, day)
+		item["Reasons"] = &types.AttributeValueMemberSS{Value: uniq(l.Reasons)}
+		item["DiscountedShare"] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(l.DiscountedShare, 'f', 2, 64)}
+		item["DetectedAt"] = &types.AttributeValueMemberS{Value: now}
+		if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(tbl), Item: item}); err != nil {
+			return fmt.Errorf("put promo day %s: %w", day, err)
+		}
+	}
+	return nil
+}
+
+func uniq(in []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Load returns the labelled days of shop in [from, to], keyed by date.
+func Load(ctx context.Context, ddb *dynamodb.Client, shop, from, to string) (map[string]Label, error) {
+	tbl := Table()
+	if tbl == "" {
+		return nil, fmt.Errorf("PROMO_DAYS_TABLE not set")
+	}
+	out := map[string]Label{}
+	var startKey map[string]types.AttributeValue
+	for {
+		page, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk AND SK BETWEEN :from AND :to"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":   &types.AttributeValueMemberS{Value: "SHOP#" + strings.ToLower(shop)},
+				":from": &types.AttributeValueMemberS{Value: "DAY#" + from},
+				":to":   &types.AttributeValueMemberS{Value: "DAY#" + to},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query promo days: %w", err)
+		}
+		for _, it := range page.Items {
+			sk, _ := it["SK"].(*types.AttributeValueMemberS)
+			if sk == nil {
+				continue
+			}
+			l := Label{Date: strings.TrimPrefix(sk.Value, "DAY#")}
+			if r, ok := it["Reasons"].(*types.AttributeValueMemberSS); ok {
+				l.Reasons = r.Value
+			}
+			if n, ok := it["DiscountedShare"].(*types.AttributeValueMemberN); ok {
+				l.DiscountedShare, _ = strconv.ParseFloat(n.Value, 64)
+			}
+			out[l.Date] = l
+		}
+		if len(page.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = page.LastEvaluatedKey
+	}
+	return out, nil
+}
+
+// IsPromoDay reports whether shop has a label on day.
+func IsPromoDay(ctx context.Context, ddb *dynamodb.Client, shop, day string) (bool, error) {
+	tbl := Table()
+	if tbl == "" {
+		return false, nil
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(tbl), Key: dayKey(shop, day)})
+	if err != nil {
+		return false, fmt.Errorf("get promo day: %w", err)
+	}
+	return out.Item != nil, nil
+}
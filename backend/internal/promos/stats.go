@@ -0,0 +1,113 @@
+package promos
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/live"
+	"backend/internal/shopify"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DailyStats counts one shop's Shopify orders per local day in [from, to],
+// read from sub's transactions. Days without orders are included as zeros.
+func DailyStats(ctx context.Context, ddb *dynamodb.Client, txTable, sub, shop string, from, to time.Time) ([]DayStats, error) {
+	loc := live.Location()
+	first := from.In(loc).Format("2006-01-02")
+	last := to.In(loc).Format("2006-01-02")
+
+	byDay := map[string]*DayStats{}
+	var days []string
+	for d := from.In(loc); d.Format("2006-01-02") <= last; d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		byDay[day] = &DayStats{Date: day}
+		days = append(days, day)
+	}
+
+	// Transactions are bucketed by UTC month; widen by a month each side so
+	// local days near a month boundary are covered.
+	start := time.Date(from.UTC().Year(), from.UTC().Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	for m := start; !m.After(to.UTC().AddDate(0, 1, 0)); m = m.AddDate(0, 1, 0) {
+		var startKey map[string]types.AttributeValue
+		for {
+			page, err := ddb.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(txTable),
+				IndexName:              aws.String("GSI1"),
+				KeyConditionExpression: aws.String("GSI1PK = :pk"),
+				FilterExpression:       aws.String("Category = :c AND Shop = :s AND attribute_not_exists(ParentId) AND attribute_not_exists(DeletedAt)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, m.Format("2006-01"))},
+					":c":  &types.AttributeValueMemberS{Value: "Shopify Sales"},
+					":s":  &types.AttributeValueMemberS{Value: shop},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("query orders %s: %w", m.Format("2006-01"), err)
+			}
+			for _, it := range page.Items {
+				sk, _ := it["GSI1SK"].(*types.AttributeValueMemberS)
+				if sk == nil {
+					continue
+				}
+				at, err := time.Parse(time.RFC3339Nano, sk.Value)
+				if err != nil {
+					continue
+				}
+				day := at.In(loc).Format("2006-01-02")
+				if day < first || day > last {
+					continue
+				}
+				s := byDay[day]
+				s.Orders++
+				if n, ok := it["Amount"].(*types.AttributeValueMemberN); ok {
+					amt, _ := strconv.ParseFloat(n.Value, 64)
+					s.Revenue += amt
+				}
+				disc := 0.0
+				if n, ok := it["Discount"].(*types.AttributeValueMemberN); ok {
+					disc, _ = strconv.ParseFloat(n.Value, 64)
+				}
+				_, hasCodes := it["DiscountCodes"].(*types.AttributeValueMemberSS)
+				if disc > 0 || hasCodes {
+					s.Discounted++
+					s.Discount += disc
+				}
+			}
+			if len(page.LastEvaluatedKey) == 0 {
+				break
+			}
+			startKey = page.LastEvaluatedKey
+		}
+	}
+
+	out := make([]DayStats, 0, len(days))
+	for _, d := range days {
+		out = append(out, *byDay[d])
+	}
+	return out, nil
+}
+
+// Windows keeps the limited-time discounts (ending within MaxPromoDays of
+// starting) and converts them to local dates.
+func Windows(ds []shopify.Discount) []Window {
+	loc := live.Location()
+	var out []Window
+	for _, d := range ds {
+		if d.EndsAt.IsZero() || d.EndsAt.Sub(d.StartsAt) > MaxPromoDays*24*time.Hour {
+			continue
+		}
+		out = append(out, Window{
+			Title: strings.TrimSpace(d.Title),
+			From:  d.StartsAt.In(loc).Format("2006-01-02"),
+			To:    d.EndsAt.In(loc).Format("2006-01-02"),
+		})
+	}
+	return out
+}
@@ -0,0 +1,223 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/restate"
+	"backend/internal/shadow"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// A purge hard-deletes every transaction a user has from one source (and
+// shop), e.g. for a clean slate after disconnecting a store, together with
+// the split allocations and restatement history of those rows. Purges can
+// run to many thousands of rows, so the API only enqueues a Job on
+// TRANSACTIONS_PURGE_QUEUE_URL; the purge worker deletes page by page and
+// re-enqueues the job with StartAfter when it runs out of time.
+//
+// Rows in a closed month are kept (see handlers/transactions_close.go): a
+// close locks its rows against deletes, a purge included. Adjustments are
+// never purged; the API refuses that source and Page errors on it.
+
+type Job struct {
+	Sub         string `json:"sub"`
+	Source      string `json:"source"`
+	Shop        string `json:"shop,omitempty"`
+	RequestedAt string `json:"requestedAt"`
+	StartAfter  string `json:"startAfter,omitempty"` // SK the previous run stopped at
+	Deleted     int    `json:"deleted"`
+	Locked      int    `json:"locked"` // rows kept because their month is closed
+}
+
+// SourceAdjustment is the source of month-close adjustments, which can't
+// be purged.
+const SourceAdjustment = "adjustment"
+
+// Send queues job for the purge worker.
+func Send(ctx context.Context, job Job) error {
+	queueURL := strings.TrimSpace(os.Getenv("TRANSACTIONS_PURGE_QUEUE_URL"))
+	if queueURL == "" {
+		return fmt.Errorf("TRANSACTIONS_PURGE_QUEUE_URL not set")
+	}
+	cfg, err := shadow.LoadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(job)
+	_, err = sqs.NewFromConfig(cfg).SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(string(b)),
+	})
+	return err
+}
+
+// Page deletes the next page of up to pageSize of the job's rows (counted
+// before filtering) and returns where to continue, "" once the user's
+// partition is exhausted, with the number of transactions deleted and the
+// number kept because their month is closed.
+func Page(ctx context.Context, ddb *dynamodb.Client, table string, job Job, pageSize int32) (string, int, int, error) {
+	if job.Source == SourceAdjustment {
+		return "", 0, 0, fmt.Errorf("adjustments can't be purged")
+	}
+	closed, err := ClosedMonths(ctx, ddb, table, job.Sub)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	pk := "USER#" + job.Sub
+	filter := "#source = :source"
+	names := map[string]string{"#source": "Source", "#splitInto": "SplitInto"}
+	vals := map[string]types.AttributeValue{
+		":pk":     &types.AttributeValueMemberS{Value: pk},
+		":source": &types.AttributeValueMemberS{Value: job.Source},
+	}
+	if job.Shop != "" {
+		filter += " AND #shop = :shop"
+		names["#shop"] = "Shop"
+		vals[":shop"] = &types.AttributeValueMemberS{Value: job.Shop}
+	}
+	in := &dynamodb.QueryInput{
+		TableName:                 aws.String(table),
+		KeyConditionExpression:    aws.String("PK = :pk"),
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: vals,
+		ProjectionExpression:      aws.String("SK, GSI1PK, #splitInto"),
+		Limit:                     aws.Int32(pageSize),
+	}
+	if job.StartAfter != "" {
+		in.ExclusiveStartKey = map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pk},
+			"SK": &types.AttributeValueMemberS{Value: job.StartAfter},
+		}
+	}
+	out, err := ddb.Query(ctx, in)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("query transactions: %w", err)
+	}
+
+	rows, locked := Purgeable(out.Items, closed)
+	var keys []map[string]types.AttributeValue
+	for _, it := range rows {
+		sk, _ := it["SK"].(*types.AttributeValueMemberS)
+		if sk == nil {
+			continue
+		}
+		keys = append(keys, txKey(pk, sk.Value))
+		// Allocations of a split row (see handlers/transactions_split.go).
+		if n, ok := it["SplitInto"].(*types.AttributeValueMemberN); ok {
+			parts, _ := strconv.Atoi(n.Value)
+			for i := 1; i <= parts; i++ {
+				keys = append(keys, txKey(pk, fmt.Sprintf("SPLIT#%s#%d", sk.Value, i)))
+			}
+		}
+		history, err := restate.History(ctx, ddb, table, job.Sub, sk.Value)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		for _, e := range history {
+			keys = append(keys, restate.Key(job.Sub, e.TxSK, e.ReplacedAt))
+		}
+	}
+	for len(keys) > 0 {
+		n := min(len(keys), 25) // BatchWriteItem takes at most 25 requests.
+		if err := batchDelete(ctx, ddb, table, keys[:n]); err != nil {
+			return "", 0, 0, err
+		}
+		keys = keys[n:]
+	}
+
+	next := ""
+	if sk, ok := out.LastEvaluatedKey["SK"].(*types.AttributeValueMemberS); ok {
+		next = sk.Value
+	}
+	return next, len(rows), locked, nil
+}
+
+// ClosedMonths returns the user's closed months (YYYY-MM).
+func ClosedMonths(ctx context.Context, ddb *dynamodb.Client, table, sub string) (map[string]bool, error) {
+	closed := map[string]bool{}
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :m)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: "CLOSE#USER#" + sub},
+				":m":  &types.AttributeValueMemberS{Value: "MONTH#"},
+			},
+			ProjectionExpression: aws.String("SK"),
+			ExclusiveStartKey:    startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query month closes: %w", err)
+		}
+		for _, it := range out.Items {
+			if sk, ok := it["SK"].(*types.AttributeValueMemberS); ok {
+				closed[strings.TrimPrefix(sk.Value, "MONTH#")] = true
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return closed, nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
+
+// Purgeable drops the rows of items whose month is closed, by their GSI1PK
+// (USER#<sub>#MONTH#<YYYY-MM>), and returns the rest with the number
+// dropped.
+func Purgeable(items []map[string]types.AttributeValue, closed map[string]bool) ([]map[string]types.AttributeValue, int) {
+	var rows []map[string]types.AttributeValue
+	for _, it := range items {
+		month := ""
+		if pk, ok := it["GSI1PK"].(*types.AttributeValueMemberS); ok {
+			if i := strings.LastIndex(pk.Value, "#MONTH#"); i >= 0 {
+				month = pk.Value[i+len("#MONTH#"):]
+			}
+		}
+		if closed[month] {
+			continue
+		}
+		rows = append(rows, it)
+	}
+	return rows, len(items) - len(rows)
+}
+
+func txKey(pk, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pk},
+		"SK": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+func batchDelete(ctx context.Context, ddb *dynamodb.Client, table string, keys []map[string]types.AttributeValue) error {
+	reqs := make([]types.WriteRequest, 0, len(keys))
+	for _, k := range keys {
+		reqs = append(reqs, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: k}})
+	}
+	items := map[string][]types.WriteRequest{table: reqs}
+	for attempt := 0; len(items[table]) > 0 && attempt < 5; attempt++ {
+		out, err := ddb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: items})
+		if err != nil {
+			return fmt.Errorf("BatchWriteItem: %w", err)
+		}
+		items = out.UnprocessedItems
+		if len(items[table]) > 0 {
+			time.Sleep(time.Duration(attempt+1) * 200 * time.Millisecond)
+		}
+	}
+	if n := len(items[table]); n > 0 {
+		return fmt.Errorf("BatchWriteItem: %d unprocessed", n)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package purge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func row(sk, gsi1pk string) map[string]types.AttributeValue {
+	it := map[string]types.AttributeValue{"SK": &types.AttributeValueMemberS{Value: sk}}
+	if gsi1pk != "" {
+		it["GSI1PK"] = &types.AttributeValueMemberS{Value: gsi1pk}
+	}
+	return it
+}
+
+func TestPurgeableKeepsClosedMonths(t *testing.T) {
+	items := []map[string]types.AttributeValue{
+		row("TX#1", "USER#u#MONTH#2024-01"),
+		row("TX#2", "USER#u#MONTH#2024-02"),
+		row("TX#3", "USER#u#MONTH#2024-01"),
+		row("TX#4", ""),
+	}
+	rows, locked := Purgeable(items, map[string]bool{"2024-01": true})
+	if locked != 2 {
+		t.Fatalf("locked = %d, want 2", locked)
+	}
+	var got []string
+	for _, it := range rows {
+		got = append(got, it["SK"].(*types.AttributeValueMemberS).Value)
+	}
+	if len(got) != 2 || got[0] != "TX#2" || got[1] != "TX#4" {
+		t.Fatalf("purgeable = %v, want [TX#2 TX#4]", got)
+	}
+}
+
+func TestPurgeableNothingClosed(t *testing.T) {
+	items := []map[string]types.AttributeValue{row("TX#1", "USER#u#MONTH#2024-01")}
+	rows, locked := Purgeable(items, map[string]bool{})
+	if len(rows) != 1 || locked != 0 {
+		t.Fatalf("rows = %d, locked = %d, want 1, 0", len(rows), locked)
+	}
+}
+
+func TestPageRefusesAdjustments(t *testing.T) {
+	_, _, _, err := Page(context.Background(), nil, "tx", Job{Sub: "u", Source: SourceAdjustment}, 10)
+	if err == nil {
+		t.Fatal("Page purged adjustments")
+	}
+}
@@ -0,0 +1,127 @@
+package recharge
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiBase    = "https://api.rechargeapps.com"
+	apiVersion = "2021-11"
+)
+
+// Topics the integration subscribes to. charge/paid covers renewals whose
+// Shopify order webhook is missed or delayed; charge/refunded covers churn
+// refunds issued from Recharge.
+var Topics = []string{"charge/paid", "charge/refunded"}
+
+var httpClient = &http.Client{Timeout: 20 * time.Second}
+
+type Store struct {
+	Id              int64  `json:"id"`
+	Name            string `json:"name"`
+	Domain          string `json:"domain"`
+	MyshopifyDomain string `json:"myshopify_domain"`
+	Currency        string `json:"currency"`
+}
+
+// Charge is the subset of a Recharge charge we store.
+type Charge struct {
+	Id              int64  `json:"id"`
+	Status          string `json:"status"`
+	TotalPrice      string `json:"total_price"`
+	TotalRefunds    string `json:"total_refunds"`
+	Currency        string `json:"currency"`
+	ProcessedAt     string `json:"processed_at"`
+	UpdatedAt       string `json:"updated_at"`
+	ExternalOrderId struct {
+		Ecommerce string `json:"ecommerce"`
+	} `json:"external_order_id"`
+}
+
+func do(ctx context.Context, token, method, path string, body any, out any) error {
+	var rd io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = strings.NewReader(string(b))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiBase+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Recharge-Access-Token", token)
+	req.Header.Set("X-Recharge-Version", apiVersion)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("recharge %s %s: status %d: %s", method, path, res.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// GetStore validates the token and returns the store it belongs to.
+func GetStore(ctx context.Context, token string) (*Store, error) {
+	var out struct {
+		Store Store `json:"store"`
+	}
+	if err := do(ctx, token, "GET", "/store", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Store, nil
+}
+
+// CreateWebhook subscribes address to topic.
+func CreateWebhook(ctx context.Context, token, topic, address string) error {
+	return do(ctx, token, "POST", "/webhooks", map[string]any{
+		"address": address,
+		"topic":   topic,
+	}, nil)
+}
+
+// VerifyWebhook checks X-Recharge-Hmac-Sha256, which Recharge computes as
+// hex(sha256(clientSecret + body)).
+func VerifyWebhook(clientSecret string, body []byte, signature string) bool {
+	if clientSecret == "" || signature == "" {
+		return false
+	}
+	sum := sha256.Sum256(append([]byte(clientSecret), body...))
+	want := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(want), []byte(strings.ToLower(strings.TrimSpace(signature)))) == 1
+}
+
+func parseAmount(s string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f
+}
+
+// parseTime accepts Recharge's RFC3339 and offset-less timestamps.
+func parseTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC()
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05", s); err == nil {
+		return t.UTC()
+	}
+	return time.Now().UTC()
+}
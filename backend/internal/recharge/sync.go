@@ -0,0 +1,292 @@
+package recharge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/restate"
+	"backend/internal/security"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const Source = "recharge"
+
+// Integration mirrors the Recharge item in INTEGRATIONS_TABLE.
+// PK = USER#<sub>
+// SK = RECHARGE
+//
+// Webhook deliveries carry no user, so each integration gets a random
+// webhook key in its URL and a reverse item:
+// PK = RECHARGEKEY#<webhookKey>
+// SK = USER
+type Integration struct {
+	PK              string `dynamodbav:"PK" json:"-"`
+	SK              string `dynamodbav:"SK" json:"-"`
+	StoreId         int64  `dynamodbav:"StoreId" json:"storeId"`
+	StoreName       string `dynamodbav:"StoreName" json:"storeName"`
+	Shop            string `dynamodbav:"Shop" json:"shop"`
+	ApiTokenEnc     string `dynamodbav:"ApiTokenEnc" json:"-"`
+	ClientSecretEnc string `dynamodbav:"ClientSecretEnc" json:"-"`
+	WebhookKey      string `dynamodbav:"WebhookKey" json:"-"`
+	CreatedAt       string `dynamodbav:"CreatedAt" json:"createdAt"`
+	LastEventAt     string `dynamodbav:"LastEventAt,omitempty" json:"lastEventAt,omitempty"`
+}
+
+func (i Integration) UserSub() string {
+	return strings.TrimPrefix(i.PK, "USER#")
+}
+
+// ClientSecret decrypts the secret used to verify webhook deliveries.
+func (i Integration) ClientSecret() (string, error) {
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	return security.DecryptAESGCM(key, i.ClientSecretEnc)
+}
+
+func key(sub string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+		"SK": &types.AttributeValueMemberS{Value: "RECHARGE"},
+	}
+}
+
+func reverseKey(webhookKey string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("RECHARGEKEY#%s", webhookKey)},
+		"SK": &types.AttributeValueMemberS{Value: "USER"},
+	}
+}
+
+// Save stores a (re)connected integration, replacing any previous one for the
+// user together with its webhook key.
+func Save(ctx context.Context, ddb *dynamodb.Client, sub string, store *Store, apiToken, clientSecret, webhookKey string) (*Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	k, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	tokenEnc, err := security.EncryptAESGCM(k, apiToken)
+	if err != nil {
+		return nil, err
+	}
+	secretEnc, err := security.EncryptAESGCM(k, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if prev, err := Load(ctx, ddb, sub); err == nil && prev != nil && prev.WebhookKey != "" {
+		_, _ = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(tbl), Key: reverseKey(prev.WebhookKey)})
+	}
+
+	integ := &Integration{
+		PK:              fmt.Sprintf("USER#%s", sub),
+		SK:              "RECHARGE",
+		StoreId:         store.Id,
+		StoreName:       store.Name,
+		Shop:            strings.ToLower(store.MyshopifyDomain),
+		ApiTokenEnc:     tokenEnc,
+		ClientSecretEnc: secretEnc,
+		WebhookKey:      webhookKey,
+		CreatedAt:       time.Now().UTC().Format(time.RFC3339),
+	}
+	item, err := attributevalue.MarshalMap(integ)
+	if err != nil {
+		return nil, err
+	}
+	item["Provider"] = &types.AttributeValueMemberS{Value: Source}
+	rev := reverseKey(webhookKey)
+	rev["UserSub"] = &types.AttributeValueMemberS{Value: sub}
+
+	_, err = ddb.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{TableName: aws.String(tbl), Item: item}},
+			{Put: &types.Put{TableName: aws.String(tbl), Item: rev}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return integ, nil
+}
+
+func Load(ctx context.Context, ddb *dynamodb.Client, sub string) (*Integration, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(db.IntegrationsTableName()),
+		Key:       key(sub),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var integ Integration
+	if err := attributevalue.UnmarshalMap(out.Item, &integ); err != nil {
+		return nil, err
+	}
+	return &integ, nil
+}
+
+// Resolve finds the integration behind a webhook key.
+func Resolve(ctx context.Context, ddb *dynamodb.Client, webhookKey string) (*Integration, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(db.IntegrationsTableName()),
+		Key:       reverseKey(webhookKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := out.Item["UserSub"].(*types.AttributeValueMemberS)
+	if sub == nil || sub.Value == "" {
+		return nil, nil
+	}
+	integ, err := Load(ctx, ddb, sub.Value)
+	if err != nil || integ == nil || integ.WebhookKey != webhookKey {
+		return nil, err
+	}
+	return integ, nil
+}
+
+func Delete(ctx context.Context, ddb *dynamodb.Client, sub string) error {
+	integ, err := Load(ctx, ddb, sub)
+	if err != nil || integ == nil {
+		return err
+	}
+	tbl := db.IntegrationsTableName()
+	if _, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(tbl), Key: reverseKey(integ.WebhookKey)}); err != nil {
+		return err
+	}
+	_, err = ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(tbl), Key: key(sub)})
+	return err
+}
+
+func Touch(ctx context.Context, ddb *dynamodb.Client, sub string) {
+	_, _ = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(db.IntegrationsTableName()),
+		Key:              key(sub),
+		UpdateExpression: aws.String("SET LastEventAt = :a"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":a": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+}
+
+// WriteCharge records a paid charge as a sale. When Recharge already created
+// the Shopify order, the transaction takes that order's key, so the order
+// webhook (authoritative, and written with UpdatedAt) replaces it instead of
+// double counting; if the order is already stored nothing is written.
+func WriteCharge(ctx context.Context, ddb *dynamodb.Client, table string, integ *Integration, c Charge) (bool, error) {
+	amt := parseAmount(c.TotalPrice)
+	if amt == 0 {
+		return false, nil
+	}
+	at := parseTime(c.ProcessedAt)
+	sub := integ.UserSub()
+
+	sk := fmt.Sprintf("RECHARGE#%d#CHARGE#%d", integ.StoreId, c.Id)
+	category := "Recharge Subscription Sales"
+	if oid := strings.TrimSpace(c.ExternalOrderId.Ecommerce); oid != "" && integ.Shop != "" {
+		sk = fmt.Sprintf("SHOPIFY#%s#ORDER#%s", integ.Shop, oid)
+		category = "Shopify Sales"
+	}
+
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+		"SK":        &types.AttributeValueMemberS{Value: sk},
+		"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, at.Format("2006-01"))},
+		"GSI1SK":    &types.AttributeValueMemberS{Value: at.Format(time.RFC3339Nano)},
+		"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", amt)},
+		"Currency":  &types.AttributeValueMemberS{Value: strings.ToUpper(c.Currency)},
+		"Category":  &types.AttributeValueMemberS{Value: category},
+		"Note":      &types.AttributeValueMemberS{Value: fmt.Sprintf("Recharge charge %d", c.Id)},
+		"CreatedAt": &types.AttributeValueMemberS{Value: at.Format(time.RFC3339)},
+		"Source":    &types.AttributeValueMemberS{Value: Source},
+		"ChargeId":  &types.AttributeValueMemberS{Value: fmt.Sprintf("%d", c.Id)},
+	}
+	if integ.Shop != "" {
+		item["Shop"] = &types.AttributeValueMemberS{Value: integ.Shop}
+	}
+	restate.Stamp(item)
+	return putIfAbsent(ctx, ddb, table, item)
+}
+
+// WriteRefund records the charge's refunded total. Recharge reports the
+// cumulative total_refunds, so one item per charge is kept current. Charges
+// tied to a Shopify order are skipped: their refunds arrive as Shopify
+// refunds/create webhooks.
+func WriteRefund(ctx context.Context, ddb *dynamodb.Client, table string, integ *Integration, c Charge) (bool, error) {
+	amt := parseAmount(c.TotalRefunds)
+	if amt == 0 || (strings.TrimSpace(c.ExternalOrderId.Ecommerce) != "" && integ.Shop != "") {
+		return false, nil
+	}
+	at := parseTime(c.UpdatedAt)
+	sub := integ.UserSub()
+	updatedAt := at.Format(time.RFC3339)
+
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+		"SK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("RECHARGE#%d#REFUND#%d", integ.StoreId, c.Id)},
+		"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, at.Format("2006-01"))},
+		"GSI1SK":    &types.AttributeValueMemberS{Value: at.Format(time.RFC3339Nano)},
+		"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", -amt)},
+		"Currency":  &types.AttributeValueMemberS{Value: strings.ToUpper(c.Currency)},
+		"Category":  &types.AttributeValueMemberS{Value: "Recharge Refunds"},
+		"Note":      &types.AttributeValueMemberS{Value: fmt.Sprintf("Recharge refund for charge %d", c.Id)},
+		"CreatedAt": &types.AttributeValueMemberS{Value: updatedAt},
+		"Source":    &types.AttributeValueMemberS{Value: Source},
+		"ChargeId":  &types.AttributeValueMemberS{Value: fmt.Sprintf("%d", c.Id)},
+		"UpdatedAt": &types.AttributeValueMemberS{Value: updatedAt},
+	}
+	if integ.Shop != "" {
+		item["Shop"] = &types.AttributeValueMemberS{Value: integ.Shop}
+	}
+	restate.Stamp(item)
+
+	out, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK) OR attribute_not_exists(UpdatedAt) OR UpdatedAt < :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: updatedAt},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := restate.Record(ctx, ddb, table, out.Attributes, item); err != nil {
+		fmt.Printf("recharge: record restatement charge=%d: %v\n", c.Id, err)
+	}
+	return true, nil
+}
+
+func putIfAbsent(ctx context.Context, ddb *dynamodb.Client, table string, item map[string]types.AttributeValue) (bool, error) {
+	_, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return false, nil
+	}
+	return err == nil, err
+}
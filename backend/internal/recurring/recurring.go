@@ -0,0 +1,305 @@
+package recurring
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/restate"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Recurring expenses (rent, SaaS, salaries) are rules the scheduler turns
+// into ordinary transactions on each due date.
+//
+// Layout of RECURRING_EXPENSES_TABLE:
+//
+//	PK = USER#<sub>, SK = RULE#<id>
+//
+// Materialized transactions get SK RECUR#<id>#<YYYY-MM-DD> and Source
+// "recurring", so a re-run of the scheduler can't post the same date twice.
+
+const (
+	Weekly    = "weekly"
+	Monthly   = "monthly"
+	Quarterly = "quarterly"
+	Yearly    = "yearly"
+
+	// Source marks materialized transactions.
+	Source = "recurring"
+
+	// maxCatchUp bounds how many missed dates one run posts per rule.
+	maxCatchUp = 24
+)
+
+var Cadences = map[string]bool{Weekly: true, Monthly: true, Quarterly: true, Yearly: true}
+
+var ErrNotFound = errors.New("rule not found")
+
+func Table() string {
+	return strings.TrimSpace(os.Getenv("RECURRING_EXPENSES_TABLE"))
+}
+
+type Rule struct {
+	Id        string  `dynamodbav:"RuleId" json:"id"`
+	UserSub   string  `dynamodbav:"UserSub" json:"-"`
+	Amount    float64 `dynamodbav:"Amount" json:"amount"` // positive; posted as an expense
+	Currency  string  `dynamodbav:"Currency" json:"currency"`
+	Category  string  `dynamodbav:"Category" json:"category"`
+	Note      string  `dynamodbav:"Note,omitempty" json:"note,omitempty"`
+	Cadence   string  `dynamodbav:"Cadence" json:"cadence"`
+	StartDate string  `dynamodbav:"StartDate" json:"startDate"`       // YYYY-MM-DD, first due date
+	EndDate   string  `dynamodbav:"EndDate,omitempty" json:"endDate"` // inclusive, "" = open-ended
+	NextIndex int     `dynamodbav:"NextIndex" json:"-"`               // occurrences posted so far
+	NextDue   string  `dynamodbav:"NextDue,omitempty" json:"nextDue"` // "" once the rule has ended
+	CreatedAt string  `dynamodbav:"CreatedAt" json:"createdAt"`
+}
+
+// Validate normalizes and checks a user-supplied rule.
+func (r *Rule) Validate() error {
+	r.Currency = strings.ToUpper(strings.TrimSpace(r.Currency))
+	r.Category = strings.TrimSpace(r.Category)
+	r.Note = strings.TrimSpace(r.Note)
+	r.Cadence = strings.ToLower(strings.TrimSpace(r.Cadence))
+	r.Amount = math.Abs(r.Amount)
+	if r.Amount == 0 || r.Currency == "" || r.Category == "" {
+		return fmt.Errorf("amount, currency, category are required")
+	}
+	if !Cadences[r.Cadence] {
+		return fmt.Errorf("cadence must be weekly, monthly, quarterly or yearly")
+	}
+	start, err := time.Parse("2006-01-02", r.StartDate)
+	if err != nil {
+		return fmt.Errorf("startDate must be YYYY-MM-DD")
+	}
+	if r.EndDate != "" {
+		end, err := time.Parse("2006-01-02", r.EndDate)
+		if err != nil {
+			return fmt.Errorf("endDate must be YYYY-MM-DD")
+		}
+		if end.Before(start) {
+			return fmt.Errorf("endDate must not be before startDate")
+		}
+	}
+	return nil
+}
+
+// Occurrence returns the k-th due date (k = 0 is StartDate). Monthly-style
+// rules keep the start's day of month, clamped to short months, so a rule
+// starting Jan 31 is due Feb 28 and then Mar 31.
+func (r Rule) Occurrence(k int) time.Time {
+	start, _ := time.Parse("2006-01-02", r.StartDate)
+	months := 0
+	switch r.Cadence {
+	case Weekly:
+		return start.AddDate(0, 0, 7*k)
+	case Monthly:
+		months = k
+	case Quarterly:
+		months = 3 * k
+	case Yearly:
+		months = 12 * k
+	}
+	first := time.Date(start.Year(), start.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	day := start.Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// nextDue is the date of occurrence k, or "" when it falls after EndDate.
+func (r Rule) nextDue(k int) string {
+	d := r.Occurrence(k).Format("2006-01-02")
+	if r.EndDate != "" && d > r.EndDate {
+		return ""
+	}
+	return d
+}
+
+func ruleKey(sub, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: "RULE#" + id},
+	}
+}
+
+// Put creates or replaces a rule. Replacing keeps what was already posted:
+// scheduling resumes at the first occurrence not yet materialized.
+func Put(ctx context.Context, ddb *dynamodb.Client, sub string, r Rule) (Rule, error) {
+	if Table() == "" {
+		return r, fmt.Errorf("RECURRING_EXPENSES_TABLE not set")
+	}
+	if r.Id == "" {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return r, err
+		}
+		r.Id = hex.EncodeToString(b)
+		r.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	r.UserSub = sub
+	r.NextDue = r.nextDue(r.NextIndex)
+
+	item, err := attributevalue.MarshalMap(r)
+	if err != nil {
+		return r, err
+	}
+	for k, v := range ruleKey(sub, r.Id) {
+		item[k] = v
+	}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(Table()), Item: item}); err != nil {
+		return r, fmt.Errorf("put rule: %w", err)
+	}
+	return r, nil
+}
+
+func Get(ctx context.Context, ddb *dynamodb.Client, sub, id string) (Rule, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(Table()), Key: ruleKey(sub, id)})
+	if err != nil {
+		return Rule{}, fmt.Errorf("get rule: %w", err)
+	}
+	if out.Item == nil {
+		return Rule{}, ErrNotFound
+	}
+	var r Rule
+	err = attributevalue.UnmarshalMap(out.Item, &r)
+	return r, err
+}
+
+func List(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Rule, error) {
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(Table()),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			":p":  &types.AttributeValueMemberS{Value: "RULE#"},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query rules: %w", err)
+	}
+	rules := []Rule{}
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &rules)
+	return rules, err
+}
+
+// Delete stops a rule. Transactions it already posted stay.
+func Delete(ctx context.Context, ddb *dynamodb.Client, sub, id string) error {
+	_, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(Table()), Key: ruleKey(sub, id)})
+	return err
+}
+
+// Due scans for rules with an occurrence on or before today.
+func Due(ctx context.Context, ddb *dynamodb.Client, today string) ([]Rule, error) {
+	var (
+		rules    []Rule
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(Table()),
+			FilterExpression: aws.String("NextDue <= :today"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":today": &types.AttributeValueMemberS{Value: today},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan rules: %w", err)
+		}
+		var page []Rule
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		rules = append(rules, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return rules, nil
+}
+
+// Materialize posts every occurrence of r due on or before today into
+// txTable and advances the rule. Returns how many transactions were written.
+func Materialize(ctx context.Context, ddb *dynamodb.Client, txTable string, r Rule, today string) (int, error) {
+	k, posted := r.NextIndex, 0
+	for ; posted < maxCatchUp; k++ {
+		due := r.nextDue(k)
+		if due == "" || due > today {
+			break
+		}
+		if err := putTransaction(ctx, ddb, txTable, r, due); err != nil {
+			return posted, err
+		}
+		posted++
+	}
+	if k == r.NextIndex {
+		return 0, nil
+	}
+
+	// Conditional on NextIndex so an overlapping run can't move it backwards.
+	in := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(Table()),
+		Key:                 ruleKey(r.UserSub, r.Id),
+		ConditionExpression: aws.String("NextIndex = :prev"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prev": &types.AttributeValueMemberN{Value: fmt.Sprint(r.NextIndex)},
+			":k":    &types.AttributeValueMemberN{Value: fmt.Sprint(k)},
+		},
+	}
+	if next := r.nextDue(k); next != "" {
+		in.UpdateExpression = aws.String("SET NextIndex = :k, NextDue = :next")
+		in.ExpressionAttributeValues[":next"] = &types.AttributeValueMemberS{Value: next}
+	} else {
+		in.UpdateExpression = aws.String("SET NextIndex = :k REMOVE NextDue")
+	}
+	_, err := ddb.UpdateItem(ctx, in)
+	var cfe *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		return posted, fmt.Errorf("advance rule %s: %w", r.Id, err)
+	}
+	return posted, nil
+}
+
+func putTransaction(ctx context.Context, ddb *dynamodb.Client, txTable string, r Rule, due string) error {
+	at, _ := time.Parse("2006-01-02", due)
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: "USER#" + r.UserSub},
+		"SK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("RECUR#%s#%s", r.Id, due)},
+		"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", r.UserSub, at.Format("2006-01"))},
+		"GSI1SK":    &types.AttributeValueMemberS{Value: at.Format(time.RFC3339Nano)},
+		"UserSub":   &types.AttributeValueMemberS{Value: r.UserSub},
+		"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", -r.Amount)},
+		"Currency":  &types.AttributeValueMemberS{Value: r.Currency},
+		"Category":  &types.AttributeValueMemberS{Value: r.Category},
+		"Note":      &types.AttributeValueMemberS{Value: r.Note},
+		"CreatedAt": &types.AttributeValueMemberS{Value: at.Format(time.RFC3339)},
+		"Source":    &types.AttributeValueMemberS{Value: Source},
+		"RuleId":    &types.AttributeValueMemberS{Value: r.Id},
+	}
+	restate.Stamp(item)
+
+	_, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(txTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		return fmt.Errorf("put recurring transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package restate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Transactions are upserted in place when the source restates them (order
+// edits, late ad spend, Square/Recharge updates). To answer "what did this
+// month look like on date X", every write is stamped with RecordedAt and
+// every replaced version is kept as a history item in TRANSACTIONS_TABLE:
+//
+//	PK     = RESTATE#USER#<sub>
+//	SK     = <tx SK>#<ReplacedAt>
+//	GSI1PK = USER#<sub>#MONTH#<YYYY-MM>#RESTATE   (month of the replaced version)
+//	GSI1SK = <ReplacedAt>
+//
+// History items carry Prev* attributes only (no Amount/Shop), so scans and
+// month queries over live transactions never count them.
+//
+// Users leave history too: a soft delete keeps the deleted version (Action
+// "delete") and a revert keeps the version it replaced (Action "revert"),
+// both with ReplacedBy set to the user's sub.
+
+const (
+	ActionDelete = "delete"
+	ActionRevert = "revert"
+)
+
+// Stamp sets RecordedAt on a transaction item about to be written.
+func Stamp(item map[string]types.AttributeValue) {
+	item["RecordedAt"] = &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)}
+}
+
+// Entry is one replaced version of a transaction.
+type Entry struct {
+	TxSK           string  `dynamodbav:"TxSK"`
+	PrevAmount     float64 `dynamodbav:"PrevAmount"`
+	PrevCurrency   string  `dynamodbav:"PrevCurrency"`
+	PrevCategory   string  `dynamodbav:"PrevCategory"`
+	PrevCreatedAt  string  `dynamodbav:"PrevCreatedAt"`
+	PrevRecordedAt string  `dynamodbav:"PrevRecordedAt,omitempty"`
+	ReplacedAt     string  `dynamodbav:"ReplacedAt"`
+	Action         string  `dynamodbav:"Action,omitempty"`     // "" for a source rewrite
+	ReplacedBy     string  `dynamodbav:"ReplacedBy,omitempty"` // user sub, for user actions
+}
+
+func attrS(av types.AttributeValue) string {
+	if v, ok := av.(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func attrN(av types.AttributeValue) string {
+	if v, ok := av.(*types.AttributeValueMemberN); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// Record keeps old (PutItem's ALL_OLD attributes) when the write that replaced
+// it changed the amount, currency, category or month. First inserts
+// (old == nil) need nothing; no-op rewrites get their original RecordedAt back
+// so the row doesn't look newer than it is. Tags and receipts the user put on
+// the old row are copied onto the new one, since sources never send them.
+// A soft-deleted row stays deleted and gets no further history: its delete
+// entry already holds the version that counted.
+func Record(ctx context.Context, ddb *dynamodb.Client, table string, old, item map[string]types.AttributeValue) error {
+	if len(old) == 0 || attrN(old["Amount"]) == "" {
+		return nil
+	}
+	if err := keepUserFields(ctx, ddb, table, old, item); err != nil {
+		return err
+	}
+	if attrS(old["DeletedAt"]) != "" {
+		return nil
+	}
+	if attrN(old["Amount"]) == attrN(item["Amount"]) &&
+		attrS(old["Currency"]) == attrS(item["Currency"]) &&
+		attrS(old["Category"]) == attrS(item["Category"]) &&
+		attrS(old["GSI1PK"]) == attrS(item["GSI1PK"]) {
+		return restoreRecordedAt(ctx, ddb, table, old, item)
+	}
+
+	replacedAt := attrS(item["RecordedAt"])
+	if replacedAt == "" {
+		replacedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	av, err := HistoryItem(old, replacedAt, "", "")
+	if err != nil || av == nil {
+		return err
+	}
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: av})
+	if err != nil {
+		return fmt.Errorf("put restatement: %w", err)
+	}
+	return nil
+}
+
+// HistoryItem builds the history item keeping old, a transaction item,
+// replaced at replacedAt, for callers writing it along with the change
+// itself. It is nil when old has no amount.
+func HistoryItem(old map[string]types.AttributeValue, replacedAt, action, by string) (map[string]types.AttributeValue, error) {
+	amt, err := strconv.ParseFloat(attrN(old["Amount"]), 64)
+	if err != nil {
+		return nil, nil
+	}
+	e := Entry{
+		TxSK:           attrS(old["SK"]),
+		PrevAmount:     amt,
+		PrevCurrency:   attrS(old["Currency"]),
+		PrevCategory:   attrS(old["Category"]),
+		PrevCreatedAt:  attrS(old["CreatedAt"]),
+		PrevRecordedAt: attrS(old["RecordedAt"]),
+		ReplacedAt:     replacedAt,
+		Action:         action,
+		ReplacedBy:     by,
+	}
+	av, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return nil, err
+	}
+	av["PK"] = &types.AttributeValueMemberS{Value: "RESTATE#" + attrS(old["PK"])}
+	av["SK"] = &types.AttributeValueMemberS{Value: e.TxSK + "#" + replacedAt}
+	av["GSI1PK"] = &types.AttributeValueMemberS{Value: attrS(old["GSI1PK"]) + "#RESTATE"}
+	av["GSI1SK"] = &types.AttributeValueMemberS{Value: replacedAt}
+	return av, nil
+}
+
+func restoreRecordedAt(ctx context.Context, ddb *dynamodb.Client, table string, old, item map[string]types.AttributeValue) error {
+	in := &dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": old["PK"],
+			"SK": old["SK"],
+		},
+		// don't touch a row a concurrent writer has since replaced
+		ConditionExpression: aws.String("RecordedAt = :new"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":new": item["RecordedAt"],
+		},
+	}
+	if prev := attrS(old["RecordedAt"]); prev != "" {
+		in.UpdateExpression = aws.String("SET RecordedAt = :prev")
+		in.ExpressionAttributeValues[":prev"] = &types.AttributeValueMemberS{Value: prev}
+	} else {
+		in.UpdateExpression = aws.String("REMOVE RecordedAt")
+	}
+	_, err := ddb.UpdateItem(ctx, in)
+	var cfe *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		return fmt.Errorf("restore RecordedAt: %w", err)
+	}
+	return nil
+}
+
+// userFields are set by the user, never by a source.
+var userFields = []string{"Tags", "ReceiptKey", "SplitInto", "SplitAt", "DeletedAt"}
+
+func keepUserFields(ctx context.Context, ddb *dynamodb.Client, table string, old, item map[string]types.AttributeValue) error {
+	var sets []string
+	vals := map[string]types.AttributeValue{}
+	for i, f := range userFields {
+		if old[f] == nil || item[f] != nil {
+			continue
+		}
+		v := fmt.Sprintf(":v%d", i)
+		sets = append(sets, fmt.Sprintf("%s = if_not_exists(%s, %s)", f, f, v))
+		vals[v] = old[f]
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(table),
+		Key:                       map[string]types.AttributeValue{"PK": old["PK"], "SK": old["SK"]},
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:       aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: vals,
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cfe) {
+		return fmt.Errorf("keep user fields: %w", err)
+	}
+	return nil
+}
+
+// LoadMonth returns the replaced versions that belonged to a user's month.
+func LoadMonth(ctx context.Context, ddb *dynamodb.Client, table, sub, month string) ([]Entry, error) {
+	var (
+		entries  []Entry
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			IndexName:              aws.String("GSI1"),
+			KeyConditionExpression: aws.String("GSI1PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s#RESTATE", sub, month)},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query restatements: %w", err)
+		}
+		var page []Entry
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return entries, nil
+}
+
+// History returns the replaced versions of one of a user's transactions,
+// newest first.
+func History(ctx context.Context, ddb *dynamodb.Client, table, sub, txSK string) ([]Entry, error) {
+	var (
+		entries  []Entry
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :pref)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":   &types.AttributeValueMemberS{Value: "RESTATE#USER#" + sub},
+				":pref": &types.AttributeValueMemberS{Value: txSK + "#"},
+			},
+			ScanIndexForward:  aws.Bool(false),
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query history: %w", err)
+		}
+		var page []Entry
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		// SKs of other transactions can share the prefix up to the "#".
+		for _, e := range page {
+			if e.TxSK == txSK {
+				entries = append(entries, e)
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return entries, nil
+}
+
+// Key is the key of the history item for txSK replaced at replacedAt.
+func Key(sub, txSK, replacedAt string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "RESTATE#USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: txSK + "#" + replacedAt},
+	}
+}
+
+// Get returns one replaced version, or nil.
+func Get(ctx context.Context, ddb *dynamodb.Client, table, sub, txSK, replacedAt string) (*Entry, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key:       Key(sub, txSK, replacedAt),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get history: %w", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var e Entry
+	if err := attributevalue.UnmarshalMap(out.Item, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,245 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Running totals of the transactions table, kept by the transactions-rollup
+// stream consumer so a month or day summary is one GetItem:
+//
+// ROLLUP_TABLE
+// PK = USER#<sub>
+// SK = MONTH#<YYYY-MM> | DAY#<YYYY-MM-DD>
+// INC#<cur>, EXP#<cur>, CNT#<cur>    income, expense (positive), row count
+// CAT#<cur>#<category>               net amount per category
+//
+// Periods are the GSI1 ones (UTC), and a row counts exactly when the summary
+// queries would count it: not split, not deleted.
+
+func TableName() string {
+	return strings.TrimSpace(os.Getenv("ROLLUP_TABLE"))
+}
+
+// Covers reports whether month is complete in the rollup: ROLLUP_SINCE is
+// the first month the stream consumer saw from its start, earlier months are
+// only in the transactions table.
+func Covers(month string) bool {
+	since := strings.TrimSpace(os.Getenv("ROLLUP_SINCE"))
+	return TableName() != "" && since != "" && month >= since
+}
+
+// Totals is one currency's share of a period.
+type Totals struct {
+	Income     float64
+	Expense    float64
+	Count      int
+	ByCategory map[string]float64
+}
+
+// row is what the rollup needs of a transactions item.
+type row struct {
+	GSI1PK    string  `dynamodbav:"GSI1PK"`
+	GSI1SK    string  `dynamodbav:"GSI1SK"`
+	Amount    float64 `dynamodbav:"Amount"`
+	Currency  string  `dynamodbav:"Currency"`
+	Category  string  `dynamodbav:"Category"`
+	SplitInto int     `dynamodbav:"SplitInto"`
+	DeletedAt string  `dynamodbav:"DeletedAt"`
+}
+
+// counted decodes img and reports whether it counts toward totals, with
+// the owner and month taken from GSI1PK (USER#<sub>#MONTH#<YYYY-MM>).
+func counted(img map[string]types.AttributeValue) (r row, sub, month string, ok bool) {
+	if len(img) == 0 {
+		return r, "", "", false
+	}
+	if err := attributevalue.UnmarshalMap(img, &r); err != nil {
+		return r, "", "", false
+	}
+	rest, found := strings.CutPrefix(r.GSI1PK, "USER#")
+	if !found {
+		return r, "", "", false
+	}
+	sub, month, found = strings.Cut(rest, "#MONTH#")
+	if !found || sub == "" || len(r.GSI1SK) < 10 || r.Currency == "" {
+		return r, "", "", false
+	}
+	return r, sub, month, r.SplitInto == 0 && r.DeletedAt == ""
+}
+
+type bucketKey struct{ sub, sk string }
+
+// Apply moves one item change into the rollup: oldImg's contribution comes
+// off, newImg's goes on. token makes a retried call a no-op for DynamoDB's
+// idempotency window; the stream event ID fits.
+func Apply(ctx context.Context, ddb *dynamodb.Client, token string, oldImg, newImg map[string]types.AttributeValue) error {
+	tbl := TableName()
+	if tbl == "" {
+		return nil
+	}
+
+	deltas := map[bucketKey]map[string]float64{}
+	add := func(img map[string]types.AttributeValue, sign float64) {
+		r, sub, month, ok := counted(img)
+		if !ok {
+			return
+		}
+		for _, sk := range []string{"MONTH#" + month, "DAY#" + r.GSI1SK[:10]} {
+			k := bucketKey{sub, sk}
+			d := deltas[k]
+			if d == nil {
+				d = map[string]float64{}
+				deltas[k] = d
+			}
+			if r.Amount >= 0 {
+				d["INC#"+r.Currency] += sign * r.Amount
+			} else {
+				d["EXP#"+r.Currency] += sign * math.Abs(r.Amount)
+			}
+			d["CNT#"+r.Currency] += sign
+			d["CAT#"+r.Currency+"#"+r.Category] += sign * r.Amount
+		}
+	}
+	add(oldImg, -1)
+	add(newImg, 1)
+
+	keys := make([]bucketKey, 0, len(deltas))
+	for k := range deltas {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].sk < keys[j].sk })
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var items []types.TransactWriteItem
+	for _, k := range keys {
+		names := map[string]string{"#u": "UpdatedAt"}
+		vals := map[string]types.AttributeValue{":now": &types.AttributeValueMemberS{Value: now}}
+		var adds []string
+		attrs := make([]string, 0, len(deltas[k]))
+		for a := range deltas[k] {
+			attrs = append(attrs, a)
+		}
+		sort.Strings(attrs)
+		for i, a := range attrs {
+			v := deltas[k][a]
+			if math.Abs(v) < 1e-9 {
+				continue // e.g. a tag edit: the old and new rows cancel out
+			}
+			n, p := fmt.Sprintf("#a%d", i), fmt.Sprintf(":v%d", i)
+			names[n] = a
+			vals[p] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(v, 'f', -1, 64)}
+			adds = append(adds, n+" "+p)
+		}
+		if len(adds) == 0 {
+			continue
+		}
+		items = append(items, types.TransactWriteItem{Update: &types.Update{
+			TableName: aws.String(tbl),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: "USER#" + k.sub},
+				"SK": &types.AttributeValueMemberS{Value: k.sk},
+			},
+			UpdateExpression:          aws.String("SET #u = :now ADD " + strings.Join(adds, ", ")),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: vals,
+		}})
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	in := &dynamodb.TransactWriteItemsInput{TransactItems: items}
+	if token != "" {
+		if len(token) > 36 {
+			token = token[:36]
+		}
+		in.ClientRequestToken = aws.String(token)
+	}
+	if _, err := ddb.TransactWriteItems(ctx, in); err != nil {
+		return fmt.Errorf("rollup apply: %w", err)
+	}
+	return nil
+}
+
+// Month returns the totals of a user's YYYY-MM month by currency; currencies
+// whose rows have all gone are left out.
+func Month(ctx context.Context, ddb *dynamodb.Client, sub, month string) (map[string]*Totals, error) {
+	return get(ctx, ddb, sub, "MONTH#"+month)
+}
+
+// Day is Month for one YYYY-MM-DD day.
+func Day(ctx context.Context, ddb *dynamodb.Client, sub, day string) (map[string]*Totals, error) {
+	return get(ctx, ddb, sub, "DAY#"+day)
+}
+
+func get(ctx context.Context, ddb *dynamodb.Client, sub, sk string) (map[string]*Totals, error) {
+	tbl := TableName()
+	if tbl == "" {
+		return nil, fmt.Errorf("ROLLUP_TABLE not set")
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+			"SK": &types.AttributeValueMemberS{Value: sk},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get rollup: %w", err)
+	}
+
+	byCur := map[string]*Totals{}
+	cur := func(c string) *Totals {
+		if byCur[c] == nil {
+			byCur[c] = &Totals{ByCategory: map[string]float64{}}
+		}
+		return byCur[c]
+	}
+	for name, av := range out.Item {
+		n, ok := av.(*types.AttributeValueMemberN)
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(n.Value, 64)
+		if err != nil {
+			continue
+		}
+		kind, rest, _ := strings.Cut(name, "#")
+		switch kind {
+		case "INC":
+			cur(rest).Income = cents(v)
+		case "EXP":
+			cur(rest).Expense = cents(v)
+		case "CNT":
+			cur(rest).Count = int(math.Round(v))
+		case "CAT":
+			c, category, _ := strings.Cut(rest, "#")
+			if v = cents(v); v != 0 {
+				cur(c).ByCategory[category] = v
+			}
+		}
+	}
+	for c, t := range byCur {
+		if t.Count <= 0 {
+			delete(byCur, c)
+		}
+	}
+	return byCur, nil
+}
+
+// cents rounds away the float drift of many ADDs.
+func cents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
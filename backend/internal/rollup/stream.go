@@ -0,0 +1,54 @@
+package rollup
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// FromStreamImage converts a stream record image to the SDK's attribute
+// values, so items decode the same way they do from a GetItem.
+func FromStreamImage(img map[string]events.DynamoDBAttributeValue) map[string]types.AttributeValue {
+	if img == nil {
+		return nil
+	}
+	out := make(map[string]types.AttributeValue, len(img))
+	for k, v := range img {
+		out[k] = fromStream(v)
+	}
+	return out
+}
+
+func fromStream(v events.DynamoDBAttributeValue) types.AttributeValue {
+	switch v.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: v.String()}
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: v.Number()}
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: v.Boolean()}
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: v.Binary()}
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: v.StringSet()}
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: v.NumberSet()}
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: v.BinarySet()}
+	case events.DataTypeList:
+		l := v.List()
+		out := make([]types.AttributeValue, 0, len(l))
+		for _, e := range l {
+			out = append(out, fromStream(e))
+		}
+		return &types.AttributeValueMemberL{Value: out}
+	case events.DataTypeMap:
+		m := v.Map()
+		out := make(map[string]types.AttributeValue, len(m))
+		for k, e := range m {
+			out[k] = fromStream(e)
+		}
+		return &types.AttributeValueMemberM{Value: out}
+	default:
+		return &types.AttributeValueMemberNULL{Value: true}
+	}
+}
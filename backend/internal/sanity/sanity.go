@@ -0,0 +1,367 @@
+package sanity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Data-sanity checks over a user's whole transactions partition, run weekly
+// by the sanity-checker job and on demand. The latest report is kept in the
+// transactions table, outside the user's own partition:
+//
+// PK = SANITY#USER#<sub>
+// SK = REPORT
+//
+// Only missing GSI keys can be fixed automatically; the other findings need
+// a resync or a look from the user.
+
+const (
+	CheckCurrencyMix    = "currency_mix"    // one shop's rows in several currencies
+	CheckOrphanedRefund = "orphaned_refund" // refund whose order is not stored
+	CheckMissingGSI     = "missing_gsi"     // row invisible to monthly queries
+	CheckGapMonth       = "gap_month"       // no rows in a month between active ones
+)
+
+// maxIds caps the example ids kept per finding; Count has the full number.
+const maxIds = 50
+
+var ErrNotFound = errors.New("no sanity report yet")
+
+type Finding struct {
+	Check   string   `dynamodbav:"Check" json:"check"`
+	Subject string   `dynamodbav:"Subject,omitempty" json:"subject,omitempty"` // shop, currency, ...
+	Message string   `dynamodbav:"Message" json:"message"`
+	Count   int      `dynamodbav:"Count" json:"count"`
+	Ids     []string `dynamodbav:"Ids,omitempty" json:"ids,omitempty"`
+	Months  []string `dynamodbav:"Months,omitempty" json:"months,omitempty"`
+	Fixable bool     `dynamodbav:"Fixable" json:"fixable"`
+}
+
+// Key identifies a finding across runs, for telling new ones apart.
+func (f Finding) Key() string { return f.Check + "|" + f.Subject }
+
+type Report struct {
+	PK string `dynamodbav:"PK" json:"-"`
+	SK string `dynamodbav:"SK" json:"-"`
+
+	RanAt    string    `dynamodbav:"RanAt" json:"ranAt"`
+	Rows     int       `dynamodbav:"Rows" json:"rows"`
+	Findings []Finding `dynamodbav:"Findings" json:"findings"`
+	Fixed    int       `dynamodbav:"Fixed,omitempty" json:"fixed,omitempty"` // rows repaired by the run
+}
+
+func reportKey(sub string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "SANITY#USER#" + sub},
+		"SK": &types.AttributeValueMemberS{Value: "REPORT"},
+	}
+}
+
+// row is what the checks need of a transactions item.
+type row struct {
+	SK        string `dynamodbav:"SK"`
+	GSI1PK    string `dynamodbav:"GSI1PK"`
+	GSI1SK    string `dynamodbav:"GSI1SK"`
+	Currency  string `dynamodbav:"Currency"`
+	Shop      string `dynamodbav:"Shop"`
+	CreatedAt string `dynamodbav:"CreatedAt"`
+	OrderId   string `dynamodbav:"OrderId"`
+	OrderGid  string `dynamodbav:"OrderGid"`
+	DeletedAt string `dynamodbav:"DeletedAt"`
+}
+
+func loadRows(ctx context.Context, ddb *dynamodb.Client, txTable, sub string) ([]row, error) {
+	var (
+		rows     []row
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(txTable),
+			KeyConditionExpression:    aws.String("PK = :pk"),
+			ProjectionExpression:      aws.String("SK, GSI1PK, GSI1SK, Currency, Shop, CreatedAt, OrderId, OrderGid, DeletedAt"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":pk": &types.AttributeValueMemberS{Value: "USER#" + sub}},
+			ExclusiveStartKey:         startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query transactions: %w", err)
+		}
+		var page []row
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		rows = append(rows, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return rows, nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
+
+// Run checks sub's transactions as of now.
+func Run(ctx context.Context, ddb *dynamodb.Client, txTable, sub string, now time.Time) (Report, error) {
+	rows, err := loadRows(ctx, ddb, txTable, sub)
+	if err != nil {
+		return Report{}, err
+	}
+	r := Report{RanAt: now.UTC().Format(time.RFC3339), Rows: len(rows), Findings: []Finding{}}
+	r.Findings = append(r.Findings, currencyMix(rows)...)
+	r.Findings = append(r.Findings, orphanedRefunds(rows)...)
+	r.Findings = append(r.Findings, missingGSI(rows)...)
+	r.Findings = append(r.Findings, gapMonths(rows, now)...)
+	return r, nil
+}
+
+func addId(f *Finding, id string) {
+	f.Count++
+	if len(f.Ids) < maxIds {
+		f.Ids = append(f.Ids, id)
+	}
+}
+
+// currencyMix flags shops with rows in more than one currency; the ids are
+// those outside the shop's most used currency.
+func currencyMix(rows []row) []Finding {
+	byShop := map[string]map[string][]string{}
+	for _, t := range rows {
+		if t.Shop == "" || t.Currency == "" || t.DeletedAt != "" {
+			continue
+		}
+		if byShop[t.Shop] == nil {
+			byShop[t.Shop] = map[string][]string{}
+		}
+		byShop[t.Shop][t.Currency] = append(byShop[t.Shop][t.Currency], t.SK)
+	}
+	var out []Finding
+	for shop, byCur := range byShop {
+		if len(byCur) < 2 {
+			continue
+		}
+		curs := make([]string, 0, len(byCur))
+		for c := range byCur {
+			curs = append(curs, c)
+		}
+		sort.Slice(curs, func(i, j int) bool {
+			if len(byCur[curs[i]]) != len(byCur[curs[j]]) {
+				return len(byCur[curs[i]]) > len(byCur[curs[j]])
+			}
+			return curs[i] < curs[j]
+		})
+		f := Finding{
+			Check:   CheckCurrencyMix,
+			Subject: shop,
+			Message: fmt.Sprintf("%s has transactions in %s; most are in %s", shop, strings.Join(curs, ", "), curs[0]),
+		}
+		for _, c := range curs[1:] {
+			for _, id := range byCur[c] {
+				addId(&f, id)
+			}
+		}
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Subject < out[j].Subject })
+	return out
+}
+
+// orphanedRefunds flags refunds naming an order that isn't stored, e.g. one
+// placed before the shop's first sync. Refunds that name no order can't be
+// checked and are left out.
+func orphanedRefunds(rows []row) []Finding {
+	sks := make(map[string]bool, len(rows))
+	for _, t := range rows {
+		sks[t.SK] = true
+	}
+	byChannel := map[string]*Finding{}
+	for _, t := range rows {
+		prefix, _, ok := strings.Cut(t.SK, "#REFUND#")
+		if !ok || t.DeletedAt != "" {
+			continue
+		}
+		orderID := t.OrderId
+		if orderID == "" && t.OrderGid != "" {
+			orderID = t.OrderGid[strings.LastIndex(t.OrderGid, "/")+1:]
+		}
+		if orderID == "" || sks[prefix+"#ORDER#"+orderID] {
+			continue
+		}
+		f := byChannel[prefix]
+		if f == nil {
+			f = &Finding{Check: CheckOrphanedRefund, Subject: prefix}
+			byChannel[prefix] = f
+		}
+		addId(f, t.SK)
+	}
+	var out []Finding
+	for _, f := range byChannel {
+		f.Message = fmt.Sprintf("%d refund(s) in %s have no stored order; resync the orders they belong to", f.Count, f.Subject)
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Subject < out[j].Subject })
+	return out
+}
+
+// missingGSI flags rows without month index keys: no summary or report can
+// see them. Rows with a CreatedAt can be fixed.
+func missingGSI(rows []row) []Finding {
+	fixable := Finding{Check: CheckMissingGSI, Subject: "fixable", Fixable: true}
+	stuck := Finding{Check: CheckMissingGSI, Subject: "no_date"}
+	for _, t := range rows {
+		if t.GSI1PK != "" && t.GSI1SK != "" {
+			continue
+		}
+		if _, ok := createdAt(t); ok {
+			addId(&fixable, t.SK)
+		} else {
+			addId(&stuck, t.SK)
+		}
+	}
+	var out []Finding
+	if fixable.Count > 0 {
+		fixable.Message = fmt.Sprintf("%d transaction(s) are missing from monthly reports; they can be re-indexed from their date", fixable.Count)
+		out = append(out, fixable)
+	}
+	if stuck.Count > 0 {
+		stuck.Message = fmt.Sprintf("%d transaction(s) are missing from monthly reports and have no usable date", stuck.Count)
+		out = append(out, stuck)
+	}
+	return out
+}
+
+// gapMonths flags months without a single row between the first and the
+// last month that has some, up to the current month.
+func gapMonths(rows []row, now time.Time) []Finding {
+	active := map[string]bool{}
+	for _, t := range rows {
+		if _, month, ok := strings.Cut(t.GSI1PK, "#MONTH#"); ok && t.DeletedAt == "" {
+			active[month] = true
+		}
+	}
+	if len(active) < 2 {
+		return nil
+	}
+	months := make([]string, 0, len(active))
+	for m := range active {
+		months = append(months, m)
+	}
+	sort.Strings(months)
+	first, err := time.Parse("2006-01", months[0])
+	if err != nil {
+		return nil
+	}
+	last, current := months[len(months)-1], now.UTC().Format("2006-01")
+	if last > current {
+		last = current
+	}
+	f := Finding{Check: CheckGapMonth, Subject: "months"}
+	for m := first; m.Format("2006-01") < last; m = m.AddDate(0, 1, 0) {
+		if !active[m.Format("2006-01")] {
+			f.Months = append(f.Months, m.Format("2006-01"))
+		}
+	}
+	if len(f.Months) == 0 {
+		return nil
+	}
+	f.Count = len(f.Months)
+	f.Message = fmt.Sprintf("no transactions in %s although earlier and later months have some; a sync may have missed them", strings.Join(f.Months, ", "))
+	return []Finding{f}
+}
+
+func createdAt(t row) (time.Time, bool) {
+	at, err := time.Parse(time.RFC3339Nano, t.CreatedAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at.UTC(), true
+}
+
+// FixMissingGSI sets the month index keys of sub's rows that lack them from
+// their CreatedAt, and returns how many rows it repaired.
+func FixMissingGSI(ctx context.Context, ddb *dynamodb.Client, txTable, sub string) (int, error) {
+	rows, err := loadRows(ctx, ddb, txTable, sub)
+	if err != nil {
+		return 0, err
+	}
+	fixed := 0
+	for _, t := range rows {
+		if t.GSI1PK != "" && t.GSI1SK != "" {
+			continue
+		}
+		at, ok := createdAt(t)
+		if !ok {
+			continue
+		}
+		_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(txTable),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: "USER#" + sub},
+				"SK": &types.AttributeValueMemberS{Value: t.SK},
+			},
+			UpdateExpression:    aws.String("SET GSI1PK = :pk, GSI1SK = :sk"),
+			ConditionExpression: aws.String("attribute_exists(PK) AND (attribute_not_exists(GSI1PK) OR attribute_not_exists(GSI1SK))"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, at.Format("2006-01"))},
+				":sk": &types.AttributeValueMemberS{Value: at.Format(time.RFC3339Nano)},
+			},
+		})
+		var cfe *types.ConditionalCheckFailedException
+		if errors.As(err, &cfe) {
+			continue
+		}
+		if err != nil {
+			return fixed, fmt.Errorf("fix %s: %w", t.SK, err)
+		}
+		fixed++
+	}
+	return fixed, nil
+}
+
+func Save(ctx context.Context, ddb *dynamodb.Client, txTable, sub string, r Report) error {
+	item, err := attributevalue.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	for k, v := range reportKey(sub) {
+		item[k] = v
+	}
+	if _, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(txTable), Item: item}); err != nil {
+		return fmt.Errorf("put sanity report: %w", err)
+	}
+	return nil
+}
+
+func Latest(ctx context.Context, ddb *dynamodb.Client, txTable, sub string) (Report, error) {
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(txTable), Key: reportKey(sub)})
+	if err != nil {
+		return Report{}, fmt.Errorf("get sanity report: %w", err)
+	}
+	if out.Item == nil {
+		return Report{}, ErrNotFound
+	}
+	var r Report
+	err = attributevalue.UnmarshalMap(out.Item, &r)
+	return r, err
+}
+
+// New returns the findings of r that prev did not have.
+func New(prev, r Report) []Finding {
+	seen := map[string]bool{}
+	for _, f := range prev.Findings {
+		seen[f.Key()] = true
+	}
+	var out []Finding
+	for _, f := range r.Findings {
+		if !seen[f.Key()] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,30 @@
+package scorecard
+
+// Efficiency is the ad-spend view of one period: how much revenue each unit
+// of spend brought in, and how much it has to bring in to not lose money.
+type Efficiency struct {
+	Inputs
+	BlendedROAS   *float64 `json:"blendedRoas"`   // revenue per unit of all ad spend
+	BreakevenROAS *float64 `json:"breakevenRoas"` // ROAS at which profit is zero
+	NetMargin     *float64 `json:"netMargin"`     // profit share of revenue
+	Profitable    *bool    `json:"profitable"`    // blended at or above breakeven
+}
+
+// ComputeEfficiency derives the ROAS metrics of in. Breakeven assumes the
+// other costs and refunds scale with revenue: spend can grow until it eats
+// the pre-ad margin, so breakeven ROAS = revenue / (revenue - refunds -
+// other costs). It is nil when that margin is not positive, as no ROAS
+// breaks even then.
+func ComputeEfficiency(in Inputs) Efficiency {
+	e := Efficiency{Inputs: in}
+	e.BlendedROAS = roas(in)
+	e.NetMargin = margin(in)
+	if preAd := in.Revenue - in.Refunds - in.OtherCosts; preAd > 0 {
+		e.BreakevenROAS = ratio(in.Revenue, preAd)
+	}
+	if e.BlendedROAS != nil && e.BreakevenROAS != nil {
+		ok := *e.BlendedROAS >= *e.BreakevenROAS
+		e.Profitable = &ok
+	}
+	return e
+}
@@ -0,0 +1,139 @@
+package scorecard
+
+import (
+	"math"
+	"strings"
+)
+
+// Inputs are one shop's numbers for one month. Amounts are positive.
+type Inputs struct {
+	Month      string  `json:"month"`
+	Days       int     `json:"days"` // days covered (elapsed days for the current month)
+	Revenue    float64 `json:"revenue"`
+	Refunds    float64 `json:"refunds"`
+	AdSpend    float64 `json:"adSpend"`
+	OtherCosts float64 `json:"otherCosts"`
+	Orders     int     `json:"orders"`
+}
+
+// Profit is revenue less refunds and every cost we know about.
+func (in Inputs) Profit() float64 {
+	return in.Revenue - in.Refunds - in.AdSpend - in.OtherCosts
+}
+
+// Metric is one scored number. Value and Delta are nil when they can't be
+// computed (no revenue, no ad spend, no previous month).
+type Metric struct {
+	Value *float64 `json:"value"`
+	Delta *float64 `json:"delta"` // change vs the previous month
+	Grade string   `json:"grade"` // A-F, "" when Value is nil
+}
+
+type Scorecard struct {
+	Grade         string   `json:"grade"`
+	Margin        Metric   `json:"margin"`
+	Growth        Metric   `json:"growth"`
+	RefundRate    Metric   `json:"refundRate"`
+	AdEfficiency  Metric   `json:"adEfficiency"` // ROAS: revenue per unit of ad spend
+	AvgOrderValue *float64 `json:"avgOrderValue"`
+	Current       Inputs   `json:"current"`
+	Previous      Inputs   `json:"previous"`
+}
+
+// band grades v against thresholds for A, B, C, D (best first). With
+// higherIsBetter false, values at or below a threshold earn its grade.
+func band(v float64, higherIsBetter bool, a, b, c, d float64) string {
+	for i, t := range []float64{a, b, c, d} {
+		if (higherIsBetter && v >= t) || (!higherIsBetter && v <= t) {
+			return string(rune('A' + i))
+		}
+	}
+	return "F"
+}
+
+func ratio(n, d float64) *float64 {
+	if d == 0 {
+		return nil
+	}
+	v := round4(n / d)
+	return &v
+}
+
+func diff(cur, prev *float64) *float64 {
+	if cur == nil || prev == nil {
+		return nil
+	}
+	v := round4(*cur - *prev)
+	return &v
+}
+
+func round4(v float64) float64 { return math.Round(v*10000) / 10000 }
+
+func margin(in Inputs) *float64 { return ratio(in.Profit(), in.Revenue) }
+
+func refundRate(in Inputs) *float64 { return ratio(in.Refunds, in.Revenue) }
+
+func roas(in Inputs) *float64 { return ratio(in.Revenue, in.AdSpend) }
+
+// dailyNet is net revenue per covered day, so a partial month compares
+// fairly with a full one.
+func dailyNet(in Inputs) float64 {
+	if in.Days == 0 {
+		return 0
+	}
+	return (in.Revenue - in.Refunds) / float64(in.Days)
+}
+
+// Build scores cur against prev.
+func Build(cur, prev Inputs) Scorecard {
+	s := Scorecard{Current: cur, Previous: prev}
+
+	s.Margin = Metric{Value: margin(cur), Delta: diff(margin(cur), margin(prev))}
+	if s.Margin.Value != nil {
+		s.Margin.Grade = band(*s.Margin.Value, true, 0.25, 0.15, 0.08, 0)
+	}
+
+	if p := dailyNet(prev); p > 0 {
+		g := round4((dailyNet(cur) - p) / p)
+		s.Growth = Metric{Value: &g, Grade: band(g, true, 0.10, 0.03, -0.03, -0.10)}
+	}
+
+	s.RefundRate = Metric{Value: refundRate(cur), Delta: diff(refundRate(cur), refundRate(prev))}
+	if s.RefundRate.Value != nil {
+		s.RefundRate.Grade = band(*s.RefundRate.Value, false, 0.02, 0.05, 0.08, 0.12)
+	}
+
+	s.AdEfficiency = Metric{Value: roas(cur), Delta: diff(roas(cur), roas(prev))}
+	if s.AdEfficiency.Value != nil {
+		s.AdEfficiency.Grade = band(*s.AdEfficiency.Value, true, 4, 3, 2, 1)
+	}
+
+	if cur.Orders > 0 {
+		aov := math.Round(cur.Revenue/float64(cur.Orders)*100) / 100
+		s.AvgOrderValue = &aov
+	}
+
+	s.Grade = overall(s.Margin.Grade, s.Growth.Grade, s.RefundRate.Grade, s.AdEfficiency.Grade)
+	return s
+}
+
+// overall averages the metric grades (A=4 ... F=0), ignoring ungraded ones.
+func overall(grades ...string) string {
+	sum, n := 0, 0
+	for _, g := range grades {
+		if g == "" {
+			continue
+		}
+		p := int('E' - g[0])
+		if g == "F" {
+			p = 0
+		}
+		sum += p
+		n++
+	}
+	if n == 0 {
+		return ""
+	}
+	avg := int(math.Round(float64(sum) / float64(n)))
+	return strings.Split("F,D,C,B,A", ",")[avg]
+}
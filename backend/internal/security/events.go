@@ -0,0 +1,163 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// NLQRejection is one SQL validator rejection on the /ask path.
+type NLQRejection struct {
+	UserSub  string
+	Question string
+	SQL      string
+	Reason   string
+	Kind     string // see nlq.RejectionKind
+	Stage    string // initial | fix | feedback
+	Shops    []string
+}
+
+// RecordNLQRejection stores the rejection in SECURITY_EVENTS_TABLE and, for
+// shop allowlist violations, bumps the user's daily violation counter.
+// Returns the counter value after the bump (0 for other kinds).
+//
+// PK = USER#<sub>
+// SK = NLQREJECT#<RFC3339Nano>#<rand>          (event, 90 day TTL)
+// SK = COUNTER#SHOP_ALLOWLIST#<YYYY-MM-DD>     (daily counter, 7 day TTL)
+func RecordNLQRejection(ctx context.Context, ddb *dynamodb.Client, ev NLQRejection) (int, error) {
+	tbl := strings.TrimSpace(db.SecurityEventsTableName())
+	if tbl == "" {
+		return 0, nil
+	}
+
+	now := time.Now().UTC()
+	pk := fmt.Sprintf("USER#%s", ev.UserSub)
+
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+
+	_, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tbl),
+		Item: map[string]types.AttributeValue{
+			"PK":        &types.AttributeValueMemberS{Value: pk},
+			"SK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("NLQREJECT#%s#%s", now.Format(time.RFC3339Nano), hex.EncodeToString(b))},
+			"UserSub":   &types.AttributeValueMemberS{Value: ev.UserSub},
+			"Kind":      &types.AttributeValueMemberS{Value: ev.Kind},
+			"Stage":     &types.AttributeValueMemberS{Value: ev.Stage},
+			"Reason":    &types.AttributeValueMemberS{Value: ev.Reason},
+			"SQL":       &types.AttributeValueMemberS{Value: truncateUTF8(ev.SQL, 8000)},
+			"Question":  &types.AttributeValueMemberS{Value: truncateUTF8(ev.Question, 2000)},
+			"Shops":     &types.AttributeValueMemberS{Value: strings.Join(ev.Shops, ",")},
+			"CreatedAt": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			"ExpiresAt": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(90*24*time.Hour).Unix())},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("put security event: %w", err)
+	}
+
+	if ev.Kind != "shop_allowlist" {
+		return 0, nil
+	}
+
+	out, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pk},
+			"SK": &types.AttributeValueMemberS{Value: "COUNTER#SHOP_ALLOWLIST#" + now.Format("2006-01-02")},
+		},
+		UpdateExpression: aws.String("ADD #c :one SET ExpiresAt = :exp"),
+		ExpressionAttributeNames: map[string]string{
+			"#c": "Count",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":exp": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(7*24*time.Hour).Unix())},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("bump violation counter: %w", err)
+	}
+
+	n := 0
+	if v, ok := out.Attributes["Count"].(*types.AttributeValueMemberN); ok {
+		n, _ = strconv.Atoi(v.Value)
+	}
+	return n, nil
+}
+
+// ShopViolationAlertThreshold is how many allowlist violations per user per day
+// trigger an ops alert (and every multiple thereafter).
+func ShopViolationAlertThreshold() int {
+	if v := strings.TrimSpace(os.Getenv("NLQ_SHOP_VIOLATION_ALERT_THRESHOLD")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 3
+}
+
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// back off to a rune boundary
+	for n > 0 && (s[n]&0xC0) == 0x80 {
+		n--
+	}
+	return s[:n] + "...(truncated)"
+}
+
+// AdminAction is one operator action that touched another user's data.
+type AdminAction struct {
+	AdminSub  string
+	Action    string // e.g. integrations_export
+	TargetSub string
+	Detail    string // never secrets
+}
+
+// RecordAdminAction audits an admin action in SECURITY_EVENTS_TABLE under the
+// admin's partition.
+//
+// PK = USER#<adminSub>
+// SK = ADMIN#<RFC3339Nano>#<rand>              (event, 1 year TTL)
+func RecordAdminAction(ctx context.Context, ddb *dynamodb.Client, a AdminAction) error {
+	tbl := strings.TrimSpace(db.SecurityEventsTableName())
+	if tbl == "" {
+		return fmt.Errorf("SECURITY_EVENTS_TABLE not set")
+	}
+
+	now := time.Now().UTC()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+
+	_, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tbl),
+		Item: map[string]types.AttributeValue{
+			"PK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", a.AdminSub)},
+			"SK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("ADMIN#%s#%s", now.Format(time.RFC3339Nano), hex.EncodeToString(b))},
+			"AdminSub":  &types.AttributeValueMemberS{Value: a.AdminSub},
+			"Action":    &types.AttributeValueMemberS{Value: a.Action},
+			"TargetSub": &types.AttributeValueMemberS{Value: a.TargetSub},
+			"Detail":    &types.AttributeValueMemberS{Value: truncateUTF8(a.Detail, 2000)},
+			"CreatedAt": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			"ExpiresAt": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(365*24*time.Hour).Unix())},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("put admin audit event: %w", err)
+	}
+	return nil
+}
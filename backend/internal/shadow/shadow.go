@@ -0,0 +1,373 @@
+package shadow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"backend/internal/notify"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Shadow mode runs a new build of a worker against production traffic
+// without changing anything: with SHADOW_MODE=true, writes made through
+// clients built from LoadConfig (DynamoDB, SNS, SQS, S3) are logged instead
+// of sent and answered with an empty success. DynamoDB writes are logged
+// with a diff against the item as it is now. Reads go through, so the
+// worker sees live data.
+//
+// A shadowed worker must never take the place of the live one: a consumer
+// that logs instead of writing still acks what it reads, and the live
+// worker would lose those events. Shadow builds are deployed as their own
+// service (serverless.shadow.yml), next to a stage's live one, on copies of
+// its event sources: their own queues on the partner bus rules' events and
+// a second consumer of the transactions stream. That service's role has no
+// write actions, so a write that gets past the interceptor fails.
+//
+// Only Workers run shadowed. Each makes all its writes through LoadConfig
+// clients and has an event source that can be copied; LoadConfig refuses
+// SHADOW_MODE for any other (SHADOW_WORKER names the worker). Workers fed
+// by our own SendMessage (initial sync, webhook subscriber, purge,
+// quarantine), scheduled ones, and any that call Xero, Google Sheets or
+// Shopify are out of scope.
+//
+// A shadowed write is not evaluated: conditions always "pass" (the log line
+// carries them) and ReturnValues come back empty.
+
+// Workers are the workers that may run shadowed, by cmd name.
+var Workers = map[string]bool{
+	"shopify-orders-worker":  true,
+	"shopify-refunds-worker": true,
+	"shopify-emailer":        true,
+	"transactions-rollup":    true,
+}
+
+// Enabled reads SHADOW_MODE.
+func Enabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SHADOW_MODE"))) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// LoadConfig is config.LoadDefaultConfig, with writes intercepted when
+// shadow mode is on. Shadow mode outside Workers is an error, so the
+// worker fails rather than write for real or ack what it can't handle.
+func LoadConfig(ctx context.Context, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	if !Enabled() {
+		return config.LoadDefaultConfig(ctx, optFns...)
+	}
+	if w := strings.TrimSpace(os.Getenv("SHADOW_WORKER")); !Workers[w] {
+		return aws.Config{}, fmt.Errorf("shadow mode: worker %q cannot run shadowed", w)
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return cfg, err
+	}
+	// Built before the interceptor is added: it reads current items.
+	reader := dynamodb.NewFromConfig(cfg)
+	opts := append([]func(*middleware.Stack) error{}, cfg.APIOptions...)
+	cfg.APIOptions = append(opts, func(s *middleware.Stack) error {
+		return s.Initialize.Add(&interceptor{ddb: reader}, middleware.Before)
+	})
+	return cfg, nil
+}
+
+type interceptor struct {
+	ddb *dynamodb.Client
+}
+
+func (*interceptor) ID() string { return "ShadowWrites" }
+
+func (i *interceptor) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	var result any
+	switch p := in.Parameters.(type) {
+	case *dynamodb.PutItemInput:
+		i.logPut(ctx, p)
+		result = &dynamodb.PutItemOutput{}
+	case *dynamodb.UpdateItemInput:
+		i.logUpdate(ctx, p)
+		result = &dynamodb.UpdateItemOutput{}
+	case *dynamodb.DeleteItemInput:
+		i.logDelete(ctx, p)
+		result = &dynamodb.DeleteItemOutput{}
+	case *dynamodb.BatchWriteItemInput:
+		for table, reqs := range p.RequestItems {
+			puts, deletes := 0, 0
+			for _, r := range reqs {
+				if r.PutRequest != nil {
+					puts++
+				} else if r.DeleteRequest != nil {
+					deletes++
+				}
+			}
+			logLine(map[string]any{"op": "BatchWriteItem", "table": table, "puts": puts, "deletes": deletes})
+		}
+		result = &dynamodb.BatchWriteItemOutput{}
+	case *dynamodb.TransactWriteItemsInput:
+		for _, it := range p.TransactItems {
+			switch {
+			case it.Put != nil:
+				i.logPut(ctx, &dynamodb.PutItemInput{TableName: it.Put.TableName, Item: it.Put.Item, ConditionExpression: it.Put.ConditionExpression})
+			case it.Update != nil:
+				i.logUpdate(ctx, &dynamodb.UpdateItemInput{TableName: it.Update.TableName, Key: it.Update.Key, UpdateExpression: it.Update.UpdateExpression,
+					ConditionExpression: it.Update.ConditionExpression, ExpressionAttributeNames: it.Update.ExpressionAttributeNames, ExpressionAttributeValues: it.Update.ExpressionAttributeValues})
+			case it.Delete != nil:
+				i.logDelete(ctx, &dynamodb.DeleteItemInput{TableName: it.Delete.TableName, Key: it.Delete.Key, ConditionExpression: it.Delete.ConditionExpression})
+			}
+		}
+		result = &dynamodb.TransactWriteItemsOutput{}
+	case *sns.PublishInput:
+		logLine(map[string]any{"op": "Publish", "topic": aws.ToString(p.TopicArn), "subject": aws.ToString(p.Subject), "bytes": len(aws.ToString(p.Message))})
+		result = &sns.PublishOutput{MessageId: aws.String("shadow")}
+	case *sqs.SendMessageInput:
+		logLine(map[string]any{"op": "SendMessage", "queue": aws.ToString(p.QueueUrl), "bytes": len(aws.ToString(p.MessageBody))})
+		result = &sqs.SendMessageOutput{MessageId: aws.String("shadow")}
+	case *sqs.SendMessageBatchInput:
+		out := &sqs.SendMessageBatchOutput{}
+		for _, e := range p.Entries {
+			out.Successful = append(out.Successful, sqstypes.SendMessageBatchResultEntry{Id: e.Id, MessageId: aws.String("shadow")})
+		}
+		logLine(map[string]any{"op": "SendMessageBatch", "queue": aws.ToString(p.QueueUrl), "messages": len(p.Entries)})
+		result = out
+	case *s3.PutObjectInput:
+		logLine(map[string]any{"op": "PutObject", "bucket": aws.ToString(p.Bucket), "key": aws.ToString(p.Key)})
+		result = &s3.PutObjectOutput{}
+	default:
+		return next.HandleInitialize(ctx, in)
+	}
+	return middleware.InitializeOutput{Result: result}, middleware.Metadata{}, nil
+}
+
+// keyNames are the key attributes of this stack's tables: PK (and SK), or
+// State for OAuth state.
+var keyNames = []string{"PK", "SK", "State"}
+
+func keyOf(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	key := map[string]types.AttributeValue{}
+	for _, k := range keyNames {
+		if v, ok := item[k]; ok {
+			key[k] = v
+		}
+	}
+	return key
+}
+
+// current reads the item a write targets; nil when it doesn't exist or
+// can't be read (the error is logged with the write).
+func (i *interceptor) current(ctx context.Context, table string, key map[string]types.AttributeValue) (map[string]types.AttributeValue, string) {
+	if len(key) == 0 {
+		return nil, "no key"
+	}
+	out, err := i.ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(table), Key: key, ConsistentRead: aws.Bool(true)})
+	if err != nil {
+		return nil, err.Error()
+	}
+	return out.Item, ""
+}
+
+func (i *interceptor) logPut(ctx context.Context, p *dynamodb.PutItemInput) {
+	table := aws.ToString(p.TableName)
+	key := keyOf(p.Item)
+	cur, readErr := i.current(ctx, table, key)
+	line := map[string]any{
+		"op": "PutItem", "table": table, "key": render(key), "exists": cur != nil,
+		"condition": aws.ToString(p.ConditionExpression), "readError": readErr,
+	}
+	var added, changed, removed []string
+	for k, v := range p.Item {
+		old, ok := cur[k]
+		switch {
+		case !ok:
+			added = append(added, k)
+		case !reflect.DeepEqual(old, v):
+			changed = append(changed, fmt.Sprintf("%s: %s -> %s", k, value(old), value(v)))
+		}
+	}
+	for k := range cur {
+		if _, ok := p.Item[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	if cur != nil {
+		sort.Strings(added)
+		sort.Strings(changed)
+		sort.Strings(removed)
+		line["added"], line["changed"], line["removed"] = added, changed, removed
+	}
+	logLine(line)
+}
+
+func (i *interceptor) logUpdate(ctx context.Context, p *dynamodb.UpdateItemInput) {
+	table := aws.ToString(p.TableName)
+	cur, readErr := i.current(ctx, table, p.Key)
+	before := map[string]string{}
+	for _, a := range touched(aws.ToString(p.UpdateExpression), p.ExpressionAttributeNames) {
+		if v, ok := cur[a]; ok {
+			before[a] = value(v)
+		} else {
+			before[a] = "<absent>"
+		}
+	}
+	values := map[string]string{}
+	for k, v := range p.ExpressionAttributeValues {
+		values[k] = value(v)
+	}
+	logLine(map[string]any{
+		"op": "UpdateItem", "table": table, "key": render(p.Key), "exists": cur != nil,
+		"update": aws.ToString(p.UpdateExpression), "condition": aws.ToString(p.ConditionExpression),
+		"before": before, "values": values, "readError": readErr,
+	})
+}
+
+func (i *interceptor) logDelete(ctx context.Context, p *dynamodb.DeleteItemInput) {
+	table := aws.ToString(p.TableName)
+	cur, readErr := i.current(ctx, table, p.Key)
+	logLine(map[string]any{
+		"op": "DeleteItem", "table": table, "key": render(p.Key), "exists": cur != nil,
+		"attributes": len(cur), "condition": aws.ToString(p.ConditionExpression), "readError": readErr,
+	})
+}
+
+// touched lists the top-level attributes an update expression writes.
+func touched(expr string, names map[string]string) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(path string) {
+		path = strings.TrimSpace(path)
+		if i := strings.IndexAny(path, ".["); i >= 0 {
+			path = path[:i]
+		}
+		if n, ok := names[path]; ok {
+			path = n
+		}
+		if path != "" && !seen[path] {
+			seen[path] = true
+			out = append(out, path)
+		}
+	}
+	// Walk the clauses: SET a = ..., b = ... | REMOVE a, b | ADD a :v, ...
+	rest := expr
+	for rest != "" {
+		kw, body, next := nextClause(rest)
+		for _, part := range splitTop(body, ',') {
+			part = strings.TrimSpace(part)
+			switch kw {
+			case "SET":
+				if lhs, _, ok := strings.Cut(part, "="); ok {
+					add(lhs)
+				}
+			case "REMOVE":
+				add(part)
+			case "ADD", "DELETE":
+				add(strings.Fields(part + " ")[0])
+			}
+		}
+		rest = next
+	}
+	return out
+}
+
+// nextClause splits off the first clause of an update expression.
+func nextClause(expr string) (kw, body, rest string) {
+	expr = strings.TrimSpace(expr)
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return "", "", ""
+	}
+	kw = strings.ToUpper(fields[0])
+	body = strings.TrimSpace(expr[len(fields[0]):])
+	depth := 0
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ' ':
+			if depth == 0 {
+				word := strings.ToUpper(strings.Fields(body[i:] + " ")[0])
+				if word == "SET" || word == "REMOVE" || word == "ADD" || word == "DELETE" {
+					return kw, body[:i], body[i:]
+				}
+			}
+		}
+	}
+	return kw, body, ""
+}
+
+// splitTop splits s on sep outside parentheses.
+func splitTop(s string, sep byte) []string {
+	var out []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, s[start:])
+}
+
+func render(item map[string]types.AttributeValue) map[string]string {
+	out := make(map[string]string, len(item))
+	for k, v := range item {
+		out[k] = value(v)
+	}
+	return out
+}
+
+// value renders an attribute compactly: scalars as text, collections by
+// type and size.
+func value(v types.AttributeValue) string {
+	var s string
+	switch t := v.(type) {
+	case *types.AttributeValueMemberS:
+		s = t.Value
+	case *types.AttributeValueMemberN:
+		s = t.Value
+	case *types.AttributeValueMemberBOOL:
+		s = fmt.Sprint(t.Value)
+	case *types.AttributeValueMemberNULL:
+		s = "null"
+	case *types.AttributeValueMemberL:
+		s = fmt.Sprintf("<list %d>", len(t.Value))
+	case *types.AttributeValueMemberM:
+		s = fmt.Sprintf("<map %d>", len(t.Value))
+	case *types.AttributeValueMemberSS:
+		s = fmt.Sprintf("<string set %d>", len(t.Value))
+	case *types.AttributeValueMemberNS:
+		s = fmt.Sprintf("<number set %d>", len(t.Value))
+	case *types.AttributeValueMemberB:
+		s = fmt.Sprintf("<binary %d>", len(t.Value))
+	default:
+		s = "<?>"
+	}
+	return notify.Truncate(s, 80)
+}
+
+func logLine(fields map[string]any) {
+	b, _ := json.Marshal(fields)
+	fmt.Printf("shadow: %s\n", b)
+}
@@ -0,0 +1,27 @@
+package shadow
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoadConfigRefusesUnlistedWorker(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("SHADOW_MODE", "true")
+
+	for _, w := range []string{"", "shopify-initial-sync", "sanity-checker"} {
+		t.Setenv("SHADOW_WORKER", w)
+		if _, err := LoadConfig(context.Background()); err == nil {
+			t.Errorf("SHADOW_WORKER=%q: shadow mode allowed", w)
+		}
+	}
+
+	t.Setenv("SHADOW_WORKER", "shopify-orders-worker")
+	cfg, err := LoadConfig(context.Background())
+	if err != nil {
+		t.Fatalf("listed worker: %v", err)
+	}
+	if len(cfg.APIOptions) == 0 {
+		t.Fatal("listed worker: writes not intercepted")
+	}
+}
@@ -0,0 +1,88 @@
+package shopify
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Budget paces background Shopify traffic across all shops in one run: a
+// global cap on GraphQL cost points and a minimum gap between any two
+// requests, on top of each shop's own leaky bucket (see ShopBackoff).
+type Budget struct {
+	MaxCost     float64
+	MinInterval time.Duration
+
+	spent float64
+	last  time.Time
+}
+
+// NewBudgetFromEnv reads SHOPIFY_BACKFILL_MAX_COST (default 200000 points)
+// and SHOPIFY_BACKFILL_RPS (default 4 requests/second across all shops).
+func NewBudgetFromEnv() *Budget {
+	maxCost := 200000.0
+	if v := strings.TrimSpace(os.Getenv("SHOPIFY_BACKFILL_MAX_COST")); v != "" {
+		if n, err := strconv.ParseFloat(v, 64); err == nil && n > 0 {
+			maxCost = n
+		}
+	}
+	rps := 4.0
+	if v := strings.TrimSpace(os.Getenv("SHOPIFY_BACKFILL_RPS")); v != "" {
+		if n, err := strconv.ParseFloat(v, 64); err == nil && n > 0 {
+			rps = n
+		}
+	}
+	return &Budget{MaxCost: maxCost, MinInterval: time.Duration(float64(time.Second) / rps)}
+}
+
+// Wait blocks until the next request may go out.
+func (b *Budget) Wait(ctx context.Context) error {
+	if d := time.Until(b.last.Add(b.MinInterval)); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	b.last = time.Now()
+	return nil
+}
+
+// Spend records the cost of a completed request.
+func (b *Budget) Spend(c *QueryCost) {
+	if c == nil {
+		return
+	}
+	cost := c.ActualQueryCost
+	if cost == 0 {
+		cost = c.RequestedQueryCost
+	}
+	b.spent += cost
+}
+
+func (b *Budget) Spent() float64 {
+	return b.spent
+}
+
+func (b *Budget) Exhausted() bool {
+	return b.spent >= b.MaxCost
+}
+
+// ShopBackoff is how long to leave a shop alone so its bucket refills enough
+// for another request of the same cost, keeping half the bucket free for the
+// merchant's other apps.
+func ShopBackoff(c *QueryCost) time.Duration {
+	if c == nil || c.ThrottleStatus.RestoreRate <= 0 {
+		return 0
+	}
+	need := c.RequestedQueryCost + c.ThrottleStatus.MaximumAvailable/2
+	missing := need - c.ThrottleStatus.CurrentlyAvailable
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / c.ThrottleStatus.RestoreRate * float64(time.Second))
+}
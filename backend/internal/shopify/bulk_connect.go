@@ -0,0 +1,148 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Bulk connect lets one user (typically an agency) queue OAuth for many
+// client stores and walk through them one after another.
+//
+// PK = USER#<sub>
+// SK = BULKCONNECT#<batchId>
+
+const (
+	BulkPending    = "pending"
+	BulkInProgress = "in_progress"
+	BulkConnected  = "connected"
+	BulkFailed     = "failed"
+	BulkSkipped    = "skipped"
+)
+
+type BulkShop struct {
+	Shop      string `dynamodbav:"Shop" json:"shop"`
+	Status    string `dynamodbav:"Status" json:"status"`
+	Error     string `dynamodbav:"Error,omitempty" json:"error,omitempty"`
+	UpdatedAt string `dynamodbav:"UpdatedAt" json:"updatedAt"`
+}
+
+type BulkConnectBatch struct {
+	PK        string     `dynamodbav:"PK" json:"-"`
+	SK        string     `dynamodbav:"SK" json:"-"`
+	BatchId   string     `dynamodbav:"BatchId" json:"batchId"`
+	CreatedAt string     `dynamodbav:"CreatedAt" json:"createdAt"`
+	UpdatedAt string     `dynamodbav:"UpdatedAt" json:"updatedAt"`
+	Shops     []BulkShop `dynamodbav:"Shops" json:"shops"`
+}
+
+func bulkKey(userSub, batchID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", userSub)},
+		"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("BULKCONNECT#%s", batchID)},
+	}
+}
+
+func NewBulkConnectBatch(userSub, batchID string, shops []string) *BulkConnectBatch {
+	now := time.Now().UTC().Format(time.RFC3339)
+	b := &BulkConnectBatch{
+		PK:        fmt.Sprintf("USER#%s", userSub),
+		SK:        fmt.Sprintf("BULKCONNECT#%s", batchID),
+		BatchId:   batchID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, s := range shops {
+		b.Shops = append(b.Shops, BulkShop{Shop: s, Status: BulkPending, UpdatedAt: now})
+	}
+	return b
+}
+
+func LoadBulkConnectBatch(ctx context.Context, ddb *dynamodb.Client, userSub, batchID string) (*BulkConnectBatch, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tbl),
+		Key:            bulkKey(userSub, batchID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var b BulkConnectBatch
+	if err := attributevalue.UnmarshalMap(out.Item, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func SaveBulkConnectBatch(ctx context.Context, ddb *dynamodb.Client, b *BulkConnectBatch) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	b.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	item, err := attributevalue.MarshalMap(b)
+	if err != nil {
+		return err
+	}
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tbl),
+		Item:      item,
+	})
+	return err
+}
+
+// SetStatus updates one shop in the batch; returns false when the shop is not part of it.
+func (b *BulkConnectBatch) SetStatus(shop, status, errMsg string) bool {
+	for i := range b.Shops {
+		if b.Shops[i].Shop == shop {
+			b.Shops[i].Status = status
+			b.Shops[i].Error = errMsg
+			b.Shops[i].UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+			return true
+		}
+	}
+	return false
+}
+
+// NextPending returns the first shop still waiting for OAuth, or "".
+func (b *BulkConnectBatch) NextPending() string {
+	for _, s := range b.Shops {
+		if s.Status == BulkPending {
+			return s.Shop
+		}
+	}
+	return ""
+}
+
+// InProgress returns the shop whose OAuth was started last, or "".
+func (b *BulkConnectBatch) InProgress() string {
+	for _, s := range b.Shops {
+		if s.Status == BulkInProgress {
+			return s.Shop
+		}
+	}
+	return ""
+}
+
+func (b *BulkConnectBatch) Counts() map[string]int {
+	out := map[string]int{}
+	for _, s := range b.Shops {
+		out[s.Status]++
+	}
+	return out
+}
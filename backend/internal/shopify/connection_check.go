@@ -0,0 +1,193 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// RequiredWebhookTopics are the GraphQL enum names of the topics
+// SubscribeEventBridgeTopics creates.
+var RequiredWebhookTopics = []string{"ORDERS_CREATE", "ORDERS_UPDATED", "REFUNDS_CREATE"}
+
+// CheckItem is one step of a connection test with a user-facing fix.
+type CheckItem struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+	Action string `json:"action,omitempty"`
+}
+
+type ConnectionCheck struct {
+	Shop          string      `json:"shop"`
+	OK            bool        `json:"ok"`
+	ShopName      string      `json:"shopName,omitempty"`
+	Currency      string      `json:"currency,omitempty"`
+	Plan          string      `json:"plan,omitempty"`
+	Checks        []CheckItem `json:"checks"`
+	LastCheckedAt string      `json:"lastCheckedAt"`
+}
+
+type connectionCheckData struct {
+	Shop struct {
+		Name         string `json:"name"`
+		CurrencyCode string `json:"currencyCode"`
+		Plan         struct {
+			DisplayName string `json:"displayName"`
+		} `json:"plan"`
+	} `json:"shop"`
+	AppInstallation struct {
+		AccessScopes []struct {
+			Handle string `json:"handle"`
+		} `json:"accessScopes"`
+	} `json:"currentAppInstallation"`
+	WebhookSubscriptions struct {
+		Edges []struct {
+			Node struct {
+				Topic    string `json:"topic"`
+				Endpoint struct {
+					Typename string `json:"__typename"`
+					Arn      string `json:"arn"`
+				} `json:"endpoint"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"webhookSubscriptions"`
+}
+
+const connectionCheckQuery = `
+query ConnectionCheck {
+  shop { name currencyCode plan { displayName } }
+  currentAppInstallation { accessScopes { handle } }
+  webhookSubscriptions(first: 50) {
+    edges {
+      node {
+        topic
+        endpoint {
+          __typename
+          ... on WebhookEventBridgeEndpoint { arn }
+        }
+      }
+    }
+  }
+}`
+
+// CheckConnection runs a lightweight GraphQL query with the stored token and
+// verifies scopes and webhook subscriptions. It never returns an error for
+// shop-side problems; those are reported as failed checks.
+func CheckConnection(ctx context.Context, shopDomain, apiVersion, accessToken, eventSourceArn string) *ConnectionCheck {
+	res := &ConnectionCheck{Shop: shopDomain}
+
+	resp, status, err := PostGraphQL[connectionCheckData](ctx, shopDomain, apiVersion, accessToken, connectionCheckQuery, map[string]any{})
+	api := CheckItem{Name: "api_access", OK: true}
+	switch {
+	case err != nil && status == 0:
+		api = CheckItem{Name: "api_access", Reason: fmt.Sprintf("could not reach Shopify: %v", err), Action: "Check the shop domain and try again in a few minutes."}
+	case status == 401 || status == 403:
+		api = CheckItem{Name: "api_access", Reason: "Shopify rejected the stored access token (app uninstalled or token revoked).", Action: "Reconnect the shop."}
+	case status == 402:
+		api = CheckItem{Name: "api_access", Reason: "The shop is frozen for unpaid Shopify bills.", Action: "Settle the Shopify bill, then test again."}
+	case status == 404:
+		api = CheckItem{Name: "api_access", Reason: "Shopify does not know this shop domain.", Action: "Check whether the store was closed or renamed, then reconnect."}
+	case status == 423:
+		api = CheckItem{Name: "api_access", Reason: "The shop is locked by Shopify.", Action: "Contact Shopify support to unlock the store."}
+	case status == 429:
+		api = CheckItem{Name: "api_access", Reason: "Shopify rate limited the request.", Action: "Wait a minute and test again."}
+	case status >= 500:
+		api = CheckItem{Name: "api_access", Reason: fmt.Sprintf("Shopify returned http %d.", status), Action: "Shopify may be having an outage; test again later."}
+	case err != nil:
+		api = CheckItem{Name: "api_access", Reason: fmt.Sprintf("unexpected Shopify response (http %d): %v", status, err), Action: "Test again; reconnect the shop if it keeps failing."}
+	case resp != nil && len(resp.Errors) > 0:
+		api = CheckItem{Name: "api_access", Reason: "Shopify GraphQL error: " + resp.Errors[0].Message, Action: "Reconnect the shop to refresh its permissions."}
+	}
+	res.Checks = append(res.Checks, api)
+	if !api.OK {
+		return res
+	}
+
+	d := resp.Data
+	res.ShopName = d.Shop.Name
+	res.Currency = d.Shop.CurrencyCode
+	res.Plan = d.Shop.Plan.DisplayName
+
+	scope := CheckItem{Name: "scopes", OK: false, Reason: "The app is missing the read_orders permission.", Action: "Reconnect the shop and approve order access."}
+	for _, s := range d.AppInstallation.AccessScopes {
+		if s.Handle == "read_orders" || s.Handle == "write_orders" {
+			scope = CheckItem{Name: "scopes", OK: true}
+			break
+		}
+	}
+	res.Checks = append(res.Checks, scope)
+
+	have := map[string]string{}
+	for _, e := range d.WebhookSubscriptions.Edges {
+		have[e.Node.Topic] = e.Node.Endpoint.Arn
+	}
+	for _, t := range RequiredWebhookTopics {
+		name := "webhook_" + strings.ToLower(t)
+		arn, ok := have[t]
+		switch {
+		case !ok:
+			res.Checks = append(res.Checks, CheckItem{Name: name, Reason: fmt.Sprintf("No %s webhook is registered, so new activity will not sync live.", t), Action: "Re-subscribe webhooks or reconnect the shop."})
+		case eventSourceArn != "" && arn != eventSourceArn:
+			res.Checks = append(res.Checks, CheckItem{Name: name, Reason: fmt.Sprintf("The %s webhook points to a different destination.", t), Action: "Re-subscribe webhooks or reconnect the shop."})
+		default:
+			res.Checks = append(res.Checks, CheckItem{Name: name, OK: true})
+		}
+	}
+
+	res.OK = true
+	for _, c := range res.Checks {
+		if !c.OK {
+			res.OK = false
+			break
+		}
+	}
+	return res
+}
+
+// RecordConnectionCheck stores the outcome on the integrations item.
+// PK = USER#<sub>
+// SK = SHOPIFY#<shopDomain>
+func RecordConnectionCheck(ctx context.Context, ddb *dynamodb.Client, userSub string, c *ConnectionCheck) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	c.LastCheckedAt = time.Now().UTC().Format(time.RFC3339)
+
+	status := "ok"
+	reason := ""
+	if !c.OK {
+		status = "failed"
+		for _, it := range c.Checks {
+			if !it.OK {
+				reason = it.Reason
+				break
+			}
+		}
+	}
+
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", userSub)},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("SHOPIFY#%s", c.Shop)},
+		},
+		UpdateExpression:    aws.String("SET LastCheckedAt=:a, LastCheckStatus=:s, LastCheckReason=:r"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":a": &types.AttributeValueMemberS{Value: c.LastCheckedAt},
+			":s": &types.AttributeValueMemberS{Value: status},
+			":r": &types.AttributeValueMemberS{Value: reason},
+		},
+	})
+	return err
+}
@@ -0,0 +1,85 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Discount is a code or automatic discount with its active window. EndsAt is
+// zero for discounts that never end.
+type Discount struct {
+	Title    string
+	StartsAt time.Time
+	EndsAt   time.Time
+}
+
+type discountsPage struct {
+	DiscountNodes struct {
+		Edges []struct {
+			Node struct {
+				Discount struct {
+					Title    string  `json:"title"`
+					StartsAt string  `json:"startsAt"`
+					EndsAt   *string `json:"endsAt"`
+				} `json:"discount"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"discountNodes"`
+}
+
+// Every discount type has the same window fields, but discount is a union.
+const discountsQuery = `
+query Discounts($first: Int!) {
+  discountNodes(first: $first, reverse: true) {
+    edges {
+      node {
+        discount {
+          ... on DiscountCodeBasic { title startsAt endsAt }
+          ... on DiscountCodeBxgy { title startsAt endsAt }
+          ... on DiscountCodeFreeShipping { title startsAt endsAt }
+          ... on DiscountAutomaticBasic { title startsAt endsAt }
+          ... on DiscountAutomaticBxgy { title startsAt endsAt }
+          ... on DiscountAutomaticFreeShipping { title startsAt endsAt }
+        }
+      }
+    }
+  }
+}`
+
+// ListDiscounts returns the shop's most recently created discounts (up to
+// 100). Needs the read_discounts scope; callers should treat an error as
+// "no discount data" rather than fail.
+func ListDiscounts(ctx context.Context, shopDomain, apiVersion, accessToken string) ([]Discount, error) {
+	resp, status, err := PostGraphQL[discountsPage](ctx, shopDomain, apiVersion, accessToken, discountsQuery, map[string]any{"first": 100})
+	if err != nil {
+		return nil, fmt.Errorf("shopify request failed: %w", err)
+	}
+	if status < 200 || status >= 300 {
+		return nil, fmt.Errorf("shopify error status %d", status)
+	}
+	if len(resp.Errors) > 0 {
+		msgs := make([]string, 0, len(resp.Errors))
+		for _, e := range resp.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return nil, &GraphQLErrors{Messages: msgs}
+	}
+
+	var out []Discount
+	for _, e := range resp.Data.DiscountNodes.Edges {
+		d := e.Node.Discount
+		start, err := time.Parse(time.RFC3339, d.StartsAt)
+		if err != nil {
+			continue
+		}
+		disc := Discount{Title: d.Title, StartsAt: start.UTC()}
+		if d.EndsAt != nil {
+			if end, err := time.Parse(time.RFC3339, *d.EndsAt); err == nil {
+				disc.EndsAt = end.UTC()
+			}
+		}
+		out = append(out, disc)
+	}
+	return out, nil
+}
@@ -7,8 +7,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
+// EventBridgeTopics are the webhook topics every connected shop needs (REST
+// names; RequiredWebhookTopics has the GraphQL ones).
+var EventBridgeTopics = []string{
+	"orders/create",
+	"orders/updated",
+	"refunds/create",
+}
+
 type webhookCreateReq struct {
 	Webhook struct {
 		Address string `json:"address"`
@@ -17,9 +26,11 @@ type webhookCreateReq struct {
 	} `json:"webhook"`
 }
 
-type webhookCreateResp struct {
-	Webhook any `json:"webhook"`
-	Errors  any `json:"errors"`
+type webhookListResp struct {
+	Webhooks []struct {
+		Topic   string `json:"topic"`
+		Address string `json:"address"`
+	} `json:"webhooks"`
 }
 
 // Creates a Shopify webhook whose address is the EventBridge partner event source ARN.
@@ -46,6 +57,10 @@ func CreateEventBridgeWebhook(ctx context.Context, shopDomain, apiVersion, acces
 
 	raw, _ := io.ReadAll(res.Body)
 
+	// A concurrent attempt may have created it between our list and create.
+	if res.StatusCode == http.StatusUnprocessableEntity && strings.Contains(string(raw), "already been taken") {
+		return topic, nil
+	}
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
 		return "", fmt.Errorf("create webhook failed: http %d: %s", res.StatusCode, string(raw))
 	}
@@ -55,21 +70,59 @@ func CreateEventBridgeWebhook(ctx context.Context, shopDomain, apiVersion, acces
 	return topic, nil
 }
 
-// Subscribe a shop to all required topics.
-func SubscribeEventBridgeTopics(ctx context.Context, shopDomain, apiVersion, accessToken, eventSourceArn string) (created []string, failed []map[string]string) {
-	topics := []string{
-		"orders/create",
-		"orders/updated",
-		"refunds/create",
+// EventBridgeWebhookTopics lists the topics the shop already delivers to
+// eventSourceArn.
+func EventBridgeWebhookTopics(ctx context.Context, shopDomain, apiVersion, accessToken, eventSourceArn string) (map[string]bool, error) {
+	url := fmt.Sprintf("https://%s/admin/api/%s/webhooks.json?limit=250&fields=topic,address", shopDomain, apiVersion)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req.Header.Set("X-Shopify-Access-Token", accessToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("list webhooks failed: http %d: %s", res.StatusCode, string(raw))
+	}
+	var out webhookListResp
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("list webhooks: %w", err)
+	}
+	topics := map[string]bool{}
+	for _, w := range out.Webhooks {
+		if w.Address == eventSourceArn {
+			topics[w.Topic] = true
+		}
+	}
+	return topics, nil
+}
+
+// SubscribeEventBridgeTopics subscribes a shop to every EventBridgeTopics
+// topic it isn't subscribed to yet, so it is safe to repeat. It returns one
+// status per topic; a failed listing fails every topic.
+func SubscribeEventBridgeTopics(ctx context.Context, shopDomain, apiVersion, accessToken, eventSourceArn string) []WebhookTopicStatus {
+	statuses := make([]WebhookTopicStatus, 0, len(EventBridgeTopics))
+	existing, err := EventBridgeWebhookTopics(ctx, shopDomain, apiVersion, accessToken, eventSourceArn)
+	if err != nil {
+		for _, t := range EventBridgeTopics {
+			statuses = append(statuses, WebhookTopicStatus{Topic: t, Status: WebhookTopicFailed, Error: err.Error()})
+		}
+		return statuses
 	}
 
-	for _, t := range topics {
-		_, err := CreateEventBridgeWebhook(ctx, shopDomain, apiVersion, accessToken, t, eventSourceArn)
-		if err != nil {
-			failed = append(failed, map[string]string{"topic": t, "error": err.Error()})
+	for _, t := range EventBridgeTopics {
+		if existing[t] {
+			statuses = append(statuses, WebhookTopicStatus{Topic: t, Status: WebhookTopicSubscribed})
+			continue
+		}
+		if _, err := CreateEventBridgeWebhook(ctx, shopDomain, apiVersion, accessToken, t, eventSourceArn); err != nil {
+			statuses = append(statuses, WebhookTopicStatus{Topic: t, Status: WebhookTopicFailed, Error: err.Error()})
 			continue
 		}
-		created = append(created, t)
+		statuses = append(statuses, WebhookTopicStatus{Topic: t, Status: WebhookTopicSubscribed})
 	}
-	return created, failed
+	return statuses
 }
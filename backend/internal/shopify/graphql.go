@@ -17,9 +17,24 @@ type GraphQLError struct {
 	} `json:"extensions,omitempty"`
 }
 
+// QueryCost is Shopify's extensions.cost block, used to pace requests
+// against the per-shop leaky bucket.
+type QueryCost struct {
+	RequestedQueryCost float64 `json:"requestedQueryCost"`
+	ActualQueryCost    float64 `json:"actualQueryCost"`
+	ThrottleStatus     struct {
+		MaximumAvailable   float64 `json:"maximumAvailable"`
+		CurrentlyAvailable float64 `json:"currentlyAvailable"`
+		RestoreRate        float64 `json:"restoreRate"`
+	} `json:"throttleStatus"`
+}
+
 type GraphQLResponse[T any] struct {
-	Data   T              `json:"data"`
-	Errors []GraphQLError `json:"errors"`
+	Data       T              `json:"data"`
+	Errors     []GraphQLError `json:"errors"`
+	Extensions struct {
+		Cost *QueryCost `json:"cost,omitempty"`
+	} `json:"extensions"`
 }
 
 func PostGraphQL[T any](ctx context.Context, shopDomain, apiVersion, accessToken string, query string, variables any) (*GraphQLResponse[T], int, error) {
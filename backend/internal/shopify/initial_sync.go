@@ -0,0 +1,130 @@
+package shopify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/shadow"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// InitialSyncDays is how much history a newly connected shop gets.
+const InitialSyncDays = 90
+
+// Initial sync states, stored as InitialSyncStatus on the integrations item.
+const (
+	InitialSyncQueued  = "queued"
+	InitialSyncRunning = "running"
+	InitialSyncDone    = "done"
+	InitialSyncFailed  = "failed"
+)
+
+// ErrIntegrationGone means the shop was disconnected while a job ran.
+var ErrIntegrationGone = errors.New("shopify integration no longer exists")
+
+// InitialSyncJob is the SHOPIFY_INITIAL_SYNC_QUEUE_URL message. A job that
+// runs out of Lambda time re-enqueues itself with Since moved forward.
+type InitialSyncJob struct {
+	Sub   string `json:"sub"`
+	Shop  string `json:"shop"`
+	Since string `json:"since"`
+}
+
+// EnqueueInitialSync marks the shop's initial backfill as queued and hands
+// it to the initial sync worker.
+func EnqueueInitialSync(ctx context.Context, ddb *dynamodb.Client, sub, shop string) error {
+	job := InitialSyncJob{
+		Sub:   sub,
+		Shop:  shop,
+		Since: time.Now().UTC().AddDate(0, 0, -InitialSyncDays).Format(time.RFC3339),
+	}
+	if err := SendInitialSync(ctx, job); err != nil {
+		return err
+	}
+	return updateInitialSync(ctx, ddb, sub, shop,
+		"SET InitialSyncStatus = :s, InitialSyncQueuedAt = :now, InitialSyncOrders = :zero REMOVE InitialSyncStartedAt, InitialSyncFinishedAt, InitialSyncError",
+		map[string]types.AttributeValue{
+			":s":    &types.AttributeValueMemberS{Value: InitialSyncQueued},
+			":now":  &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		})
+}
+
+func SendInitialSync(ctx context.Context, job InitialSyncJob) error {
+	queueURL := strings.TrimSpace(os.Getenv("SHOPIFY_INITIAL_SYNC_QUEUE_URL"))
+	if queueURL == "" {
+		return fmt.Errorf("SHOPIFY_INITIAL_SYNC_QUEUE_URL not set")
+	}
+	cfg, err := shadow.LoadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(job)
+	_, err = sqs.NewFromConfig(cfg).SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(string(b)),
+	})
+	return err
+}
+
+// RecordInitialSyncRunning sets the status and adds `orders` to the running count.
+func RecordInitialSyncRunning(ctx context.Context, ddb *dynamodb.Client, sub, shop string, orders int) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	return updateInitialSync(ctx, ddb, sub, shop,
+		"SET InitialSyncStatus = :s, InitialSyncStartedAt = if_not_exists(InitialSyncStartedAt, :now) ADD InitialSyncOrders :n",
+		map[string]types.AttributeValue{
+			":s":   &types.AttributeValueMemberS{Value: InitialSyncRunning},
+			":now": &types.AttributeValueMemberS{Value: now},
+			":n":   &types.AttributeValueMemberN{Value: strconv.Itoa(orders)},
+		})
+}
+
+// RecordInitialSyncFinished stores the final state; errMsg is empty on success.
+func RecordInitialSyncFinished(ctx context.Context, ddb *dynamodb.Client, sub, shop, errMsg string) error {
+	status := InitialSyncDone
+	if errMsg != "" {
+		status = InitialSyncFailed
+	}
+	return updateInitialSync(ctx, ddb, sub, shop,
+		"SET InitialSyncStatus = :s, InitialSyncFinishedAt = :now, InitialSyncError = :e",
+		map[string]types.AttributeValue{
+			":s":   &types.AttributeValueMemberS{Value: status},
+			":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":e":   &types.AttributeValueMemberS{Value: errMsg},
+		})
+}
+
+// updateInitialSync only touches an existing integration, so a job finishing
+// after a disconnect does not resurrect a partial item.
+func updateInitialSync(ctx context.Context, ddb *dynamodb.Client, sub, shop, expr string, vals map[string]types.AttributeValue) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("SHOPIFY#%s", shop)},
+		},
+		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: vals,
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return ErrIntegrationGone
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package shopify
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// LineItem is one order line as stored on the order's transaction (Lines
+// attribute), for product-level reporting. Amounts are in the shop currency.
+type LineItem struct {
+	SKU       string  `dynamodbav:"SKU,omitempty" json:"sku,omitempty"`
+	Title     string  `dynamodbav:"Title" json:"title"`
+	Quantity  int     `dynamodbav:"Quantity" json:"quantity"`
+	UnitPrice float64 `dynamodbav:"UnitPrice" json:"unitPrice"`
+	Discount  float64 `dynamodbav:"Discount,omitempty" json:"discount,omitempty"` // whole line
+}
+
+// Revenue is what the line sold for after its discounts.
+func (l LineItem) Revenue() float64 {
+	return l.UnitPrice*float64(l.Quantity) - l.Discount
+}
+
+// Key groups lines of the same product: the SKU, or the title for products
+// without one.
+func (l LineItem) Key() string {
+	if l.SKU != "" {
+		return l.SKU
+	}
+	return l.Title
+}
+
+// maxStoredLines keeps big wholesale orders well under the item size limit.
+const maxStoredLines = 100
+
+// SetLineItems stores lines on a transaction item (nothing for no lines).
+func SetLineItems(item map[string]types.AttributeValue, lines []LineItem) {
+	if len(lines) == 0 {
+		return
+	}
+	if len(lines) > maxStoredLines {
+		lines = lines[:maxStoredLines]
+	}
+	if av, err := attributevalue.Marshal(lines); err == nil {
+		item["Lines"] = av
+	}
+}
+
+// LineItemsOf reads the lines stored on a transaction item.
+func LineItemsOf(item map[string]types.AttributeValue) []LineItem {
+	var lines []LineItem
+	if av, ok := item["Lines"]; ok {
+		_ = attributevalue.Unmarshal(av, &lines)
+	}
+	return lines
+}
+
+// LineItemsFromWebhook parses line_items from an orders/* webhook payload.
+func LineItemsFromWebhook(order map[string]any) []LineItem {
+	raw, _ := order["line_items"].([]any)
+	var out []LineItem
+	for _, r := range raw {
+		m, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		l := LineItem{
+			SKU:       strings.TrimSpace(str(m["sku"])),
+			Title:     strings.TrimSpace(str(m["title"])),
+			UnitPrice: num(m["price"]),
+			Discount:  num(m["total_discount"]),
+		}
+		if q, ok := m["quantity"].(float64); ok {
+			l.Quantity = int(q)
+		}
+		if name := strings.TrimSpace(str(m["name"])); name != "" {
+			l.Title = name // includes the variant
+		}
+		if l.Quantity > 0 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// RefundLineItemsFromWebhook parses refund_line_items from a refunds/create
+// payload. UnitPrice is what was refunded per unit, so Revenue() is the
+// line's refunded amount.
+func RefundLineItemsFromWebhook(refund map[string]any) []LineItem {
+	raw, _ := refund["refund_line_items"].([]any)
+	var out []LineItem
+	for _, r := range raw {
+		m, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		li, _ := m["line_item"].(map[string]any)
+		q, _ := m["quantity"].(float64)
+		l := RefundLine(str(li["sku"]), str(li["name"]), int(q), num(m["subtotal"]))
+		if l.Title == "" {
+			l.Title = strings.TrimSpace(str(li["title"]))
+		}
+		if l.Quantity > 0 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// RefundLine is a refunded line of quantity units worth subtotal in all.
+func RefundLine(sku, title string, quantity int, subtotal float64) LineItem {
+	l := LineItem{SKU: strings.TrimSpace(sku), Title: strings.TrimSpace(title), Quantity: quantity}
+	if quantity > 0 {
+		l.UnitPrice = subtotal / float64(quantity)
+	}
+	return l
+}
+
+func str(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// num reads Shopify money, which webhooks send as strings.
+func num(v any) float64 {
+	switch t := v.(type) {
+	case string:
+		f, _ := strconv.ParseFloat(t, 64)
+		return f
+	case float64:
+		return t
+	}
+	return 0
+}
@@ -0,0 +1,239 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Order numbers seen per shop per UTC day live next to the webhook dedupe
+// records (same table, same TTL):
+// PK = ORDERSEQ#<shopDomain>#<YYYY-MM-DD>
+// Numbers  NS  order numbers received by webhook or sync
+// Resolved NS  numbers still missing after a repair (deleted/test orders), never re-flagged
+const orderSeqRetention = 35 * 24 * time.Hour
+
+func orderSeqKey(shop, day string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("ORDERSEQ#%s#%s", shop, day)},
+	}
+}
+
+// RecordOrderNumber notes that order `number` of `shop`, created at `at`, has
+// been stored. It is a no-op when the dedupe table is not configured.
+func RecordOrderNumber(ctx context.Context, ddb *dynamodb.Client, shop string, number int64, at time.Time) error {
+	return addOrderNumbers(ctx, ddb, shop, at.UTC().Format("2006-01-02"), "Numbers", []int64{number})
+}
+
+// MarkOrderNumbersResolved stops the detector from flagging numbers that a
+// repair sync could not find.
+func MarkOrderNumbersResolved(ctx context.Context, ddb *dynamodb.Client, shop, day string, numbers []int64) error {
+	return addOrderNumbers(ctx, ddb, shop, day, "Resolved", numbers)
+}
+
+func addOrderNumbers(ctx context.Context, ddb *dynamodb.Client, shop, day, attr string, numbers []int64) error {
+	tbl := strings.TrimSpace(DedupeTable())
+	if tbl == "" || len(numbers) == 0 {
+		return nil
+	}
+	ns := make([]string, 0, len(numbers))
+	for _, n := range numbers {
+		if n > 0 {
+			ns = append(ns, strconv.FormatInt(n, 10))
+		}
+	}
+	if len(ns) == 0 {
+		return nil
+	}
+
+	exp := time.Now().UTC().Add(orderSeqRetention).Unix()
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              orderSeqKey(shop, day),
+		UpdateExpression: aws.String("SET Shop = :s, #d = :d, ExpiresAt = :e ADD #n :n"),
+		ExpressionAttributeNames: map[string]string{
+			"#d": "Day",
+			"#n": attr,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberS{Value: shop},
+			":d": &types.AttributeValueMemberS{Value: day},
+			":e": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", exp)},
+			":n": &types.AttributeValueMemberNS{Value: ns},
+		},
+	})
+	return err
+}
+
+// OrderGap is a run of missing order numbers between two numbers we did see.
+type OrderGap struct {
+	FirstMissing int64     `json:"firstMissing"`
+	LastMissing  int64     `json:"lastMissing"`
+	WindowStart  time.Time `json:"windowStart"` // start of the day of the number before the gap
+	WindowEnd    time.Time `json:"windowEnd"`   // end of the day of the number after the gap
+	anchorDay    string
+}
+
+func (g OrderGap) Missing() []int64 {
+	out := make([]int64, 0, g.LastMissing-g.FirstMissing+1)
+	for n := g.FirstMissing; n <= g.LastMissing; n++ {
+		out = append(out, n)
+	}
+	return out
+}
+
+// maxGapSize ignores jumps that are not missed webhooks (e.g. the merchant
+// changed the starting order number).
+const maxGapSize = 500
+
+// FindOrderGaps returns the holes in shop's order number sequence over the
+// UTC days [from, to]. Numbers before the first or after the last one seen
+// are not gaps: they belong to days outside the window or have not happened.
+func FindOrderGaps(ctx context.Context, ddb *dynamodb.Client, shop string, from, to time.Time) ([]OrderGap, error) {
+	tbl := strings.TrimSpace(DedupeTable())
+	if tbl == "" {
+		return nil, fmt.Errorf("SHOPIFY_WEBHOOK_DEDUPE_TABLE not set")
+	}
+
+	dayOf := map[int64]string{}
+	resolved := map[int64]bool{}
+	for d := from.UTC().Truncate(24 * time.Hour); !d.After(to); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(tbl),
+			Key:       orderSeqKey(shop, day),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range attrNS(out.Item["Numbers"]) {
+			dayOf[n] = day
+		}
+		for _, n := range attrNS(out.Item["Resolved"]) {
+			resolved[n] = true
+		}
+	}
+
+	nums := make([]int64, 0, len(dayOf))
+	for n := range dayOf {
+		nums = append(nums, n)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	var gaps []OrderGap
+	for i := 1; i < len(nums); i++ {
+		prev, next := nums[i-1], nums[i]
+		if next-prev <= 1 || next-prev > maxGapSize {
+			continue
+		}
+		first, last := prev+1, next-1
+		for first <= last && resolved[first] {
+			first++
+		}
+		for last >= first && resolved[last] {
+			last--
+		}
+		if first > last {
+			continue
+		}
+		start, _ := time.Parse("2006-01-02", dayOf[prev])
+		end, _ := time.Parse("2006-01-02", dayOf[next])
+		gaps = append(gaps, OrderGap{
+			FirstMissing: first,
+			LastMissing:  last,
+			WindowStart:  start,
+			WindowEnd:    end.Add(24*time.Hour - time.Second),
+			anchorDay:    dayOf[prev],
+		})
+	}
+	return gaps, nil
+}
+
+// ResolveGap records the numbers of g that are still unseen after a repair so
+// later runs leave them alone. It returns how many of g's numbers were
+// recovered.
+func ResolveGap(ctx context.Context, ddb *dynamodb.Client, shop string, g OrderGap) (int, error) {
+	after, err := FindOrderGaps(ctx, ddb, shop, g.WindowStart, g.WindowEnd)
+	if err != nil {
+		return 0, err
+	}
+	var still []int64
+	for _, a := range after {
+		for _, n := range a.Missing() {
+			if n >= g.FirstMissing && n <= g.LastMissing {
+				still = append(still, n)
+			}
+		}
+	}
+	if err := MarkOrderNumbersResolved(ctx, ddb, shop, g.anchorDay, still); err != nil {
+		return 0, err
+	}
+	return len(g.Missing()) - len(still), nil
+}
+
+// GapRepair summarizes one detector run for a shop.
+type GapRepair struct {
+	Found     int
+	Recovered int
+	Window    string
+	Err       string
+}
+
+// RecordGapRepair stores the detector outcome on the integrations item next
+// to the connection-check fields.
+// PK = USER#<sub>
+// SK = SHOPIFY#<shopDomain>
+func RecordGapRepair(ctx context.Context, ddb *dynamodb.Client, userSub, shop string, r GapRepair) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	expr := "SET LastGapCheckAt = :a, LastGapError = :e"
+	vals := map[string]types.AttributeValue{
+		":a": &types.AttributeValueMemberS{Value: now},
+		":e": &types.AttributeValueMemberS{Value: r.Err},
+	}
+	if r.Found > 0 {
+		expr += ", LastGapRepairAt = :a, LastGapFound = :f, LastGapRecovered = :r, LastGapWindow = :w"
+		vals[":f"] = &types.AttributeValueMemberN{Value: strconv.Itoa(r.Found)}
+		vals[":r"] = &types.AttributeValueMemberN{Value: strconv.Itoa(r.Recovered)}
+		vals[":w"] = &types.AttributeValueMemberS{Value: r.Window}
+	}
+
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", userSub)},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("SHOPIFY#%s", shop)},
+		},
+		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: vals,
+	})
+	return err
+}
+
+func attrNS(av types.AttributeValue) []int64 {
+	ns, ok := av.(*types.AttributeValueMemberNS)
+	if !ok {
+		return nil
+	}
+	out := make([]int64, 0, len(ns.Value))
+	for _, s := range ns.Value {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			out = append(out, n)
+		}
+	}
+	return out
+}
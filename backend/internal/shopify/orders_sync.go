@@ -0,0 +1,483 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/feerules"
+	"backend/internal/restate"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type money struct {
+	Amount       string `json:"amount"`
+	CurrencyCode string `json:"currencyCode"`
+}
+
+type orderNode struct {
+	Id            string `json:"id"`
+	Name          string `json:"name"`
+	Number        int64  `json:"number"`
+	CreatedAt     string `json:"createdAt"`
+	ProcessedAt   string `json:"processedAt"`
+	UpdatedAt     string `json:"updatedAt"`
+	TotalPriceSet struct {
+		ShopMoney money `json:"shopMoney"`
+	} `json:"totalPriceSet"`
+	TotalDiscountsSet struct {
+		ShopMoney money `json:"shopMoney"`
+	} `json:"totalDiscountsSet"`
+	DiscountCodes []string `json:"discountCodes"`
+	Tags          []string `json:"tags"`
+	LineItems     struct {
+		Edges []struct {
+			Node lineItemNode `json:"node"`
+		} `json:"edges"`
+	} `json:"lineItems"`
+
+	Refunds struct {
+		Edges []struct {
+			Node refundNode `json:"node"`
+		} `json:"edges"`
+	} `json:"refunds"`
+}
+
+type lineItemNode struct {
+	SKU                  string `json:"sku"`
+	Name                 string `json:"name"`
+	Quantity             int    `json:"quantity"`
+	OriginalUnitPriceSet struct {
+		ShopMoney money `json:"shopMoney"`
+	} `json:"originalUnitPriceSet"`
+	TotalDiscountSet struct {
+		ShopMoney money `json:"shopMoney"`
+	} `json:"totalDiscountSet"`
+}
+
+type refundNode struct {
+	Id               string `json:"id"`
+	CreatedAt        string `json:"createdAt"`
+	TotalRefundedSet struct {
+		ShopMoney money `json:"shopMoney"`
+	} `json:"totalRefundedSet"`
+	RefundLineItems struct {
+		Edges []struct {
+			Node struct {
+				Quantity    int `json:"quantity"`
+				SubtotalSet struct {
+					ShopMoney money `json:"shopMoney"`
+				} `json:"subtotalSet"`
+				LineItem struct {
+					SKU  string `json:"sku"`
+					Name string `json:"name"`
+				} `json:"lineItem"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"refundLineItems"`
+}
+
+type ordersPage struct {
+	Orders struct {
+		Edges []struct {
+			Cursor string    `json:"cursor"`
+			Node   orderNode `json:"node"`
+		} `json:"edges"`
+		PageInfo struct {
+			HasNextPage bool   `json:"hasNextPage"`
+			EndCursor   string `json:"endCursor"`
+		} `json:"pageInfo"`
+	} `json:"orders"`
+}
+
+const ordersSyncQuery = `
+query OrdersSync($first: Int!, $after: String, $q: String!) {
+  orders(first: $first, after: $after, query: $q, sortKey: UPDATED_AT) {
+    edges {
+      cursor
+      node {
+        id
+        name
+        number
+        createdAt
+        processedAt
+        updatedAt
+        totalPriceSet { shopMoney { amount currencyCode } }
+        totalDiscountsSet { shopMoney { amount } }
+        discountCodes
+        tags
+        lineItems(first: 50) {
+          edges {
+            node {
+              sku
+              name
+              quantity
+              originalUnitPriceSet { shopMoney { amount } }
+              totalDiscountSet { shopMoney { amount } }
+            }
+          }
+        }
+
+        refunds(first: 20) {
+          edges {
+            node {
+              id
+              createdAt
+              totalRefundedSet { shopMoney { amount currencyCode } }
+              refundLineItems(first: 20) {
+                edges {
+                  node {
+                    quantity
+                    subtotalSet { shopMoney { amount } }
+                    lineItem { sku name }
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+    pageInfo { hasNextPage endCursor }
+  }
+}`
+
+// GraphQLErrors is returned when Shopify answers 2xx with a GraphQL errors array.
+type GraphQLErrors struct {
+	Messages []string
+}
+
+func (e *GraphQLErrors) Error() string {
+	return "shopify graphql returned errors: " + strings.Join(e.Messages, "; ")
+}
+
+// OrdersSync pulls orders (and their refunds) updated since a point in time,
+// one page per NextPage call, so callers can interleave shops and pace
+// requests. Writes are idempotent: existing transactions are left alone.
+type OrdersSync struct {
+	Sub        string
+	Shop       string
+	APIVersion string
+
+	Created    int
+	Skipped    int
+	Pages      int
+	LastSyncAt string // newest order updatedAt seen so far
+	Done       bool
+
+	// LastCost is the cost block of the most recent page, if Shopify sent one.
+	LastCost *QueryCost
+
+	accessToken string
+	query       string
+	cursor      *string
+}
+
+func NewOrdersSync(sub, shop, apiVersion, accessToken, since string) *OrdersSync {
+	return &OrdersSync{
+		Sub:         sub,
+		Shop:        shop,
+		APIVersion:  apiVersion,
+		LastSyncAt:  since,
+		accessToken: accessToken,
+		query:       fmt.Sprintf("updated_at:>=%s", since),
+	}
+}
+
+// NewOrdersRepair re-reads orders created within [from, to], e.g. to fill a
+// gap left by missed webhooks. It does not move LastSyncAt, so callers should
+// not SaveProgress.
+func NewOrdersRepair(sub, shop, apiVersion, accessToken string, from, to time.Time) *OrdersSync {
+	return &OrdersSync{
+		Sub:         sub,
+		Shop:        shop,
+		APIVersion:  apiVersion,
+		accessToken: accessToken,
+		query: fmt.Sprintf("created_at:>='%s' created_at:<='%s'",
+			from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)),
+	}
+}
+
+// NextPage fetches and writes up to `first` orders. Done is set once Shopify
+// reports no further pages.
+func (s *OrdersSync) NextPage(ctx context.Context, ddb *dynamodb.Client, txTable string, first int) error {
+	if s.Done {
+		return nil
+	}
+
+	vars := map[string]any{
+		"first": first,
+		"after": s.cursor,
+		"q":     s.query,
+	}
+
+	resp, status, err := PostGraphQL[ordersPage](ctx, s.Shop, s.APIVersion, s.accessToken, ordersSyncQuery, vars)
+	if err != nil {
+		return fmt.Errorf("shopify request failed: %w", err)
+	}
+	if status < 200 || status >= 300 {
+		return fmt.Errorf("shopify error status %d", status)
+	}
+	s.LastCost = resp.Extensions.Cost
+	if len(resp.Errors) > 0 {
+		msgs := make([]string, 0, len(resp.Errors))
+		for _, e := range resp.Errors {
+			if e.Extensions.Code != "" {
+				msgs = append(msgs, e.Message+" ("+e.Extensions.Code+")")
+			} else {
+				msgs = append(msgs, e.Message)
+			}
+		}
+		return &GraphQLErrors{Messages: msgs}
+	}
+	s.Pages++
+
+	edges := resp.Data.Orders.Edges
+	if len(edges) == 0 {
+		s.Done = true
+		return nil
+	}
+
+	for _, e := range edges {
+		if err := s.writeOrder(ctx, ddb, txTable, e.Node); err != nil {
+			return err
+		}
+	}
+
+	if !resp.Data.Orders.PageInfo.HasNextPage || resp.Data.Orders.PageInfo.EndCursor == "" {
+		s.Done = true
+		return nil
+	}
+	c := resp.Data.Orders.PageInfo.EndCursor
+	s.cursor = &c
+	return nil
+}
+
+func (s *OrdersSync) writeOrder(ctx context.Context, ddb *dynamodb.Client, txTable string, o orderNode) error {
+	// Track newest updatedAt to advance LastSyncAt
+	if o.UpdatedAt != "" && o.UpdatedAt > s.LastSyncAt {
+		s.LastSyncAt = o.UpdatedAt
+	}
+
+	amt, err := strconv.ParseFloat(o.TotalPriceSet.ShopMoney.Amount, 64)
+	if err != nil {
+		s.Skipped++
+		return nil
+	}
+
+	// Use order processedAt as CreatedAt
+	tm, terr := time.Parse(time.RFC3339, o.ProcessedAt)
+	if terr != nil {
+		tm = time.Now().UTC()
+	}
+	tm = tm.UTC()
+
+	// Deterministic transaction key (idempotent):
+	// SHOPIFY#shop.myshopify.com#ORDER#<gid last segment>
+	orderID := o.Id
+	if i := strings.LastIndex(orderID, "/"); i >= 0 {
+		orderID = orderID[i+1:]
+	}
+	txPK := fmt.Sprintf("USER#%s", s.Sub)
+
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: txPK},
+		"SK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("SHOPIFY#%s#ORDER#%s", s.Shop, orderID)},
+		"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", s.Sub, tm.Format("2006-01"))},
+		"GSI1SK":    &types.AttributeValueMemberS{Value: tm.Format(time.RFC3339Nano)},
+		"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", amt)},
+		"Currency":  &types.AttributeValueMemberS{Value: o.TotalPriceSet.ShopMoney.CurrencyCode},
+		"Category":  &types.AttributeValueMemberS{Value: "Shopify Sales"},
+		"Note":      &types.AttributeValueMemberS{Value: fmt.Sprintf("%s (%s)", o.Name, s.Shop)},
+		"CreatedAt": &types.AttributeValueMemberS{Value: tm.Format(time.RFC3339)},
+		"Source":    &types.AttributeValueMemberS{Value: "shopify"},
+		"Shop":      &types.AttributeValueMemberS{Value: s.Shop},
+		"OrderGid":  &types.AttributeValueMemberS{Value: o.Id},
+		"OrderName": &types.AttributeValueMemberS{Value: o.Name},
+		"UpdatedAt": &types.AttributeValueMemberS{Value: o.UpdatedAt},
+	}
+	if d, err := strconv.ParseFloat(o.TotalDiscountsSet.ShopMoney.Amount, 64); err == nil && d > 0 {
+		item["Discount"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", d)}
+	}
+	if codes := uniqueStrings(o.DiscountCodes); len(codes) > 0 {
+		item["DiscountCodes"] = &types.AttributeValueMemberSS{Value: codes}
+	}
+	if tags := uniqueStrings(o.Tags); len(tags) > 0 {
+		item["OrderTags"] = &types.AttributeValueMemberSS{Value: tags}
+	}
+	var lines []LineItem
+	for _, e := range o.LineItems.Edges {
+		n := e.Node
+		if n.Quantity <= 0 {
+			continue
+		}
+		price, _ := strconv.ParseFloat(n.OriginalUnitPriceSet.ShopMoney.Amount, 64)
+		disc, _ := strconv.ParseFloat(n.TotalDiscountSet.ShopMoney.Amount, 64)
+		lines = append(lines, LineItem{SKU: strings.TrimSpace(n.SKU), Title: strings.TrimSpace(n.Name), Quantity: n.Quantity, UnitPrice: price, Discount: disc})
+	}
+	SetLineItems(item, lines)
+	restate.Stamp(item)
+
+	_, putErr := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(txTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+	})
+	if putErr != nil {
+		// If already exists, treat as idempotent skip
+		s.Skipped++
+	} else {
+		s.Created++
+	}
+	// Fees are applied to orders already stored as well, so a backfill
+	// charges rules added since the order was first ingested.
+	if err := feerules.Apply(ctx, ddb, txTable, s.Sub, item, putErr != nil); err != nil {
+		fmt.Printf("shopify: fee rules shop=%s order=%s: %v\n", s.Shop, orderID, err)
+	}
+
+	if o.Number > 0 {
+		created, cerr := time.Parse(time.RFC3339, o.CreatedAt)
+		if cerr != nil {
+			created = tm
+		}
+		if err := RecordOrderNumber(ctx, ddb, s.Shop, o.Number, created); err != nil {
+			fmt.Printf("shopify: record order number shop=%s number=%d: %v\n", s.Shop, o.Number, err)
+		}
+	}
+
+	// Refund transactions (negative amounts)
+	for _, re := range o.Refunds.Edges {
+		r := re.Node
+
+		refAmt, err := strconv.ParseFloat(r.TotalRefundedSet.ShopMoney.Amount, 64)
+		if err != nil || refAmt == 0 {
+			continue
+		}
+
+		refID := r.Id
+		if i := strings.LastIndex(refID, "/"); i >= 0 {
+			refID = refID[i+1:]
+		}
+
+		refTime, terr := time.Parse(time.RFC3339, r.CreatedAt)
+		if terr != nil {
+			refTime = time.Now().UTC()
+		}
+		refTime = refTime.UTC()
+
+		refItem := map[string]types.AttributeValue{
+			"PK":        &types.AttributeValueMemberS{Value: txPK},
+			"SK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("SHOPIFY#%s#REFUND#%s", s.Shop, refID)},
+			"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", s.Sub, refTime.Format("2006-01"))},
+			"GSI1SK":    &types.AttributeValueMemberS{Value: refTime.Format(time.RFC3339Nano)},
+			"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", -1*refAmt)},
+			"Currency":  &types.AttributeValueMemberS{Value: r.TotalRefundedSet.ShopMoney.CurrencyCode},
+			"Category":  &types.AttributeValueMemberS{Value: "Shopify Refunds"},
+			"Note":      &types.AttributeValueMemberS{Value: fmt.Sprintf("%s refund (%s)", o.Name, s.Shop)},
+			"CreatedAt": &types.AttributeValueMemberS{Value: refTime.Format(time.RFC3339)},
+			"Source":    &types.AttributeValueMemberS{Value: "shopify"},
+			"Shop":      &types.AttributeValueMemberS{Value: s.Shop},
+			"OrderGid":  &types.AttributeValueMemberS{Value: o.Id},
+			"OrderName": &types.AttributeValueMemberS{Value: o.Name},
+			"RefundGid": &types.AttributeValueMemberS{Value: r.Id},
+		}
+		var refLines []LineItem
+		for _, le := range r.RefundLineItems.Edges {
+			n := le.Node
+			sub, _ := strconv.ParseFloat(n.SubtotalSet.ShopMoney.Amount, 64)
+			if l := RefundLine(n.LineItem.SKU, n.LineItem.Name, n.Quantity, sub); l.Quantity > 0 {
+				refLines = append(refLines, l)
+			}
+		}
+		SetLineItems(refItem, refLines)
+		restate.Stamp(refItem)
+
+		_, putErr := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(txTable),
+			Item:                refItem,
+			ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+		})
+		if putErr == nil {
+			s.Created++
+		}
+	}
+	return nil
+}
+
+// SaveProgress persists LastSyncAt on the shop's integration item so the
+// next sync continues from there.
+func (s *OrdersSync) SaveProgress(ctx context.Context, ddb *dynamodb.Client) error {
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.IntegrationsTableName()),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", s.Sub)},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("SHOPIFY#%s", s.Shop)},
+		},
+		UpdateExpression: aws.String("SET LastSyncAt = :t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberS{Value: s.LastSyncAt},
+		},
+	})
+	return err
+}
+
+// ListIntegrations returns every connected Shopify shop across all users.
+func ListIntegrations(ctx context.Context, ddb *dynamodb.Client) ([]IntegrationItem, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	var items []map[string]types.AttributeValue
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(tbl),
+			FilterExpression: aws.String("begins_with(PK, :u) AND begins_with(SK, :p)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":u": &types.AttributeValueMemberS{Value: "USER#"},
+				":p": &types.AttributeValueMemberS{Value: "SHOPIFY#"},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+
+	var out []IntegrationItem
+	if err := attributevalue.UnmarshalListOfMaps(items, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (i IntegrationItem) UserSub() string {
+	return strings.TrimPrefix(i.PK, "USER#")
+}
+
+// uniqueStrings drops blanks and duplicates, as string sets require.
+func uniqueStrings(in []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range in {
+		s = strings.TrimSpace(s)
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
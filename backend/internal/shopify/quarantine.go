@@ -0,0 +1,89 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/shadow"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// QuarantineWindow is how long an event for a shop with no mapped users is
+// kept and retried before it is dropped.
+const QuarantineWindow = 24 * time.Hour
+
+// ErrQuarantineDisabled means SHOPIFY_QUARANTINE_QUEUE_URL is not set.
+var ErrQuarantineDisabled = errors.New("SHOPIFY_QUARANTINE_QUEUE_URL not set")
+
+// Quarantine targets name the worker queue an event goes back to.
+const (
+	QuarantineOrders  = "orders"
+	QuarantineRefunds = "refunds"
+)
+
+func QuarantineQueueURL() string {
+	return strings.TrimSpace(os.Getenv("SHOPIFY_QUARANTINE_QUEUE_URL"))
+}
+
+// TargetQueueURL is the worker queue for a quarantine target.
+func TargetQueueURL(target string) string {
+	switch target {
+	case QuarantineOrders:
+		return strings.TrimSpace(os.Getenv("SHOPIFY_ORDERS_QUEUE_URL"))
+	case QuarantineRefunds:
+		return strings.TrimSpace(os.Getenv("SHOPIFY_REFUNDS_QUEUE_URL"))
+	}
+	return ""
+}
+
+// Quarantine parks a webhook event whose shop has no users yet (mapping
+// deleted, or the webhook beat the OAuth callback). The quarantine worker
+// hands it back to `target` once the shop is mapped again.
+func Quarantine(ctx context.Context, body, target, shopDomain string) error {
+	queueURL := QuarantineQueueURL()
+	if queueURL == "" {
+		return ErrQuarantineDisabled
+	}
+	cfg, err := shadow.LoadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = sqs.NewFromConfig(cfg).SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(body),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"Target":        {DataType: aws.String("String"), StringValue: aws.String(target)},
+			"Shop":          {DataType: aws.String("String"), StringValue: aws.String(shopDomain)},
+			"QuarantinedAt": {DataType: aws.String("Number"), StringValue: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("quarantine send: %w", err)
+	}
+	return nil
+}
+
+// Release sends a quarantined event back to its worker queue.
+func Release(ctx context.Context, body, target string) error {
+	queueURL := TargetQueueURL(target)
+	if queueURL == "" {
+		return fmt.Errorf("no queue for quarantine target %q", target)
+	}
+	cfg, err := shadow.LoadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = sqs.NewFromConfig(cfg).SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(body),
+	})
+	return err
+}
@@ -0,0 +1,109 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// RefundAmount is what a refunds/create payload gave back: the sum of its
+// successful refund transactions, else its amount or total_refunded.
+func RefundAmount(refund map[string]any) (float64, bool) {
+	if txs, ok := refund["transactions"].([]any); ok && len(txs) > 0 {
+		sum := 0.0
+		found := false
+		for _, t := range txs {
+			m, ok := t.(map[string]any)
+			if !ok {
+				continue
+			}
+			kind := strings.ToLower(fmt.Sprintf("%v", m["kind"]))
+			status := strings.ToLower(fmt.Sprintf("%v", m["status"]))
+
+			if kind != "" && kind != "refund" {
+				continue
+			}
+			if status != "" && status != "success" {
+				continue
+			}
+			if f, ok := parseFloatAny(m["amount"]); ok {
+				sum += f
+				found = true
+			}
+		}
+		if found {
+			return sum, true
+		}
+	}
+
+	if f, ok := parseFloatAny(refund["amount"]); ok {
+		return f, true
+	}
+	if f, ok := parseFloatAny(refund["total_refunded"]); ok {
+		return f, true
+	}
+	return 0, false
+}
+
+func parseFloatAny(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		if x == "" || x == "<nil>" {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return 0, false
+		}
+		return f, true
+	default:
+		return 0, false
+	}
+}
+
+// OrderTx is the stored transaction of a Shopify order, as refund alerts
+// show it.
+type OrderTx struct {
+	OrderName string  `dynamodbav:"OrderName"`
+	Amount    float64 `dynamodbav:"Amount"`
+	Currency  string  `dynamodbav:"Currency"`
+	CreatedAt string  `dynamodbav:"CreatedAt"`
+}
+
+// LoadOrderTx reads the order transaction the orders worker wrote for sub,
+// or nil when the order never reached TrueProfit (placed before connecting,
+// or its webhook is still queued).
+func LoadOrderTx(ctx context.Context, ddb *dynamodb.Client, sub, shopDomain, orderID string) (*OrderTx, error) {
+	tbl := strings.TrimSpace(db.TransactionsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("TRANSACTIONS_TABLE not set")
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("SHOPIFY#%s#ORDER#%s", shopDomain, orderID)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get order tx: %w", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var o OrderTx
+	if err := attributevalue.UnmarshalMap(out.Item, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
@@ -0,0 +1,295 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// When Shopify replays a backlog (or our queues drain one after an outage)
+// thousands of webhooks for a shop arrive at once. A shop is in a replay
+// while its events run ReplayLag behind their trigger time or arrive faster
+// than ReplayRate a minute. During a replay the emailer holds back per-event
+// alerts; once the shop has been quiet for ReplayQuiet the replay monitor
+// sends a single catch-up summary and closes it.
+//
+// Replays live next to the webhook dedupe records:
+// PK = REPLAYRATE#<shop>#<YYYY-MM-DDTHH:MM>  Events, 1h TTL
+// PK = REPLAY#<shop>                         the open replay (deleted on close)
+// PK = REPLAY#OPEN                           Shops (SS) with an open replay
+const (
+	ReplayLag   = 10 * time.Minute
+	ReplayRate  = 120
+	ReplayQuiet = 10 * time.Minute
+
+	replayTTL = 7 * 24 * time.Hour
+)
+
+var openReplaysKey = map[string]types.AttributeValue{
+	"PK": &types.AttributeValueMemberS{Value: "REPLAY#OPEN"},
+}
+
+func replayKey(shop string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "REPLAY#" + shop},
+	}
+}
+
+// EventTime is when Shopify triggered a webhook: the X-Shopify-Triggered-At
+// header when present, else the EventBridge event time, else now.
+func EventTime(triggeredAt, ebTime string, now time.Time) time.Time {
+	for _, s := range []string{triggeredAt, ebTime} {
+		if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(s)); err == nil {
+			return t.UTC()
+		}
+	}
+	return now.UTC()
+}
+
+// ReplayBatch is what one worker batch saw for a shop.
+type ReplayBatch struct {
+	Events  int
+	Orders  int
+	Refunds int
+	Oldest  time.Time // earliest trigger time in the batch
+}
+
+// Add counts one event triggered at `at`.
+func (b *ReplayBatch) Add(at time.Time, order, refund bool) {
+	b.Events++
+	if order {
+		b.Orders++
+	}
+	if refund {
+		b.Refunds++
+	}
+	if b.Oldest.IsZero() || at.Before(b.Oldest) {
+		b.Oldest = at
+	}
+}
+
+// ObserveEvents records a batch of a shop's webhooks and reports whether the
+// shop is replaying. Events join an open replay; otherwise a replay opens
+// when the batch is old or the shop's rate this minute is above ReplayRate.
+func ObserveEvents(ctx context.Context, ddb *dynamodb.Client, shop string, b ReplayBatch) (bool, error) {
+	tbl := strings.TrimSpace(DedupeTable())
+	if tbl == "" || shop == "" || b.Events == 0 {
+		return false, nil
+	}
+	now := time.Now().UTC()
+
+	rate, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("REPLAYRATE#%s#%s", shop, now.Format("2006-01-02T15:04"))},
+		},
+		UpdateExpression: aws.String("SET ExpiresAt = :e ADD Events :n"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":e": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+			":n": &types.AttributeValueMemberN{Value: strconv.Itoa(b.Events)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return false, fmt.Errorf("replay rate: %w", err)
+	}
+	perMinute := 0
+	if n, ok := rate.Attributes["Events"].(*types.AttributeValueMemberN); ok {
+		perMinute, _ = strconv.Atoi(n.Value)
+	}
+
+	start := (!b.Oldest.IsZero() && now.Sub(b.Oldest) >= ReplayLag) || perMinute >= ReplayRate
+	return touchReplay(ctx, ddb, tbl, shop, map[string]int{
+		"Events":  b.Events,
+		"Orders":  b.Orders,
+		"Refunds": b.Refunds,
+	}, b.Oldest, start)
+}
+
+// InReplay reports whether shop has an open replay.
+func InReplay(ctx context.Context, ddb *dynamodb.Client, shop string) (bool, error) {
+	tbl := strings.TrimSpace(DedupeTable())
+	if tbl == "" || shop == "" {
+		return false, nil
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:            aws.String(tbl),
+		Key:                  replayKey(shop),
+		ProjectionExpression: aws.String("PK"),
+	})
+	if err != nil {
+		return false, fmt.Errorf("get replay: %w", err)
+	}
+	return out.Item != nil, nil
+}
+
+// NoteSuppressed counts alerts held back during a replay of shop, opening
+// the replay if the emailer noticed it first.
+func NoteSuppressed(ctx context.Context, ddb *dynamodb.Client, shop string, n int, oldest time.Time) error {
+	tbl := strings.TrimSpace(DedupeTable())
+	if tbl == "" || shop == "" || n == 0 {
+		return nil
+	}
+	_, err := touchReplay(ctx, ddb, tbl, shop, map[string]int{"Suppressed": n}, oldest, true)
+	return err
+}
+
+// touchReplay adds counts to shop's replay. It only creates the replay when
+// start is set, and reports whether the replay exists afterwards.
+func touchReplay(ctx context.Context, ddb *dynamodb.Client, tbl, shop string, add map[string]int, oldest time.Time, start bool) (bool, error) {
+	now := time.Now().UTC()
+	if oldest.IsZero() {
+		oldest = now
+	}
+	expr := "SET Shop = :s, StartedAt = if_not_exists(StartedAt, :now), BacklogSince = if_not_exists(BacklogSince, :since), LastEventAt = :now, ExpiresAt = :e ADD "
+	vals := map[string]types.AttributeValue{
+		":s":     &types.AttributeValueMemberS{Value: shop},
+		":now":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+		":since": &types.AttributeValueMemberS{Value: oldest.Format(time.RFC3339)},
+		":e":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(replayTTL).Unix(), 10)},
+	}
+	var adds []string
+	for _, k := range []string{"Events", "Orders", "Refunds", "Suppressed"} {
+		if n, ok := add[k]; ok {
+			adds = append(adds, fmt.Sprintf("%s :%s", k, k))
+			vals[":"+k] = &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+		}
+	}
+	in := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tbl),
+		Key:                       replayKey(shop),
+		UpdateExpression:          aws.String(expr + strings.Join(adds, ", ")),
+		ExpressionAttributeValues: vals,
+		ReturnValues:              types.ReturnValueAllOld,
+	}
+	if !start {
+		in.ConditionExpression = aws.String("attribute_exists(PK)")
+	}
+	out, err := ddb.UpdateItem(ctx, in)
+	if err != nil {
+		var cfe *types.ConditionalCheckFailedException
+		if errors.As(err, &cfe) {
+			return false, nil
+		}
+		return false, fmt.Errorf("update replay: %w", err)
+	}
+	if _, existed := out.Attributes["StartedAt"]; existed {
+		return true, nil
+	}
+
+	fmt.Printf("shopify: replay started shop=%s backlogSince=%s\n", shop, oldest.Format(time.RFC3339))
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              openReplaysKey,
+		UpdateExpression: aws.String("ADD Shops :s"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberSS{Value: []string{shop}},
+		},
+	})
+	if err != nil {
+		return true, fmt.Errorf("list open replay: %w", err)
+	}
+	return true, nil
+}
+
+type Replay struct {
+	Shop         string `dynamodbav:"Shop" json:"shop"`
+	StartedAt    string `dynamodbav:"StartedAt" json:"startedAt"`
+	BacklogSince string `dynamodbav:"BacklogSince" json:"backlogSince"`
+	LastEventAt  string `dynamodbav:"LastEventAt" json:"lastEventAt"`
+	Events       int    `dynamodbav:"Events" json:"events"`
+	Orders       int    `dynamodbav:"Orders" json:"orders"`
+	Refunds      int    `dynamodbav:"Refunds" json:"refunds"`
+	Suppressed   int    `dynamodbav:"Suppressed" json:"suppressed"`
+}
+
+// Quiet reports whether no event has joined the replay for ReplayQuiet.
+func (r Replay) Quiet(now time.Time) bool {
+	last, err := time.Parse(time.RFC3339Nano, r.LastEventAt)
+	return err != nil || now.Sub(last) >= ReplayQuiet
+}
+
+// OpenReplays lists the replays not closed yet.
+func OpenReplays(ctx context.Context, ddb *dynamodb.Client) ([]Replay, error) {
+	tbl := strings.TrimSpace(DedupeTable())
+	if tbl == "" {
+		return nil, nil
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(tbl), Key: openReplaysKey})
+	if err != nil {
+		return nil, fmt.Errorf("get open replays: %w", err)
+	}
+	shops, _ := out.Item["Shops"].(*types.AttributeValueMemberSS)
+	if shops == nil {
+		return nil, nil
+	}
+
+	var replays []Replay
+	for _, shop := range shops.Value {
+		it, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(tbl), Key: replayKey(shop)})
+		if err != nil {
+			return nil, fmt.Errorf("get replay %s: %w", shop, err)
+		}
+		if it.Item == nil {
+			// Closed, but the listing update didn't go through.
+			if err := unlistReplay(ctx, ddb, tbl, shop); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var r Replay
+		if err := attributevalue.UnmarshalMap(it.Item, &r); err != nil {
+			return nil, fmt.Errorf("unmarshal replay %s: %w", shop, err)
+		}
+		replays = append(replays, r)
+	}
+	return replays, nil
+}
+
+// CloseReplay ends r unless more events joined it since it was read, and
+// reports whether it did.
+func CloseReplay(ctx context.Context, ddb *dynamodb.Client, r Replay) (bool, error) {
+	tbl := strings.TrimSpace(DedupeTable())
+	if tbl == "" {
+		return false, nil
+	}
+	_, err := ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(tbl),
+		Key:                 replayKey(r.Shop),
+		ConditionExpression: aws.String("LastEventAt = :seen"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":seen": &types.AttributeValueMemberS{Value: r.LastEventAt},
+		},
+	})
+	if err != nil {
+		var cfe *types.ConditionalCheckFailedException
+		if errors.As(err, &cfe) {
+			return false, nil
+		}
+		return false, fmt.Errorf("close replay %s: %w", r.Shop, err)
+	}
+	return true, unlistReplay(ctx, ddb, tbl, r.Shop)
+}
+
+func unlistReplay(ctx context.Context, ddb *dynamodb.Client, tbl, shop string) error {
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              openReplaysKey,
+		UpdateExpression: aws.String("DELETE Shops :s"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberSS{Value: []string{shop}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unlist replay %s: %w", shop, err)
+	}
+	return nil
+}
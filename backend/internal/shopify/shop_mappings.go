@@ -0,0 +1,155 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ShopMapping is one SHOP_TO_USER_TABLE row: PK = SHOP#<shop>, SK = USER#<sub>.
+type ShopMapping struct {
+	Shop    string `json:"shop"`
+	UserSub string `json:"userSub"`
+}
+
+// MappingRepair reports a RepairShopMappings run.
+type MappingRepair struct {
+	DryRun       bool          `json:"dryRun"`
+	Integrations int           `json:"integrations"` // connected shops scanned
+	Present      int           `json:"present"`      // already mapped
+	Repaired     []ShopMapping `json:"repaired"`     // written (or, on a dry run, missing)
+	// Stale mappings have no integration behind them, e.g. left by a
+	// disconnect. They are reported, not removed.
+	Stale []ShopMapping `json:"stale"`
+}
+
+// RepairShopMappings writes the shop-to-user row of every connected Shopify
+// integration that lacks one; webhooks for an unmapped shop are dropped.
+// Existing rows are never overwritten, so it is safe to run at any time.
+func RepairShopMappings(ctx context.Context, ddb *dynamodb.Client, dryRun bool) (MappingRepair, error) {
+	r := MappingRepair{DryRun: dryRun, Repaired: []ShopMapping{}, Stale: []ShopMapping{}}
+	tbl := strings.TrimSpace(db.ShopToUserTableName())
+	if tbl == "" {
+		return r, fmt.Errorf("SHOP_TO_USER_TABLE not set")
+	}
+
+	integs, err := ListIntegrations(ctx, ddb)
+	if err != nil {
+		return r, fmt.Errorf("list integrations: %w", err)
+	}
+	connected := map[ShopMapping]bool{}
+	for _, it := range integs {
+		shop, ok := strings.CutPrefix(it.SK, "SHOPIFY#")
+		if !ok || strings.Contains(shop, "#") || it.AccessTokenEnc == "" || it.UserSub() == "" {
+			continue
+		}
+		connected[ShopMapping{Shop: strings.ToLower(shop), UserSub: it.UserSub()}] = true
+	}
+	r.Integrations = len(connected)
+
+	mapped, err := listShopMappings(ctx, ddb, tbl)
+	if err != nil {
+		return r, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for m := range connected {
+		if mapped[m] {
+			r.Present++
+			continue
+		}
+		if !dryRun {
+			written, err := putShopMapping(ctx, ddb, tbl, m, now)
+			if err != nil {
+				return r, err
+			}
+			if !written { // mapped since the scan
+				r.Present++
+				continue
+			}
+			InvalidateUsersForShop(m.Shop)
+		}
+		r.Repaired = append(r.Repaired, m)
+	}
+	for m := range mapped {
+		if !connected[m] {
+			r.Stale = append(r.Stale, m)
+		}
+	}
+
+	byShop := func(s []ShopMapping) {
+		sort.Slice(s, func(i, j int) bool {
+			if s[i].Shop != s[j].Shop {
+				return s[i].Shop < s[j].Shop
+			}
+			return s[i].UserSub < s[j].UserSub
+		})
+	}
+	byShop(r.Repaired)
+	byShop(r.Stale)
+	return r, nil
+}
+
+func listShopMappings(ctx context.Context, ddb *dynamodb.Client, tbl string) (map[ShopMapping]bool, error) {
+	mapped := map[ShopMapping]bool{}
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:            aws.String(tbl),
+			ProjectionExpression: aws.String("PK, SK"),
+			ExclusiveStartKey:    startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan shop mappings: %w", err)
+		}
+		for _, it := range out.Items {
+			pk, _ := it["PK"].(*types.AttributeValueMemberS)
+			sk, _ := it["SK"].(*types.AttributeValueMemberS)
+			if pk == nil || sk == nil {
+				continue
+			}
+			shop, ok1 := strings.CutPrefix(pk.Value, "SHOP#")
+			sub, ok2 := strings.CutPrefix(sk.Value, "USER#")
+			if ok1 && ok2 && shop != "" && sub != "" {
+				mapped[ShopMapping{Shop: shop, UserSub: sub}] = true
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return mapped, nil
+}
+
+// putShopMapping writes m unless it exists, reporting whether it wrote.
+func putShopMapping(ctx context.Context, ddb *dynamodb.Client, tbl string, m ShopMapping, now string) (bool, error) {
+	_, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tbl),
+		Item: map[string]types.AttributeValue{
+			"PK":        &types.AttributeValueMemberS{Value: "SHOP#" + m.Shop},
+			"SK":        &types.AttributeValueMemberS{Value: "USER#" + m.UserSub},
+			"Shop":      &types.AttributeValueMemberS{Value: m.Shop},
+			"UserSub":   &types.AttributeValueMemberS{Value: m.UserSub},
+			"CreatedAt": &types.AttributeValueMemberS{Value: now},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("put shop mapping %s: %w", m.Shop, err)
+	}
+	return true, nil
+}
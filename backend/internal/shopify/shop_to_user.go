@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	"backend/internal/cache"
 	"backend/internal/db"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -12,11 +13,23 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+// shopUsers caches UsersForShop, which the webhook workers run per event.
+var shopUsers = cache.New[string, []string](1000, cache.TTL())
+
+// InvalidateUsersForShop drops the cached users of shopDomain after a change
+// to its mappings.
+func InvalidateUsersForShop(shopDomain string) {
+	shopUsers.Delete(shopDomain)
+}
+
 func UsersForShop(ctx context.Context, ddb *dynamodb.Client, shopDomain string) ([]string, error) {
 	tbl := db.ShopToUserTableName()
 	if strings.TrimSpace(tbl) == "" {
 		return nil, fmt.Errorf("SHOP_TO_USER_TABLE not set")
 	}
+	if subs, ok := shopUsers.Get(shopDomain); ok {
+		return append([]string(nil), subs...), nil
+	}
 
 	pk := fmt.Sprintf("SHOP#%s", shopDomain)
 
@@ -42,5 +55,10 @@ func UsersForShop(ctx context.Context, ddb *dynamodb.Client, shopDomain string)
 			}
 		}
 	}
+	// An unknown shop isn't cached: its first webhooks may race the mapping
+	// written by another container's OAuth callback.
+	if len(subs) > 0 {
+		shopUsers.Set(shopDomain, append([]string(nil), subs...))
+	}
 	return subs, nil
 }
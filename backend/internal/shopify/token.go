@@ -69,25 +69,33 @@ func LoadIntegrationAndDecryptToken(ctx context.Context, sub, shopDomain string)
 		return "", nil, err
 	}
 
-	enc := strings.TrimSpace(integ.AccessTokenEnc)
+	token, err := integ.DecryptAccessToken()
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &integ, nil
+}
+
+// DecryptAccessToken returns the plaintext offline access token.
+func (i IntegrationItem) DecryptAccessToken() (string, error) {
+	enc := strings.TrimSpace(i.AccessTokenEnc)
 	if enc == "" {
-		return "", nil, errors.New("no AccessTokenEnc on record")
+		return "", errors.New("no AccessTokenEnc on record")
 	}
 
 	keyB64 := os.Getenv("TOKEN_ENC_KEY_B64")
 	if keyB64 == "" {
-		return "", nil, errors.New("TOKEN_ENC_KEY_B64 not set")
+		return "", errors.New("TOKEN_ENC_KEY_B64 not set")
 	}
 
 	key, err := security.LoadKeyFromBase64(keyB64)
 	if err != nil {
-		return "", nil, fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+		return "", fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
 	}
 
 	token, err := security.DecryptAESGCM(key, enc)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to decrypt token: %w", err)
+		return "", fmt.Errorf("failed to decrypt token: %w", err)
 	}
-
-	return token, &integ, nil
+	return token, nil
 }
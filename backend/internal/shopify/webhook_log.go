@@ -0,0 +1,161 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Webhook log statuses.
+const (
+	WebhookProcessed   = "processed"
+	WebhookQuarantined = "quarantined"
+	WebhookFailed      = "failed" // retried by SQS; a later entry may follow
+)
+
+// webhookLogTTL is how long a webhook stays verifiable.
+const webhookLogTTL = 30 * 24 * time.Hour
+
+func WebhookLogTable() string {
+	return strings.TrimSpace(os.Getenv("SHOPIFY_WEBHOOK_LOG_TABLE"))
+}
+
+// WebhookLogEntry is the audit record of one webhook delivery: Shopify's
+// metadata headers and what the worker did with it, never the payload.
+//
+// PK = SHOP#<shop>
+// SK = <triggeredAt RFC3339>#<webhookId>   (newest last; a retry overwrites)
+type WebhookLogEntry struct {
+	Shop        string    `json:"-"`
+	WebhookID   string    `json:"webhookId"`
+	Topic       string    `json:"topic"`
+	TriggeredAt time.Time `json:"triggeredAt"`
+	APIVersion  string    `json:"apiVersion,omitempty"`
+	Status      string    `json:"status"`
+	ProcessedAt string    `json:"processedAt"`
+}
+
+// LogWebhooks writes entries in batches. Entries without a shop or webhook
+// id are skipped, and the last entry per key wins. Best effort: callers log
+// the error and carry on.
+func LogWebhooks(ctx context.Context, ddb *dynamodb.Client, entries []WebhookLogEntry) error {
+	tbl := WebhookLogTable()
+	if tbl == "" || len(entries) == 0 {
+		return nil
+	}
+
+	exp := fmt.Sprintf("%d", time.Now().Add(webhookLogTTL).Unix())
+	byKey := map[string]map[string]types.AttributeValue{}
+	var order []string
+	for _, e := range entries {
+		if e.Shop == "" || e.WebhookID == "" {
+			continue
+		}
+		sk := fmt.Sprintf("%s#%s", e.TriggeredAt.UTC().Format(time.RFC3339), e.WebhookID)
+		key := e.Shop + "|" + sk
+		if _, ok := byKey[key]; !ok {
+			order = append(order, key)
+		}
+		item := map[string]types.AttributeValue{
+			"PK":          &types.AttributeValueMemberS{Value: "SHOP#" + e.Shop},
+			"SK":          &types.AttributeValueMemberS{Value: sk},
+			"WebhookId":   &types.AttributeValueMemberS{Value: e.WebhookID},
+			"Topic":       &types.AttributeValueMemberS{Value: e.Topic},
+			"TriggeredAt": &types.AttributeValueMemberS{Value: e.TriggeredAt.UTC().Format(time.RFC3339)},
+			"Status":      &types.AttributeValueMemberS{Value: e.Status},
+			"ProcessedAt": &types.AttributeValueMemberS{Value: e.ProcessedAt},
+			"ExpiresAt":   &types.AttributeValueMemberN{Value: exp},
+		}
+		if e.APIVersion != "" {
+			item["ApiVersion"] = &types.AttributeValueMemberS{Value: e.APIVersion}
+		}
+		byKey[key] = item
+	}
+
+	for start := 0; start < len(order); start += 25 {
+		end := start + 25
+		if end > len(order) {
+			end = len(order)
+		}
+		reqs := make([]types.WriteRequest, 0, end-start)
+		for _, k := range order[start:end] {
+			reqs = append(reqs, types.WriteRequest{PutRequest: &types.PutRequest{Item: byKey[k]}})
+		}
+		pending := map[string][]types.WriteRequest{tbl: reqs}
+		for attempt := 0; len(pending[tbl]) > 0; attempt++ {
+			if attempt == 3 {
+				return fmt.Errorf("webhook log: %d entries unprocessed", len(pending[tbl]))
+			}
+			out, err := ddb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return fmt.Errorf("webhook log: %w", err)
+			}
+			pending = out.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// ListWebhookLog returns a shop's logged webhooks, newest first, optionally
+// narrowed to one webhook id or topic. Filters apply after the limit, so a
+// filtered page may be short; follow the returned key for more.
+func ListWebhookLog(ctx context.Context, ddb *dynamodb.Client, shop, webhookID, topic string, limit int32, startKey map[string]types.AttributeValue) ([]WebhookLogEntry, map[string]types.AttributeValue, error) {
+	tbl := WebhookLogTable()
+	if tbl == "" {
+		return nil, nil, fmt.Errorf("SHOPIFY_WEBHOOK_LOG_TABLE not set")
+	}
+
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(tbl),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "SHOP#" + shop},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: startKey,
+	}
+	var filters []string
+	if webhookID != "" {
+		filters = append(filters, "WebhookId = :w")
+		in.ExpressionAttributeValues[":w"] = &types.AttributeValueMemberS{Value: webhookID}
+	}
+	if topic != "" {
+		filters = append(filters, "Topic = :t")
+		in.ExpressionAttributeValues[":t"] = &types.AttributeValueMemberS{Value: topic}
+	}
+	if len(filters) > 0 {
+		in.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+
+	out, err := ddb.Query(ctx, in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query webhook log: %w", err)
+	}
+	str := func(it map[string]types.AttributeValue, k string) string {
+		if v, ok := it[k].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	entries := make([]WebhookLogEntry, 0, len(out.Items))
+	for _, it := range out.Items {
+		at, _ := time.Parse(time.RFC3339, str(it, "TriggeredAt"))
+		entries = append(entries, WebhookLogEntry{
+			Shop:        shop,
+			WebhookID:   str(it, "WebhookId"),
+			Topic:       str(it, "Topic"),
+			TriggeredAt: at,
+			APIVersion:  str(it, "ApiVersion"),
+			Status:      str(it, "Status"),
+			ProcessedAt: str(it, "ProcessedAt"),
+		})
+	}
+	return entries, out.LastEvaluatedKey, nil
+}
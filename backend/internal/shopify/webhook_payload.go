@@ -0,0 +1,173 @@
+package shopify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Typed models of the Shopify webhooks EventBridge delivers. Only fields a
+// consumer reads are declared, so a new webhook field can't reach an alert
+// email or a stored row by accident.
+
+// WebhookEvent is the EventBridge envelope: Shopify's headers in
+// detail.metadata, its body in detail.payload. The payload stays raw so each
+// consumer decodes the model it needs.
+type WebhookEvent struct {
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Time       string `json:"time"`
+	Detail     struct {
+		Metadata WebhookMetadata `json:"metadata"`
+		Payload  json.RawMessage `json:"payload"`
+	} `json:"detail"`
+}
+
+type WebhookMetadata struct {
+	Topic       string `json:"X-Shopify-Topic"`
+	ShopDomain  string `json:"X-Shopify-Shop-Domain"`
+	WebhookID   string `json:"X-Shopify-Webhook-Id"`
+	APIVersion  string `json:"X-Shopify-API-Version"`
+	TriggeredAt string `json:"X-Shopify-Triggered-At"`
+}
+
+// ParseWebhookEvent decodes an SQS record body.
+func ParseWebhookEvent(body string) (WebhookEvent, error) {
+	var e WebhookEvent
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return e, fmt.Errorf("unmarshal eb event: %w", err)
+	}
+	return e, nil
+}
+
+// DecodePayload decodes detail.payload into v; a missing payload leaves v
+// as it is.
+func (e WebhookEvent) DecodePayload(v any) error {
+	p := bytes.TrimSpace(e.Detail.Payload)
+	if len(p) == 0 || bytes.Equal(p, []byte("null")) {
+		return nil
+	}
+	if err := json.Unmarshal(p, v); err != nil {
+		return fmt.Errorf("unmarshal payload: %w", err)
+	}
+	return nil
+}
+
+// ObjectID is a Shopify numeric id, rendered the way the workers render
+// ids read from untyped payloads (fmt %v of a float64), so it matches the
+// order ids in transaction SKs.
+type ObjectID string
+
+func (id *ObjectID) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v == nil {
+		*id = ""
+		return nil
+	}
+	*id = ObjectID(fmt.Sprintf("%v", v))
+	return nil
+}
+
+// Decimal is a Shopify money or number field, which webhooks send as a
+// string; the text is kept as sent.
+type Decimal string
+
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		*d = ""
+	case len(b) > 0 && b[0] == '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*d = Decimal(strings.TrimSpace(s))
+	default:
+		*d = Decimal(b)
+	}
+	return nil
+}
+
+// Float is d as a number, 0 when empty or malformed.
+func (d Decimal) Float() float64 {
+	f, _ := strconv.ParseFloat(string(d), 64)
+	return f
+}
+
+// OrderPayload is the body of an orders/* webhook.
+type OrderPayload struct {
+	ID                ObjectID           `json:"id"`
+	Name              string             `json:"name"`
+	FinancialStatus   string             `json:"financial_status"`
+	CurrentTotalPrice Decimal            `json:"current_total_price"`
+	TotalPrice        Decimal            `json:"total_price"`
+	Currency          string             `json:"currency"`
+	CreatedAt         string             `json:"created_at"`
+	ProcessedAt       string             `json:"processed_at"`
+	LineItems         []OrderLinePayload `json:"line_items"`
+}
+
+type OrderLinePayload struct {
+	SKU           string  `json:"sku"`
+	Title         string  `json:"title"`
+	Name          string  `json:"name"` // title with the variant
+	Quantity      int     `json:"quantity"`
+	Price         Decimal `json:"price"`
+	TotalDiscount Decimal `json:"total_discount"`
+}
+
+// Total is the order's current total, falling back to the original one.
+func (o OrderPayload) Total() Decimal {
+	if o.CurrentTotalPrice != "" {
+		return o.CurrentTotalPrice
+	}
+	return o.TotalPrice
+}
+
+// Lines are the order's line items as LineItemsFromWebhook reads them.
+func (o OrderPayload) Lines() []LineItem {
+	var out []LineItem
+	for _, p := range o.LineItems {
+		l := LineItem{
+			SKU:       strings.TrimSpace(p.SKU),
+			Title:     strings.TrimSpace(p.Title),
+			Quantity:  p.Quantity,
+			UnitPrice: p.Price.Float(),
+			Discount:  p.TotalDiscount.Float(),
+		}
+		if name := strings.TrimSpace(p.Name); name != "" {
+			l.Title = name
+		}
+		if l.Quantity > 0 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// TopLines returns the n lines that sold for the most, in that order.
+func TopLines(lines []LineItem, n int) []LineItem {
+	top := append([]LineItem(nil), lines...)
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Revenue() > top[j].Revenue() })
+	if n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
+
+// RefundPayload is the body of a refunds/create webhook. The refunded
+// amount is read by RefundAmount from the untyped payload.
+type RefundPayload struct {
+	ID          ObjectID `json:"id"`
+	OrderID     ObjectID `json:"order_id"`
+	Currency    string   `json:"currency"`
+	CreatedAt   string   `json:"created_at"`
+	ProcessedAt string   `json:"processed_at"`
+}
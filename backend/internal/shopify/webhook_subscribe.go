@@ -0,0 +1,139 @@
+package shopify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/shadow"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Webhook subscription runs as a job on SHOPIFY_WEBHOOK_SUBSCRIBE_QUEUE_URL
+// rather than inline in the OAuth callback, so a Shopify hiccup is retried
+// instead of leaving the shop connected without webhooks. Progress is kept
+// on the integrations item:
+//
+//	WebhooksStatus = queued | retrying | subscribed | failed
+//	Webhooks       = [{topic, status, error, attempts, updatedAt}, ...]
+const (
+	WebhooksQueued     = "queued"
+	WebhooksRetrying   = "retrying"
+	WebhooksSubscribed = "subscribed"
+	WebhooksFailed     = "failed"
+
+	WebhookTopicSubscribed = "subscribed"
+	WebhookTopicFailed     = "failed"
+	WebhookTopicPending    = "pending"
+)
+
+// MaxWebhookSubscribeAttempts bounds the retries of one connect; after it
+// the shop stays "failed" until reconnected or resubscribed.
+const MaxWebhookSubscribeAttempts = 6
+
+// WebhookTopicStatus is the subscription state of one topic.
+type WebhookTopicStatus struct {
+	Topic     string `dynamodbav:"Topic" json:"topic"`
+	Status    string `dynamodbav:"Status" json:"status"`
+	Error     string `dynamodbav:"Error,omitempty" json:"error,omitempty"`
+	Attempts  int    `dynamodbav:"Attempts" json:"attempts"`
+	UpdatedAt string `dynamodbav:"UpdatedAt" json:"updatedAt"`
+}
+
+// WebhookSubscribeJob is the SHOPIFY_WEBHOOK_SUBSCRIBE_QUEUE_URL message.
+type WebhookSubscribeJob struct {
+	Sub     string `json:"sub"`
+	Shop    string `json:"shop"`
+	Attempt int    `json:"attempt"` // 1-based
+}
+
+// EnqueueWebhookSubscribe marks every topic pending and queues the first
+// attempt.
+func EnqueueWebhookSubscribe(ctx context.Context, ddb *dynamodb.Client, sub, shop string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	pending := make([]WebhookTopicStatus, 0, len(EventBridgeTopics))
+	for _, t := range EventBridgeTopics {
+		pending = append(pending, WebhookTopicStatus{Topic: t, Status: WebhookTopicPending, UpdatedAt: now})
+	}
+	if err := RecordWebhookStatus(ctx, ddb, sub, shop, WebhooksQueued, pending); err != nil {
+		return err
+	}
+	return SendWebhookSubscribe(ctx, WebhookSubscribeJob{Sub: sub, Shop: shop, Attempt: 1}, 0)
+}
+
+// SendWebhookSubscribe queues job, delivered after delay (at most 15 minutes).
+func SendWebhookSubscribe(ctx context.Context, job WebhookSubscribeJob, delay time.Duration) error {
+	queueURL := strings.TrimSpace(os.Getenv("SHOPIFY_WEBHOOK_SUBSCRIBE_QUEUE_URL"))
+	if queueURL == "" {
+		return fmt.Errorf("SHOPIFY_WEBHOOK_SUBSCRIBE_QUEUE_URL not set")
+	}
+	if delay > 15*time.Minute {
+		delay = 15 * time.Minute
+	}
+	cfg, err := shadow.LoadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(job)
+	_, err = sqs.NewFromConfig(cfg).SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:     aws.String(queueURL),
+		MessageBody:  aws.String(string(b)),
+		DelaySeconds: int32(delay / time.Second),
+	})
+	return err
+}
+
+// WebhookRetryDelay is the wait before attempt n+1: 30s, 1m, 2m, ... 15m.
+func WebhookRetryDelay(n int) time.Duration {
+	d := 30 * time.Second << (n - 1)
+	if n > 6 || d > 15*time.Minute {
+		return 15 * time.Minute
+	}
+	return d
+}
+
+// OverallWebhookStatus folds per-topic results into WebhooksStatus; retry
+// says whether another attempt will follow a failure.
+func OverallWebhookStatus(topics []WebhookTopicStatus, retry bool) string {
+	for _, t := range topics {
+		if t.Status != WebhookTopicSubscribed {
+			if retry {
+				return WebhooksRetrying
+			}
+			return WebhooksFailed
+		}
+	}
+	return WebhooksSubscribed
+}
+
+// RecordWebhookStatus stores the subscription state on the integration. Like
+// the initial sync state, it never recreates a disconnected integration.
+func RecordWebhookStatus(ctx context.Context, ddb *dynamodb.Client, sub, shop, overall string, topics []WebhookTopicStatus) error {
+	av, err := attributevalue.Marshal(topics)
+	if err != nil {
+		return err
+	}
+	err = updateInitialSync(ctx, ddb, sub, shop,
+		"SET WebhooksStatus = :s, Webhooks = :w, WebhooksUpdatedAt = :now",
+		map[string]types.AttributeValue{
+			":s":   &types.AttributeValueMemberS{Value: overall},
+			":w":   av,
+			":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		})
+	if errors.Is(err, ErrIntegrationGone) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("record webhook status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,457 @@
+package sqlast
+
+// The AST covers the read-only subset of Trino that Athena runs: a query
+// with its WITH clause, set operations, SELECTs with joins and subqueries,
+// and the expression forms the model writes. Names are lowercased; a
+// qualified name is split into its parts.
+
+// Node is any node of the tree.
+type Node interface{ node() }
+
+// Query is a full query: WITH, a body, ORDER BY and LIMIT/OFFSET/FETCH.
+type Query struct {
+	With    []*CTE
+	Body    QueryBody
+	OrderBy []*SortItem
+	Limit   string // "all", a number, or "" when absent
+	Offset  string
+}
+
+// CTE is one WITH entry.
+type CTE struct {
+	Name    string
+	Columns []string
+	Query   *Query
+}
+
+// QueryBody is a *Select, a *SetOp or a *ParenQuery.
+type QueryBody interface {
+	Node
+	queryBody()
+}
+
+// Select is one SELECT block.
+type Select struct {
+	Distinct bool
+	Items    []*SelectItem
+	From     []Relation // several when comma-separated
+	Where    Expr
+	GroupBy  []Expr // ROLLUP, CUBE and GROUPING SETS flattened
+	Having   Expr
+	Windows  []*NamedWindow
+}
+
+// SetOp is UNION, INTERSECT or EXCEPT.
+type SetOp struct {
+	Op          string // "union", "intersect", "except"
+	All         bool
+	Left, Right QueryBody
+}
+
+// ParenQuery is a parenthesized query used as a set operand.
+type ParenQuery struct{ Query *Query }
+
+// SelectItem is an output column; Star for * or qualifier.*.
+type SelectItem struct {
+	Expr      Expr
+	Alias     string
+	Star      bool
+	Qualifier []string
+}
+
+// SortItem is an ORDER BY entry.
+type SortItem struct {
+	Expr       Expr
+	Desc       bool
+	NullsFirst *bool
+}
+
+// NamedWindow is a WINDOW clause entry.
+type NamedWindow struct {
+	Name   string
+	Window *Window
+}
+
+// Relation is a *Table, *Derived, *Unnest or *Join.
+type Relation interface {
+	Node
+	relation()
+}
+
+// Table is a reference to a table or WITH name, with its alias.
+type Table struct {
+	Name     []string // catalog.schema.table parts; last is the table
+	Alias    string
+	Pos, End int // byte offsets of the name in the parsed SQL
+}
+
+// Derived is a subquery in FROM.
+type Derived struct {
+	Query   *Query
+	Alias   string
+	Lateral bool
+}
+
+// Unnest is UNNEST(...) in FROM.
+type Unnest struct {
+	Args  []Expr
+	Alias string
+}
+
+// Join kinds.
+const (
+	JoinInner = "inner"
+	JoinLeft  = "left"
+	JoinRight = "right"
+	JoinFull  = "full"
+	JoinCross = "cross"
+)
+
+// Join is an explicit JOIN of two relations.
+type Join struct {
+	Kind        string
+	Natural     bool
+	Left, Right Relation
+	On          Expr
+	Using       []string
+}
+
+// Expr is an expression.
+type Expr interface {
+	Node
+	expr()
+}
+
+// Ident is a column or a qualified column reference.
+type Ident struct{ Parts []string }
+
+// Literal kinds.
+const (
+	LitString    = "string"
+	LitNumber    = "number"
+	LitBool      = "bool"
+	LitNull      = "null"
+	LitTyped     = "typed" // DATE '...', TIMESTAMP '...'; Type holds the type
+	LitInterval  = "interval"
+	LitParameter = "parameter"
+)
+
+// Literal is a constant. Value is the unescaped text.
+type Literal struct {
+	Kind  string
+	Type  string
+	Value string
+}
+
+// Binary is a binary operator: and, or, comparisons (with an "any"/"all"
+// quantifier folded into Op, e.g. "= any"), arithmetic, ||, "is distinct
+// from", "at time zone".
+type Binary struct {
+	Op          string
+	Left, Right Expr
+}
+
+// Unary is not, - or +.
+type Unary struct {
+	Op string
+	X  Expr
+}
+
+// Between is X [NOT] BETWEEN Low AND High.
+type Between struct {
+	X, Low, High Expr
+	Not          bool
+}
+
+// In is X [NOT] IN (List) or X [NOT] IN (Query).
+type In struct {
+	X     Expr
+	List  []Expr
+	Query *Query
+	Not   bool
+}
+
+// Like is X [NOT] LIKE Pattern [ESCAPE Escape].
+type Like struct {
+	X, Pattern, Escape Expr
+	Not                bool
+}
+
+// IsNull is X IS [NOT] NULL.
+type IsNull struct {
+	X   Expr
+	Not bool
+}
+
+// Call is a function call, aggregate or window function. extract(field
+// FROM x) is a Call with the field as a Keyword first argument.
+type Call struct {
+	Name     string
+	Args     []Expr
+	Star     bool
+	Distinct bool
+	OrderBy  []*SortItem
+	Filter   Expr
+	Over     *Window
+}
+
+// Keyword is a bare keyword argument, e.g. the field of extract.
+type Keyword struct{ Name string }
+
+// Window is an OVER specification. Of the frame only the bound offsets are
+// kept.
+type Window struct {
+	Ref         string
+	PartitionBy []Expr
+	OrderBy     []*SortItem
+	Frame       []Expr // bound offsets, e.g. 3 in "3 PRECEDING"
+}
+
+// Cast is CAST or TRY_CAST; Type is the type text.
+type Cast struct {
+	X    Expr
+	Type string
+	Try  bool
+}
+
+// Case is a simple (Operand set) or searched CASE.
+type Case struct {
+	Operand Expr
+	Whens   []*When
+	Else    Expr
+}
+
+// When is one WHEN ... THEN ... of a Case.
+type When struct{ Cond, Result Expr }
+
+// Subquery is a scalar subquery, or the right side of a quantified comparison.
+type Subquery struct{ Query *Query }
+
+// Exists is EXISTS (query).
+type Exists struct{ Query *Query }
+
+// Row is a parenthesized list, (a, b).
+type Row struct{ Items []Expr }
+
+// Array is ARRAY[...].
+type Array struct{ Items []Expr }
+
+// Subscript is X[Index].
+type Subscript struct{ X, Index Expr }
+
+// Deref is a field of a row value, e.g. (x).f.
+type Deref struct {
+	X     Expr
+	Field string
+}
+
+// Lambda is x -> body or (x, y) -> body.
+type Lambda struct {
+	Params []string
+	Body   Expr
+}
+
+func (*Query) node()       {}
+func (*CTE) node()         {}
+func (*Select) node()      {}
+func (*SetOp) node()       {}
+func (*ParenQuery) node()  {}
+func (*SelectItem) node()  {}
+func (*SortItem) node()    {}
+func (*NamedWindow) node() {}
+func (*Table) node()       {}
+func (*Derived) node()     {}
+func (*Unnest) node()      {}
+func (*Join) node()        {}
+func (*Ident) node()       {}
+func (*Literal) node()     {}
+func (*Binary) node()      {}
+func (*Unary) node()       {}
+func (*Between) node()     {}
+func (*In) node()          {}
+func (*Like) node()        {}
+func (*IsNull) node()      {}
+func (*Call) node()        {}
+func (*Keyword) node()     {}
+func (*Window) node()      {}
+func (*Cast) node()        {}
+func (*Case) node()        {}
+func (*When) node()        {}
+func (*Subquery) node()    {}
+func (*Exists) node()      {}
+func (*Row) node()         {}
+func (*Array) node()       {}
+func (*Subscript) node()   {}
+func (*Deref) node()       {}
+func (*Lambda) node()      {}
+
+func (*Select) queryBody()     {}
+func (*SetOp) queryBody()      {}
+func (*ParenQuery) queryBody() {}
+
+func (*Table) relation()   {}
+func (*Derived) relation() {}
+func (*Unnest) relation()  {}
+func (*Join) relation()    {}
+
+func (*Ident) expr()     {}
+func (*Literal) expr()   {}
+func (*Binary) expr()    {}
+func (*Unary) expr()     {}
+func (*Between) expr()   {}
+func (*In) expr()        {}
+func (*Like) expr()      {}
+func (*IsNull) expr()    {}
+func (*Call) expr()      {}
+func (*Keyword) expr()   {}
+func (*Cast) expr()      {}
+func (*Case) expr()      {}
+func (*Subquery) expr()  {}
+func (*Exists) expr()    {}
+func (*Row) expr()       {}
+func (*Array) expr()     {}
+func (*Subscript) expr() {}
+func (*Deref) expr()     {}
+func (*Lambda) expr()    {}
+
+// Inspect walks the tree rooted at n depth-first, like go/ast.Inspect:
+// f is called for each node and its children are visited while f returns
+// true.
+func Inspect(n Node, f func(Node) bool) {
+	if isNil(n) || !f(n) {
+		return
+	}
+	walk := func(c Node) { Inspect(c, f) }
+	switch n := n.(type) {
+	case *Query:
+		for _, c := range n.With {
+			walk(c)
+		}
+		walk(n.Body)
+		for _, s := range n.OrderBy {
+			walk(s)
+		}
+	case *CTE:
+		walk(n.Query)
+	case *Select:
+		for _, it := range n.Items {
+			walk(it)
+		}
+		for _, r := range n.From {
+			walk(r)
+		}
+		walk(n.Where)
+		for _, g := range n.GroupBy {
+			walk(g)
+		}
+		walk(n.Having)
+		for _, w := range n.Windows {
+			walk(w)
+		}
+	case *SetOp:
+		walk(n.Left)
+		walk(n.Right)
+	case *ParenQuery:
+		walk(n.Query)
+	case *SelectItem:
+		walk(n.Expr)
+	case *SortItem:
+		walk(n.Expr)
+	case *NamedWindow:
+		walk(n.Window)
+	case *Derived:
+		walk(n.Query)
+	case *Unnest:
+		for _, a := range n.Args {
+			walk(a)
+		}
+	case *Join:
+		walk(n.Left)
+		walk(n.Right)
+		walk(n.On)
+	case *Binary:
+		walk(n.Left)
+		walk(n.Right)
+	case *Unary:
+		walk(n.X)
+	case *Between:
+		walk(n.X)
+		walk(n.Low)
+		walk(n.High)
+	case *In:
+		walk(n.X)
+		for _, e := range n.List {
+			walk(e)
+		}
+		walk(n.Query)
+	case *Like:
+		walk(n.X)
+		walk(n.Pattern)
+		walk(n.Escape)
+	case *IsNull:
+		walk(n.X)
+	case *Call:
+		for _, a := range n.Args {
+			walk(a)
+		}
+		for _, s := range n.OrderBy {
+			walk(s)
+		}
+		walk(n.Filter)
+		walk(n.Over)
+	case *Window:
+		for _, e := range n.PartitionBy {
+			walk(e)
+		}
+		for _, s := range n.OrderBy {
+			walk(s)
+		}
+		for _, e := range n.Frame {
+			walk(e)
+		}
+	case *Cast:
+		walk(n.X)
+	case *Case:
+		walk(n.Operand)
+		for _, w := range n.Whens {
+			walk(w)
+		}
+		walk(n.Else)
+	case *When:
+		walk(n.Cond)
+		walk(n.Result)
+	case *Subquery:
+		walk(n.Query)
+	case *Exists:
+		walk(n.Query)
+	case *Row:
+		for _, e := range n.Items {
+			walk(e)
+		}
+	case *Array:
+		for _, e := range n.Items {
+			walk(e)
+		}
+	case *Subscript:
+		walk(n.X)
+		walk(n.Index)
+	case *Deref:
+		walk(n.X)
+	case *Lambda:
+		walk(n.Body)
+	}
+}
+
+// isNil reports a nil interface or a typed nil pointer, so optional
+// children can be passed to Inspect unchecked.
+func isNil(n Node) bool {
+	switch n := n.(type) {
+	case nil:
+		return true
+	case *Query:
+		return n == nil
+	case *Window:
+		return n == nil
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package sqlast
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The lexer splits Athena (Trino) SQL into tokens. Unquoted identifiers and
+// keywords are lowercased, as Trino folds them; quoted identifiers keep
+// their text. Semicolons and comments are rejected outright: the model
+// never needs them and both are classic ways to smuggle a second statement
+// or hide part of one from a reviewer.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // lowercased for tokIdent, unescaped for tokString/tokQuotedIdent
+	pos  int
+	end  int
+}
+
+// SyntaxError is a lexing or parsing failure at byte offset Pos.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("sql syntax error at %d: %s", e.Pos, e.Msg)
+}
+
+// ErrComment and ErrSemicolon carry the lexer's two policy rejections.
+var (
+	ErrComment   = errors.New("comments not allowed")
+	ErrSemicolon = errors.New("semicolon not allowed")
+)
+
+// twoCharOps are matched before single characters.
+var twoCharOps = []string{"<>", "!=", "<=", ">=", "||", "->", "=>"}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+
+		case c == ';':
+			return nil, ErrSemicolon
+
+		case c == '-' && i+1 < len(src) && src[i+1] == '-',
+			c == '/' && i+1 < len(src) && src[i+1] == '*':
+			return nil, ErrComment
+
+		case c == '\'':
+			start := i
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, &SyntaxError{Pos: start, Msg: "unterminated string"}
+				}
+				if src[i] == '\'' {
+					if i+1 < len(src) && src[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			toks = append(toks, token{kind: tokString, text: b.String(), pos: start, end: i})
+
+		case c == '"' || c == '`':
+			start := i
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, &SyntaxError{Pos: start, Msg: "unterminated quoted identifier"}
+				}
+				if src[i] == c {
+					if i+1 < len(src) && src[i+1] == c {
+						b.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			// Trino identifiers are case-insensitive, quoted or not.
+			toks = append(toks, token{kind: tokQuotedIdent, text: strings.ToLower(b.String()), pos: start, end: i})
+
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[start:i], pos: start, end: i})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: strings.ToLower(src[start:i]), pos: start, end: i})
+
+		default:
+			start := i
+			op := ""
+			for _, o := range twoCharOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("()[],.*+-/%=<>?:", rune(c)) {
+					return nil, &SyntaxError{Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+				}
+				op = string(c)
+			}
+			i += len(op)
+			toks = append(toks, token{kind: tokOp, text: op, pos: start, end: i})
+		}
+	}
+	toks = append(toks, token{kind: tokEOF, pos: len(src), end: len(src)})
+	return toks, nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentPart(c byte) bool { return isIdentStart(c) || isDigit(c) || c == '@' || c == '$' }
@@ -0,0 +1,986 @@
+package sqlast
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotQuery is returned by Parse for any statement other than a query.
+var ErrNotQuery = errors.New("not a query")
+
+// reserved words end an expression or a relation, so they are never taken
+// as an implicit alias or a bare column name.
+var reserved = map[string]bool{
+	"all": true, "and": true, "as": true, "asc": true, "between": true, "by": true,
+	"case": true, "cross": true, "desc": true, "distinct": true, "else": true,
+	"end": true, "escape": true, "except": true, "exists": true, "fetch": true,
+	"from": true, "full": true, "group": true, "having": true, "in": true,
+	"inner": true, "intersect": true, "is": true, "join": true, "left": true,
+	"like": true, "limit": true, "natural": true, "not": true, "nulls": true,
+	"offset": true, "on": true, "or": true, "order": true, "outer": true,
+	"right": true, "select": true, "then": true, "union": true, "using": true,
+	"when": true, "where": true, "window": true, "with": true,
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// Parse parses a single query. Other statements fail with ErrNotQuery;
+// anything else that does not parse with a *SyntaxError.
+func Parse(sql string) (q *Query, err error) {
+	toks, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	if t := p.peek(); !(p.isKw("select") || p.isKw("with") || p.isOp("(")) {
+		if t.kind == tokEOF {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "empty statement"}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotQuery, t.text)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			q, err = nil, se
+		}
+	}()
+	q = p.query()
+	if t := p.peek(); t.kind != tokEOF {
+		p.fail("unexpected %q after query", t.text)
+	}
+	return q, nil
+}
+
+// ---- token helpers ----
+
+func (p *parser) peek() token { return p.peekN(0) }
+
+func (p *parser) peekN(n int) token {
+	if p.pos+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) fail(format string, args ...any) {
+	panic(&SyntaxError{Pos: p.peek().pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// isKw reports whether the next tokens are the keywords words.
+func (p *parser) isKw(words ...string) bool {
+	for i, w := range words {
+		if t := p.peekN(i); t.kind != tokIdent || t.text != w {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *parser) acceptKw(words ...string) bool {
+	if !p.isKw(words...) {
+		return false
+	}
+	p.pos += len(words)
+	return true
+}
+
+func (p *parser) expectKw(words ...string) {
+	if !p.acceptKw(words...) {
+		p.fail("expected %s", strings.ToUpper(strings.Join(words, " ")))
+	}
+}
+
+func (p *parser) isOp(op string) bool { return p.opAt(0, op) }
+
+// opAt reports whether the token n ahead is the operator op.
+func (p *parser) opAt(n int, op string) bool {
+	t := p.peekN(n)
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if !p.isOp(op) {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *parser) expectOp(op string) {
+	if !p.acceptOp(op) {
+		p.fail("expected %q", op)
+	}
+}
+
+// isName reports whether the next token can be an identifier.
+func (p *parser) isName() bool {
+	t := p.peek()
+	return t.kind == tokQuotedIdent || (t.kind == tokIdent && !reserved[t.text])
+}
+
+func (p *parser) name() string {
+	if !p.isName() {
+		p.fail("expected identifier")
+	}
+	return p.next().text
+}
+
+// startsQuery reports whether the parenthesis at the current position opens
+// a query rather than an expression or a relation.
+func (p *parser) startsQuery() bool {
+	i := 0
+	for p.opAt(i, "(") {
+		i++
+	}
+	t := p.peekN(i)
+	return i > 0 && t.kind == tokIdent && (t.text == "select" || t.text == "with")
+}
+
+// try runs f and reports whether it parsed, rewinding on failure.
+func (p *parser) try(f func()) (ok bool) {
+	save := p.pos
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isSyntax := r.(*SyntaxError); !isSyntax {
+				panic(r)
+			}
+			p.pos, ok = save, false
+		}
+	}()
+	f()
+	return true
+}
+
+// ---- queries ----
+
+func (p *parser) query() *Query {
+	q := &Query{}
+	if p.acceptKw("with") {
+		p.acceptKw("recursive")
+		for {
+			c := &CTE{Name: p.name()}
+			if p.isOp("(") {
+				c.Columns = p.nameList()
+			}
+			p.expectKw("as")
+			p.expectOp("(")
+			c.Query = p.query()
+			p.expectOp(")")
+			q.With = append(q.With, c)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	q.Body = p.setOps()
+	if p.acceptKw("order", "by") {
+		q.OrderBy = p.sortItems()
+	}
+	if p.acceptKw("offset") {
+		q.Offset = p.number()
+		_ = p.acceptKw("row") || p.acceptKw("rows")
+	}
+	switch {
+	case p.acceptKw("limit"):
+		if p.acceptKw("all") {
+			q.Limit = "all"
+		} else {
+			q.Limit = p.number()
+		}
+	case p.acceptKw("fetch"):
+		_ = p.acceptKw("first") || p.acceptKw("next")
+		q.Limit = "1"
+		if p.peek().kind == tokNumber {
+			q.Limit = p.number()
+		}
+		_ = p.acceptKw("row") || p.acceptKw("rows")
+		if !p.acceptKw("only") {
+			p.expectKw("with", "ties")
+		}
+	}
+	return q
+}
+
+func (p *parser) number() string {
+	if p.peek().kind != tokNumber {
+		p.fail("expected number")
+	}
+	return p.next().text
+}
+
+func (p *parser) nameList() []string {
+	p.expectOp("(")
+	var out []string
+	for {
+		out = append(out, p.name())
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	p.expectOp(")")
+	return out
+}
+
+func (p *parser) setOps() QueryBody {
+	left := p.queryTerm()
+	for {
+		var op string
+		switch {
+		case p.acceptKw("union"):
+			op = "union"
+		case p.acceptKw("intersect"):
+			op = "intersect"
+		case p.acceptKw("except"):
+			op = "except"
+		default:
+			return left
+		}
+		s := &SetOp{Op: op, Left: left}
+		if p.acceptKw("all") {
+			s.All = true
+		} else {
+			p.acceptKw("distinct")
+		}
+		s.Right = p.queryTerm()
+		left = s
+	}
+}
+
+func (p *parser) queryTerm() QueryBody {
+	if p.acceptOp("(") {
+		q := p.query()
+		p.expectOp(")")
+		return &ParenQuery{Query: q}
+	}
+	if !p.acceptKw("select") {
+		p.fail("expected SELECT")
+	}
+	s := &Select{}
+	if p.acceptKw("distinct") {
+		s.Distinct = true
+	} else {
+		p.acceptKw("all")
+	}
+	for {
+		s.Items = append(s.Items, p.selectItem())
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if p.acceptKw("from") {
+		for {
+			s.From = append(s.From, p.relation())
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKw("where") {
+		s.Where = p.expr()
+	}
+	if p.acceptKw("group", "by") {
+		_ = p.acceptKw("all") || p.acceptKw("distinct")
+		s.GroupBy = p.groupingElements()
+	}
+	if p.acceptKw("having") {
+		s.Having = p.expr()
+	}
+	if p.acceptKw("window") {
+		for {
+			nw := &NamedWindow{Name: p.name()}
+			p.expectKw("as")
+			nw.Window = p.windowSpec()
+			s.Windows = append(s.Windows, nw)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	return s
+}
+
+func (p *parser) selectItem() *SelectItem {
+	if p.acceptOp("*") {
+		return &SelectItem{Star: true}
+	}
+	// qualifier.*
+	i := 0
+	for {
+		t := p.peekN(i)
+		if !(t.kind == tokQuotedIdent || (t.kind == tokIdent && !reserved[t.text])) {
+			break
+		}
+		if !p.opAt(i+1, ".") {
+			break
+		}
+		if p.opAt(i+2, "*") {
+			item := &SelectItem{Star: true}
+			for j := 0; j <= i; j += 2 {
+				item.Qualifier = append(item.Qualifier, p.peekN(j).text)
+			}
+			p.pos += i + 3
+			return item
+		}
+		i += 2
+	}
+	item := &SelectItem{Expr: p.expr()}
+	item.Alias = p.alias()
+	return item
+}
+
+// alias parses an optional [AS] name.
+func (p *parser) alias() string {
+	if p.acceptKw("as") {
+		return p.name()
+	}
+	if p.isName() {
+		return p.next().text
+	}
+	return ""
+}
+
+func (p *parser) groupingElements() []Expr {
+	var out []Expr
+	for {
+		switch {
+		case p.isKw("rollup") && p.opAt(1, "("),
+			p.isKw("cube") && p.opAt(1, "("):
+			p.next()
+			out = append(out, p.parenExprs()...)
+		case p.acceptKw("grouping", "sets"):
+			p.expectOp("(")
+			for {
+				if p.isOp("(") {
+					out = append(out, p.parenExprs()...)
+				} else {
+					out = append(out, p.expr())
+				}
+				if !p.acceptOp(",") {
+					break
+				}
+			}
+			p.expectOp(")")
+		default:
+			out = append(out, p.expr())
+		}
+		if !p.acceptOp(",") {
+			return out
+		}
+	}
+}
+
+// parenExprs parses "(e, ...)", possibly empty.
+func (p *parser) parenExprs() []Expr {
+	p.expectOp("(")
+	var out []Expr
+	if p.acceptOp(")") {
+		return out
+	}
+	for {
+		out = append(out, p.expr())
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	p.expectOp(")")
+	return out
+}
+
+func (p *parser) sortItems() []*SortItem {
+	var out []*SortItem
+	for {
+		s := &SortItem{Expr: p.expr()}
+		if p.acceptKw("desc") {
+			s.Desc = true
+		} else {
+			p.acceptKw("asc")
+		}
+		if p.acceptKw("nulls") {
+			first := p.acceptKw("first")
+			if !first {
+				p.expectKw("last")
+			}
+			s.NullsFirst = &first
+		}
+		out = append(out, s)
+		if !p.acceptOp(",") {
+			return out
+		}
+	}
+}
+
+// ---- relations ----
+
+func (p *parser) relation() Relation {
+	left := p.primaryRelation()
+	for {
+		j := &Join{Left: left}
+		if p.acceptKw("cross", "join") {
+			j.Kind = JoinCross
+			j.Right = p.primaryRelation()
+			left = j
+			continue
+		}
+		j.Natural = p.acceptKw("natural")
+		switch {
+		case p.acceptKw("join"), p.acceptKw("inner", "join"):
+			j.Kind = JoinInner
+		case p.acceptKw("left"):
+			j.Kind = JoinLeft
+		case p.acceptKw("right"):
+			j.Kind = JoinRight
+		case p.acceptKw("full"):
+			j.Kind = JoinFull
+		default:
+			if j.Natural {
+				p.fail("expected JOIN")
+			}
+			return left
+		}
+		if j.Kind != JoinInner {
+			p.acceptKw("outer")
+			p.expectKw("join")
+		}
+		j.Right = p.primaryRelation()
+		switch {
+		case j.Natural:
+		case p.acceptKw("on"):
+			j.On = p.expr()
+		case p.acceptKw("using"):
+			j.Using = p.nameList()
+		default:
+			p.fail("expected ON or USING")
+		}
+		left = j
+	}
+}
+
+func (p *parser) primaryRelation() Relation {
+	switch {
+	case p.acceptKw("lateral"):
+		p.expectOp("(")
+		d := &Derived{Query: p.query(), Lateral: true}
+		p.expectOp(")")
+		d.Alias = p.relationAlias()
+		return d
+	case p.isKw("unnest") && p.opAt(1, "("):
+		p.next()
+		u := &Unnest{Args: p.parenExprs()}
+		p.acceptKw("with", "ordinality")
+		u.Alias = p.relationAlias()
+		return u
+	case p.isOp("("):
+		if p.startsQuery() {
+			var d *Derived
+			if p.try(func() {
+				p.expectOp("(")
+				d = &Derived{Query: p.query()}
+				p.expectOp(")")
+			}) {
+				d.Alias = p.relationAlias()
+				return d
+			}
+		}
+		p.expectOp("(")
+		r := p.relation()
+		p.expectOp(")")
+		return r
+	}
+	t := &Table{Pos: p.peek().pos, Name: []string{p.name()}}
+	for p.acceptOp(".") {
+		t.Name = append(t.Name, p.name())
+	}
+	t.End = p.toks[p.pos-1].end
+	t.Alias = p.relationAlias()
+	return t
+}
+
+// relationAlias parses an optional [AS] alias [(columns)].
+func (p *parser) relationAlias() string {
+	a := p.alias()
+	if a != "" && p.isOp("(") {
+		p.nameList()
+	}
+	return a
+}
+
+// ---- expressions ----
+
+func (p *parser) expr() Expr { return p.or() }
+
+func (p *parser) or() Expr {
+	e := p.and()
+	for p.acceptKw("or") {
+		e = &Binary{Op: "or", Left: e, Right: p.and()}
+	}
+	return e
+}
+
+func (p *parser) and() Expr {
+	e := p.not()
+	for p.acceptKw("and") {
+		e = &Binary{Op: "and", Left: e, Right: p.not()}
+	}
+	return e
+}
+
+func (p *parser) not() Expr {
+	if p.acceptKw("not") {
+		return &Unary{Op: "not", X: p.not()}
+	}
+	return p.predicate()
+}
+
+var comparisons = map[string]string{"=": "=", "<>": "<>", "!=": "<>", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+func (p *parser) predicate() Expr {
+	e := p.value()
+	for {
+		if t := p.peek(); t.kind == tokOp && comparisons[t.text] != "" {
+			p.next()
+			op := comparisons[t.text]
+			if q := p.peek().text; (p.isKw("any") || p.isKw("some") || p.isKw("all")) && p.opAt(1, "(") {
+				p.next()
+				if q == "some" {
+					q = "any"
+				}
+				p.expectOp("(")
+				sub := p.query()
+				p.expectOp(")")
+				e = &Binary{Op: op + " " + q, Left: e, Right: &Subquery{Query: sub}}
+				continue
+			}
+			e = &Binary{Op: op, Left: e, Right: p.value()}
+			continue
+		}
+		if p.acceptKw("is") {
+			not := p.acceptKw("not")
+			if p.acceptKw("null") {
+				e = &IsNull{X: e, Not: not}
+				continue
+			}
+			p.expectKw("distinct", "from")
+			op := "is distinct from"
+			if not {
+				op = "is not distinct from"
+			}
+			e = &Binary{Op: op, Left: e, Right: p.value()}
+			continue
+		}
+		not := false
+		if p.isKw("not", "between") || p.isKw("not", "in") || p.isKw("not", "like") {
+			p.next()
+			not = true
+		}
+		switch {
+		case p.acceptKw("between"):
+			b := &Between{X: e, Not: not, Low: p.value()}
+			p.expectKw("and")
+			b.High = p.value()
+			e = b
+		case p.acceptKw("in"):
+			in := &In{X: e, Not: not}
+			if p.startsQuery() {
+				p.expectOp("(")
+				in.Query = p.query()
+				p.expectOp(")")
+			} else {
+				in.List = p.parenExprs()
+			}
+			e = in
+		case p.acceptKw("like"):
+			l := &Like{X: e, Not: not, Pattern: p.value()}
+			if p.acceptKw("escape") {
+				l.Escape = p.value()
+			}
+			e = l
+		default:
+			return e
+		}
+	}
+}
+
+// value is the arithmetic level: ||, then + -, then * / %.
+func (p *parser) value() Expr {
+	e := p.additive()
+	for p.acceptOp("||") {
+		e = &Binary{Op: "||", Left: e, Right: p.additive()}
+	}
+	return e
+}
+
+func (p *parser) additive() Expr {
+	e := p.multiplicative()
+	for p.isOp("+") || p.isOp("-") {
+		op := p.next().text
+		e = &Binary{Op: op, Left: e, Right: p.multiplicative()}
+	}
+	return e
+}
+
+func (p *parser) multiplicative() Expr {
+	e := p.unary()
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		op := p.next().text
+		e = &Binary{Op: op, Left: e, Right: p.unary()}
+	}
+	return e
+}
+
+func (p *parser) unary() Expr {
+	if p.isOp("-") || p.isOp("+") {
+		op := p.next().text
+		return &Unary{Op: op, X: p.unary()}
+	}
+	e := p.postfix()
+	if p.acceptKw("at", "time", "zone") {
+		e = &Binary{Op: "at time zone", Left: e, Right: p.postfix()}
+	}
+	return e
+}
+
+func (p *parser) postfix() Expr {
+	e := p.primary()
+	for {
+		switch {
+		case p.acceptOp("["):
+			e = &Subscript{X: e, Index: p.expr()}
+			p.expectOp("]")
+		case p.isOp(".") && (p.peekN(1).kind == tokIdent || p.peekN(1).kind == tokQuotedIdent):
+			p.next()
+			f := p.next().text
+			if id, ok := e.(*Ident); ok {
+				id.Parts = append(id.Parts, f)
+			} else {
+				e = &Deref{X: e, Field: f}
+			}
+		default:
+			return e
+		}
+	}
+}
+
+// niladic are the functions written without parentheses.
+var niladic = map[string]bool{
+	"current_date": true, "current_time": true, "current_timestamp": true,
+	"localtime": true, "localtimestamp": true,
+}
+
+func (p *parser) primary() Expr {
+	t := p.peek()
+	switch t.kind {
+	case tokString:
+		p.next()
+		return &Literal{Kind: LitString, Value: t.text}
+	case tokNumber:
+		p.next()
+		return &Literal{Kind: LitNumber, Value: t.text}
+	case tokOp:
+		switch t.text {
+		case "?":
+			p.next()
+			return &Literal{Kind: LitParameter, Value: "?"}
+		case "(":
+			return p.parenExpr()
+		}
+		p.fail("unexpected %q", t.text)
+	case tokEOF:
+		p.fail("unexpected end of query")
+	}
+
+	// identifiers and keyword-led expressions
+	if t.kind == tokIdent {
+		switch t.text {
+		case "null":
+			p.next()
+			return &Literal{Kind: LitNull}
+		case "true", "false":
+			p.next()
+			return &Literal{Kind: LitBool, Value: t.text}
+		case "case":
+			return p.caseExpr()
+		case "cast", "try_cast":
+			p.next()
+			p.expectOp("(")
+			c := &Cast{X: p.expr(), Try: t.text == "try_cast"}
+			p.expectKw("as")
+			c.Type = p.typeText()
+			p.expectOp(")")
+			return c
+		case "exists":
+			p.next()
+			p.expectOp("(")
+			e := &Exists{Query: p.query()}
+			p.expectOp(")")
+			return e
+		case "array":
+			if p.opAt(1, "[") {
+				p.pos += 2
+				a := &Array{}
+				for !p.acceptOp("]") {
+					a.Items = append(a.Items, p.expr())
+					if !p.acceptOp(",") {
+						p.expectOp("]")
+						break
+					}
+				}
+				return a
+			}
+		case "interval":
+			p.next()
+			lit := &Literal{Kind: LitInterval}
+			if p.isOp("-") || p.isOp("+") {
+				if p.next().text == "-" {
+					lit.Value = "-"
+				}
+			}
+			if p.peek().kind != tokString {
+				p.fail("expected interval string")
+			}
+			lit.Value += p.next().text
+			lit.Type = p.name()
+			if p.acceptKw("to") {
+				lit.Type += " to " + p.name()
+			}
+			return lit
+		case "date", "time", "timestamp", "decimal", "real", "double", "char", "varbinary", "json":
+			if p.peekN(1).kind == tokString {
+				p.next()
+				return &Literal{Kind: LitTyped, Type: t.text, Value: p.next().text}
+			}
+		case "extract":
+			if p.opAt(1, "(") {
+				p.pos += 2
+				c := &Call{Name: "extract", Args: []Expr{&Keyword{Name: p.name()}}}
+				p.expectKw("from")
+				c.Args = append(c.Args, p.expr())
+				p.expectOp(")")
+				return c
+			}
+		case "position":
+			if p.opAt(1, "(") {
+				p.pos += 2
+				c := &Call{Name: "position", Args: []Expr{p.value()}}
+				p.expectKw("in")
+				c.Args = append(c.Args, p.value())
+				p.expectOp(")")
+				return c
+			}
+		}
+		if niladic[t.text] {
+			p.next()
+			c := &Call{Name: t.text}
+			if p.isOp("(") {
+				c.Args = p.parenExprs()
+			}
+			return c
+		}
+		if p.opAt(1, "(") {
+			p.next()
+			return p.call(t.text)
+		}
+	}
+
+	if !p.isName() {
+		p.fail("unexpected %q", t.text)
+	}
+	// x -> body
+	if p.opAt(1, "->") {
+		name := p.next().text
+		p.next()
+		return &Lambda{Params: []string{name}, Body: p.expr()}
+	}
+	id := &Ident{Parts: []string{p.next().text}}
+	// qualified function name, e.g. system.f(x)
+	if p.isOp(".") && (p.peekN(1).kind == tokIdent || p.peekN(1).kind == tokQuotedIdent) && p.opAt(2, "(") {
+		p.next()
+		id.Parts = append(id.Parts, p.next().text)
+		return p.call(strings.Join(id.Parts, "."))
+	}
+	return id
+}
+
+// parenExpr parses what follows "(" in an expression: a scalar subquery,
+// a parenthesized expression, a row, or the parameters of a lambda.
+func (p *parser) parenExpr() Expr {
+	if p.startsQuery() {
+		var s *Subquery
+		if p.try(func() {
+			p.expectOp("(")
+			s = &Subquery{Query: p.query()}
+			p.expectOp(")")
+		}) {
+			return s
+		}
+	}
+	items := p.parenExprs()
+	if p.acceptOp("->") {
+		l := &Lambda{}
+		for _, it := range items {
+			id, ok := it.(*Ident)
+			if !ok || len(id.Parts) != 1 {
+				p.fail("invalid lambda parameter")
+			}
+			l.Params = append(l.Params, id.Parts[0])
+		}
+		l.Body = p.expr()
+		return l
+	}
+	if len(items) == 1 {
+		return items[0]
+	}
+	return &Row{Items: items}
+}
+
+func (p *parser) call(name string) Expr {
+	p.expectOp("(")
+	c := &Call{Name: name}
+	switch {
+	case p.acceptOp("*"):
+		c.Star = true
+		p.expectOp(")")
+	case p.acceptOp(")"):
+	default:
+		if p.acceptKw("distinct") {
+			c.Distinct = true
+		} else {
+			p.acceptKw("all")
+		}
+		for {
+			c.Args = append(c.Args, p.expr())
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if p.acceptKw("order", "by") {
+			c.OrderBy = p.sortItems()
+		}
+		p.expectOp(")")
+	}
+	if p.isKw("filter") && p.opAt(1, "(") {
+		p.pos += 2
+		p.expectKw("where")
+		c.Filter = p.expr()
+		p.expectOp(")")
+	}
+	_ = p.acceptKw("ignore", "nulls") || p.acceptKw("respect", "nulls")
+	if p.acceptKw("over") {
+		if p.isOp("(") {
+			c.Over = p.windowSpec()
+		} else {
+			c.Over = &Window{Ref: p.name()}
+		}
+	}
+	return c
+}
+
+func (p *parser) windowSpec() *Window {
+	p.expectOp("(")
+	w := &Window{}
+	if p.isName() && !p.isKw("partition") && !p.isKw("order") && !p.isKw("rows") && !p.isKw("range") && !p.isKw("groups") {
+		w.Ref = p.next().text
+	}
+	if p.acceptKw("partition", "by") {
+		for {
+			w.PartitionBy = append(w.PartitionBy, p.expr())
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKw("order", "by") {
+		w.OrderBy = p.sortItems()
+	}
+	if p.acceptKw("rows") || p.acceptKw("range") || p.acceptKw("groups") {
+		if p.acceptKw("between") {
+			p.frameBound(w)
+			p.expectKw("and")
+		}
+		p.frameBound(w)
+	}
+	p.expectOp(")")
+	return w
+}
+
+func (p *parser) frameBound(w *Window) {
+	switch {
+	case p.acceptKw("unbounded"):
+		if !p.acceptKw("preceding") {
+			p.expectKw("following")
+		}
+	case p.acceptKw("current", "row"):
+	default:
+		w.Frame = append(w.Frame, p.value())
+		if !p.acceptKw("preceding") {
+			p.expectKw("following")
+		}
+	}
+}
+
+func (p *parser) caseExpr() Expr {
+	p.expectKw("case")
+	c := &Case{}
+	if !p.isKw("when") {
+		c.Operand = p.expr()
+	}
+	for p.acceptKw("when") {
+		w := &When{Cond: p.expr()}
+		p.expectKw("then")
+		w.Result = p.expr()
+		c.Whens = append(c.Whens, w)
+	}
+	if len(c.Whens) == 0 {
+		p.fail("expected WHEN")
+	}
+	if p.acceptKw("else") {
+		c.Else = p.expr()
+	}
+	p.expectKw("end")
+	return c
+}
+
+// typeText reads a type name up to the closing parenthesis of CAST, e.g.
+// "decimal(12,2)" or "array(varchar)".
+func (p *parser) typeText() string {
+	var parts []string
+	depth := 0
+	for {
+		t := p.peek()
+		if t.kind == tokEOF {
+			p.fail("unterminated type")
+		}
+		if t.kind == tokOp && t.text == ")" {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		if t.kind == tokOp && t.text == "(" {
+			depth++
+		}
+		if t.kind != tokIdent && t.kind != tokQuotedIdent && t.kind != tokNumber && t.kind != tokOp {
+			p.fail("unexpected %q in type", t.text)
+		}
+		parts = append(parts, t.text)
+		p.next()
+	}
+	if len(parts) == 0 {
+		p.fail("expected type")
+	}
+	return strings.Join(parts, " ")
+}
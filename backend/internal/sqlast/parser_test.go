@@ -0,0 +1,99 @@
+package sqlast
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseRejects(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want error
+	}{
+		{"SELECT 1; DROP TABLE t", ErrSemicolon},
+		{"SELECT 1;", ErrSemicolon},
+		{"SELECT 1 -- shop_id = 's1'", ErrComment},
+		{"SELECT 1 /* hidden */ FROM t", ErrComment},
+		{"SELECT 1 FROM t WHERE a = 1 --\nOR 1 = 1", ErrComment},
+		{"DELETE FROM t", ErrNotQuery},
+		{"INSERT INTO t SELECT 1", ErrNotQuery},
+		{"drop table t", ErrNotQuery},
+	}
+	for _, c := range cases {
+		if _, err := Parse(c.sql); !errors.Is(err, c.want) {
+			t.Errorf("Parse(%q) = %v, want %v", c.sql, err, c.want)
+		}
+	}
+
+	var se *SyntaxError
+	for _, sql := range []string{"", "SELECT 1 FROM t t2 t3", "SELECT 'open", `SELECT "open`, "SELECT 1 UNION"} {
+		if _, err := Parse(sql); !errors.As(err, &se) {
+			t.Errorf("Parse(%q) = %v, want a syntax error", sql, err)
+		}
+	}
+}
+
+func TestParseKeepsPolicyCharactersInStrings(t *testing.T) {
+	q, err := Parse("SELECT 'a;b', '--', '/*' FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	Inspect(q.Body, func(n Node) bool {
+		if l, ok := n.(*Literal); ok {
+			got = append(got, l.Value)
+		}
+		return true
+	})
+	if strings.Join(got, " ") != "a;b -- /*" {
+		t.Fatalf("literals = %q", got)
+	}
+}
+
+func TestIdentifiersFoldCase(t *testing.T) {
+	q, err := Parse(`SELECT "Shop_ID", Dt FROM "DB"."Daily_Metrics" "M"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := BaseTables(q)
+	if len(tables) != 1 || strings.Join(tables[0].Name, ".") != "db.daily_metrics" || tables[0].Alias != "m" {
+		t.Fatalf("tables = %+v", tables)
+	}
+	var ids []string
+	Inspect(q.Body, func(n Node) bool {
+		if id, ok := n.(*Ident); ok {
+			ids = append(ids, strings.Join(id.Parts, "."))
+		}
+		return true
+	})
+	if strings.Join(ids, " ") != "shop_id dt" {
+		t.Fatalf("idents = %q", ids)
+	}
+}
+
+func TestBaseTablesWithScoping(t *testing.T) {
+	cases := []struct {
+		name, sql string
+		want      int // base table references
+	}{
+		{"cte hides the table after its definition", "WITH t AS (SELECT 1 AS x) SELECT * FROM t", 0},
+		{"cte named like the table reads the table", "WITH t AS (SELECT * FROM t) SELECT * FROM t", 1},
+		{"mixed-case cte name", `WITH "T" AS (SELECT 1 AS x) SELECT * FROM t`, 0},
+		{"cte not visible outside its query", "SELECT * FROM (WITH t AS (SELECT 1 AS x) SELECT * FROM t) a JOIN t ON a.x = t.x", 1},
+		{"qualified name is never a cte", "WITH t AS (SELECT 1 AS x) SELECT * FROM db.t", 1},
+		{"subquery and union", "SELECT * FROM t WHERE x IN (SELECT x FROM u) UNION ALL SELECT * FROM v", 3},
+		{"later cte sees earlier", "WITH a AS (SELECT * FROM t), b AS (SELECT * FROM a) SELECT * FROM b", 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			q, err := Parse(c.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := BaseTables(q); len(got) != c.want {
+				t.Fatalf("%d base tables, want %d", len(got), c.want)
+			}
+		})
+	}
+}
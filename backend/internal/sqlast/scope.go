@@ -0,0 +1,38 @@
+package sqlast
+
+// BaseTables returns the references in q to tables, as opposed to WITH
+// queries in scope, at any depth. A WITH name is only in scope after its
+// definition and inside the query that defines it, so a WITH query named
+// like a table still reads the table itself.
+func BaseTables(q *Query) []*Table {
+	var out []*Table
+	baseTables(q, nil, &out)
+	return out
+}
+
+func baseTables(q *Query, outer map[string]bool, out *[]*Table) {
+	ctes := map[string]bool{}
+	for name := range outer {
+		ctes[name] = true
+	}
+	for _, c := range q.With {
+		baseTables(c.Query, ctes, out)
+		ctes[c.Name] = true
+	}
+	visit := func(n Node) bool {
+		switch n := n.(type) {
+		case *Query:
+			baseTables(n, ctes, out)
+			return false
+		case *Table:
+			if len(n.Name) != 1 || !ctes[n.Name[0]] {
+				*out = append(*out, n)
+			}
+		}
+		return true
+	}
+	Inspect(q.Body, visit)
+	for _, o := range q.OrderBy {
+		Inspect(o, visit)
+	}
+}
@@ -0,0 +1,460 @@
+package square
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/restate"
+	"backend/internal/security"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const Source = "square"
+
+// lookback re-reads recently created payments so fees and refunds that
+// settle after the last sync still land.
+const lookback = 3 * 24 * time.Hour
+
+// Integration mirrors the Square item in INTEGRATIONS_TABLE.
+// PK = USER#<sub>
+// SK = SQUARE#<merchantId>
+//
+// Webhooks resolve merchant -> users through reverse items:
+// PK = SQUAREMERCHANT#<merchantId>
+// SK = USER#<sub>
+type Integration struct {
+	PK                   string `dynamodbav:"PK"`
+	SK                   string `dynamodbav:"SK"`
+	MerchantId           string `dynamodbav:"MerchantId"`
+	AccessTokenEnc       string `dynamodbav:"AccessTokenEnc"`
+	RefreshTokenEnc      string `dynamodbav:"RefreshTokenEnc"`
+	AccessTokenExpiresAt string `dynamodbav:"AccessTokenExpiresAt"`
+	CreatedAt            string `dynamodbav:"CreatedAt"`
+	LastSyncAt           string `dynamodbav:"LastSyncAt,omitempty"`
+}
+
+func (i Integration) UserSub() string {
+	return strings.TrimPrefix(i.PK, "USER#")
+}
+
+type SyncResult struct {
+	MerchantId string `json:"merchantId"`
+	Payments   int    `json:"payments"`
+	Fees       int    `json:"fees"`
+	Refunds    int    `json:"refunds"`
+	Skipped    int    `json:"skipped"`
+	LastSyncAt string `json:"lastSyncAt"`
+}
+
+// SaveIntegration stores the encrypted tokens and the merchant -> user reverse item.
+func SaveIntegration(ctx context.Context, ddb *dynamodb.Client, sub string, tok *Tokens) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	accessEnc, err := security.EncryptAESGCM(key, tok.AccessToken)
+	if err != nil {
+		return err
+	}
+	refreshEnc, err := security.EncryptAESGCM(key, tok.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("SQUARE#%s", tok.MerchantId)},
+		},
+		UpdateExpression: aws.String("SET Provider = :p, MerchantId = :m, AccessTokenEnc = :a, RefreshTokenEnc = :r, " +
+			"AccessTokenExpiresAt = :exp, CreatedAt = if_not_exists(CreatedAt, :now)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":p":   &types.AttributeValueMemberS{Value: Source},
+			":m":   &types.AttributeValueMemberS{Value: tok.MerchantId},
+			":a":   &types.AttributeValueMemberS{Value: accessEnc},
+			":r":   &types.AttributeValueMemberS{Value: refreshEnc},
+			":exp": &types.AttributeValueMemberS{Value: tok.ExpiresAt},
+			":now": &types.AttributeValueMemberS{Value: now},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tbl),
+		Item: map[string]types.AttributeValue{
+			"PK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("SQUAREMERCHANT#%s", tok.MerchantId)},
+			"SK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+			"UserSub":   &types.AttributeValueMemberS{Value: sub},
+			"CreatedAt": &types.AttributeValueMemberS{Value: now},
+		},
+	})
+	return err
+}
+
+// UsersForMerchant returns the users who connected a Square merchant.
+func UsersForMerchant(ctx context.Context, ddb *dynamodb.Client, merchantID string) ([]string, error) {
+	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(db.IntegrationsTableName()),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("SQUAREMERCHANT#%s", merchantID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]string, 0, len(out.Items))
+	for _, it := range out.Items {
+		if v, ok := it["UserSub"].(*types.AttributeValueMemberS); ok && v.Value != "" {
+			subs = append(subs, v.Value)
+		}
+	}
+	return subs, nil
+}
+
+// ListIntegrations returns Square integrations for one user, or for every user when sub is empty.
+func ListIntegrations(ctx context.Context, ddb *dynamodb.Client, sub string) ([]Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	var items []map[string]types.AttributeValue
+	var startKey map[string]types.AttributeValue
+	for {
+		var (
+			page    []map[string]types.AttributeValue
+			lastKey map[string]types.AttributeValue
+		)
+		if sub != "" {
+			out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(tbl),
+				KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+					":p":  &types.AttributeValueMemberS{Value: "SQUARE#"},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			page, lastKey = out.Items, out.LastEvaluatedKey
+		} else {
+			out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+				TableName:        aws.String(tbl),
+				FilterExpression: aws.String("begins_with(SK, :p)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":p": &types.AttributeValueMemberS{Value: "SQUARE#"},
+				},
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			page, lastKey = out.Items, out.LastEvaluatedKey
+		}
+
+		items = append(items, page...)
+		if len(lastKey) == 0 {
+			break
+		}
+		startKey = lastKey
+	}
+
+	var out []Integration
+	if err := attributevalue.UnmarshalListOfMaps(items, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// accessToken returns a usable access token, refreshing (and storing) it
+// when it expires within a week.
+func accessToken(ctx context.Context, ddb *dynamodb.Client, integ *Integration) (string, error) {
+	key, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+
+	exp, err := time.Parse(time.RFC3339, integ.AccessTokenExpiresAt)
+	if err == nil && time.Until(exp) > 7*24*time.Hour {
+		tok, err := security.DecryptAESGCM(key, integ.AccessTokenEnc)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt access token: %w", err)
+		}
+		return tok, nil
+	}
+
+	refresh, err := security.DecryptAESGCM(key, integ.RefreshTokenEnc)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+	tok, err := Refresh(ctx, refresh)
+	if err != nil {
+		return "", err
+	}
+	if tok.MerchantId == "" {
+		tok.MerchantId = integ.MerchantId
+	}
+	if err := SaveIntegration(ctx, ddb, integ.UserSub(), tok); err != nil {
+		return "", fmt.Errorf("store refreshed token: %w", err)
+	}
+	return tok.AccessToken, nil
+}
+
+// SyncMerchant pulls payments and refunds created since LastSyncAt (minus a
+// short lookback, or the last 30 days) and writes them as transactions.
+func SyncMerchant(ctx context.Context, ddb *dynamodb.Client, integ Integration) (*SyncResult, error) {
+	txTable := strings.TrimSpace(db.TransactionsTableName())
+	intTable := strings.TrimSpace(db.IntegrationsTableName())
+	if txTable == "" || intTable == "" {
+		return nil, fmt.Errorf("tables not configured")
+	}
+
+	token, err := accessToken(ctx, ddb, &integ)
+	if err != nil {
+		return nil, err
+	}
+
+	startedAt := time.Now().UTC()
+	since := startedAt.Add(-30 * 24 * time.Hour)
+	if t, err := time.Parse(time.RFC3339, integ.LastSyncAt); err == nil {
+		since = t.Add(-lookback)
+	}
+	begin := since.Format(time.RFC3339)
+
+	res := &SyncResult{MerchantId: integ.MerchantId}
+	sub := integ.UserSub()
+
+	cursor := ""
+	for {
+		payments, next, err := ListPayments(ctx, token, begin, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range payments {
+			n, fees, err := WritePayment(ctx, ddb, txTable, sub, integ.MerchantId, p)
+			if err != nil {
+				return nil, err
+			}
+			res.Payments += n
+			res.Fees += fees
+			if n == 0 && fees == 0 {
+				res.Skipped++
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	cursor = ""
+	for {
+		refunds, next, err := ListRefunds(ctx, token, begin, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range refunds {
+			wrote, err := WriteRefund(ctx, ddb, txTable, sub, integ.MerchantId, r)
+			if err != nil {
+				return nil, err
+			}
+			if wrote {
+				res.Refunds++
+			} else {
+				res.Skipped++
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	res.LastSyncAt = startedAt.Format(time.RFC3339)
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(intTable),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: integ.PK},
+			"SK": &types.AttributeValueMemberS{Value: integ.SK},
+		},
+		UpdateExpression: aws.String("SET LastSyncAt = :t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberS{Value: res.LastSyncAt},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update LastSyncAt: %w", err)
+	}
+	return res, nil
+}
+
+// WritePayment writes a completed payment as revenue plus its processing fee
+// as an expense. Returns how many of each were written (0 when an equal or
+// newer version already exists).
+func WritePayment(ctx context.Context, ddb *dynamodb.Client, table, sub, merchantID string, p Payment) (int, int, error) {
+	if !strings.EqualFold(p.Status, "COMPLETED") {
+		return 0, 0, nil
+	}
+	total := p.AmountMoney
+	if p.TotalMoney != nil {
+		total = *p.TotalMoney
+	}
+
+	extra := map[string]string{
+		"MerchantId": merchantID,
+		"PaymentId":  p.Id,
+	}
+	if p.LocationId != "" {
+		extra["LocationId"] = p.LocationId
+	}
+
+	payments, fees := 0, 0
+	wrote, err := putTransaction(ctx, ddb, table, sub, fmt.Sprintf("SQUARE#%s#PAYMENT#%s", merchantID, p.Id), txFields{
+		At:        parseTime(p.CreatedAt),
+		Amount:    total.Value(),
+		Currency:  total.Currency,
+		Category:  "Square Sales",
+		Note:      fmt.Sprintf("Square payment %s", p.Id),
+		UpdatedAt: p.UpdatedAt,
+		Extra:     extra,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if wrote {
+		payments++
+	}
+
+	var fee Money
+	for _, f := range p.ProcessingFee {
+		fee.Amount += f.AmountMoney.Amount
+		fee.Currency = f.AmountMoney.Currency
+	}
+	if fee.Amount != 0 {
+		amt := fee.Value()
+		if amt > 0 {
+			amt = -amt
+		}
+		wrote, err := putTransaction(ctx, ddb, table, sub, fmt.Sprintf("SQUARE#%s#FEE#%s", merchantID, p.Id), txFields{
+			At:        parseTime(p.CreatedAt),
+			Amount:    amt,
+			Currency:  fee.Currency,
+			Category:  "Square Processing Fees",
+			Note:      fmt.Sprintf("Square processing fee for payment %s", p.Id),
+			UpdatedAt: p.UpdatedAt,
+			Extra:     extra,
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		if wrote {
+			fees++
+		}
+	}
+	return payments, fees, nil
+}
+
+// WriteRefund writes a completed refund as a negative transaction.
+func WriteRefund(ctx context.Context, ddb *dynamodb.Client, table, sub, merchantID string, r Refund) (bool, error) {
+	if !strings.EqualFold(r.Status, "COMPLETED") {
+		return false, nil
+	}
+	return putTransaction(ctx, ddb, table, sub, fmt.Sprintf("SQUARE#%s#REFUND#%s", merchantID, r.Id), txFields{
+		At:        parseTime(r.CreatedAt),
+		Amount:    -r.AmountMoney.Value(),
+		Currency:  r.AmountMoney.Currency,
+		Category:  "Square Refunds",
+		Note:      fmt.Sprintf("Square refund %s for payment %s", r.Id, r.PaymentId),
+		UpdatedAt: r.UpdatedAt,
+		Extra: map[string]string{
+			"MerchantId": merchantID,
+			"PaymentId":  r.PaymentId,
+			"RefundId":   r.Id,
+		},
+	})
+}
+
+type txFields struct {
+	At        time.Time
+	Amount    float64
+	Currency  string
+	Category  string
+	Note      string
+	UpdatedAt string // newer versions overwrite older ones
+	Extra     map[string]string
+}
+
+// putTransaction writes one transaction unless an equal or newer version exists.
+func putTransaction(ctx context.Context, ddb *dynamodb.Client, table, sub, sk string, f txFields) (bool, error) {
+	if f.Currency == "" {
+		f.Currency = "USD"
+	}
+	if f.UpdatedAt == "" {
+		f.UpdatedAt = f.At.Format(time.RFC3339)
+	}
+	item := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+		"SK":        &types.AttributeValueMemberS{Value: sk},
+		"GSI1PK":    &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, f.At.Format("2006-01"))},
+		"GSI1SK":    &types.AttributeValueMemberS{Value: f.At.Format(time.RFC3339Nano)},
+		"Amount":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", f.Amount)},
+		"Currency":  &types.AttributeValueMemberS{Value: strings.ToUpper(f.Currency)},
+		"Category":  &types.AttributeValueMemberS{Value: f.Category},
+		"Note":      &types.AttributeValueMemberS{Value: f.Note},
+		"CreatedAt": &types.AttributeValueMemberS{Value: f.At.Format(time.RFC3339)},
+		"UpdatedAt": &types.AttributeValueMemberS{Value: f.UpdatedAt},
+		"Source":    &types.AttributeValueMemberS{Value: Source},
+	}
+	for k, v := range f.Extra {
+		item[k] = &types.AttributeValueMemberS{Value: v}
+	}
+	restate.Stamp(item)
+
+	out, err := ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK) OR attribute_not_exists(UpdatedAt) OR UpdatedAt < :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: f.UpdatedAt},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		var cfe *types.ConditionalCheckFailedException
+		if errors.As(err, &cfe) {
+			return false, nil
+		}
+		return false, fmt.Errorf("ddb put square tx: %w", err)
+	}
+	if err := restate.Record(ctx, ddb, table, out.Attributes, item); err != nil {
+		fmt.Printf("square: record restatement %s: %v\n", sk, err)
+	}
+	return true, nil
+}
+
+func parseTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC()
+	}
+	return time.Now().UTC()
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"strings"
 
+	"backend/internal/cache"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -15,11 +17,23 @@ type DDBClient interface {
 	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
 }
 
+// allowedShops caches GetAllowedShopsByUserSub, which nearly every request runs.
+var allowedShops = cache.New[string, []string](1000, cache.TTL())
+
+// InvalidateAllowedShops drops the cached shops of userSub after a change to
+// their shop mappings.
+func InvalidateAllowedShops(userSub string) {
+	allowedShops.Delete(strings.TrimSpace(userSub))
+}
+
 func GetAllowedShopsByUserSub(ctx context.Context, ddb DDBClient, userSub string) ([]string, error) {
 	userSub = strings.TrimSpace(userSub)
 	if userSub == "" {
 		return nil, fmt.Errorf("empty userSub")
 	}
+	if shops, ok := allowedShops.Get(userSub); ok {
+		return append([]string(nil), shops...), nil
+	}
 
 	table := strings.TrimSpace(os.Getenv("SHOP_TO_USER_TABLE"))
 	if table == "" {
@@ -32,8 +46,8 @@ func GetAllowedShopsByUserSub(ctx context.Context, ddb DDBClient, userSub string
 	}
 
 	out, err := ddb.Query(ctx, &dynamodb.QueryInput{
-		TableName: aws.String(table),
-		IndexName: aws.String(indexName),
+		TableName:              aws.String(table),
+		IndexName:              aws.String(indexName),
 		KeyConditionExpression: aws.String("#u = :u"),
 		ExpressionAttributeNames: map[string]string{
 			"#u": "UserSub",
@@ -59,7 +73,13 @@ func GetAllowedShopsByUserSub(ctx context.Context, ddb DDBClient, userSub string
 			}
 		}
 	}
-	return uniqueStrings(shops), nil
+	shops = uniqueStrings(shops)
+	// No shops yet isn't cached, so a store connected through another
+	// container shows up right away.
+	if len(shops) > 0 {
+		allowedShops.Set(userSub, append([]string(nil), shops...))
+	}
+	return shops, nil
 }
 
 func uniqueStrings(in []string) []string {
@@ -73,16 +73,21 @@ func EnsureUserEmailAlerts(ctx context.Context, ddb *dynamodb.Client, snsClient
 		return "", err
 	}
 
-	// Save to Users table (also store email)
+	// Save to Users table (also store email) and clear any pending
+	// provisioning. Update, not put, so other attributes on the user item
+	// (settings) survive.
 	tbl := strings.TrimSpace(db.UsersTableName())
 	if tbl != "" {
-		_, _ = ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		_, _ = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 			TableName: aws.String(tbl),
-			Item: map[string]types.AttributeValue{
-				"PK":             &types.AttributeValueMemberS{Value: UserPK(sub)},
-				"Email":          &types.AttributeValueMemberS{Value: email},
-				"AlertsTopicArn": &types.AttributeValueMemberS{Value: topicArn},
-				"UpdatedAt":      &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: UserPK(sub)},
+			},
+			UpdateExpression: aws.String("SET Email = :e, AlertsTopicArn = :t, UpdatedAt = :u REMOVE AlertsPendingSince, AlertsAttempts, AlertsNextAttemptAt, AlertsLastError"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":e": &types.AttributeValueMemberS{Value: email},
+				":t": &types.AttributeValueMemberS{Value: topicArn},
+				":u": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
 			},
 		})
 	}
@@ -111,3 +116,27 @@ func GetAlertsTopicArn(ctx context.Context, ddb *dynamodb.Client, sub string) (s
 	}
 	return "", nil
 }
+
+// AlertsConfirmed reports whether any subscription of the user's alerts
+// topic has been confirmed (the email link was clicked).
+func AlertsConfirmed(ctx context.Context, snsClient *sns.Client, topicArn string) (bool, error) {
+	if strings.TrimSpace(topicArn) == "" {
+		return false, nil
+	}
+	p := sns.NewListSubscriptionsByTopicPaginator(snsClient, &sns.ListSubscriptionsByTopicInput{
+		TopicArn: aws.String(topicArn),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("list subscriptions: %w", err)
+		}
+		for _, s := range page.Subscriptions {
+			// Unconfirmed subscriptions have no real ARN yet.
+			if arn := aws.ToString(s.SubscriptionArn); strings.HasPrefix(arn, "arn:") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
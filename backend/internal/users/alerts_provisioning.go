@@ -0,0 +1,163 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/internal/cache"
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// Alerts topics are provisioned off the request path: RequestEmailAlerts
+// marks the Users row pending, and the alerts-provisioner job calls
+// EnsureUserEmailAlerts for pending rows, backing off on SNS errors.
+//
+// Users row attributes while pending:
+//
+//	AlertsPendingSince  RFC3339, set once
+//	AlertsNextAttemptAt RFC3339, when the job may try again
+//	AlertsAttempts      failed attempts so far
+//	AlertsLastError     last SNS error
+//
+// After maxAlertsAttempts the row keeps AlertsFailedAt instead and is not
+// requeued; clearing that attribute retries it.
+const maxAlertsAttempts = 8
+
+// alertsRequested remembers users whose row already has a topic or a pending
+// request, so warm containers skip the Users read. Neither state reverts on
+// its own, hence the long TTL.
+var alertsRequested = cache.New[string, bool](10000, 6*time.Hour)
+
+// RequestEmailAlerts queues alerts provisioning for sub unless it is done,
+// pending or given up. It never calls SNS and is cheap to call per request.
+func RequestEmailAlerts(ctx context.Context, ddb *dynamodb.Client, sub, email string) error {
+	sub = strings.TrimSpace(sub)
+	email = strings.TrimSpace(email)
+	tbl := strings.TrimSpace(db.UsersTableName())
+	if sub == "" || email == "" || tbl == "" {
+		return nil
+	}
+	if _, ok := alertsRequested.Get(sub); ok {
+		return nil
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: UserPK(sub)},
+		},
+		UpdateExpression:    aws.String("SET Email = :e, AlertsPendingSince = :now, AlertsNextAttemptAt = :now, AlertsAttempts = :zero"),
+		ConditionExpression: aws.String("attribute_not_exists(AlertsTopicArn) AND attribute_not_exists(AlertsPendingSince) AND attribute_not_exists(AlertsFailedAt)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":e":    &types.AttributeValueMemberS{Value: email},
+			":now":  &types.AttributeValueMemberS{Value: now},
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		},
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &ccf) {
+		return fmt.Errorf("request alerts: %w", err)
+	}
+	alertsRequested.Set(sub, true)
+	return nil
+}
+
+// PendingAlerts is a Users row waiting for its alerts topic.
+type PendingAlerts struct {
+	PK       string `dynamodbav:"PK"`
+	Email    string `dynamodbav:"Email"`
+	Attempts int    `dynamodbav:"AlertsAttempts"`
+}
+
+// Sub is the user the row belongs to.
+func (p PendingAlerts) Sub() string {
+	return strings.TrimPrefix(p.PK, "USER#")
+}
+
+// DuePendingAlerts lists pending rows whose next attempt is due at now.
+func DuePendingAlerts(ctx context.Context, ddb *dynamodb.Client, now time.Time) ([]PendingAlerts, error) {
+	tbl := strings.TrimSpace(db.UsersTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("USERS_TABLE not set")
+	}
+	var (
+		rows     []PendingAlerts
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(tbl),
+			FilterExpression: aws.String("attribute_exists(AlertsPendingSince) AND AlertsNextAttemptAt <= :now"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan users: %w", err)
+		}
+		var page []PendingAlerts
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		rows = append(rows, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return rows, nil
+}
+
+// ProvisionPendingAlerts runs one attempt for p. On failure the row is
+// rescheduled with exponential backoff (1m, 2m, 4m, ... capped at 6h), or
+// marked AlertsFailedAt once maxAlertsAttempts is reached; the SNS error is
+// returned either way.
+func ProvisionPendingAlerts(ctx context.Context, ddb *dynamodb.Client, snsClient *sns.Client, p PendingAlerts) error {
+	_, runErr := EnsureUserEmailAlerts(ctx, ddb, snsClient, p.Sub(), p.Email)
+	if runErr == nil {
+		return nil
+	}
+
+	attempts := p.Attempts + 1
+	now := time.Now().UTC()
+	msg := runErr.Error()
+	if len(msg) > 500 {
+		msg = msg[:500]
+	}
+	in := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.UsersTableName()),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: p.PK},
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":a":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", attempts)},
+			":err": &types.AttributeValueMemberS{Value: msg},
+		},
+	}
+	if attempts >= maxAlertsAttempts {
+		in.UpdateExpression = aws.String("SET AlertsAttempts = :a, AlertsLastError = :err, AlertsFailedAt = :now REMOVE AlertsPendingSince, AlertsNextAttemptAt")
+		in.ExpressionAttributeValues[":now"] = &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)}
+	} else {
+		backoff := time.Minute << (attempts - 1)
+		if backoff > 6*time.Hour {
+			backoff = 6 * time.Hour
+		}
+		in.UpdateExpression = aws.String("SET AlertsAttempts = :a, AlertsLastError = :err, AlertsNextAttemptAt = :next")
+		in.ExpressionAttributeValues[":next"] = &types.AttributeValueMemberS{Value: now.Add(backoff).Format(time.RFC3339)}
+	}
+	if _, err := ddb.UpdateItem(ctx, in); err != nil {
+		return fmt.Errorf("%w (and reschedule failed: %v)", runErr, err)
+	}
+	return runErr
+}
@@ -0,0 +1,45 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// AllSubs lists the sub of every user row, for jobs that sweep all users.
+func AllSubs(ctx context.Context, ddb *dynamodb.Client) ([]string, error) {
+	tbl := strings.TrimSpace(db.UsersTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("USERS_TABLE not set")
+	}
+	var (
+		subs     []string
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:            aws.String(tbl),
+			ProjectionExpression: aws.String("PK"),
+			ExclusiveStartKey:    startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan users: %w", err)
+		}
+		for _, it := range out.Items {
+			pk, _ := it["PK"].(*types.AttributeValueMemberS)
+			if pk != nil && strings.HasPrefix(pk.Value, "USER#") {
+				subs = append(subs, strings.TrimPrefix(pk.Value, "USER#"))
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return subs, nil
+		}
+		startKey = out.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,110 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Onboarding progress is mostly computed from real data (see the onboarding
+// handler); the user item only keeps what can't be: the first /ask, steps
+// the user marked done themselves, and whether the checklist was dismissed.
+//
+// OnboardingDismissedAt S, OnboardingCompleted SS, FirstAskAt S
+type Onboarding struct {
+	DismissedAt string
+	Completed   []string
+	FirstAskAt  string
+}
+
+func GetOnboarding(ctx context.Context, ddb *dynamodb.Client, sub string) (Onboarding, error) {
+	var o Onboarding
+	tbl := strings.TrimSpace(db.UsersTableName())
+	if tbl == "" {
+		return o, nil
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: UserPK(sub)},
+		},
+		ProjectionExpression: aws.String("OnboardingDismissedAt, OnboardingCompleted, FirstAskAt"),
+	})
+	if err != nil {
+		return o, fmt.Errorf("get onboarding: %w", err)
+	}
+	if v, ok := out.Item["OnboardingDismissedAt"].(*types.AttributeValueMemberS); ok {
+		o.DismissedAt = v.Value
+	}
+	if v, ok := out.Item["OnboardingCompleted"].(*types.AttributeValueMemberSS); ok {
+		o.Completed = v.Value
+	}
+	if v, ok := out.Item["FirstAskAt"].(*types.AttributeValueMemberS); ok {
+		o.FirstAskAt = v.Value
+	}
+	return o, nil
+}
+
+// SetOnboardingDismissed hides (or brings back) the checklist.
+func SetOnboardingDismissed(ctx context.Context, ddb *dynamodb.Client, sub string, dismissed bool) error {
+	in := &dynamodb.UpdateItemInput{
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: UserPK(sub)},
+		},
+		UpdateExpression: aws.String("REMOVE OnboardingDismissedAt"),
+	}
+	if dismissed {
+		in.UpdateExpression = aws.String("SET OnboardingDismissedAt = :t")
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		}
+	}
+	return updateUser(ctx, ddb, in)
+}
+
+// CompleteOnboardingStep marks a step done by hand, e.g. a merchant with no
+// product costs to enter.
+func CompleteOnboardingStep(ctx context.Context, ddb *dynamodb.Client, sub, step string) error {
+	return updateUser(ctx, ddb, &dynamodb.UpdateItemInput{
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: UserPK(sub)},
+		},
+		UpdateExpression: aws.String("ADD OnboardingCompleted :s"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberSS{Value: []string{step}},
+		},
+	})
+}
+
+// MarkFirstAsk records the user's first answered /ask. Later calls keep the
+// original time.
+func MarkFirstAsk(ctx context.Context, ddb *dynamodb.Client, sub string) error {
+	return updateUser(ctx, ddb, &dynamodb.UpdateItemInput{
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: UserPK(sub)},
+		},
+		UpdateExpression: aws.String("SET FirstAskAt = if_not_exists(FirstAskAt, :t)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+}
+
+func updateUser(ctx context.Context, ddb *dynamodb.Client, in *dynamodb.UpdateItemInput) error {
+	tbl := strings.TrimSpace(db.UsersTableName())
+	if tbl == "" {
+		return fmt.Errorf("USERS_TABLE not set")
+	}
+	in.TableName = aws.String(tbl)
+	if _, err := ddb.UpdateItem(ctx, in); err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	return nil
+}
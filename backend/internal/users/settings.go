@@ -0,0 +1,131 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/cache"
+	"backend/internal/db"
+	"backend/internal/fx"
+	"backend/internal/margin"
+	"backend/internal/notify"
+	"backend/internal/periods"
+	"backend/internal/vat"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Settings are per-user reporting preferences, stored as JSON in the
+// Settings attribute of the user's Users table item.
+type Settings struct {
+	Calendar      periods.Calendar   `json:"calendar"`
+	CostModel     margin.Model       `json:"costModel"`
+	VAT           vat.Settings       `json:"vat"`
+	Notifications notify.Preferences `json:"notifications"`
+
+	// BaseCurrency is what mixed-currency totals are converted to; empty
+	// falls back to ETL_CURRENCY, then the FX pivot currency.
+	BaseCurrency string `json:"baseCurrency,omitempty"`
+
+	// CashBalance is what the dashboard's runway counts down from until
+	// bank feeds exist; nil leaves the runway at unknown_balance.
+	CashBalance *float64 `json:"cashBalance,omitempty"`
+}
+
+// ReportingCurrency is the currency mixed-currency totals are shown in.
+func (s Settings) ReportingCurrency() string {
+	if s.BaseCurrency != "" {
+		return s.BaseCurrency
+	}
+	if c := strings.ToUpper(strings.TrimSpace(os.Getenv("ETL_CURRENCY"))); c != "" {
+		return c
+	}
+	return fx.PivotBase()
+}
+
+// DefaultSettings applies to users who never saved any.
+func DefaultSettings() Settings {
+	return Settings{Calendar: periods.Default}
+}
+
+// settingsCache keeps recently read settings; PutSettings refreshes it.
+var settingsCache = cache.New[string, Settings](1000, cache.TTL())
+
+// GetSettings returns the user's settings, or the defaults when none are stored.
+func GetSettings(ctx context.Context, ddb *dynamodb.Client, sub string) (Settings, error) {
+	s := DefaultSettings()
+	tbl := strings.TrimSpace(db.UsersTableName())
+	if tbl == "" {
+		return s, nil
+	}
+	if cached, ok := settingsCache.Get(sub); ok {
+		return cached, nil
+	}
+
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: UserPK(sub)},
+		},
+		ProjectionExpression: aws.String("Settings"),
+	})
+	if err != nil {
+		return s, fmt.Errorf("get settings: %w", err)
+	}
+	if v, ok := out.Item["Settings"].(*types.AttributeValueMemberS); ok {
+		if err := json.Unmarshal([]byte(v.Value), &s); err != nil {
+			s = DefaultSettings()
+		}
+	}
+	settingsCache.Set(sub, s)
+	return s, nil
+}
+
+// PutSettings validates and stores s.
+func PutSettings(ctx context.Context, ddb *dynamodb.Client, sub string, s Settings) error {
+	if err := s.Calendar.Validate(); err != nil {
+		return err
+	}
+	if err := s.CostModel.Validate(); err != nil {
+		return err
+	}
+	if err := s.VAT.Validate(); err != nil {
+		return err
+	}
+	if err := s.Notifications.Validate(); err != nil {
+		return err
+	}
+	s.BaseCurrency = strings.ToUpper(strings.TrimSpace(s.BaseCurrency))
+	if s.BaseCurrency != "" && len(s.BaseCurrency) != 3 {
+		return fmt.Errorf("baseCurrency must be a 3-letter currency code")
+	}
+	tbl := strings.TrimSpace(db.UsersTableName())
+	if tbl == "" {
+		return fmt.Errorf("USERS_TABLE not set")
+	}
+
+	b, _ := json.Marshal(s)
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: UserPK(sub)},
+		},
+		UpdateExpression: aws.String("SET Settings = :s, UpdatedAt = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberS{Value: string(b)},
+			":u": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		settingsCache.Delete(sub)
+		return fmt.Errorf("put settings: %w", err)
+	}
+	settingsCache.Set(sub, s)
+	return nil
+}
@@ -0,0 +1,108 @@
+package vat
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Settings are a user's VAT defaults: the country their costs are usually
+// incurred in, the standard rate per country, and whether they are
+// registered (and so can reclaim the VAT they pay).
+type Settings struct {
+	Registered bool               `json:"registered"`
+	Country    string             `json:"country,omitempty"` // ISO 3166-1 alpha-2
+	Rates      map[string]float64 `json:"rates,omitempty"`   // country -> rate in %
+}
+
+func (s *Settings) Validate() error {
+	s.Country = strings.ToUpper(strings.TrimSpace(s.Country))
+	if s.Country != "" && len(s.Country) != 2 {
+		return fmt.Errorf("vat.country must be a two-letter country code")
+	}
+	rates := make(map[string]float64, len(s.Rates))
+	for c, r := range s.Rates {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if len(c) != 2 {
+			return fmt.Errorf("vat.rates keys must be two-letter country codes")
+		}
+		if r < 0 || r > 100 {
+			return fmt.Errorf("vat.rates must be between 0 and 100")
+		}
+		rates[c] = r
+	}
+	s.Rates = rates
+	return nil
+}
+
+// Input is the VAT part of a cost as a user enters it; anything left out
+// comes from Settings.
+type Input struct {
+	Country     string   `json:"country,omitempty"`
+	Rate        *float64 `json:"rate,omitempty"`   // %
+	Amount      *float64 `json:"amount,omitempty"` // VAT paid, when the receipt states it
+	Reclaimable *bool    `json:"reclaimable,omitempty"`
+}
+
+// Components split a gross amount. Net + VAT = gross, all with the gross
+// amount's sign.
+type Components struct {
+	Country     string
+	Rate        float64
+	Net         float64
+	VAT         float64
+	Reclaimable bool
+}
+
+// Resolve splits gross under in, falling back to s for the country, the
+// rate and reclaimability. A stated VAT amount wins over the rate; the
+// rate is then derived from it.
+func Resolve(gross float64, in Input, s Settings) (Components, error) {
+	c := Components{Country: strings.ToUpper(strings.TrimSpace(in.Country)), Reclaimable: s.Registered}
+	if c.Country == "" {
+		c.Country = s.Country
+	}
+	if c.Country == "" {
+		return c, fmt.Errorf("vat.country is required when no default country is set")
+	}
+	if len(c.Country) != 2 {
+		return c, fmt.Errorf("vat.country must be a two-letter country code")
+	}
+	if in.Reclaimable != nil {
+		c.Reclaimable = *in.Reclaimable
+	}
+
+	sign := 1.0
+	if gross < 0 {
+		sign = -1
+	}
+	abs := math.Abs(gross)
+	switch {
+	case in.Amount != nil:
+		v := math.Abs(*in.Amount)
+		if v >= abs {
+			return c, fmt.Errorf("vat.amount must be less than the transaction amount")
+		}
+		c.VAT = round2(v)
+		c.Rate = math.Round(v/(abs-v)*10000) / 100
+	case in.Rate != nil:
+		c.Rate = *in.Rate
+	default:
+		r, ok := s.Rates[c.Country]
+		if !ok {
+			return c, fmt.Errorf("no VAT rate set for %s; pass vat.rate or add it to settings", c.Country)
+		}
+		c.Rate = r
+	}
+	if c.Rate < 0 || c.Rate > 100 {
+		return c, fmt.Errorf("vat.rate must be between 0 and 100")
+	}
+	if in.Amount == nil {
+		c.VAT = round2(abs * c.Rate / (100 + c.Rate))
+	}
+	c.Net = sign * round2(abs-c.VAT)
+	c.VAT = sign * c.VAT
+	return c, nil
+}
+
+func round2(v float64) float64 { return math.Round(v*100) / 100 }
@@ -0,0 +1,189 @@
+package xero
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	authorizeEndpoint = "https://login.xero.com/identity/connect/authorize"
+	tokenEndpoint     = "https://identity.xero.com/connect/token"
+	connectionsURL    = "https://api.xero.com/connections"
+	apiBase           = "https://api.xero.com/api.xro/2.0"
+
+	Scopes = "offline_access accounting.transactions accounting.settings.read"
+)
+
+func AuthorizeURL(state, redirectURI string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", os.Getenv("XERO_CLIENT_ID"))
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", Scopes)
+	q.Set("state", state)
+	return authorizeEndpoint + "?" + q.Encode()
+}
+
+// Tokens is a token response. Xero rotates refresh tokens: every refresh
+// returns a new one and the old one stops working, so it must be stored back.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func ExchangeCode(ctx context.Context, code, redirectURI string) (*Tokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	return postToken(ctx, form)
+}
+
+func Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	return postToken(ctx, form)
+}
+
+func postToken(ctx context.Context, form url.Values) (*Tokens, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(os.Getenv("XERO_CLIENT_ID"), os.Getenv("XERO_CLIENT_SECRET"))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("xero token failed: http %d: %s", res.StatusCode, string(raw))
+	}
+	var tok Tokens
+	if err := json.Unmarshal(raw, &tok); err != nil {
+		return nil, fmt.Errorf("xero token unmarshal: %w", err)
+	}
+	if tok.AccessToken == "" || tok.RefreshToken == "" {
+		return nil, fmt.Errorf("xero token response missing tokens")
+	}
+	return &tok, nil
+}
+
+type Connection struct {
+	TenantId   string `json:"tenantId"`
+	TenantType string `json:"tenantType"`
+	TenantName string `json:"tenantName"`
+}
+
+// Connections lists the organisations the token was granted for.
+func Connections(ctx context.Context, accessToken string) ([]Connection, error) {
+	var out []Connection
+	if err := do(ctx, http.MethodGet, connectionsURL, accessToken, "", "", nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BaseCurrency returns the organisation's base currency; manual journals are
+// always in base currency.
+func BaseCurrency(ctx context.Context, accessToken, tenantID string) (string, error) {
+	var out struct {
+		Organisations []struct {
+			BaseCurrency string `json:"BaseCurrency"`
+		} `json:"Organisations"`
+	}
+	if err := do(ctx, http.MethodGet, apiBase+"/Organisation", accessToken, tenantID, "", nil, &out); err != nil {
+		return "", err
+	}
+	if len(out.Organisations) == 0 {
+		return "", fmt.Errorf("xero: no organisation returned")
+	}
+	return out.Organisations[0].BaseCurrency, nil
+}
+
+type JournalLine struct {
+	LineAmount  float64 `json:"LineAmount"` // positive = debit, negative = credit
+	AccountCode string  `json:"AccountCode"`
+	Description string  `json:"Description,omitempty"`
+}
+
+type ManualJournal struct {
+	ManualJournalID string        `json:"ManualJournalID,omitempty"`
+	Narration       string        `json:"Narration"`
+	Date            string        `json:"Date"` // YYYY-MM-DD
+	Status          string        `json:"Status"`
+	JournalLines    []JournalLine `json:"JournalLines"`
+}
+
+// PutManualJournal creates the journal, or updates it when ManualJournalID is
+// set. idempotencyKey makes Xero replay the first response for retried calls.
+func PutManualJournal(ctx context.Context, accessToken, tenantID, idempotencyKey string, j ManualJournal) (string, error) {
+	body := map[string]any{"ManualJournals": []ManualJournal{j}}
+
+	var out struct {
+		ManualJournals []struct {
+			ManualJournalID  string `json:"ManualJournalID"`
+			ValidationErrors []struct {
+				Message string `json:"Message"`
+			} `json:"ValidationErrors"`
+		} `json:"ManualJournals"`
+	}
+	if err := do(ctx, http.MethodPost, apiBase+"/ManualJournals", accessToken, tenantID, idempotencyKey, body, &out); err != nil {
+		return "", err
+	}
+	if len(out.ManualJournals) == 0 {
+		return "", fmt.Errorf("xero: empty ManualJournals response")
+	}
+	if v := out.ManualJournals[0].ValidationErrors; len(v) > 0 {
+		return "", fmt.Errorf("xero validation: %s", v[0].Message)
+	}
+	return out.ManualJournals[0].ManualJournalID, nil
+}
+
+func do(ctx context.Context, method, u, accessToken, tenantID, idempotencyKey string, body any, out any) error {
+	var rdr io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rdr = bytes.NewReader(b)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, method, u, rdr)
+	req.Header.Set("authorization", "Bearer "+accessToken)
+	req.Header.Set("accept", "application/json")
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	if tenantID != "" {
+		req.Header.Set("xero-tenant-id", tenantID)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("xero %s: http %d: %s", u, res.StatusCode, string(raw))
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("xero unmarshal: %w", err)
+		}
+	}
+	return nil
+}
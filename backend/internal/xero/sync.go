@@ -0,0 +1,502 @@
+package xero
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/security"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	Source       = "xero"
+	sortKey      = "XERO"
+	mirrorPrefix = "XERO#TX#"
+)
+
+// Integration mirrors the Xero item in INTEGRATIONS_TABLE.
+// PK = USER#<sub>
+// SK = XERO
+//
+// Revenue (positive amounts) is journalled clearing -> revenue account,
+// costs (negative amounts) expense account -> clearing.
+type Integration struct {
+	PK                  string `dynamodbav:"PK" json:"-"`
+	SK                  string `dynamodbav:"SK" json:"-"`
+	RefreshTokenEnc     string `dynamodbav:"RefreshTokenEnc" json:"-"`
+	TenantId            string `dynamodbav:"TenantId" json:"tenantId"`
+	TenantName          string `dynamodbav:"TenantName,omitempty" json:"tenantName"`
+	BaseCurrency        string `dynamodbav:"BaseCurrency,omitempty" json:"baseCurrency"`
+	RevenueAccountCode  string `dynamodbav:"RevenueAccountCode,omitempty" json:"revenueAccountCode"`
+	ExpenseAccountCode  string `dynamodbav:"ExpenseAccountCode,omitempty" json:"expenseAccountCode"`
+	ClearingAccountCode string `dynamodbav:"ClearingAccountCode,omitempty" json:"clearingAccountCode"`
+	LastSyncAt          string `dynamodbav:"LastSyncAt,omitempty" json:"lastSyncAt"`
+	LastSyncError       string `dynamodbav:"LastSyncError,omitempty" json:"lastSyncError"`
+	CreatedAt           string `dynamodbav:"CreatedAt" json:"createdAt"`
+}
+
+func (i Integration) UserSub() string {
+	return strings.TrimPrefix(i.PK, "USER#")
+}
+
+// Accounts returns the revenue, expense and clearing account codes, falling
+// back to XERO_*_ACCOUNT_CODE and then Xero's default chart of accounts.
+func (i Integration) Accounts() (revenue, expense, clearing string) {
+	pick := func(v, env, def string) string {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+		if v = strings.TrimSpace(os.Getenv(env)); v != "" {
+			return v
+		}
+		return def
+	}
+	return pick(i.RevenueAccountCode, "XERO_REVENUE_ACCOUNT_CODE", "200"),
+		pick(i.ExpenseAccountCode, "XERO_EXPENSE_ACCOUNT_CODE", "400"),
+		pick(i.ClearingAccountCode, "XERO_CLEARING_ACCOUNT_CODE", "090")
+}
+
+func key(sub, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+		"SK": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+func LoadIntegration(ctx context.Context, ddb *dynamodb.Client, sub string) (*Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+	out, err := ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tbl),
+		Key:       key(sub, sortKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var integ Integration
+	if err := attributevalue.UnmarshalMap(out.Item, &integ); err != nil {
+		return nil, err
+	}
+	return &integ, nil
+}
+
+// ListIntegrations returns every user's Xero integration.
+func ListIntegrations(ctx context.Context, ddb *dynamodb.Client) ([]Integration, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	if tbl == "" {
+		return nil, fmt.Errorf("INTEGRATIONS_TABLE not set")
+	}
+
+	var items []map[string]types.AttributeValue
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(tbl),
+			FilterExpression: aws.String("SK = :sk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":sk": &types.AttributeValueMemberS{Value: sortKey},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+
+	var out []Integration
+	if err := attributevalue.UnmarshalListOfMaps(items, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveConnection stores a freshly authorised organisation, keeping any account
+// mapping already configured.
+func SaveConnection(ctx context.Context, ddb *dynamodb.Client, sub string, tok *Tokens, conn Connection, baseCurrency string) error {
+	enc, err := encrypt(tok.RefreshToken)
+	if err != nil {
+		return err
+	}
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tbl),
+		Key:       key(sub, sortKey),
+		UpdateExpression: aws.String("SET Provider = :p, RefreshTokenEnc = :t, TenantId = :tid, TenantName = :tn, " +
+			"BaseCurrency = :cur, CreatedAt = if_not_exists(CreatedAt, :now) REMOVE LastSyncError"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":p":   &types.AttributeValueMemberS{Value: Source},
+			":t":   &types.AttributeValueMemberS{Value: enc},
+			":tid": &types.AttributeValueMemberS{Value: conn.TenantId},
+			":tn":  &types.AttributeValueMemberS{Value: conn.TenantName},
+			":cur": &types.AttributeValueMemberS{Value: strings.ToUpper(baseCurrency)},
+			":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	return err
+}
+
+// SetAccounts updates the account mapping; empty codes fall back to the defaults.
+func SetAccounts(ctx context.Context, ddb *dynamodb.Client, sub, revenue, expense, clearing string) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tbl),
+		Key:                 key(sub, sortKey),
+		UpdateExpression:    aws.String("SET RevenueAccountCode = :r, ExpenseAccountCode = :e, ClearingAccountCode = :c"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":r": &types.AttributeValueMemberS{Value: strings.TrimSpace(revenue)},
+			":e": &types.AttributeValueMemberS{Value: strings.TrimSpace(expense)},
+			":c": &types.AttributeValueMemberS{Value: strings.TrimSpace(clearing)},
+		},
+	})
+	return err
+}
+
+// AccessToken refreshes the stored token and persists the rotated refresh
+// token before returning the access token.
+func AccessToken(ctx context.Context, ddb *dynamodb.Client, integ *Integration) (string, error) {
+	k, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	refresh, err := security.DecryptAESGCM(k, integ.RefreshTokenEnc)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+
+	tok, err := Refresh(ctx, refresh)
+	if err != nil {
+		return "", err
+	}
+	enc, err := security.EncryptAESGCM(k, tok.RefreshToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt token: %w", err)
+	}
+
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	_, err = ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              key(integ.UserSub(), sortKey),
+		UpdateExpression: aws.String("SET RefreshTokenEnc = :t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberS{Value: enc},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("store rotated refresh token: %w", err)
+	}
+	integ.RefreshTokenEnc = enc
+	return tok.AccessToken, nil
+}
+
+func encrypt(s string) (string, error) {
+	k, err := security.LoadKeyFromBase64(os.Getenv("TOKEN_ENC_KEY_B64"))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOKEN_ENC_KEY_B64: %w", err)
+	}
+	return security.EncryptAESGCM(k, s)
+}
+
+// mirror records what was last pushed for one transaction.
+// PK = USER#<sub>
+// SK = XERO#TX#<transaction SK>
+type mirror struct {
+	SK              string `dynamodbav:"SK"`
+	Fingerprint     string `dynamodbav:"Fingerprint"`
+	ManualJournalId string `dynamodbav:"ManualJournalId"`
+}
+
+type transaction struct {
+	SK        string  `dynamodbav:"SK"`
+	GSI1SK    string  `dynamodbav:"GSI1SK"`
+	Amount    float64 `dynamodbav:"Amount"`
+	Currency  string  `dynamodbav:"Currency"`
+	Category  string  `dynamodbav:"Category"`
+	Note      string  `dynamodbav:"Note"`
+	Source    string  `dynamodbav:"Source"`
+	CreatedAt string  `dynamodbav:"CreatedAt"`
+}
+
+func (t transaction) date() string {
+	for _, v := range []string{t.GSI1SK, t.CreatedAt} {
+		if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return ts.UTC().Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+// fingerprint changes whenever anything that ends up in the journal changes.
+func (t transaction) fingerprint() string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%.2f|%s|%s|%s|%s", t.SK, t.Amount, strings.ToUpper(t.Currency), t.Category, t.Note, t.date())))
+	return hex.EncodeToString(h[:16])
+}
+
+type SyncResult struct {
+	Month      string   `json:"month"`
+	Created    int      `json:"created"`
+	Updated    int      `json:"updated"`
+	Unchanged  int      `json:"unchanged"`
+	Skipped    int      `json:"skipped"`
+	Conflicts  int      `json:"conflicts"`
+	Errors     []string `json:"errors,omitempty"`
+	LastSyncAt string   `json:"lastSyncAt"`
+}
+
+// errConflict means another sync recorded a different journal for the
+// transaction while this one was pushing.
+var errConflict = errors.New("xero mirror changed concurrently")
+
+// SyncMonth mirrors the month's transactions (YYYY-MM) into Xero as posted
+// manual journals. Each transaction maps to exactly one journal: new ones are
+// created, edited ones update the journal in place and unchanged ones are
+// left alone. Every push carries an Idempotency-Key derived from the
+// transaction and its content, so a retried or concurrent push of the same
+// version cannot create a second journal in Xero.
+func SyncMonth(ctx context.Context, ddb *dynamodb.Client, integ *Integration, month string) (*SyncResult, error) {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return nil, fmt.Errorf("invalid month %q", month)
+	}
+	txTable := strings.TrimSpace(db.TransactionsTableName())
+	if txTable == "" {
+		return nil, fmt.Errorf("TRANSACTIONS_TABLE not set")
+	}
+	sub := integ.UserSub()
+
+	txs, err := monthTransactions(ctx, ddb, txTable, sub, month)
+	if err != nil {
+		return nil, err
+	}
+	mirrors, err := loadMirrors(ctx, ddb, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := AccessToken(ctx, ddb, integ)
+	if err != nil {
+		recordSync(ctx, ddb, sub, err.Error())
+		return nil, err
+	}
+
+	revenue, expense, clearing := integ.Accounts()
+	res := &SyncResult{Month: month}
+
+	for _, t := range txs {
+		amt := math.Round(t.Amount*100) / 100
+		if amt == 0 || t.date() == "" {
+			res.Skipped++
+			continue
+		}
+		// Manual journals are always in the organisation's base currency.
+		if integ.BaseCurrency != "" && t.Currency != "" && !strings.EqualFold(t.Currency, integ.BaseCurrency) {
+			res.Skipped++
+			continue
+		}
+
+		fp := t.fingerprint()
+		prev, seen := mirrors[t.SK]
+		if seen && prev.Fingerprint == fp {
+			res.Unchanged++
+			continue
+		}
+
+		desc := t.Category
+		if t.Note != "" {
+			desc += ": " + t.Note
+		}
+		j := ManualJournal{
+			ManualJournalID: prev.ManualJournalId,
+			Narration:       truncate(fmt.Sprintf("TrueProfit %s (%s)", t.SK, t.Category), 500),
+			Date:            t.date(),
+			Status:          "POSTED",
+		}
+		if amt > 0 {
+			j.JournalLines = []JournalLine{
+				{LineAmount: amt, AccountCode: clearing, Description: truncate(desc, 200)},
+				{LineAmount: -amt, AccountCode: revenue, Description: truncate(desc, 200)},
+			}
+		} else {
+			j.JournalLines = []JournalLine{
+				{LineAmount: -amt, AccountCode: expense, Description: truncate(desc, 200)},
+				{LineAmount: amt, AccountCode: clearing, Description: truncate(desc, 200)},
+			}
+		}
+
+		idem := idempotencyKey(sub, t.SK, fp)
+		id, err := PutManualJournal(ctx, accessToken, integ.TenantId, idem, j)
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", t.SK, err))
+			continue
+		}
+
+		if err := putMirror(ctx, ddb, sub, t.SK, fp, id, prev.ManualJournalId); err != nil {
+			if errors.Is(err, errConflict) {
+				res.Conflicts++
+				continue
+			}
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", t.SK, err))
+			continue
+		}
+		if seen {
+			res.Updated++
+		} else {
+			res.Created++
+		}
+	}
+
+	res.LastSyncAt = time.Now().UTC().Format(time.RFC3339)
+	errMsg := ""
+	if len(res.Errors) > 0 {
+		errMsg = res.Errors[0]
+	}
+	recordSync(ctx, ddb, sub, errMsg)
+	return res, nil
+}
+
+// idempotencyKey is stable for one version of one transaction.
+func idempotencyKey(sub, txSK, fingerprint string) string {
+	h := sha256.Sum256([]byte(sub + "|" + txSK + "|" + fingerprint))
+	return "tp-" + hex.EncodeToString(h[:24])
+}
+
+// putMirror records the pushed version. It only succeeds if the mirror still
+// points at the journal this run started from, so two runs racing on the same
+// transaction cannot silently disagree about which journal it maps to.
+func putMirror(ctx context.Context, ddb *dynamodb.Client, sub, txSK, fp, journalID, prevJournalID string) error {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+
+	cond := "attribute_not_exists(ManualJournalId) OR ManualJournalId = :j"
+	vals := map[string]types.AttributeValue{
+		":f":   &types.AttributeValueMemberS{Value: fp},
+		":j":   &types.AttributeValueMemberS{Value: journalID},
+		":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+	}
+	if prevJournalID != "" {
+		cond = "ManualJournalId = :j"
+	}
+
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tbl),
+		Key:                       key(sub, mirrorPrefix+txSK),
+		UpdateExpression:          aws.String("SET Fingerprint = :f, ManualJournalId = :j, SyncedAt = :now"),
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeValues: vals,
+	})
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return errConflict
+	}
+	return err
+}
+
+func loadMirrors(ctx context.Context, ddb *dynamodb.Client, sub string) (map[string]mirror, error) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+
+	out := map[string]mirror{}
+	var startKey map[string]types.AttributeValue
+	for {
+		page, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tbl),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :p)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", sub)},
+				":p":  &types.AttributeValueMemberS{Value: mirrorPrefix},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var ms []mirror
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &ms); err != nil {
+			return nil, err
+		}
+		for _, m := range ms {
+			out[strings.TrimPrefix(m.SK, mirrorPrefix)] = m
+		}
+		if len(page.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = page.LastEvaluatedKey
+	}
+	return out, nil
+}
+
+func monthTransactions(ctx context.Context, ddb *dynamodb.Client, table, sub, month string) ([]transaction, error) {
+	var (
+		items    []transaction
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		out, err := ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			IndexName:              aws.String("GSI1"),
+			KeyConditionExpression: aws.String("GSI1PK = :pk"),
+			// Split transactions are posted through their allocations;
+			// deleted ones not at all.
+			FilterExpression: aws.String("attribute_not_exists(SplitInto) AND attribute_not_exists(DeletedAt)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s#MONTH#%s", sub, month)},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var page []transaction
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return items, nil
+}
+
+func recordSync(ctx context.Context, ddb *dynamodb.Client, sub, errMsg string) {
+	tbl := strings.TrimSpace(db.IntegrationsTableName())
+	_, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tbl),
+		Key:              key(sub, sortKey),
+		UpdateExpression: aws.String("SET LastSyncAt = :now, LastSyncError = :e"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":e":   &types.AttributeValueMemberS{Value: errMsg},
+		},
+	})
+	if err != nil {
+		fmt.Printf("xero: record sync user=%s: %v\n", sub, err)
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
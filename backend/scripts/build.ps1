@@ -1,7 +1,8 @@
 $ErrorActionPreference = "Stop"
 
 $root = Resolve-Path (Join-Path $PSScriptRoot "..")
-$dist = Join-Path $root "dist"
+# DIST_DIR puts the zips elsewhere, e.g. dist/shadow for serverless.shadow.yml.
+$dist = if ($env:DIST_DIR) { $env:DIST_DIR } else { Join-Path $root "dist" }
 
 if (!(Test-Path $dist)) { New-Item -ItemType Directory -Path $dist | Out-Null }
 
@@ -42,5 +43,52 @@ Build-One "shopify-emailer"
 Build-One "ask"
 Build-One "etl-daily-metrics"
 Build-One "repair-partitions"
+Build-One "fx"
+Build-One "forecast"
+Build-One "amazon"
+Build-One "amazon-sync-worker"
+Build-One "meta"
+Build-One "meta-sync-worker"
+Build-One "gsheets"
+Build-One "gsheets-exporter"
+Build-One "xero"
+Build-One "xero-sync-worker"
+Build-One "bi-access"
+Build-One "status"
+Build-One "square"
+Build-One "square-sync-worker"
+Build-One "shopify-backfill"
+Build-One "shopify-gap-detector"
+Build-One "ingest"
+Build-One "shopify-quarantine-worker"
+Build-One "shopify-initial-sync"
+Build-One "shopify-webhook-subscriber"
+Build-One "admin"
+Build-One "usage"
+Build-One "recharge"
+Build-One "fx-fetcher"
+Build-One "changelog"
+Build-One "feedback"
+Build-One "settings"
+Build-One "orgs"
+Build-One "metrics"
+Build-One "shops"
+Build-One "recurring-expenses"
+Build-One "recurring-expenses-scheduler"
+Build-One "promo-detector"
+Build-One "profit"
+Build-One "ops-report"
+Build-One "shopify-replay-monitor"
+Build-One "onboarding"
+Build-One "sparklines"
+Build-One "transactions-purge-worker"
+Build-One "alerts-provisioner"
+Build-One "transactions-rollup"
+Build-One "duplicates-scanner"
+Build-One "fee-rules"
+Build-One "sanity-checker"
+Build-One "report-schedules"
+Build-One "report-exporter"
+Build-One "nlq-digests"
 
 Write-Host "Done."
@@ -2,7 +2,8 @@
 set -euo pipefail
 
 ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
-DIST_DIR="${ROOT_DIR}/dist"
+# DIST_DIR puts the zips elsewhere, e.g. dist/shadow for serverless.shadow.yml.
+DIST_DIR="${DIST_DIR:-${ROOT_DIR}/dist}"
 
 mkdir -p "${DIST_DIR}"
 
@@ -36,5 +37,52 @@ build_one shopify-emailer
 build_one ask
 build_one etl-daily-metrics
 build_one repair-partitions
+build_one fx
+build_one forecast
+build_one amazon
+build_one amazon-sync-worker
+build_one meta
+build_one meta-sync-worker
+build_one gsheets
+build_one gsheets-exporter
+build_one xero
+build_one xero-sync-worker
+build_one bi-access
+build_one status
+build_one square
+build_one square-sync-worker
+build_one shopify-backfill
+build_one shopify-gap-detector
+build_one ingest
+build_one shopify-quarantine-worker
+build_one shopify-initial-sync
+build_one shopify-webhook-subscriber
+build_one admin
+build_one usage
+build_one recharge
+build_one fx-fetcher
+build_one changelog
+build_one feedback
+build_one settings
+build_one orgs
+build_one metrics
+build_one shops
+build_one recurring-expenses
+build_one recurring-expenses-scheduler
+build_one promo-detector
+build_one profit
+build_one ops-report
+build_one shopify-replay-monitor
+build_one onboarding
+build_one sparklines
+build_one transactions-purge-worker
+build_one alerts-provisioner
+build_one transactions-rollup
+build_one duplicates-scanner
+build_one fee-rules
+build_one sanity-checker
+build_one report-schedules
+build_one report-exporter
+build_one nlq-digests
 
 echo "Done."
@@ -175,6 +175,7 @@ export type AskResultResponse = {
   type: "result";
   cached?: boolean;
   sql: string;
+  explanation?: string; // what the SQL does, in plain English
   assumptions: string[];
   confidence: number;
   result: {
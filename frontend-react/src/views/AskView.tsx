@@ -219,6 +219,9 @@ function AskView() {
                       <Database className="h-4 w-4" />
                       Generated SQL
                     </h3>
+                    {response.explanation && (
+                      <p className="text-sm text-muted-foreground mb-2">{response.explanation}</p>
+                    )}
                     <pre className="bg-slate-900 text-slate-100 p-4 rounded-lg text-xs overflow-x-auto">
                       <code>{response.sql}</code>
                     </pre>